@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mehrdadrad/tcpdog/checkpoint"
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/sharedhttp"
+)
+
+// TokenKey is the metadata key of the admin token
+const TokenKey = "tcpdog-token"
+
+// tailBufSize is the number of records which can be queued
+// for a tailer before they start to drop.
+const tailBufSize = 1000
+
+// Server represents the admin gRPC server
+type Server struct {
+	hub    *Hub
+	states *checkpoint.Store
+	token  string
+	logger *zap.Logger
+}
+
+// Tail streams the sampled records to the client until it disconnects
+func (s *Server) Tail(req *pb.TailRequest, srv pb.Admin_TailServer) error {
+	if err := s.auth(srv.Context()); err != nil {
+		return err
+	}
+
+	sub := s.hub.subscribe(req.GetSample(), tailBufSize)
+	defer s.hub.unsubscribe(sub)
+
+	s.logger.Info("admin", zap.String("msg", "tail has been started"), zap.Uint32("sample", req.GetSample()))
+
+	defer func() {
+		s.logger.Info("admin", zap.String("msg", "tail has been stopped"),
+			zap.Uint64("dropped", atomic.LoadUint64(&sub.dropped)))
+	}()
+
+	for {
+		select {
+		case r := <-sub.ch:
+			if err := srv.Send(r); err != nil {
+				return err
+			}
+		case <-srv.Context().Done():
+			return nil
+		}
+	}
+}
+
+// PutState stores the processor checkpoint of the standby peer
+func (s *Server) PutState(ctx context.Context, req *pb.State) (*pb.Response, error) {
+	if err := s.auth(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.states.Put(req.GetName(), req.GetData()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &pb.Response{}, nil
+}
+
+// GetState returns the stored processor checkpoint
+func (s *Server) GetState(ctx context.Context, req *pb.StateRequest) (*pb.State, error) {
+	if err := s.auth(ctx); err != nil {
+		return nil, err
+	}
+
+	b, ok := s.states.Get(req.GetName())
+	if !ok {
+		return nil, status.Error(codes.NotFound, req.GetName()+" state not found")
+	}
+
+	return &pb.State{Name: req.GetName(), Data: b}, nil
+}
+
+func (s *Server) auth(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, t := range md.Get(TokenKey) {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "invalid admin token")
+}
+
+// Start starts the admin gRPC server
+func Start(ctx context.Context, hub *Hub) error {
+	cfg := config.FromContextServer(ctx)
+
+	l, err := net.Listen("tcp", cfg.Admin.Addr)
+	if err != nil {
+		return err
+	}
+
+	var opts []grpc.ServerOption
+
+	if cfg.Admin.TLSConfig != nil && cfg.Admin.TLSConfig.Enable {
+		creds, err := config.GetCreds(cfg.Admin.TLSConfig)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	if cfg.Admin.HTTPAddr != "" {
+		hub.presence = newPresence(presenceWindow)
+		hub.token = cfg.Admin.Token
+		hub.ui = cfg.Admin.UI
+
+		// the grpc ingress serves it on the shared listener
+		if cfg.SharedListener(cfg.Admin.HTTPAddr) {
+			sharedhttp.Handle(ctx, cfg.Admin.HTTPAddr, httpHandler(hub))
+		} else {
+			hl, err := net.Listen("tcp", cfg.Admin.HTTPAddr)
+			if err != nil {
+				l.Close()
+				return err
+			}
+
+			serveHTTP(ctx, hl, hub, cfg.Logger())
+		}
+	}
+
+	serve(ctx, l, &Server{
+		hub:    hub,
+		states: checkpoint.NewStore(),
+		token:  cfg.Admin.Token,
+		logger: cfg.Logger(),
+	}, opts...)
+
+	return nil
+}
+
+func serve(ctx context.Context, l net.Listener, srv *Server, opts ...grpc.ServerOption) {
+	gServer := grpc.NewServer(opts...)
+	pb.RegisterAdminServer(gServer, srv)
+
+	go func() {
+		<-ctx.Done()
+		gServer.Stop()
+	}()
+
+	go func() {
+		if err := gServer.Serve(l); err != nil {
+			srv.logger.Error("admin", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mehrdadrad/tcpdog/checkpoint"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func testServer(t *testing.T, ctx context.Context, hub *Hub, token string) pb.AdminClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	serve(ctx, l, &Server{hub: hub, states: checkpoint.NewStore(), token: token, logger: zap.NewNop()})
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+
+	return pb.NewAdminClient(conn)
+}
+
+func waitSubscribers(hub *Hub, n int) {
+	for i := 0; i < 100; i++ {
+		hub.mu.RLock()
+		l := len(hub.subs)
+		hub.mu.RUnlock()
+		if l == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	client := testServer(t, ctx, hub, "")
+
+	// no tailer
+	hub.Publish(map[string]interface{}{"RTT": float64(1)})
+
+	stream, err := client.Tail(ctx, &pb.TailRequest{Sample: 2})
+	assert.NoError(t, err)
+
+	waitSubscribers(hub, 1)
+
+	for i := 1; i <= 4; i++ {
+		hub.Publish(map[string]interface{}{"RTT": float64(i), "Task": "curl"})
+	}
+
+	for _, rtt := range []float64{2, 4} {
+		r, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"RTT": rtt, "Task": "curl"}, r.GetFields().AsMap())
+	}
+
+	hub.Publish(&pb.Fields{RTT: uint32Ptr(12), Task: strPtr("wget")})
+	hub.Publish(&pb.Fields{RTT: uint32Ptr(14), Task: strPtr("wget")})
+
+	r, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(14), "Task": "wget"}, r.GetFields().AsMap())
+}
+
+func TestTailToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	client := testServer(t, ctx, hub, "secret")
+
+	stream, err := client.Tail(ctx, &pb.TailRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	mdCtx := metadata.AppendToOutgoingContext(ctx, TokenKey, "secret")
+	stream, err = client.Tail(mdCtx, &pb.TailRequest{})
+	assert.NoError(t, err)
+
+	waitSubscribers(hub, 1)
+	hub.Publish(map[string]interface{}{"RTT": float64(1)})
+
+	r, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(1)}, r.GetFields().AsMap())
+}
+
+func TestPublishSlowTailer(t *testing.T) {
+	hub := NewHub()
+	slow := hub.subscribe(0, 1)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			hub.Publish(map[string]interface{}{"RTT": float64(i)})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish has been blocked")
+	}
+
+	assert.Len(t, slow.ch, 1)
+	assert.Equal(t, uint64(9), slow.dropped)
+
+	hub.unsubscribe(slow)
+	assert.Equal(t, int32(0), hub.count)
+}
+
+func TestToSPB(t *testing.T) {
+	m := map[string]interface{}{"RTT": float64(5)}
+	spb, err := toSPB(m)
+	assert.NoError(t, err)
+
+	// the copy shouldn't be changed by the ingestion
+	m["RTT"] = float64(6)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(5)}, spb.GetFields().AsMap())
+}
+
+func uint32Ptr(v uint32) *uint32 { return &v }
+
+func strPtr(v string) *string { return &v }
+
+func TestState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := testServer(t, ctx, NewHub(), "secret")
+	ctx = metadata.AppendToOutgoingContext(ctx, TokenKey, "secret")
+
+	_, err := client.GetState(ctx, &pb.StateRequest{Name: "grpc/anomaly"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// corrupt checkpoint
+	_, err = client.PutState(ctx, &pb.State{Name: "grpc/anomaly", Data: []byte("foo")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetState(context.Background(), &pb.StateRequest{Name: "grpc/anomaly"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/fault"
+)
+
+// faultsHandler returns the active fault injections, a POST to
+// /faults/inject with the point, kind, probability, delay and
+// duration queries injects a fault and a POST to /faults/clear
+// clears the point or all of them. it requires the admin token
+// and the injections are refused without the unsafe flag.
+func (h *Hub) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if h.token != "" && !h.validToken(r) {
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		q := r.URL.Query()
+
+		switch strings.TrimPrefix(r.URL.Path, "/faults/") {
+		case "inject":
+			f, err := faultQuery(q.Get("point"), q.Get("kind"), q.Get("probability"), q.Get("delay"), q.Get("duration"))
+			if err == nil {
+				err = fault.Inject(f)
+			}
+
+			if err == fault.ErrDisabled {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case "clear":
+			fault.Clear(q.Get("point"))
+		default:
+			http.NotFound(w, r)
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fault.Status())
+}
+
+func faultQuery(point, kind, probability, delay, duration string) (config.Fault, error) {
+	var err error
+
+	f := config.Fault{Point: point, Kind: kind, Probability: 1}
+
+	if probability != "" {
+		if f.Probability, err = strconv.ParseFloat(probability, 64); err != nil {
+			return f, err
+		}
+	}
+
+	if delay != "" {
+		if f.Delay, err = time.ParseDuration(delay); err != nil {
+			return f, err
+		}
+	}
+
+	if duration != "" {
+		if f.Duration, err = time.ParseDuration(duration); err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/fault"
+)
+
+func TestFaultsHandler(t *testing.T) {
+	hub := NewHub()
+	hub.token = "secret"
+
+	// the unsafe flag isn't set
+	if !fault.Allow(false) {
+		r := httptest.NewRequest(http.MethodPost, "/faults/inject?point=kafka.publish&kind=error", nil)
+		r.Header.Set(TokenKey, "secret")
+		w := httptest.NewRecorder()
+		hub.faultsHandler(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+
+	fault.Allow(true)
+	defer fault.Allow(false)
+
+	w := httptest.NewRecorder()
+	hub.faultsHandler(w, httptest.NewRequest(http.MethodPost, "/faults/inject?point=kafka.publish&kind=error", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r := httptest.NewRequest(http.MethodPost, "/faults/inject?point=geo.lookup&kind=delay&delay=10ms&probability=0.5&duration=5m", nil)
+	r.Header.Set(TokenKey, "secret")
+	w = httptest.NewRecorder()
+	hub.faultsHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var states []fault.State
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&states))
+	assert.Len(t, states, 1)
+	assert.Equal(t, "geo.lookup", states[0].Point)
+	assert.Equal(t, 0.5, states[0].Probability)
+	assert.Equal(t, "10ms", states[0].Delay)
+
+	// the status shows the active injections
+	assert.Len(t, hub.serverStatus().Faults, 1)
+
+	r = httptest.NewRequest(http.MethodPost, "/faults/inject?point=foo&kind=error", nil)
+	r.Header.Set(TokenKey, "secret")
+	w = httptest.NewRecorder()
+	hub.faultsHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "fault point foo is not supported\n", w.Body.String())
+
+	r = httptest.NewRequest(http.MethodPost, "/faults/clear?point=geo.lookup", nil)
+	r.Header.Set(TokenKey, "secret")
+	w = httptest.NewRecorder()
+	hub.faultsHandler(w, r)
+	assert.Equal(t, "[]\n", w.Body.String())
+}
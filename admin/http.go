@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+// presenceWindow is the fields presence window
+const presenceWindow = time.Minute
+
+func serveHTTP(ctx context.Context, l net.Listener, hub *Hub, logger *zap.Logger) {
+	srv := &http.Server{Handler: httpHandler(hub)}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Error("admin", zap.Error(err))
+		}
+	}()
+}
+
+func httpHandler(hub *Hub) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status/fields", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.presence.Status())
+	})
+	mux.HandleFunc("/status/drops", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drops.Snapshot())
+	})
+	mux.HandleFunc("/mirrors", hub.mirrorsHandler)
+	mux.HandleFunc("/mirrors/", hub.mirrorsHandler)
+	mux.HandleFunc("/faults", hub.faultsHandler)
+	mux.HandleFunc("/faults/", hub.faultsHandler)
+
+	if hub.ui {
+		mux.Handle("/status", hub.auth(http.HandlerFunc(hub.statusHandler)))
+		mux.Handle("/tail", hub.auth(http.HandlerFunc(hub.tailHandler)))
+		mux.Handle("/ui/", hub.auth(uiHandler()))
+		mux.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+	}
+
+	return mux
+}
+
+// auth requires the admin token if it's set, the browsers send it
+// by the token query since the event source can't set the headers.
+func (h *Hub) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.token != "" && !h.validToken(r) {
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Hub) validToken(r *http.Request) bool {
+	for _, t := range []string{r.Header.Get(TokenKey), r.URL.Query().Get("token")} {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(h.token)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
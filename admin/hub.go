@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// Hub fans out the decoded records to the tailers, a tailer which
+// can't keep up loses the records but it never blocks the publisher.
+type Hub struct {
+	mu    sync.RWMutex
+	subs  map[*subscriber]struct{}
+	count int32
+
+	presence *presence
+	mirrors  map[string]Switch
+	status   serverState
+	token    string
+	ui       bool
+}
+
+type subscriber struct {
+	sample  uint32
+	seen    uint32
+	dropped uint64
+	ch      chan *pb.FieldsSPB
+}
+
+// NewHub constructs a new hub
+func NewHub() *Hub {
+	return &Hub{
+		subs: map[*subscriber]struct{}{},
+		status: serverState{
+			start:      time.Now(),
+			flows:      map[string]*uint64{},
+			components: map[string]ComponentStatus{},
+			quarantine: map[string]func() interface{}{},
+		},
+	}
+}
+
+// Publish sends a copy of the record to the tailers, it returns
+// immediately if there isn't any tailer. the record fields presence
+// is tracked if the admin http is enabled.
+func (h *Hub) Publish(r interface{}) {
+	if h.presence != nil {
+		h.presence.Observe(r)
+	}
+
+	if atomic.LoadInt32(&h.count) < 1 {
+		return
+	}
+
+	var spb *pb.FieldsSPB
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subs {
+		n := atomic.AddUint32(&s.seen, 1)
+		if s.sample > 1 && n%s.sample != 0 {
+			continue
+		}
+
+		// the record converts once and only if it's needed,
+		// the copy is safe from the ingestion modifications.
+		if spb == nil {
+			var err error
+			spb, err = toSPB(r)
+			if err != nil {
+				return
+			}
+		}
+
+		select {
+		case s.ch <- spb:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (h *Hub) subscribe(sample uint32, size int) *subscriber {
+	s := &subscriber{
+		sample: sample,
+		ch:     make(chan *pb.FieldsSPB, size),
+	}
+
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	atomic.AddInt32(&h.count, 1)
+	h.mu.Unlock()
+
+	return s
+}
+
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	atomic.AddInt32(&h.count, -1)
+	h.mu.Unlock()
+}
+
+func toSPB(r interface{}) (*pb.FieldsSPB, error) {
+	var m map[string]interface{}
+
+	switch v := r.(type) {
+	case *pb.FieldsSPB:
+		return proto.Clone(v).(*pb.FieldsSPB), nil
+	case *pb.Fields:
+		m = map[string]interface{}{}
+		v.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			m[string(fd.Name())] = v.Interface()
+			return true
+		})
+	case map[string]interface{}:
+		m = v
+	}
+
+	f, err := structpb.NewStruct(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.FieldsSPB{Fields: f}, nil
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Switch represents a flow component which can be
+// turned off and on at runtime, e.g. a mirror.
+type Switch interface {
+	Enabled() bool
+	SetEnabled(enabled bool)
+	Stats() map[string]uint64
+}
+
+// SwitchStatus represents the state and the counters of a switch
+type SwitchStatus struct {
+	Name    string            `json:"name"`
+	Enabled bool              `json:"enabled"`
+	Stats   map[string]uint64 `json:"stats"`
+}
+
+// AddMirror registers the mirror, it's controlled by the admin http
+func (h *Hub) AddMirror(name string, s Switch) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.mirrors == nil {
+		h.mirrors = map[string]Switch{}
+	}
+	h.mirrors[name] = s
+}
+
+func (h *Hub) mirrorsStatus() []SwitchStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	status := []SwitchStatus{}
+	for name, s := range h.mirrors {
+		status = append(status, SwitchStatus{Name: name, Enabled: s.Enabled(), Stats: s.Stats()})
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+
+	return status
+}
+
+// mirrorsHandler returns the mirrors status, a POST to
+// /mirrors/enable or /mirrors/disable with the name query
+// turns the mirror on or off. it requires the admin token.
+func (h *Hub) mirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if h.token != "" && !h.validToken(r) {
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		var enabled bool
+
+		switch strings.TrimPrefix(r.URL.Path, "/mirrors/") {
+		case "enable":
+			enabled = true
+		case "disable":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		h.mu.RLock()
+		s, ok := h.mirrors[r.URL.Query().Get("name")]
+		h.mu.RUnlock()
+
+		if !ok {
+			http.Error(w, "mirror not found", http.StatusNotFound)
+			return
+		}
+
+		s.SetEnabled(enabled)
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.mirrorsStatus())
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSwitch struct {
+	enabled bool
+}
+
+func (s *fakeSwitch) Enabled() bool            { return s.enabled }
+func (s *fakeSwitch) SetEnabled(enabled bool)  { s.enabled = enabled }
+func (s *fakeSwitch) Stats() map[string]uint64 { return map[string]uint64{"sent": 5} }
+
+func TestMirrorsHandler(t *testing.T) {
+	hub := NewHub()
+	hub.token = "secret"
+	m := &fakeSwitch{enabled: true}
+	hub.AddMirror("grpc01/ch01", m)
+
+	w := httptest.NewRecorder()
+	hub.mirrorsHandler(w, httptest.NewRequest(http.MethodGet, "/mirrors", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	status := []SwitchStatus{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, []SwitchStatus{{Name: "grpc01/ch01", Enabled: true, Stats: map[string]uint64{"sent": 5}}}, status)
+
+	// without token
+	w = httptest.NewRecorder()
+	hub.mirrorsHandler(w, httptest.NewRequest(http.MethodPost, "/mirrors/disable?name=grpc01/ch01", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.True(t, m.enabled)
+
+	r := httptest.NewRequest(http.MethodPost, "/mirrors/disable?name=grpc01/ch01", nil)
+	r.Header.Set(TokenKey, "secret")
+	w = httptest.NewRecorder()
+	hub.mirrorsHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, m.enabled)
+
+	r = httptest.NewRequest(http.MethodPost, "/mirrors/enable?name=grpc01/ch01", nil)
+	r.Header.Set(TokenKey, "secret")
+	w = httptest.NewRecorder()
+	hub.mirrorsHandler(w, r)
+	assert.True(t, m.enabled)
+
+	r = httptest.NewRequest(http.MethodPost, "/mirrors/disable?name=foo", nil)
+	r.Header.Set(TokenKey, "secret")
+	w = httptest.NewRecorder()
+	hub.mirrorsHandler(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
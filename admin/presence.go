@@ -0,0 +1,186 @@
+package admin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+const presenceShards = 16
+
+// presence tracks which fields each agent populates, an agent is
+// identified by the records Hostname. the agents are sharded by
+// their hostname thus the flows taps don't contend on a lock.
+type presence struct {
+	interval time.Duration
+	shards   [presenceShards]presenceShard
+	now      func() time.Time
+}
+
+type presenceShard struct {
+	sync.Mutex
+	rolled time.Time
+	agents map[string]*agentFields
+}
+
+type agentFields struct {
+	prev, cur serialization.Bitmap
+	seen      bool
+}
+
+// FieldsStatus represents the fields presence of the agents
+type FieldsStatus struct {
+	Agents int               `json:"agents"`
+	Window string            `json:"window"`
+	Fields map[string]int    `json:"fields"`
+	Bitmap map[string]string `json:"bitmap"`
+}
+
+func newPresence(interval time.Duration) *presence {
+	p := &presence{
+		interval: interval,
+		now:      time.Now,
+	}
+
+	now := p.now()
+	for i := range p.shards {
+		p.shards[i].rolled = now
+		p.shards[i].agents = map[string]*agentFields{}
+	}
+
+	return p
+}
+
+// Observe sets the populated fields of a record, a field is populated
+// if it's not zero or empty. the numbers of the json, msgpack and cbor
+// records are normalized e.g. the msgpack zero is an int64.
+func (p *presence) Observe(r interface{}) {
+	var (
+		b        serialization.Bitmap
+		hostname string
+	)
+
+	switch v := r.(type) {
+	case map[string]interface{}:
+		hostname, _ = v["Hostname"].(string)
+		for k, f := range v {
+			if populated(f) {
+				b.Set(k)
+			}
+		}
+	case *pb.FieldsSPB:
+		hostname = v.GetFields().GetFields()["Hostname"].GetStringValue()
+		for k, f := range v.GetFields().GetFields() {
+			if f.GetNumberValue() != 0 || f.GetStringValue() != "" || f.GetBoolValue() {
+				b.Set(k)
+			}
+		}
+	case *pb.Fields:
+		hostname = v.GetHostname()
+		v.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, f protoreflect.Value) bool {
+			if f.Interface() != fd.Default().Interface() {
+				b.Set(string(fd.Name()))
+			}
+			return true
+		})
+	default:
+		return
+	}
+
+	if hostname == "" {
+		hostname = "unknown"
+	}
+
+	sh := &p.shards[xxhash.Sum64String(hostname)%presenceShards]
+
+	sh.Lock()
+	defer sh.Unlock()
+
+	p.roll(sh)
+
+	a, ok := sh.agents[hostname]
+	if !ok {
+		a = &agentFields{}
+		sh.agents[hostname] = a
+	}
+
+	a.cur.Or(b)
+	a.seen = true
+}
+
+// populated returns true if the value of a map record isn't zero or empty
+func populated(v interface{}) bool {
+	if n, ok := serialization.Number(v); ok {
+		return n != 0
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case bool:
+		return v
+	}
+
+	return true
+}
+
+// Status returns the number of agents which populate each field
+// during the last window and the current one.
+func (p *presence) Status() FieldsStatus {
+	s := FieldsStatus{
+		Window: p.interval.String(),
+		Fields: map[string]int{},
+		Bitmap: map[string]string{},
+	}
+
+	for i := range p.shards {
+		sh := &p.shards[i]
+
+		sh.Lock()
+		p.roll(sh)
+
+		for hostname, a := range sh.agents {
+			var b serialization.Bitmap
+			b.Or(a.prev)
+			b.Or(a.cur)
+
+			for _, name := range b.Names() {
+				s.Fields[name]++
+			}
+
+			s.Bitmap[hostname] = b.Encode()
+		}
+
+		s.Agents += len(sh.agents)
+		sh.Unlock()
+	}
+
+	return s
+}
+
+// roll starts a new window of the shard, the agents which
+// haven't been seen during the last window are removed.
+func (p *presence) roll(sh *presenceShard) {
+	now := p.now()
+	if now.Sub(sh.rolled) < p.interval {
+		return
+	}
+
+	sh.rolled = now
+
+	for hostname, a := range sh.agents {
+		if !a.seen {
+			delete(sh.agents, hostname)
+			continue
+		}
+
+		a.prev, a.cur, a.seen = a.cur, nil, false
+	}
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+func TestPresence(t *testing.T) {
+	p := newPresence(time.Minute)
+	now := p.shards[0].rolled
+	p.now = func() time.Time { return now }
+
+	p.Observe(map[string]interface{}{"Hostname": "foo", "RTT": float64(5), "Task": "", "Geo": "x"})
+	p.Observe(map[string]interface{}{"Hostname": "foo", "Task": "curl", "RTT": float64(0)})
+
+	// the msgpack numbers
+	p.Observe(map[string]interface{}{"Hostname": "foo", "SRTT": int64(0), "SndCwnd": uint64(0), "Synthetic": false})
+
+	s, _ := structpb.NewStruct(map[string]interface{}{"Hostname": "bar", "RTT": float64(1), "SAddr": ""})
+	p.Observe(&pb.FieldsSPB{Fields: s})
+
+	rtt, zero, hostname := uint32(3), uint32(0), "baz"
+	p.Observe(&pb.Fields{Hostname: &hostname, RTT: &zero, TotalRetrans: &rtt})
+
+	status := p.Status()
+	assert.Equal(t, 3, status.Agents)
+	assert.Equal(t, map[string]int{"Hostname": 3, "RTT": 2, "Task": 1, "TotalRetrans": 1}, status.Fields)
+
+	b, err := serialization.DecodeBitmap(status.Bitmap["baz"])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TotalRetrans", "Hostname"}, b.Names())
+
+	// the last window is still reported
+	now = now.Add(time.Minute)
+	p.Observe(map[string]interface{}{"Hostname": "foo", "SAddr": "10.0.0.1"})
+	status = p.Status()
+	assert.Equal(t, 3, status.Agents)
+	assert.Equal(t, 3, status.Fields["Hostname"])
+
+	// bar and baz have not been seen during the last window
+	now = now.Add(time.Minute)
+	p.Observe(map[string]interface{}{"Hostname": "foo"})
+	status = p.Status()
+	assert.Equal(t, 1, status.Agents)
+	assert.Equal(t, map[string]int{"Hostname": 1, "SAddr": 1}, status.Fields)
+}
+
+func TestServeHTTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	hub := NewHub()
+	hub.presence = newPresence(time.Minute)
+	serveHTTP(ctx, l, hub, zap.NewNop())
+
+	hub.Publish(map[string]interface{}{"Hostname": "foo", "RTT": float64(5)})
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/status/fields")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	status := FieldsStatus{}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status.Agents)
+	assert.Equal(t, map[string]int{"Hostname": 1, "RTT": 1}, status.Fields)
+}
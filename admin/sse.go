@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// tailHandler streams the sampled records as the server-sent events,
+// the fields query keeps the listed fields of the records.
+func (h *Hub) tailHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sample, _ := strconv.ParseUint(r.URL.Query().Get("sample"), 10, 32)
+
+	var fields []string
+	if f := r.URL.Query().Get("fields"); f != "" {
+		fields = strings.Split(f, ",")
+	}
+
+	sub := h.subscribe(uint32(sample), tailBufSize)
+	defer h.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case rec := <-sub.ch:
+			m := rec.GetFields().AsMap()
+			if len(fields) > 0 {
+				selected := map[string]interface{}{}
+				for _, f := range fields {
+					if v, ok := m[f]; ok {
+						selected[f] = v
+					}
+				}
+				m = selected
+			}
+
+			b, err := json.Marshal(m)
+			if err != nil {
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/fault"
+)
+
+// ServerStatus represents the server status of the web ui, the flows
+// records are the totals thus the clients derive the throughput.
+type ServerStatus struct {
+	Uptime     string            `json:"uptime"`
+	Agents     int               `json:"agents"`
+	Flows      map[string]uint64 `json:"flows"`
+	Components []ComponentStatus `json:"components"`
+	Mirrors    []SwitchStatus    `json:"mirrors"`
+	// Quarantined is the quarantined peers per ingress
+	Quarantined map[string]interface{} `json:"quarantined,omitempty"`
+	// Faults is the active fault injections
+	Faults []fault.State `json:"faults,omitempty"`
+}
+
+// ComponentStatus represents the last lifecycle state of a component
+type ComponentStatus struct {
+	Component string `json:"component"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+}
+
+type serverState struct {
+	sync.Mutex
+	start      time.Time
+	flows      map[string]*uint64
+	components map[string]ComponentStatus
+	quarantine map[string]func() interface{}
+}
+
+// AddQuarantine registers the quarantined peers of the ingress
+func (h *Hub) AddQuarantine(name string, peers func() interface{}) {
+	h.status.Lock()
+	defer h.status.Unlock()
+
+	h.status.quarantine[name] = peers
+}
+
+// AddFlow registers the flow, it returns the records counter of the flow
+func (h *Hub) AddFlow(name string) *uint64 {
+	h.status.Lock()
+	defer h.status.Unlock()
+
+	if c, ok := h.status.flows[name]; ok {
+		return c
+	}
+
+	c := new(uint64)
+	h.status.flows[name] = c
+
+	return c
+}
+
+// SetStatus keeps the last lifecycle state of the component
+func (h *Hub) SetStatus(s config.Status) {
+	h.status.Lock()
+	defer h.status.Unlock()
+
+	c := ComponentStatus{Component: s.Component, Name: s.Name, State: string(s.State)}
+	if s.Err != nil {
+		c.Error = s.Err.Error()
+	}
+
+	h.status.components[s.Component+"/"+s.Name] = c
+}
+
+func (h *Hub) serverStatus() ServerStatus {
+	h.status.Lock()
+
+	s := ServerStatus{
+		Uptime:     time.Since(h.status.start).Truncate(time.Second).String(),
+		Flows:      map[string]uint64{},
+		Components: []ComponentStatus{},
+	}
+
+	for name, c := range h.status.flows {
+		s.Flows[name] = atomic.LoadUint64(c)
+	}
+
+	for _, c := range h.status.components {
+		s.Components = append(s.Components, c)
+	}
+
+	if len(h.status.quarantine) > 0 {
+		s.Quarantined = map[string]interface{}{}
+		for name, peers := range h.status.quarantine {
+			s.Quarantined[name] = peers()
+		}
+	}
+
+	h.status.Unlock()
+
+	sort.Slice(s.Components, func(i, j int) bool {
+		if s.Components[i].Component != s.Components[j].Component {
+			return s.Components[i].Component < s.Components[j].Component
+		}
+		return s.Components[i].Name < s.Components[j].Name
+	})
+
+	if h.presence != nil {
+		s.Agents = h.presence.Status().Agents
+	}
+
+	s.Mirrors = h.mirrorsStatus()
+
+	if fault.Allowed() {
+		s.Faults = fault.Status()
+	}
+
+	return s
+}
+
+func (h *Hub) statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.serverStatus())
+}
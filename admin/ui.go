@@ -0,0 +1,17 @@
+package admin
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFS embed.FS
+
+// uiHandler serves the embedded web ui, it's a single page which
+// polls the status and streams the live tail of the admin http.
+func uiHandler() http.Handler {
+	sub, _ := fs.Sub(uiFS, "ui")
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(sub)))
+}
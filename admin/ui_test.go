@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestUI(t *testing.T) {
+	hub := NewHub()
+	hub.presence = newPresence(time.Minute)
+
+	// disabled
+	srv := httptest.NewServer(httpHandler(hub))
+	resp, err := http.Get(srv.URL + "/ui/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	srv.Close()
+
+	hub.ui = true
+	hub.token = "secret"
+	srv = httptest.NewServer(httpHandler(hub))
+	defer srv.Close()
+
+	resp, err = http.Get(srv.URL + "/ui/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/ui/?token=secret")
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(b), "<title>TCPDog</title>")
+}
+
+func TestStatusHandler(t *testing.T) {
+	hub := NewHub()
+	hub.presence = newPresence(time.Minute)
+	hub.ui = true
+
+	records := hub.AddFlow("grpc01/es01")
+	assert.Equal(t, records, hub.AddFlow("grpc01/es01"))
+	*records = 5
+
+	hub.SetStatus(config.Status{Component: "ingress", Name: "grpc01", State: config.StateStarted})
+	hub.SetStatus(config.Status{Component: "ingestion", Name: "es01", State: config.StateFailed, Err: errors.New("timeout")})
+	hub.Publish(map[string]interface{}{"Hostname": "foo"})
+
+	srv := httptest.NewServer(httpHandler(hub))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/status")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	s := ServerStatus{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&s))
+	assert.Equal(t, 1, s.Agents)
+	assert.Equal(t, map[string]uint64{"grpc01/es01": 5}, s.Flows)
+	assert.Equal(t, []ComponentStatus{
+		{Component: "ingestion", Name: "es01", State: "failed", Error: "timeout"},
+		{Component: "ingress", Name: "grpc01", State: "started"},
+	}, s.Components)
+}
+
+func TestTailHandler(t *testing.T) {
+	hub := NewHub()
+	hub.presence = newPresence(time.Minute)
+	hub.ui = true
+
+	srv := httptest.NewServer(httpHandler(hub))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tail?fields=RTT,Task")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	waitSubscribers(hub, 1)
+	hub.Publish(map[string]interface{}{"RTT": float64(5), "Task": "curl", "SAddr": "10.0.0.1"})
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "data: {\"RTT\":5,\"Task\":\"curl\"}\n", line)
+}
@@ -0,0 +1,582 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/dedup"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/ebpf"
+	"github.com/mehrdadrad/tcpdog/egress"
+	"github.com/mehrdadrad/tcpdog/egress/console"
+	"github.com/mehrdadrad/tcpdog/fault"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
+	"github.com/mehrdadrad/tcpdog/recordid"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/summary"
+	"github.com/mehrdadrad/tcpdog/tracing"
+)
+
+// ErrDegraded is returned once the agent stops while some of the
+// skipped tracepoints have never been attached.
+var ErrDegraded = errors.New("agent has been degraded")
+
+// summaryOut is the output of the summary at the exit
+var summaryOut io.Writer = os.Stderr
+
+// tracepointRetryInterval is the retry interval of the skipped tracepoints
+var tracepointRetryInterval = 30 * time.Second
+
+// Option represents an agent option
+type Option func(*options)
+
+type options struct {
+	status   config.StatusFunc
+	tracer   func(*config.Config) (tracer, error)
+	egress   startFunc
+	updates  <-chan map[string]config.EgressConfig
+	progress func() uint64
+	presence func() map[string]serialization.Bitmap
+}
+
+// tracer represents the bpf tracepoints
+type tracer interface {
+	Start(ctx context.Context, tp ebpf.TP) error
+	Flush(ctx context.Context) int
+	Close()
+}
+
+// skipped represents the tracepoints which have been failed
+// to attach and they're retried periodically.
+type skipped struct {
+	sync.Mutex
+	tps []ebpf.TP
+}
+
+// len returns the number of the skipped tracepoints
+func (s *skipped) len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.tps)
+}
+
+func newTracer(cfg *config.Config) (tracer, error) {
+	return ebpf.New(cfg)
+}
+
+// WithStatus sets a callback which receives the components
+// lifecycle events (egresses and tracepoints).
+func WithStatus(fn config.StatusFunc) Option {
+	return func(o *options) {
+		o.status = fn
+	}
+}
+
+// WithEgressUpdates sets a channel which receives the egresses
+// configuration, the changed egresses are swapped at runtime.
+func WithEgressUpdates(ch <-chan map[string]config.EgressConfig) Option {
+	return func(o *options) {
+		o.updates = ch
+	}
+}
+
+// Run validates the configuration, loads the bpf program and starts
+// the egresses and the tracepoints. it blocks until the context is
+// canceled or a component fails to start. with onTracepointError skip
+// the failed tracepoints are retried and it returns ErrDegraded if
+// some of them have never been attached.
+func Run(ctx context.Context, cfg *config.Config, opts ...Option) error {
+	o := &options{status: func(config.Status) {}, tracer: newTracer, egress: egress.Start, progress: ebpf.Progress, presence: ebpf.Presence}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg.SetDefault()
+
+	err := validate(cfg)
+	if err != nil {
+		return err
+	}
+
+	logger := cfg.Logger()
+
+	if fault.Allow(cfg.UnsafeFaultInjection) {
+		logger.Warn("fault", zap.String("msg", "fault injection is enabled"))
+	}
+
+	for _, f := range cfg.Faults {
+		if err := fault.Inject(f); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(ctx))
+	defer cancel()
+
+	if cfg.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var sum *summary.Summary
+	if cfg.Summary.Enable {
+		sum, err = summary.New(cfg.Summary.Fields, cfg.Summary.GroupBy, cfg.Summary.MaxGroups)
+		if err != nil {
+			return err
+		}
+		defer sum.Print(summaryOut)
+	}
+
+	t, err := o.tracer(cfg)
+	if err != nil {
+		return err
+	}
+
+	// the reloaded tracepoints may load other programs
+	r := newReloader(o.tracer, logger)
+	defer r.close()
+	e := r.program(t, cfg)
+	defer logAgentDelay(logger)
+
+	var (
+		mu      sync.Mutex
+		started []config.Status
+	)
+
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, s := range started {
+			s.State = config.StateStopped
+			o.status(s)
+		}
+	}()
+
+	report := func(component, name string, err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		s := config.Status{Component: component, Name: name, State: config.StateStarted}
+		if err != nil {
+			s.State, s.Err = config.StateFailed, err
+			o.status(s)
+			return err
+		}
+
+		started = append(started, s)
+		o.status(s)
+
+		return nil
+	}
+
+	stop := func(component, name string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for i, s := range started {
+			if s.Component == component && s.Name == name {
+				started = append(started[:i], started[i+1:]...)
+				s.State = config.StateStopped
+				o.status(s)
+				return
+			}
+		}
+	}
+
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	var filter *dedup.Filter
+	if cfg.Dedup.Enable {
+		filter = dedup.Start(ctx, cfg.Dedup, logger)
+		defer func() {
+			if err := filter.Save(); err != nil {
+				logger.Warn("dedup", zap.Error(err))
+			}
+			logger.Info("dedup", zap.String("msg", "filter has been saved"),
+				zap.Uint64("dropped", filter.Dropped()))
+		}()
+	}
+
+	if cfg.Tracing.Enabled() {
+		if err := tracing.Start(ctx, cfg.Tracing, "tcpdog-agent", logger); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Metrics.Enable {
+		defer metrics.SetEvents(tracepointEvents)()
+		defer metrics.SetBacklog(r.backlog)()
+		defer metrics.SetAgentDelay(agentDelay)()
+
+		err = metrics.Start(ctx, cfg.Metrics.Addr, logger)
+		if err = report("metrics", cfg.Metrics.Addr, err); err != nil {
+			return err
+		}
+
+		logger.Info("metrics", zap.String("msg", cfg.Metrics.Addr+" has been started"))
+	}
+
+	if !cfg.Quiet && hasConsole(cfg) {
+		console.StartStatus(ctx, cfg.StatsInterval, consoleStats)
+	}
+
+	gen, _ := recordid.New(cfg.RecordID)
+
+	// newPipeline starts the egress of the tracepoint and its stages,
+	// they're stopped once the pipeline is canceled.
+	newPipeline := func(ctx context.Context, cfg *config.Config, tracepoint config.Tracepoint) (*pipeline, error) {
+		ctx, cancel := context.WithCancel(ctx)
+
+		in := make(chan *bytes.Buffer, 1000)
+		ch := in
+
+		// the tracepoints send to the filter and it sends
+		// the new events to the egress.
+		if filter != nil {
+			out := make(chan *bytes.Buffer, 1000)
+			go filter.Run(ctx, bufPool, ch, out)
+			ch = out
+		}
+
+		// the events are stamped after the filter since it prefers
+		// the id and a random id makes every event a new one.
+		if gen != nil {
+			out := make(chan *bytes.Buffer, 1000)
+			go stamp(ctx, gen, ch, out)
+			ch = out
+		}
+
+		if sum != nil {
+			out := make(chan *bytes.Buffer, 1000)
+			go summarize(ctx, sum, ch, out)
+			ch = out
+		}
+
+		// the high records bypass the backlog of the egress
+		lane := priority.NewLane(priority.Reserved)
+
+		router, err := newEgressRouter(priority.WithLane(ctx, lane), cfg, tracepoint, bufPool, ch, o.egress)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		return &pipeline{in: in, lane: lane, router: router, cancel: cancel}, nil
+	}
+
+	for _, tracepoint := range cfg.Tracepoints {
+		if _, ok := r.pipelines[tracepoint.Egress]; ok {
+			continue
+		}
+
+		p, err := newPipeline(ctx, cfg, tracepoint)
+		if err = report("egress", tracepoint.Egress, err); err != nil {
+			return err
+		}
+		r.pipelines[tracepoint.Egress] = p
+
+		eType := cfg.Egress[tracepoint.Egress].Type
+		logger.Info("egress", zap.String("msg", tracepoint.Egress+" has been started"), zap.String("type", eType))
+	}
+
+	go logPriority(ctx, r.lanes, logger)
+
+	if o.updates != nil {
+		go watchEgress(ctx, o.updates, r.routers, logger)
+	}
+
+	sk := &skipped{}
+	if cfg.Metrics.Enable {
+		defer metrics.SetSkipped(sk.len)()
+	}
+
+	for index, tracepoint := range cfg.Tracepoints {
+		tp := ebpf.TP{
+			Name:    tracepoint.Name,
+			Index:   index,
+			BufPool: bufPool,
+			OutChan: r.pipelines[tracepoint.Egress].in,
+			INet:    tracepoint.INet,
+			Workers: tracepoint.Workers,
+			Fields:  cfg.GetTPFields(tracepoint.Fields),
+
+			Aggregate: tracepoint.Aggregate,
+			Custom:    tracepoint.Custom,
+		}
+
+		err := e.Start(ctx, tp)
+		if err != nil && cfg.OnTracepointError == "skip" {
+			report("tracepoint", tp.Name, err)
+			logger.Error("tracepoint", zap.String("msg", tp.Name+" has been skipped"), zap.Error(err))
+			sk.tps = append(sk.tps, tp)
+			continue
+		}
+
+		if err = report("tracepoint", tracepoint.Name, err); err != nil {
+			return err
+		}
+
+		// the connections which have been opened before the attach
+		if tracepoint.ScanExisting != nil {
+			lCtx := priority.WithLane(ctx, r.pipelines[tracepoint.Egress].lane)
+			go scanExisting(lCtx, tracepoint, cfg.Fields[tracepoint.Fields], gen, bufPool, tp.OutChan, logger)
+		}
+	}
+
+	// the tracepoints are reloaded on SIGHUP if it's a config file
+	r.newPipeline, r.bufPool, r.sk, r.report, r.stop = newPipeline, bufPool, sk, report, stop
+	go watchConfig(ctx, cfg.Watch(ctx), r)
+
+	if len(sk.tps) > 0 && len(sk.tps) == len(cfg.Tracepoints) {
+		err := errors.New("all the tracepoints have been failed")
+		o.status(config.Status{Component: "agent", Name: "tracepoints", State: config.StateFailed, Err: err})
+		return err
+	}
+
+	n := newNotifier(cfg.Heartbeat, o.progress, o.presence, logger)
+
+	// the degraded state is exposed by the systemd status and
+	// the skipped tracepoints metric besides the callback.
+	status := func(s config.Status) {
+		o.status(s)
+		n.status(s)
+	}
+
+	status(agentStatus(sk.tps))
+	n.ready(ctx)
+
+	if len(sk.tps) > 0 {
+		go retryTracepoints(ctx, e, sk, func(tp ebpf.TP) {
+			report("tracepoint", tp.Name, nil)
+			logger.Info("tracepoint", zap.String("msg", tp.Name+" has been attached"))
+		}, status)
+	}
+
+	for _, tracepoint := range cfg.Tracepoints {
+		if tracepoint.Aggregate != nil {
+			go flushOnSignal(ctx, e, logger)
+			break
+		}
+	}
+
+	<-ctx.Done()
+
+	n.stopping()
+
+	drops.Finish(cfg.DropReport, logger)
+
+	sk.Lock()
+	defer sk.Unlock()
+
+	if len(sk.tps) > 0 {
+		return ErrDegraded
+	}
+
+	return nil
+}
+
+// hasConsole returns true if a tracepoint sends to a console egress
+func hasConsole(cfg *config.Config) bool {
+	for _, tracepoint := range cfg.Tracepoints {
+		if cfg.Egress[tracepoint.Egress].Type == "console" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// consoleStats returns the tracepoints counters of the status line
+func consoleStats() []console.Counter {
+	var stats []console.Counter
+	for _, c := range ebpf.Stats() {
+		stats = append(stats, console.Counter{Name: c.Name, Events: c.Events, Drops: c.Drops})
+	}
+
+	return stats
+}
+
+// tracepointEvents returns the events per tracepoint name, the
+// reloaded tracepoints are added to their previous counters.
+func tracepointEvents() map[string]uint64 {
+	events := map[string]uint64{}
+	for _, c := range ebpf.Stats() {
+		events[c.Name] += c.Events
+	}
+
+	return events
+}
+
+// retryTracepoints retries the skipped tracepoints until
+// all of them have been attached, e.g. after a debugfs mount.
+func retryTracepoints(ctx context.Context, e tracer, sk *skipped, attached func(ebpf.TP), status config.StatusFunc) {
+	ticker := time.NewTicker(tracepointRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		sk.Lock()
+
+		var tps []ebpf.TP
+		for _, tp := range sk.tps {
+			if err := e.Start(ctx, tp); err != nil {
+				tps = append(tps, tp)
+				continue
+			}
+
+			attached(tp)
+		}
+
+		changed := len(tps) != len(sk.tps)
+		sk.tps = tps
+
+		sk.Unlock()
+
+		if changed {
+			status(agentStatus(tps))
+		}
+
+		if len(tps) == 0 {
+			return
+		}
+	}
+}
+
+// agentStatus returns the agent status, it's degraded
+// if some of the tracepoints have been skipped.
+func agentStatus(tps []ebpf.TP) config.Status {
+	s := config.Status{Component: "agent", Name: "tracepoints", State: config.StateStarted}
+	if len(tps) < 1 {
+		return s
+	}
+
+	var names []string
+	for _, tp := range tps {
+		names = append(names, tp.Name)
+	}
+
+	s.State = config.StateDegraded
+	s.Err = fmt.Errorf("skipped tracepoints: %s", strings.Join(names, ", "))
+
+	return s
+}
+
+// summarize adds the events to the summary
+func summarize(ctx context.Context, sum *summary.Summary, in, out chan *bytes.Buffer) {
+	for {
+		select {
+		case buf := <-in:
+			sum.Add(buf.Bytes())
+
+			select {
+			case out <- buf:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stamp adds the EventID to the events
+func stamp(ctx context.Context, gen recordid.Generator, in, out chan *bytes.Buffer) {
+	for {
+		select {
+		case buf := <-in:
+			recordid.Stamp(buf, gen)
+
+			select {
+			case out <- buf:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logPriority logs the high priority lanes stats per minute
+func logPriority(ctx context.Context, lanes func() map[string]*priority.Lane, logger *zap.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for name, lane := range lanes() {
+				s := lane.Stats()
+				if s.High < 1 && s.Fallback < 1 {
+					continue
+				}
+
+				logger.Info("priority", zap.String("egress", name), zap.Uint64("high", s.High),
+					zap.Uint64("fallback", s.Fallback), zap.Uint64("normal", s.Normal))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logAgentDelay logs the perf buffer dwell time histogram, the
+// buckets are the counts of the ebpf.AgentDelay bounds in usecs.
+func logAgentDelay(logger *zap.Logger) {
+	s := ebpf.AgentDelay.Snapshot()
+	if s.Count < 1 {
+		return
+	}
+
+	logger.Info("agent", zap.String("msg", "agent delay histogram"),
+		zap.Uint64("count", s.Count), zap.Uint64("avg_us", s.Sum/s.Count),
+		zap.Uint64s("bounds_us", s.Bounds), zap.Uint64s("buckets", s.Counts))
+}
+
+// agentDelay returns the ebpf.AgentDelay histogram for the metrics
+func agentDelay() (bounds, counts []uint64, count, sum uint64) {
+	s := ebpf.AgentDelay.Snapshot()
+	return s.Bounds, s.Counts, s.Count, s.Sum
+}
+
+// flushOnSignal flushes the in-kernel aggregations once SIGUSR1
+// is received, e.g. for a snapshot or right before the shutdown.
+func flushOnSignal(ctx context.Context, e tracer, logger *zap.Logger) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-sig:
+			n := e.Flush(ctx)
+			logger.Info("agent", zap.String("msg", "aggregations have been flushed"), zap.Int("entries", n))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,540 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/ebpf"
+)
+
+// fakeTracer fails the tracepoints until they have
+// been retried the configured number of times.
+type fakeTracer struct {
+	sync.Mutex
+	fails map[string]int
+}
+
+func (f *fakeTracer) Start(ctx context.Context, tp ebpf.TP) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.fails[tp.Name] != 0 {
+		f.fails[tp.Name]--
+		return errors.New("tracepoint not found")
+	}
+
+	return nil
+}
+
+func (f *fakeTracer) Flush(ctx context.Context) int { return 0 }
+
+func (f *fakeTracer) Close() {}
+
+func withTracer(t tracer) Option {
+	return func(o *options) {
+		o.tracer = func(*config.Config) (tracer, error) {
+			return t, nil
+		}
+	}
+}
+
+func testConfig(onError string) *config.Config {
+	return &config.Config{
+		Tracepoints: []config.Tracepoint{
+			{Name: "tcp:tcp_retransmit_skb", Fields: "f", TCPState: "TCP_ALL", Egress: "console"},
+			{Name: "tcp:tcp_probe", Fields: "f", TCPState: "TCP_ALL", Egress: "console"},
+		},
+		Fields:            map[string][]config.Field{"f": {{Name: "RTT"}}},
+		Egress:            map[string]config.EgressConfig{"console": {Type: "console"}},
+		OnTracepointError: onError,
+	}
+}
+
+type statusRecorder struct {
+	sync.Mutex
+	events []config.Status
+}
+
+func (r *statusRecorder) record(s config.Status) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.events = append(r.events, s)
+}
+
+func (r *statusRecorder) agent() []config.State {
+	r.Lock()
+	defer r.Unlock()
+
+	var states []config.State
+	for _, s := range r.events {
+		if s.Component == "agent" {
+			states = append(states, s.State)
+		}
+	}
+
+	return states
+}
+
+func TestRunOnTracepointErrorFail(t *testing.T) {
+	tr := &fakeTracer{fails: map[string]int{"tcp:tcp_probe": 1}}
+
+	err := Run(context.Background(), testConfig("fail"), withTracer(tr))
+	assert.EqualError(t, err, "tracepoint not found")
+
+	err = Run(context.Background(), testConfig("ignore"), withTracer(tr))
+	assert.EqualError(t, err, "wrong onTracepointError:ignore")
+}
+
+func TestValidatePriority(t *testing.T) {
+	cfg := testConfig("fail")
+	cfg.Tracepoints[0].Priority = "critical"
+	assert.NoError(t, validate(cfg))
+
+	cfg.Tracepoints[1].Priority = "low"
+	assert.EqualError(t, validate(cfg), "wrong priority (tcp:tcp_probe) priority:low")
+}
+
+func TestValidateScanExistingRate(t *testing.T) {
+	cfg := testConfig("fail")
+	cfg.Tracepoints[0].ScanExisting = &config.ScanExisting{Rate: 1000}
+	assert.NoError(t, validate(cfg))
+
+	cfg.Tracepoints[0].ScanExisting.Rate = 2e9
+	assert.EqualError(t, validate(cfg), "wrong scanExisting rate (tcp:tcp_retransmit_skb) rate:2000000000")
+}
+
+func TestValidateAggregatePB(t *testing.T) {
+	cfg := testConfig("fail")
+	cfg.Tracepoints[0].Fields = ""
+	cfg.Tracepoints[0].Aggregate = &config.Aggregate{Key: []string{"DAddr"}, Metrics: []string{"count", "max:RTT"}}
+	cfg.Egress["console"] = config.EgressConfig{Type: "console", Config: map[string]interface{}{"serialization": "spb"}}
+	assert.NoError(t, validate(cfg))
+	assert.Equal(t, []config.Field{{Name: "DAddr"}, {Name: "Count"}, {Name: "MaxRTT"}, {Name: "Window"}}, cfg.Fields["aggregate0"])
+
+	cfg = testConfig("fail")
+	cfg.Tracepoints[0].Fields = ""
+	cfg.Tracepoints[0].Aggregate = &config.Aggregate{Metrics: []string{"count"}}
+	cfg.Egress["console"] = config.EgressConfig{Type: "grpc-pb"}
+	assert.EqualError(t, validate(cfg), "aggregate-in-kernel doesn't support pb serialization, "+
+		"the summary fields aren't in the pb schema, use spb (tcp:tcp_retransmit_skb)")
+}
+
+func TestRunOnTracepointErrorSkip(t *testing.T) {
+	interval := tracepointRetryInterval
+	tracepointRetryInterval = 10 * time.Millisecond
+	defer func() { tracepointRetryInterval = interval }()
+
+	// all the tracepoints have been failed
+	r := &statusRecorder{}
+	tr := &fakeTracer{fails: map[string]int{"tcp:tcp_probe": 1, "tcp:tcp_retransmit_skb": 1}}
+	err := Run(context.Background(), testConfig("skip"), withTracer(tr), WithStatus(r.record))
+	assert.EqualError(t, err, "all the tracepoints have been failed")
+	assert.Equal(t, []config.State{config.StateFailed}, r.agent())
+
+	// degraded and it never recovers
+	r = &statusRecorder{}
+	tr = &fakeTracer{fails: map[string]int{"tcp:tcp_probe": -1}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = Run(ctx, testConfig("skip"), withTracer(tr), WithStatus(r.record))
+	assert.Equal(t, ErrDegraded, err)
+	assert.Equal(t, []config.State{config.StateDegraded}, r.agent())
+
+	// degraded and it recovers after two retries
+	r = &statusRecorder{}
+	tr = &fakeTracer{fails: map[string]int{"tcp:tcp_probe": 3}}
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = Run(ctx, testConfig("skip"), withTracer(tr), WithStatus(r.record))
+	assert.NoError(t, err)
+	assert.Equal(t, []config.State{config.StateDegraded, config.StateStarted}, r.agent())
+
+	var probe []config.State
+	for _, s := range r.events {
+		if s.Name == "tcp:tcp_probe" {
+			probe = append(probe, s.State)
+		}
+	}
+	assert.Equal(t, []config.State{config.StateFailed, config.StateStarted, config.StateStopped}, probe)
+}
+
+// lossyTracer reports the perf buffer lost samples once it's started
+type lossyTracer struct {
+	fakeTracer
+}
+
+func (l *lossyTracer) Start(ctx context.Context, tp ebpf.TP) error {
+	drops.Add(drops.KernelLost, tp.Name, 4)
+	return nil
+}
+
+func TestRunDropReport(t *testing.T) {
+	cfg := testConfig("fail")
+	cfg.DropReport = filepath.Join(t.TempDir(), "drops.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := Run(ctx, cfg, withTracer(&lossyTracer{}))
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(cfg.DropReport)
+	assert.NoError(t, err)
+
+	r := drops.Report{}
+	assert.NoError(t, json.Unmarshal(b, &r))
+	assert.Equal(t, uint64(8), r.Totals[drops.KernelLost])
+
+	var names []string
+	for _, d := range r.Drops {
+		if d.Category == drops.KernelLost {
+			names = append(names, d.Name)
+			assert.Equal(t, uint64(4), d.Count)
+		}
+	}
+	assert.Equal(t, []string{"tcp:tcp_probe", "tcp:tcp_retransmit_skb"}, names)
+}
+
+func TestPresets(t *testing.T) {
+	assert.Len(t, config.Presets(), 3)
+
+	for _, name := range config.Presets() {
+		file := filepath.Join(t.TempDir(), "agent.yml")
+		assert.NoError(t, ioutil.WriteFile(file, []byte("preset: "+name), 0644))
+
+		cfg, err := config.Get([]string{"tcpdog", "-config", file}, "0.0.0")
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, cfg.Tracepoints, name)
+		assert.NoError(t, validate(cfg), name)
+	}
+}
+
+// emitTracer sends the events to the tracepoint channel once it's started
+type emitTracer struct {
+	fakeTracer
+	events []string
+}
+
+func (e *emitTracer) Start(ctx context.Context, tp ebpf.TP) error {
+	for _, event := range e.events {
+		buf := tp.BufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		buf.WriteString(event)
+		tp.OutChan <- buf
+	}
+
+	e.events = nil
+
+	return nil
+}
+
+func TestRunSummary(t *testing.T) {
+	out := new(bytes.Buffer)
+	summaryOut = out
+	defer func() { summaryOut = os.Stderr }()
+
+	cfg := testConfig("fail")
+	cfg.Fields["f"] = []config.Field{{Name: "RTT"}, {Name: "DAddr"}}
+	cfg.Summary = config.Summary{Enable: true, GroupBy: "daddr", MaxGroups: 1}
+	cfg.Duration = 100 * time.Millisecond
+
+	tr := &emitTracer{events: []string{
+		`{"RTT":100,"DAddr":"10.0.0.1"}`,
+		`{"RTT":300,"DAddr":"10.0.0.1"}`,
+		`{"RTT":500,"DAddr":"10.0.0.2"}`,
+	}}
+
+	err := Run(context.Background(), cfg, withTracer(tr))
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, []string{"DADDR", "FIELD", "COUNT", "P50", "P90", "P99", "MAX"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"10.0.0.1", "RTT", "2"}, strings.Fields(lines[1])[:3])
+	assert.Equal(t, []string{"other", "RTT", "1", "500.0", "500.0", "500.0", "500"}, strings.Fields(lines[2]))
+
+	cfg.Summary.Fields = []string{"SAddr"}
+	err = Run(context.Background(), cfg, withTracer(tr))
+	assert.EqualError(t, err, "summary field SAddr is not numeric")
+}
+
+func TestRunDedupRecordID(t *testing.T) {
+	cfg := testConfig("fail")
+	cfg.Tracepoints = cfg.Tracepoints[:1]
+	cfg.Dedup = config.Dedup{Enable: true, File: filepath.Join(t.TempDir(), "dedup.bloom")}
+	cfg.RecordID = "uuid4"
+	cfg.Duration = 100 * time.Millisecond
+
+	tr := &emitTracer{events: []string{
+		`{"RTT":100,"Timestamp":1611634115}`,
+		`{"RTT":100,"Timestamp":1611634115}`,
+	}}
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+
+	egress := func(ctx context.Context, tp config.Tracepoint, bufPool *sync.Pool, ch chan *bytes.Buffer) error {
+		go func() {
+			for buf := range ch {
+				mu.Lock()
+				events = append(events, buf.String())
+				mu.Unlock()
+			}
+		}()
+		return nil
+	}
+
+	err := Run(context.Background(), cfg, withTracer(tr), func(o *options) { o.egress = egress })
+	assert.NoError(t, err)
+
+	// the duplicate is dropped before the random id is stamped
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, events, 1)
+	assert.Contains(t, events[0], `"EventID":"`)
+}
+
+// recordTracer records the started tracepoints of a program
+type recordTracer struct {
+	fakeTracer
+	tps    []string
+	closed bool
+}
+
+func (r *recordTracer) Start(ctx context.Context, tp ebpf.TP) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.tps = append(r.tps, tp.Name)
+
+	return nil
+}
+
+func (r *recordTracer) Close() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.closed = true
+}
+
+func (r *recordTracer) state() ([]string, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]string{}, r.tps...), r.closed
+}
+
+const reloadConfig = `
+quiet: true
+tracepoints:
+  - name: tcp:tcp_retransmit_skb
+    fields: f
+    tcp_state: TCP_ALL
+    egress: console
+  - name: tcp:tcp_probe
+    fields: f
+    tcp_state: TCP_ALL
+    workers: %d
+    egress: console
+fields:
+  f:
+    - name: RTT
+egress:
+  console:
+    type: console
+`
+
+func TestRunReload(t *testing.T) {
+	// the signal is ignored until the agent watches it
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	file := filepath.Join(t.TempDir(), "agent.yml")
+	reload := func(content string) {
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+		assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	}
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(fmt.Sprintf(reloadConfig, 1)), 0644))
+	cfg, err := config.Get([]string{"tcpdog", "-config", file}, "0.0.0")
+	assert.NoError(t, err)
+	ms := cfg.SetMockLogger("reload")
+
+	var (
+		mu       sync.Mutex
+		programs []*recordTracer
+	)
+
+	program := func(i int) ([]string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(programs) <= i {
+			return nil, false
+		}
+
+		return programs[i].state()
+	}
+
+	newTracer := func(*config.Config) (tracer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		r := &recordTracer{}
+		programs = append(programs, r)
+
+		return r, nil
+	}
+
+	sr := &statusRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- Run(ctx, cfg, func(o *options) { o.tracer = newTracer }, WithStatus(sr.record))
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(sr.agent()) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// tcp_probe has been changed and it's attached by a new program
+	reload(fmt.Sprintf(reloadConfig, 2))
+
+	assert.Eventually(t, func() bool {
+		tps, _ := program(1)
+		return len(tps) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tps, closed := program(1)
+	assert.Equal(t, []string{"tcp:tcp_probe"}, tps)
+	assert.False(t, closed)
+
+	tps, closed = program(0)
+	assert.Equal(t, []string{"tcp:tcp_retransmit_skb", "tcp:tcp_probe"}, tps)
+	assert.False(t, closed)
+
+	// the invalid config is rejected
+	reload(strings.Replace(fmt.Sprintf(reloadConfig, 2), "TCP_ALL", "TCP_FOO", 1))
+
+	time.Sleep(200 * time.Millisecond)
+
+	// the first program is closed once its tracepoints have been detached
+	retransmit := "  - name: tcp:tcp_retransmit_skb\n    fields: f\n    tcp_state: TCP_ALL\n    egress: console\n"
+	reload(strings.Replace(fmt.Sprintf(reloadConfig, 2), retransmit, "", 1))
+
+	assert.Eventually(t, func() bool {
+		_, closed := program(0)
+		return closed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tps, closed = program(1)
+	assert.Equal(t, []string{"tcp:tcp_probe"}, tps)
+	assert.False(t, closed)
+
+	mu.Lock()
+	assert.Len(t, programs, 2)
+	mu.Unlock()
+
+	// the new egress is started by its tracepoint
+	content := strings.Replace(fmt.Sprintf(reloadConfig, 2), retransmit, "", 1)
+	destroy := "  - name: tcp:tcp_destroy_sock\n    fields: f\n    tcp_state: TCP_ALL\n    egress: stdout\n"
+	content = strings.Replace(content, "fields:\n  f:", destroy+"fields:\n  f:", 1)
+	reload(content + "  stdout:\n    type: console\n")
+
+	assert.Eventually(t, func() bool {
+		tps, _ := program(2)
+		return len(tps) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tps, _ = program(2)
+	assert.Equal(t, []string{"tcp:tcp_destroy_sock"}, tps)
+	assert.Contains(t, ms.String(), "stdout has been started")
+
+	// the removed egress is stopped once its tracepoint has been detached
+	reload(strings.Replace(fmt.Sprintf(reloadConfig, 2), retransmit, "", 1))
+
+	assert.Eventually(t, func() bool {
+		_, closed := program(2)
+		return closed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(ms.String(), "stdout has been stopped")
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	_, closed = program(1)
+	assert.True(t, closed)
+	assert.Contains(t, ms.String(), "reloaded config has been rejected")
+}
+
+func TestReloadIdenticalTracepoints(t *testing.T) {
+	cfg := testConfig("fail")
+	cfg.Tracepoints[1] = cfg.Tracepoints[0]
+	cfg.SetMockLogger("identical")
+
+	var programs []*recordTracer
+
+	r := newReloader(func(*config.Config) (tracer, error) {
+		programs = append(programs, &recordTracer{})
+		return programs[len(programs)-1], nil
+	}, cfg.Logger())
+	r.sk = &skipped{}
+	r.report = func(component, name string, err error) error { return err }
+	r.stop = func(component, name string) {}
+	r.pipelines["console"] = &pipeline{in: make(chan *bytes.Buffer, 1), cancel: func() {}}
+
+	first := &recordTracer{}
+	p := r.program(first, cfg)
+	ctx := context.Background()
+
+	for i, tp := range cfg.Tracepoints {
+		assert.NoError(t, p.Start(ctx, ebpf.TP{Name: tp.Name, Index: i}))
+	}
+
+	assert.Len(t, r.attached, 2)
+
+	// one of the identical tracepoints is detached
+	conf := testConfig("fail")
+	conf.Tracepoints = conf.Tracepoints[:1]
+	r.apply(ctx, &config.ChangeSet{Config: conf})
+
+	assert.Len(t, r.attached, 1)
+	assert.Len(t, programs, 0)
+	_, closed := first.state()
+	assert.False(t, closed)
+
+	// the identical tracepoint is attached again by a new program
+	r.apply(ctx, &config.ChangeSet{Config: cfg})
+
+	assert.Len(t, r.attached, 2)
+	assert.Len(t, programs, 1)
+
+	tps, _ := programs[0].state()
+	assert.Equal(t, []string{"tcp:tcp_retransmit_skb"}, tps)
+}
@@ -0,0 +1,264 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/priority"
+	"github.com/mehrdadrad/tcpdog/recordid"
+)
+
+// procRoot is the procfs mount point
+var procRoot = "/proc"
+
+// tcpEstablished is the ESTABLISHED state in /proc/net/tcp{,6}
+const tcpEstablished = "01"
+
+// connection represents an established socket of /proc/net/tcp{,6}
+type connection struct {
+	saddr   net.IP
+	daddr   net.IP
+	lport   uint16
+	dport   uint16
+	txQueue uint64
+	rxQueue uint64
+	uid     uint64
+	inode   uint64
+}
+
+// process represents the owner of a socket
+type process struct {
+	pid  int
+	comm string
+}
+
+// scanExisting emits a synthetic record per established connection
+// which has been opened before the agent started, the records have
+// the tracepoint fields which are available in procfs. it's rate
+// limited to avoid a burst on the egress. the records are high
+// priority thus they're not held behind the tracepoints backlog,
+// they bypass the stamp and filter stages so they're stamped here.
+func scanExisting(ctx context.Context, tp config.Tracepoint, fields []config.Field, gen recordid.Generator, bufPool *sync.Pool, ch chan *bytes.Buffer, logger *zap.Logger) {
+	var conns []connection
+
+	for _, version := range tp.INet {
+		name := "tcp"
+		if version == 6 {
+			name = "tcp6"
+		}
+
+		f, err := os.Open(filepath.Join(procRoot, "net", name))
+		if err != nil {
+			logger.Warn("agent", zap.String("msg", "existing connections"), zap.Error(err))
+			continue
+		}
+
+		c, err := parseProcNet(f)
+		f.Close()
+		if err != nil {
+			logger.Warn("agent", zap.String("msg", "existing connections"), zap.Error(err))
+			continue
+		}
+
+		conns = append(conns, c...)
+	}
+
+	if len(conns) < 1 {
+		return
+	}
+
+	procs := socketOwners()
+	lane := priority.FromContext(ctx)
+
+	ticker := time.NewTicker(time.Second / time.Duration(tp.ScanExisting.Rate))
+	defer ticker.Stop()
+
+	for _, c := range conns {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		buf := bufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		c.encode(fields, procs[c.inode], buf)
+
+		if gen != nil {
+			recordid.Stamp(buf, gen)
+		}
+
+		if !lane.SendBuffer(ctx, ch, buf, priority.High) {
+			return
+		}
+	}
+
+	logger.Info("agent", zap.String("msg", "existing connections have been emitted"),
+		zap.String("tracepoint", tp.Name), zap.Int("connections", len(conns)))
+}
+
+// parseProcNet returns the established connections of /proc/net/tcp{,6}
+func parseProcNet(r io.Reader) ([]connection, error) {
+	var conns []connection
+
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 10 || f[3] != tcpEstablished {
+			continue
+		}
+
+		c := connection{}
+
+		var err error
+		c.saddr, c.lport, err = parseAddr(f[1])
+		if err != nil {
+			return nil, err
+		}
+
+		c.daddr, c.dport, err = parseAddr(f[2])
+		if err != nil {
+			return nil, err
+		}
+
+		queues := strings.SplitN(f[4], ":", 2)
+		if len(queues) != 2 {
+			return nil, fmt.Errorf("invalid queues: %s", f[4])
+		}
+		c.txQueue, _ = strconv.ParseUint(queues[0], 16, 64)
+		c.rxQueue, _ = strconv.ParseUint(queues[1], 16, 64)
+
+		c.uid, _ = strconv.ParseUint(f[7], 10, 32)
+		c.inode, _ = strconv.ParseUint(f[9], 10, 64)
+
+		conns = append(conns, c)
+	}
+
+	return conns, scanner.Err()
+}
+
+// parseAddr parses the hex address and port, the address is
+// the 32 bits words of the kernel in the host byte order.
+func parseAddr(s string) (net.IP, uint16, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid address: %s", s)
+	}
+
+	b, err := hex.DecodeString(parts[0])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address: %s", s)
+	}
+
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.LittleEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port: %s", s)
+	}
+
+	return ip, uint16(port), nil
+}
+
+// socketOwners resolves the sockets inode to their processes
+// by the /proc/<pid>/fd links, e.g. socket:[12345].
+func socketOwners() map[uint64]process {
+	procs := map[uint64]process{}
+
+	dirs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return procs
+	}
+
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join(procRoot, d.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		var comm string
+
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[8:], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			if comm == "" {
+				b, _ := ioutil.ReadFile(filepath.Join(procRoot, d.Name(), "comm"))
+				comm = strings.TrimSpace(string(b))
+			}
+
+			procs[inode] = process{pid: pid, comm: comm}
+		}
+	}
+
+	return procs
+}
+
+// encode writes the record in the decoder format, the fields which
+// aren't available in procfs are omitted.
+func (c connection) encode(fields []config.Field, p process, buf *bytes.Buffer) {
+	buf.WriteRune('{')
+
+	for _, f := range fields {
+		switch f.Name {
+		case "Task":
+			if p.pid != 0 {
+				comm, _ := json.Marshal(p.comm)
+				fmt.Fprintf(buf, `"Task":%s,`, comm)
+			}
+		case "PID":
+			if p.pid != 0 {
+				fmt.Fprintf(buf, `"PID":%d,`, p.pid)
+			}
+		case "SAddr":
+			fmt.Fprintf(buf, `"SAddr":"%s",`, c.saddr)
+		case "DAddr":
+			fmt.Fprintf(buf, `"DAddr":"%s",`, c.daddr)
+		case "LPort":
+			fmt.Fprintf(buf, `"LPort":%d,`, c.lport)
+		case "DPort":
+			fmt.Fprintf(buf, `"DPort":%d,`, c.dport)
+		case "TxQueue":
+			fmt.Fprintf(buf, `"TxQueue":%d,`, c.txQueue)
+		case "RxQueue":
+			fmt.Fprintf(buf, `"RxQueue":%d,`, c.rxQueue)
+		}
+	}
+
+	fmt.Fprintf(buf, `"UID":%d,"Synthetic":true,"Timestamp":%d}`, c.uid, time.Now().Unix())
+}
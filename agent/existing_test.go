@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 23456 1 0000000000000000 100 0 0 10 0
+   1: 0F02000A:D4B2 0101A8C0:01BB 01 0000002A:00000010 02:000000A5 00000000  1000        0 12345 2 0000000000000000 20 4 30 10 -1
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0000000000000000FFFF00000F02000A:D4B4 0000000000000000FFFF00000101A8C0:0050 01 00000000:00000000 00:00000000 00000000     0        0 22222 1 0000000000000000 20 4 30 10 -1
+   1: B80D0120000000000000000001000000:0016 B80D0120000000000000000002000000:C350 01 00000000:00000000 00:00000000 00000000     0        0 33333 1 0000000000000000 20 4 30 10 -1
+`
+
+func TestParseProcNet(t *testing.T) {
+	conns, err := parseProcNet(strings.NewReader(procNetTCP))
+	assert.NoError(t, err)
+	assert.Len(t, conns, 1)
+	assert.Equal(t, "10.0.2.15", conns[0].saddr.String())
+	assert.Equal(t, "192.168.1.1", conns[0].daddr.String())
+	assert.Equal(t, uint16(54450), conns[0].lport)
+	assert.Equal(t, uint16(443), conns[0].dport)
+	assert.Equal(t, uint64(42), conns[0].txQueue)
+	assert.Equal(t, uint64(16), conns[0].rxQueue)
+	assert.Equal(t, uint64(1000), conns[0].uid)
+	assert.Equal(t, uint64(12345), conns[0].inode)
+
+	conns, err = parseProcNet(strings.NewReader(procNetTCP6))
+	assert.NoError(t, err)
+	assert.Len(t, conns, 2)
+	assert.Equal(t, "10.0.2.15", conns[0].saddr.String())
+	assert.Equal(t, "2001:db8::1", conns[1].saddr.String())
+	assert.Equal(t, "2001:db8::2", conns[1].daddr.String())
+	assert.Equal(t, uint16(22), conns[1].lport)
+
+	_, err = parseProcNet(strings.NewReader("header\n 0: 01:0016 02:0050 01 0:0 0 0 0 0 1\n"))
+	assert.Error(t, err)
+}
+
+func TestScanExisting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcpdog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	procRoot = dir
+	defer func() { procRoot = "/proc" }()
+
+	os.MkdirAll(filepath.Join(dir, "net"), 0755)
+	os.MkdirAll(filepath.Join(dir, "4242", "fd"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "net", "tcp"), []byte(procNetTCP), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "4242", "comm"), []byte("curl\n"), 0644)
+	os.Symlink("socket:[12345]", filepath.Join(dir, "4242", "fd", "3"))
+	os.Symlink("/dev/null", filepath.Join(dir, "4242", "fd", "0"))
+
+	tp := config.Tracepoint{Name: "tcp:tcp_probe", INet: []int{4}, ScanExisting: &config.ScanExisting{Rate: 100}}
+	fields := []config.Field{{Name: "Task"}, {Name: "PID"}, {Name: "SRTT"}, {Name: "SAddr"}, {Name: "DAddr"}, {Name: "DPort"}}
+	bufPool := &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	ch := make(chan *bytes.Buffer, 10)
+
+	scanExisting(context.Background(), tp, fields, nil, bufPool, ch, zap.NewNop())
+
+	assert.Len(t, ch, 1)
+	b := (<-ch).String()
+	assert.True(t, strings.HasPrefix(b, `{"Task":"curl","PID":4242,"SAddr":"10.0.2.15","DAddr":"192.168.1.1","DPort":443,"UID":1000,"Synthetic":true,"Timestamp":`), b)
+
+	// rate limited
+	tp.INet = []int{4, 4, 4, 4, 4}
+	tp.ScanExisting.Rate = 10
+
+	start := time.Now()
+	scanExisting(context.Background(), tp, fields, nil, bufPool, ch, zap.NewNop())
+	assert.Len(t, ch, 5)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+}
+
+func TestEncodeTask(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := connection{saddr: net.ParseIP("10.0.2.15"), daddr: net.ParseIP("192.168.1.1")}
+	c.encode([]config.Field{{Name: "Task"}}, process{pid: 4242, comm: `a"b\\c`}, buf)
+
+	m := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+	assert.Equal(t, `a"b\\c`, m["Task"])
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// notifier sends the liveness notifications to systemd (sd_notify)
+// or writes the heartbeat file if the agent isn't under systemd.
+type notifier struct {
+	socket   string
+	file     string
+	interval time.Duration
+	progress func() uint64
+	presence func() map[string]serialization.Bitmap
+	logger   *zap.Logger
+}
+
+// heartbeatFile is the heartbeat file content, the fields are the
+// encoded bitmap of the populated fields of each tracepoint since
+// the last heartbeat.
+type heartbeatFile struct {
+	Time   time.Time         `json:"time"`
+	Fields map[string]string `json:"fields"`
+}
+
+// newNotifier returns nil if neither systemd nor the heartbeat
+// file are available. the systemd watchdog interval is the half
+// of the WatchdogSec if it's enabled for the agent process.
+func newNotifier(hCfg config.Heartbeat, progress func() uint64, presence func() map[string]serialization.Bitmap, logger *zap.Logger) *notifier {
+	n := &notifier{
+		socket:   os.Getenv("NOTIFY_SOCKET"),
+		file:     hCfg.File,
+		interval: hCfg.Interval,
+		progress: progress,
+		presence: presence,
+		logger:   logger,
+	}
+
+	if n.socket == "" && n.file == "" {
+		return nil
+	}
+
+	if n.socket != "" {
+		n.file = ""
+		n.interval = 0
+
+		usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+		pid := os.Getenv("WATCHDOG_PID")
+		if err == nil && usec > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+			n.interval = time.Duration(usec) * time.Microsecond / 2
+		}
+	}
+
+	return n
+}
+
+// ready notifies systemd once the egresses and the tracepoints
+// have been started and it starts the watchdog.
+func (n *notifier) ready(ctx context.Context) {
+	if n == nil {
+		return
+	}
+
+	n.notify("READY=1")
+
+	if n.file != "" {
+		n.heartbeat()
+	}
+
+	if n.interval > 0 {
+		go n.run(ctx)
+	}
+}
+
+// status sends the agent status to systemd, e.g. it shows
+// the skipped tracepoints of the degraded agent.
+func (n *notifier) status(s config.Status) {
+	if n == nil {
+		return
+	}
+
+	state := "STATUS=" + string(s.State)
+	if s.Err != nil {
+		state += ": " + s.Err.Error()
+	}
+
+	n.notify(state)
+}
+
+// stopping notifies systemd the graceful shutdown has been started
+func (n *notifier) stopping() {
+	if n == nil {
+		return
+	}
+
+	n.notify("STOPPING=1")
+}
+
+// run sends the heartbeat per interval if the bpf readers have made
+// progress since the last one, the readers make progress on a quiet
+// host as long as they're waiting for the events.
+func (n *notifier) run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	last := n.progress()
+	stalled := false
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		p := n.progress()
+		if p == last {
+			if !stalled {
+				n.logger.Warn("agent", zap.String("msg", "bpf readers are not making progress, heartbeat has been stopped"))
+			}
+			stalled = true
+			continue
+		}
+
+		if stalled {
+			n.logger.Info("agent", zap.String("msg", "bpf readers are making progress, heartbeat has been resumed"))
+		}
+
+		last, stalled = p, false
+		n.heartbeat()
+	}
+}
+
+// heartbeat notifies the systemd watchdog or it replaces the
+// heartbeat file, the readers never see a partial file.
+func (n *notifier) heartbeat() {
+	if n.socket != "" {
+		n.notify("WATCHDOG=1")
+		return
+	}
+
+	h := heartbeatFile{Time: time.Now(), Fields: map[string]string{}}
+	if n.presence != nil {
+		for tp, b := range n.presence() {
+			h.Fields[tp] = b.Encode()
+		}
+	}
+
+	b, _ := json.Marshal(h)
+
+	tmp := n.file + ".tmp"
+	err := ioutil.WriteFile(tmp, b, 0644)
+	if err == nil {
+		err = os.Rename(tmp, n.file)
+	}
+	if err != nil {
+		n.logger.Warn("agent", zap.String("msg", "heartbeat"), zap.Error(err))
+	}
+}
+
+// notify sends the state to the systemd notify socket, an abstract
+// socket name starts with @.
+func (n *notifier) notify(state string) {
+	if n.socket == "" {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if err != nil {
+		n.logger.Warn("agent", zap.String("msg", "sd_notify"), zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		n.logger.Warn("agent", zap.String("msg", "sd_notify"), zap.Error(err))
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/ebpf"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+func TestNotifierSystemd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcpdog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	recv := func() string {
+		b := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(b)
+		if err != nil {
+			return err.Error()
+		}
+		return string(b[:n])
+	}
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	os.Setenv("WATCHDOG_USEC", "20000")
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	var progress uint64
+
+	n := newNotifier(config.Heartbeat{File: "/tmp/ignored", Interval: time.Hour},
+		func() uint64 { return atomic.LoadUint64(&progress) }, nil, zap.NewNop())
+	assert.Equal(t, 10*time.Millisecond, n.interval)
+	assert.Equal(t, "", n.file)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n.status(agentStatus([]ebpf.TP{{Name: "tcp:tcp_probe"}}))
+	assert.Equal(t, "STATUS=degraded: skipped tracepoints: tcp:tcp_probe", recv())
+
+	n.ready(ctx)
+	assert.Equal(t, "READY=1", recv())
+
+	// no progress, no watchdog
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 64))
+	assert.Error(t, err)
+
+	atomic.AddUint64(&progress, 1)
+	assert.Equal(t, "WATCHDOG=1", recv())
+
+	n.stopping()
+	assert.Equal(t, "STOPPING=1", recv())
+}
+
+func TestNotifierWatchdogPID(t *testing.T) {
+	os.Setenv("NOTIFY_SOCKET", "@tcpdog")
+	os.Setenv("WATCHDOG_USEC", "20000")
+	os.Setenv("WATCHDOG_PID", "1")
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	// the watchdog belongs to another process
+	n := newNotifier(config.Heartbeat{}, nil, nil, zap.NewNop())
+	assert.Equal(t, time.Duration(0), n.interval)
+}
+
+func TestNotifierFile(t *testing.T) {
+	assert.Nil(t, newNotifier(config.Heartbeat{}, nil, nil, zap.NewNop()))
+
+	dir, err := ioutil.TempDir("", "tcpdog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "heartbeat")
+
+	var progress uint64
+
+	n := newNotifier(config.Heartbeat{File: file, Interval: 10 * time.Millisecond},
+		func() uint64 { return atomic.LoadUint64(&progress) },
+		func() map[string]serialization.Bitmap {
+			var b serialization.Bitmap
+			b.Set("RTT")
+			return map[string]serialization.Bitmap{"tcp:tcp_retransmit_skb": b}
+		}, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n.ready(ctx)
+	assert.FileExists(t, file)
+
+	// the populated fields of the tracepoints
+	b, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	h := heartbeatFile{}
+	assert.NoError(t, json.Unmarshal(b, &h))
+	fields, err := serialization.DecodeBitmap(h.Fields["tcp:tcp_retransmit_skb"])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"RTT"}, fields.Names())
+
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(file, old, old))
+
+	// no progress, the file isn't touched
+	time.Sleep(50 * time.Millisecond)
+	info, _ := os.Stat(file)
+	assert.Equal(t, old.Unix(), info.ModTime().Unix())
+
+	atomic.AddUint64(&progress, 1)
+	assert.Eventually(t, func() bool {
+		info, _ := os.Stat(file)
+		return info.ModTime().After(old)
+	}, time.Second, 5*time.Millisecond)
+}
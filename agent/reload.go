@@ -0,0 +1,378 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/ebpf"
+	"github.com/mehrdadrad/tcpdog/priority"
+)
+
+// program represents a loaded bpf program, the tracepoints which
+// it starts are tracked thus the config reload can detach them.
+type program struct {
+	tracer
+	cfg    *config.Config
+	r      *reloader
+	refs   int
+	closed bool
+}
+
+// pipeline represents a running egress and its stages, the
+// tracepoints of the egress send their events to the in channel.
+type pipeline struct {
+	in     chan *bytes.Buffer
+	lane   *priority.Lane
+	router *egressRouter
+	cancel context.CancelFunc
+}
+
+// attachment represents an attached tracepoint of a program
+type attachment struct {
+	name    string
+	key     string
+	cancel  context.CancelFunc
+	program *program
+}
+
+// reloader applies the reloaded configurations, the tracepoints
+// which haven't been changed keep their programs, the changed ones
+// are detached and the new ones are attached by a new program. a bpf
+// program is closed once all of its tracepoints have been detached.
+// the egresses are started once a tracepoint requires them, swapped
+// if they've been changed and stopped once they've been removed.
+type reloader struct {
+	sync.Mutex
+
+	newTracer func(*config.Config) (tracer, error)
+	logger    *zap.Logger
+	attached  map[*attachment]struct{}
+	programs  []*program
+	closed    bool
+
+	pipelines   map[string]*pipeline
+	newPipeline func(context.Context, *config.Config, config.Tracepoint) (*pipeline, error)
+	bufPool     *sync.Pool
+	sk          *skipped
+	report      func(component, name string, err error) error
+	stop        func(component, name string)
+}
+
+func newReloader(newTracer func(*config.Config) (tracer, error), logger *zap.Logger) *reloader {
+	return &reloader{
+		newTracer: newTracer,
+		logger:    logger,
+		attached:  map[*attachment]struct{}{},
+		pipelines: map[string]*pipeline{},
+	}
+}
+
+// lanes returns the high priority lanes of the egresses
+func (r *reloader) lanes() map[string]*priority.Lane {
+	r.Lock()
+	defer r.Unlock()
+
+	lanes := make(map[string]*priority.Lane, len(r.pipelines))
+	for name, p := range r.pipelines {
+		lanes[name] = p.lane
+	}
+
+	return lanes
+}
+
+// routers returns the routers of the egresses
+func (r *reloader) routers() map[string]*egressRouter {
+	r.Lock()
+	defer r.Unlock()
+
+	routers := make(map[string]*egressRouter, len(r.pipelines))
+	for name, p := range r.pipelines {
+		routers[name] = p.router
+	}
+
+	return routers
+}
+
+// backlog returns the length of the egresses channels
+func (r *reloader) backlog() map[string]int {
+	r.Lock()
+	defer r.Unlock()
+
+	backlog := make(map[string]int, len(r.pipelines))
+	for name, p := range r.pipelines {
+		backlog[name] = len(p.in)
+	}
+
+	return backlog
+}
+
+// program returns the tracked program of the tracer
+func (r *reloader) program(t tracer, cfg *config.Config) *program {
+	r.Lock()
+	defer r.Unlock()
+
+	p := &program{tracer: t, cfg: cfg, r: r}
+	r.programs = append(r.programs, p)
+
+	return p
+}
+
+// Start starts the tracepoint and it tracks its attachment
+func (p *program) Start(ctx context.Context, tp ebpf.TP) error {
+	a, err := p.start(ctx, tp)
+	if err != nil {
+		return err
+	}
+
+	p.r.Lock()
+	defer p.r.Unlock()
+
+	p.refs++
+	a.key = tracepointKey(p.cfg, p.cfg.Tracepoints[tp.Index])
+	p.r.attached[a] = struct{}{}
+
+	return nil
+}
+
+// start starts the tracepoint with its own context which
+// stops the tracepoint workers once it's detached.
+func (p *program) start(ctx context.Context, tp ebpf.TP) (*attachment, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	if err := p.tracer.Start(ctx, tp); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &attachment{name: tp.Name, cancel: cancel, program: p}, nil
+}
+
+// tracepointKey returns the identity of a tracepoint, a tracepoint is
+// reattached if its key has been changed e.g. its name, inet or fields.
+func tracepointKey(cfg *config.Config, tp config.Tracepoint) string {
+	b, _ := json.Marshal(struct {
+		Tracepoint config.Tracepoint
+		Fields     []config.Field
+	}{tp, cfg.Fields[tp.Fields]})
+
+	return string(b)
+}
+
+// watchConfig applies the reloaded configurations until the context is done
+func watchConfig(ctx context.Context, updates <-chan *config.ChangeSet, r *reloader) {
+	for cs := range updates {
+		r.apply(ctx, cs)
+	}
+}
+
+// apply swaps the changed egresses, detaches the tracepoints which
+// don't exist anymore and attaches the new ones then it stops the
+// removed egresses. the configuration is validated before the swap
+// and the invalid one is ignored.
+func (r *reloader) apply(ctx context.Context, cs *config.ChangeSet) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed || ctx.Err() != nil {
+		return
+	}
+
+	conf := cs.Config
+
+	if err := validate(conf); err != nil {
+		r.logger.Error("agent", zap.String("msg", "reloaded config has been rejected"), zap.Error(err))
+		return
+	}
+
+	r.sk.Lock()
+	skipped := len(r.sk.tps)
+	r.sk.Unlock()
+
+	if skipped > 0 {
+		r.logger.Warn("agent", zap.String("msg", "reload isn't supported while some tracepoints are skipped"))
+		return
+	}
+
+	for _, name := range cs.Egress.Changed {
+		p, ok := r.pipelines[name]
+		if !ok {
+			continue
+		}
+
+		if err := p.router.swap(ctx, conf.Egress[name]); err != nil {
+			r.logger.Error("egress", zap.String("msg", name+" swap has been failed"), zap.Error(err))
+		}
+	}
+
+	var (
+		kept  = map[*attachment]bool{}
+		added []config.Tracepoint
+	)
+
+	// the identical tracepoints have the same key thus each
+	// of them keeps one of the attachments with that key.
+	for _, tp := range conf.Tracepoints {
+		if a := r.unkept(tracepointKey(conf, tp), kept); a != nil {
+			kept[a] = true
+			continue
+		}
+
+		if err := r.startEgress(ctx, conf, tp); err != nil {
+			continue
+		}
+
+		added = append(added, tp)
+	}
+
+	for a := range r.attached {
+		if !kept[a] {
+			r.detach(a)
+		}
+	}
+
+	if len(added) > 0 {
+		r.attach(ctx, conf, added)
+	}
+
+	// the tracepoints of the removed egresses have been detached
+	for _, name := range cs.Egress.Removed {
+		r.stopEgress(name)
+	}
+}
+
+// unkept returns an attachment of the key which hasn't been kept yet
+func (r *reloader) unkept(key string, kept map[*attachment]bool) *attachment {
+	for a := range r.attached {
+		if a.key == key && !kept[a] {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// startEgress starts the egress of the tracepoint if it isn't running
+func (r *reloader) startEgress(ctx context.Context, conf *config.Config, tp config.Tracepoint) error {
+	if _, ok := r.pipelines[tp.Egress]; ok {
+		return nil
+	}
+
+	p, err := r.newPipeline(ctx, conf, tp)
+	if err = r.report("egress", tp.Egress, err); err != nil {
+		r.logger.Error("egress", zap.String("msg", tp.Egress+" has been failed"), zap.Error(err))
+		return err
+	}
+
+	r.pipelines[tp.Egress] = p
+	r.logger.Info("egress", zap.String("msg", tp.Egress+" has been started"), zap.String("type", conf.Egress[tp.Egress].Type))
+
+	return nil
+}
+
+// stopEgress stops the egress and its stages
+func (r *reloader) stopEgress(name string) {
+	p, ok := r.pipelines[name]
+	if !ok {
+		return
+	}
+
+	p.cancel()
+	delete(r.pipelines, name)
+
+	r.stop("egress", name)
+	r.logger.Info("egress", zap.String("msg", name+" has been stopped"))
+}
+
+// attach loads a new program for the tracepoints and attaches them
+func (r *reloader) attach(ctx context.Context, conf *config.Config, tps []config.Tracepoint) {
+	sub := *conf
+	sub.Tracepoints = tps
+
+	t, err := r.newTracer(&sub)
+	if err != nil {
+		for _, tp := range tps {
+			r.report("tracepoint", tp.Name, err)
+		}
+		r.logger.Error("agent", zap.String("msg", "reloaded tracepoints program has been failed"), zap.Error(err))
+		return
+	}
+
+	p := &program{tracer: t, cfg: &sub, r: r}
+	r.programs = append(r.programs, p)
+
+	ctx = sub.WithContext(ctx)
+
+	for index, tracepoint := range tps {
+		tp := ebpf.TP{
+			Name:    tracepoint.Name,
+			Index:   index,
+			BufPool: r.bufPool,
+			OutChan: r.pipelines[tracepoint.Egress].in,
+			INet:    tracepoint.INet,
+			Workers: tracepoint.Workers,
+			Fields:  sub.GetTPFields(tracepoint.Fields),
+
+			Aggregate: tracepoint.Aggregate,
+			Custom:    tracepoint.Custom,
+		}
+
+		a, err := p.start(ctx, tp)
+		if err != nil {
+			r.report("tracepoint", tp.Name, err)
+			r.logger.Error("tracepoint", zap.String("msg", tp.Name+" has been failed"), zap.Error(err))
+			continue
+		}
+
+		p.refs++
+		a.key = tracepointKey(&sub, tracepoint)
+		r.attached[a] = struct{}{}
+		r.report("tracepoint", tp.Name, nil)
+		r.logger.Info("tracepoint", zap.String("msg", tp.Name+" has been attached"))
+	}
+
+	if p.refs < 1 {
+		r.closeProgram(p)
+	}
+}
+
+// detach stops the tracepoint workers, its program is closed if it
+// has no other attached tracepoint. the kernel tracepoint stays
+// attached until then thus its samples are drained by the program
+// and closing the program doesn't block on its perf maps.
+func (r *reloader) detach(a *attachment) {
+	a.cancel()
+	delete(r.attached, a)
+
+	r.stop("tracepoint", a.name)
+	r.logger.Info("tracepoint", zap.String("msg", a.name+" has been detached"))
+
+	if a.program.refs--; a.program.refs < 1 {
+		r.closeProgram(a.program)
+	}
+}
+
+func (r *reloader) closeProgram(p *program) {
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	p.tracer.Close()
+}
+
+// close closes the programs, the reloads are ignored afterwards
+func (r *reloader) close() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.closed = true
+
+	for _, p := range r.programs {
+		r.closeProgram(p)
+	}
+}
@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/priority"
+)
+
+// egressSwapTimeout is the max time the old egress has to send
+// its in-flight events before its context is canceled anyway.
+var egressSwapTimeout = 30 * time.Second
+
+// egressSwapCheck is the interval of the old egress in-flight check
+const egressSwapCheck = 10 * time.Millisecond
+
+// laneEgress are the egress types which receive the high
+// priority lane records themselves.
+var laneEgress = map[string]bool{
+	"kafka":     true,
+	"grpc-pb":   true,
+	"grpc-spb":  true,
+	"grpc-cbor": true,
+}
+
+type startFunc func(context.Context, config.Tracepoint, *sync.Pool, chan *bytes.Buffer) error
+
+// egressRouter routes the events of an egress to its current
+// instance, the egress can be swapped at runtime while the
+// tracepoints keep sending to the same channel.
+type egressRouter struct {
+	cfg     *config.Config
+	tp      config.Tracepoint
+	bufPool *sync.Pool
+	in      chan *bytes.Buffer
+	start   startFunc
+	lane    *priority.Lane
+	logger  *zap.Logger
+
+	swapCh chan swapRequest
+
+	eCfg     config.EgressConfig
+	ch       chan *bytes.Buffer
+	cancel   context.CancelFunc
+	inflight *helper.Inflight
+}
+
+type swapRequest struct {
+	eCfg config.EgressConfig
+	err  chan error
+}
+
+func newEgressRouter(ctx context.Context, cfg *config.Config, tp config.Tracepoint, bufPool *sync.Pool, in chan *bytes.Buffer, start startFunc) (*egressRouter, error) {
+	r := &egressRouter{
+		cfg:     cfg,
+		tp:      tp,
+		bufPool: bufPool,
+		in:      in,
+		start:   start,
+		lane:    priority.FromContext(ctx),
+		logger:  cfg.Logger(),
+		swapCh:  make(chan swapRequest),
+		eCfg:    cfg.Egress[tp.Egress],
+	}
+
+	ch, cancel, inflight, err := r.startEgress(ctx, r.eCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r.ch, r.cancel, r.inflight = ch, cancel, inflight
+
+	go r.run(ctx)
+
+	return r, nil
+}
+
+// startEgress starts an egress instance with its own context, channel
+// and inflight counter based on the given egress configuration.
+func (r *egressRouter) startEgress(ctx context.Context, eCfg config.EgressConfig) (chan *bytes.Buffer, context.CancelFunc, *helper.Inflight, error) {
+	cfg := *r.cfg
+	cfg.Egress = make(map[string]config.EgressConfig, len(r.cfg.Egress))
+	for name, e := range r.cfg.Egress {
+		cfg.Egress[name] = e
+	}
+	cfg.Egress[r.tp.Egress] = eCfg
+
+	inflight := &helper.Inflight{}
+	ctx, cancel := context.WithCancel(helper.WithInflight(cfg.WithContext(ctx), inflight))
+	ch := make(chan *bytes.Buffer, 1000)
+
+	if err := r.start(ctx, r.tp, r.bufPool, ch); err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+
+	return ch, cancel, inflight, nil
+}
+
+// laneC returns the high priority lane if the current egress
+// doesn't receive it, the router forwards its records then.
+func (r *egressRouter) laneC() chan interface{} {
+	if laneEgress[r.eCfg.Type] {
+		return nil
+	}
+
+	return r.lane.C()
+}
+
+// run forwards the events to the current egress, an event is counted
+// as in-flight before it's handed to the egress thus the old egress
+// of a swap isn't canceled while one of its workers is holding it.
+func (r *egressRouter) run(ctx context.Context) {
+	for {
+		select {
+		case buf := <-r.laneC():
+			if !r.forward(ctx, buf.(*bytes.Buffer)) {
+				return
+			}
+		case buf := <-r.in:
+			if !r.forward(ctx, buf) {
+				return
+			}
+		case req := <-r.swapCh:
+			req.err <- r.replace(ctx, req.eCfg)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *egressRouter) forward(ctx context.Context, buf *bytes.Buffer) bool {
+	r.inflight.Add(1)
+
+	select {
+	case r.ch <- buf:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// replace starts the new egress and drains the buffered events of
+// the old one into it, the old one is closed once it has sent its
+// in-flight events. the tracepoints events are held in the input
+// channel meanwhile.
+func (r *egressRouter) replace(ctx context.Context, eCfg config.EgressConfig) error {
+	if reflect.DeepEqual(eCfg, r.eCfg) {
+		return nil
+	}
+
+	ch, cancel, inflight, err := r.startEgress(ctx, eCfg)
+	if err != nil {
+		return err
+	}
+
+	drained := 0
+	for done := false; !done; {
+		select {
+		case buf := <-r.ch:
+			r.inflight.Add(-1)
+			inflight.Add(1)
+			ch <- buf
+			drained++
+		default:
+			done = true
+		}
+	}
+
+	go r.retire(ctx, r.cancel, r.inflight)
+
+	r.eCfg, r.ch, r.cancel, r.inflight = eCfg, ch, cancel, inflight
+
+	r.logger.Info("egress", zap.String("msg", r.tp.Egress+" has been swapped"),
+		zap.String("type", eCfg.Type), zap.Int("drained", drained))
+
+	return nil
+}
+
+// retire cancels the old egress once it doesn't have any in-flight
+// event, e.g. the grpc events which are held while it's reconnecting.
+// it's canceled after the egressSwapTimeout anyway.
+func (r *egressRouter) retire(ctx context.Context, cancel context.CancelFunc, inflight *helper.Inflight) {
+	defer cancel()
+
+	ticker := time.NewTicker(egressSwapCheck)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(egressSwapTimeout)
+	defer timer.Stop()
+
+	for inflight.Len() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			r.logger.Warn("egress", zap.String("msg", r.tp.Egress+" has been canceled with in-flight events"),
+				zap.Int("inflight", inflight.Len()))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// swap replaces the egress if its configuration has been changed.
+func (r *egressRouter) swap(ctx context.Context, eCfg config.EgressConfig) error {
+	req := swapRequest{eCfg: eCfg, err: make(chan error, 1)}
+
+	select {
+	case r.swapCh <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return <-req.err
+}
+
+// watchEgress swaps the egresses once their configuration
+// is updated, the tracepoints are left untouched.
+func watchEgress(ctx context.Context, updates <-chan map[string]config.EgressConfig, routers func() map[string]*egressRouter, logger *zap.Logger) {
+	for {
+		select {
+		case egresses := <-updates:
+			for name, r := range routers() {
+				eCfg, ok := egresses[name]
+				if !ok {
+					continue
+				}
+
+				if err := r.swap(ctx, eCfg); err != nil {
+					logger.Error("egress", zap.String("msg", name+" swap has been failed"), zap.Error(err))
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/priority"
+)
+
+// fakeEgress records the received events per broker
+type fakeEgress struct {
+	sync.Mutex
+	events map[string][]int
+}
+
+func (f *fakeEgress) start(ctx context.Context, tp config.Tracepoint, bufPool *sync.Pool, ch chan *bytes.Buffer) error {
+	broker := config.FromContext(ctx).Egress[tp.Egress].Config["brokers"].(string)
+
+	go func() {
+		for {
+			select {
+			case buf := <-ch:
+				n, _ := strconv.Atoi(buf.String())
+				f.Lock()
+				f.events[broker] = append(f.events[broker], n)
+				f.Unlock()
+				// slow consumer to keep the channel buffered
+				time.Sleep(10 * time.Microsecond)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (f *fakeEgress) len() int {
+	f.Lock()
+	defer f.Unlock()
+
+	n := 0
+	for _, e := range f.events {
+		n += len(e)
+	}
+
+	return n
+}
+
+func TestEgressRouterSwap(t *testing.T) {
+	eCfg := func(broker string) config.EgressConfig {
+		return config.EgressConfig{Type: "kafka", Config: map[string]interface{}{"brokers": broker}}
+	}
+
+	cfg := &config.Config{
+		Egress: map[string]config.EgressConfig{"kafka": eCfg("broker1")},
+	}
+	cfg.SetDefault()
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	fe := &fakeEgress{events: map[string][]int{}}
+	bufPool := &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	in := make(chan *bytes.Buffer, 1000)
+
+	r, err := newEgressRouter(ctx, cfg, config.Tracepoint{Egress: "kafka"}, bufPool, in, fe.start)
+	assert.NoError(t, err)
+
+	updates := make(chan map[string]config.EgressConfig)
+	routers := func() map[string]*egressRouter {
+		return map[string]*egressRouter{"kafka": r}
+	}
+	go watchEgress(ctx, updates, routers, cfg.Logger())
+
+	total := 3000
+	go func() {
+		for i := 0; i < total; i++ {
+			in <- bytes.NewBufferString(strconv.Itoa(i))
+			if i == total/2 {
+				updates <- map[string]config.EgressConfig{"kafka": eCfg("broker2")}
+			}
+		}
+	}()
+
+	assert.Eventually(t, func() bool { return fe.len() == total }, 5*time.Second, 10*time.Millisecond)
+
+	// no gap and no duplicate
+	seen := map[int]bool{}
+	fe.Lock()
+	for _, events := range fe.events {
+		for _, n := range events {
+			assert.False(t, seen[n], n)
+			seen[n] = true
+		}
+	}
+	assert.Len(t, seen, total)
+	assert.NotEmpty(t, fe.events["broker1"])
+	assert.NotEmpty(t, fe.events["broker2"])
+	fe.Unlock()
+
+	// unchanged configuration
+	assert.NoError(t, r.swap(ctx, eCfg("broker2")))
+	assert.Equal(t, eCfg("broker2"), r.eCfg)
+}
+
+func TestEgressRouterInflight(t *testing.T) {
+	cfg := &config.Config{
+		Egress: map[string]config.EgressConfig{"grpc": {Type: "grpc-pb", Config: map[string]interface{}{"server": "s1"}}},
+	}
+	cfg.SetDefault()
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	var (
+		taken    = make(chan struct{})
+		release  = make(chan struct{})
+		sent     = make(chan string, 1)
+		canceled = make(chan struct{})
+		started  = 0
+	)
+
+	// the first egress holds an event across the swap until it's
+	// released, the event has been counted by the router already.
+	start := func(ctx context.Context, _ config.Tracepoint, _ *sync.Pool, ch chan *bytes.Buffer) error {
+		if started++; started > 1 {
+			return nil
+		}
+
+		inflight := helper.InflightFrom(ctx)
+		go func() {
+			buf := <-ch
+			close(taken)
+			<-release
+			sent <- buf.String()
+			inflight.Add(-1)
+		}()
+
+		go func() {
+			<-ctx.Done()
+			close(canceled)
+		}()
+
+		return nil
+	}
+
+	in := make(chan *bytes.Buffer, 1)
+	r, err := newEgressRouter(ctx, cfg, config.Tracepoint{Egress: "grpc"}, nil, in, start)
+	assert.NoError(t, err)
+
+	in <- bytes.NewBufferString("held")
+
+	select {
+	case <-taken:
+	case <-time.After(time.Second):
+		t.Fatal("event hasn't been taken")
+	}
+
+	assert.Equal(t, 1, r.inflight.Len())
+	assert.NoError(t, r.swap(ctx, config.EgressConfig{Type: "grpc-pb", Config: map[string]interface{}{"server": "s2"}}))
+
+	// the old egress isn't canceled while it has an in-flight event
+	select {
+	case <-canceled:
+		t.Fatal("egress has been canceled with in-flight event")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("egress has not been canceled")
+	}
+
+	assert.Equal(t, "held", <-sent)
+}
+
+func TestEgressRouterLane(t *testing.T) {
+	cfg := &config.Config{
+		Egress: map[string]config.EgressConfig{"console": {Type: "console"}},
+	}
+	cfg.SetDefault()
+
+	lane := priority.NewLane(priority.Reserved)
+	ctx, cancel := context.WithCancel(priority.WithLane(cfg.WithContext(context.Background()), lane))
+	defer cancel()
+
+	out := make(chan *bytes.Buffer, 1)
+	start := func(_ context.Context, _ config.Tracepoint, _ *sync.Pool, ch chan *bytes.Buffer) error {
+		go func() { out <- <-ch }()
+		return nil
+	}
+
+	// the egress doesn't receive the lane, the router forwards it
+	_, err := newEgressRouter(ctx, cfg, config.Tracepoint{Egress: "console"}, nil, make(chan *bytes.Buffer), start)
+	assert.NoError(t, err)
+
+	assert.True(t, lane.SendBuffer(ctx, nil, bytes.NewBufferString("synthetic"), priority.High))
+
+	select {
+	case buf := <-out:
+		assert.Equal(t, "synthetic", buf.String())
+	case <-time.After(time.Second):
+		t.Fatal("lane record hasn't been forwarded")
+	}
+}
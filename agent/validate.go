@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/ebpf"
+	"github.com/mehrdadrad/tcpdog/recordid"
+)
+
+func validate(cfg *config.Config) error {
+	for i, tp := range cfg.Tracepoints {
+		if tp.Priority != "" && tp.Priority != "normal" && tp.Priority != "critical" {
+			return fmt.Errorf("wrong priority (%s) priority:%s", tp.Name, tp.Priority)
+		}
+
+		if tp.ScanExisting != nil && (tp.Custom != nil || tp.Aggregate != nil) {
+			return fmt.Errorf("scanExisting doesn't support custom and aggregate-in-kernel (%s)", tp.Name)
+		}
+
+		// the rate is the number of the records per second
+		if tp.ScanExisting != nil && tp.ScanExisting.Rate > int(time.Second) {
+			return fmt.Errorf("wrong scanExisting rate (%s) rate:%d", tp.Name, tp.ScanExisting.Rate)
+		}
+
+		if tp.Custom != nil {
+			if err := validateCustom(cfg, i); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// fields validation
+		var err error
+		if tp.Aggregate != nil {
+			err = validateAggregate(cfg, i)
+		} else {
+			err = validateFields(cfg, tp.Fields)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, f := range cfg.Fields[tp.Fields] {
+			if err := ebpf.ValidateAvailability(tp.Name, f.Name); err != nil {
+				return err
+			}
+		}
+
+		// tcpstatus validation
+		s, err := ebpf.ValidateTCPStatus(tp.TCPState)
+		if err != nil {
+			return err
+		}
+		cfg.Tracepoints[i].TCPState = s
+
+		// tracepoint
+		err = ebpf.ValidateTracepoint(tp.Name)
+		if err != nil {
+			return err
+		}
+
+		// egress, inet and sample
+		err = validateMix(cfg, tp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.OnTracepointError != "fail" && cfg.OnTracepointError != "skip" {
+		return fmt.Errorf("wrong onTracepointError:%s", cfg.OnTracepointError)
+	}
+
+	for v := range cfg.DSCPNames {
+		if v > 63 {
+			return fmt.Errorf("wrong dscp value:%d", v)
+		}
+	}
+
+	if _, err := recordid.New(cfg.RecordID); err != nil {
+		return err
+	}
+
+	if cfg.Dedup.Enable && (cfg.Dedup.FPR <= 0 || cfg.Dedup.FPR >= 1) {
+		return fmt.Errorf("wrong dedup fpr:%g", cfg.Dedup.FPR)
+	}
+
+	return nil
+}
+
+func validateFields(cfg *config.Config, name string) error {
+	if _, ok := cfg.Fields[name]; !ok {
+		return fmt.Errorf("%s not exist", name)
+	}
+
+	for i, f := range cfg.Fields[name] {
+		cf, err := ebpf.ValidateField(f.Name)
+		if err != nil {
+			return err
+		}
+
+		if ebpf.IsUserSpace(cf) && (f.Filter != "" || f.Math != "") {
+			return fmt.Errorf("%s doesn't support filter and math", cf)
+		}
+
+		cfg.Fields[name][i].Name = cf
+		cfg.Fields[name][i].Filter = strings.Replace(f.Filter, f.Name, cf, -1)
+	}
+	return nil
+}
+
+// validateAggregate validates the in-kernel aggregation and
+// registers the summary records fields for the egress.
+func validateAggregate(cfg *config.Config, index int) error {
+	tp := cfg.Tracepoints[index]
+
+	if tp.Fields != "" {
+		return fmt.Errorf("fields and aggregate-in-kernel are exclusive (%s)", tp.Name)
+	}
+
+	if tp.Sample != 0 {
+		return fmt.Errorf("sample and aggregate-in-kernel are exclusive (%s)", tp.Name)
+	}
+
+	// the summary fields (e.g. Count, SumRTT and Window) are built from
+	// the metrics config at runtime and the pb Fields message is a fixed
+	// schema which can't carry them, the pb marshaler drops the unknown
+	// fields silently. the spb (struct) carries any field thus it's the
+	// protobuf serialization of the summary records.
+	eCfg := cfg.Egress[tp.Egress]
+	if ser, _ := eCfg.Config["serialization"].(string); ser == "pb" || eCfg.Type == "grpc-pb" || eCfg.Type == "grpc-cbor" {
+		return fmt.Errorf("aggregate-in-kernel doesn't support pb serialization, the summary fields aren't in the pb schema, use spb (%s)", tp.Name)
+	}
+
+	fields, err := ebpf.ValidateAggregate(tp.Aggregate)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("aggregate%d", index)
+	if cfg.Fields == nil {
+		cfg.Fields = map[string][]config.Field{}
+	}
+
+	cfg.Fields[name] = []config.Field{}
+	for _, f := range fields {
+		cfg.Fields[name] = append(cfg.Fields[name], config.Field{Name: f})
+	}
+	cfg.Tracepoints[index].Fields = name
+
+	return nil
+}
+
+// validateCustom validates the custom program and registers
+// its schema fields for the egress.
+func validateCustom(cfg *config.Config, index int) error {
+	tp := cfg.Tracepoints[index]
+
+	if tp.Fields != "" || tp.Aggregate != nil || tp.Sample != 0 {
+		return fmt.Errorf("custom doesn't support fields, aggregate-in-kernel and sample (%s)", tp.Name)
+	}
+
+	if tp.PID != 0 || len(tp.PIDs) > 0 || tp.Comm != "" {
+		return fmt.Errorf("custom doesn't support pid and comm filters (%s)", tp.Name)
+	}
+
+	if len(tp.DPort) > 0 || len(tp.SPort) > 0 {
+		return fmt.Errorf("custom doesn't support dport and sport filters (%s)", tp.Name)
+	}
+
+	eCfg, ok := cfg.Egress[tp.Egress]
+	if !ok {
+		return fmt.Errorf("egress not found: %s", tp.Egress)
+	}
+
+	if ser, _ := eCfg.Config["serialization"].(string); ser == "pb" || eCfg.Type == "grpc-pb" || eCfg.Type == "grpc-cbor" {
+		return fmt.Errorf("custom doesn't support pb serialization (%s)", tp.Name)
+	}
+
+	if err := ebpf.ValidateCustom(tp.Custom); err != nil {
+		return fmt.Errorf("%v (%s)", err, tp.Name)
+	}
+
+	name := fmt.Sprintf("custom%d", index)
+	if cfg.Fields == nil {
+		cfg.Fields = map[string][]config.Field{}
+	}
+
+	cfg.Fields[name] = []config.Field{}
+	for _, f := range tp.Custom.Schema {
+		cfg.Fields[name] = append(cfg.Fields[name], config.Field{Name: f.Name})
+	}
+	cfg.Tracepoints[index].Fields = name
+
+	return nil
+}
+
+func validateMix(cfg *config.Config, tp config.Tracepoint) error {
+	if _, ok := cfg.Egress[tp.Egress]; !ok {
+		return fmt.Errorf("egress not found: %s", tp.Egress)
+	}
+
+	v6 := false
+	for _, inet := range tp.INet {
+		if inet != 4 && inet != 6 {
+			return fmt.Errorf("wrong inet version (%s) inet:%d", tp.Name, inet)
+		}
+		v6 = v6 || inet == 6
+	}
+
+	for _, f := range cfg.Fields[tp.Fields] {
+		if !v6 && ebpf.IsV6Only(f.Name) {
+			return fmt.Errorf("%s requires inet 6 (%s)", f.Name, tp.Name)
+		}
+	}
+
+	if tp.Sample < 0 {
+		return fmt.Errorf("negative sample (%s) sample:%d", tp.Name, tp.Sample)
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+// Package checkpoint saves and restores the state of the stateful
+// processors, the state is written to a local file and/or replicated
+// to a peer server (warm standby) periodically and it's restored at
+// startup if it's fresh. the processors which don't implement the
+// Stateful interface aren't checkpointed.
+package checkpoint
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+// peerTimeout is the peer timeout of the restore and the
+// last checkpoint at shutdown.
+var peerTimeout = 2 * time.Second
+
+// Stateful is implemented by the processors which keep state
+type Stateful interface {
+	// Snapshot serializes the state
+	Snapshot() ([]byte, error)
+	// Restore replaces the state with the serialized state
+	Restore([]byte) error
+}
+
+// Checkpointer checkpoints the state of a processor, the
+// methods of a nil checkpointer do nothing.
+type Checkpointer struct {
+	name   string
+	cfg    config.Standby
+	peer   *Peer
+	logger *zap.Logger
+
+	ticker *time.Ticker
+	ch     chan []byte
+
+	mu      sync.Mutex
+	written time.Time
+}
+
+type ctxKey struct{}
+
+// New constructs a new checkpointer, the name identifies the
+// state e.g. the flow ingress and processor names.
+func New(ctx context.Context, name string, cfg config.Standby, peer *Peer, logger *zap.Logger) *Checkpointer {
+	c := &Checkpointer{
+		name:   name,
+		cfg:    cfg,
+		peer:   peer,
+		logger: logger,
+		ticker: time.NewTicker(cfg.Interval),
+		ch:     make(chan []byte, 1),
+	}
+
+	go c.run(ctx)
+
+	return c
+}
+
+// WithContext returns a copy of the context with the checkpointer
+func WithContext(ctx context.Context, c *Checkpointer) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the checkpointer of the context, it's nil if there isn't
+func FromContext(ctx context.Context) *Checkpointer {
+	c, _ := ctx.Value(ctxKey{}).(*Checkpointer)
+	return c
+}
+
+// C returns the checkpoint interval channel, it's nil for
+// a nil checkpointer.
+func (c *Checkpointer) C() <-chan time.Time {
+	if c == nil {
+		return nil
+	}
+
+	return c.ticker.C
+}
+
+// Restore restores the freshest state of the local file and the
+// peer, a stale or corrupt state is discarded.
+func (c *Checkpointer) Restore(ctx context.Context, s Stateful) {
+	if c == nil {
+		return
+	}
+
+	var (
+		data []byte
+		ts   time.Time
+	)
+
+	for _, src := range []struct {
+		name string
+		load func(context.Context) ([]byte, error)
+	}{{"file", c.loadFile}, {"peer", c.loadPeer}} {
+		b, err := src.load(ctx)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				c.logger.Warn("checkpoint", zap.String("name", c.name), zap.String("source", src.name), zap.Error(err))
+			}
+			continue
+		}
+		if b == nil {
+			continue
+		}
+
+		d, t, err := decode(b)
+		if err != nil {
+			c.logger.Warn("checkpoint", zap.String("name", c.name), zap.String("source", src.name),
+				zap.String("msg", "corrupt state has been discarded"), zap.Error(err))
+			continue
+		}
+
+		if t.After(ts) {
+			data, ts = d, t
+		}
+	}
+
+	if data == nil {
+		return
+	}
+
+	age := time.Since(ts)
+	if age > c.cfg.MaxAge {
+		c.logger.Info("checkpoint", zap.String("name", c.name), zap.String("msg", "stale state has been discarded"),
+			zap.Duration("age", age))
+		return
+	}
+
+	if err := s.Restore(data); err != nil {
+		c.logger.Warn("checkpoint", zap.String("name", c.name), zap.String("msg", "state has been discarded"), zap.Error(err))
+		return
+	}
+
+	metrics.CheckpointAge(c.name)(age.Seconds())
+
+	c.logger.Info("checkpoint", zap.String("name", c.name), zap.String("msg", "state has been restored"),
+		zap.Duration("age", age))
+}
+
+// Save takes a snapshot and writes it in the background, it
+// should be called by the processor goroutine which owns the state.
+func (c *Checkpointer) Save(s Stateful) {
+	if c == nil {
+		return
+	}
+
+	b, err := c.snapshot(s)
+	if err != nil {
+		c.logger.Warn("checkpoint", zap.String("name", c.name), zap.Error(err))
+		return
+	}
+
+	// the latest snapshot replaces the pending one
+	for {
+		select {
+		case c.ch <- b:
+			return
+		default:
+		}
+
+		select {
+		case <-c.ch:
+		default:
+		}
+	}
+}
+
+// Flush takes a snapshot and writes it right away, it's
+// called once the processor is stopped.
+func (c *Checkpointer) Flush(s Stateful) {
+	if c == nil {
+		return
+	}
+
+	b, err := c.snapshot(s)
+	if err != nil {
+		c.logger.Warn("checkpoint", zap.String("name", c.name), zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), peerTimeout)
+	defer cancel()
+
+	c.write(ctx, b)
+}
+
+func (c *Checkpointer) snapshot(s Stateful) ([]byte, error) {
+	data, err := s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(data, time.Now()), nil
+}
+
+func (c *Checkpointer) run(ctx context.Context) {
+	defer c.ticker.Stop()
+
+	for {
+		select {
+		case b := <-c.ch:
+			c.write(ctx, b)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// write writes the checkpoint unless a newer one has been written,
+// the pending background write may race with the last checkpoint.
+func (c *Checkpointer) write(ctx context.Context, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ts, _ := decode(b)
+	if !ts.After(c.written) {
+		return
+	}
+	c.written = ts
+
+	if c.cfg.Dir != "" {
+		if err := writeFile(c.path(), b); err != nil {
+			c.logger.Warn("checkpoint", zap.String("name", c.name), zap.Error(err))
+		}
+	}
+
+	if c.peer != nil {
+		if err := c.peer.put(ctx, c.name, b); err != nil {
+			c.logger.Warn("checkpoint", zap.String("name", c.name), zap.String("peer", c.cfg.Peer), zap.Error(err))
+		}
+	}
+}
+
+func (c *Checkpointer) path() string {
+	return filepath.Join(c.cfg.Dir, url.PathEscape(c.name)+".state")
+}
+
+func (c *Checkpointer) loadFile(_ context.Context) ([]byte, error) {
+	if c.cfg.Dir == "" {
+		return nil, nil
+	}
+
+	return ioutil.ReadFile(c.path())
+}
+
+func (c *Checkpointer) loadPeer(ctx context.Context) ([]byte, error) {
+	if c.peer == nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, peerTimeout)
+	defer cancel()
+
+	return c.peer.get(ctx, c.name)
+}
+
+// writeFile replaces the file atomically
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,201 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+type counter struct {
+	n   string
+	err error
+}
+
+func (c *counter) Snapshot() ([]byte, error) { return []byte(c.n), c.err }
+
+func (c *counter) Restore(b []byte) error {
+	c.n = string(b)
+	return nil
+}
+
+// peerServer is the state service of the admin server
+type peerServer struct {
+	pb.UnimplementedAdminServer
+	store *Store
+}
+
+func (s *peerServer) PutState(_ context.Context, req *pb.State) (*pb.Response, error) {
+	return &pb.Response{}, s.store.Put(req.GetName(), req.GetData())
+}
+
+func (s *peerServer) GetState(_ context.Context, req *pb.StateRequest) (*pb.State, error) {
+	b, ok := s.store.Get(req.GetName())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return &pb.State{Data: b}, nil
+}
+
+func testPeer(t *testing.T, store *Store) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := grpc.NewServer()
+	pb.RegisterAdminServer(s, &peerServer{store: store})
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	return l.Addr().String()
+}
+
+func TestEncode(t *testing.T) {
+	now := time.Now()
+	b := encode([]byte("state"), now)
+
+	data, ts, err := decode(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "state", string(data))
+	assert.Equal(t, now.UnixNano(), ts.UnixNano())
+
+	b[len(b)-1] = 'x'
+	_, _, err = decode(b)
+	assert.Error(t, err)
+
+	_, _, err = decode(b[:len(b)-1])
+	assert.Error(t, err)
+
+	_, _, err = decode([]byte("TDCP"))
+	assert.Error(t, err)
+}
+
+func TestCheckpointFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.Standby{Dir: t.TempDir(), Interval: time.Hour, MaxAge: time.Minute}
+
+	c := New(ctx, "grpc/anomaly", cfg, nil, zap.NewNop())
+	c.Flush(&counter{n: "5"})
+
+	s := &counter{}
+	New(ctx, "grpc/anomaly", cfg, nil, zap.NewNop()).Restore(ctx, s)
+	assert.Equal(t, "5", s.n)
+
+	// the restored state age
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Regexp(t, `tcpdog_checkpoint_restored_age_seconds{name="grpc/anomaly"} \d`, rec.Body.String())
+
+	// the background write
+	c.Save(&counter{n: "6"})
+	for i := 0; i < 100 && s.n != "6"; i++ {
+		time.Sleep(10 * time.Millisecond)
+		c.Restore(ctx, s)
+	}
+	assert.Equal(t, "6", s.n)
+
+	// an older snapshot doesn't replace the newer one
+	c.write(ctx, encode([]byte("4"), time.Now().Add(-time.Second)))
+	c.Restore(ctx, s)
+	assert.Equal(t, "6", s.n)
+
+	// snapshot error
+	c.Flush(&counter{n: "7", err: errors.New("foo")})
+	c.Restore(ctx, s)
+	assert.Equal(t, "6", s.n)
+}
+
+func TestCheckpointDiscard(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Standby{Dir: t.TempDir(), Interval: time.Hour, MaxAge: time.Minute}
+	c := New(ctx, "grpc/anomaly", cfg, nil, zap.NewNop())
+
+	// stale
+	c.write(ctx, encode([]byte("5"), time.Now().Add(-time.Hour)))
+	s := &counter{}
+	c.Restore(ctx, s)
+	assert.Equal(t, "", s.n)
+
+	// corrupt
+	b := encode([]byte("5"), time.Now())
+	b[len(b)-1] = '6'
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(cfg.Dir, "grpc%2Fanomaly.state"), b, 0600))
+	c.Restore(ctx, s)
+	assert.Equal(t, "", s.n)
+
+	// nil checkpointer
+	var nc *Checkpointer
+	assert.Nil(t, nc.C())
+	nc.Save(s)
+	nc.Flush(s)
+	nc.Restore(ctx, s)
+	assert.Nil(t, FromContext(ctx))
+	assert.Equal(t, c, FromContext(WithContext(ctx, c)))
+}
+
+func TestCheckpointPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore()
+	cfg := config.Standby{Peer: testPeer(t, store), Interval: time.Hour, MaxAge: time.Minute}
+
+	peer, err := Dial(cfg)
+	assert.NoError(t, err)
+	defer peer.Close()
+
+	s := &counter{}
+	c := New(ctx, "grpc/anomaly", cfg, peer, zap.NewNop())
+
+	// the peer doesn't have the state
+	c.Restore(ctx, s)
+	assert.Equal(t, "", s.n)
+
+	c.Flush(&counter{n: "5"})
+	_, ok := store.Get("grpc/anomaly")
+	assert.True(t, ok)
+
+	// the restarted server restores from the peer
+	New(ctx, "grpc/anomaly", cfg, peer, zap.NewNop()).Restore(ctx, s)
+	assert.Equal(t, "5", s.n)
+
+	// the freshest state of the file and the peer
+	cfg.Dir = t.TempDir()
+	c = New(ctx, "grpc/anomaly", cfg, nil, zap.NewNop())
+	c.Flush(&counter{n: "4"})
+	c = New(ctx, "grpc/anomaly", cfg, peer, zap.NewNop())
+	c.Restore(ctx, s)
+	assert.Equal(t, "4", s.n)
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	now := time.Now()
+
+	assert.NoError(t, s.Put("a", encode([]byte("2"), now)))
+	assert.Error(t, s.Put("a", encode([]byte("1"), now.Add(-time.Second))))
+	assert.Error(t, s.Put("a", []byte("foo")))
+
+	b, ok := s.Get("a")
+	assert.True(t, ok)
+	data, _, _ := decode(b)
+	assert.Equal(t, "2", string(data))
+
+	_, ok = s.Get("b")
+	assert.False(t, ok)
+}
@@ -0,0 +1,79 @@
+package checkpoint
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// tokenKey is the metadata key of the peer admin token (admin.TokenKey)
+const tokenKey = "tcpdog-token"
+
+// Peer represents the admin server of the standby peer
+type Peer struct {
+	conn   *grpc.ClientConn
+	client pb.AdminClient
+	token  string
+}
+
+// Dial connects to the peer admin server, the connection is
+// established in the background.
+func Dial(cfg config.Standby) (*Peer, error) {
+	var opts []grpc.DialOption
+
+	if cfg.TLSConfig != nil && cfg.TLSConfig.Enable {
+		creds, err := config.GetCreds(cfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	conn, err := grpc.Dial(cfg.Peer, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Peer{conn: conn, client: pb.NewAdminClient(conn), token: cfg.Token}, nil
+}
+
+// Close closes the peer connection
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+func (p *Peer) context(ctx context.Context) context.Context {
+	if p.token == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, tokenKey, p.token)
+}
+
+func (p *Peer) put(ctx context.Context, name string, b []byte) error {
+	_, err := p.client.PutState(p.context(ctx), &pb.State{Name: name, Data: b})
+	return err
+}
+
+// get returns the checkpoint of the peer, it's nil if the
+// peer doesn't have the checkpoint.
+func (p *Peer) get(ctx context.Context, name string) ([]byte, error) {
+	s, err := p.client.GetState(p.context(ctx), &pb.StateRequest{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetData(), nil
+}
@@ -0,0 +1,94 @@
+package checkpoint
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+// the checkpoint layout: magic, version, unix nano timestamp,
+// crc32 (castagnoli) and length of the state then the state.
+const (
+	magic      = "TDCP"
+	version    = 1
+	headerSize = len(magic) + 1 + 8 + 4 + 4
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func encode(data []byte, ts time.Time) []byte {
+	b := make([]byte, headerSize, headerSize+len(data))
+	copy(b, magic)
+	b[4] = version
+	binary.BigEndian.PutUint64(b[5:], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(b[13:], crc32.Checksum(data, crcTable))
+	binary.BigEndian.PutUint32(b[17:], uint32(len(data)))
+
+	return append(b, data...)
+}
+
+func decode(b []byte) ([]byte, time.Time, error) {
+	if len(b) < headerSize || string(b[:4]) != magic {
+		return nil, time.Time{}, errors.New("invalid checkpoint header")
+	}
+
+	if b[4] != version {
+		return nil, time.Time{}, errors.New("unsupported checkpoint version")
+	}
+
+	data := b[headerSize:]
+	if uint32(len(data)) != binary.BigEndian.Uint32(b[17:]) {
+		return nil, time.Time{}, errors.New("truncated checkpoint")
+	}
+
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(b[13:]) {
+		return nil, time.Time{}, errors.New("checkpoint checksum mismatch")
+	}
+
+	return data, time.Unix(0, int64(binary.BigEndian.Uint64(b[5:]))), nil
+}
+
+// Store keeps the checkpoints which the peer servers replicate
+type Store struct {
+	sync.Mutex
+	states map[string][]byte
+}
+
+// NewStore constructs a new store
+func NewStore() *Store {
+	return &Store{states: map[string][]byte{}}
+}
+
+// Put stores the checkpoint, a corrupt checkpoint or an older
+// one than the stored checkpoint is rejected.
+func (s *Store) Put(name string, b []byte) error {
+	_, ts, err := decode(b)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if old, ok := s.states[name]; ok {
+		if _, oldTS, _ := decode(old); oldTS.After(ts) {
+			return errors.New("checkpoint is older than the stored one")
+		}
+	}
+
+	s.states[name] = append([]byte(nil), b...)
+
+	return nil
+}
+
+// Get returns the stored checkpoint
+func (s *Store) Get(name string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	b, ok := s.states[name]
+
+	return b, ok
+}
@@ -0,0 +1,174 @@
+// Package coerce converts the fields of the json records to their
+// canonical types of the fields registry (pb.Fields) e.g. the numbers
+// as strings of the older agents. the json numbers stay float64, which
+// the processors, the filters and the ingestions handle, but an integer
+// field is integral and in the range of its type. the unknown fields
+// pass through untouched.
+package coerce
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// the policies of a field which can't be coerced
+const (
+	// Keep keeps the field value as it is (default)
+	Keep = "keep"
+	// Null sets the field value to null
+	Null = "null"
+	// DropRecord drops the record
+	DropRecord = "drop-record"
+)
+
+// kinds maps the field names to their registry kinds
+var kinds = func() map[string]protoreflect.Kind {
+	m := map[string]protoreflect.Kind{}
+	fields := (&pb.Fields{}).ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		m[string(fields.Get(i).Name())] = fields.Get(i).Kind()
+	}
+
+	return m
+}()
+
+// Coercer coerces the records and counts the failures per field
+type Coercer struct {
+	policy   string
+	failures sync.Map
+	dropped  uint64
+}
+
+// New constructs a new coercer of the policy
+func New(policy string) (*Coercer, error) {
+	switch policy {
+	case "":
+		policy = Keep
+	case Keep, Null, DropRecord:
+	default:
+		return nil, fmt.Errorf("onCoerceError %s is not supported", policy)
+	}
+
+	return &Coercer{policy: policy}, nil
+}
+
+// Record coerces the known fields of the json record in place,
+// it returns false if the record should be dropped.
+func (c *Coercer) Record(m map[string]interface{}) bool {
+	for name, v := range m {
+		kind, ok := kinds[name]
+		if !ok || v == nil {
+			continue
+		}
+
+		cv, err := Value(kind, v)
+		if err == nil {
+			m[name] = cv
+			continue
+		}
+
+		c.fail(name)
+
+		switch c.policy {
+		case Null:
+			m[name] = nil
+		case DropRecord:
+			atomic.AddUint64(&c.dropped, 1)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *Coercer) fail(name string) {
+	v, ok := c.failures.Load(name)
+	if !ok {
+		v, _ = c.failures.LoadOrStore(name, new(uint64))
+	}
+
+	atomic.AddUint64(v.(*uint64), 1)
+}
+
+// Failures returns the failures of the fields
+func (c *Coercer) Failures() map[string]uint64 {
+	m := map[string]uint64{}
+	c.failures.Range(func(k, v interface{}) bool {
+		m[k.(string)] = atomic.LoadUint64(v.(*uint64))
+		return true
+	})
+
+	return m
+}
+
+// Dropped returns the number of the dropped records
+func (c *Coercer) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Value returns the canonical json value of the kind
+func Value(kind protoreflect.Kind, v interface{}) (interface{}, error) {
+	switch kind {
+	case protoreflect.Uint32Kind:
+		return integer(v, math.MaxUint32)
+	case protoreflect.Uint64Kind:
+		return integer(v, math.MaxUint64)
+	case protoreflect.StringKind:
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case protoreflect.BoolKind:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(v))
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		}
+	default:
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("invalid %s value: %v", kind, v)
+}
+
+// integer returns the unsigned integer as float64, a fraction is
+// truncated and a negative or an overflowed value is an error.
+func integer(v interface{}, max float64) (interface{}, error) {
+	var f float64
+
+	switch v := v.(type) {
+	case float64:
+		f = v
+	case string:
+		var err error
+		f, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %q", v)
+		}
+	default:
+		return nil, fmt.Errorf("invalid number: %v", v)
+	}
+
+	if math.IsNaN(f) || f < 0 || f > max {
+		return nil, fmt.Errorf("number %v is out of range", v)
+	}
+
+	return math.Trunc(f), nil
+}
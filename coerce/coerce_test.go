@@ -0,0 +1,99 @@
+package coerce
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New("")
+	assert.NoError(t, err)
+	assert.Equal(t, Keep, c.policy)
+
+	_, err = New("foo")
+	assert.EqualError(t, err, "onCoerceError foo is not supported")
+}
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		kind protoreflect.Kind
+		in   interface{}
+		out  interface{}
+		err  bool
+	}{
+		// string numbers
+		{protoreflect.Uint32Kind, "120", float64(120), false},
+		{protoreflect.Uint64Kind, " 5000 ", float64(5000), false},
+		{protoreflect.Uint32Kind, "1.5e3", float64(1500), false},
+		{protoreflect.Uint32Kind, "foo", nil, true},
+		// floats for integer fields
+		{protoreflect.Uint32Kind, 10.7, float64(10), false},
+		{protoreflect.Uint64Kind, float64(42), float64(42), false},
+		// overflow
+		{protoreflect.Uint32Kind, float64(math.MaxUint32) + 1, nil, true},
+		{protoreflect.Uint32Kind, "4294967296", nil, true},
+		{protoreflect.Uint32Kind, float64(math.MaxUint32), float64(math.MaxUint32), false},
+		{protoreflect.Uint64Kind, 1e20, nil, true},
+		{protoreflect.Uint64Kind, -1.0, nil, true},
+		{protoreflect.Uint64Kind, "NaN", nil, true},
+		{protoreflect.Uint32Kind, true, nil, true},
+		// strings and bools
+		{protoreflect.StringKind, float64(443), "443", false},
+		{protoreflect.StringKind, "10.0.0.1", "10.0.0.1", false},
+		{protoreflect.BoolKind, "true", true, false},
+		{protoreflect.BoolKind, float64(0), false, false},
+		{protoreflect.BoolKind, float64(2), nil, true},
+	}
+
+	for _, tt := range tests {
+		v, err := Value(tt.kind, tt.in)
+		if tt.err {
+			assert.Error(t, err, tt.in)
+		} else {
+			assert.NoError(t, err, tt.in)
+			assert.Equal(t, tt.out, v, tt.in)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	r := func() map[string]interface{} {
+		return map[string]interface{}{
+			"RTT":          "120",
+			"TotalRetrans": 2.5,
+			"SAddr":        "10.0.0.1",
+			"PID":          "4294967296",
+			"Unknown":      "17",
+			"Hostname":     nil,
+		}
+	}
+
+	c, _ := New(Keep)
+	m := r()
+	assert.True(t, c.Record(m))
+	assert.Equal(t, map[string]interface{}{
+		"RTT":          float64(120),
+		"TotalRetrans": float64(2),
+		"SAddr":        "10.0.0.1",
+		"PID":          "4294967296",
+		"Unknown":      "17",
+		"Hostname":     nil,
+	}, m)
+	assert.Equal(t, map[string]uint64{"PID": 1}, c.Failures())
+
+	c, _ = New(Null)
+	m = r()
+	assert.True(t, c.Record(m))
+	assert.Nil(t, m["PID"])
+	assert.Equal(t, float64(120), m["RTT"])
+
+	c, _ = New(DropRecord)
+	assert.False(t, c.Record(r()))
+	assert.False(t, c.Record(r()))
+	assert.True(t, c.Record(map[string]interface{}{"RTT": 1.0}))
+	assert.Equal(t, map[string]uint64{"PID": 2}, c.Failures())
+	assert.Equal(t, uint64(2), c.Dropped())
+}
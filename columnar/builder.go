@@ -0,0 +1,205 @@
+package columnar
+
+import (
+	"fmt"
+	"reflect"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// Builder accumulates the decoded records (json, spb or pb) into a batch
+type Builder struct {
+	schema   Schema
+	capacity int
+	batch    *Batch
+}
+
+// NewBuilder constructs a new builder, the capacity is the
+// expected number of the rows of a batch.
+func NewBuilder(schema Schema, capacity int) *Builder {
+	b := &Builder{schema: schema, capacity: capacity}
+	b.reset()
+
+	return b
+}
+
+func (b *Builder) reset() {
+	b.batch = &Batch{
+		Schema:  b.schema,
+		Columns: make([]*Column, len(b.schema)),
+	}
+
+	for i, f := range b.schema {
+		c := &Column{Field: f, valid: make([]byte, 0, (b.capacity+7)/8)}
+
+		switch f.Type {
+		case Uint32:
+			c.uint32s = make([]uint32, 0, b.capacity)
+		case Uint64:
+			c.uint64s = make([]uint64, 0, b.capacity)
+		case String:
+			c.offsets = make([]int32, 1, b.capacity+1)
+			c.data = make([]byte, 0, b.capacity*8)
+		case Bool:
+			c.values = make([]byte, 0, (b.capacity+7)/8)
+		}
+
+		b.batch.Columns[i] = c
+	}
+}
+
+// Len returns the number of the appended rows
+func (b *Builder) Len() int {
+	return b.batch.rows
+}
+
+// NewBatch returns the built batch and the builder starts a new one
+func (b *Builder) NewBatch() *Batch {
+	batch := b.batch
+	b.reset()
+
+	return batch
+}
+
+// Append appends the record as a row, a missing field is null. the
+// row is kept if a value has a wrong type, it's null and an error
+// is returned.
+func (b *Builder) Append(record interface{}) error {
+	var err error
+
+	switch r := record.(type) {
+	case map[string]interface{}:
+		for _, c := range b.batch.Columns {
+			if !c.appendInterface(r[c.Name]) {
+				err = fmt.Errorf("invalid %s value: %v", c.Name, r[c.Name])
+			}
+		}
+	case *pb.FieldsSPB:
+		f := r.GetFields().GetFields()
+		for _, c := range b.batch.Columns {
+			v, ok := f[c.Name]
+			if !ok {
+				c.appendNull()
+				continue
+			}
+			if !c.appendInterface(v.AsInterface()) {
+				err = fmt.Errorf("invalid %s value: %v", c.Name, v.AsInterface())
+			}
+		}
+	case *pb.Fields:
+		v := reflect.ValueOf(r).Elem()
+		for _, c := range b.batch.Columns {
+			c.appendPB(v.Field(c.index))
+		}
+	default:
+		return fmt.Errorf("record type %T is not supported", record)
+	}
+
+	b.batch.rows++
+
+	return err
+}
+
+func (c *Column) appendInterface(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		c.appendNull()
+		return true
+	case float64:
+		switch c.Type {
+		case Uint32:
+			c.appendUint32(uint32(v))
+			return true
+		case Uint64:
+			c.appendUint64(uint64(v))
+			return true
+		}
+	case string:
+		if c.Type == String {
+			c.appendString(v)
+			return true
+		}
+	case bool:
+		if c.Type == Bool {
+			c.appendBool(v)
+			return true
+		}
+	}
+
+	c.appendNull()
+
+	return false
+}
+
+func (c *Column) appendPB(v reflect.Value) {
+	if v.IsNil() {
+		c.appendNull()
+		return
+	}
+
+	switch c.Type {
+	case Uint32:
+		c.appendUint32(uint32(v.Elem().Uint()))
+	case Uint64:
+		c.appendUint64(v.Elem().Uint())
+	case String:
+		c.appendString(v.Elem().String())
+	case Bool:
+		c.appendBool(v.Elem().Bool())
+	}
+}
+
+// grow adds a row, it extends the bitmaps at the byte boundaries
+func (c *Column) grow(valid bool) {
+	if c.n&7 == 0 {
+		c.valid = append(c.valid, 0)
+		if c.Type == Bool {
+			c.values = append(c.values, 0)
+		}
+	}
+
+	if valid {
+		c.valid[c.n>>3] |= 1 << (c.n & 7)
+	} else {
+		c.nulls++
+	}
+
+	c.n++
+}
+
+func (c *Column) appendNull() {
+	switch c.Type {
+	case Uint32:
+		c.uint32s = append(c.uint32s, 0)
+	case Uint64:
+		c.uint64s = append(c.uint64s, 0)
+	case String:
+		c.offsets = append(c.offsets, int32(len(c.data)))
+	}
+
+	c.grow(false)
+}
+
+func (c *Column) appendUint32(v uint32) {
+	c.uint32s = append(c.uint32s, v)
+	c.grow(true)
+}
+
+func (c *Column) appendUint64(v uint64) {
+	c.uint64s = append(c.uint64s, v)
+	c.grow(true)
+}
+
+func (c *Column) appendString(v string) {
+	c.data = append(c.data, v...)
+	c.offsets = append(c.offsets, int32(len(c.data)))
+	c.grow(true)
+}
+
+func (c *Column) appendBool(v bool) {
+	i := c.n
+	c.grow(true)
+	if v {
+		c.values[i>>3] |= 1 << (i & 7)
+	}
+}
@@ -0,0 +1,97 @@
+package columnar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestNewSchema(t *testing.T) {
+	schema, err := NewSchema([]string{"PID", "BytesSent", "SAddr", "Synthetic"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Type{Uint32, Uint64, String, Bool},
+		[]Type{schema[0].Type, schema[1].Type, schema[2].Type, schema[3].Type})
+
+	_, err = NewSchema([]string{"Foo"})
+	assert.EqualError(t, err, "field Foo is not available")
+}
+
+func TestBuilder(t *testing.T) {
+	schema, err := NewSchema([]string{"PID", "BytesSent", "SAddr", "Synthetic"})
+	assert.NoError(t, err)
+
+	b := NewBuilder(schema, 4)
+
+	assert.NoError(t, b.Append(map[string]interface{}{
+		"PID": 5.0, "BytesSent": 0.0, "SAddr": "10.0.0.1", "Synthetic": true,
+	}))
+
+	spb, _ := structpb.NewStruct(map[string]interface{}{"PID": 6.0, "SAddr": "10.0.0.2"})
+	assert.NoError(t, b.Append(&pb.FieldsSPB{Fields: spb}))
+
+	assert.NoError(t, b.Append(&pb.Fields{
+		PID:       proto.Uint32(7),
+		BytesSent: proto.Uint64(1 << 40),
+		Synthetic: proto.Bool(false),
+	}))
+
+	// the wrong value is null but the row is kept
+	assert.EqualError(t, b.Append(map[string]interface{}{"PID": "8"}), "invalid PID value: 8")
+
+	assert.EqualError(t, b.Append("foo"), "record type string is not supported")
+
+	batch := b.NewBatch()
+	assert.Equal(t, 4, batch.Len())
+	assert.Equal(t, 0, b.Len())
+
+	pid := batch.Column("PID")
+	assert.Equal(t, []uint32{5, 6, 7, 0}, pid.Uint32s())
+	assert.Equal(t, 1, pid.NullN())
+	assert.True(t, pid.IsNull(3))
+
+	// the zero value isn't null
+	sent := batch.Column("BytesSent")
+	assert.Equal(t, []uint64{0, 0, 1 << 40, 0}, sent.Uint64s())
+	assert.False(t, sent.IsNull(0))
+	assert.True(t, sent.IsNull(1))
+	assert.Equal(t, 2, sent.NullN())
+
+	saddr := batch.Column("SAddr")
+	assert.Equal(t, "10.0.0.1", saddr.String(0))
+	assert.Equal(t, "10.0.0.2", saddr.String(1))
+	assert.True(t, saddr.IsNull(2))
+	assert.Equal(t, "", saddr.String(2))
+
+	synthetic := batch.Column("Synthetic")
+	assert.True(t, synthetic.Bool(0))
+	assert.True(t, synthetic.IsNull(1))
+	assert.False(t, synthetic.Bool(2))
+	assert.False(t, synthetic.IsNull(2))
+	assert.Equal(t, 4, synthetic.Len())
+
+	assert.Nil(t, batch.Column("RTT"))
+}
+
+func TestBuilderBitmap(t *testing.T) {
+	schema, _ := NewSchema([]string{"RTT"})
+	b := NewBuilder(schema, 0)
+
+	for i := 0; i < 20; i++ {
+		if i%3 == 0 {
+			b.Append(&pb.Fields{})
+			continue
+		}
+		b.Append(&pb.Fields{RTT: proto.Uint32(uint32(i))})
+	}
+
+	c := b.NewBatch().Column("RTT")
+	assert.Equal(t, 20, c.Len())
+	assert.Equal(t, 7, c.NullN())
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, i%3 == 0, c.IsNull(i))
+	}
+}
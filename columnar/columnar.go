@@ -0,0 +1,176 @@
+// Package columnar builds the in-memory record batches in the Arrow
+// layout, the records are accumulated into the typed column arrays with
+// a validity bitmap thus a columnar ingestion backend maps a whole batch
+// natively instead of converting the records row by row. the schema
+// comes from the fields registry (pb.Fields) and a missing field is
+// null, it's never mixed up with the zero value.
+package columnar
+
+import (
+	"fmt"
+	"reflect"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// Type represents a column type
+type Type int
+
+const (
+	// Uint32 is a fixed width uint32 column
+	Uint32 Type = iota
+	// Uint64 is a fixed width uint64 column
+	Uint64
+	// String is a variable width utf8 column
+	String
+	// Bool is a bit packed boolean column
+	Bool
+)
+
+func (t Type) String() string {
+	switch t {
+	case Uint32:
+		return "uint32"
+	case Uint64:
+		return "uint64"
+	case String:
+		return "utf8"
+	case Bool:
+		return "bool"
+	}
+
+	return "unknown"
+}
+
+// Field represents a schema field
+type Field struct {
+	Name string
+	Type Type
+
+	index int // pb.Fields struct field index
+}
+
+// Schema represents the batch columns
+type Schema []Field
+
+// NewSchema returns the schema of the fields, the types
+// come from the fields registry.
+func NewSchema(names []string) (Schema, error) {
+	t := reflect.TypeOf(pb.Fields{})
+	schema := make(Schema, 0, len(names))
+
+	for _, name := range names {
+		sf, ok := t.FieldByName(name)
+		if !ok || sf.Type.Kind() != reflect.Ptr {
+			return nil, fmt.Errorf("field %s is not available", name)
+		}
+
+		f := Field{Name: name, index: sf.Index[0]}
+
+		switch sf.Type.Elem().Kind() {
+		case reflect.Uint32:
+			f.Type = Uint32
+		case reflect.Uint64:
+			f.Type = Uint64
+		case reflect.String:
+			f.Type = String
+		case reflect.Bool:
+			f.Type = Bool
+		default:
+			return nil, fmt.Errorf("field %s type is not supported", name)
+		}
+
+		schema = append(schema, f)
+	}
+
+	return schema, nil
+}
+
+// Column represents a typed array, the offsets and the data
+// hold the strings and the values hold the bools bitmap.
+type Column struct {
+	Field
+
+	valid   []byte
+	uint32s []uint32
+	uint64s []uint64
+	offsets []int32
+	data    []byte
+	values  []byte
+	nulls   int
+	n       int
+}
+
+// Len returns the column length
+func (c *Column) Len() int {
+	return c.n
+}
+
+// NullN returns the number of the nulls
+func (c *Column) NullN() int {
+	return c.nulls
+}
+
+// IsNull returns true if the value of the row is missing
+func (c *Column) IsNull(i int) bool {
+	return c.valid[i>>3]&(1<<(i&7)) == 0
+}
+
+// Uint32 returns the value of the row, it's zero if it's null
+func (c *Column) Uint32(i int) uint32 {
+	return c.uint32s[i]
+}
+
+// Uint64 returns the value of the row, it's zero if it's null
+func (c *Column) Uint64(i int) uint64 {
+	return c.uint64s[i]
+}
+
+// String returns the value of the row, it's empty if it's null
+func (c *Column) String(i int) string {
+	return string(c.Bytes(i))
+}
+
+// Bytes returns the raw value of the row without a copy
+func (c *Column) Bytes(i int) []byte {
+	return c.data[c.offsets[i]:c.offsets[i+1]]
+}
+
+// Bool returns the value of the row, it's false if it's null
+func (c *Column) Bool(i int) bool {
+	return c.values[i>>3]&(1<<(i&7)) != 0
+}
+
+// Uint32s returns the values, the null rows are zero
+func (c *Column) Uint32s() []uint32 {
+	return c.uint32s
+}
+
+// Uint64s returns the values, the null rows are zero
+func (c *Column) Uint64s() []uint64 {
+	return c.uint64s
+}
+
+// Batch represents a record batch
+type Batch struct {
+	Schema  Schema
+	Columns []*Column
+
+	rows int
+}
+
+// Len returns the number of the rows
+func (b *Batch) Len() int {
+	return b.rows
+}
+
+// Column returns the column by name, it returns nil if it's not available
+func (b *Batch) Column(name string) *Column {
+	for _, c := range b.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
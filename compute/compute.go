@@ -0,0 +1,182 @@
+// Package compute derives the fields of the decoded records (json, spb
+// or pb) on the server side e.g. the RetransRate. a derived field is a
+// function which is registered by its name and the flows compute the
+// configured ones before the ingestion.
+package compute
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// Record represents the numeric fields of a decoded record
+type Record interface {
+	// Number returns the value of the field if it's a number
+	Number(name string) (float64, bool)
+	// SetNumber sets the value of the field
+	SetNumber(name string, v float64)
+}
+
+// Func computes a derived field of the record
+type Func func(r Record)
+
+var registry = struct {
+	sync.RWMutex
+	funcs map[string]Func
+}{funcs: map[string]Func{}}
+
+func init() {
+	Register("RetransRate", retransRate)
+}
+
+// Register registers the derived field function by its name, the
+// name is the field which the function sets.
+func Register(name string, f Func) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.funcs[name] = f
+}
+
+// Names returns the registered derived fields
+func Names() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var names []string
+	for name := range registry.funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Computer computes the derived fields of a flow in the configured order
+type Computer []Func
+
+// New returns the computer of the derived fields
+func New(names []string) (Computer, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	c := make(Computer, 0, len(names))
+	for _, name := range names {
+		f, ok := registry.funcs[name]
+		if !ok {
+			return nil, fmt.Errorf("compute %s is not supported", name)
+		}
+		c = append(c, f)
+	}
+
+	return c, nil
+}
+
+// Record computes the derived fields of the decoded record in place
+func (c Computer) Record(record interface{}) {
+	var r Record
+
+	switch v := record.(type) {
+	case map[string]interface{}:
+		r = jsonRecord(v)
+	case *pb.FieldsSPB:
+		if v.GetFields() == nil {
+			return
+		}
+		r = spbRecord{v.GetFields()}
+	case *pb.Fields:
+		r = pbRecord{v.ProtoReflect()}
+	default:
+		return
+	}
+
+	for _, f := range c {
+		f(r)
+	}
+}
+
+// retransRate is the retransmitted segments per the sent segments,
+// it's zero if there is no sent segment.
+func retransRate(r Record) {
+	retrans, ok := r.Number("TotalRetrans")
+	if !ok {
+		return
+	}
+
+	var rate float64
+	if segs, ok := r.Number("SegsOut"); ok && segs > 0 {
+		rate = retrans / segs
+	}
+
+	r.SetNumber("RetransRate", rate)
+}
+
+// jsonRecord is the json, msgpack or cbor record
+type jsonRecord map[string]interface{}
+
+func (r jsonRecord) Number(name string) (float64, bool) {
+	return serialization.Number(r[name])
+}
+
+func (r jsonRecord) SetNumber(name string, v float64) {
+	r[name] = v
+}
+
+type spbRecord struct {
+	s *structpb.Struct
+}
+
+func (r spbRecord) Number(name string) (float64, bool) {
+	v, ok := r.s.GetFields()[name].GetKind().(*structpb.Value_NumberValue)
+	if !ok {
+		return 0, false
+	}
+
+	return v.NumberValue, true
+}
+
+func (r spbRecord) SetNumber(name string, v float64) {
+	if r.s.Fields == nil {
+		r.s.Fields = map[string]*structpb.Value{}
+	}
+	r.s.Fields[name] = structpb.NewNumberValue(v)
+}
+
+var fieldsDesc = (&pb.Fields{}).ProtoReflect().Descriptor().Fields()
+
+type pbRecord struct {
+	m protoreflect.Message
+}
+
+func (r pbRecord) Number(name string) (float64, bool) {
+	fd := fieldsDesc.ByName(protoreflect.Name(name))
+	if fd == nil || !r.m.Has(fd) {
+		return 0, false
+	}
+
+	switch fd.Kind() {
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind:
+		return float64(r.m.Get(fd).Uint()), true
+	case protoreflect.DoubleKind:
+		return r.m.Get(fd).Float(), true
+	}
+
+	return 0, false
+}
+
+// SetNumber sets the field if it's a double field of the registry
+func (r pbRecord) SetNumber(name string, v float64) {
+	fd := fieldsDesc.ByName(protoreflect.Name(name))
+	if fd == nil || fd.Kind() != protoreflect.DoubleKind {
+		return
+	}
+
+	r.m.Set(fd, protoreflect.ValueOfFloat64(v))
+}
@@ -0,0 +1,86 @@
+package compute
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestRetransRate(t *testing.T) {
+	c, err := New([]string{"RetransRate"})
+	assert.NoError(t, err)
+
+	// json
+	m := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"TotalRetrans":5,"SegsOut":100}`), &m))
+	c.Record(m)
+	assert.Equal(t, 0.05, m["RetransRate"])
+
+	// msgpack and cbor integers
+	m = map[string]interface{}{"TotalRetrans": uint64(5), "SegsOut": int64(100)}
+	c.Record(m)
+	assert.Equal(t, 0.05, m["RetransRate"])
+
+	// division by zero
+	m = map[string]interface{}{"TotalRetrans": float64(5), "SegsOut": float64(0)}
+	c.Record(m)
+	assert.Equal(t, float64(0), m["RetransRate"])
+
+	m = map[string]interface{}{"TotalRetrans": float64(5)}
+	c.Record(m)
+	assert.Equal(t, float64(0), m["RetransRate"])
+
+	// the record without the retransmits doesn't have the rate
+	m = map[string]interface{}{"SegsOut": float64(100)}
+	c.Record(m)
+	assert.NotContains(t, m, "RetransRate")
+
+	// spb
+	s, err := structpb.NewStruct(map[string]interface{}{"TotalRetrans": 5, "SegsOut": 100})
+	assert.NoError(t, err)
+	spb := &pb.FieldsSPB{Fields: s}
+	c.Record(spb)
+	assert.Equal(t, 0.05, spb.Fields.Fields["RetransRate"].GetNumberValue())
+
+	// pb
+	p := &pb.Fields{TotalRetrans: proto.Uint32(5), SegsOut: proto.Uint32(100)}
+	c.Record(p)
+	assert.Equal(t, 0.05, p.GetRetransRate())
+
+	p = &pb.Fields{TotalRetrans: proto.Uint32(5), SegsOut: proto.Uint32(0)}
+	c.Record(p)
+	assert.NotNil(t, p.RetransRate)
+	assert.Equal(t, float64(0), p.GetRetransRate())
+}
+
+func TestRegister(t *testing.T) {
+	Register("BytesRatio", func(r Record) {
+		sent, _ := r.Number("BytesSent")
+		received, ok := r.Number("BytesReceived")
+		if ok && received > 0 {
+			r.SetNumber("BytesRatio", sent/received)
+		}
+	})
+	assert.Contains(t, Names(), "BytesRatio")
+
+	c, err := New([]string{"RetransRate", "BytesRatio"})
+	assert.NoError(t, err)
+
+	m := map[string]interface{}{"TotalRetrans": float64(1), "SegsOut": float64(4), "BytesSent": float64(10), "BytesReceived": float64(5)}
+	c.Record(m)
+	assert.Equal(t, 0.25, m["RetransRate"])
+	assert.Equal(t, float64(2), m["BytesRatio"])
+
+	// the pb records have only the registry fields
+	p := &pb.Fields{BytesSent: proto.Uint64(10), BytesReceived: proto.Uint64(5)}
+	c.Record(p)
+	assert.Nil(t, p.RetransRate)
+
+	_, err = New([]string{"Foo"})
+	assert.EqualError(t, err, "compute Foo is not supported")
+}
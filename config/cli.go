@@ -4,12 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	cli "github.com/urfave/cli/v2"
 )
@@ -22,7 +22,18 @@ var flags = []cli.Flag{
 	&cli.StringFlag{Name: "state", Aliases: []string{"s"}, Value: "TCP_CLOSE", Usage: "tcp state"},
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Value: "", Usage: "path to a file in yaml format to read configuration"},
 	&cli.IntFlag{Name: "sample", Aliases: []string{"a"}, Value: 0, Usage: "sample rate"},
+	&cli.IntFlag{Name: "pid", Value: 0, Usage: "filter the events of the socket owner process id"},
+	&cli.StringFlag{Name: "comm", Value: "", Usage: "filter the events of the socket owner process name prefix"},
+	&cli.StringFlag{Name: "dport", Value: "", Usage: "filter the destination ports e.g. 443,8000-8100"},
+	&cli.StringFlag{Name: "sport", Value: "", Usage: "filter the source ports e.g. 3306"},
 	&cli.IntFlag{Name: "workers", Aliases: []string{"w"}, Value: 1, Usage: "number of workers"},
+	&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, Usage: "suppress the status line"},
+	&cli.DurationFlag{Name: "stats-interval", Value: time.Second, Usage: "status line refresh interval"},
+	&cli.BoolFlag{Name: "print-config", Usage: "print the configuration with the expanded preset then exit"},
+	&cli.BoolFlag{Name: "summary", Usage: "print the percentiles of the summary fields at the exit"},
+	&cli.StringFlag{Name: "summary-fields", Value: "rtt", Usage: "summary numeric fields"},
+	&cli.StringFlag{Name: "summary-group", Value: "", Usage: "summary group field e.g. daddr"},
+	&cli.DurationFlag{Name: "duration", Aliases: []string{"d"}, Usage: "stop after the duration e.g. 60s"},
 }
 
 // Get returns cli config.CLIRequested parameters.
@@ -55,8 +66,19 @@ func action(r *cliRequest) cli.ActionFunc {
 		r.IPv6 = c.Bool("6")
 		r.Workers = c.Int("workers")
 		r.Sample = c.Int("sample")
+		r.PID = c.Int("pid")
+		r.Comm = c.String("comm")
+		r.DPort = c.String("dport")
+		r.SPort = c.String("sport")
 		r.TCPState = c.String("state")
 		r.Config = c.String("config")
+		r.Quiet = c.Bool("quiet")
+		r.StatsInterval = c.Duration("stats-interval")
+		r.PrintConfig = c.Bool("print-config")
+		r.Summary.Enable = c.Bool("summary")
+		r.Summary.Fields = strings.Split(c.String("summary-fields"), ",")
+		r.Summary.GroupBy = c.String("summary-group")
+		r.Duration = c.Duration("duration")
 
 		return nil
 	}
@@ -70,12 +92,12 @@ func checkSudo() error {
 	cmd := exec.Command("id", "-u")
 	output, err := cmd.Output()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	id, err := strconv.Atoi(string(output[:len(output)-1]))
+	id, err := strconv.Atoi(strings.TrimSpace(string(output)))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	if id != 0 {
@@ -11,6 +11,8 @@ import (
 
 var flagsServer = []cli.Flag{
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, Value: "", Usage: "path to a file in yaml format to read configuration"},
+	&cli.StringFlag{Name: "verify-ingestion", Value: "", Usage: "verify the ingestion connectivity, auth and write permission then exit"},
+	&cli.BoolFlag{Name: "print-config", Usage: "print the configuration with the expanded preset then exit"},
 }
 
 // Get returns server cli request
@@ -33,6 +35,8 @@ func getServer(args []string, version string) (*serverCLIRequest, error) {
 func actionServer(r *serverCLIRequest) cli.ActionFunc {
 	return func(c *cli.Context) error {
 		r.Config = c.String("config")
+		r.VerifyIngestion = c.String("verify-ingestion")
+		r.PrintConfig = c.Bool("print-config")
 
 		return nil
 	}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// TailConfig represents the tail command configuration
+type TailConfig struct {
+	Addr      string
+	Token     string
+	Sample    uint32
+	Filter    string
+	TLSConfig *TLSConfig
+}
+
+var flagsTail = []cli.Flag{
+	&cli.StringFlag{Name: "addr", Aliases: []string{"a"}, Value: "localhost:8087", Usage: "server admin address"},
+	&cli.StringFlag{Name: "token", Aliases: []string{"t"}, Value: "", Usage: "server admin token", EnvVars: []string{"TCPDOG_TOKEN"}},
+	&cli.UintFlag{Name: "sample", Aliases: []string{"s"}, Value: 0, Usage: "stream one of every sample events"},
+	&cli.StringFlag{Name: "filter", Aliases: []string{"f"}, Value: "", Usage: "filter expression e.g. 'DPort == 443 && RTT > 10000'"},
+	&cli.BoolFlag{Name: "tls", Usage: "enable TLS"},
+	&cli.BoolFlag{Name: "insecure-skip-verify", Usage: "skip the server certificate verification"},
+	&cli.StringFlag{Name: "ca-file", Value: "", Usage: "path to the CA certificate file"},
+}
+
+// GetTail returns the tail command configuration, the args
+// starts with the program name and the tail command.
+func GetTail(args []string, version string) (*TailConfig, error) {
+	var r = &TailConfig{}
+
+	initCLITail()
+
+	app := &cli.App{
+		Version: version,
+		Flags:   flagsTail,
+		Action:  actionTail(r),
+	}
+
+	if len(args) > 0 {
+		args = args[1:]
+	}
+
+	err := app.Run(args)
+
+	return r, err
+}
+
+func actionTail(r *TailConfig) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		r.Addr = c.String("addr")
+		r.Token = c.String("token")
+		r.Sample = uint32(c.Uint("sample"))
+		r.Filter = c.String("filter")
+
+		if c.Bool("tls") {
+			r.TLSConfig = &TLSConfig{
+				Enable:             true,
+				InsecureSkipVerify: c.Bool("insecure-skip-verify"),
+				CAFile:             c.String("ca-file"),
+			}
+		}
+
+		return nil
+	}
+}
+
+func initCLITail() {
+	cli.AppHelpTemplate = `usage: tcpdog tail options
+
+options:
+
+   {{range .VisibleFlags}}{{.}}
+   {{end}}
+`
+
+	cli.VersionPrinter = func(c *cli.Context) {
+		fmt.Printf("TCPDog version: %s [tail]\n", c.App.Version)
+		cli.OsExiter(0)
+	}
+
+	cli.HelpPrinter = func(w io.Writer, templ string, data interface{}) {
+		funcMap := template.FuncMap{
+			"join": strings.Join,
+		}
+		t := template.Must(template.New("help").Funcs(funcMap).Parse(templ))
+		t.Execute(w, data)
+		cli.OsExiter(0)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -21,12 +23,82 @@ type ctxKey string
 
 // Config represents tcpstats's config
 type Config struct {
+	// Preset is the built-in configuration which this one
+	// overrides e.g. retransmit-es, see the config presets.
+	Preset string `yaml:"preset"`
+
 	Tracepoints []Tracepoint
 	Fields      map[string][]Field
 	Egress      map[string]EgressConfig
+	Dedup       Dedup
+	Heartbeat   Heartbeat
 	Log         *zap.Config
 
-	logger *zap.Logger
+	// RecordID is the EventID generator of the events: none (default),
+	// uuid4, ulid or hash, the hash is the content hash of the event.
+	RecordID string `yaml:"recordID"`
+
+	// DSCPNames maps the DSCP values to the class names of the
+	// DSCPName field, it extends or overrides the default names.
+	DSCPNames map[uint8]string `yaml:"dscpNames"`
+
+	// Quiet suppresses the console status line, it's shown on the
+	// stderr per StatsInterval (default 1s) if it's a terminal.
+	Quiet         bool          `yaml:"quiet"`
+	StatsInterval time.Duration `yaml:"statsInterval"`
+
+	// Summary prints the percentiles of the fields at the exit
+	Summary Summary `yaml:"summary"`
+
+	// Duration stops the agent once it's passed, it's unlimited by default
+	Duration time.Duration `yaml:"duration"`
+
+	// OnTracepointError is fail (default) or skip, the skipped
+	// tracepoints are retried periodically.
+	OnTracepointError string `yaml:"onTracepointError"`
+
+	// DropReport is the path of the drop report file which
+	// is written at the shutdown, it's disabled by default.
+	DropReport string `yaml:"dropReport"`
+
+	// Tracing starts the traces of a sample of the kafka egress
+	// messages, the server continues them by the traceparent.
+	Tracing Tracing `yaml:"tracing"`
+
+	// Metrics exposes the prometheus metrics of the agent e.g. the
+	// tracepoints events and the egresses bytes, it's disabled by default.
+	Metrics Metrics `yaml:"metrics"`
+
+	// UnsafeFaultInjection allows the Faults which are injected at
+	// the start and expire after their duration, never set it in
+	// production. see the fault package for the points and the kinds.
+	UnsafeFaultInjection bool    `yaml:"unsafeFaultInjection"`
+	Faults               []Fault `yaml:"faults"`
+
+	logger  *zap.Logger
+	version string
+	file    string
+	source  []byte
+}
+
+// Summary represents the percentiles summary of the numeric fields
+// (default RTT) which is printed on the stderr at the exit, the groups
+// of the GroupBy field beyond MaxGroups (default 20) are the other.
+type Summary struct {
+	Enable    bool     `yaml:"enable"`
+	Fields    []string `yaml:"fields"`
+	GroupBy   string   `yaml:"groupBy"`
+	MaxGroups int      `yaml:"maxGroups"`
+}
+
+// Fault represents a fault injection at a named point, it's injected
+// by the probability and expires after the duration (default 1m).
+type Fault struct {
+	Point       string        `yaml:"point"`
+	Kind        string        `yaml:"kind"`
+	Probability float64       `yaml:"probability"`
+	Delay       time.Duration `yaml:"delay"`
+	Duration    time.Duration `yaml:"duration"`
 }
 
 // TLSConfig represents TLS configuration.
@@ -38,10 +110,37 @@ type TLSConfig struct {
 	CAFile             string
 }
 
+// SASLConfig represents the kafka SASL authentication, the Mechanism is
+// PLAIN (default), SCRAM-SHA-256 or SCRAM-SHA-512. the password can be
+// read from the PasswordFile or the PasswordEnv environment variable
+// instead of the Password thus it doesn't sit in the configuration.
+type SASLConfig struct {
+	Enable       bool
+	Mechanism    string
+	Username     string
+	Password     string
+	PasswordEnv  string
+	PasswordFile string
+}
+
 // EgressConfig represents egress configuration.
 type EgressConfig struct {
 	Type   string
 	Config map[string]interface{}
+	// Spool persists the events on the disk once the egress can't
+	// keep up or the grpc and kafka sinks fail to deliver them, it's
+	// disabled by default.
+	Spool *Spool `yaml:"spool"`
+}
+
+// Spool represents the write-ahead spool of an egress, the Path is the
+// directory of the segment files. the oldest segments are deleted once
+// the spool exceeds the MaxSize (e.g. 512MB, default 1GB) or they're
+// older than the MaxAge (unlimited by default).
+type Spool struct {
+	Path    string        `yaml:"path"`
+	MaxSize string        `yaml:"max-size"`
+	MaxAge  time.Duration `yaml:"max-age"`
 }
 
 // cliRequest represents cli requests.
@@ -52,9 +151,20 @@ type cliRequest struct {
 	IPv6       bool
 	Workers    int
 	Sample     int
+	PID        int
+	Comm       string
+	DPort      string
+	SPort      string
 	TCPState   string
 	Egress     string
 	Config     string
+
+	Quiet         bool
+	StatsInterval time.Duration
+	PrintConfig   bool
+
+	Summary  Summary
+	Duration time.Duration
 }
 
 // Logger returns logger.
@@ -62,6 +172,11 @@ func (c *Config) Logger() *zap.Logger {
 	return c.logger
 }
 
+// Version returns the agent version.
+func (c *Config) Version() string {
+	return c.version
+}
+
 // WithContext returns new context including configuration.
 func (c *Config) WithContext(ctx context.Context) context.Context {
 	return context.WithValue(ctx, ctxKey("cfg"), c)
@@ -72,7 +187,7 @@ func (c *Config) SetMockLogger(scheme string) *MemSink {
 	var err error
 
 	cfg := zap.NewDevelopmentConfig()
-	ms := &MemSink{new(bytes.Buffer)}
+	ms := &MemSink{Buffer: new(bytes.Buffer)}
 	zap.RegisterSink(scheme, func(*url.URL) (zap.Sink, error) {
 		return ms, nil
 	})
@@ -103,6 +218,122 @@ type Tracepoint struct {
 	Workers  int    `yaml:"workers"`
 	INet     []int  `yaml:"inet"`
 	Egress   string `yaml:"egress"`
+	// Priority is normal (default) or critical, the critical
+	// tracepoints are never throttled by the flow control.
+	Priority string `yaml:"priority"`
+
+	// PID, PIDs and Comm (process name prefix) filter the events of the
+	// socket owner in the kernel, the owner is the task which connects or
+	// accepts the socket not the current task since most of the events
+	// fire in the softirq context. the events of the sockets which were
+	// opened before the agent or aren't accepted yet don't match. the
+	// sample is applied after the filters thus it samples the matched
+	// events only.
+	PID  int    `yaml:"pid"`
+	PIDs []int  `yaml:"pids"`
+	Comm string `yaml:"comm"`
+
+	// DPort and SPort (local port) filter the events of
+	// the connections in the kernel e.g. 443 or 8000-8100.
+	DPort Ports `yaml:"dport"`
+	SPort Ports `yaml:"sport"`
+
+	Aggregate    *Aggregate    `yaml:"aggregate-in-kernel"`
+	Custom       *Custom       `yaml:"custom"`
+	ScanExisting *ScanExisting `yaml:"scanExisting"`
+}
+
+// ScanExisting represents the startup scan of /proc/net/tcp{,6}, a
+// synthetic tcpdog.existing_connection record is emitted per established
+// connection with the Synthetic and UID fields. the Rate is the maximum
+// records per second.
+type ScanExisting struct {
+	Rate int `yaml:"rate"`
+}
+
+// Custom represents a user bpf program which is attached instead of
+// the generated one, the name is the tracepoint or the kernel function.
+// the program submits its events to a perf map and they're decoded by
+// the schema which declares the output struct layout.
+type Custom struct {
+	// Source is the bpf C source file, it's compiled by bcc
+	Source string `yaml:"source"`
+	// Object is the precompiled bpf ELF object, it's loaded as is
+	// thus it should match the running kernel (no CO-RE relocations)
+	Object string `yaml:"object"`
+	// Program is the function name of the source program or
+	// the section name of the object program e.g. kprobe/tcp_close
+	Program string `yaml:"program"`
+	// Kind is tracepoint (default) or kprobe
+	Kind string `yaml:"kind"`
+	// Map is the perf output map name
+	Map string `yaml:"map"`
+	// Size is the output struct size in bytes
+	Size   int           `yaml:"size"`
+	Schema []SchemaField `yaml:"schema"`
+}
+
+// SchemaField represents a field of the custom program output struct,
+// the type is u8, u16, u32, u64, ipv4, ipv6 or comm (char[16]).
+type SchemaField struct {
+	Name      string `yaml:"name"`
+	Type      string `yaml:"type"`
+	Offset    int    `yaml:"offset"`
+	BigEndian bool   `yaml:"big_endian"`
+}
+
+// Aggregate represents the in-kernel aggregation of a tracepoint,
+// the events are summarized per key in a bpf map and one record
+// per key is emitted every window. the metrics are count,
+// sum:<field>, max:<field> and min:<field>. the records have
+// the Window field which is the window length in milliseconds.
+type Aggregate struct {
+	Window     time.Duration `yaml:"window"`
+	Key        []string      `yaml:"key"`
+	Metrics    []string      `yaml:"metrics"`
+	MaxEntries int           `yaml:"max_entries"`
+}
+
+// Dedup represents the events deduplication config, the ids of the
+// emitted events are kept in a bloom filter which persists across
+// restarts. the fpr is the false positive rate, a false positive
+// drops a new event as a duplicate.
+type Dedup struct {
+	Enable       bool          `yaml:"enable"`
+	File         string        `yaml:"file"`
+	Capacity     uint64        `yaml:"capacity"`
+	FPR          float64       `yaml:"fpr"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+// Heartbeat represents the agent liveness notifications, the agent
+// sends READY, WATCHDOG and STOPPING to systemd if it runs as a
+// Type=notify service, otherwise it writes the File per Interval
+// with the populated fields bitmap of each tracepoint. both are
+// skipped while the bpf readers make no progress.
+type Heartbeat struct {
+	File     string        `yaml:"file"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Tracing represents the OpenTelemetry tracing of a sample of the records,
+// the SampleRate is the fraction of the records which are traced and the
+// spans are exported to the OTLP/HTTP (json) Endpoint per FlushInterval
+// (default 5s) e.g. http://localhost:4318/v1/traces. a trace which isn't
+// finished within the MaxAge (default 5m), e.g. its record has been
+// dropped, is exported as incomplete. it's disabled by default.
+type Tracing struct {
+	Endpoint      string            `yaml:"endpoint"`
+	Headers       map[string]string `yaml:"headers"`
+	SampleRate    float64           `yaml:"sampleRate"`
+	ServiceName   string            `yaml:"serviceName"`
+	FlushInterval time.Duration     `yaml:"flushInterval"`
+	MaxAge        time.Duration     `yaml:"maxAge"`
+}
+
+// Enabled returns true if the tracing endpoint is set
+func (t Tracing) Enabled() bool {
+	return t.Endpoint != ""
 }
 
 // Field represents a field.
@@ -126,24 +357,34 @@ func (c *Config) GetTPFields(name string) []string {
 
 // load reads yaml configuration
 func load(file string) (*Config, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(f)
+	b, err := read(file, "agent")
 	if err != nil {
 		return nil, err
 	}
 
+	return parse(b)
+}
+
+// parse unmarshals the yaml configuration, the source
+// is kept thus the reload can find out the changes.
+func parse(b []byte) (*Config, error) {
 	c := &Config{}
-	err = yml.Unmarshal(b, c)
+	err := yml.Unmarshal(b, c)
 	if err != nil {
 		return nil, err
 	}
 
+	c.source = b
+
 	return c, nil
 }
 
+// SetDefault sets the default values of an unset configuration,
+// it's useful when the configuration is built without Get.
+func (c *Config) SetDefault() {
+	setDefault(c)
+}
+
 func setDefault(conf *Config) {
 	for i := range conf.Tracepoints {
 		if len(conf.Tracepoints[i].INet) < 1 {
@@ -152,6 +393,56 @@ func setDefault(conf *Config) {
 		if conf.Tracepoints[i].Workers < 1 {
 			conf.Tracepoints[i].Workers = 1
 		}
+		if c := conf.Tracepoints[i].Custom; c != nil && c.Kind == "" {
+			c.Kind = "tracepoint"
+		}
+		if scan := conf.Tracepoints[i].ScanExisting; scan != nil && scan.Rate < 1 {
+			scan.Rate = 1000
+		}
+		if agg := conf.Tracepoints[i].Aggregate; agg != nil {
+			if agg.Window <= 0 {
+				agg.Window = 10 * time.Second
+			}
+			if agg.MaxEntries < 1 {
+				agg.MaxEntries = 10240
+			}
+		}
+	}
+
+	if conf.OnTracepointError == "" {
+		conf.OnTracepointError = "fail"
+	}
+
+	if conf.Metrics.Addr == "" {
+		conf.Metrics.Addr = "localhost:9111"
+	}
+
+	if len(conf.Summary.Fields) < 1 {
+		conf.Summary.Fields = []string{"RTT"}
+	}
+	if conf.Summary.MaxGroups < 1 {
+		conf.Summary.MaxGroups = 20
+	}
+
+	if conf.Dedup.File == "" {
+		conf.Dedup.File = "/var/lib/tcpdog/dedup.bloom"
+	}
+	if conf.Dedup.Capacity < 1 {
+		conf.Dedup.Capacity = 1000000
+	}
+	if conf.Dedup.FPR == 0 {
+		conf.Dedup.FPR = 0.001
+	}
+	if conf.Dedup.SyncInterval <= 0 {
+		conf.Dedup.SyncInterval = 10 * time.Second
+	}
+
+	if conf.Heartbeat.Interval <= 0 {
+		conf.Heartbeat.Interval = 10 * time.Second
+	}
+
+	if conf.StatsInterval <= 0 {
+		conf.StatsInterval = time.Second
 	}
 
 	// set default logger
@@ -162,36 +453,54 @@ func setDefault(conf *Config) {
 
 // Get returns the configuration based on the file or cli
 func Get(args []string, version string) (*Config, error) {
-	var (
-		config *Config
-		err    error
-	)
-
-	defer func() {
-		if config != nil {
-			setDefault(config)
-		}
-	}()
-
 	cli, err := get(args, version)
 	if err != nil {
 		return nil, err
 	}
 
 	if cli.Config != "" {
-		config, err = load(cli.Config)
+		if cli.PrintConfig {
+			if err := printConfig(cli.Config, "agent"); err != nil {
+				return nil, err
+			}
+		}
+
+		config, err := load(cli.Config)
 		if err != nil {
 			return nil, err
 		}
 
 		config.logger = GetLogger(config.Log)
+		config.file = cli.Config
+
+		if cli.Summary.Enable {
+			config.Summary = cli.Summary
+		}
+		if cli.Duration > 0 {
+			config.Duration = cli.Duration
+		}
 
-		return config, nil
+		return validated(config, version)
 	}
 
-	config, err = cliToConfig(cli)
+	config, err := cliToConfig(cli)
+	if err != nil {
+		return nil, err
+	}
 
-	return config, err
+	return validated(config, version)
+}
+
+// validated sets the defaults and validates the configuration
+func validated(config *Config, version string) (*Config, error) {
+	config.version = version
+	setDefault(config)
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
 }
 
 func cliToConfig(cli *cliRequest) (*Config, error) {
@@ -212,6 +521,10 @@ func cliToConfig(cli *cliRequest) (*Config, error) {
 				TCPState: cli.TCPState,
 				Workers:  cli.Workers,
 				Sample:   cli.Sample,
+				PID:      cli.PID,
+				Comm:     cli.Comm,
+				DPort:    cliPorts(cli.DPort),
+				SPort:    cliPorts(cli.SPort),
 				INet:     inet,
 				Egress:   "console",
 			},
@@ -224,11 +537,23 @@ func cliToConfig(cli *cliRequest) (*Config, error) {
 				Type: "console",
 			},
 		},
+		Quiet:         cli.Quiet,
+		StatsInterval: cli.StatsInterval,
+		Summary:       cli.Summary,
+		Duration:      cli.Duration,
 	}
 
 	return config, nil
 }
 
+func cliPorts(ports string) Ports {
+	if ports == "" {
+		return nil
+	}
+
+	return Ports{ports}
+}
+
 func cliFieldsStrToSlice(fs []string) []Field {
 	fields := []Field{}
 
@@ -336,9 +661,35 @@ func GetCreds(cfg *TLSConfig) (credentials.TransportCredentials, error) {
 	return credentials.NewTLS(tlsConfig), nil
 }
 
-// MemSink represents logging in memory
+// MemSink represents logging in memory, it's safe to read
+// while the goroutines are logging.
 type MemSink struct {
 	*bytes.Buffer
+	mu sync.Mutex
+}
+
+// Write writes the log entry
+func (s *MemSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Buffer.Write(p)
+}
+
+// String returns the logs
+func (s *MemSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Buffer.String()
+}
+
+// Reset resets the buffer
+func (s *MemSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Buffer.Reset()
 }
 
 // Close is required method for sink interface.
@@ -349,9 +700,12 @@ func (s *MemSink) Sync() error { return nil }
 
 // Unmarshal returns decoded data as key value and reset the buffer.
 func (s *MemSink) Unmarshal() map[string]string {
-	defer s.Reset()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	v := make(map[string]string)
 	json.Unmarshal(s.Bytes(), &v)
+	s.Buffer.Reset()
 	return v
 }
 
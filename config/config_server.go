@@ -3,9 +3,8 @@ package config
 import (
 	"bytes"
 	"context"
-	"io/ioutil"
 	"net/url"
-	"os"
+	"time"
 
 	"go.uber.org/zap"
 	yml "gopkg.in/yaml.v3"
@@ -29,27 +28,187 @@ type Ingress struct {
 	Config map[string]interface{} `yaml:"config"`
 }
 
+// Processor represents a processor, the Cluster opts in its flows
+// to the cluster routing of the gRPC ingress thus the records of a
+// connection are processed by one server instance.
+type Processor struct {
+	Type    string                 `yaml:"type"`
+	Config  map[string]interface{} `yaml:"config"`
+	Cluster bool                   `yaml:"cluster"`
+}
+
+// Admin represents the server admin gRPC API
+type Admin struct {
+	Enable bool   `yaml:"enable"`
+	Addr   string `yaml:"addr"`
+	// Token is required from the clients if it's set
+	Token     string     `yaml:"token"`
+	TLSConfig *TLSConfig `yaml:"tlsConfig"`
+	// HTTPAddr enables the admin http status endpoints
+	HTTPAddr string `yaml:"httpAddr"`
+	// UI enables the web ui (/ui) and its status and live
+	// tail endpoints on the admin http.
+	UI bool `yaml:"ui"`
+}
+
+// Metrics represents the prometheus metrics endpoint
+type Metrics struct {
+	Enable bool   `yaml:"enable"`
+	Addr   string `yaml:"addr"`
+}
+
+// Health represents the health endpoints of the probes, /healthz
+// and /readyz, the address should be reachable by the probes.
+type Health struct {
+	Enable bool   `yaml:"enable"`
+	Addr   string `yaml:"addr"`
+}
+
 // Flow represents flow from an ingress to an ingestion
 type Flow struct {
 	Ingress       string
 	Ingestion     string
+	Processor     string
 	Serialization string
+	// MaxRecordAge enables the late records handling if it's set,
+	// the LateAction can be drop (default), tag or route, the tag
+	// action marks the records with late: true and the route action
+	// sends the late records to the LateIngestion. the records with
+	// a timestamp from the future (more than a minute) are passed as
+	// untrusted but the records of an agent clock which runs behind
+	// by more than the MaxRecordAge are handled as late, the agents
+	// clocks should be synchronized e.g. by NTP.
+	MaxRecordAge  time.Duration `yaml:"maxRecordAge"`
+	LateAction    string        `yaml:"lateAction"`
+	LateIngestion string        `yaml:"lateIngestion"`
+	// RecordID generates the EventID of the records which arrive
+	// without one: none (default), uuid4, ulid or hash.
+	RecordID string `yaml:"recordID"`
+	// Mirror tees a sample of the post-processor records
+	// to another ingestion, e.g. to test a new backend.
+	Mirror *Mirror `yaml:"mirror"`
+	// Columnar hands the records to the ingestion as the columnar
+	// batches, the ingestion should support it (e.g. clickhouse).
+	Columnar *Columnar `yaml:"columnar"`
+	// Coerce converts the known fields of the json records to their
+	// canonical types, the OnCoerceError policy of a field which can't
+	// be converted is keep (default), null or drop-record.
+	Coerce        bool   `yaml:"coerce"`
+	OnCoerceError string `yaml:"onCoerceError"`
+	// Compute is the derived fields which the server computes
+	// per record e.g. RetransRate (TotalRetrans / SegsOut).
+	Compute []string `yaml:"compute"`
+	// Buffer is the capacity of the flow channel (default 1000), the
+	// Overflow policy of a full channel is block (default) which holds
+	// the ingress, drop-oldest or drop-new which count the drops.
+	Buffer   int    `yaml:"buffer"`
+	Overflow string `yaml:"overflow"`
+}
+
+// Mirror represents a shadow ingestion of a flow, it has its own bounded
+// queue thus its overflow or failure never back-pressures the flow. the
+// OnError is ignore (default) which keeps the flow running if the mirror
+// ingestion fails to start or fail which stops the server.
+type Mirror struct {
+	Ingestion string  `yaml:"ingestion"`
+	Percent   float64 `yaml:"percent"`
+	OnError   string  `yaml:"onError"`
+	QueueSize int     `yaml:"queueSize"`
+}
+
+// Columnar represents the columnar batches of a flow, a batch is
+// handed to the ingestion once it has BatchSize records or the
+// FlushInterval has been passed.
+type Columnar struct {
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+}
+
+// Watchdog represents the flows watchdog, a flow stage is stalled if
+// its input queue isn't empty and it makes no progress for the timeout.
+type Watchdog struct {
+	Enable bool `yaml:"enable"`
+	// Timeout is a minute by default and it's at least a second
+	Timeout time.Duration `yaml:"timeout"`
+	// Restart stops the server run with the stall error once a stage
+	// is stalled, the tcpdog server exits thus the supervisor (e.g.
+	// systemd) restarts it and an embedder gets the error of the run.
+	Restart bool `yaml:"restart"`
+}
+
+// Standby represents the stateful processors checkpointing, the
+// state is saved to Dir and/or replicated to the Peer admin server
+// per Interval (default 10s) and it's restored at startup if it's
+// not older than MaxAge (default 5m).
+type Standby struct {
+	Dir string `yaml:"dir"`
+	// Peer is the admin grpc address of the standby server
+	Peer string `yaml:"peer"`
+	// Token is the peer admin token
+	Token     string        `yaml:"token"`
+	TLSConfig *TLSConfig    `yaml:"tlsConfig"`
+	Interval  time.Duration `yaml:"interval"`
+	MaxAge    time.Duration `yaml:"maxAge"`
+}
+
+// Enabled returns true if the checkpoints have a destination
+func (s Standby) Enabled() bool {
+	return s.Dir != "" || s.Peer != ""
+}
+
+// Intern represents the strings interning of the records, the
+// configured fields values are shared across the records and
+// the pool holds up to MaxEntries (default 100000) strings.
+type Intern struct {
+	Fields     []string `yaml:"fields"`
+	MaxEntries int      `yaml:"maxEntries"`
 }
 
 // cliRequest represents cli request
 type serverCLIRequest struct {
-	Config string
+	Config          string
+	VerifyIngestion string
+	PrintConfig     bool
 }
 
 // ServerConfig represents server configuration
 type ServerConfig struct {
+	// Preset is the built-in configuration which this one
+	// overrides e.g. retransmit-es, see the config presets.
+	Preset string `yaml:"preset"`
+
 	Ingress   map[string]Ingress
 	Ingestion map[string]Ingestion
+	Processor map[string]Processor
 	Flow      []Flow
 	Geo       Geo
+	Admin     Admin
+	Metrics   Metrics
+	Health    Health
+	Watchdog  Watchdog
+	Intern    Intern
+	Standby   Standby
 	Log       *zap.Config
 
-	logger *zap.Logger
+	// DropReport is the path of the drop report file which
+	// is written at the shutdown, it's disabled by default.
+	DropReport string `yaml:"dropReport"`
+
+	// Provisioning is auto (default), verify or off: auto creates
+	// the missing ingestions objects e.g. tables, verify fails the
+	// ingestions which miss an object and off skips the checks.
+	Provisioning string `yaml:"provisioning"`
+
+	// UnsafeFaultInjection allows the fault injections of the
+	// admin http (/faults), never set it in production.
+	UnsafeFaultInjection bool `yaml:"unsafeFaultInjection"`
+
+	// Tracing traces a sample of the records through the flows
+	// stages, the agent traces are continued if they're sampled.
+	Tracing Tracing `yaml:"tracing"`
+
+	logger          *zap.Logger
+	verifyIngestion string
 }
 
 // Logger returns logger
@@ -57,12 +216,53 @@ func (c *ServerConfig) Logger() *zap.Logger {
 	return c.logger
 }
 
+// SharedListener returns true if a grpc ingress serves the http
+// requests of the address on its shared listener.
+func (c *ServerConfig) SharedListener(addr string) bool {
+	for _, ingress := range c.Ingress {
+		if ingress.Type != "grpc" {
+			continue
+		}
+
+		gCfg := struct {
+			Addr           string
+			SharedListener bool
+			Listeners      []struct {
+				Addr           string
+				SharedListener bool
+			}
+		}{Addr: ":8085"}
+
+		if err := Transform(ingress.Config, &gCfg); err != nil {
+			continue
+		}
+
+		if len(gCfg.Listeners) < 1 && gCfg.SharedListener && gCfg.Addr == addr {
+			return true
+		}
+
+		for _, l := range gCfg.Listeners {
+			if l.SharedListener && l.Addr == addr {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// VerifyIngestion returns the ingestion name which is requested
+// to verify from the command line, it's empty by default.
+func (c *ServerConfig) VerifyIngestion() string {
+	return c.verifyIngestion
+}
+
 // SetMockLogger sets the in memory logger
 func (c *ServerConfig) SetMockLogger(scheme string) *MemSink {
 	var err error
 
 	cfg := zap.NewDevelopmentConfig()
-	ms := &MemSink{new(bytes.Buffer)}
+	ms := &MemSink{Buffer: new(bytes.Buffer)}
 	zap.RegisterSink(scheme, func(*url.URL) (zap.Sink, error) {
 		return ms, nil
 	})
@@ -91,11 +291,7 @@ func FromContextServer(ctx context.Context) *ServerConfig {
 
 // loadServer reads server yaml configuration
 func loadServer(file string) (*ServerConfig, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(f)
+	b, err := read(file, "server")
 	if err != nil {
 		return nil, err
 	}
@@ -132,18 +328,84 @@ func GetServer(args []string, version string) (*ServerConfig, error) {
 		cli.Config = "/etc/tcpdog/server.yaml"
 	}
 
+	if cli.PrintConfig {
+		if err := printConfig(cli.Config, "server"); err != nil {
+			return nil, err
+		}
+	}
+
 	config, err = loadServer(cli.Config)
 	if err != nil {
 		return nil, err
 	}
 
 	config.logger = GetLogger(config.Log)
+	config.verifyIngestion = cli.VerifyIngestion
 
 	return config, nil
 }
 
+// SetDefault sets the default values of an unset configuration,
+// it's useful when the configuration is built without GetServer.
+func (c *ServerConfig) SetDefault() {
+	setDefaultServer(c)
+}
+
 func setDefaultServer(conf *ServerConfig) {
 	if conf.logger == nil {
 		conf.logger = GetDefaultLogger()
 	}
+
+	if conf.Admin.Addr == "" {
+		conf.Admin.Addr = "localhost:8087"
+	}
+
+	if conf.Metrics.Addr == "" {
+		conf.Metrics.Addr = "localhost:9110"
+	}
+
+	if conf.Health.Addr == "" {
+		conf.Health.Addr = "localhost:8088"
+	}
+
+	if conf.Watchdog.Timeout <= 0 {
+		conf.Watchdog.Timeout = time.Minute
+	}
+
+	if conf.Intern.MaxEntries < 1 {
+		conf.Intern.MaxEntries = 100000
+	}
+
+	if conf.Standby.Interval <= 0 {
+		conf.Standby.Interval = 10 * time.Second
+	}
+
+	if conf.Standby.MaxAge <= 0 {
+		conf.Standby.MaxAge = 5 * time.Minute
+	}
+
+	if conf.Provisioning == "" {
+		conf.Provisioning = "auto"
+	}
+
+	for _, flow := range conf.Flow {
+		if flow.Columnar != nil {
+			if flow.Columnar.BatchSize < 1 {
+				flow.Columnar.BatchSize = 10000
+			}
+			if flow.Columnar.FlushInterval <= 0 {
+				flow.Columnar.FlushInterval = time.Second
+			}
+		}
+
+		if flow.Mirror == nil {
+			continue
+		}
+		if flow.Mirror.OnError == "" {
+			flow.Mirror.OnError = "ignore"
+		}
+		if flow.Mirror.QueueSize < 1 {
+			flow.Mirror.QueueSize = 1000
+		}
+	}
 }
@@ -10,12 +10,17 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"io"
+	"io/ioutil"
 	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	cli "github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 )
 
@@ -60,7 +65,14 @@ func TestLoad(t *testing.T) {
     tcp_state: TCP_CLOSE
     sample: 0
     inet: [4,6]
-    egress: console`
+    egress: console
+egress:
+  grpc:
+    type: grpc-pb
+    spool:
+      path: /var/spool/tcpdog
+      max-size: 512MB
+      max-age: 24h`
 
 	filename := os.TempDir() + "/config.yml"
 	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0755)
@@ -75,6 +87,7 @@ func TestLoad(t *testing.T) {
 	assert.Len(t, cfg.Tracepoints, 1)
 	assert.Equal(t, "sock:inet_sock_set_state", cfg.Tracepoints[0].Name)
 	assert.Equal(t, "fields_01", cfg.Tracepoints[0].Fields)
+	assert.Equal(t, &Spool{Path: "/var/spool/tcpdog", MaxSize: "512MB", MaxAge: 24 * time.Hour}, cfg.Egress["grpc"].Spool)
 
 	// wrong file
 	_, err = load("not_exist")
@@ -116,10 +129,17 @@ func TestCliToConfig(t *testing.T) {
 		Egress:     "bar",
 		IPv4:       true,
 		IPv6:       true,
+		PID:        1234,
+		Comm:       "nginx",
+		DPort:      "443,8000-8100",
 	}
 
 	c, err := cliToConfig(cli)
 	assert.NoError(t, err)
+	assert.Equal(t, 1234, c.Tracepoints[0].PID)
+	assert.Equal(t, "nginx", c.Tracepoints[0].Comm)
+	assert.Equal(t, Ports{"443,8000-8100"}, c.Tracepoints[0].DPort)
+	assert.Nil(t, c.Tracepoints[0].SPort)
 	assert.Len(t, c.Fields["cli"], 2)
 	assert.Equal(t, "f1", c.Fields["cli"][0].Name)
 	assert.Equal(t, "f2", c.Fields["cli"][1].Name)
@@ -134,6 +154,31 @@ func TestGet(t *testing.T) {
 	assert.Equal(t, "RTT", c.Fields["cli"][1].Name)
 	assert.Equal(t, 4, c.Tracepoints[0].INet[0])
 	assert.Equal(t, "TCP_FOO", c.Tracepoints[0].TCPState)
+	assert.False(t, c.Quiet)
+	assert.Equal(t, time.Second, c.StatsInterval)
+
+	c, err = Get([]string{"tcpdog", "-pid", "1234", "-comm", "nginx"}, "0.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, c.Tracepoints[0].PID)
+	assert.Equal(t, "nginx", c.Tracepoints[0].Comm)
+
+	c, err = Get([]string{"tcpdog", "-dport", "443", "-sport", "3306"}, "0.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, Ports{"443"}, c.Tracepoints[0].DPort)
+	assert.Equal(t, Ports{"3306"}, c.Tracepoints[0].SPort)
+
+	_, err = Get([]string{"tcpdog", "-dport", "0-80"}, "0.0.0")
+	assert.EqualError(t, err, `invalid configuration: tracepoint sock:inet_sock_set_state has wrong dport: invalid port or port range "0-80"`)
+
+	c, err = Get([]string{"tcpdog", "-quiet", "-stats-interval", "5s"}, "0.0.0")
+	assert.NoError(t, err)
+	assert.True(t, c.Quiet)
+	assert.Equal(t, 5*time.Second, c.StatsInterval)
+
+	c, err = Get([]string{"tcpdog", "-summary", "-summary-group", "daddr", "-duration", "60s"}, "0.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, Summary{Enable: true, Fields: []string{"rtt"}, GroupBy: "daddr", MaxGroups: 20}, c.Summary)
+	assert.Equal(t, time.Minute, c.Duration)
 
 	// config option
 	filename := os.TempDir() + "/config.yml"
@@ -289,10 +334,16 @@ geo:
     path-asn: "GeoLite2-ASN.mmdb"
     level: city-loc-asn
 
+metrics:
+  enable: true
+  addr: ":9110"
+
 flow:
   - ingress: grpc
     ingestion: elasticsearch
-    serialization: spb`
+    serialization: spb
+    buffer: 10000
+    overflow: drop-oldest`
 
 	filename := os.TempDir() + "/config.yml"
 	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0755)
@@ -309,8 +360,11 @@ flow:
 	assert.Equal(t, "grpc", cfg.Flow[0].Ingress)
 	assert.Equal(t, "elasticsearch", cfg.Flow[0].Ingestion)
 	assert.Equal(t, "spb", cfg.Flow[0].Serialization)
+	assert.Equal(t, 10000, cfg.Flow[0].Buffer)
+	assert.Equal(t, "drop-oldest", cfg.Flow[0].Overflow)
 	assert.Equal(t, "grpc", cfg.Ingress["grpc"].Type)
 	assert.Equal(t, "elasticsearch", cfg.Ingestion["elasticsearch"].Type)
+	assert.Equal(t, Metrics{Enable: true, Addr: ":9110"}, cfg.Metrics)
 
 	// wrong filename
 	_, err = loadServer("not-exist")
@@ -351,6 +405,11 @@ flow:
 	assert.Equal(t, "maxmind", c.Geo.Type)
 	assert.Equal(t, "elasticsearch", c.Ingestion["elasticsearch"].Type)
 	assert.Equal(t, "grpc", c.Ingress["grpc"].Type)
+	assert.Equal(t, "", c.VerifyIngestion())
+
+	c, err = GetServer([]string{"tcpdog", "-config", filename, "-verify-ingestion", "elasticsearch"}, "0.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "elasticsearch", c.VerifyIngestion())
 
 	_, err = GetServer([]string{"tcpdog"}, "0.0.0")
 	assert.Error(t, err)
@@ -381,3 +440,328 @@ func TestSetMockLoggerServer(t *testing.T) {
 func TestCheckSudo(t *testing.T) {
 	assert.NoError(t, checkSudo())
 }
+
+func TestPreset(t *testing.T) {
+	assert.Equal(t, []string{"kafka-clickhouse", "latency-influx", "retransmit-es"}, Presets())
+
+	ymlContent := `preset: retransmit-es
+ingestion:
+  elasticsearch:
+    config:
+      urls:
+        - http://es01:9200
+flow:
+  - ingress: grpc
+    ingestion: elasticsearch
+    serialization: json`
+
+	filename := filepath.Join(t.TempDir(), "server.yml")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(ymlContent), 0644))
+
+	c, err := GetServer([]string{"tcpdog", "-config", filename}, "0.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "retransmit-es", c.Preset)
+	// the maps are merged
+	assert.Equal(t, "elasticsearch", c.Ingestion["elasticsearch"].Type)
+	assert.Equal(t, []interface{}{"http://es01:9200"}, c.Ingestion["elasticsearch"].Config["urls"])
+	assert.Equal(t, "tcpdog-retransmit", c.Ingestion["elasticsearch"].Config["index"])
+	assert.Equal(t, "anomaly", c.Processor["retransmit"].Type)
+	// the lists are replaced
+	assert.Equal(t, []Flow{{Ingress: "grpc", Ingestion: "elasticsearch", Serialization: "json"}}, c.Flow)
+
+	// print config
+	var (
+		buf  bytes.Buffer
+		code = -1
+	)
+
+	stdout = &buf
+	cli.OsExiter = func(c int) { code = c }
+	defer func() {
+		stdout = os.Stdout
+		cli.OsExiter = os.Exit
+	}()
+
+	_, err = GetServer([]string{"tcpdog", "-config", filename, "-print-config"}, "0.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, buf.String(), "index: tcpdog-retransmit")
+	assert.Contains(t, buf.String(), "- http://es01:9200")
+
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("preset: foo"), 0644))
+	_, err = GetServer([]string{"tcpdog", "-config", filename}, "0.0.0")
+	assert.EqualError(t, err, "preset foo is not available")
+
+	agentFile := filepath.Join(t.TempDir(), "agent.yml")
+	assert.NoError(t, ioutil.WriteFile(agentFile, []byte("preset: kafka-clickhouse\nquiet: true"), 0644))
+
+	ac, err := Get([]string{"tcpdog", "-config", agentFile}, "0.0.0")
+	assert.NoError(t, err)
+	assert.True(t, ac.Quiet)
+	assert.Equal(t, "kafka", ac.Egress["kafka"].Type)
+	assert.Equal(t, "TCP_CLOSE", ac.Tracepoints[0].TCPState)
+}
+
+func TestEnvSubstitution(t *testing.T) {
+	ymlContent := `
+ingress:
+  grpc01:
+    type: grpc
+    config:
+      addr: ${TCPDOG_TEST_ADDR:-:8085}
+ingestion:
+  elasticsearch:
+    type: elasticsearch
+    config:
+      urls:
+        - ${TCPDOG_TEST_ES_URL}
+      workers: ${TCPDOG_TEST_WORKERS}
+      index: "tcpdog-${TCPDOG_TEST_ENV}"
+flow:
+  - ingress: grpc01
+    ingestion: elasticsearch
+    serialization: spb
+`
+	filename := filepath.Join(t.TempDir(), "server.yml")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(ymlContent), 0644))
+
+	os.Setenv("TCPDOG_TEST_ES_URL", "http://10.0.0.1:9200")
+	os.Setenv("TCPDOG_TEST_WORKERS", "4")
+	os.Setenv("TCPDOG_TEST_ENV", "prod")
+	defer os.Unsetenv("TCPDOG_TEST_ES_URL")
+	defer os.Unsetenv("TCPDOG_TEST_WORKERS")
+	defer os.Unsetenv("TCPDOG_TEST_ENV")
+
+	cfg, err := loadServer(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"http://10.0.0.1:9200"}, cfg.Ingestion["elasticsearch"].Config["urls"])
+	assert.Equal(t, 4, cfg.Ingestion["elasticsearch"].Config["workers"])
+	assert.Equal(t, "tcpdog-prod", cfg.Ingestion["elasticsearch"].Config["index"])
+	assert.Equal(t, ":8085", cfg.Ingress["grpc01"].Config["addr"])
+
+	// set but empty variable with default
+	os.Setenv("TCPDOG_TEST_ADDR", "")
+	defer os.Unsetenv("TCPDOG_TEST_ADDR")
+	cfg, err = loadServer(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, ":8085", cfg.Ingress["grpc01"].Config["addr"])
+
+	// set but empty variable without default
+	os.Setenv("TCPDOG_TEST_ENV", "")
+	cfg, err = loadServer(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog-", cfg.Ingestion["elasticsearch"].Config["index"])
+
+	// unset variable without default
+	os.Unsetenv("TCPDOG_TEST_ES_URL")
+	_, err = loadServer(filename)
+	assert.EqualError(t, err, "environment variable TCPDOG_TEST_ES_URL is not set")
+}
+
+const watchConfig = `
+tracepoints:
+  - name: tcp:tcp_probe
+    fields: f
+    egress: console
+fields:
+  f:
+    - name: RTT
+egress:
+  console:
+    type: console
+`
+
+func TestWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "agent.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(watchConfig), 0644))
+
+	c, err := Get([]string{"tcpdog", "-config", file, "-duration", "60s"}, "0.0.0")
+	assert.NoError(t, err)
+	ms := c.SetMockLogger("watch")
+
+	// the user changes the loaded configuration
+	c.Fields["f"][0].Name = "rtt"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.Watch(ctx)
+
+	reload := func(content string) {
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+		assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	}
+
+	next := func() *ChangeSet {
+		select {
+		case cs := <-ch:
+			return cs
+		case <-time.After(5 * time.Second):
+			t.Fatal("config has not been reloaded")
+		}
+		return nil
+	}
+
+	content := strings.Replace(watchConfig, "    egress: console\n", "    workers: 2\n    egress: console\n", 1)
+	content = strings.Replace(content, "tcp:tcp_probe", "tcp:tcp_retransmit_skb", 1)
+	content += "  kafka:\n    type: kafka\n"
+	reload(content)
+
+	cs := next()
+	n := cs.Config
+	assert.Equal(t, "tcp:tcp_retransmit_skb", n.Tracepoints[0].Name)
+	assert.Equal(t, 2, n.Tracepoints[0].Workers)
+	assert.Equal(t, []int{4}, n.Tracepoints[0].INet)
+	assert.Equal(t, time.Minute, n.Duration)
+	assert.Equal(t, c.Logger(), n.Logger())
+
+	assert.Len(t, cs.AddedTracepoints, 1)
+	assert.Equal(t, "tcp:tcp_retransmit_skb", cs.AddedTracepoints[0].Name)
+	assert.Len(t, cs.RemovedTracepoints, 1)
+	assert.Equal(t, "tcp:tcp_probe", cs.RemovedTracepoints[0].Name)
+	assert.Equal(t, Diff{}, cs.Fields)
+	assert.Equal(t, Diff{Added: []string{"kafka"}}, cs.Egress)
+
+	// the fields and the egress have been changed
+	content = strings.Replace(content, "- name: RTT", "- name: SRTT", 1)
+	reload(strings.Replace(content, "type: kafka", "type: grpc", 1))
+
+	cs = next()
+	assert.Len(t, cs.AddedTracepoints, 1)
+	assert.Len(t, cs.RemovedTracepoints, 1)
+	assert.Equal(t, Diff{Changed: []string{"f"}}, cs.Fields)
+	assert.Equal(t, Diff{Changed: []string{"kafka"}}, cs.Egress)
+
+	// the previous configuration is kept
+	reload("tracepoints: [")
+
+	select {
+	case <-ch:
+		t.Fatal("unexpected config")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Contains(t, ms.String(), "reload has been failed")
+
+	// the tracepoint egress doesn't exist
+	reload(strings.Replace(content, "egress: console", "egress: foo", 1))
+
+	select {
+	case <-ch:
+		t.Fatal("unexpected config")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Contains(t, ms.String(), `unknown egress: \"foo\"`)
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	// the cli configuration isn't reloaded
+	c, err = Get([]string{"tcpdog"}, "0.0.0")
+	assert.NoError(t, err)
+
+	_, err = c.Reload()
+	assert.Error(t, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	ch = c.Watch(ctx)
+	cancel()
+	_, ok = <-ch
+	assert.False(t, ok)
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "agent.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(watchConfig), 0644))
+
+	c, err := Get([]string{"tcpdog", "-config", file}, "0.0.0")
+	assert.NoError(t, err)
+
+	// nothing has been changed
+	cs, err := c.Reload()
+	assert.NoError(t, err)
+	assert.True(t, cs.Empty())
+
+	// the egress has been removed
+	content := strings.Replace(watchConfig, "egress: console", "egress: kafka", 1)
+	content = strings.Replace(content, "console:\n    type: console", "kafka:\n    type: kafka", 1)
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+
+	cs, err = c.Reload()
+	assert.NoError(t, err)
+	assert.False(t, cs.Empty())
+	assert.Equal(t, Diff{Added: []string{"kafka"}, Removed: []string{"console"}}, cs.Egress)
+
+	// the fields of the tracepoint don't exist
+	assert.NoError(t, ioutil.WriteFile(file, []byte(strings.Replace(content, "fields: f", "fields: g", 1)), 0644))
+
+	_, err = c.Reload()
+	assert.EqualError(t, err, `invalid configuration: tracepoint tcp:tcp_probe has unknown fields group: "g"`)
+}
+
+func TestValidate(t *testing.T) {
+	// the registry is restored for the other tests
+	fieldRegistry.Lock()
+	names := fieldRegistry.names
+	fieldRegistry.names = map[string]struct{}{}
+	fieldRegistry.Unlock()
+
+	defer func() {
+		fieldRegistry.Lock()
+		fieldRegistry.names = names
+		fieldRegistry.Unlock()
+	}()
+
+	c := &Config{
+		Tracepoints: []Tracepoint{
+			{Name: "tcp:tcp_retransmit_skb", Fields: "fields01", INet: []int{4, 6}, Egress: "console"},
+			{Name: "tcp:tcp_probe", Fields: "fields02", INet: []int{4}, Egress: "console"},
+			{Name: "sock:inet_sock_set_state", Fields: "fields_01", INet: []int{5}, Egress: "console"},
+			{Name: "tcp:tcp_destroy_sock", Aggregate: &Aggregate{}, Egress: "console"},
+		},
+		Fields: map[string][]Field{
+			"fields01": {{Name: "SAddr"}, {Name: "rtt"}},
+			"fields02": {{Name: "RTT"}, {Name: "FooBar"}},
+		},
+		Egress: map[string]EgressConfig{
+			"console": {Type: "console"},
+		},
+	}
+
+	// the field names aren't validated without the registry
+	err := c.Validate()
+	assert.EqualError(t, err, `invalid configuration: tracepoint sock:inet_sock_set_state has wrong inet version: 5; `+
+		`tracepoint sock:inet_sock_set_state has unknown fields group: "fields_01"`)
+
+	RegisterFields("SAddr", "DAddr", "RTT")
+
+	err = c.Validate()
+	assert.EqualError(t, err, `invalid configuration: tracepoint sock:inet_sock_set_state has wrong inet version: 5; `+
+		`tracepoint sock:inet_sock_set_state has unknown fields group: "fields_01"; `+
+		`fields fields02 has unknown field: "FooBar"`)
+
+	c.Tracepoints = c.Tracepoints[:1]
+	delete(c.Fields, "fields02")
+	assert.NoError(t, c.Validate())
+
+	// the process filters
+	c.Tracepoints[0].PID, c.Tracepoints[0].PIDs, c.Tracepoints[0].Comm = -1, []int{5, 0}, "a-very-long-process"
+	assert.EqualError(t, c.Validate(), `invalid configuration: tracepoint tcp:tcp_retransmit_skb has wrong pid: -1; `+
+		`tracepoint tcp:tcp_retransmit_skb has wrong pid: 0; `+
+		`tracepoint tcp:tcp_retransmit_skb has too long comm: "a-very-long-process" (max 15)`)
+	c.Tracepoints[0].PID, c.Tracepoints[0].PIDs, c.Tracepoints[0].Comm = 0, nil, ""
+
+	// the port filters
+	c.Tracepoints[0].DPort, c.Tracepoints[0].SPort = Ports{"443", "9000-8000"}, Ports{"65536"}
+	assert.EqualError(t, c.Validate(), `invalid configuration: tracepoint tcp:tcp_retransmit_skb has wrong dport: invalid port or port range "9000-8000"; `+
+		`tracepoint tcp:tcp_retransmit_skb has wrong sport: invalid port or port range "65536"`)
+	c.Tracepoints[0].DPort, c.Tracepoints[0].SPort = nil, nil
+
+	// Get returns the aggregated error
+	file := filepath.Join(t.TempDir(), "agent.yml")
+	content := "tracepoints:\n  - name: tcp:tcp_probe\n    fields: f\n    inet: [4, 7]\n    egress: kafka\nfields:\n  f:\n    - name: Foo\n"
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+
+	_, err = Get([]string{"tcpdog", "-config", file}, "0.0.0")
+	assert.EqualError(t, err, `invalid configuration: tracepoint tcp:tcp_probe has wrong inet version: 7; `+
+		`tracepoint tcp:tcp_probe has unknown egress: "kafka"; `+
+		`fields f has unknown field: "Foo"`)
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	yml "gopkg.in/yaml.v3"
+)
+
+// envRegex matches ${VAR} and ${VAR:-default}, the default is used
+// if the variable is unset or empty as the shell does.
+var envRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv expands the environment variables of the yaml string
+// values, the keys and the comments are untouched. an unset variable
+// without a default is an error.
+func expandEnv(b []byte) ([]byte, error) {
+	if !envRegex.Match(b) {
+		return b, nil
+	}
+
+	node := &yml.Node{}
+	if err := yml.Unmarshal(b, node); err != nil {
+		return nil, err
+	}
+
+	if err := expandNode(node); err != nil {
+		return nil, err
+	}
+
+	return yml.Marshal(node)
+}
+
+func expandNode(n *yml.Node) error {
+	switch n.Kind {
+	case yml.ScalarNode:
+		if n.Tag != "!!str" || !envRegex.MatchString(n.Value) {
+			return nil
+		}
+
+		v, err := expandValue(n.Value)
+		if err != nil {
+			return err
+		}
+
+		n.Value = v
+		// a plain value is resolved again e.g. ${PORT} to int
+		if n.Style == 0 {
+			n.Tag = ""
+		}
+
+	case yml.MappingNode:
+		// skip the keys
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := expandNode(n.Content[i]); err != nil {
+				return err
+			}
+		}
+
+	default:
+		for _, c := range n.Content {
+			if err := expandNode(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func expandValue(s string) (string, error) {
+	var err error
+
+	s = envRegex.ReplaceAllStringFunc(s, func(m string) string {
+		sm := envRegex.FindStringSubmatch(m)
+		if v, ok := os.LookupEnv(sm[1]); ok && (v != "" || sm[2] == "") {
+			return v
+		}
+
+		if sm[2] != "" {
+			return sm[3]
+		}
+
+		if err == nil {
+			err = fmt.Errorf("environment variable %s is not set", sm[1])
+		}
+
+		return ""
+	})
+
+	return s, err
+}
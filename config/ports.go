@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	yml "gopkg.in/yaml.v3"
+)
+
+// Ports represents a port filter, the items are the ports or the port
+// ranges e.g. 443 or 8000-8100 and an item may have a comma separated
+// list of them. it's a yaml scalar or sequence.
+type Ports []string
+
+// PortRange represents an inclusive range of the ports
+type PortRange struct {
+	From int
+	To   int
+}
+
+// UnmarshalYAML decodes the scalar or the sequence of the ports
+func (p *Ports) UnmarshalYAML(value *yml.Node) error {
+	if value.Kind == yml.ScalarNode {
+		*p = Ports{value.Value}
+		return nil
+	}
+
+	var ports []string
+	if err := value.Decode(&ports); err != nil {
+		return err
+	}
+
+	*p = ports
+
+	return nil
+}
+
+// Ranges parses the ports, a port is the range of itself
+func (p Ports) Ranges() ([]PortRange, error) {
+	var ranges []PortRange
+
+	for _, item := range p {
+		for _, s := range strings.Split(item, ",") {
+			r, err := parsePortRange(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges, nil
+}
+
+func parsePortRange(s string) (PortRange, error) {
+	from, to := s, s
+	if i := strings.Index(s, "-"); i > 0 {
+		from, to = s[:i], s[i+1:]
+	}
+
+	f, err1 := strconv.Atoi(from)
+	t, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil || f < 1 || t > 65535 || f > t {
+		return PortRange{}, fmt.Errorf("invalid port or port range %q", s)
+	}
+
+	return PortRange{From: f, To: t}, nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yml "gopkg.in/yaml.v3"
+)
+
+func TestPortsUnmarshalYAML(t *testing.T) {
+	content := `
+- name: tcp:tcp_retransmit_skb
+  dport: 443
+  sport: 8000-8100
+- name: tcp:tcp_probe
+  dport: [80, 443, "8000-8100"]
+`
+	var tps []Tracepoint
+	assert.NoError(t, yml.Unmarshal([]byte(content), &tps))
+
+	assert.Equal(t, Ports{"443"}, tps[0].DPort)
+	assert.Equal(t, Ports{"8000-8100"}, tps[0].SPort)
+	assert.Equal(t, Ports{"80", "443", "8000-8100"}, tps[1].DPort)
+	assert.Nil(t, tps[1].SPort)
+
+	assert.Error(t, yml.Unmarshal([]byte("dport: {port: 443}"), &Tracepoint{}))
+}
+
+func TestPortsRanges(t *testing.T) {
+	ranges, err := Ports{"80", "443, 8000-8100"}.Ranges()
+	assert.NoError(t, err)
+	assert.Equal(t, []PortRange{{80, 80}, {443, 443}, {8000, 8100}}, ranges)
+
+	ranges, err = Ports(nil).Ranges()
+	assert.NoError(t, err)
+	assert.Nil(t, ranges)
+
+	for _, p := range []string{"", "0", "65536", "http", "100-80", "-80", "80-", "1-2-3"} {
+		_, err := Ports{p}.Ranges()
+		assert.Error(t, err, p)
+	}
+}
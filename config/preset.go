@@ -0,0 +1,111 @@
+package config
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	cli "github.com/urfave/cli/v2"
+	yml "gopkg.in/yaml.v3"
+)
+
+// stdout is the output of the print config
+var stdout io.Writer = os.Stdout
+
+// presets are the built-in configurations of the common cases, a
+// preset has an agent and a server part e.g. presets/retransmit-es.
+//
+//go:embed presets
+var presets embed.FS
+
+// Presets returns the built-in preset names
+func Presets() []string {
+	var names []string
+
+	entries, _ := fs.ReadDir(presets, "presets")
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// read reads the yaml configuration file, expands its preset
+// and then the environment variables.
+func read(file, part string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = expand(b, part)
+	if err != nil {
+		return nil, err
+	}
+
+	return expandEnv(b)
+}
+
+// printConfig prints the configuration with its expanded preset then exits
+func printConfig(file, part string) error {
+	b, err := read(file, part)
+	if err != nil {
+		return err
+	}
+
+	stdout.Write(b)
+	cli.OsExiter(0)
+
+	return nil
+}
+
+// expand expands the preset of the yaml configuration, the preset part
+// (agent or server) is the base which the configuration overrides. the
+// maps are merged and the other values e.g. the lists are replaced.
+func expand(b []byte, part string) ([]byte, error) {
+	m := map[string]interface{}{}
+	if err := yml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	name, ok := m["preset"]
+	if !ok {
+		return b, nil
+	}
+
+	p, err := presets.ReadFile(path.Join("presets", fmt.Sprint(name), part+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("preset %v is not available", name)
+	}
+
+	base := map[string]interface{}{}
+	if err := yml.Unmarshal(p, &base); err != nil {
+		return nil, err
+	}
+
+	return yml.Marshal(merge(base, m))
+}
+
+// merge merges the src into the dst recursively
+func merge(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				dst[k] = merge(dm, sm)
+				continue
+			}
+		}
+
+		dst[k] = v
+	}
+
+	return dst
+}
@@ -0,0 +1,32 @@
+package config
+
+// State represents a component lifecycle state
+type State string
+
+const (
+	// StateStarted means the component has been started
+	StateStarted State = "started"
+	// StateFailed means the component has been failed to start
+	StateFailed State = "failed"
+	// StateStopped means the component has been stopped
+	StateStopped State = "stopped"
+	// StateDegraded means the component is running partially
+	StateDegraded State = "degraded"
+)
+
+// Status represents a component lifecycle event
+type Status struct {
+	// Component is the component kind e.g. egress, tracepoint, ingress
+	Component string
+	// Name is the configured name of the component
+	Name  string
+	State State
+	Err   error
+}
+
+// StatusFunc receives the components lifecycle events
+type StatusFunc func(Status)
+
+// VerifyFunc receives the result of a component verification check,
+// the detail describes the passed check and err is the failure.
+type VerifyFunc func(check, detail string, err error)
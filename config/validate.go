@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxCommLen is the max process name length, TASK_COMM_LEN - 1
+const maxCommLen = 15
+
+// fieldRegistry is the known fields which the agent emits,
+// the ebpf package registers them thus the names aren't
+// validated if the registry hasn't been registered.
+var fieldRegistry = struct {
+	sync.RWMutex
+	names map[string]struct{}
+}{names: map[string]struct{}{}}
+
+// RegisterFields registers the known fields, the names are case-insensitive.
+func RegisterFields(names ...string) {
+	fieldRegistry.Lock()
+	defer fieldRegistry.Unlock()
+
+	for _, name := range names {
+		fieldRegistry.names[strings.ToLower(name)] = struct{}{}
+	}
+}
+
+func knownField(name string) (known bool, registered bool) {
+	fieldRegistry.RLock()
+	defer fieldRegistry.RUnlock()
+
+	_, known = fieldRegistry.names[strings.ToLower(name)]
+
+	return known, len(fieldRegistry.names) > 0
+}
+
+// validationError represents all of the configuration problems
+type validationError []string
+
+func (e validationError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e, "; "))
+}
+
+// Validate validates the tracepoints fields, egresses and inet versions, it
+// returns an error which lists all of the problems at once.
+func (c *Config) Validate() error {
+	var errs validationError
+
+	for _, tp := range c.Tracepoints {
+		for _, inet := range tp.INet {
+			if inet != 4 && inet != 6 {
+				errs = append(errs, fmt.Sprintf("tracepoint %s has wrong inet version: %d", tp.Name, inet))
+			}
+		}
+
+		if tp.PID < 0 {
+			errs = append(errs, fmt.Sprintf("tracepoint %s has wrong pid: %d", tp.Name, tp.PID))
+		}
+
+		for _, pid := range tp.PIDs {
+			if pid <= 0 {
+				errs = append(errs, fmt.Sprintf("tracepoint %s has wrong pid: %d", tp.Name, pid))
+			}
+		}
+
+		if len(tp.Comm) > maxCommLen {
+			errs = append(errs, fmt.Sprintf("tracepoint %s has too long comm: %q (max %d)", tp.Name, tp.Comm, maxCommLen))
+		}
+
+		if _, err := tp.DPort.Ranges(); err != nil {
+			errs = append(errs, fmt.Sprintf("tracepoint %s has wrong dport: %v", tp.Name, err))
+		}
+
+		if _, err := tp.SPort.Ranges(); err != nil {
+			errs = append(errs, fmt.Sprintf("tracepoint %s has wrong sport: %v", tp.Name, err))
+		}
+
+		if _, ok := c.Egress[tp.Egress]; !ok {
+			errs = append(errs, fmt.Sprintf("tracepoint %s has unknown egress: %q", tp.Name, tp.Egress))
+		}
+
+		// the custom and aggregate-in-kernel fields are made by the agent
+		if tp.Custom != nil || tp.Aggregate != nil {
+			continue
+		}
+
+		if _, ok := c.Fields[tp.Fields]; !ok {
+			errs = append(errs, fmt.Sprintf("tracepoint %s has unknown fields group: %q", tp.Name, tp.Fields))
+		}
+	}
+
+	groups := make([]string, 0, len(c.Fields))
+	for name := range c.Fields {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		for _, f := range c.Fields[group] {
+			if known, registered := knownField(f.Name); registered && !known {
+				errs = append(errs, fmt.Sprintf("fields %s has unknown field: %q", group, f.Name))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
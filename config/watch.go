@@ -0,0 +1,226 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// reloadSignal is the signal which reloads the configuration file
+var reloadSignal os.Signal = syscall.SIGHUP
+
+// ChangeSet represents the changes of a reloaded configuration, a
+// tracepoint which has been changed is removed and added again.
+type ChangeSet struct {
+	Config *Config
+
+	AddedTracepoints   []Tracepoint
+	RemovedTracepoints []Tracepoint
+
+	Fields Diff
+	Egress Diff
+}
+
+// Diff represents the added, removed and changed names of a section
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty returns true if nothing has been changed
+func (cs *ChangeSet) Empty() bool {
+	return len(cs.AddedTracepoints) == 0 && len(cs.RemovedTracepoints) == 0 &&
+		cs.Fields.empty() && cs.Egress.empty()
+}
+
+func (d Diff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Watch reloads the configuration file on SIGHUP and sends its change
+// set to the returned channel until the context is done. the file which
+// fails to load or to validate is logged and it isn't sent thus the
+// previous configuration is kept. the channel never receives if the
+// configuration has been built from the command line.
+func (c *Config) Watch(ctx context.Context) <-chan *ChangeSet {
+	ch := make(chan *ChangeSet, 1)
+
+	if c.file == "" {
+		go func() {
+			<-ctx.Done()
+			close(ch)
+		}()
+
+		return ch
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, reloadSignal)
+
+	go func() {
+		defer close(ch)
+		defer signal.Stop(sig)
+
+		current := c
+
+		for {
+			select {
+			case <-sig:
+			case <-ctx.Done():
+				return
+			}
+
+			cs, err := current.Reload()
+			if err != nil {
+				c.logger.Error("config", zap.String("msg", "reload has been failed, the previous configuration is kept"),
+					zap.String("file", c.file), zap.Error(err))
+				continue
+			}
+
+			if cs.Empty() {
+				c.logger.Info("config", zap.String("msg", c.file+" has not been changed"))
+				continue
+			}
+
+			c.logger.Info("config", zap.String("msg", c.file+" has been reloaded"))
+
+			select {
+			case ch <- cs:
+				current = cs.Config
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Reload loads the configuration file again and returns its changes
+// against this configuration, the invalid file returns the error.
+func (c *Config) Reload() (*ChangeSet, error) {
+	if c.file == "" {
+		return nil, errors.New("configuration has not been loaded from a file")
+	}
+
+	conf, err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	// this configuration may have been changed by its user
+	// e.g. the agent validation thus its source is compared.
+	prev, err := parse(c.source)
+	if err != nil {
+		return nil, err
+	}
+
+	prev.logger = c.logger
+	setDefault(prev)
+
+	return diff(prev, conf), nil
+}
+
+// reload loads the configuration file again, the logger, the
+// summary and the duration belong to the process and they're kept.
+func (c *Config) reload() (*Config, error) {
+	conf, err := load(c.file)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.file = c.file
+	conf.version = c.version
+	conf.logger = c.logger
+	conf.Summary = c.Summary
+	conf.Duration = c.Duration
+
+	setDefault(conf)
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// diff returns the changes of the tracepoints, the fields and the egresses
+func diff(prev, conf *Config) *ChangeSet {
+	cs := &ChangeSet{Config: conf}
+
+	keys := map[string]int{}
+	for _, tp := range prev.Tracepoints {
+		keys[tracepointKey(prev, tp)]++
+	}
+
+	for _, tp := range conf.Tracepoints {
+		key := tracepointKey(conf, tp)
+		if keys[key] > 0 {
+			keys[key]--
+			continue
+		}
+
+		cs.AddedTracepoints = append(cs.AddedTracepoints, tp)
+	}
+
+	for _, tp := range prev.Tracepoints {
+		key := tracepointKey(prev, tp)
+		if keys[key] > 0 {
+			keys[key]--
+			cs.RemovedTracepoints = append(cs.RemovedTracepoints, tp)
+		}
+	}
+
+	cs.Fields = diffSection(prev.Fields, conf.Fields)
+	cs.Egress = diffSection(prev.Egress, conf.Egress)
+
+	return cs
+}
+
+// tracepointKey returns the identity of a tracepoint and its fields
+func tracepointKey(conf *Config, tp Tracepoint) string {
+	b, _ := json.Marshal(struct {
+		Tracepoint Tracepoint
+		Fields     []Field
+	}{tp, conf.Fields[tp.Fields]})
+
+	return string(b)
+}
+
+// diffSection returns the changed names of two maps of a section
+func diffSection(prev, conf interface{}) Diff {
+	var (
+		d  Diff
+		pv = reflect.ValueOf(prev)
+		cv = reflect.ValueOf(conf)
+	)
+
+	for _, k := range cv.MapKeys() {
+		p := pv.MapIndex(k)
+		if !p.IsValid() {
+			d.Added = append(d.Added, k.String())
+		} else if !reflect.DeepEqual(p.Interface(), cv.MapIndex(k).Interface()) {
+			d.Changed = append(d.Changed, k.String())
+		}
+	}
+
+	for _, k := range pv.MapKeys() {
+		if !cv.MapIndex(k).IsValid() {
+			d.Removed = append(d.Removed, k.String())
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+
+	return d
+}
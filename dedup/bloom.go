@@ -0,0 +1,110 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var bloomMagic = [4]byte{'T', 'D', 'B', 'F'}
+
+// bloom represents a bloom filter of the 64 bits ids, the
+// k positions are derived from the id by double hashing.
+type bloom struct {
+	bits  []uint64
+	m     uint64
+	k     uint64
+	count uint64
+}
+
+// newBloom constructs a bloom filter which holds n ids
+// with the false positive rate p.
+func newBloom(n uint64, p float64) *bloom {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloom{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+func (b *bloom) add(id uint64) {
+	h1, h2 := id, id>>32|id<<32
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+	b.count++
+}
+
+func (b *bloom) has(id uint64) bool {
+	h1, h2 := id, id>>32|id<<32
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *bloom) reset() {
+	for i := range b.bits {
+		b.bits[i] = 0
+	}
+	b.count = 0
+}
+
+// writeTo encodes the filter: magic, m, k, count and the bits
+func (b *bloom) writeTo(w io.Writer) error {
+	header := make([]byte, 28)
+	copy(header, bloomMagic[:])
+	binary.LittleEndian.PutUint64(header[4:], b.m)
+	binary.LittleEndian.PutUint64(header[12:], b.k)
+	binary.LittleEndian.PutUint64(header[20:], b.count)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, b.bits)
+}
+
+func readBloom(r io.Reader) (*bloom, error) {
+	header := make([]byte, 28)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if string(header[:4]) != string(bloomMagic[:]) {
+		return nil, errors.New("invalid bloom filter")
+	}
+
+	b := &bloom{
+		m:     binary.LittleEndian.Uint64(header[4:]),
+		k:     binary.LittleEndian.Uint64(header[12:]),
+		count: binary.LittleEndian.Uint64(header[20:]),
+	}
+
+	if b.m < 64 || b.m > 1<<36 || b.k < 1 || b.k > 64 {
+		return nil, errors.New("invalid bloom filter")
+	}
+
+	b.bits = make([]uint64, (b.m+63)/64)
+	if err := binary.Read(r, binary.LittleEndian, b.bits); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,220 @@
+// Package dedup suppresses the events which have already been emitted,
+// e.g. the replayed events after an agent restart. It keeps the ids of
+// the recently emitted events in a bloom filter which is persisted to
+// the disk and loaded at the start.
+//
+// A bloom filter has no false negatives but it has false positives, so
+// rarely a new event is considered as a duplicate and it's dropped. the
+// probability is bounded by the configured fpr per generation, there are
+// two generations which are consulted thus the effective rate is up to
+// twice the fpr.
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+var idKey = []byte(`"EventID":"`)
+
+// Filter represents the recently emitted events, when the current
+// generation is full it replaces the previous one, so the last
+// capacity to 2*capacity events are remembered.
+type Filter struct {
+	sync.Mutex
+
+	file     string
+	capacity uint64
+
+	cur  *bloom
+	prev *bloom
+
+	dropped uint64
+}
+
+// New constructs a new filter
+func New(file string, capacity uint64, fpr float64) *Filter {
+	return &Filter{
+		file:     file,
+		capacity: capacity,
+		cur:      newBloom(capacity, fpr),
+		prev:     newBloom(capacity, fpr),
+	}
+}
+
+// ID returns the event id, the EventID is preferred if the event has
+// it otherwise it's the hash of the whole event. the Timestamp is part
+// of the identity thus the samples of a flow which have equal values
+// aren't duplicates, the KTime field makes it exact within a second.
+func ID(b []byte) uint64 {
+	if i := bytes.Index(b, idKey); i > 0 {
+		id := b[i+len(idKey):]
+		if j := bytes.IndexByte(id, '"'); j > 0 {
+			return xxhash.Sum64(id[:j])
+		}
+	}
+
+	return xxhash.Sum64(b)
+}
+
+// Has returns true if the event id has been emitted
+func (f *Filter) Has(id uint64) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.cur.has(id) || f.prev.has(id)
+}
+
+// Add adds the event id as emitted
+func (f *Filter) Add(id uint64) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.cur.count >= f.capacity {
+		f.prev.reset()
+		f.cur, f.prev = f.prev, f.cur
+	}
+
+	f.cur.add(id)
+}
+
+// Dropped returns the number of the suppressed events
+func (f *Filter) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// Load loads the persisted filter, the file is ignored if
+// it has been created with another capacity or fpr.
+func (f *Filter) Load() error {
+	r, err := os.Open(f.file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+
+	prev, err := readBloom(br)
+	if err != nil {
+		return err
+	}
+
+	cur, err := readBloom(br)
+	if err != nil {
+		return err
+	}
+
+	if cur.m != f.cur.m || cur.k != f.cur.k || prev.m != cur.m || prev.k != cur.k {
+		return errors.New("dedup file has different capacity or fpr")
+	}
+
+	f.Lock()
+	f.prev, f.cur = prev, cur
+	f.Unlock()
+
+	return nil
+}
+
+// Save persists the filter, it writes a temporary file and
+// renames it to keep the last one in case of failure.
+func (f *Filter) Save() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.file), filepath.Base(f.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+
+	f.Lock()
+	err = f.prev.writeTo(w)
+	if err == nil {
+		err = f.cur.writeTo(w)
+	}
+	f.Unlock()
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.file)
+}
+
+// Run passes the events which haven't been emitted from in to out,
+// the duplicates are put back to the pool.
+func (f *Filter) Run(ctx context.Context, bufPool *sync.Pool, in, out chan *bytes.Buffer) {
+	for {
+		select {
+		case buf := <-in:
+			id := ID(buf.Bytes())
+			if f.Has(id) {
+				atomic.AddUint64(&f.dropped, 1)
+				bufPool.Put(buf)
+				continue
+			}
+
+			select {
+			case out <- buf:
+				f.Add(id)
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync saves the filter every interval until the context is canceled
+func (f *Filter) Sync(ctx context.Context, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Save(); err != nil {
+				logger.Warn("dedup", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Start loads the persisted filter and starts to save it periodically,
+// the caller should save it once more at the end.
+func Start(ctx context.Context, cfg config.Dedup, logger *zap.Logger) *Filter {
+	f := New(cfg.File, cfg.Capacity, cfg.FPR)
+
+	err := f.Load()
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warn("dedup", zap.String("msg", "filter has not been loaded"), zap.Error(err))
+	}
+
+	go f.Sync(ctx, cfg.SyncInterval, logger)
+
+	return f
+}
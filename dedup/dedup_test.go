@@ -0,0 +1,114 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestID(t *testing.T) {
+	a := ID([]byte(`{"RTT":5,"Task":"curl","Timestamp":1616940000}`))
+	b := ID([]byte(`{"RTT":5,"Task":"curl","Timestamp":1616940060}`))
+	c := ID([]byte(`{"RTT":6,"Task":"curl","Timestamp":1616940000}`))
+
+	// the samples of a flow which have equal values aren't duplicates
+	assert.NotEqual(t, a, b)
+	assert.NotEqual(t, a, c)
+	assert.Equal(t, a, ID([]byte(`{"RTT":5,"Task":"curl","Timestamp":1616940000}`)))
+
+	// the event id is preferred
+	a = ID([]byte(`{"RTT":5,"EventID":"2fd4e1c67a2d28fc","Timestamp":1616940000}`))
+	b = ID([]byte(`{"RTT":6,"EventID":"2fd4e1c67a2d28fc","Timestamp":1616940000}`))
+	assert.Equal(t, a, b)
+}
+
+func TestFilterGenerations(t *testing.T) {
+	f := New("", 10, 0.01)
+
+	for i := uint64(0); i < 20; i++ {
+		f.Add(i)
+	}
+
+	// the previous generation is consulted
+	assert.True(t, f.Has(0))
+	assert.True(t, f.Has(19))
+
+	// the first generation has been replaced
+	f.Add(20)
+	assert.False(t, f.Has(0))
+	assert.True(t, f.Has(10))
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcpdog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dedup.bloom")
+	bufPool := &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+	run := func(events ...string) []string {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		f := New(file, 1000, 0.001)
+		err := f.Load()
+		if err != nil {
+			assert.True(t, os.IsNotExist(err))
+		}
+
+		in := make(chan *bytes.Buffer, len(events))
+		out := make(chan *bytes.Buffer, len(events))
+		go f.Run(ctx, bufPool, in, out)
+
+		for _, e := range events {
+			in <- bytes.NewBufferString(e)
+		}
+
+		var emitted []string
+		for i := 0; i < 50 && uint64(len(emitted))+f.Dropped() < uint64(len(events)); i++ {
+			select {
+			case buf := <-out:
+				emitted = append(emitted, buf.String())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		assert.NoError(t, f.Save())
+
+		return emitted
+	}
+
+	emitted := run(`{"RTT":5,"Timestamp":1616940000}`)
+	assert.Equal(t, []string{`{"RTT":5,"Timestamp":1616940000}`}, emitted)
+
+	// restart, the first event has been replayed
+	emitted = run(`{"RTT":5,"Timestamp":1616940000}`, `{"RTT":7,"Timestamp":1616940090}`)
+	assert.Equal(t, []string{`{"RTT":7,"Timestamp":1616940090}`}, emitted)
+}
+
+func TestLoadMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcpdog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dedup.bloom")
+
+	f := New(file, 1000, 0.001)
+	f.Add(1)
+	assert.NoError(t, f.Save())
+
+	f = New(file, 5000, 0.001)
+	assert.Error(t, f.Load())
+	assert.False(t, f.Has(1))
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("foo"), 0644))
+	assert.Error(t, New(file, 1000, 0.001).Load())
+}
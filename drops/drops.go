@@ -0,0 +1,212 @@
+// Package drops keeps the drop counters of the process lifetime per
+// stage (category) and component, e.g. the kernel lost samples of a
+// tracepoint or the dead-letters of an ingestion. the counters are the
+// authoritative source of the drops and the report is a snapshot of them
+// which is logged and written to a file at the graceful shutdown.
+package drops
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/safewriter"
+)
+
+// the drop categories, the name of a drop is the component
+// of the category e.g. the tracepoint, egress or ingestion.
+const (
+	// KernelLost is the perf buffer lost samples
+	KernelLost = "kernel_lost"
+	// AgentQueue is the agent egress channel drops
+	AgentQueue = "agent_queue"
+	// EgressPublish is the agent egress failures after the retries
+	EgressPublish = "egress_publish"
+	// EgressSpool is the spooled events which have been evicted by
+	// the spool size or age limit before they were replayed
+	EgressSpool = "egress_spool"
+	// ServerChannel is the server ingress channel drops
+	ServerChannel = "server_channel"
+	// IngestionDeadLetter is the records which the ingestion failed to write
+	IngestionDeadLetter = "ingestion_dead_letter"
+)
+
+// Categories is the drop categories in the pipeline order
+var Categories = []string{KernelLost, AgentQueue, EgressPublish, EgressSpool, ServerChannel, IngestionDeadLetter}
+
+type key struct {
+	category string
+	name     string
+}
+
+// counter is the drops of a component, the first and
+// the last are the unix nano timestamps of the drops.
+type counter struct {
+	count uint64
+	first int64
+	last  int64
+}
+
+var (
+	start    = time.Now()
+	counters sync.Map
+)
+
+// Drop represents the drops of a component
+type Drop struct {
+	Category string    `json:"category"`
+	Name     string    `json:"name"`
+	Count    uint64    `json:"count"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// Report represents the drops of the process lifetime
+type Report struct {
+	Start  time.Time         `json:"start"`
+	End    time.Time         `json:"end"`
+	Total  uint64            `json:"total"`
+	Totals map[string]uint64 `json:"totals"`
+	Drops  []Drop            `json:"drops"`
+}
+
+// Add adds n drops to the component counter of the category
+func Add(category, name string, n uint64) {
+	if n < 1 {
+		return
+	}
+
+	k := key{category, name}
+	v, ok := counters.Load(k)
+	if !ok {
+		v, _ = counters.LoadOrStore(k, &counter{})
+	}
+
+	c := v.(*counter)
+	now := time.Now().UnixNano()
+
+	atomic.CompareAndSwapInt64(&c.first, 0, now)
+	atomic.StoreInt64(&c.last, now)
+	atomic.AddUint64(&c.count, n)
+}
+
+// Count returns the drops of the component
+func Count(category, name string) uint64 {
+	v, ok := counters.Load(key{category, name})
+	if !ok {
+		return 0
+	}
+
+	return atomic.LoadUint64(&v.(*counter).count)
+}
+
+// Snapshot returns the report of the drops so far, the drops
+// are sorted by the category (pipeline order) and the name.
+func Snapshot() Report {
+	r := Report{Start: start, End: time.Now(), Totals: map[string]uint64{}, Drops: []Drop{}}
+
+	for _, category := range Categories {
+		r.Totals[category] = 0
+	}
+
+	counters.Range(func(k, v interface{}) bool {
+		var (
+			key = k.(key)
+			c   = v.(*counter)
+		)
+
+		d := Drop{
+			Category: key.category,
+			Name:     key.name,
+			Count:    atomic.LoadUint64(&c.count),
+			First:    time.Unix(0, atomic.LoadInt64(&c.first)),
+			Last:     time.Unix(0, atomic.LoadInt64(&c.last)),
+		}
+
+		r.Drops = append(r.Drops, d)
+		r.Totals[d.Category] += d.Count
+		r.Total += d.Count
+
+		return true
+	})
+
+	order := map[string]int{}
+	for i, category := range Categories {
+		order[category] = i
+	}
+
+	sort.Slice(r.Drops, func(i, j int) bool {
+		if r.Drops[i].Category != r.Drops[j].Category {
+			return order[r.Drops[i].Category] < order[r.Drops[j].Category]
+		}
+		return r.Drops[i].Name < r.Drops[j].Name
+	})
+
+	return r
+}
+
+// Finish logs the drops summary and writes the report to
+// the path if it's set, it's called at the graceful shutdown.
+func Finish(path string, logger *zap.Logger) {
+	r := Snapshot()
+
+	fields := []zap.Field{
+		zap.String("msg", "drop report"),
+		zap.Duration("uptime", r.End.Sub(r.Start).Truncate(time.Second)),
+		zap.Uint64("total", r.Total),
+	}
+	for _, category := range Categories {
+		fields = append(fields, zap.Uint64(category, r.Totals[category]))
+	}
+
+	logger.Info("drops", fields...)
+
+	for _, d := range r.Drops {
+		logger.Info("drops", zap.String("category", d.Category), zap.String("name", d.Name),
+			zap.Uint64("count", d.Count), zap.Time("first", d.First), zap.Time("last", d.Last))
+	}
+
+	if path == "" {
+		return
+	}
+
+	if err := write(path, r); err != nil {
+		logger.Error("drops", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// write replaces the report file atomically, the temporary file
+// of an interrupted write is removed and the report is synced
+// before the rename.
+func write(path string, r Report) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	w, err := safewriter.Create(tmp, safewriter.Options{Mode: safewriter.Line, Sync: safewriter.SyncAlways})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,123 @@
+package drops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func reset() {
+	counters.Range(func(k, _ interface{}) bool {
+		counters.Delete(k)
+		return true
+	})
+}
+
+func TestAdd(t *testing.T) {
+	reset()
+
+	before := time.Now()
+	Add(AgentQueue, "tcp:tcp_probe", 1)
+	Add(AgentQueue, "tcp:tcp_probe", 0)
+	time.Sleep(time.Millisecond)
+	Add(AgentQueue, "tcp:tcp_probe", 2)
+
+	assert.Equal(t, uint64(3), Count(AgentQueue, "tcp:tcp_probe"))
+	assert.Equal(t, uint64(0), Count(KernelLost, "tcp:tcp_probe"))
+
+	r := Snapshot()
+	assert.Len(t, r.Drops, 1)
+
+	d := r.Drops[0]
+	assert.False(t, d.First.Before(before))
+	assert.True(t, d.Last.After(d.First))
+}
+
+func TestSnapshot(t *testing.T) {
+	reset()
+
+	Add(IngestionDeadLetter, "ch", 10)
+	Add(KernelLost, "tcp:tcp_retransmit_skb", 5)
+	Add(ServerChannel, "grpc", 1)
+	Add(KernelLost, "tcp:tcp_probe", 2)
+
+	r := Snapshot()
+	assert.Equal(t, start, r.Start)
+	assert.Equal(t, uint64(18), r.Total)
+	assert.Equal(t, map[string]uint64{
+		KernelLost:          7,
+		AgentQueue:          0,
+		EgressPublish:       0,
+		EgressSpool:         0,
+		ServerChannel:       1,
+		IngestionDeadLetter: 10,
+	}, r.Totals)
+
+	var names []string
+	for _, d := range r.Drops {
+		names = append(names, d.Category+"/"+d.Name)
+	}
+	assert.Equal(t, []string{
+		"kernel_lost/tcp:tcp_probe",
+		"kernel_lost/tcp:tcp_retransmit_skb",
+		"server_channel/grpc",
+		"ingestion_dead_letter/ch",
+	}, names)
+}
+
+func TestFinish(t *testing.T) {
+	reset()
+
+	dir := t.TempDir()
+
+	Add(EgressPublish, "kafka", 3)
+
+	cfg := &config.Config{}
+	ms := cfg.SetMockLogger("drops")
+
+	path := filepath.Join(dir, "drops.json")
+	Finish(path, cfg.Logger())
+
+	assert.Contains(t, ms.String(), `"msg":"drop report"`)
+	assert.Contains(t, ms.String(), `"egress_publish":3`)
+	assert.Contains(t, ms.String(), `"name":"kafka"`)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	r := Report{}
+	assert.NoError(t, json.Unmarshal(b, &r))
+	assert.Equal(t, uint64(3), r.Total)
+	assert.Equal(t, uint64(3), r.Totals[EgressPublish])
+	assert.Len(t, r.Drops, 1)
+	assert.Equal(t, "kafka", r.Drops[0].Name)
+	assert.Equal(t, uint64(3), r.Drops[0].Count)
+	assert.False(t, r.Drops[0].First.IsZero())
+
+	// the report and the torn temporary file are replaced
+	assert.NoError(t, ioutil.WriteFile(path+".tmp", []byte(`{"start":`), 0644))
+	Add(EgressPublish, "kafka", 2)
+	Finish(path, cfg.Logger())
+
+	b, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(b, &r))
+	assert.Equal(t, uint64(5), r.Total)
+	assert.NoFileExists(t, path+".tmp")
+
+	// the report isn't written without a path
+	reset()
+	Finish("", zap.NewNop())
+
+	// the report of a missing directory is logged
+	ms = cfg.SetMockLogger("drops-error")
+	Finish(filepath.Join(dir, "missing", "drops.json"), cfg.Logger())
+	assert.Contains(t, ms.String(), "no such file or directory")
+}
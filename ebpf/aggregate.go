@@ -0,0 +1,529 @@
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+// aggSettle is the time which is given to the running bpf programs
+// to finish their updates once the generation has been switched.
+const aggSettle = 50 * time.Millisecond
+
+var aggKinds = map[string]string{
+	"count": "Count",
+	"sum":   "Sum",
+	"max":   "Max",
+	"min":   "Min",
+}
+
+// aggMetric represents an aggregation metric, count has no
+// field and it's kept at the beginning of the leaf.
+type aggMetric struct {
+	kind  string
+	field string
+	index int
+	slot  int
+}
+
+// aggregation represents the in-kernel aggregation of a tracepoint,
+// the bpf program updates a per-cpu hash map per generation and the
+// user space switches the generation every window then it reads and
+// deletes the previous one.
+type aggregation struct {
+	keys       []string
+	metrics    []aggMetric
+	fields     []string
+	window     time.Duration
+	maxEntries int
+}
+
+func (m aggMetric) name() string {
+	return aggKinds[m.kind] + m.field
+}
+
+func newAggregation(a *config.Aggregate) (*aggregation, error) {
+	agg := &aggregation{window: a.Window, maxEntries: a.MaxEntries}
+	index := map[string]int{}
+
+	if len(a.Key) < 1 {
+		return nil, errors.New("aggregate key is empty")
+	}
+
+	if len(a.Metrics) < 1 {
+		return nil, errors.New("aggregate metrics is empty")
+	}
+
+	for _, k := range a.Key {
+		f, err := ValidateField(k)
+		if err != nil {
+			return nil, err
+		}
+
+		if IsV6Only(f) {
+			return nil, fmt.Errorf("aggregate doesn't support the ipv6 only field %s", f)
+		}
+
+		if IsUserSpace(f) {
+			return nil, fmt.Errorf("aggregate doesn't support the user space field %s", f)
+		}
+
+		if _, ok := index[f]; ok {
+			return nil, fmt.Errorf("duplicate aggregate key: %s", f)
+		}
+
+		index[f] = len(agg.fields)
+		agg.keys = append(agg.keys, f)
+		agg.fields = append(agg.fields, f)
+	}
+
+	names := map[string]bool{}
+	slot := 0
+
+	for _, m := range a.Metrics {
+		metric := aggMetric{kind: strings.ToLower(m), slot: -1}
+		if i := strings.Index(m, ":"); i > 0 {
+			metric.kind, metric.field = strings.ToLower(m[:i]), m[i+1:]
+		}
+
+		switch metric.kind {
+		case "count":
+			if metric.field != "" {
+				return nil, fmt.Errorf("invalid aggregate metric: %s", m)
+			}
+		case "sum", "max", "min":
+			f, err := ValidateField(metric.field)
+			if err != nil {
+				return nil, err
+			}
+
+			if IsV6Only(f) {
+				return nil, fmt.Errorf("aggregate doesn't support the ipv6 only field %s", f)
+			}
+
+			attrs := fieldsModel4[f]
+			if attrs.DType != 0 || attrs.CType == char || attrs.CType == u128 || strings.HasPrefix(attrs.DS, "bpf_") || attrs.DS == userSpace {
+				return nil, fmt.Errorf("aggregate metric %s requires a numeric field", m)
+			}
+
+			if _, ok := index[f]; !ok {
+				index[f] = len(agg.fields)
+				agg.fields = append(agg.fields, f)
+			}
+
+			metric.field = f
+			metric.index = index[f]
+			metric.slot = slot
+			slot++
+		default:
+			return nil, fmt.Errorf("invalid aggregate metric: %s", m)
+		}
+
+		if names[metric.name()] {
+			return nil, fmt.Errorf("duplicate aggregate metric: %s", m)
+		}
+		names[metric.name()] = true
+
+		agg.metrics = append(agg.metrics, metric)
+	}
+
+	return agg, nil
+}
+
+// output returns the fields of the emitted records
+func (a *aggregation) output() []string {
+	fields := append([]string{}, a.keys...)
+	for _, m := range a.metrics {
+		fields = append(fields, m.name())
+	}
+
+	return append(fields, "Window")
+}
+
+// leafSize returns the size of the leaf (count and the metrics)
+func (a *aggregation) leafSize() int {
+	size := 8
+	for _, m := range a.metrics {
+		if m.slot >= 0 {
+			size += 8
+		}
+	}
+
+	return size
+}
+
+// ValidateAggregate validates the in-kernel aggregation
+// and returns the fields of the emitted records.
+func ValidateAggregate(a *config.Aggregate) ([]string, error) {
+	agg, err := newAggregation(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return agg.output(), nil
+}
+
+// member returns the data struct member of a field
+func member(f FieldAttrs, index int) string {
+	if f.CType == char {
+		return f.CField
+	}
+
+	return fmt.Sprintf("%s%d", f.CField, index)
+}
+
+// aggCommon returns the leaf struct, the generation and the overflow
+// counter declarations.
+func aggCommon(suffix int, agg *aggregation) string {
+	b := new(strings.Builder)
+
+	fmt.Fprintf(b, "struct agg_leaf%d_t {\n\t\tu64 count;\n", suffix)
+	for _, m := range agg.metrics {
+		if m.slot >= 0 {
+			fmt.Fprintf(b, "\t\tu64 m%d;\n", m.slot)
+		}
+	}
+	fmt.Fprintf(b, "\t};\n")
+	fmt.Fprintf(b, "\tBPF_ARRAY(agg_gen%d, u32, 1);\n", suffix)
+	fmt.Fprintf(b, "\tBPF_PERCPU_ARRAY(agg_overflow%d, u64, 1);", suffix)
+
+	return b.String()
+}
+
+// aggDecl returns the key struct and the maps declarations, the
+// key members are the same as the data struct's first members.
+func aggDecl(ipv int, suffix int, fields []FieldAttrs, agg *aggregation) string {
+	b := new(strings.Builder)
+
+	fmt.Fprintf(b, "struct ipv%d_agg_key%d_t {\n", ipv, suffix)
+	for i, f := range fields[:len(agg.keys)] {
+		if f.CType == char {
+			fmt.Fprintf(b, "\t\tchar %s[TASK_COMM_LEN];\n", member(f, i))
+		} else {
+			fmt.Fprintf(b, "\t\t%s %s;\n", f.CType, member(f, i))
+		}
+	}
+	fmt.Fprintf(b, "\t};\n")
+
+	for gen := 0; gen < 2; gen++ {
+		fmt.Fprintf(b, "\tBPF_PERCPU_HASH(ipv%d_agg%d_%d, struct ipv%d_agg_key%d_t, struct agg_leaf%d_t, %d);\n",
+			ipv, suffix, gen, ipv, suffix, suffix, agg.maxEntries)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// aggUpdate returns the code which updates the current generation,
+// the overflow counter is incremented once the map is full.
+func aggUpdate(ipv int, suffix int, fields []FieldAttrs, agg *aggregation) string {
+	b := new(strings.Builder)
+	data := fmt.Sprintf("data%d", ipv)
+
+	fmt.Fprintf(b, "struct ipv%d_agg_key%d_t key%d;\n", ipv, suffix, ipv)
+	fmt.Fprintf(b, "\t\t\t__builtin_memset(&key%d, 0, sizeof(key%d));\n", ipv, ipv)
+	for i, f := range fields[:len(agg.keys)] {
+		m := member(f, i)
+		fmt.Fprintf(b, "\t\t\t__builtin_memcpy(&key%d.%s, &%s.%s, sizeof(key%d.%s));\n", ipv, m, data, m, ipv, m)
+	}
+
+	fmt.Fprintf(b, "\t\t\tu32 agg_index = 0;\n")
+	fmt.Fprintf(b, "\t\t\tu32 *gen = agg_gen%d.lookup(&agg_index);\n", suffix)
+	fmt.Fprintf(b, "\t\t\tstruct agg_leaf%d_t zero_leaf = {}, *leaf;\n", suffix)
+	fmt.Fprintf(b, "\t\t\tif (gen && *gen) {\n")
+	fmt.Fprintf(b, "\t\t\t\tleaf = ipv%d_agg%d_1.lookup_or_try_init(&key%d, &zero_leaf);\n", ipv, suffix, ipv)
+	fmt.Fprintf(b, "\t\t\t} else {\n")
+	fmt.Fprintf(b, "\t\t\t\tleaf = ipv%d_agg%d_0.lookup_or_try_init(&key%d, &zero_leaf);\n", ipv, suffix, ipv)
+	fmt.Fprintf(b, "\t\t\t}\n")
+	fmt.Fprintf(b, "\t\t\tif (!leaf) {\n")
+	fmt.Fprintf(b, "\t\t\t\tagg_overflow%d.increment(agg_index);\n", suffix)
+	fmt.Fprintf(b, "\t\t\t\treturn 0;\n")
+	fmt.Fprintf(b, "\t\t\t}\n")
+	fmt.Fprintf(b, "\t\t\tleaf->count++;\n")
+
+	for _, m := range agg.metrics {
+		v := fmt.Sprintf("%s.%s", data, member(fields[m.index], m.index))
+		switch m.kind {
+		case "sum":
+			fmt.Fprintf(b, "\t\t\tleaf->m%d += %s;\n", m.slot, v)
+		case "max":
+			fmt.Fprintf(b, "\t\t\tif (%s > leaf->m%d)\n\t\t\t\tleaf->m%d = %s;\n", v, m.slot, m.slot, v)
+		case "min":
+			fmt.Fprintf(b, "\t\t\tif (leaf->count == 1 || %s < leaf->m%d)\n\t\t\t\tleaf->m%d = %s;\n", v, m.slot, m.slot, v)
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// merge merges the per-cpu values of a key, the min and max of the
+// cpus without any event are ignored.
+func (a *aggregation) merge(values []byte, ncpu int) (uint64, []uint64) {
+	var count uint64
+
+	stride := (a.leafSize() + 7) &^ 7
+	result := make([]uint64, len(a.metrics))
+	seen := false
+
+	for cpu := 0; cpu < ncpu; cpu++ {
+		leaf := values[cpu*stride:]
+		c := binary.LittleEndian.Uint64(leaf)
+		if c == 0 {
+			continue
+		}
+
+		count += c
+
+		for i, m := range a.metrics {
+			if m.slot < 0 {
+				continue
+			}
+
+			v := binary.LittleEndian.Uint64(leaf[8*(m.slot+1):])
+
+			switch m.kind {
+			case "sum":
+				result[i] += v
+			case "max":
+				if v > result[i] {
+					result[i] = v
+				}
+			case "min":
+				if !seen || v < result[i] {
+					result[i] = v
+				}
+			}
+		}
+
+		seen = true
+	}
+
+	for i, m := range a.metrics {
+		if m.kind == "count" {
+			result[i] = count
+		}
+	}
+
+	return count, result
+}
+
+// record writes a summary record: the keys, the metrics and the window
+// in milliseconds thus a window under a second e.g. a flush is kept.
+func (a *aggregation) record(d *decoder, key []byte, values []uint64, window time.Duration, buf *bytes.Buffer) error {
+	buf.WriteRune('{')
+	if err := d.decodeFields(key, a.keys, buf); err != nil {
+		return err
+	}
+
+	for i, m := range a.metrics {
+		buf.WriteRune('"')
+		buf.WriteString(m.name())
+		buf.WriteString(`":`)
+		buf.WriteString(strconv.FormatUint(values[i], 10))
+		buf.WriteRune(',')
+	}
+
+	buf.WriteString(`"Window":`)
+	buf.WriteString(strconv.FormatInt(int64(window/time.Millisecond), 10))
+	buf.WriteRune(',')
+
+	d.timestamp(buf)
+
+	return nil
+}
+
+// aggMap represents an aggregation map generation
+type aggMap interface {
+	drain(ncpu int, fn func(key, values []byte)) error
+}
+
+// aggMaps represents the generations of an ip version
+type aggMaps struct {
+	version int
+	gens    [2]aggMap
+	decoder *decoder
+}
+
+// aggregator switches the generation every window or once a flush
+// is requested and emits the summary records of the previous one,
+// the collection only happens in the run goroutine.
+type aggregator struct {
+	agg  *aggregation
+	tp   TP
+	ncpu int
+
+	gen interface {
+		updateUint32(key, value uint32) error
+	}
+	overflow interface {
+		sumUint64(key uint32, ncpu int) (uint64, error)
+	}
+	maps []*aggMaps
+
+	cur      uint32
+	overflew uint64
+	counter  *Counter
+	last     time.Time
+	flushCh  chan chan int
+	logger   *zap.Logger
+}
+
+// startAggregate starts the aggregator of the tracepoint
+func (b *BPF) startAggregate(ctx context.Context, tp TP, logger *zap.Logger) error {
+	agg, err := newAggregation(tp.Aggregate)
+	if err != nil {
+		return err
+	}
+
+	a := &aggregator{
+		agg:     agg,
+		tp:      tp,
+		counter: counter(tp),
+		last:    time.Now(),
+		flushCh: make(chan chan int),
+		logger:  logger,
+	}
+
+	a.ncpu, err = possibleCPUs()
+	if err != nil {
+		return err
+	}
+
+	a.gen, err = b.bpfMap(fmt.Sprintf("agg_gen%d", tp.Index))
+	if err != nil {
+		return err
+	}
+
+	a.overflow, err = b.bpfMap(fmt.Sprintf("agg_overflow%d", tp.Index))
+	if err != nil {
+		return err
+	}
+
+	for _, version := range tp.INet {
+		m := &aggMaps{version: version, decoder: newDecoder(logger, version == 4)}
+		for i := range m.gens {
+			m.gens[i], err = b.bpfMap(fmt.Sprintf("ipv%d_agg%d_%d", version, tp.Index, i))
+			if err != nil {
+				return err
+			}
+		}
+		a.maps = append(a.maps, m)
+	}
+
+	b.aggregators = append(b.aggregators, a)
+
+	go a.run(ctx)
+
+	return nil
+}
+
+// Flush emits the pending in-kernel aggregations immediately,
+// it returns the number of the emitted records.
+func (b *BPF) Flush(ctx context.Context) int {
+	n := 0
+	for _, a := range b.aggregators {
+		n += a.flush(ctx)
+	}
+
+	return n
+}
+
+func (a *aggregator) run(ctx context.Context) {
+	ticker := time.NewTicker(a.agg.window)
+	defer ticker.Stop()
+
+	idle := time.NewTicker(idleInterval)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-idle.C:
+			atomic.AddUint64(&progress, 1)
+		case <-ticker.C:
+			a.collect()
+		case r := <-a.flushCh:
+			r <- a.collect()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *aggregator) flush(ctx context.Context) int {
+	r := make(chan int, 1)
+
+	select {
+	case a.flushCh <- r:
+	case <-ctx.Done():
+		return 0
+	}
+
+	select {
+	case n := <-r:
+		return n
+	case <-ctx.Done():
+		return 0
+	}
+}
+
+// collect switches the generation, once the bpf programs finished
+// their updates it drains the previous generation which isn't
+// updated anymore. it returns the number of the emitted records.
+func (a *aggregator) collect() int {
+	n := 0
+
+	prev := a.cur
+	a.cur ^= 1
+
+	if err := a.gen.updateUint32(0, a.cur); err != nil {
+		a.logger.Error("ebpf", zap.String("msg", "aggregate generation"), zap.Error(err))
+		a.cur = prev
+		return 0
+	}
+
+	time.Sleep(aggSettle)
+
+	now := time.Now()
+	window := now.Sub(a.last).Round(time.Millisecond)
+	a.last = now
+
+	for _, m := range a.maps {
+		err := m.gens[prev].drain(a.ncpu, func(key, values []byte) {
+			_, result := a.agg.merge(values, a.ncpu)
+
+			buf := a.tp.BufPool.Get().(*bytes.Buffer)
+			buf.Reset()
+			if err := a.agg.record(m.decoder, key, result, window, buf); err != nil {
+				a.logger.Warn("ebpf", zap.String("tracepoint", a.tp.Name), zap.Error(err))
+				a.tp.BufPool.Put(buf)
+				return
+			}
+			n++
+
+			a.counter.emit(a.tp.OutChan, buf, a.logger)
+		})
+		if err != nil {
+			a.logger.Error("ebpf", zap.String("msg", "aggregate drain"), zap.Error(err))
+		}
+	}
+
+	total, err := a.overflow.sumUint64(0, a.ncpu)
+	if err == nil && total > a.overflew {
+		a.logger.Warn("ebpf", zap.String("msg", "aggregate map is full"),
+			zap.String("tracepoint", a.tp.Name), zap.Uint64("dropped", total-a.overflew))
+		drops.Add(drops.KernelLost, a.tp.Name, total-a.overflew)
+		a.overflew = total
+	}
+
+	return n
+}
@@ -0,0 +1,221 @@
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestNewAggregation(t *testing.T) {
+	agg, err := newAggregation(&config.Aggregate{
+		Window:  10 * time.Second,
+		Key:     []string{"saddr", "DAddr", "DPort"},
+		Metrics: []string{"count", "sum:RTT", "max:RTT", "min:TotalRetrans"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SAddr", "DAddr", "DPort"}, agg.keys)
+	assert.Equal(t, []string{"SAddr", "DAddr", "DPort", "RTT", "TotalRetrans"}, agg.fields)
+	assert.Equal(t, []string{"SAddr", "DAddr", "DPort", "Count", "SumRTT", "MaxRTT", "MinTotalRetrans", "Window"}, agg.output())
+	assert.Equal(t, 32, agg.leafSize())
+
+	tests := []config.Aggregate{
+		{Metrics: []string{"count"}},
+		{Key: []string{"DAddr"}},
+		{Key: []string{"DAddr", "daddr"}, Metrics: []string{"count"}},
+		{Key: []string{"Foo"}, Metrics: []string{"count"}},
+		{Key: []string{"DAddr"}, Metrics: []string{"avg:RTT"}},
+		{Key: []string{"DAddr"}, Metrics: []string{"sum:SAddr"}},
+		{Key: []string{"DAddr"}, Metrics: []string{"sum:Task"}},
+		{Key: []string{"DAddr"}, Metrics: []string{"count:RTT"}},
+		{Key: []string{"DAddr"}, Metrics: []string{"sum:RTT", "sum:rtt"}},
+	}
+
+	for _, tt := range tests {
+		_, err := newAggregation(&tt)
+		assert.Error(t, err, tt)
+	}
+}
+
+func TestAggregationMerge(t *testing.T) {
+	agg, err := newAggregation(&config.Aggregate{
+		Key:     []string{"DPort"},
+		Metrics: []string{"count", "sum:RTT", "max:RTT", "min:RTT"},
+	})
+	assert.NoError(t, err)
+
+	// count, sum, max and min per cpu, the second cpu has no event
+	values := make([]byte, 3*agg.leafSize())
+	for i, v := range []uint64{2, 30, 20, 10, 0, 0, 0, 0, 3, 15, 8, 2} {
+		binary.LittleEndian.PutUint64(values[i*8:], v)
+	}
+
+	count, result := agg.merge(values, 3)
+	assert.Equal(t, uint64(5), count)
+	assert.Equal(t, []uint64{5, 45, 20, 2}, result)
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	agg.record(d, []byte{0x1, 0xbb}, result, 10*time.Second, buf)
+
+	assert.Regexp(t, `^{"DPort":443,"Count":5,"SumRTT":45,"MaxRTT":20,"MinRTT":2,"Window":10000,"Timestamp":\d+}$`, buf.String())
+}
+
+func TestGetBPFCodeAggregate(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "tcp:tcp_probe",
+			TCPState: "TCP_ALL",
+			INet:     []int{4, 6},
+			Aggregate: &config.Aggregate{
+				Key:        []string{"SAddr", "DAddr", "DPort"},
+				Metrics:    []string{"count", "sum:RTT", "max:RTT"},
+				MaxEntries: 1024,
+			},
+		}},
+	})
+
+	assert.NoError(t, err)
+	assert.NotContains(t, source, "perf_submit")
+	assert.NotContains(t, source, "BPF_PERF_OUTPUT")
+
+	assert.Contains(t, source, "u64 m0;")
+	assert.Contains(t, source, "u64 m1;")
+	assert.Contains(t, source, "BPF_ARRAY(agg_gen0, u32, 1);")
+	assert.Contains(t, source, "BPF_PERCPU_ARRAY(agg_overflow0, u64, 1);")
+	assert.Contains(t, source, "agg_overflow0.increment(agg_index);")
+
+	// v4
+	assert.Contains(t, source, "u32 skc_daddr1;\n")
+	assert.Contains(t, source, "BPF_PERCPU_HASH(ipv4_agg0_0, struct ipv4_agg_key0_t, struct agg_leaf0_t, 1024);")
+	assert.Contains(t, source, "BPF_PERCPU_HASH(ipv4_agg0_1, struct ipv4_agg_key0_t, struct agg_leaf0_t, 1024);")
+	assert.Contains(t, source, "__builtin_memcpy(&key4.skc_dport2, &data4.skc_dport2, sizeof(key4.skc_dport2));")
+	assert.Contains(t, source, "leaf = ipv4_agg0_1.lookup_or_try_init(&key4, &zero_leaf);")
+	assert.Contains(t, source, "leaf->m0 += data4.srtt_us3;")
+	assert.Contains(t, source, "if (data4.srtt_us3 > leaf->m1)")
+
+	// v6
+	assert.Contains(t, source, "unsigned __int128 skc_v6_daddr1;\n")
+	assert.Contains(t, source, "BPF_PERCPU_HASH(ipv6_agg0_0, struct ipv6_agg_key0_t, struct agg_leaf0_t, 1024);")
+	assert.Contains(t, source, "leaf->m0 += data6.srtt_us3;")
+}
+
+func TestParseCPUs(t *testing.T) {
+	tests := []struct {
+		s string
+		n int
+	}{
+		{"0\n", 1},
+		{"0-7\n", 8},
+		{"0-3,8-11", 12},
+		{"0,2", 3},
+	}
+
+	for _, tt := range tests {
+		n, err := parseCPUs(tt.s)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.n, n)
+	}
+
+	_, err := parseCPUs("")
+	assert.Error(t, err)
+}
+
+type fakeAggMap struct {
+	entries map[string][]byte
+}
+
+func (m *fakeAggMap) drain(ncpu int, fn func(key, values []byte)) error {
+	for k, v := range m.entries {
+		fn([]byte(k), v)
+		delete(m.entries, k)
+	}
+
+	return nil
+}
+
+type fakeGen struct {
+	cur uint32
+}
+
+func (g *fakeGen) updateUint32(key, value uint32) error {
+	g.cur = value
+	return nil
+}
+
+func (g *fakeGen) sumUint64(key uint32, ncpu int) (uint64, error) {
+	return 0, nil
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	agg, err := newAggregation(&config.Aggregate{
+		Window:  time.Hour,
+		Key:     []string{"DPort"},
+		Metrics: []string{"count", "sum:RTT"},
+	})
+	assert.NoError(t, err)
+
+	leaf := func(count, sum uint64) []byte {
+		b := make([]byte, 16)
+		binary.LittleEndian.PutUint64(b, count)
+		binary.LittleEndian.PutUint64(b[8:], sum)
+		return b
+	}
+
+	gens := [2]*fakeAggMap{
+		{entries: map[string][]byte{"\x01\xbb": leaf(2, 10), "\x00\x50": leaf(1, 5)}},
+		{entries: map[string][]byte{}},
+	}
+
+	ch := make(chan *bytes.Buffer, 10)
+	gen := &fakeGen{}
+	a := &aggregator{
+		agg:      agg,
+		ncpu:     1,
+		gen:      gen,
+		overflow: gen,
+		maps:     []*aggMaps{{version: 4, gens: [2]aggMap{gens[0], gens[1]}, decoder: newDecoder(nil, true)}},
+		last:     time.Now(),
+		flushCh:  make(chan chan int),
+		logger:   zap.NewNop(),
+		counter:  &Counter{},
+		tp: TP{
+			Name:    "tcp:tcp_probe",
+			BufPool: &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+			OutChan: ch,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go a.run(ctx)
+
+	// the buffered aggregates are emitted without waiting for the window
+	assert.Equal(t, 2, a.flush(ctx))
+	assert.Equal(t, uint32(1), gen.cur)
+	assert.Len(t, gens[0].entries, 0)
+
+	var records []string
+	for i := 0; i < 2; i++ {
+		records = append(records, (<-ch).String())
+	}
+	// the window under a second is kept
+	assert.Regexp(t, `{"DPort":443,"Count":2,"SumRTT":10,"Window":[1-9]\d*,`, records[0]+records[1])
+	assert.Regexp(t, `{"DPort":80,"Count":1,"SumRTT":5,"Window":[1-9]\d*,`, records[0]+records[1])
+
+	// the next generation
+	gens[1].entries["\x01\xbb"] = leaf(1, 1)
+	assert.Equal(t, 1, a.flush(ctx))
+	assert.Equal(t, uint32(0), gen.cur)
+
+	cancel()
+	assert.Equal(t, 0, a.flush(ctx))
+}
@@ -3,10 +3,13 @@ package ebpf
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	bpf "github.com/iovisor/gobpf/bcc"
+	"github.com/iovisor/gobpf/elf"
 	"go.uber.org/zap"
 
 	"github.com/mehrdadrad/tcpdog/config"
@@ -14,8 +17,14 @@ import (
 
 // BPF represents eBPF procedures.
 type BPF struct {
-	m        *bpf.Module
-	perfMaps []*bpf.PerfMap
+	m           *bpf.Module
+	perfMaps    []*bpf.PerfMap
+	aggregators []*aggregator
+	customs     []*bpf.Module
+	objects     []*elf.Module
+	objectMaps  []*elf.PerfMap
+	lost        []chan uint64
+	stopped     chan struct{}
 }
 
 // TP represents a tracepoint
@@ -27,66 +36,131 @@ type TP struct {
 	Workers int
 	INet    []int
 	Fields  []string
+
+	Aggregate *config.Aggregate
+	Custom    *config.Custom
 }
 
 // New generates and loads the bpf program.
-func New(conf *config.Config) *BPF {
+func New(conf *config.Config) (*BPF, error) {
 	code, err := GetBPFCode(conf)
 	if err != nil {
-		conf.Logger().Fatal("ebpf", zap.Error(err))
+		return nil, err
 	}
 
+	setDSCPNames(conf.DSCPNames)
+
 	m := bpf.NewModule(code, []string{})
+	if m == nil {
+		return nil, errors.New("failed to compile the bpf program")
+	}
+
+	if requiredFields(conf)["InitCwnd"] {
+		if err := attachInitCwndClose(m); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+
+	if ownerFilter(conf) {
+		if err := attachSkOwner(m); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+
+	return &BPF{m: m, stopped: make(chan struct{})}, nil
+}
 
-	return &BPF{m: m}
+// attachInitCwndClose attaches the cleanup of the initial
+// congestion windows of the closed sockets.
+func attachInitCwndClose(m *bpf.Module) error {
+	fd, err := m.LoadTracepoint(initCwndClose)
+	if err != nil {
+		return err
+	}
+
+	return m.AttachTracepoint(initCwndTracepoint, fd)
+}
+
+// attachSkOwner attaches the programs which keep
+// the owners of the sockets for the pid and comm filters.
+func attachSkOwner(m *bpf.Module) error {
+	fd, err := m.LoadTracepoint(skOwnerState)
+	if err != nil {
+		return err
+	}
+
+	if err := m.AttachTracepoint(skOwnerTracepoint, fd); err != nil {
+		return err
+	}
+
+	fd, err = m.LoadKprobe(skOwnerAccept)
+	if err != nil {
+		return err
+	}
+
+	return m.AttachKretprobe(skOwnerKretprobe, fd, -1)
 }
 
 // Start loads and attaches tracepoint and approperiate channel
-func (b *BPF) Start(ctx context.Context, tp TP) {
+func (b *BPF) Start(ctx context.Context, tp TP) error {
 	logger := config.FromContext(ctx).Logger()
 
+	if tp.Custom != nil {
+		return b.startCustom(ctx, tp, logger)
+	}
+
 	trace, err := b.m.LoadTracepoint(fmt.Sprintf("sk_trace%d", tp.Index))
 	if err != nil {
-		logger.Fatal("ebpf", zap.Error(err))
+		return err
 	}
 
 	if err := b.m.AttachTracepoint(tp.Name, trace); err != nil {
-		logger.Fatal("ebpf", zap.Error(err))
+		return err
 	}
 
 	logger.Info("ebpf", zap.String("msg", tp.Name+" has been attached"))
 
+	if tp.Aggregate != nil {
+		return b.startAggregate(ctx, tp, logger)
+	}
+
+	c := counter(tp)
+	p := newPresence(tp)
+
 	for _, version := range tp.INet {
 		table := bpf.NewTable(b.m.TableId(fmt.Sprintf("ipv%d_events%d", version, tp.Index)), b.m)
-		ch := make(chan []byte, 1000)
+		ch := b.sampleChan(ctx)
 
-		perfMap, err := bpf.InitPerfMap(table, ch, nil)
+		perfMap, err := bpf.InitPerfMap(table, ch, b.lostChan(tp.Name))
 		if err != nil {
-			logger.Fatal("ebpf", zap.Error(err))
+			return err
 		}
 
 		for i := 0; i < tp.Workers; i++ {
 			go func(version int) {
-				var data []byte
-
 				d := newDecoder(logger, (version == 4))
 
+				idle := time.NewTicker(idleInterval)
+				defer idle.Stop()
+
 				for {
-					select {
-					case data = <-ch:
-					case <-ctx.Done():
+					data, ok := next(ctx, ch, idle)
+					if !ok {
 						return
 					}
 
 					buf := tp.BufPool.Get().(*bytes.Buffer)
 					buf.Reset()
-					d.decode(data, tp.Fields, buf)
-
-					select {
-					case tp.OutChan <- buf:
-					default:
-						logger.Warn("ebpf", zap.String("msg", "egress channel maxed out"))
+					if err := d.decode(data, tp.Fields, buf); err != nil {
+						logger.Warn("ebpf", zap.String("tracepoint", tp.Name), zap.Error(err))
+						tp.BufPool.Put(buf)
+						continue
 					}
+					p.merge(d.present)
+
+					c.emit(tp.OutChan, buf, logger)
 				}
 			}(version)
 		}
@@ -94,6 +168,8 @@ func (b *BPF) Start(ctx context.Context, tp TP) {
 		perfMap.Start()
 		b.perfMaps = append(b.perfMaps, perfMap)
 	}
+
+	return nil
 }
 
 // Close cleans up BPF attachments
@@ -101,5 +177,20 @@ func (b *BPF) Close() {
 	for _, perfMap := range b.perfMaps {
 		perfMap.Stop()
 	}
+	for _, perfMap := range b.objectMaps {
+		perfMap.PollStop()
+	}
+	if b.stopped != nil {
+		close(b.stopped)
+	}
+	for _, ch := range b.lost {
+		close(ch)
+	}
+	for _, m := range b.customs {
+		m.Close()
+	}
+	for _, m := range b.objects {
+		m.Close()
+	}
 	b.m.Close()
 }
@@ -44,13 +44,14 @@ func TestStart(t *testing.T) {
 		},
 	}
 
-	eBPF := New(cfg)
+	eBPF, err := New(cfg)
+	assert.NoError(t, err)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	ctx = cfg.WithContext(ctx)
 	ch := make(chan *bytes.Buffer, 10)
 
-	eBPF.Start(ctx, TP{
+	err = eBPF.Start(ctx, TP{
 		Name:    "sock:inet_sock_set_state",
 		BufPool: bufPool,
 		OutChan: ch,
@@ -59,6 +60,7 @@ func TestStart(t *testing.T) {
 		INet:    []int{4},
 		Fields:  []string{"RTT"},
 	})
+	assert.NoError(t, err)
 
 	// create a tcp connection
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,170 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	bpf "github.com/iovisor/gobpf/bcc"
+	"golang.org/x/sys/unix"
+)
+
+// the bpf syscall commands, gobpf doesn't support the
+// per-cpu maps values which are per possible cpu.
+const (
+	bpfMapLookupElem = 1
+	bpfMapUpdateElem = 2
+	bpfMapDeleteElem = 3
+	bpfMapGetNextKey = 4
+)
+
+type bpfAttrMapElem struct {
+	mapFD uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// bpfMap represents a bpf map which is accessed by the syscalls
+type bpfMap struct {
+	fd       int
+	keySize  int
+	leafSize int
+}
+
+func (b *BPF) bpfMap(name string) (*bpfMap, error) {
+	cfg := bpf.NewTable(b.m.TableId(name), b.m).Config()
+
+	fd, ok1 := cfg["fd"].(int)
+	keySize, ok2 := cfg["key_size"].(uint64)
+	leafSize, ok3 := cfg["leaf_size"].(uint64)
+	if !ok1 || !ok2 || !ok3 || fd < 0 {
+		return nil, fmt.Errorf("bpf map %s not found", name)
+	}
+
+	return &bpfMap{fd: fd, keySize: int(keySize), leafSize: int(leafSize)}, nil
+}
+
+func (m *bpfMap) call(cmd int, key, value []byte, flags uint64) error {
+	attr := bpfAttrMapElem{mapFD: uint32(m.fd), flags: flags}
+	if len(key) > 0 {
+		attr.key = uint64(uintptr(unsafe.Pointer(&key[0])))
+	}
+	if len(value) > 0 {
+		attr.value = uint64(uintptr(unsafe.Pointer(&value[0])))
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func (m *bpfMap) updateUint32(key, value uint32) error {
+	k, v := make([]byte, 4), make([]byte, 4)
+	binary.LittleEndian.PutUint32(k, key)
+	binary.LittleEndian.PutUint32(v, value)
+
+	return m.call(bpfMapUpdateElem, k, v, 0)
+}
+
+// sumUint64 returns the sum of a per-cpu array element
+func (m *bpfMap) sumUint64(key uint32, ncpu int) (uint64, error) {
+	var sum uint64
+
+	k, v := make([]byte, 4), make([]byte, ncpu*8)
+	binary.LittleEndian.PutUint32(k, key)
+
+	if err := m.call(bpfMapLookupElem, k, v, 0); err != nil {
+		return 0, err
+	}
+
+	for cpu := 0; cpu < ncpu; cpu++ {
+		sum += binary.LittleEndian.Uint64(v[cpu*8:])
+	}
+
+	return sum, nil
+}
+
+// drain reads and deletes all the elements of a per-cpu hash map,
+// the map shouldn't be updated in the meantime.
+func (m *bpfMap) drain(ncpu int, fn func(key, values []byte)) error {
+	var (
+		keys [][]byte
+		key  []byte
+	)
+
+	for {
+		next := make([]byte, m.keySize)
+		err := m.call(bpfMapGetNextKey, key, next, 0)
+		if err == unix.ENOENT {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		keys = append(keys, next)
+		key = next
+	}
+
+	values := make([]byte, ncpu*((m.leafSize+7)&^7))
+	for _, key := range keys {
+		if err := m.call(bpfMapLookupElem, key, values, 0); err != nil {
+			continue
+		}
+
+		fn(key, values)
+
+		if err := m.call(bpfMapDeleteElem, key, nil, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// possibleCPUs returns the number of the possible cpus,
+// the per-cpu maps have a value per possible cpu.
+func possibleCPUs() (int, error) {
+	b, err := ioutil.ReadFile("/sys/devices/system/cpu/possible")
+	if err != nil {
+		return 0, err
+	}
+
+	return parseCPUs(string(b))
+}
+
+// parseCPUs parses the cpu list format e.g. 0-3,5
+func parseCPUs(s string) (int, error) {
+	n := -1
+
+	for _, r := range strings.Split(strings.TrimSpace(s), ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		v, err := strconv.Atoi(bounds[len(bounds)-1])
+		if err != nil {
+			return 0, err
+		}
+
+		if v > n {
+			n = v
+		}
+	}
+
+	if n < 0 {
+		return 0, errors.New("invalid cpu list")
+	}
+
+	return n + 1, nil
+}
@@ -0,0 +1,173 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// btfPath is the kernel BTF which describes the running kernel types
+var btfPath = "/sys/kernel/btf/vmlinux"
+
+const btfMagic = 0xeb9f
+
+// the BTF kinds which are needed to walk the types section
+const (
+	btfInt      = 1
+	btfArray    = 3
+	btfStruct   = 4
+	btfUnion    = 5
+	btfEnum     = 6
+	btfFuncProt = 13
+	btfVar      = 14
+	btfDataSec  = 15
+	btfDeclTag  = 17
+	btfEnum64   = 19
+)
+
+// btfTypes represents the requested struct members and enum values
+// of the kernel BTF, the other types are skipped.
+type btfTypes struct {
+	members map[string]map[string]bool
+	enums   map[string]map[string]int64
+}
+
+// hasMembers returns true if the struct has all the members
+func (t *btfTypes) hasMembers(name string, members ...string) bool {
+	for _, m := range members {
+		if !t.members[name][m] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// enum returns the value of the enum constant
+func (t *btfTypes) enum(name, constant string) (int64, bool) {
+	v, ok := t.enums[name][constant]
+	return v, ok
+}
+
+// loadBTF reads the requested structs and enums of the BTF file
+func loadBTF(path string, structs, enums []string) (*btfTypes, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBTF(b, structs, enums)
+}
+
+func parseBTF(b []byte, structs, enums []string) (*btfTypes, error) {
+	var hdr struct {
+		Magic   uint16
+		Version uint8
+		Flags   uint8
+		HdrLen  uint32
+		TypeOff uint32
+		TypeLen uint32
+		StrOff  uint32
+		StrLen  uint32
+	}
+
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr.Magic != btfMagic {
+		return nil, errors.New("btf: invalid magic or not little endian")
+	}
+
+	body := b[hdr.HdrLen:]
+	if uint64(hdr.TypeOff)+uint64(hdr.TypeLen) > uint64(len(body)) ||
+		uint64(hdr.StrOff)+uint64(hdr.StrLen) > uint64(len(body)) {
+		return nil, errors.New("btf: truncated")
+	}
+
+	types := body[hdr.TypeOff : hdr.TypeOff+hdr.TypeLen]
+	strs := body[hdr.StrOff : hdr.StrOff+hdr.StrLen]
+
+	name := func(off uint32) string {
+		if int(off) >= len(strs) {
+			return ""
+		}
+		if i := bytes.IndexByte(strs[off:], 0); i >= 0 {
+			return string(strs[off : int(off)+i])
+		}
+		return ""
+	}
+
+	t := &btfTypes{
+		members: map[string]map[string]bool{},
+		enums:   map[string]map[string]int64{},
+	}
+
+	wanted := map[string]bool{}
+	for _, s := range append(structs, enums...) {
+		wanted[s] = true
+	}
+
+	le := binary.LittleEndian
+
+	for len(types) > 0 {
+		if len(types) < 12 {
+			return nil, errors.New("btf: truncated type")
+		}
+
+		var (
+			tName = name(le.Uint32(types))
+			info  = le.Uint32(types[4:])
+			kind  = info >> 24 & 0x1f
+			vlen  = int(info & 0xffff)
+			size  int
+		)
+
+		types = types[12:]
+
+		switch kind {
+		case btfInt, btfVar, btfDeclTag:
+			size = 4
+		case btfArray:
+			size = 12
+		case btfStruct, btfUnion, btfDataSec, btfEnum64:
+			size = vlen * 12
+		case btfEnum, btfFuncProt:
+			size = vlen * 8
+		}
+
+		if len(types) < size {
+			return nil, fmt.Errorf("btf: truncated %s", tName)
+		}
+
+		if wanted[tName] {
+			switch kind {
+			case btfStruct, btfUnion:
+				m := map[string]bool{}
+				for i := 0; i < vlen; i++ {
+					m[name(le.Uint32(types[i*12:]))] = true
+				}
+				t.members[tName] = m
+			case btfEnum:
+				e := map[string]int64{}
+				for i := 0; i < vlen; i++ {
+					e[name(le.Uint32(types[i*8:]))] = int64(int32(le.Uint32(types[i*8+4:])))
+				}
+				t.enums[tName] = e
+			case btfEnum64:
+				e := map[string]int64{}
+				for i := 0; i < vlen; i++ {
+					lo, hi := le.Uint32(types[i*12+4:]), le.Uint32(types[i*12+8:])
+					e[name(le.Uint32(types[i*12:]))] = int64(uint64(hi)<<32 | uint64(lo))
+				}
+				t.enums[tName] = e
+			}
+		}
+
+		types = types[size:]
+	}
+
+	return t, nil
+}
@@ -0,0 +1,124 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// btfBuilder encodes a minimal BTF blob
+type btfBuilder struct {
+	types bytes.Buffer
+	strs  bytes.Buffer
+}
+
+func newBTFBuilder() *btfBuilder {
+	b := &btfBuilder{}
+	b.strs.WriteByte(0)
+	return b
+}
+
+func (b *btfBuilder) str(s string) uint32 {
+	off := uint32(b.strs.Len())
+	b.strs.WriteString(s)
+	b.strs.WriteByte(0)
+	return off
+}
+
+func (b *btfBuilder) put(v ...uint32) {
+	for _, u := range v {
+		binary.Write(&b.types, binary.LittleEndian, u)
+	}
+}
+
+func (b *btfBuilder) typ(name string, kind, vlen uint32) {
+	b.put(b.str(name), kind<<24|vlen, 0)
+}
+
+func (b *btfBuilder) structType(name string, members ...string) {
+	b.typ(name, btfStruct, uint32(len(members)))
+	for i, m := range members {
+		b.put(b.str(m), 1, uint32(i*8))
+	}
+}
+
+func (b *btfBuilder) enumType(name string, values map[string]int32) {
+	b.typ(name, btfEnum, uint32(len(values)))
+	for n, v := range values {
+		b.put(b.str(n), uint32(v))
+	}
+}
+
+func (b *btfBuilder) bytes() []byte {
+	out := new(bytes.Buffer)
+	binary.Write(out, binary.LittleEndian, struct {
+		Magic   uint16
+		Version uint8
+		Flags   uint8
+		HdrLen  uint32
+		TypeOff uint32
+		TypeLen uint32
+		StrOff  uint32
+		StrLen  uint32
+	}{btfMagic, 1, 0, 24, 0, uint32(b.types.Len()), uint32(b.types.Len()), uint32(b.strs.Len())})
+	out.Write(b.types.Bytes())
+	out.Write(b.strs.Bytes())
+
+	return out.Bytes()
+}
+
+func writeBTF(t *testing.T, b *btfBuilder) string {
+	path := filepath.Join(t.TempDir(), "vmlinux")
+	assert.NoError(t, ioutil.WriteFile(path, b.bytes(), 0644))
+	return path
+}
+
+func TestParseBTF(t *testing.T) {
+	b := newBTFBuilder()
+	b.typ("int", btfInt, 0)
+	b.put(32)
+	b.typ("", btfFuncProt, 2)
+	b.put(0, 1, 0, 1)
+	b.structType("inet_connection_sock", "icsk_rto", "icsk_ca_state", "icsk_pending")
+	b.structType("tcp_sock", "snd_cwnd")
+	b.enumType("tcp_ca_state", map[string]int32{"TCP_CA_Recovery": 3, "TCP_CA_Loss": 4})
+
+	bt, err := parseBTF(b.bytes(), []string{"inet_connection_sock"}, []string{"tcp_ca_state"})
+	assert.NoError(t, err)
+	assert.True(t, bt.hasMembers("inet_connection_sock", "icsk_ca_state", "icsk_pending"))
+	assert.False(t, bt.hasMembers("inet_connection_sock", "icsk_ca_priv"))
+	assert.False(t, bt.hasMembers("tcp_sock", "snd_cwnd"))
+
+	v, ok := bt.enum("tcp_ca_state", "TCP_CA_Loss")
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), v)
+
+	_, err = parseBTF(b.bytes()[:30], nil, nil)
+	assert.Error(t, err)
+
+	_, err = parseBTF(make([]byte, 24), nil, nil)
+	assert.Error(t, err)
+}
+
+func TestRetransTypeHelper(t *testing.T) {
+	b := newBTFBuilder()
+	b.structType("inet_connection_sock", "icsk_ca_state", "icsk_pending")
+	b.enumType("tcp_ca_state", map[string]int32{"TCP_CA_Recovery": 3, "TCP_CA_Loss": 4})
+
+	helper := retransTypeHelper(writeBTF(t, b))
+	assert.Contains(t, helper, "if (state == 4)\n\t\treturn 1;")
+	assert.Contains(t, helper, "if (state == 3)\n\t\treturn 2;")
+	assert.Contains(t, helper, "if (pending == ICSK_TIME_LOSS_PROBE)\n\t\treturn 3;")
+
+	// the kernel doesn't describe the congestion state
+	b = newBTFBuilder()
+	b.structType("inet_connection_sock", "icsk_pending")
+	assert.Equal(t, retransTypeUnknown, retransTypeHelper(writeBTF(t, b)))
+
+	// no BTF
+	assert.Equal(t, retransTypeUnknown, retransTypeHelper(filepath.Join(t.TempDir(), "vmlinux")))
+}
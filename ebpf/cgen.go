@@ -17,8 +17,14 @@ type TracepointTemplate struct {
 	TCPState   string
 	Suffix     int
 	Sample     int
+	PIDs       []int
+	Comm       string
+	DPorts     []config.PortRange
+	SPorts     []config.PortRange
 	TCPInfo    bool
 	ICSK       bool
+	NP         bool
+	Agg        *aggregation
 }
 
 // Init intializes tracepointTemplate
@@ -30,6 +36,9 @@ func (t *TracepointTemplate) Init() {
 		if strings.Contains(f.DS, "icsk") {
 			t.ICSK = true
 		}
+		if f.DS == "np" {
+			t.NP = true
+		}
 	}
 }
 
@@ -40,10 +49,15 @@ type CGen struct {
 
 // GetBPFCode returns BPF program
 func GetBPFCode(conf *config.Config) (string, error) {
-	var bpfCode string
+	var bpfCode, helpers string
 
 	cg := CGen{conf: conf}
 	for index, tracepoint := range conf.Tracepoints {
+		// the custom programs are compiled separately
+		if tracepoint.Custom != nil {
+			continue
+		}
+
 		code, err := cg.getTracepointBPFCode(index, tracepoint)
 		if err != nil {
 			return "", err
@@ -52,7 +66,48 @@ func GetBPFCode(conf *config.Config) (string, error) {
 		bpfCode += code
 	}
 
-	return includes + bpfCode, nil
+	required := requiredFields(conf)
+	for _, name := range []string{"InitCwnd", "TSRTT", "RxQueue"} {
+		if required[name] {
+			helpers += fieldHelpers[name]
+		}
+	}
+
+	if required["RetransType"] {
+		helpers += retransTypeHelper(btfPath)
+	}
+
+	if ownerFilter(conf) {
+		helpers += skOwner
+	}
+
+	return includes + helpers + bpfCode, nil
+}
+
+// requiredFields returns the fields of the generated tracepoints
+func requiredFields(conf *config.Config) map[string]bool {
+	required := map[string]bool{}
+	for _, tp := range conf.Tracepoints {
+		if tp.Custom != nil {
+			continue
+		}
+
+		for _, f := range conf.Fields[tp.Fields] {
+			required[f.Name] = true
+		}
+
+		if tp.Aggregate == nil {
+			continue
+		}
+
+		if agg, err := newAggregation(tp.Aggregate); err == nil {
+			for _, f := range agg.fields {
+				required[f] = true
+			}
+		}
+	}
+
+	return required
 }
 
 func (c *CGen) getTracepointBPFCode(index int, tp config.Tracepoint) (string, error) {
@@ -60,10 +115,22 @@ func (c *CGen) getTracepointBPFCode(index int, tp config.Tracepoint) (string, er
 		cfgFields []config.Field
 		fields4   []FieldAttrs
 		fields6   []FieldAttrs
+		agg       *aggregation
 		ok        bool
+		err       error
 	)
 
-	if cfgFields, ok = c.conf.Fields[tp.Fields]; !ok {
+	// the aggregated tracepoint fields are the keys and the metrics
+	if tp.Aggregate != nil {
+		agg, err = newAggregation(tp.Aggregate)
+		if err != nil {
+			return "", err
+		}
+
+		for _, f := range agg.fields {
+			cfgFields = append(cfgFields, config.Field{Name: f})
+		}
+	} else if cfgFields, ok = c.conf.Fields[tp.Fields]; !ok {
 		return "", errors.New("field's template not exist")
 	}
 
@@ -75,6 +142,16 @@ func (c *CGen) getTracepointBPFCode(index int, tp config.Tracepoint) (string, er
 		}
 	}
 
+	dports, err := tp.DPort.Ranges()
+	if err != nil {
+		return "", err
+	}
+
+	sports, err := tp.SPort.Ranges()
+	if err != nil {
+		return "", err
+	}
+
 	tp.Name = strings.Replace(tp.Name, ":", "__", 1)
 
 	tt := TracepointTemplate{
@@ -84,6 +161,11 @@ func (c *CGen) getTracepointBPFCode(index int, tp config.Tracepoint) (string, er
 		TCPState:   tp.TCPState,
 		Suffix:     index,
 		Sample:     tp.Sample,
+		PIDs:       filterPIDs(tp),
+		Comm:       tp.Comm,
+		DPorts:     dports,
+		SPorts:     sports,
+		Agg:        agg,
 	}
 
 	tt.Init()
@@ -100,3 +182,26 @@ func (c *CGen) getTracepointBPFCode(index int, tp config.Tracepoint) (string, er
 
 	return buf.String(), nil
 }
+
+// ownerFilter reports whether any of the generated
+// tracepoints filters the events by the socket owner.
+func ownerFilter(conf *config.Config) bool {
+	for _, tp := range conf.Tracepoints {
+		if tp.Custom == nil && (len(filterPIDs(tp)) > 0 || tp.Comm != "") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterPIDs returns the pid and pids filters of the tracepoint
+func filterPIDs(tp config.Tracepoint) []int {
+	var pids []int
+
+	if tp.PID != 0 {
+		pids = append(pids, tp.PID)
+	}
+
+	return append(pids, tp.PIDs...)
+}
@@ -1,6 +1,8 @@
 package ebpf
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/mehrdadrad/tcpdog/config"
@@ -55,3 +57,448 @@ func TestGetBPFCode(t *testing.T) {
 	assert.Contains(t, source, "unsigned __int128 skc_v6_rcv_saddr2;")
 	assert.Contains(t, source, "unsigned __int128 skc_v6_daddr3;")
 }
+
+func TestGetBPFCodeInitCwnd(t *testing.T) {
+	cfgTracepoint := config.Tracepoint{
+		Name:   "tcp:tcp_retransmit_skb",
+		Fields: "custom_fields1",
+		INet:   []int{4},
+	}
+
+	cfgFileds := map[string][]config.Field{
+		"custom_fields1": {
+			{Name: "InitCwnd"},
+			{Name: "Cwnd"},
+		},
+	}
+
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      cfgFileds,
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "BPF_TABLE(\"lru_hash\", struct sock *, u32, init_cwnd, 65536);")
+	assert.Contains(t, source, "data4.snd_cwnd0 = (get_init_cwnd(sk, tcpi->snd_cwnd))")
+	assert.Contains(t, source, "data4.snd_cwnd1 = (tcpi->snd_cwnd)")
+	assert.Contains(t, source, "int init_cwnd_close(struct tracepoint__sock__inet_sock_set_state* args)")
+	assert.Contains(t, source, "init_cwnd.delete(&sk);")
+
+	source, err = GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      map[string][]config.Field{"custom_fields1": {{Name: "Cwnd"}}},
+	})
+
+	assert.NoError(t, err)
+	assert.NotContains(t, source, "init_cwnd")
+}
+
+func TestGetBPFCodeSampleWeight(t *testing.T) {
+	cfgTracepoint := config.Tracepoint{
+		Name:   "tcp:tcp_retransmit_skb",
+		Fields: "custom_fields1",
+		INet:   []int{4, 6},
+		Sample: 9,
+	}
+
+	cfgFileds := map[string][]config.Field{
+		"custom_fields1": {
+			{Name: "RTT"},
+			{Name: "SampleWeight"},
+		},
+	}
+
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      cfgFileds,
+	})
+
+	assert.NoError(t, err)
+
+	// the weight is the number of the events since the last emitted
+	// one which means it's always the effective sample rate
+	for _, ipv := range []string{"4", "6"} {
+		skip := strings.Index(source, "if (*count < 9) {\n\t\t\t\tipv"+ipv+"_sample.increment(sk);")
+		weight := strings.Index(source, "data"+ipv+".sample_weight1 = *count + 1;")
+		del := strings.Index(source, "ipv"+ipv+"_sample.delete(&sk);")
+
+		assert.Greater(t, skip, 0)
+		assert.Greater(t, weight, skip)
+		assert.Greater(t, del, weight)
+	}
+
+	assert.Contains(t, source, "u32 sample_weight1;")
+
+	cfgTracepoint.Sample = 0
+	source, err = GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      cfgFileds,
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "data4.sample_weight1 = 1;")
+	assert.Contains(t, source, "data6.sample_weight1 = 1;")
+	assert.NotContains(t, source, "*count + 1")
+}
+
+func TestSampleWeightRate(t *testing.T) {
+	cfgFileds := map[string][]config.Field{
+		"custom_fields1": {{Name: "SampleWeight"}},
+	}
+
+	// sample mirrors the sampling branch of the generated code, the
+	// count of the socket is kept while the sample rate changes.
+	var count uint64
+	sample := func(rate int) (uint32, bool) {
+		if count < uint64(rate) {
+			count++
+			return 0, false
+		}
+		weight := uint32(count + 1)
+		count = 0
+		return weight, true
+	}
+
+	var events, total, since uint64
+	for _, rate := range []int{9, 3, 1, 5, 2} {
+		source, err := GetBPFCode(&config.Config{
+			Tracepoints: []config.Tracepoint{{
+				Name:   "tcp:tcp_retransmit_skb",
+				Fields: "custom_fields1",
+				INet:   []int{4},
+				Sample: rate,
+			}},
+			Fields: cfgFileds,
+		})
+		assert.NoError(t, err)
+
+		assert.Contains(t, source, fmt.Sprintf("if (*count < %d) {", rate))
+		assert.Contains(t, source, "data4.sample_weight0 = *count + 1;")
+
+		// the weight is the number of the events since the last emitted
+		// one, it's the inverse of the rate once the rate has settled.
+		settled := false
+		for i := 0; i < 15; i++ {
+			events++
+			since++
+
+			weight, ok := sample(rate)
+			if !ok {
+				continue
+			}
+
+			assert.Equal(t, since, uint64(weight), "rate %d", rate)
+			if settled {
+				assert.Equal(t, uint32(rate+1), weight, "rate %d", rate)
+			}
+
+			total += uint64(weight)
+			since, settled = 0, true
+		}
+	}
+
+	// the sum of the weights estimates the events without bias
+	assert.Equal(t, events, total+count)
+}
+
+func TestGetBPFCodeConnectFail(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "sock:inet_sock_set_state",
+			Fields:   "custom_fields1",
+			TCPState: "TCP_CONNECT_FAIL",
+			INet:     []int{4},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {{Name: "FailReason"}, {Name: "DAddr"}, {Name: "DPort"}},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "if (args->oldstate != TCP_SYN_SENT || args->newstate != TCP_CLOSE) {")
+	assert.Contains(t, source, "data4.oldstate0 = (args->newstate << 24 | args->oldstate << 16 | (u16)sk->sk_err)")
+	assert.NotContains(t, source, "args->newstate != TCP_CONNECT_FAIL")
+}
+
+func TestGetBPFCodeVRF(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "sock:inet_sock_set_state",
+			Fields:   "custom_fields1",
+			TCPState: "TCP_CLOSE",
+			INet:     []int{4, 6},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {
+				{Name: "VRF"},
+				{Name: "VRFName"},
+			},
+		},
+	})
+
+	assert.NoError(t, err)
+
+	for _, ipv := range []string{"4", "6"} {
+		assert.Contains(t, source, "data"+ipv+".skc_bound_dev_if0 = (sk->__sk_common.skc_bound_dev_if)")
+		assert.Contains(t, source, "data"+ipv+".skc_bound_dev_if1 = (sk->__sk_common.skc_bound_dev_if)")
+	}
+}
+
+func TestGetBPFCodeSynRetrans(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "sock:inet_sock_set_state",
+			Fields:   "custom_fields1",
+			TCPState: "TCP_ESTABLISHED",
+			INet:     []int{4},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {{Name: "SynRetrans"}, {Name: "TotalRetrans"}},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "data4.total_retrans0 = (args->oldstate == TCP_SYN_SENT || args->oldstate == TCP_SYN_RECV ? tcpi->total_retrans : 0)")
+	assert.Contains(t, source, "data4.total_retrans1 = (tcpi->total_retrans)")
+}
+
+func TestGetBPFCodeFlowLabel(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "sock:inet_sock_set_state",
+			Fields:   "custom_fields1",
+			TCPState: "TCP_CLOSE",
+			INet:     []int{4, 6},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {{Name: "FlowLabel"}, {Name: "TClass"}, {Name: "DPort", Filter: "DPort != 443"}},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "struct ipv6_pinfo *np = inet_sk(sk)->pinet6;")
+
+	// v4
+	assert.Contains(t, source, "u16 skc_dport0;")
+	assert.Contains(t, source, "if (data4.skc_dport0 != 443) {")
+	assert.NotContains(t, source, "data4.flow_label")
+
+	// v6
+	assert.Contains(t, source, "data6.flow_label0 = (bpf_ntohl(np->flow_label) & 0xFFFFF)")
+	assert.Contains(t, source, "data6.tclass1 = (np->tclass)")
+	assert.Contains(t, source, "if (data6.skc_dport2 != 443) {")
+}
+
+func TestGetBPFCodeDSCP(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "sock:inet_sock_set_state",
+			Fields:   "custom_fields1",
+			TCPState: "TCP_CLOSE",
+			INet:     []int{4, 6},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {{Name: "TOS"}, {Name: "DSCP"}, {Name: "DSCPName"}},
+		},
+	})
+
+	assert.NoError(t, err)
+
+	// v4
+	assert.Contains(t, source, "data4.tos0 = (inet_sk(sk)->tos)")
+	assert.Contains(t, source, "data4.tos1 = (inet_sk(sk)->tos >> 2)")
+	assert.Contains(t, source, "data4.tos2 = (inet_sk(sk)->tos >> 2)")
+
+	// v6
+	assert.Contains(t, source, "struct ipv6_pinfo *np = inet_sk(sk)->pinet6;")
+	assert.Contains(t, source, "data6.tclass0 = (np->tclass)")
+	assert.Contains(t, source, "data6.tclass1 = (np->tclass >> 2)")
+}
+
+func TestGetBPFCodeTSRTT(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:   "tcp:tcp_probe",
+			Fields: "custom_fields1",
+			INet:   []int{4},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {{Name: "TSRTT"}, {Name: "InitCwnd"}},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "static inline u32 get_ts_rtt(u32 tsecr, u64 mstamp)")
+	assert.Contains(t, source, "static inline u32 get_init_cwnd(struct sock *sk, u32 cwnd)")
+	assert.Contains(t, source, "data4.rcv_tsecr0 = (get_ts_rtt(tcpi->rx_opt.rcv_tsecr, tcpi->tcp_mstamp))")
+
+	source, err = GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:   "tcp:tcp_probe",
+			Fields: "custom_fields1",
+			INet:   []int{4},
+		}},
+		Fields: map[string][]config.Field{"custom_fields1": {{Name: "RTT"}}},
+	})
+
+	assert.NoError(t, err)
+	assert.NotContains(t, source, "get_ts_rtt")
+}
+
+func TestGetBPFCodeQueue(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "sock:inet_sock_set_state",
+			Fields:   "custom_fields1",
+			TCPState: "TCP_ESTABLISHED",
+			INet:     []int{4},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {{Name: "RxQueue"}, {Name: "TxQueue"}},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "#define RX_QUEUE(x) (x)")
+	assert.Contains(t, source, "u16 sk_rx_queue_mapping0;")
+	assert.Contains(t, source, "data4.sk_rx_queue_mapping0 = (RX_QUEUE(sk->sk_rx_queue_mapping))")
+	assert.Contains(t, source, "data4.skc_tx_queue_mapping1 = (sk->__sk_common.skc_tx_queue_mapping)")
+}
+
+func TestGetBPFCodeKTime(t *testing.T) {
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:     "sock:inet_sock_set_state",
+			Fields:   "custom_fields1",
+			TCPState: "TCP_ESTABLISHED",
+			INet:     []int{4, 6},
+		}},
+		Fields: map[string][]config.Field{
+			"custom_fields1": {{Name: "KTime"}, {Name: "RTT"}, {Name: "ReadTime"}},
+		},
+	})
+
+	assert.NoError(t, err)
+	// the user space fields aren't in the struct
+	assert.Contains(t, source, "u32 srtt_us0;")
+	assert.NotContains(t, source, "srtt_us1")
+	assert.Contains(t, source, "u64 ktime;")
+	assert.Contains(t, source, "data4.ktime = bpf_ktime_get_ns();")
+	assert.Contains(t, source, "data6.ktime = bpf_ktime_get_ns();")
+}
+
+func TestGetBPFCodeRetransType(t *testing.T) {
+	path := btfPath
+	btfPath = "/nonexistent/vmlinux"
+	defer func() { btfPath = path }()
+
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{{
+			Name:   "tcp:tcp_retransmit_skb",
+			Fields: "custom_fields1",
+			INet:   []int{4},
+		}},
+		Fields: map[string][]config.Field{"custom_fields1": {{Name: "RetransType"}}},
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, source, "struct inet_connection_sock *icsk = inet_csk(sk);")
+	assert.Contains(t, source, "data4.icsk_pending0 = (get_retrans_type(sk, icsk->icsk_pending))")
+	assert.Contains(t, source, retransTypeUnknown)
+}
+
+func TestGetBPFCodeProcessFilter(t *testing.T) {
+	cfgTracepoint := config.Tracepoint{
+		Name:   "tcp:tcp_retransmit_skb",
+		Fields: "custom_fields1",
+		INet:   []int{4, 6},
+		Sample: 9,
+		PID:    1234,
+		PIDs:   []int{5678},
+		Comm:   "nginx",
+	}
+
+	cfgFileds := map[string][]config.Field{
+		"custom_fields1": {
+			{Name: "RTT"},
+			{Name: "SampleWeight"},
+		},
+	}
+
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      cfgFileds,
+	})
+
+	assert.NoError(t, err)
+
+	pid := strings.Index(source, "if (filter_pid != 1234 && filter_pid != 5678) {")
+	comm := strings.Index(source, "if (filter_comm[0] != 110 || filter_comm[1] != 103 || filter_comm[2] != 105 || "+
+		"filter_comm[3] != 110 || filter_comm[4] != 120) {")
+
+	// the filters match the socket owner not the current task
+	owner := strings.Index(source, "struct sk_owner_t *owner = sk_owner.lookup(&sk);")
+
+	assert.Greater(t, owner, 0)
+	assert.Greater(t, pid, owner)
+	assert.Greater(t, comm, pid)
+	assert.Contains(t, source, "u32 filter_pid = owner->pid;")
+	assert.Contains(t, source, "char *filter_comm = owner->comm;")
+	assert.Contains(t, source, skOwner)
+
+	// the sample counts the filtered events only
+	for _, ipv := range []string{"4", "6"} {
+		sample := strings.Index(source, "count = ipv"+ipv+"_sample.lookup_or_try_init(&sk, &zero);")
+		assert.Greater(t, sample, comm)
+	}
+
+	cfgTracepoint.PID, cfgTracepoint.PIDs, cfgTracepoint.Comm = 0, nil, ""
+	source, err = GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      cfgFileds,
+	})
+
+	assert.NoError(t, err)
+	assert.NotContains(t, source, "filter_pid")
+	assert.NotContains(t, source, "filter_comm")
+	assert.NotContains(t, source, "sk_owner")
+}
+
+func TestGetBPFCodePortFilter(t *testing.T) {
+	cfgTracepoint := config.Tracepoint{
+		Name:   "tcp:tcp_retransmit_skb",
+		Fields: "custom_fields1",
+		INet:   []int{4},
+		DPort:  config.Ports{"443", "8000-8100"},
+		SPort:  config.Ports{"3306"},
+	}
+
+	cfgFileds := map[string][]config.Field{
+		"custom_fields1": {{Name: "RTT"}},
+	}
+
+	source, err := GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      cfgFileds,
+	})
+
+	assert.NoError(t, err)
+
+	sk := strings.Index(source, "struct sock *sk = (struct sock *)args->skaddr;")
+	dport := strings.Index(source, "if (!(filter_dport == 443 || (filter_dport >= 8000 && filter_dport <= 8100))) {")
+	sport := strings.Index(source, "if (!(filter_sport == 3306)) {")
+
+	assert.Greater(t, dport, sk)
+	assert.Greater(t, sport, dport)
+	assert.Contains(t, source, "u16 filter_dport = ntohs(sk->__sk_common.skc_dport);")
+
+	cfgTracepoint.DPort, cfgTracepoint.SPort = nil, nil
+	source, err = GetBPFCode(&config.Config{
+		Tracepoints: []config.Tracepoint{cfgTracepoint},
+		Fields:      cfgFileds,
+	})
+
+	assert.NoError(t, err)
+	assert.NotContains(t, source, "filter_dport")
+	assert.NotContains(t, source, "filter_sport")
+}
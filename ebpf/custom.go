@@ -0,0 +1,263 @@
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bpf "github.com/iovisor/gobpf/bcc"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// schemaTypes are the custom schema types and their sizes
+var schemaTypes = map[string]int{
+	"u8":   1,
+	"u16":  2,
+	"u32":  4,
+	"u64":  8,
+	"ipv4": 4,
+	"ipv6": 16,
+	"comm": 16,
+}
+
+// ValidateCustom validates the custom program and its schema, the
+// fields should be in the output struct and they can't overlap.
+func ValidateCustom(c *config.Custom) error {
+	if (c.Source == "" && c.Object == "") || c.Program == "" || c.Map == "" {
+		return fmt.Errorf("custom source or object, program and map are required")
+	}
+
+	if c.Source != "" && c.Object != "" {
+		return fmt.Errorf("custom source and object are exclusive")
+	}
+
+	if c.Kind != "tracepoint" && c.Kind != "kprobe" {
+		return fmt.Errorf("invalid custom kind: %s", c.Kind)
+	}
+
+	if c.Size < 1 || c.Size > math.MaxUint16 {
+		return fmt.Errorf("invalid custom struct size: %d", c.Size)
+	}
+
+	if len(c.Schema) < 1 {
+		return fmt.Errorf("custom schema is empty")
+	}
+
+	fields := make([]config.SchemaField, len(c.Schema))
+	copy(fields, c.Schema)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].Offset < fields[j].Offset
+	})
+
+	names := map[string]bool{"Timestamp": true}
+	end := 0
+
+	for _, f := range fields {
+		size, ok := schemaTypes[f.Type]
+		if !ok {
+			return fmt.Errorf("invalid schema type: %s (%s)", f.Type, f.Name)
+		}
+
+		if f.Name == "" || names[f.Name] {
+			return fmt.Errorf("invalid or duplicate schema field: %q", f.Name)
+		}
+		names[f.Name] = true
+
+		if f.Offset < 0 || f.Offset+size > c.Size {
+			return fmt.Errorf("schema field %s is out of the struct size %d", f.Name, c.Size)
+		}
+
+		if f.Offset < end {
+			return fmt.Errorf("schema field %s overlaps the previous field", f.Name)
+		}
+
+		end = f.Offset + size
+	}
+
+	if c.Object != "" {
+		return validateObject(c)
+	}
+
+	return nil
+}
+
+// validateObject validates the object has the program and the
+// perf map sections, the sections are named by the gobpf elf
+// loader convention: <kind>/<attach point> and maps/<name>.
+func validateObject(c *config.Custom) error {
+	f, err := elf.Open(c.Object)
+	if err != nil {
+		return fmt.Errorf("invalid custom object: %v", err)
+	}
+	defer f.Close()
+
+	if f.Machine != elf.EM_BPF {
+		return fmt.Errorf("custom object %s is not a bpf object", c.Object)
+	}
+
+	if !strings.HasPrefix(c.Program, c.Kind+"/") || f.Section(c.Program) == nil {
+		return fmt.Errorf("custom object %s doesn't have the %s section %s", c.Object, c.Kind, c.Program)
+	}
+
+	if f.Section("maps/"+c.Map) == nil {
+		return fmt.Errorf("custom object %s doesn't have the map %s", c.Object, c.Map)
+	}
+
+	return nil
+}
+
+// schemaDecoder decodes the custom program events to json
+// based on the declared schema.
+type schemaDecoder struct {
+	decoder
+	schema []config.SchemaField
+	size   int
+}
+
+func newSchemaDecoder(c *config.Custom) *schemaDecoder {
+	return &schemaDecoder{schema: c.Schema, size: c.Size}
+}
+
+func (d *schemaDecoder) decode(data []byte, buf *bytes.Buffer) error {
+	if len(data) < d.size {
+		return fmt.Errorf("event size %d is less than the struct size %d", len(data), d.size)
+	}
+
+	buf.WriteRune('{')
+
+	for _, f := range d.schema {
+		buf.WriteRune('"')
+		buf.WriteString(f.Name)
+		buf.WriteString(`":`)
+
+		o := uint16(f.Offset)
+
+		switch f.Type {
+		case "u8":
+			buf.WriteString(strconv.FormatUint(uint64(data[o]), 10))
+		case "u16":
+			buf.WriteString(strconv.FormatUint(uint64(bytesToUint16(f.BigEndian, data, o)), 10))
+		case "u32":
+			buf.WriteString(strconv.FormatUint(uint64(bytesToUint32(f.BigEndian, data, o)), 10))
+		case "u64":
+			buf.WriteString(strconv.FormatUint(bytesToUint64(f.BigEndian, data, o), 10))
+		case "ipv4":
+			buf.WriteRune('"')
+			buf.WriteString(net.IP(data[o : o+4]).String())
+			buf.WriteRune('"')
+		case "ipv6":
+			buf.WriteRune('"')
+			buf.WriteString(net.IP(data[o : o+16]).String())
+			buf.WriteRune('"')
+		case "comm":
+			buf.WriteRune('"')
+			buf.Write(trim(data[o : o+16]))
+			buf.WriteRune('"')
+		}
+
+		buf.WriteRune(',')
+	}
+
+	d.timestamp(buf)
+
+	return nil
+}
+
+// startCustom compiles and attaches the custom program, its
+// events are decoded by the schema into the standard pipeline.
+func (b *BPF) startCustom(ctx context.Context, tp TP, logger *zap.Logger) error {
+	c := tp.Custom
+
+	if c.Object != "" {
+		return b.startObject(ctx, tp, logger)
+	}
+
+	source, err := ioutil.ReadFile(c.Source)
+	if err != nil {
+		return err
+	}
+
+	m := bpf.NewModule(string(source), []string{})
+	if m == nil {
+		return fmt.Errorf("failed to compile the custom bpf program %s", c.Source)
+	}
+
+	b.customs = append(b.customs, m)
+
+	switch c.Kind {
+	case "kprobe":
+		fd, err := m.LoadKprobe(c.Program)
+		if err != nil {
+			return err
+		}
+
+		err = m.AttachKprobe(tp.Name, fd, -1)
+		if err != nil {
+			return err
+		}
+	default:
+		fd, err := m.LoadTracepoint(c.Program)
+		if err != nil {
+			return err
+		}
+
+		err = m.AttachTracepoint(tp.Name, fd)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("ebpf", zap.String("msg", tp.Name+" has been attached"), zap.String("custom", c.Source))
+
+	table := bpf.NewTable(m.TableId(c.Map), m)
+	ch := b.sampleChan(ctx)
+
+	perfMap, err := bpf.InitPerfMap(table, ch, b.lostChan(tp.Name))
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < tp.Workers; i++ {
+		go runCustom(ctx, tp, newSchemaDecoder(c), ch, logger)
+	}
+
+	perfMap.Start()
+	b.perfMaps = append(b.perfMaps, perfMap)
+
+	return nil
+}
+
+func runCustom(ctx context.Context, tp TP, d *schemaDecoder, ch chan []byte, logger *zap.Logger) {
+	c := counter(tp)
+
+	idle := time.NewTicker(idleInterval)
+	defer idle.Stop()
+
+	for {
+		data, ok := next(ctx, ch, idle)
+		if !ok {
+			return
+		}
+
+		buf := tp.BufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+
+		if err := d.decode(data, buf); err != nil {
+			logger.Warn("ebpf", zap.String("tracepoint", tp.Name), zap.Error(err))
+			tp.BufPool.Put(buf)
+			continue
+		}
+
+		c.emit(tp.OutChan, buf, logger)
+	}
+}
@@ -0,0 +1,175 @@
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	yml "gopkg.in/yaml.v3"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestValidateCustom(t *testing.T) {
+	custom := func(size int, schema ...config.SchemaField) *config.Custom {
+		return &config.Custom{
+			Source:  "foo.c",
+			Program: "foo",
+			Kind:    "tracepoint",
+			Map:     "events",
+			Size:    size,
+			Schema:  schema,
+		}
+	}
+
+	assert.NoError(t, ValidateCustom(custom(8,
+		config.SchemaField{Name: "DPort", Type: "u16", Offset: 4},
+		config.SchemaField{Name: "PID", Type: "u32", Offset: 0},
+	)))
+
+	tests := []struct {
+		custom *config.Custom
+		err    string
+	}{
+		{&config.Custom{Kind: "tracepoint"}, "custom source or object, program and map are required"},
+		{custom(0, config.SchemaField{Name: "PID", Type: "u32"}), "invalid custom struct size: 0"},
+		{custom(4), "custom schema is empty"},
+		{custom(4, config.SchemaField{Name: "PID", Type: "s32"}), "invalid schema type: s32 (PID)"},
+		{custom(4, config.SchemaField{Name: "PID", Type: "u32", Offset: 2}), "schema field PID is out of the struct size 4"},
+		{custom(8,
+			config.SchemaField{Name: "PID", Type: "u32", Offset: 0},
+			config.SchemaField{Name: "DPort", Type: "u16", Offset: 2},
+		), "schema field DPort overlaps the previous field"},
+		{custom(8,
+			config.SchemaField{Name: "PID", Type: "u32", Offset: 0},
+			config.SchemaField{Name: "PID", Type: "u32", Offset: 4},
+		), `invalid or duplicate schema field: "PID"`},
+		{custom(8, config.SchemaField{Name: "Timestamp", Type: "u64"}), `invalid or duplicate schema field: "Timestamp"`},
+	}
+
+	for _, tt := range tests {
+		assert.EqualError(t, ValidateCustom(tt.custom), tt.err)
+	}
+
+	c := custom(4, config.SchemaField{Name: "PID", Type: "u32"})
+	c.Kind = "uprobe"
+	assert.EqualError(t, ValidateCustom(c), "invalid custom kind: uprobe")
+
+	c.Kind, c.Object = "tracepoint", "foo.o"
+	assert.EqualError(t, ValidateCustom(c), "custom source and object are exclusive")
+}
+
+func TestValidateCustomObject(t *testing.T) {
+	object := "../scripts/examples/custom/tcp_state_object.o"
+	custom := func(object, kind, program, m string) *config.Custom {
+		return &config.Custom{
+			Object:  object,
+			Program: program,
+			Kind:    kind,
+			Map:     m,
+			Size:    4,
+			Schema:  []config.SchemaField{{Name: "PID", Type: "u32"}},
+		}
+	}
+
+	assert.NoError(t, ValidateCustom(custom(object, "tracepoint", "tracepoint/sock/inet_sock_set_state", "events")))
+
+	tests := []struct {
+		custom *config.Custom
+		err    string
+	}{
+		{custom(object, "kprobe", "tracepoint/sock/inet_sock_set_state", "events"),
+			"custom object " + object + " doesn't have the kprobe section tracepoint/sock/inet_sock_set_state"},
+		{custom(object, "tracepoint", "tracepoint/tcp/tcp_probe", "events"),
+			"custom object " + object + " doesn't have the tracepoint section tracepoint/tcp/tcp_probe"},
+		{custom(object, "tracepoint", "tracepoint/sock/inet_sock_set_state", "ipv4_events"),
+			"custom object " + object + " doesn't have the map ipv4_events"},
+	}
+
+	for _, tt := range tests {
+		assert.EqualError(t, ValidateCustom(tt.custom), tt.err)
+	}
+
+	// the source isn't an elf object
+	err := ValidateCustom(custom("../scripts/examples/custom/tcp_state.c", "tracepoint", "tracepoint/sock/inet_sock_set_state", "events"))
+	assert.Contains(t, err.Error(), "invalid custom object:")
+
+	assert.Equal(t, "tracepoint/sock/inet_sock_set_state", objectSection("tracepoint", "sock:inet_sock_set_state"))
+	assert.Equal(t, "kprobe/tcp_close", objectSection("kprobe", "tcp_close"))
+}
+
+func TestCustomExample(t *testing.T) {
+	b, err := ioutil.ReadFile("../scripts/examples/custom/agent.yml")
+	assert.NoError(t, err)
+
+	cfg := &config.Config{}
+	assert.NoError(t, yml.Unmarshal(b, cfg))
+	assert.NoError(t, ValidateCustom(cfg.Tracepoints[0].Custom))
+
+	// the precompiled variant has the same schema
+	b, err = ioutil.ReadFile("../scripts/examples/custom/agent_object.yml")
+	assert.NoError(t, err)
+
+	object := &config.Config{}
+	assert.NoError(t, yml.Unmarshal(b, object))
+
+	c := object.Tracepoints[0].Custom
+	assert.Equal(t, cfg.Tracepoints[0].Custom.Schema, c.Schema)
+	assert.Equal(t, objectSection(c.Kind, object.Tracepoints[0].Name), c.Program)
+
+	c.Object = "../" + c.Object
+	assert.NoError(t, ValidateCustom(c))
+}
+
+func TestRunCustom(t *testing.T) {
+	c := &config.Custom{
+		Size: 40,
+		Schema: []config.SchemaField{
+			{Name: "PID", Type: "u32", Offset: 0},
+			{Name: "DPort", Type: "u16", Offset: 4, BigEndian: true},
+			{Name: "State", Type: "u8", Offset: 6},
+			{Name: "Bytes", Type: "u64", Offset: 8},
+			{Name: "DAddr", Type: "ipv4", Offset: 16},
+			{Name: "Task", Type: "comm", Offset: 20},
+		},
+	}
+
+	event := make([]byte, 40)
+	binary.LittleEndian.PutUint32(event, 1234)
+	binary.BigEndian.PutUint16(event[4:], 443)
+	event[6] = 7
+	binary.LittleEndian.PutUint64(event[8:], 1<<40)
+	copy(event[16:], []byte{10, 0, 0, 1})
+	copy(event[20:], "curl")
+
+	ch := make(chan []byte, 2)
+	out := make(chan *bytes.Buffer, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tp := TP{
+		Name:    "sock:inet_sock_set_state",
+		BufPool: &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+		OutChan: out,
+	}
+
+	go runCustom(ctx, tp, newSchemaDecoder(c), ch, zap.NewNop())
+
+	// the short event is dropped
+	ch <- event[:20]
+	ch <- event
+
+	select {
+	case buf := <-out:
+		assert.Regexp(t, `^{"PID":1234,"DPort":443,"State":7,"Bytes":1099511627776,"DAddr":"10.0.0.1","Task":"curl","Timestamp":\d+}$`, buf.String())
+	case <-time.After(time.Second):
+		t.Fatal("time exceeded")
+	}
+}
@@ -3,6 +3,8 @@ package ebpf
 import (
 	"fmt"
 	"strings"
+
+	"github.com/mehrdadrad/tcpdog/config"
 )
 
 var (
@@ -27,6 +29,41 @@ var (
 			DS:     "args",
 			Desc:   "TCP previous state",
 		},
+		"CloseReason": {
+			CType:  u32,
+			DType:  Reason,
+			CField: "oldstate",
+			DS:     "args",
+			Func:   "args->newstate << 24 | %s << 16 | (u16)sk->sk_err",
+			Desc:   "Connection close reason: fin, rst_sent, rst_received, error:<errno> or unknown (sock:inet_sock_set_state)",
+		},
+		"FailReason": {
+			CType:  u32,
+			DType:  Failure,
+			CField: "oldstate",
+			DS:     "args",
+			Func:   "args->newstate << 24 | %s << 16 | (u16)sk->sk_err",
+			Desc:   "Connect failure reason: rst_received, host_unreachable, net_unreachable, timed_out, aborted or error:<errno> (sock:inet_sock_set_state)",
+		},
+		// the kernel counts the SYN retransmits in total_retrans and the
+		// passive side inherits the SYN-ACK retransmits from the request
+		// sock, so no per pending connection state is needed, it's read
+		// once the socket leaves SYN_SENT or SYN_RECV.
+		"SynRetrans": {
+			CType:  u32,
+			CField: "total_retrans",
+			DS:     "tcpi",
+			Func:   "args->oldstate == TCP_SYN_SENT || args->oldstate == TCP_SYN_RECV ? %s : 0",
+			Desc:   "SYN (or SYN-ACK) retransmits before the connection established or failed, 0 for other transitions (sock:inet_sock_set_state)",
+		},
+		"RetransType": {
+			CType:  u8,
+			DType:  RetransType,
+			CField: "icsk_pending",
+			DS:     "icsk",
+			Func:   "get_retrans_type(sk, %s)",
+			Desc:   "Retransmit trigger: rto, fast, tlp (tail loss probe) or unknown, it's unknown if the kernel BTF isn't available (tcp:tcp_retransmit_skb)",
+		},
 		"SRTT": {
 			DS:     "tcpi",
 			CField: "srtt_us",
@@ -40,6 +77,15 @@ var (
 			Math:   ">> 3",
 			Desc:   "Round trip time",
 		},
+		"TSRTT": {
+			CType:  u32,
+			DType:  Optional,
+			CField: "rcv_tsecr",
+			DS:     "tcpi->rx_opt",
+			DSNP:   true,
+			Func:   "get_ts_rtt(%s, tcpi->tcp_mstamp)",
+			Desc:   "RTT sample in usecs (ms resolution) of the timestamp option echoed by the last received segment, omitted if the peer doesn't use TCP timestamps (kernel 4.13+)",
+		},
 		"MDev": {
 			DS:     "tcpi",
 			CField: "mdev_us",
@@ -135,6 +181,77 @@ var (
 			CType:     u16,
 			Desc:      "Source port",
 		},
+		"VRF": {
+			DS:     "sk->__sk_common",
+			DSNP:   true,
+			CField: "skc_bound_dev_if",
+			CType:  u32,
+			Desc:   "Bound device ifindex, it's the VRF (l3mdev) ifindex for the sockets in a VRF and zero otherwise",
+		},
+		"VRFName": {
+			DS:     "sk->__sk_common",
+			DSNP:   true,
+			CField: "skc_bound_dev_if",
+			CType:  u32,
+			DType:  IfName,
+			Desc:   "Bound device name (VRF), it's resolved from the ifindex at user space",
+		},
+		"RxQueue": {
+			DS:     "sk",
+			CField: "sk_rx_queue_mapping",
+			CType:  u16,
+			DType:  Queue,
+			Func:   "RX_QUEUE(%s)",
+			Desc:   "NIC rx queue index which the connection has been received on, zero if it's not recorded (kernel 4.19+ with CONFIG_XPS)",
+		},
+		"TxQueue": {
+			DS:     "sk->__sk_common",
+			DSNP:   true,
+			CField: "skc_tx_queue_mapping",
+			CType:  u16,
+			DType:  Queue,
+			Desc:   "NIC tx queue index which the connection has been mapped to, zero if it's not mapped yet",
+		},
+		// the ipv6 sockets read the traffic class instead, see init
+		"TOS": {
+			DS:     "inet_sk(sk)",
+			CField: "tos",
+			CType:  u8,
+			Desc:   "IPv4 TOS or IPv6 traffic class byte",
+		},
+		"DSCP": {
+			DS:     "inet_sk(sk)",
+			CField: "tos",
+			CType:  u8,
+			Func:   "%s >> 2",
+			Desc:   "DSCP, the upper 6 bits of the TOS (traffic class)",
+		},
+		"DSCPName": {
+			DS:     "inet_sk(sk)",
+			CField: "tos",
+			CType:  u8,
+			DType:  DSCPName,
+			Func:   "%s >> 2",
+			Desc:   "DSCP class name e.g. EF or AF41, the dscpNames config extends the default names and the others are reported as the value",
+		},
+		"KTime": {
+			DS:    userSpace,
+			CType: u64,
+			DType: KTime,
+			Desc:  "Kernel time which the tracepoint fired in unix nanoseconds, it's converted from the monotonic clock to wall clock",
+		},
+		"ReadTime": {
+			DS:    userSpace,
+			CType: u64,
+			DType: ReadTime,
+			Desc:  "Time which the agent read the event from the perf buffer in unix nanoseconds",
+		},
+		"AgentDelay": {
+			DS:    userSpace,
+			CType: u64,
+			DType: Delay,
+			Desc:  "Perf buffer dwell time in usecs (ReadTime - KTime), it's added if both KTime and ReadTime are enabled",
+		},
 		"BytesReceived": {
 			DS:     "tcpi",
 			CField: "bytes_received",
@@ -187,6 +304,12 @@ var (
 			CType:  char,
 			Desc:   "",
 		},
+		"SampleWeight": {
+			DS:     "bpf_sample_weight",
+			CField: "sample_weight",
+			CType:  u32,
+			Desc:   "Number of events the sampled event stands for (1/effective sample rate)",
+		},
 		"PID": {
 			DS:     "bpf_get_current_pid_tgid",
 			CField: "pid",
@@ -277,6 +400,13 @@ var (
 			DS:     "tcpi",
 			Desc:   "Sending congestion window",
 		},
+		"InitCwnd": {
+			CType:  u32,
+			CField: "snd_cwnd",
+			DS:     "tcpi",
+			Func:   "get_init_cwnd(sk, %s)",
+			Desc:   "Congestion window in segments at the first event of the connection",
+		},
 		"PrrOut": {
 			CType:  u32,
 			CField: "prr_out",
@@ -347,6 +477,24 @@ var (
 		},
 	}
 
+	// fieldsModelV6Only are the fields which only the ipv6 sockets
+	// have, the ipv4 events omit them.
+	fieldsModelV6Only = map[string]FieldAttrs{
+		"FlowLabel": {
+			CType:  u32,
+			CField: "flow_label",
+			DS:     "np",
+			Func:   "bpf_ntohl(%s) & 0xFFFFF",
+			Desc:   "IPv6 flow label",
+		},
+		"TClass": {
+			CType:  u8,
+			CField: "tclass",
+			DS:     "np",
+			Desc:   "IPv6 traffic class",
+		},
+	}
+
 	validTracepoints = map[string]bool{
 		"tcp:tcp_retransmit_skb":    true,
 		"tcp:tcp_retransmit_synack": true,
@@ -357,6 +505,24 @@ var (
 		"sock:inet_sock_set_state":  true,
 	}
 
+	// unavailableFields are the fields which the tracepoint can't
+	// report, the socket of tcp_retransmit_synack is the listener.
+	unavailableFields = map[string]map[string]bool{
+		"tcp:tcp_retransmit_synack": {"TOS": true, "DSCP": true, "DSCPName": true},
+	}
+
+	// fieldAliases are the fields which report the same
+	// value as another field e.g. the current cwnd.
+	fieldAliases = map[string]string{
+		"Cwnd": "SndCwnd",
+	}
+
+	// tracepointFields are the fields which only the
+	// tracepoint can report.
+	tracepointFields = map[string]string{
+		"RetransType": "tcp:tcp_retransmit_skb",
+	}
+
 	validTCPStatus = map[string]uint8{
 		"TCP_ESTABLISHED":  1,
 		"TCP_SYN_SENT":     2,
@@ -370,11 +536,18 @@ var (
 		"TCP_LISTEN":       10,
 		"TCP_CLOSING":      11,
 		"TCP_NEW_SYN_RECV": 12,
+		"TCP_CONNECT_FAIL": 98,
 		"TCP_ALL":          99,
 	}
 )
 
 func init() {
+	for alias, name := range fieldAliases {
+		v := fieldsModel4[name]
+		v.Desc = fmt.Sprintf("Alias of %s", name)
+		fieldsModel4[alias] = v
+	}
+
 	for k, v := range fieldsModel4 {
 		fieldsLowerCaseMap[strings.ToLower(k)] = k
 
@@ -386,8 +559,33 @@ func init() {
 			v.CType = u128
 			v.CField = "skc_v6_daddr"
 		}
+		if v.CField == "tos" {
+			v.DS = "np"
+			v.CField = "tclass"
+		}
 		fieldsModel6[k] = v
 	}
+
+	for k, v := range fieldsModelV6Only {
+		fieldsLowerCaseMap[strings.ToLower(k)] = k
+		fieldsModel6[k] = v
+	}
+
+	for k := range fieldsModel6 {
+		config.RegisterFields(k)
+	}
+}
+
+// IsV6Only returns true if the field is only available on ipv6 sockets
+func IsV6Only(f string) bool {
+	_, ok := fieldsModelV6Only[f]
+	return ok
+}
+
+// IsUserSpace returns true if the field is made by the agent
+// rather than the bpf program
+func IsUserSpace(f string) bool {
+	return fieldsModel4[f].DS == userSpace
 }
 
 // ValidateField validates a field
@@ -403,6 +601,17 @@ func ValidateField(f string) (string, error) {
 	return f, fmt.Errorf("invalid field: %s", f)
 }
 
+// ValidateAvailability validates the field is available on the tracepoint
+func ValidateAvailability(tp, f string) error {
+	if unavailableFields[tp][f] {
+		return fmt.Errorf("%s is not available on %s", f, tp)
+	}
+	if only, ok := tracepointFields[f]; ok && only != tp {
+		return fmt.Errorf("%s is only available on %s", f, only)
+	}
+	return nil
+}
+
 // ValidateTCPStatus validates a TCP status
 func ValidateTCPStatus(status string) (string, error) {
 	statusUpper := strings.ToUpper(status)
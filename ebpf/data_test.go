@@ -18,6 +18,17 @@ func TestValidateField(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestFieldAliases(t *testing.T) {
+	f, err := ValidateField("cwnd")
+	assert.NoError(t, err)
+	assert.Equal(t, "Cwnd", f)
+
+	// the alias reads the same member
+	assert.Equal(t, fieldsModel4["SndCwnd"].CField, fieldsModel4["Cwnd"].CField)
+	assert.Equal(t, fieldsModel6["SndCwnd"].CField, fieldsModel6["Cwnd"].CField)
+	assert.Equal(t, "Alias of SndCwnd", fieldsModel4["Cwnd"].Desc)
+}
+
 func TestValidateTCPStatus(t *testing.T) {
 	_, err := ValidateTCPStatus("TCP_ESTABLISHED")
 	assert.NoError(t, err)
@@ -29,3 +40,15 @@ func TestValidateTracepoint(t *testing.T) {
 	assert.NoError(t, ValidateTracepoint("tcp:tcp_probe"))
 	assert.Error(t, ValidateTracepoint("tcp:unknown"))
 }
+
+func TestValidateAvailability(t *testing.T) {
+	assert.NoError(t, ValidateAvailability("tcp:tcp_probe", "DSCP"))
+	assert.EqualError(t, ValidateAvailability("tcp:tcp_retransmit_synack", "DSCPName"),
+		"DSCPName is not available on tcp:tcp_retransmit_synack")
+}
+
+func TestValidateAvailabilityRetransType(t *testing.T) {
+	assert.NoError(t, ValidateAvailability("tcp:tcp_retransmit_skb", "RetransType"))
+	assert.EqualError(t, ValidateAvailability("tcp:tcp_probe", "RetransType"),
+		"RetransType is only available on tcp:tcp_retransmit_skb")
+}
@@ -3,13 +3,29 @@ package ebpf
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"net"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
 )
 
+// noQueueMapping is the kernel NO_QUEUE_MAPPING
+const noQueueMapping = 0xFFFF
+
+var noQueueOnce sync.Once
+
+// monotonic returns the clock which bpf_ktime_get_ns reads
+var monotonic = func() uint64 {
+	var ts unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	return uint64(ts.Nano())
+}
+
 type decoder struct {
 	v16    uint16
 	v32    uint32
@@ -18,6 +34,19 @@ type decoder struct {
 	v4     bool
 	ip     net.IP
 	logger *zap.Logger
+
+	// ktime is the event kernel time in wall clock and
+	// zero if the event doesn't carry it
+	ktime int64
+	read  int64
+
+	ifNames map[uint32]string
+	// ifMisses is the expiry (unix nano) of the failed lookups
+	ifMisses map[uint32]int64
+
+	// present is the populated fields of the last record,
+	// the bits are the positions of the fields.
+	present []uint64
 }
 
 func newDecoder(logger *zap.Logger, v4 bool) *decoder {
@@ -27,26 +56,239 @@ func newDecoder(logger *zap.Logger, v4 bool) *decoder {
 	}
 
 	return &decoder{
-		ip:     make(net.IP, size),
-		v4:     v4,
-		logger: logger,
+		ip:       make(net.IP, size),
+		v4:       v4,
+		logger:   logger,
+		ifNames:  map[uint32]string{},
+		ifMisses: map[uint32]int64{},
 	}
 }
 
-func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
-	var prop FieldAttrs
+func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) error {
+	if n := (len(fields) + 63) / 64; len(d.present) != n {
+		d.present = make([]uint64, n)
+	} else {
+		for i := range d.present {
+			d.present[i] = 0
+		}
+	}
 
-	d.c = 0
+	d.eventTime(data, fields)
 
 	buf.WriteRune('{')
+	if err := d.decodeFields(data, fields, buf); err != nil {
+		return err
+	}
+
+	d.agentDelay(fields, buf)
+	d.timestamp(buf)
+
+	return nil
+}
+
+// eventTime reads the kernel time which the bpf program appends
+// to the event, converts it to wall clock and records the delay.
+func (d *decoder) eventTime(data []byte, fields []string) {
+	d.read, d.ktime = time.Now().UnixNano(), 0
+
+	c := ktimeOffset(fields, d.v4)
+	if len(data) < int(c)+8 {
+		return
+	}
+
+	ktime := bytesToUint64(false, data, c)
+	if ktime == 0 {
+		return
+	}
+
+	var delay uint64
+	if mono := monotonic(); mono > ktime {
+		delay = mono - ktime
+	}
+
+	d.ktime = d.read - int64(delay)
+	AgentDelay.Observe(delay / 1000)
+}
+
+// agentDelay writes the derived AgentDelay if both KTime and
+// ReadTime are enabled and it's not requested explicitly.
+func (d *decoder) agentDelay(fields []string, buf *bytes.Buffer) {
+	var kt, rt bool
+
+	for _, f := range fields {
+		switch f {
+		case "KTime":
+			kt = true
+		case "ReadTime":
+			rt = true
+		case "AgentDelay":
+			return
+		}
+	}
+
+	if kt && rt && d.ktime != 0 {
+		d.userSpace("AgentDelay", Delay, buf)
+	}
+}
+
+// userSpace writes the fields which are made by the agent, the
+// time fields are omitted if the event doesn't carry the ktime.
+func (d *decoder) userSpace(field string, dtype DType, buf *bytes.Buffer) {
+	var v int64
+
+	switch dtype {
+	case KTime:
+		v = d.ktime
+	case ReadTime:
+		v = d.read
+	case Delay:
+		v = (d.read - d.ktime) / 1000
+	}
+
+	if d.ktime == 0 {
+		return
+	}
+
+	buf.WriteRune('"')
+	buf.Write([]byte(field))
+	buf.WriteRune('"')
+	buf.WriteRune(':')
+	buf.Write([]byte(strconv.FormatInt(v, 10)))
+	buf.WriteRune(',')
+}
+
+// ktimeOffset returns the offset of the kernel time which is
+// the last member of the bpf output struct.
+func ktimeOffset(fields []string, v4 bool) uint16 {
+	var (
+		c    uint16
+		prop FieldAttrs
+	)
+
+	align := func(n uint16) {
+		if c%n > 0 {
+			c += n - c%n
+		}
+	}
 
 	for _, field := range fields {
+		if IsUserSpace(field) || (v4 && IsV6Only(field)) {
+			continue
+		}
+
+		if v4 {
+			prop = fieldsModel4[field]
+		} else {
+			prop = fieldsModel6[field]
+		}
+
+		if prop.DType == Optional {
+			align(4)
+			c += 4
+			continue
+		}
+
+		switch prop.CType {
+		case u8:
+			c++
+		case u16:
+			align(2)
+			c += 2
+		case u32:
+			align(4)
+			c += 4
+		case u64:
+			align(8)
+			c += 8
+		case u128:
+			align(16)
+			c += 16
+		case char:
+			c += 16
+		}
+	}
+
+	align(8)
+
+	return c
+}
+
+// decodeFields writes the fields followed by comma
+func (d *decoder) decodeFields(data []byte, fields []string, buf *bytes.Buffer) error {
+	var prop FieldAttrs
+
+	d.c = 0
+
+	for i, field := range fields {
 		if d.v4 {
+			if IsV6Only(field) {
+				continue
+			}
 			prop = fieldsModel4[field]
 		} else {
 			prop = fieldsModel6[field]
 		}
 
+		if prop.DS == userSpace {
+			d.userSpace(field, prop.DType, buf)
+			d.mark(i, d.ktime != 0)
+			continue
+		}
+
+		// the unavailable optional value is omitted
+		if prop.DType == Optional {
+			if d.c%4 > 0 {
+				d.c += (4 - (d.c % 4))
+			}
+
+			d.v32 = bytesToUint32(prop.BigEndian, data, d.c)
+			d.c += 4
+
+			if d.v32 == 0 {
+				continue
+			}
+
+			d.mark(i, true)
+
+			buf.WriteRune('"')
+			buf.Write([]byte(field))
+			buf.WriteRune('"')
+			buf.WriteRune(':')
+			buf.Write([]byte(strconv.FormatUint(uint64(d.v32-1), 10)))
+			buf.WriteRune(',')
+
+			continue
+		}
+
+		// the reason of the other state changes is omitted
+		if prop.DType == Reason || prop.DType == Failure {
+			if d.c%4 > 0 {
+				d.c += (4 - (d.c % 4))
+			}
+
+			d.v32 = bytesToUint32(prop.BigEndian, data, d.c)
+			d.c += 4
+
+			reason := closeReason(d.v32)
+			if prop.DType == Failure {
+				reason = failReason(d.v32)
+			}
+
+			if reason == "" {
+				continue
+			}
+
+			d.mark(i, true)
+
+			buf.WriteRune('"')
+			buf.Write([]byte(field))
+			buf.WriteString(`":"`)
+			buf.Write([]byte(reason))
+			buf.WriteString(`",`)
+
+			continue
+		}
+
 		buf.WriteRune('"')
 		buf.Write([]byte(field))
 		buf.WriteRune('"')
@@ -54,8 +296,19 @@ func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
 
 		switch prop.CType {
 		case u8:
+			d.mark(i, data[d.c] != 0)
 
-			buf.Write([]byte(strconv.FormatUint(uint64(data[d.c]), 10)))
+			if prop.DType == DSCPName {
+				buf.WriteRune('"')
+				buf.Write([]byte(dscpName(data[d.c])))
+				buf.WriteRune('"')
+			} else if prop.DType == RetransType {
+				buf.WriteRune('"')
+				buf.Write([]byte(retransType(data[d.c])))
+				buf.WriteRune('"')
+			} else {
+				buf.Write([]byte(strconv.FormatUint(uint64(data[d.c]), 10)))
+			}
 			buf.WriteRune(',')
 
 			d.c++
@@ -66,6 +319,12 @@ func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
 			}
 
 			d.v16 = bytesToUint16(prop.BigEndian, data, d.c)
+			d.mark(i, d.v16 != 0 && d.v16 != noQueueMapping)
+
+			if prop.DType == Queue && d.v16 == noQueueMapping {
+				d.v16 = 0
+				d.noQueue(field)
+			}
 
 			buf.Write([]byte(strconv.FormatUint(uint64(d.v16), 10)))
 			buf.WriteRune(',')
@@ -77,11 +336,18 @@ func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
 				d.c += (4 - (d.c % 4))
 			}
 
+			d.mark(i, bytesToUint32(false, data, d.c) != 0)
+
 			if prop.DType == IP {
 				d.ip = data[d.c : d.c+4]
 				buf.WriteRune('"')
 				buf.Write([]byte(d.ip.String()))
 				buf.WriteRune('"')
+			} else if prop.DType == IfName {
+				d.v32 = bytesToUint32(prop.BigEndian, data, d.c)
+				buf.WriteRune('"')
+				buf.Write([]byte(d.ifName(d.v32)))
+				buf.WriteRune('"')
 			} else {
 				d.v32 = bytesToUint32(prop.BigEndian, data, d.c)
 				buf.Write([]byte(strconv.FormatUint(uint64(d.v32), 10)))
@@ -97,6 +363,7 @@ func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
 			}
 
 			d.v64 = bytesToUint64(prop.BigEndian, data, d.c)
+			d.mark(i, d.v64 != 0)
 
 			buf.Write([]byte(strconv.FormatUint(d.v64, 10)))
 			buf.WriteRune(',')
@@ -109,6 +376,8 @@ func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
 			}
 
 			d.ip = data[d.c : d.c+16]
+			d.mark(i, !d.ip.IsUnspecified())
+
 			buf.WriteRune('"')
 			buf.Write([]byte(d.ip.String()))
 			buf.WriteRune('"')
@@ -119,6 +388,8 @@ func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
 		case char:
 			// TODO padding
 
+			d.mark(i, len(trim(data[d.c:d.c+16])) > 0)
+
 			buf.WriteRune('"')
 			buf.Write(trim(data[d.c : d.c+16]))
 			buf.WriteRune('"')
@@ -127,10 +398,33 @@ func (d *decoder) decode(data []byte, fields []string, buf *bytes.Buffer) {
 			d.c += 16
 
 		default:
-			d.logger.Fatal("decoder", zap.String("msg", "unknown data type"))
+			return fmt.Errorf("unknown data type of %s", field)
 		}
 	}
 
+	return nil
+}
+
+// mark sets the field position if it's populated, the aggregate
+// keys don't track the presence.
+func (d *decoder) mark(i int, populated bool) {
+	if populated && i/64 < len(d.present) {
+		d.present[i/64] |= 1 << uint(i%64)
+	}
+}
+
+// noQueue logs once that the queue mapping is unavailable, e.g. the
+// kernel doesn't record it or the driver has a single queue.
+func (d *decoder) noQueue(field string) {
+	noQueueOnce.Do(func() {
+		if d.logger != nil {
+			d.logger.Info("decoder", zap.String("msg", field+" is not available, it's reported as zero"))
+		}
+	})
+}
+
+// timestamp writes the timestamp and closes the record
+func (d *decoder) timestamp(buf *bytes.Buffer) {
 	buf.WriteRune('"')
 	buf.Write([]byte("Timestamp"))
 	buf.WriteRune('"')
@@ -168,3 +462,112 @@ func trim(b []byte) []byte {
 	}
 	return b
 }
+
+// closeReason classifies the connection close based on the
+// states and the socket error which packed by the BPF program
+// as newstate << 24 | oldstate << 16 | sk_err. it returns an empty
+// string if the event isn't a close transition and unknown if
+// the transition can't be classified for sure.
+func closeReason(v uint32) string {
+	var (
+		newState = v >> 24
+		oldState = v >> 16 & 0xff
+		skErr    = syscall.Errno(v & 0xffff)
+	)
+
+	if newState != uint32(validTCPStatus["TCP_CLOSE"]) {
+		return ""
+	}
+
+	// tcp_reset sets the error based on the state
+	switch {
+	case skErr == syscall.ECONNRESET,
+		skErr == syscall.ECONNREFUSED && oldState == uint32(validTCPStatus["TCP_SYN_SENT"]),
+		skErr == syscall.EPIPE && oldState == uint32(validTCPStatus["TCP_CLOSE_WAIT"]):
+		return "rst_received"
+	case skErr != 0:
+		return "error:" + strconv.Itoa(int(skErr))
+	}
+
+	switch oldState {
+	case uint32(validTCPStatus["TCP_FIN_WAIT2"]),
+		uint32(validTCPStatus["TCP_CLOSING"]),
+		uint32(validTCPStatus["TCP_LAST_ACK"]),
+		uint32(validTCPStatus["TCP_TIME_WAIT"]):
+		return "fin"
+	case uint32(validTCPStatus["TCP_ESTABLISHED"]),
+		uint32(validTCPStatus["TCP_CLOSE_WAIT"]):
+		// tcp_close/tcp_disconnect abort with an active reset
+		return "rst_sent"
+	}
+
+	return "unknown"
+}
+
+// failReason classifies a failed connect attempt (SYN_SENT to CLOSE)
+// based on the same packed value as closeReason, it returns empty
+// for the other state changes.
+func failReason(v uint32) string {
+	var (
+		newState = v >> 24
+		oldState = v >> 16 & 0xff
+		skErr    = syscall.Errno(v & 0xffff)
+	)
+
+	if newState != uint32(validTCPStatus["TCP_CLOSE"]) ||
+		oldState != uint32(validTCPStatus["TCP_SYN_SENT"]) {
+		return ""
+	}
+
+	switch skErr {
+	case syscall.ECONNREFUSED:
+		return "rst_received"
+	case syscall.EHOSTUNREACH:
+		return "host_unreachable"
+	case syscall.ENETUNREACH:
+		return "net_unreachable"
+	case syscall.ETIMEDOUT:
+		return "timed_out"
+	case 0:
+		// closed by the application before the handshake completed
+		return "aborted"
+	}
+
+	return "error:" + strconv.Itoa(int(skErr))
+}
+
+// ifMissTTL is the time which a failed interface lookup is cached,
+// e.g. the index of another network namespace never resolves.
+const ifMissTTL = 30 * time.Second
+
+// interfaceByIndex is replaced by the tests
+var interfaceByIndex = net.InterfaceByIndex
+
+// ifName returns the network interface name of the ifindex, the names
+// are cached per decoder and the unknown index returns as it's. the
+// failed lookups are cached for the ifMissTTL since each of them is
+// a netlink dump of all the interfaces.
+func (d *decoder) ifName(index uint32) string {
+	if index == 0 {
+		return ""
+	}
+
+	if name, ok := d.ifNames[index]; ok {
+		return name
+	}
+
+	if expires, ok := d.ifMisses[index]; ok && d.read < expires {
+		return strconv.FormatUint(uint64(index), 10)
+	}
+
+	iface, err := interfaceByIndex(int(index))
+	if err != nil {
+		d.ifMisses[index] = d.read + int64(ifMissTTL)
+		return strconv.FormatUint(uint64(index), 10)
+	}
+
+	delete(d.ifMisses, index)
+	d.ifNames[index] = iface.Name
+
+	return iface.Name
+}
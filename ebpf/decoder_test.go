@@ -2,7 +2,10 @@ package ebpf
 
 import (
 	"bytes"
+	"errors"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,6 +20,9 @@ func TestDecoderV4(t *testing.T) {
 	d.decode(data, fields, buf)
 
 	assert.Contains(t, buf.String(), expected)
+
+	// the unknown field is returned instead of exiting
+	assert.EqualError(t, d.decode(data, []string{"PID", "Foo"}, buf), "unknown data type of Foo")
 }
 
 func TestDecoderV6(t *testing.T) {
@@ -31,6 +37,158 @@ func TestDecoderV6(t *testing.T) {
 	assert.Contains(t, buf.String(), expected)
 }
 
+func TestDecoderFlowLabel(t *testing.T) {
+	fields := []string{"FlowLabel", "TClass", "DPort"}
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, false)
+	d.decode([]byte{0x45, 0x23, 0x1, 0x0, 0xb8, 0x0, 0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"FlowLabel":74565,"TClass":184,"DPort":443,"Timestamp":`)
+
+	// ipv4 events omit them
+	buf.Reset()
+	d = newDecoder(nil, true)
+	d.decode([]byte{0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"DPort":443,"Timestamp":`)
+}
+
+func TestDecoderDSCP(t *testing.T) {
+	fields := []string{"TOS", "DSCP", "DSCPName", "DPort"}
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode([]byte{0xb8, 0x2e, 0x2e, 0x0, 0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"TOS":184,"DSCP":46,"DSCPName":"EF","DPort":443,"Timestamp":`)
+
+	// ipv6 traffic class
+	buf.Reset()
+	d = newDecoder(nil, false)
+	d.decode([]byte{0x88, 0x22, 0x22, 0x0, 0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"TOS":136,"DSCP":34,"DSCPName":"AF41","DPort":443,"Timestamp":`)
+
+	// configured and unnamed values
+	setDSCPNames(map[uint8]string{34: "video"})
+	defer setDSCPNames(nil)
+
+	assert.Equal(t, "video", dscpName(34))
+	assert.Equal(t, "CS1", dscpName(8))
+	assert.Equal(t, "LE", dscpName(1))
+	assert.Equal(t, "AF13", dscpName(14))
+	assert.Equal(t, "5", dscpName(5))
+}
+
+func TestDecoderTSRTT(t *testing.T) {
+	fields := []string{"TSRTT", "DPort"}
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode([]byte{0x89, 0x13, 0x0, 0x0, 0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"TSRTT":5000,"DPort":443,"Timestamp":`)
+
+	// the peer doesn't use timestamps
+	buf.Reset()
+	d.decode([]byte{0x0, 0x0, 0x0, 0x0, 0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"DPort":443,"Timestamp":`)
+}
+
+func TestDecoderQueue(t *testing.T) {
+	fields := []string{"RxQueue", "TxQueue", "DPort"}
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode([]byte{0x3, 0x0, 0x5, 0x0, 0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"RxQueue":3,"TxQueue":5,"DPort":443,"Timestamp":`)
+
+	// no queue mapping
+	buf.Reset()
+	d.decode([]byte{0xff, 0xff, 0x5, 0x0, 0x1, 0xbb}, fields, buf)
+	assert.Contains(t, buf.String(), `{"RxQueue":0,"TxQueue":5,"DPort":443,"Timestamp":`)
+}
+
+func TestCloseReason(t *testing.T) {
+	pack := func(newState, oldState, skErr uint32) uint32 {
+		return newState<<24 | oldState<<16 | skErr
+	}
+
+	tests := []struct {
+		v      uint32
+		reason string
+	}{
+		{pack(7, 9, 0), "fin"},
+		{pack(7, 5, 0), "fin"},
+		{pack(7, 11, 0), "fin"},
+		{pack(7, 1, 0), "rst_sent"},
+		{pack(7, 8, 0), "rst_sent"},
+		{pack(7, 1, 104), "rst_received"},
+		{pack(7, 2, 111), "rst_received"},
+		{pack(7, 8, 32), "rst_received"},
+		{pack(7, 1, 110), "error:110"},
+		{pack(7, 2, 0), "unknown"},
+		{pack(7, 4, 0), "unknown"},
+		{pack(1, 2, 0), ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.reason, closeReason(tt.v))
+	}
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode([]byte{0x0, 0x0, 0x9, 0x7}, []string{"CloseReason"}, buf)
+	assert.Contains(t, buf.String(), `"CloseReason":"fin"`)
+
+	// the other state changes don't have the reason
+	buf.Reset()
+	d.decode([]byte{0x0, 0x0, 0x2, 0x1, 0x0, 0x50}, []string{"CloseReason", "DPort"}, buf)
+	assert.NotContains(t, buf.String(), "CloseReason")
+	assert.Contains(t, buf.String(), `{"DPort":80,`)
+}
+
+func TestFailReason(t *testing.T) {
+	pack := func(newState, oldState, skErr uint32) uint32 {
+		return newState<<24 | oldState<<16 | skErr
+	}
+
+	tests := []struct {
+		v      uint32
+		reason string
+	}{
+		{pack(7, 2, 111), "rst_received"},
+		{pack(7, 2, 113), "host_unreachable"},
+		{pack(7, 2, 101), "net_unreachable"},
+		{pack(7, 2, 110), "timed_out"},
+		{pack(7, 2, 0), "aborted"},
+		{pack(7, 2, 13), "error:13"},
+		{pack(7, 1, 104), ""},
+		{pack(1, 2, 0), ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.reason, failReason(tt.v))
+	}
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode([]byte{0x6e, 0x0, 0x2, 0x7, 0x0, 0x50}, []string{"FailReason", "DPort"}, buf)
+	assert.Contains(t, buf.String(), `"FailReason":"timed_out","DPort":80`)
+
+	buf.Reset()
+	d.decode([]byte{0x0, 0x0, 0x1, 0x7, 0x0, 0x50}, []string{"FailReason", "DPort"}, buf)
+	assert.NotContains(t, buf.String(), "FailReason")
+}
+
+func TestDecoderSynRetrans(t *testing.T) {
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode([]byte{0x3, 0x0, 0x0, 0x0, 0x0, 0x50}, []string{"SynRetrans", "DPort"}, buf)
+	assert.Contains(t, buf.String(), `"SynRetrans":3,"DPort":80`)
+
+	// other transitions or no retransmit
+	buf.Reset()
+	d.decode([]byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x50}, []string{"SynRetrans", "DPort"}, buf)
+	assert.Contains(t, buf.String(), `"SynRetrans":0,"DPort":80`)
+}
+
 func BenchmarkDecoderV4(b *testing.B) {
 	data := []byte{0xf3, 0xd2, 0x12, 0x0, 0x63, 0x75, 0x72, 0x6c, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xc7, 0xbd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xb4, 0x5, 0x0, 0x0, 0x25, 0x39, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xe, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0xa, 0x0, 0x2, 0xf, 0xac, 0xd9, 0x5, 0xc4, 0x0, 0x50, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 	buf := new(bytes.Buffer)
@@ -69,3 +227,103 @@ func BenchmarkDecoderV4NineFields(b *testing.B) {
 		d.decode(data, fields, buf)
 	}
 }
+
+func TestDecoderVRF(t *testing.T) {
+	iface, err := net.InterfaceByIndex(1)
+	if err != nil {
+		t.Skip("interface index 1 is not available")
+	}
+
+	data := []byte{0x1, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0}
+	fields := []string{"VRF", "VRFName"}
+	expected := `"VRF":1,"VRFName":"` + iface.Name + `"`
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode(data, fields, buf)
+
+	assert.Contains(t, buf.String(), expected)
+	assert.Equal(t, iface.Name, d.ifNames[1])
+
+	assert.Equal(t, "", d.ifName(0))
+	assert.Equal(t, "4294967295", d.ifName(4294967295))
+}
+
+func TestDecoderIfNameMiss(t *testing.T) {
+	lookups := 0
+	interfaceByIndex = func(index int) (*net.Interface, error) {
+		lookups++
+		if lookups < 3 {
+			return nil, errors.New("no such network interface")
+		}
+		return &net.Interface{Index: index, Name: "eth9"}, nil
+	}
+	defer func() { interfaceByIndex = net.InterfaceByIndex }()
+
+	d := newDecoder(nil, true)
+	d.read = time.Now().UnixNano()
+
+	// the failed lookup is cached for the ttl
+	assert.Equal(t, "9", d.ifName(9))
+	assert.Equal(t, "9", d.ifName(9))
+	assert.Equal(t, 1, lookups)
+
+	d.read += int64(ifMissTTL)
+	assert.Equal(t, "9", d.ifName(9))
+	assert.Equal(t, 2, lookups)
+
+	d.read += int64(ifMissTTL)
+	assert.Equal(t, "eth9", d.ifName(9))
+	assert.Equal(t, "eth9", d.ifName(9))
+	assert.Equal(t, 3, lookups)
+	assert.Empty(t, d.ifMisses)
+}
+
+func TestDecoderEventTime(t *testing.T) {
+	mono := monotonic
+	monotonic = func() uint64 { return 5000000 }
+	defer func() { monotonic = mono }()
+
+	// DPort, padding and ktime
+	data := []byte{0x1, 0xbb, 0, 0, 0, 0, 0, 0, 0xc0, 0xc6, 0x2d, 0, 0, 0, 0, 0}
+
+	count := AgentDelay.Snapshot().Count
+
+	buf := new(bytes.Buffer)
+	d := newDecoder(nil, true)
+	d.decode(data, []string{"KTime", "DPort", "ReadTime"}, buf)
+	assert.Regexp(t, `^{"KTime":\d+,"DPort":443,"ReadTime":\d+,"AgentDelay":2000,"Timestamp":\d+}$`, buf.String())
+	assert.Equal(t, d.read-d.ktime, int64(2000000))
+	assert.Equal(t, count+1, AgentDelay.Snapshot().Count)
+
+	// explicit AgentDelay
+	buf.Reset()
+	d.decode(data, []string{"DPort", "AgentDelay"}, buf)
+	assert.Regexp(t, `^{"DPort":443,"AgentDelay":2000,"Timestamp":\d+}$`, buf.String())
+
+	// the event without ktime
+	buf.Reset()
+	d.decode(data[:2], []string{"KTime", "DPort", "ReadTime"}, buf)
+	assert.Regexp(t, `^{"DPort":443,"Timestamp":\d+}$`, buf.String())
+}
+
+func TestKTimeOffset(t *testing.T) {
+	assert.Equal(t, uint16(8), ktimeOffset([]string{"DPort", "KTime"}, true))
+	assert.Equal(t, uint16(16), ktimeOffset([]string{"SRTT", "BytesSent"}, true))
+	assert.Equal(t, uint16(24), ktimeOffset([]string{"Task", "NumSAcks"}, true))
+	// the ipv6 only field
+	assert.Equal(t, uint16(8), ktimeOffset([]string{"FlowLabel", "DPort"}, true))
+	assert.Equal(t, uint16(8), ktimeOffset([]string{"FlowLabel", "DPort"}, false))
+	assert.Equal(t, uint16(32), ktimeOffset([]string{"DPort", "DAddr"}, false))
+}
+
+func TestDecoderRetransType(t *testing.T) {
+	fields := []string{"RetransType", "DPort"}
+
+	for v, expected := range []string{"unknown", "rto", "fast", "tlp", "unknown"} {
+		buf := new(bytes.Buffer)
+		d := newDecoder(nil, true)
+		d.decode([]byte{uint8(v), 0x0, 0x1, 0xbb}, fields, buf)
+		assert.Contains(t, buf.String(), `{"RetransType":"`+expected+`","DPort":443,"Timestamp":`)
+	}
+}
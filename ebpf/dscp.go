@@ -0,0 +1,46 @@
+package ebpf
+
+import "strconv"
+
+// dscpNames are the DSCP class names (RFC 4594, RFC 5865 and RFC 8622)
+var dscpNames = defaultDSCPNames()
+
+func defaultDSCPNames() map[uint8]string {
+	names := map[uint8]string{
+		0:  "CS0",
+		1:  "LE",
+		44: "VA",
+		46: "EF",
+	}
+
+	for i := uint8(1); i < 8; i++ {
+		names[i<<3] = "CS" + strconv.Itoa(int(i))
+	}
+
+	for c := uint8(1); c < 5; c++ {
+		for p := uint8(1); p < 4; p++ {
+			names[c<<3|p<<1] = "AF" + strconv.Itoa(int(c)) + strconv.Itoa(int(p))
+		}
+	}
+
+	return names
+}
+
+// setDSCPNames adds the configured names to the default ones,
+// a configured name overrides the default name of the value.
+func setDSCPNames(names map[uint8]string) {
+	dscpNames = defaultDSCPNames()
+	for v, name := range names {
+		dscpNames[v] = name
+	}
+}
+
+// dscpName returns the class name of the DSCP value, the
+// value itself is returned if it doesn't have a name.
+func dscpName(v uint8) string {
+	if name, ok := dscpNames[v]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(v))
+}
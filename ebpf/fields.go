@@ -24,9 +24,35 @@ const (
 
 const (
 	// IP represents IP data type
-	IP DType = 1
+	IP DType = iota + 1
+	// Reason represents the connection close reason data type
+	Reason
+	// Failure represents the connection establishment failure data type
+	Failure
+	// IfName represents the network interface name data type
+	IfName
+	// Optional represents a value which may not be available, the bpf
+	// program stores the value plus one and zero means unavailable.
+	Optional
+	// Queue represents a NIC queue index, the kernel stores
+	// NO_QUEUE_MAPPING if the queue is not recorded.
+	Queue
+	// KTime represents the kernel event time in wall clock
+	KTime
+	// ReadTime represents the time which the agent read the event
+	ReadTime
+	// Delay represents the time between the kernel event and the read
+	Delay
+	// DSCPName represents the DSCP class name data type
+	DSCPName
+	// RetransType represents the retransmit type data type
+	RetransType
 )
 
+// userSpace is the data source of the fields which are made by the
+// agent, they're not a part of the bpf output struct.
+const userSpace = "user"
+
 // FieldAttrs represents
 type FieldAttrs struct {
 	CType     CType
@@ -64,7 +90,13 @@ func (c CType) String() string {
 func getReqFieldsV4(cfgFields []config.Field) []FieldAttrs {
 	var reqFields []FieldAttrs
 
-	for i, v := range cfgFields {
+	for _, v := range cfgFields {
+		// the ipv4 sockets don't have the ipv6 only fields
+		if IsV6Only(v.Name) || IsUserSpace(v.Name) {
+			continue
+		}
+
+		i := len(reqFields)
 		attrs := fieldsModel4[v.Name]
 		reqFields = append(reqFields, FieldAttrs{
 			CField: attrs.CField,
@@ -84,7 +116,12 @@ func getReqFieldsV4(cfgFields []config.Field) []FieldAttrs {
 func getReqFieldsV6(cfgFields []config.Field) []FieldAttrs {
 	var reqFields []FieldAttrs
 
-	for i, v := range cfgFields {
+	for _, v := range cfgFields {
+		if IsUserSpace(v.Name) {
+			continue
+		}
+
+		i := len(reqFields)
 		attrs := fieldsModel6[v.Name]
 		reqFields = append(reqFields, FieldAttrs{
 			CField: attrs.CField,
@@ -0,0 +1,66 @@
+package ebpf
+
+import (
+	"sync/atomic"
+)
+
+// AgentDelay is the histogram of the perf buffer dwell time in
+// usecs, it's recorded for all the events regardless of the
+// KTime and ReadTime fields. the agent exports it as the
+// tcpdog_agent_delay_seconds metric.
+var AgentDelay = NewHistogram(10, 50, 100, 500, 1000, 5000, 10000, 50000, 100000, 500000, 1000000)
+
+// Histogram represents a lock free histogram with fixed buckets
+type Histogram struct {
+	bounds []uint64
+	counts []uint64
+	count  uint64
+	sum    uint64
+}
+
+// HistogramSnapshot represents a point in time histogram, the last
+// count belongs to the values greater than the last bound.
+type HistogramSnapshot struct {
+	Bounds []uint64
+	Counts []uint64
+	Count  uint64
+	Sum    uint64
+}
+
+// NewHistogram constructs a histogram with the ascending upper bounds
+func NewHistogram(bounds ...uint64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v uint64) {
+	i := 0
+	for ; i < len(h.bounds); i++ {
+		if v <= h.bounds[i] {
+			break
+		}
+	}
+
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, v)
+}
+
+// Snapshot returns the current state of the histogram
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    atomic.LoadUint64(&h.sum),
+	}
+
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+
+	return s
+}
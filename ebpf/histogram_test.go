@@ -0,0 +1,20 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(10, 100)
+
+	for _, v := range []uint64{0, 10, 11, 100, 1000} {
+		h.Observe(v)
+	}
+
+	s := h.Snapshot()
+	assert.Equal(t, []uint64{2, 2, 1}, s.Counts)
+	assert.Equal(t, uint64(5), s.Count)
+	assert.Equal(t, uint64(1121), s.Sum)
+}
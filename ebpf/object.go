@@ -0,0 +1,62 @@
+package ebpf
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/iovisor/gobpf/elf"
+	"go.uber.org/zap"
+)
+
+// objectSection returns the object program section which
+// attaches the program to the tracepoint or the kprobe.
+func objectSection(kind, name string) string {
+	return kind + "/" + strings.Replace(name, ":", "/", 1)
+}
+
+// startObject loads and attaches the precompiled custom program, the
+// attach point is its section thus it should be the tracepoint name.
+func (b *BPF) startObject(ctx context.Context, tp TP, logger *zap.Logger) error {
+	c := tp.Custom
+
+	if section := objectSection(c.Kind, tp.Name); c.Program != section {
+		return fmt.Errorf("custom object program %s doesn't attach %s, want %s", c.Program, tp.Name, section)
+	}
+
+	m := elf.NewModule(c.Object)
+	if err := m.Load(nil); err != nil {
+		return fmt.Errorf("failed to load the custom bpf object %s: %v", c.Object, err)
+	}
+
+	b.objects = append(b.objects, m)
+
+	var err error
+	if c.Kind == "kprobe" {
+		err = m.EnableKprobe(c.Program, 0)
+	} else {
+		err = m.EnableTracepoint(c.Program)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logger.Info("ebpf", zap.String("msg", tp.Name+" has been attached"), zap.String("custom", c.Object))
+
+	ch := b.sampleChan(ctx)
+
+	perfMap, err := elf.InitPerfMap(m, c.Map, ch, b.lostChan(tp.Name))
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < tp.Workers; i++ {
+		go runCustom(ctx, tp, newSchemaDecoder(c), ch, logger)
+	}
+
+	perfMap.PollStart()
+	b.objectMaps = append(b.objectMaps, perfMap)
+
+	return nil
+}
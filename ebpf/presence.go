@@ -0,0 +1,75 @@
+package ebpf
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// presence is the populated fields of a tracepoint since the last
+// heartbeat, the bits are the positions of the tracepoint fields
+// and the workers merge them without a lock.
+type presence struct {
+	fields []string
+	words  []uint64
+}
+
+var presences = struct {
+	sync.Mutex
+	m map[counterKey]*presence
+}{m: map[counterKey]*presence{}}
+
+// newPresence registers the presence of the tracepoint, a reloaded
+// tracepoint replaces the presence of its index and name.
+func newPresence(tp TP) *presence {
+	p := &presence{
+		fields: tp.Fields,
+		words:  make([]uint64, (len(tp.Fields)+63)/64),
+	}
+
+	presences.Lock()
+	presences.m[counterKey{tp.Index, tp.Name}] = p
+	presences.Unlock()
+
+	return p
+}
+
+// merge sets the populated fields of a record, it's a load
+// once the fields have been seen since the last heartbeat.
+func (p *presence) merge(present []uint64) {
+	for i, w := range present {
+		for {
+			old := atomic.LoadUint64(&p.words[i])
+			if old&w == w || atomic.CompareAndSwapUint64(&p.words[i], old, old|w) {
+				break
+			}
+		}
+	}
+}
+
+// Presence returns the populated fields of each tracepoint since the
+// last call e.g. the last heartbeat, the fields of the reloaded
+// tracepoints are merged by their name. the aggregate and the custom
+// tracepoints aren't tracked.
+func Presence() map[string]serialization.Bitmap {
+	presences.Lock()
+	defer presences.Unlock()
+
+	m := map[string]serialization.Bitmap{}
+	for k, p := range presences.m {
+		b := m[k.name]
+		for i := range p.words {
+			w := atomic.SwapUint64(&p.words[i], 0)
+			for j := 0; j < 64 && w != 0; j++ {
+				if w&(1<<uint(j)) != 0 {
+					b.Set(p.fields[i*64+j])
+					w &^= 1 << uint(j)
+				}
+			}
+		}
+		m[k.name] = b
+	}
+
+	return m
+}
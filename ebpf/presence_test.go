@@ -0,0 +1,33 @@
+package ebpf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresence(t *testing.T) {
+	data := []byte{0xf3, 0xd2, 0x12, 0x0, 0x63, 0x75, 0x72, 0x6c, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xc7, 0xbd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xb4, 0x5, 0x0, 0x0, 0x25, 0x39, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xe, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0xa, 0x0, 0x2, 0xf, 0xac, 0xd9, 0x5, 0xc4, 0x0, 0x50, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+	fields := []string{"PID", "Task", "NumSAcks", "SRTT", "RTT", "TotalRetrans", "AdvMSS", "BytesReceived", "SegsIn", "SegsOut", "SAddr", "DAddr", "DPort"}
+
+	tp := TP{Name: "tcp:tcp_presence", Index: 100, Fields: fields}
+	p := newPresence(tp)
+	defer func() {
+		presences.Lock()
+		delete(presences.m, counterKey{tp.Index, tp.Name})
+		presences.Unlock()
+	}()
+
+	d := newDecoder(nil, true)
+	d.decode(data, fields, new(bytes.Buffer))
+	p.merge(d.present)
+
+	// the zero NumSAcks, RTT and TotalRetrans aren't populated
+	b := Presence()[tp.Name]
+	assert.Equal(t, []string{"Task", "PID", "SAddr", "DAddr", "DPort", "BytesReceived", "AdvMSS", "SRTT", "SegsIn", "SegsOut"}, b.Names())
+
+	// it's reset per heartbeat
+	assert.Empty(t, Presence()[tp.Name].Names())
+	assert.Contains(t, Presence(), tp.Name)
+}
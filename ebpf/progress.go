@@ -0,0 +1,37 @@
+package ebpf
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// idleInterval is the interval which a waiting reader confirms
+// it's idle, a quiet host makes progress at this rate.
+var idleInterval = 250 * time.Millisecond
+
+var progress uint64
+
+// Progress returns the readers progress counter, it's increased once
+// a reader reads an event or it's confirmed idle with an empty queue.
+// it doesn't change if all the readers are wedged.
+func Progress() uint64 {
+	return atomic.LoadUint64(&progress)
+}
+
+// next waits for the next event of the perf buffer queue
+func next(ctx context.Context, ch chan []byte, idle *time.Ticker) ([]byte, bool) {
+	for {
+		select {
+		case data := <-ch:
+			atomic.AddUint64(&progress, 1)
+			return data, true
+		case <-idle.C:
+			if len(ch) == 0 {
+				atomic.AddUint64(&progress, 1)
+			}
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
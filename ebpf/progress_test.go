@@ -0,0 +1,40 @@
+package ebpf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan []byte, 2)
+	idle := time.NewTicker(5 * time.Millisecond)
+	defer idle.Stop()
+
+	p := Progress()
+	ch <- []byte{1}
+	data, ok := next(ctx, ch, idle)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1}, data)
+	assert.Equal(t, p+1, Progress())
+
+	// confirmed idle
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ch <- []byte{2}
+	}()
+
+	p = Progress()
+	data, ok = next(ctx, ch, idle)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{2}, data)
+	assert.Greater(t, Progress(), p+1)
+
+	cancel()
+	_, ok = next(ctx, ch, idle)
+	assert.False(t, ok)
+}
@@ -0,0 +1,73 @@
+package ebpf
+
+import "fmt"
+
+// the retransmit types which the bpf program reports
+const (
+	retransUnknown uint8 = iota
+	retransRTO
+	retransFast
+	retransTLP
+)
+
+// retransTypeUnknown reports unknown for all the retransmits, it's
+// used if the kernel BTF doesn't describe the congestion state.
+const retransTypeUnknown = `
+static inline u8 get_retrans_type(struct sock *sk, u8 pending)
+{
+	return 0;
+}
+`
+
+// retransTypeCAState classifies the retransmit by the congestion
+// state: the retransmit timer enters the loss state before it
+// retransmits and the fast retransmits are sent in the recovery
+// state. the loss probe timer doesn't clear the pending event so
+// the tail loss probe is sent with ICSK_TIME_LOSS_PROBE pending.
+const retransTypeCAState = `
+static inline u8 get_retrans_type(struct sock *sk, u8 pending)
+{
+	u8 state = inet_csk(sk)->icsk_ca_state;
+
+	if (state == %d)
+		return %d;
+	if (state == %d)
+		return %d;
+	if (pending == ICSK_TIME_LOSS_PROBE)
+		return %d;
+
+	return 0;
+}
+`
+
+// retransTypeHelper returns the bpf helper of the RetransType field,
+// the strategy is chosen based on the kernel BTF at the program load.
+// the kernels without BTF or with different types report unknown.
+func retransTypeHelper(path string) string {
+	t, err := loadBTF(path, []string{"inet_connection_sock"}, []string{"tcp_ca_state"})
+	if err != nil || !t.hasMembers("inet_connection_sock", "icsk_ca_state", "icsk_pending") {
+		return retransTypeUnknown
+	}
+
+	loss, ok1 := t.enum("tcp_ca_state", "TCP_CA_Loss")
+	recovery, ok2 := t.enum("tcp_ca_state", "TCP_CA_Recovery")
+	if !ok1 || !ok2 {
+		return retransTypeUnknown
+	}
+
+	return fmt.Sprintf(retransTypeCAState, loss, retransRTO, recovery, retransFast, retransTLP)
+}
+
+// retransType returns the name of the retransmit type
+func retransType(v uint8) string {
+	switch v {
+	case retransRTO:
+		return "rto"
+	case retransFast:
+		return "fast"
+	case retransTLP:
+		return "tlp"
+	}
+
+	return "unknown"
+}
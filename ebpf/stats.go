@@ -0,0 +1,125 @@
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+// Counter represents the events and the drops of a tracepoint
+type Counter struct {
+	Index  int
+	Name   string
+	Events uint64
+	Drops  uint64
+}
+
+// counterKey is the index and the name of a tracepoint, the index
+// is per program thus the reloaded tracepoints may share an index.
+type counterKey struct {
+	index int
+	name  string
+}
+
+var counters = struct {
+	sync.Mutex
+	m map[counterKey]*Counter
+}{m: map[counterKey]*Counter{}}
+
+// counter returns the tracepoint counter, it's created once per index and name
+func counter(tp TP) *Counter {
+	counters.Lock()
+	defer counters.Unlock()
+
+	k := counterKey{tp.Index, tp.Name}
+
+	c, ok := counters.m[k]
+	if !ok {
+		c = &Counter{Index: tp.Index, Name: tp.Name}
+		counters.m[k] = c
+	}
+
+	return c
+}
+
+// Stats returns the tracepoints counters in the configuration order
+func Stats() []Counter {
+	counters.Lock()
+	defer counters.Unlock()
+
+	stats := make([]Counter, 0, len(counters.m))
+	for _, c := range counters.m {
+		stats = append(stats, Counter{
+			Index:  c.Index,
+			Name:   c.Name,
+			Events: atomic.LoadUint64(&c.Events),
+			Drops:  atomic.LoadUint64(&c.Drops),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Index == stats[j].Index {
+			return stats[i].Name < stats[j].Name
+		}
+		return stats[i].Index < stats[j].Index
+	})
+
+	return stats
+}
+
+// emit sends the event to the egress channel, it drops the event
+// if the channel is full.
+func (c *Counter) emit(out chan *bytes.Buffer, buf *bytes.Buffer, logger *zap.Logger) {
+	select {
+	case out <- buf:
+		atomic.AddUint64(&c.Events, 1)
+	default:
+		atomic.AddUint64(&c.Drops, 1)
+		drops.Add(drops.AgentQueue, c.Name, 1)
+		logger.Warn("ebpf", zap.String("msg", "egress channel maxed out"))
+	}
+}
+
+// lostChan returns the channel of the perf buffer lost samples of the
+// tracepoint, the perf reader blocks on it thus it's drained until the
+// perf maps have been stopped.
+func (b *BPF) lostChan(name string) chan uint64 {
+	ch := make(chan uint64, 100)
+	b.lost = append(b.lost, ch)
+
+	go func() {
+		for n := range ch {
+			drops.Add(drops.KernelLost, name, n)
+		}
+	}()
+
+	return ch
+}
+
+// sampleChan returns the channel of the perf buffer samples of the
+// tracepoint, the perf reader blocks on it thus it's drained once the
+// tracepoint workers have been stopped until the perf maps have been
+// stopped, otherwise stopping the perf maps blocks forever.
+func (b *BPF) sampleChan(ctx context.Context) chan []byte {
+	ch := make(chan []byte, 1000)
+
+	go func() {
+		<-ctx.Done()
+
+		for {
+			select {
+			case <-ch:
+			case <-b.stopped:
+				return
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,45 @@
+package ebpf
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+func TestStats(t *testing.T) {
+	tp := TP{Name: "tcp:tcp_probe", Index: 100}
+	c := counter(tp)
+	assert.Same(t, c, counter(tp))
+
+	out := make(chan *bytes.Buffer, 1)
+	c.emit(out, new(bytes.Buffer), zap.NewNop())
+	c.emit(out, new(bytes.Buffer), zap.NewNop())
+
+	counter(TP{Name: "tcp:tcp_retransmit_skb", Index: 101})
+
+	stats := Stats()
+	assert.Equal(t, Counter{Index: 100, Name: "tcp:tcp_probe", Events: 1, Drops: 1}, stats[len(stats)-2])
+	assert.Equal(t, "tcp:tcp_retransmit_skb", stats[len(stats)-1].Name)
+	assert.Equal(t, uint64(1), drops.Count(drops.AgentQueue, "tcp:tcp_probe"))
+}
+
+func TestLostChan(t *testing.T) {
+	b := &BPF{}
+	ch := b.lostChan("tcp:tcp_lost")
+	ch <- 5
+	ch <- 2
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.KernelLost, "tcp:tcp_lost") == 7
+	}, time.Second, 10*time.Millisecond)
+
+	// the lost channels are closed once the perf maps are stopped
+	for _, ch := range b.lost {
+		close(ch)
+	}
+}
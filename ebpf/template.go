@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 	"text/template"
+
+	"github.com/mehrdadrad/tcpdog/config"
 )
 
 const includes = `
@@ -12,9 +14,182 @@ const includes = `
 #include <linux/tcp.h>
 `
 
+// initCwnd keeps the congestion window of the first event per
+// connection, the kernel doesn't keep the initial window once
+// the slow start begins. the entry is deleted once the socket
+// is closed otherwise a recycled socket reports the stale one.
+const initCwnd = `
+BPF_TABLE("lru_hash", struct sock *, u32, init_cwnd, 65536);
+
+static inline u32 get_init_cwnd(struct sock *sk, u32 cwnd)
+{
+	u32 *v = init_cwnd.lookup_or_try_init(&sk, &cwnd);
+	if (!v)
+		return cwnd;
+
+	return *v;
+}
+
+int init_cwnd_close(struct tracepoint__sock__inet_sock_set_state* args)
+{
+	if (args->protocol != IPPROTO_TCP || args->newstate != TCP_CLOSE)
+		return 0;
+
+	struct sock *sk = (struct sock *)args->skaddr;
+	init_cwnd.delete(&sk);
+
+	return 0;
+}
+`
+
+// initCwndClose is the program which cleans up the initCwnd
+// entries, it's attached to the state changes of the sockets.
+const (
+	initCwndClose      = "init_cwnd_close"
+	initCwndTracepoint = "sock:inet_sock_set_state"
+)
+
+// skOwner keeps the task which owns the socket, the pid and comm
+// filters look it up since the tracepoints mostly fire in the softirq
+// context (e.g. the retransmit timer or the received segments) where
+// the current task is whatever the softirq has interrupted. the owner
+// is the task which connects or accepts the socket, the accepted
+// socket hasn't any owner till the accept thus its handshake events
+// don't match. the stale owner of a recycled socket is deleted once
+// the passive socket is established.
+const skOwner = `
+struct sk_owner_t {
+	u32 pid;
+	char comm[TASK_COMM_LEN];
+};
+
+BPF_TABLE("lru_hash", struct sock *, struct sk_owner_t, sk_owner, 65536);
+
+static inline void set_sk_owner(struct sock *sk)
+{
+	struct sk_owner_t owner = {};
+
+	owner.pid = bpf_get_current_pid_tgid() >> 32;
+	bpf_get_current_comm(&owner.comm, sizeof(owner.comm));
+	sk_owner.update(&sk, &owner);
+}
+
+int sk_owner_state(struct tracepoint__sock__inet_sock_set_state* args)
+{
+	if (args->protocol != IPPROTO_TCP)
+		return 0;
+
+	struct sock *sk = (struct sock *)args->skaddr;
+
+	// the connect is in the process context
+	if (args->newstate == TCP_SYN_SENT)
+		set_sk_owner(sk);
+	else if (args->oldstate == TCP_SYN_RECV && args->newstate == TCP_ESTABLISHED)
+		sk_owner.delete(&sk);
+
+	return 0;
+}
+
+int sk_owner_accept(struct pt_regs *ctx)
+{
+	struct sock *sk = (struct sock *)PT_REGS_RC(ctx);
+	if (sk)
+		set_sk_owner(sk);
+
+	return 0;
+}
+`
+
+// skOwnerState and skOwnerAccept are the programs which keep the
+// owners of the sockets.
+const (
+	skOwnerState      = "sk_owner_state"
+	skOwnerTracepoint = "sock:inet_sock_set_state"
+	skOwnerAccept     = "sk_owner_accept"
+	skOwnerKretprobe  = "inet_csk_accept"
+)
+
+// tsRTT returns the rtt sample of the timestamp option in usecs plus
+// one, the timestamp clock is in ms. it's the time between the send of
+// the echoed timestamp and the last received segment (tcp_mstamp). the
+// kernel has subtracted the tsoffset from the echoed timestamp at
+// the parse thus it's in the local clock already. the echoed timestamp
+// is zero if the timestamps haven't been negotiated.
+const tsRTT = `
+static inline u32 get_ts_rtt(u32 tsecr, u64 mstamp)
+{
+	if (!tsecr)
+		return 0;
+
+	return ((u32)(mstamp / 1000) - tsecr) * 1000 + 1;
+}
+`
+
+// rxQueue reads the rx queue mapping if the kernel records it
+// otherwise it's NO_QUEUE_MAPPING, the unused macro argument is
+// dropped by the preprocessor.
+const rxQueue = `
+#if defined(CONFIG_XPS) || defined(CONFIG_SOCK_RX_QUEUE_MAPPING)
+#define RX_QUEUE(x) (x)
+#else
+#define RX_QUEUE(x) 0xFFFF
+#endif
+`
+
+// fieldHelpers are the bpf helpers which the fields require
+var fieldHelpers = map[string]string{
+	"InitCwnd": initCwnd,
+	"TSRTT":    tsRTT,
+	"RxQueue":  rxQueue,
+}
+
 var funcMap = template.FuncMap{
 	"isBPF":       strings.HasPrefix,
 	"initializer": initializer,
+	"aggCommon":   aggCommon,
+	"aggDecl":     aggDecl,
+	"aggUpdate":   aggUpdate,
+	"pidFilter":   pidFilter,
+	"commFilter":  commFilter,
+	"portFilter":  portFilter,
+}
+
+// pidFilter returns the condition of the events which
+// don't belong to any of the pids.
+func pidFilter(pids []int) string {
+	conds := make([]string, len(pids))
+	for i, pid := range pids {
+		conds[i] = fmt.Sprintf("filter_pid != %d", pid)
+	}
+
+	return strings.Join(conds, " && ")
+}
+
+// commFilter returns the condition of the events which their
+// process name doesn't start with the prefix, the characters
+// are compared by their codes thus they don't need escaping.
+func commFilter(prefix string) string {
+	conds := make([]string, len(prefix))
+	for i := 0; i < len(prefix); i++ {
+		conds[i] = fmt.Sprintf("filter_comm[%d] != %d", i, prefix[i])
+	}
+
+	return strings.Join(conds, " || ")
+}
+
+// portFilter returns the condition of the events which their
+// port variable isn't in any of the port ranges.
+func portFilter(v string, ranges []config.PortRange) string {
+	conds := make([]string, len(ranges))
+	for i, r := range ranges {
+		if r.From == r.To {
+			conds[i] = fmt.Sprintf("%s == %d", v, r.From)
+		} else {
+			conds[i] = fmt.Sprintf("(%s >= %d && %s <= %d)", v, r.From, v, r.To)
+		}
+	}
+
+	return "!(" + strings.Join(conds, " || ") + ")"
 }
 
 func initializer(ipv int, index int, f FieldAttrs) string {
@@ -26,7 +201,9 @@ func initializer(ipv int, index int, f FieldAttrs) string {
 		e = fmt.Sprintf("%s->%s", f.DS, f.CField)
 	}
 
-	if f.Func != "" {
+	if strings.Contains(f.Func, "%s") {
+		e = fmt.Sprintf(f.Func, e)
+	} else if f.Func != "" {
 		e = fmt.Sprintf("%s(%s)", f.Func, e)
 	}
 
@@ -38,6 +215,10 @@ func initializer(ipv int, index int, f FieldAttrs) string {
 }
 
 const source = `
+	{{if .Agg}}
+	{{aggCommon .Suffix .Agg}}
+	{{- end}}
+
 	{{if .Fields4}}
 	{{if ne .Sample 0}}
 	BPF_HASH(ipv4_sample, struct sock *, u64, 100000);
@@ -51,9 +232,16 @@ const source = `
 		{{- printf "%s %s%d;" $value.CType $value.CField $index }} 
 		{{- end}}
 		{{- end}}
+		{{- if not .Agg}}
+		u64 ktime;
+		{{- end}}
 	};
+	{{if .Agg}}
+	{{aggDecl 4 .Suffix .Fields4 .Agg}}
+	{{- else}}
 	BPF_PERF_OUTPUT(ipv4_events{{.Suffix}});
 	{{- end}}
+	{{- end}}
 
 	{{if .Fields6}}
 	{{if ne .Sample 0}}
@@ -68,8 +256,15 @@ const source = `
 		{{- printf "%s %s%d;" $value.CType $value.CField $index}} 
 		{{- end}}
 		{{- end}}
+		{{- if not .Agg}}
+		u64 ktime;
+		{{- end}}
 	};
+	{{if .Agg}}
+	{{aggDecl 6 .Suffix .Fields6 .Agg}}
+	{{- else}}
 	BPF_PERF_OUTPUT(ipv6_events{{.Suffix}});
+	{{- end}}
 	{{end}}
 
 	
@@ -79,7 +274,11 @@ const source = `
 		if (args->protocol != IPPROTO_TCP)
 			return 0;
 
-		{{if ne .TCPState "TCP_ALL"}}
+		{{if eq .TCPState "TCP_CONNECT_FAIL"}}
+		if (args->oldstate != TCP_SYN_SENT || args->newstate != TCP_CLOSE) {
+			return 0;
+		}
+		{{else if ne .TCPState "TCP_ALL"}}
 		if (args->newstate != {{.TCPState}}) {
 			return 0;
 		}
@@ -88,12 +287,47 @@ const source = `
 
 		struct sock *sk = (struct sock *)args->skaddr;
 
+		{{if or .PIDs .Comm}}
+		struct sk_owner_t *owner = sk_owner.lookup(&sk);
+		if (!owner) {
+			return 0;
+		}
+		{{end}}
+		{{if .PIDs}}
+		u32 filter_pid = owner->pid;
+		if ({{pidFilter .PIDs}}) {
+			return 0;
+		}
+		{{end}}
+		{{if .Comm}}
+		char *filter_comm = owner->comm;
+		if ({{commFilter .Comm}}) {
+			return 0;
+		}
+		{{end}}
+
+		{{if .DPorts}}
+		u16 filter_dport = ntohs(sk->__sk_common.skc_dport);
+		if ({{portFilter "filter_dport" .DPorts}}) {
+			return 0;
+		}
+		{{end}}
+		{{if .SPorts}}
+		u16 filter_sport = sk->__sk_common.skc_num;
+		if ({{portFilter "filter_sport" .SPorts}}) {
+			return 0;
+		}
+		{{end}}
+
 		{{if .TCPInfo}}
 		struct tcp_sock *tcpi = tcp_sk(sk);
 		{{end}}
 		{{if .ICSK}}
 		struct inet_connection_sock *icsk = inet_csk(sk);
 		{{end}}
+		{{if .NP}}
+		struct ipv6_pinfo *np = inet_sk(sk)->pinet6;
+		{{end}}
 
 		u16 family = sk->__sk_common.skc_family;
 
@@ -138,12 +372,27 @@ const source = `
 				ipv4_sample.increment(sk);
 				return 0;
 			}
+			{{- range $index, $value := .Fields4}}
+			{{if eq $value.DS "bpf_sample_weight"}}
+			{{- printf "data4.%s%d = *count + 1;" $value.CField $index}}
+			{{- end}}
+			{{- end}}
 			
 			ipv4_sample.delete(&sk);	
-			
+			{{else}}
+			{{- range $index, $value := .Fields4}}
+			{{if eq $value.DS "bpf_sample_weight"}}
+			{{- printf "data4.%s%d = 1;" $value.CField $index}}
+			{{- end}}
+			{{- end}}
 			{{- end}}
 
+			{{if .Agg}}
+			{{aggUpdate 4 .Suffix .Fields4 .Agg}}
+			{{- else}}
+			data4.ktime = bpf_ktime_get_ns();
 			ipv4_events{{.Suffix}}.perf_submit(args, &data4, sizeof(data4));
+			{{- end}}
 
 			return 0;
 		}
@@ -194,10 +443,26 @@ const source = `
 				ipv6_sample.increment(sk);
 				return 0;
 			}
+			{{- range $index, $value := .Fields6}}
+			{{if eq $value.DS "bpf_sample_weight"}}
+			{{- printf "data6.%s%d = *count + 1;" $value.CField $index}}
+			{{- end}}
+			{{- end}}
 			ipv6_sample.delete(&sk);
+			{{else}}
+			{{- range $index, $value := .Fields6}}
+			{{if eq $value.DS "bpf_sample_weight"}}
+			{{- printf "data6.%s%d = 1;" $value.CField $index}}
+			{{- end}}
+			{{- end}}
 			{{- end}}
 
+			{{if .Agg}}
+			{{aggUpdate 6 .Suffix .Fields6 .Agg}}
+			{{- else}}
+			data6.ktime = bpf_ktime_get_ns();
 			ipv6_events{{.Suffix}}.perf_submit(args, &data6, sizeof(data6));
+			{{- end}}
 
 			return 0;	
 		}
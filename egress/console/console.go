@@ -3,19 +3,54 @@ package console
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"io"
+	"os"
 	"sync"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/metrics"
 )
 
-// New encodes the tcp fields on the console.
+// stdout is the output of the events
+var stdout io.Writer = os.Stdout
+
+// New encodes the tcp fields on the console in the order
+// of the fields list followed by the Timestamp, the status
+// line is erased before printing and it's redrawn per interval. the
+// events are printed as the compressed lines if the payloadCompression
+// has been configured.
 func New(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+	var (
+		cfg      = config.FromContext(ctx)
+		order    = helper.NewFieldOrder(cfg.Fields[tp.Fields])
+		status   = current()
+		inflight = helper.InflightFrom(ctx)
+		out      = stdout
+		b        []byte
+	)
+
+	compress, err := helper.LineCompressorFrom(cfg.Egress[tp.Egress].Config)
+	if err != nil {
+		return err
+	}
+
 	go func() {
 		for {
-			v := <-ch
-			fmt.Println(string(v.Bytes()[1 : v.Len()-1]))
-			bufpool.Put(v)
+			select {
+			case v := <-ch:
+				b = order.AppendJSON(b[:0], v.Bytes())
+				if compress != nil {
+					status.println(out, compress(b))
+				} else {
+					status.println(out, b[1:len(b)-1])
+				}
+				metrics.EgressBytes(tp.Egress, len(b))
+				bufpool.Put(v)
+				inflight.Add(-1)
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
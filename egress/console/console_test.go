@@ -0,0 +1,91 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+)
+
+type syncBuffer struct {
+	sync.Mutex
+	b bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) lines() []string {
+	s.Lock()
+	defer s.Unlock()
+	return strings.Split(strings.TrimSpace(s.b.String()), "\n")
+}
+
+func TestNewTracepoints(t *testing.T) {
+	out := &syncBuffer{}
+	stdout = out
+
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{"console": {Type: "console"}},
+		Fields: map[string][]config.Field{
+			"fields01": {{Name: "RTT"}, {Name: "SAddr"}},
+			"fields02": {{Name: "Task"}, {Name: "OldState"}},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	bufpool := &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	ch := make(chan *bytes.Buffer, 2)
+
+	// the egress is shared by the tracepoints, it's started by the first one
+	tp := config.Tracepoint{Name: "tcp:tcp_probe", Fields: "fields01", Egress: "console"}
+	assert.NoError(t, New(ctx, tp, bufpool, ch))
+
+	ch <- bytes.NewBufferString(`{"SAddr":"10.0.0.1","RTT":5,"Timestamp":1609720926}`)
+	ch <- bytes.NewBufferString(`{"OldState":"TCP_SYN_SENT","Task":"curl","Timestamp":1609720927}`)
+
+	expected := []string{
+		`"RTT":5,"SAddr":"10.0.0.1","Timestamp":1609720926`,
+		`"Timestamp":1609720927,"OldState":"TCP_SYN_SENT","Task":"curl"`,
+	}
+
+	assert.Eventually(t, func() bool { return len(out.lines()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, expected, out.lines())
+}
+
+func TestNewInflight(t *testing.T) {
+	stdout = &syncBuffer{}
+
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{"console": {Type: "console"}},
+		Fields: map[string][]config.Field{"fields01": {{Name: "RTT"}}},
+	}
+
+	inflight := &helper.Inflight{}
+	ctx, cancel := context.WithCancel(helper.WithInflight(cfg.WithContext(context.Background()), inflight))
+	defer cancel()
+
+	bufpool := &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	ch := make(chan *bytes.Buffer, 1)
+
+	tp := config.Tracepoint{Name: "tcp:tcp_probe", Fields: "fields01", Egress: "console"}
+	assert.NoError(t, New(ctx, tp, bufpool, ch))
+
+	// the agent counts the event before it hands it to the egress
+	inflight.Add(1)
+	ch <- bytes.NewBufferString(`{"RTT":5,"Timestamp":1609720926}`)
+
+	assert.Eventually(t, func() bool { return inflight.Len() == 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,187 @@
+package console
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// eraseLine moves the cursor to the line start and erases the line
+const eraseLine = "\r\033[K"
+
+// Counter represents the counters of a tracepoint
+type Counter struct {
+	Name   string
+	Events uint64
+	Drops  uint64
+}
+
+// statusLine shows the events rate per tracepoint, the total events,
+// the drops and the elapsed time on a single stderr line. the console
+// egress erases it before printing an event and it's redrawn per interval.
+type statusLine struct {
+	sync.Mutex
+
+	w     io.Writer
+	width func() int
+	stats func() []Counter
+	drawn bool
+	start time.Time
+	last  time.Time
+	prev  map[string]uint64
+}
+
+var active = struct {
+	sync.Mutex
+	s *statusLine
+}{}
+
+// StartStatus starts the status line if the stderr is a terminal thus
+// it never mixes with the piped or redirected data, it's erased once
+// the context is canceled.
+func StartStatus(ctx context.Context, interval time.Duration, stats func() []Counter) {
+	fd := int(os.Stderr.Fd())
+	if !isTerminal(fd) {
+		return
+	}
+
+	s := newStatusLine(os.Stderr, func() int { return termWidth(fd) }, stats)
+
+	active.Lock()
+	active.s = s
+	active.Unlock()
+
+	go s.run(ctx, interval)
+}
+
+func current() *statusLine {
+	active.Lock()
+	defer active.Unlock()
+
+	return active.s
+}
+
+func newStatusLine(w io.Writer, width func() int, stats func() []Counter) *statusLine {
+	now := time.Now()
+
+	return &statusLine{
+		w:     w,
+		width: width,
+		stats: stats,
+		start: now,
+		last:  now,
+		prev:  map[string]uint64{},
+	}
+}
+
+func (s *statusLine) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.draw(now)
+		case <-ctx.Done():
+			s.clear()
+			return
+		}
+	}
+}
+
+// draw replaces the status line, it's truncated to the terminal
+// width since a wrapped line can't be erased.
+func (s *statusLine) draw(now time.Time) {
+	line := s.render(now, s.stats())
+	if w := s.width(); w > 1 && len(line) > w-1 {
+		line = line[:w-1]
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	io.WriteString(s.w, eraseLine+line)
+	s.drawn = true
+}
+
+// clear erases the status line if it's drawn
+func (s *statusLine) clear() {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.drawn {
+		io.WriteString(s.w, eraseLine)
+		s.drawn = false
+	}
+}
+
+// println prints the event on the stdout, the status line is erased
+// beforehand since both of them share the terminal.
+func (s *statusLine) println(w io.Writer, b []byte) {
+	if s == nil {
+		fmt.Fprintln(w, string(b))
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.drawn {
+		io.WriteString(s.w, eraseLine)
+		s.drawn = false
+	}
+
+	fmt.Fprintln(w, string(b))
+}
+
+func (s *statusLine) render(now time.Time, stats []Counter) string {
+	var (
+		b       strings.Builder
+		events  uint64
+		drops   uint64
+		seconds = now.Sub(s.last).Seconds()
+	)
+
+	for _, c := range stats {
+		rate := float64(0)
+		if seconds > 0 {
+			rate = float64(c.Events-s.prev[c.Name]) / seconds
+		}
+
+		fmt.Fprintf(&b, "%s %.0f/s  ", c.Name, rate)
+
+		s.prev[c.Name] = c.Events
+		events += c.Events
+		drops += c.Drops
+	}
+
+	s.last = now
+
+	fmt.Fprintf(&b, "| events %d | drops %d | %s", events, drops, now.Sub(s.start).Truncate(time.Second))
+
+	return b.String()
+}
+
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+func termWidth(fd int) int {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0
+	}
+
+	return int(ws.Col)
+}
@@ -0,0 +1,64 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusLineRender(t *testing.T) {
+	stats := []Counter{{Name: "tcp:tcp_probe", Events: 100, Drops: 2}, {Name: "sock:inet_sock_set_state", Events: 10}}
+	s := newStatusLine(&bytes.Buffer{}, func() int { return 0 }, nil)
+
+	line := s.render(s.start.Add(2*time.Second), stats)
+	assert.Equal(t, "tcp:tcp_probe 50/s  sock:inet_sock_set_state 5/s  | events 110 | drops 2 | 2s", line)
+
+	stats[0].Events = 400
+	line = s.render(s.start.Add(5*time.Second), stats)
+	assert.Equal(t, "tcp:tcp_probe 100/s  sock:inet_sock_set_state 0/s  | events 410 | drops 2 | 5s", line)
+}
+
+func TestStatusLineDraw(t *testing.T) {
+	w := &bytes.Buffer{}
+	stats := func() []Counter { return []Counter{{Name: "tcp:tcp_probe", Events: 5}} }
+	s := newStatusLine(w, func() int { return 20 }, stats)
+
+	s.draw(s.start.Add(time.Second))
+	assert.Equal(t, eraseLine+"tcp:tcp_probe 5/s  ", w.String())
+	assert.True(t, s.drawn)
+
+	w.Reset()
+	s.clear()
+	assert.Equal(t, eraseLine, w.String())
+
+	// not drawn
+	w.Reset()
+	s.clear()
+	assert.Equal(t, "", w.String())
+}
+
+func TestStatusLineRun(t *testing.T) {
+	w := &bytes.Buffer{}
+	stats := func() []Counter { return []Counter{{Name: "tcp:tcp_probe", Events: 5}} }
+	s := newStatusLine(w, func() int { return 0 }, stats)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	out := w.String()
+	assert.Contains(t, out, "events 5 | drops 0")
+	assert.True(t, strings.HasSuffix(out, eraseLine))
+	assert.False(t, s.drawn)
+}
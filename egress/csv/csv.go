@@ -4,19 +4,24 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/safewriter"
 )
 
 type csv struct {
-	fieldsLen  []int
 	fieldsName []string
-	file       io.WriteCloser
+	order      *helper.FieldOrder
+	values     [][]byte
+	file       *safewriter.Writer
 	buffer     *bytes.Buffer
+	parts      *helper.Partitioner
+	ts         int64
+	compress   func([]byte) []byte
 }
 
 var comma = []byte(",")[0]
@@ -25,31 +30,51 @@ func (c *csv) init(conf map[string]interface{}, fields []config.Field) error {
 	var err error
 
 	for _, f := range fields {
-		c.fieldsLen = append(c.fieldsLen, len(f.Name)+3)
 		c.fieldsName = append(c.fieldsName, f.Name)
 	}
 
+	c.order = helper.NewFieldOrder(fields)
+
+	c.compress, err = helper.LineCompressorFrom(conf)
+	if err != nil {
+		return err
+	}
+
 	filename, ok := conf["filename"].(string)
 	if !ok {
 		return fmt.Errorf("file has not been configured")
 	}
 
-	c.file, err = os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	pCfg, err := helper.PartitionConfigFrom(conf)
+	if err != nil {
+		return err
+	}
+
+	if pCfg.Partition > 0 {
+		c.parts = helper.NewPartitioner(pCfg, filename)
+		return nil
+	}
+
+	c.file, err = safewriter.Open(filename, pCfg.WriterOptions())
 
 	return err
 }
 
 func (c *csv) marshal(buf *bytes.Buffer) {
-	buf.Next(1) // skip bracket
+	if c.parts != nil {
+		var ok bool
+		if c.ts, ok = helper.RecordTimestamp(buf.Bytes()); !ok {
+			c.ts = time.Now().Unix()
+		}
+	}
 
-	for _, l := range c.fieldsLen {
-		buf.Next(l)
-		v, _ := buf.ReadBytes(comma)
+	c.values = c.order.Values(c.values[:0], buf.Bytes())
+	for i, v := range c.values {
+		if i > 0 {
+			c.buffer.WriteByte(comma)
+		}
 		c.buffer.Write(v)
 	}
-
-	buf.Next(12)                 // skip timestamp key
-	c.buffer.Write(buf.Next(10)) // write timestamp
 }
 
 func (c *csv) header() {
@@ -57,12 +82,41 @@ func (c *csv) header() {
 	c.buffer.WriteString(fmt.Sprintf("%s,timestamp", m))
 }
 func (c *csv) flush() {
-	c.buffer.WriteRune('\n')
-	c.file.Write(c.buffer.Bytes())
+	line := c.line()
+	if c.parts != nil {
+		c.parts.Write(c.ts, line)
+	} else {
+		c.file.Write(line)
+	}
 	c.buffer.Reset()
 }
 
+// line returns the buffered line, it's compressed if
+// the payloadCompression has been configured.
+func (c *csv) line() []byte {
+	if c.compress != nil {
+		return append(c.compress(c.buffer.Bytes()), '\n')
+	}
+
+	c.buffer.WriteByte('\n')
+
+	return c.buffer.Bytes()
+}
+
+// sync writes the buffered records to the file(s)
+func (c *csv) sync() {
+	if c.parts != nil {
+		c.parts.Flush()
+		return
+	}
+	c.file.Flush()
+}
+
 func (c *csv) cleanup() {
+	if c.parts != nil {
+		c.parts.Close()
+		return
+	}
 	c.file.Close()
 }
 
@@ -80,11 +134,19 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 	}
 
 	c.header()
-	c.flush()
+	if c.parts != nil {
+		// the header is written at the beginning of each partition file
+		c.parts.SetHeader(c.line())
+		c.buffer.Reset()
+	} else {
+		c.flush()
+		c.sync()
+	}
 
 	go func() {
 		defer c.cleanup()
 		var buf *bytes.Buffer
+		inflight := helper.InflightFrom(ctx)
 
 		for {
 			select {
@@ -97,6 +159,11 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 			c.flush()
 
 			bufpool.Put(buf)
+			inflight.Add(-1)
+
+			if len(ch) == 0 {
+				c.sync()
+			}
 		}
 	}()
 
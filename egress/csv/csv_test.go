@@ -5,11 +5,13 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -77,3 +79,72 @@ func TestStart(t *testing.T) {
 	err = Start(ctx, tp, bufPool, ch)
 	assert.Error(t, err)
 }
+
+func TestMarshalOrder(t *testing.T) {
+	c := &csv{
+		buffer: new(bytes.Buffer),
+		order:  helper.NewFieldOrder([]config.Field{{Name: "F1"}, {Name: "F2"}, {Name: "F3"}}),
+	}
+
+	for _, e := range []string{
+		`{"F1":5,"F2":"a","F3":7,"Timestamp":1609564925}`,
+		`{"F3":7,"F1":5,"F2":"a","Timestamp":1609564925}`,
+		`{"Timestamp":1609564925,"F2":"a","F3":7,"F1":5}`,
+	} {
+		c.marshal(bytes.NewBufferString(e))
+		assert.Equal(t, `5,"a",7,1609564925`, c.buffer.String())
+		c.buffer.Reset()
+	}
+}
+
+func TestStartPartition(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tp := config.Tracepoint{
+		Egress: "myegress",
+		Fields: "myfields",
+	}
+	ch := make(chan *bytes.Buffer, 1)
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	dir := t.TempDir()
+
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"myegress": {
+				Type: "csv",
+				Config: map[string]interface{}{
+					"filename":  filepath.Join(dir, "tcpdog.csv"),
+					"partition": 3600,
+				},
+			},
+		},
+		Fields: map[string][]config.Field{
+			"myfields": {
+				{Name: "F1"},
+			},
+		},
+	}
+
+	ctx = cfg.WithContext(ctx)
+
+	err := Start(ctx, tp, bufPool, ch)
+	assert.NoError(t, err)
+
+	ch <- bytes.NewBufferString(`{"F1":5,"Timestamp":1609564925}`)
+	ch <- bytes.NewBufferString(`{"F1":6,"Timestamp":1609567200}`)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "2021/01/02/05/tcpdog.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "F1,timestamp\n5,1609564925\n", string(b))
+
+	b, err = ioutil.ReadFile(filepath.Join(dir, "2021/01/02/06/tcpdog.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "F1,timestamp\n6,1609567200\n", string(b))
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/mehrdadrad/tcpdog/egress/grpc"
 	"github.com/mehrdadrad/tcpdog/egress/jsonl"
 	"github.com/mehrdadrad/tcpdog/egress/kafka"
+	"github.com/mehrdadrad/tcpdog/egress/nats"
+	"github.com/mehrdadrad/tcpdog/egress/syslog"
+	"github.com/mehrdadrad/tcpdog/spool"
 )
 
 // Start starts an output based on the output type at configuration.
@@ -20,6 +23,19 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 	cfg := config.FromContext(ctx)
 	egress := cfg.Egress[tp.Egress]
 
+	// the spool sits between the tracepoints and the egress
+	if egress.Spool != nil {
+		s, err := spool.Open(tp.Egress, egress.Spool)
+		if err != nil {
+			return err
+		}
+
+		out := make(chan *bytes.Buffer, cap(ch))
+		go s.Run(ctx, bufpool, ch, out)
+
+		ctx, ch = spool.WithContext(ctx, s), out
+	}
+
 	switch egress.Type {
 	case "kafka":
 		err = kafka.Start(ctx, tp, bufpool, ch)
@@ -27,10 +43,16 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 		err = grpc.Start(ctx, tp, bufpool, ch)
 	case "grpc-spb":
 		err = grpc.StartStructPB(ctx, tp, bufpool, ch)
+	case "grpc-cbor":
+		err = grpc.StartCBOR(ctx, tp, bufpool, ch)
 	case "csv":
 		err = csv.Start(ctx, tp, bufpool, ch)
 	case "jsonl":
 		err = jsonl.Start(ctx, tp, bufpool, ch)
+	case "nats":
+		err = nats.Start(ctx, tp, bufpool, ch)
+	case "syslog":
+		err = syslog.Start(ctx, tp, bufpool, ch)
 	default:
 		err = console.New(ctx, tp, bufpool, ch)
 	}
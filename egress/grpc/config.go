@@ -1,21 +1,88 @@
 package grpc
 
-import "github.com/mehrdadrad/tcpdog/config"
+import (
+	"fmt"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
 
 type grpcConf struct {
 	Server    string
 	TLSConfig config.TLSConfig
+
+	// Balancer is the client-side load balancing policy (round_robin),
+	// the server name is resolved to all its addresses every
+	// ResolverRefresh seconds once the balancer is set.
+	Balancer        string
+	ResolverRefresh int
+	// Streams is the number of the concurrent streams, the events are
+	// spread across the streams and each stream is balanced separately.
+	// it's the number of the resolved addresses by default once the
+	// balancer is set, otherwise it's one.
+	Streams int
+	// FlowControl subscribes to the server backpressure hints
+	FlowControl *flowControlConf
+
+	// KeepaliveTime (seconds) pings the server if the connection is
+	// idle, it's disabled by default. the connection is closed if the
+	// ping isn't acked in KeepaliveTimeout (seconds).
+	KeepaliveTime       int  `json:"keepalive-time"`
+	KeepaliveTimeout    int  `json:"keepalive-timeout"`
+	PermitWithoutStream bool `json:"permit-without-stream"`
+
+	// ReconnectBackoff and ReconnectMaxBackoff (milliseconds) are the
+	// exponential backoff of the stream re-establishment, MaxPending
+	// is the max events which are held while it's disconnected.
+	ReconnectBackoff    int `json:"reconnect-backoff"`
+	ReconnectMaxBackoff int `json:"reconnect-max-backoff"`
+	MaxPending          int `json:"max-pending"`
 }
 
 func gRPCConfig(cfg map[string]interface{}) (*grpcConf, error) {
 	// default config
 	gCfg := &grpcConf{
-		Server: "localhost:8085",
+		Server:          "localhost:8085",
+		ResolverRefresh: 30,
+
+		KeepaliveTimeout:    20,
+		ReconnectBackoff:    500,
+		ReconnectMaxBackoff: 30000,
+		MaxPending:          1000,
 	}
 
 	if err := config.Transform(cfg, gCfg); err != nil {
 		return nil, err
 	}
 
+	if gCfg.Balancer != "" && gCfg.ResolverRefresh < 1 {
+		return nil, fmt.Errorf("wrong resolver refresh:%d", gCfg.ResolverRefresh)
+	}
+
+	if gCfg.Streams < 1 && gCfg.Balancer == "" {
+		gCfg.Streams = 1
+	}
+
+	if gCfg.ReconnectBackoff < 1 {
+		gCfg.ReconnectBackoff = 1
+	}
+
+	if gCfg.ReconnectMaxBackoff < gCfg.ReconnectBackoff {
+		gCfg.ReconnectMaxBackoff = gCfg.ReconnectBackoff
+	}
+
+	if f := gCfg.FlowControl; f != nil {
+		if f.Policy == "" {
+			f.Policy = "sample"
+		}
+
+		if f.Policy != "sample" && f.Policy != "pause" {
+			return nil, fmt.Errorf("wrong flow control policy:%s", f.Policy)
+		}
+
+		if f.MaxFactor == 0 {
+			f.MaxFactor = 16
+		}
+	}
+
 	return gCfg, nil
 }
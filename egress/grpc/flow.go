@@ -0,0 +1,151 @@
+package grpc
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// flowControlConf represents the reaction to the server backpressure
+// hints, the sample policy sends one of every factor events and the
+// pause policy drops all of them. the critical tracepoints are never
+// throttled.
+type flowControlConf struct {
+	Policy    string
+	MaxFactor uint32
+}
+
+// throttle applies the server hints to a tracepoint
+type throttle struct {
+	tp        string
+	policy    string
+	critical  bool
+	maxFactor uint32
+	logger    *zap.Logger
+
+	factor  uint32
+	n       uint64
+	dropped uint64
+}
+
+func newThrottle(tp config.Tracepoint, fCfg *flowControlConf, logger *zap.Logger) *throttle {
+	t := &throttle{
+		tp:        tp.Name,
+		policy:    fCfg.Policy,
+		critical:  tp.Priority == "critical",
+		maxFactor: fCfg.MaxFactor,
+		logger:    logger,
+		factor:    1,
+	}
+
+	t.report(1)
+
+	return t
+}
+
+// report exports the throttle state, a critical tracepoint isn't throttled
+func (t *throttle) report(factor uint32) {
+	if t.critical {
+		factor = 1
+	}
+
+	metrics.Throttle(t.tp, factor, factor > 1 && t.policy == "pause")
+}
+
+// allow returns false if the event should be dropped, it's
+// safe to call on a nil throttle.
+func (t *throttle) allow() bool {
+	if t == nil || t.critical {
+		return true
+	}
+
+	factor := atomic.LoadUint32(&t.factor)
+	if factor <= 1 {
+		return true
+	}
+
+	if t.policy == "sample" && atomic.AddUint64(&t.n, 1)%uint64(factor) == 0 {
+		return true
+	}
+
+	atomic.AddUint64(&t.dropped, 1)
+
+	return false
+}
+
+// set applies the hint and logs the adjustment
+func (t *throttle) set(h *pb.FlowControlHint) {
+	factor := uint32(1)
+	if h.GetAction() == pb.FlowControlAction_SLOW_DOWN && h.GetFactor() > 1 {
+		factor = h.GetFactor()
+		if factor > t.maxFactor {
+			factor = t.maxFactor
+		}
+	}
+
+	previous := atomic.SwapUint32(&t.factor, factor)
+	if previous == factor {
+		return
+	}
+
+	t.report(factor)
+
+	t.logger.Info("grpc", zap.String("msg", "throttle has been adjusted"),
+		zap.String("tracepoint", t.tp), zap.Uint32("factor", factor),
+		zap.Uint32("previous", previous), zap.String("policy", t.policy),
+		zap.Bool("critical", t.critical), zap.Uint64("dropped", atomic.LoadUint64(&t.dropped)))
+}
+
+// subscribe receives the server hints until the context is done, the
+// throttle resets once the stream is broken and it gives up if the
+// server doesn't support the flow control.
+func (t *throttle) subscribe(ctx context.Context, client pb.TCPDogClient) {
+	hostname, _ := os.Hostname()
+	backoff := helper.NewBackoff(t.logger)
+
+	for {
+		if !backoff.Wait(ctx) {
+			return
+		}
+
+		err := t.recv(ctx, client, hostname)
+		t.set(&pb.FlowControlHint{Action: pb.FlowControlAction_RESUME})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if status.Code(err) == codes.Unimplemented {
+			t.logger.Info("grpc", zap.String("msg", "flow control is not available on the server"),
+				zap.String("tracepoint", t.tp))
+			return
+		}
+
+		t.logger.Warn("grpc", zap.String("msg", "flow control"), zap.Error(err))
+	}
+}
+
+func (t *throttle) recv(ctx context.Context, client pb.TCPDogClient, hostname string) error {
+	stream, err := client.FlowControl(ctx, &pb.FlowControlRequest{Hostname: hostname})
+	if err != nil {
+		return err
+	}
+
+	for {
+		h, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		t.set(h)
+	}
+}
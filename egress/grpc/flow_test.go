@@ -0,0 +1,153 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+type hinter struct {
+	pb.UnimplementedTCPDogServer
+
+	hints chan *pb.FlowControlHint
+}
+
+func (h *hinter) FlowControl(req *pb.FlowControlRequest, srv pb.TCPDog_FlowControlServer) error {
+	for {
+		select {
+		case hint := <-h.hints:
+			if err := srv.Send(hint); err != nil {
+				return err
+			}
+		case <-srv.Context().Done():
+			return nil
+		}
+	}
+}
+
+func scrape() string {
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(config.Tracepoint{Name: "foo"}, &flowControlConf{Policy: "sample", MaxFactor: 4}, zap.NewNop())
+
+	count := func() int {
+		n := 0
+		for i := 0; i < 100; i++ {
+			if th.allow() {
+				n++
+			}
+		}
+		return n
+	}
+
+	assert.Equal(t, 100, count())
+
+	th.set(&pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: 2})
+	assert.Equal(t, 50, count())
+	assert.Contains(t, scrape(), `tcpdog_agent_throttle_factor{tracepoint="foo"} 2`)
+	assert.Contains(t, scrape(), `tcpdog_agent_throttle_paused{tracepoint="foo"} 0`)
+
+	// capped by the max factor
+	th.set(&pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: 64})
+	assert.Equal(t, uint32(4), atomic.LoadUint32(&th.factor))
+	assert.Equal(t, 25, count())
+
+	th.policy = "pause"
+	assert.Equal(t, 0, count())
+
+	th.critical = true
+	assert.Equal(t, 100, count())
+	th.critical = false
+
+	th.set(&pb.FlowControlHint{Action: pb.FlowControlAction_RESUME})
+	assert.Equal(t, 100, count())
+	assert.Equal(t, uint64(50+75+100), atomic.LoadUint64(&th.dropped))
+	assert.Contains(t, scrape(), `tcpdog_agent_throttle_factor{tracepoint="foo"} 1`)
+
+	// the pause policy is reported as paused
+	th.set(&pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: 2})
+	assert.Contains(t, scrape(), `tcpdog_agent_throttle_paused{tracepoint="foo"} 1`)
+
+	var nilThrottle *throttle
+	assert.True(t, nilThrottle.allow())
+}
+
+func TestThrottleSubscribe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &hinter{hints: make(chan *pb.FlowControlHint)}
+	gServer := grpc.NewServer()
+	pb.RegisterTCPDogServer(gServer, h)
+	go gServer.Serve(l)
+	defer gServer.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	th := newThrottle(config.Tracepoint{Name: "foo"}, &flowControlConf{Policy: "sample", MaxFactor: 16}, zap.NewNop())
+	done := make(chan struct{})
+	go func() {
+		th.subscribe(ctx, pb.NewTCPDogClient(conn))
+		close(done)
+	}()
+
+	h.hints <- &pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: 8}
+	assert.Eventually(t, func() bool { return atomic.LoadUint32(&th.factor) == 8 }, time.Second, time.Millisecond)
+
+	// the server is gone
+	gServer.Stop()
+	assert.Eventually(t, func() bool { return atomic.LoadUint32(&th.factor) == 1 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("time exceeded")
+	}
+}
+
+func TestThrottleUnimplemented(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gServer := grpc.NewServer()
+	pb.RegisterTCPDogServer(gServer, &counter{})
+	go gServer.Serve(l)
+	defer gServer.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	cfg := config.Config{}
+	ms := cfg.SetMockLogger("flowcontrol")
+
+	th := newThrottle(config.Tracepoint{Name: "foo"}, &flowControlConf{Policy: "sample"}, cfg.Logger())
+	th.subscribe(context.Background(), pb.NewTCPDogClient(conn))
+
+	assert.Contains(t, ms.String(), "flow control is not available on the server")
+}
@@ -6,133 +6,228 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	pb "github.com/mehrdadrad/tcpdog/proto"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/protobuf/encoding/protojson"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/fault"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
+	"github.com/mehrdadrad/tcpdog/serialization"
 )
 
+// userAgent is the user agent prefix of the agent version
+const userAgent = "tcpdog-agent/"
+
 // StartStructPB sends fields to a grpc server with structpb type.
 func StartStructPB(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
-	var (
-		stream pb.TCPDog_TracepointSPBClient
-		conn   *grpc.ClientConn
-	)
-
-	cfg := config.FromContext(ctx)
-	logger := cfg.Logger()
-	backoff := helper.NewBackoff(logger)
-
-	gCfg, err := gRPCConfig(cfg.Egress[tp.Egress].Config)
-	if err != nil {
-		return err
-	}
+	return start(ctx, tp, ch, func(ctx context.Context, client pb.TCPDogClient, th *throttle, p *pending, connected func()) error {
+		stream, err := client.TracepointSPB(ctx)
+		if err != nil {
+			return err
+		}
+		connected()
 
-	opts, err := dialOpts(gCfg)
-	if err != nil {
-		return err
-	}
+		return structpb(ctx, stream, tp, th, p, bufpool, ch)
+	})
+}
 
-	go func() {
-		for {
-			backoff.Next()
+func structpb(ctx context.Context, stream pb.TCPDog_TracepointSPBClient, tp config.Tracepoint, th *throttle, p *pending, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+	var (
+		cfg    = config.FromContext(ctx)
+		spb    = helper.NewStructPB(cfg.Fields[tp.Fields])
+		lane   = priority.FromContext(ctx)
+		logger = cfg.Logger()
+	)
 
-			conn, err = grpc.Dial(gCfg.Server, opts...)
-			if err != nil {
-				logger.Warn("grpc", zap.Error(err))
-				continue
-			}
+	for {
+		if p.replayed(ctx) {
+			stream.CloseAndRecv()
+			return errReplayed
+		}
 
-			client := pb.NewTCPDogClient(conn)
-			stream, err = client.TracepointSPB(ctx)
-			if err != nil {
-				logger.Warn("grpc", zap.Error(err))
-				conn.Close()
-				continue
-			}
+		buf, c, ok := p.recv(ctx, lane, ch)
+		if !ok {
+			stream.CloseAndRecv()
+			return nil
+		}
 
-			logger.Info("grpc", zap.String("msg",
-				fmt.Sprintf("%s has been connected to %s", tp.Egress, gCfg.Server)))
+		// the high events aren't throttled
+		if c != priority.High && !th.allow() {
+			p.release(bufpool, buf)
+			continue
+		}
 
-			err = structpb(ctx, stream, tp, bufpool, ch)
-			if err != nil {
-				logger.Warn("grpc", zap.Error(err))
-				conn.Close()
-				continue
-			}
+		fields, err := spb.Unmarshal(buf)
+		if err != nil {
+			drops.Add(drops.EgressPublish, tp.Egress, 1)
+			logger.Error("grpc", zap.Error(err))
+			p.release(bufpool, buf)
+			continue
+		}
 
-			break
+		err = fault.Check(fault.GRPCSend)
+		if err == nil {
+			err = stream.Send(&pb.FieldsSPB{Fields: fields})
+		}
+		if err != nil {
+			// the event is replayed once the stream is reconnected
+			p.hold(ctx, buf)
+			metrics.EgressError(tp.Egress)
+			return err
 		}
 
-	}()
+		p.delivered()
 
-	return nil
+		metrics.EgressBytes(tp.Egress, buf.Len())
+		p.release(bufpool, buf)
+	}
 }
 
-func structpb(ctx context.Context, stream pb.TCPDog_TracepointSPBClient, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+func protobuf(ctx context.Context, stream pb.TCPDog_TracepointClient, tp config.Tracepoint, th *throttle, p *pending, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
 	var (
-		cfg = config.FromContext(ctx)
-		spb = helper.NewStructPB(cfg.Fields[tp.Fields])
-		buf *bytes.Buffer
-		err error
+		lane        = priority.FromContext(ctx)
+		hostname, _ = os.Hostname()
 	)
 
 	for {
-		select {
-		case buf = <-ch:
-			err = stream.Send(&pb.FieldsSPB{
-				Fields: spb.Unmarshal(buf),
-			})
-			if err != nil {
-				return err
-			}
+		if p.replayed(ctx) {
+			stream.CloseAndRecv()
+			return errReplayed
+		}
 
-			bufpool.Put(buf)
-		case <-ctx.Done():
+		buf, c, ok := p.recv(ctx, lane, ch)
+		if !ok {
 			stream.CloseAndRecv()
 			return nil
 		}
+
+		if c != priority.High && !th.allow() {
+			p.release(bufpool, buf)
+			continue
+		}
+
+		m := pb.Fields{}
+		protojson.Unmarshal(buf.Bytes(), &m)
+		m.Hostname = &hostname
+		err := fault.Check(fault.GRPCSend)
+		if err == nil {
+			err = stream.Send(&m)
+		}
+		if err != nil {
+			// the event is replayed once the stream is reconnected
+			p.hold(ctx, buf)
+			metrics.EgressError(tp.Egress)
+			return err
+		}
+
+		p.delivered()
+
+		metrics.EgressBytes(tp.Egress, buf.Len())
+		p.release(bufpool, buf)
 	}
 }
 
-func protobuf(ctx context.Context, stream pb.TCPDog_TracepointClient, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+// cborpb sends the json events encoded by cbor with the hostname, the
+// server decodes them to the pb fields thus the stream is the pb stream.
+func cborpb(ctx context.Context, stream pb.TCPDog_TracepointClient, tp config.Tracepoint, th *throttle, p *pending, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
 	var (
-		buf         *bytes.Buffer
+		lane        = priority.FromContext(ctx)
+		logger      = config.FromContext(ctx).Logger()
 		hostname, _ = os.Hostname()
+		jsonTail    = []byte(fmt.Sprintf("\"Hostname\":%q}", hostname))
 	)
 
 	for {
-		select {
-		case buf = <-ch:
-			m := pb.Fields{}
-			protojson.Unmarshal(buf.Bytes(), &m)
-			m.Hostname = &hostname
-			if err := stream.Send(&m); err != nil {
-				return err
-			}
+		if p.replayed(ctx) {
+			stream.CloseAndRecv()
+			return errReplayed
+		}
 
-			bufpool.Put(buf)
-		case <-ctx.Done():
+		buf, c, ok := p.recv(ctx, lane, ch)
+		if !ok {
 			stream.CloseAndRecv()
 			return nil
 		}
+
+		if c != priority.High && !th.allow() {
+			p.release(bufpool, buf)
+			continue
+		}
+
+		b := make([]byte, 0, buf.Len()+len(jsonTail))
+		b = append(b, buf.Bytes()...)
+		b[len(b)-1] = ','
+
+		m, err := serialization.JSONToCBOR(append(b, jsonTail...))
+		if err != nil {
+			drops.Add(drops.EgressPublish, tp.Egress, 1)
+			logger.Error("grpc", zap.Error(err))
+			p.release(bufpool, buf)
+			continue
+		}
+
+		err = fault.Check(fault.GRPCSend)
+		if err == nil {
+			err = stream.SendMsg(serialization.RawCBOR(m))
+		}
+		if err != nil {
+			// the event is replayed once the stream is reconnected
+			p.hold(ctx, buf)
+			metrics.EgressError(tp.Egress)
+			return err
+		}
+
+		p.delivered()
+
+		metrics.EgressBytes(tp.Egress, len(m))
+		p.release(bufpool, buf)
 	}
 }
 
+// StartCBOR sends fields to a grpc server with the cbor content subtype
+func StartCBOR(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+	return start(ctx, tp, ch, func(ctx context.Context, client pb.TCPDogClient, th *throttle, p *pending, connected func()) error {
+		stream, err := client.Tracepoint(ctx, grpc.CallContentSubtype("cbor"),
+			grpc.ForceCodec(serialization.CBORCodec{}))
+		if err != nil {
+			return err
+		}
+		connected()
+
+		return cborpb(ctx, stream, tp, th, p, bufpool, ch)
+	})
+}
+
 // Start sends fields to a grpc server
 func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
-	var (
-		stream pb.TCPDog_TracepointClient
-		conn   *grpc.ClientConn
-	)
+	return start(ctx, tp, ch, func(ctx context.Context, client pb.TCPDogClient, th *throttle, p *pending, connected func()) error {
+		stream, err := client.Tracepoint(ctx)
+		if err != nil {
+			return err
+		}
+		connected()
 
+		return protobuf(ctx, stream, tp, th, p, bufpool, ch)
+	})
+}
+
+// start dials the server and runs the configured number of streams
+// on the connection, with round_robin balancing each stream goes to
+// the next resolved backend. the streams share the tracepoint throttle
+// if the flow control is configured. a broken stream is re-established
+// by the reconnect backoff and the events are held meanwhile, the
+// held events are replayed by a replay stream once it's reconnected.
+func start(ctx context.Context, tp config.Tracepoint, ch chan *bytes.Buffer, send func(context.Context, pb.TCPDogClient, *throttle, *pending, func()) error) error {
 	cfg := config.FromContext(ctx)
 	logger := cfg.Logger()
-	backoff := helper.NewBackoff(logger)
 
 	gCfg, err := gRPCConfig(cfg.Egress[tp.Egress].Config)
 	if err != nil {
@@ -144,44 +239,96 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 		return err
 	}
 
-	go func() {
-		for {
-			backoff.Next()
+	// the agent version is the handshake of the ingress quarantine
+	opts = append(opts, grpc.WithUserAgent(userAgent+cfg.Version()))
 
-			conn, err = grpc.Dial(gCfg.Server, opts...)
-			if err != nil {
-				logger.Warn("grpc", zap.Error(err))
-				continue
+	conn, err := grpc.Dial(target(gCfg), opts...)
+	if err != nil {
+		return err
+	}
+
+	client := pb.NewTCPDogClient(conn)
+
+	var th *throttle
+	if gCfg.FlowControl != nil {
+		th = newThrottle(tp, gCfg.FlowControl, logger)
+		go th.subscribe(ctx, client)
+	}
+
+	if gCfg.Streams < 1 {
+		gCfg.Streams = streams(ctx, gCfg.Server)
+	}
+
+	p := newPending(tp.Egress, gCfg.MaxPending)
+	p.inflight = helper.InflightFrom(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < gCfg.Streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if gCfg.Balancer != "" && !settle(ctx, conn) {
+				return
 			}
 
-			client := pb.NewTCPDogClient(conn)
-			stream, err = client.Tracepoint(ctx)
-			if err != nil {
-				logger.Warn("grpc", zap.Error(err))
-				conn.Close()
-				continue
+			b := &backoff{
+				min: time.Duration(gCfg.ReconnectBackoff) * time.Millisecond,
+				max: time.Duration(gCfg.ReconnectMaxBackoff) * time.Millisecond,
+			}
+
+			// the stream after the replay stream is on the same connection
+			replayed := false
+			connected := func() {
+				if !replayed {
+					logger.Info("grpc", zap.String("msg",
+						fmt.Sprintf("%s has been connected to %s", tp.Egress, gCfg.Server)))
+				}
 			}
 
-			logger.Info("grpc", zap.String("msg",
-				fmt.Sprintf("%s has been connected to %s", tp.Egress, gCfg.Server)))
+			for {
+				// the stream replays the held events first
+				err := send(p.withReplay(ctx), client, th, p, connected)
+				if err == nil {
+					return
+				}
+
+				if replayed = err == errReplayed; replayed {
+					continue
+				}
 
-			err = protobuf(ctx, stream, bufpool, ch)
-			if err != nil {
 				logger.Warn("grpc", zap.Error(err))
-				conn.Close()
-				continue
-			}
 
-			break
-		}
+				if p.takeDelivered() {
+					b.reset()
+				}
 
+				if !p.wait(ctx, b.next(), ch) {
+					return
+				}
+
+				if n := p.takeDropped(); n > 0 {
+					logger.Warn("grpc", zap.String("msg",
+						fmt.Sprintf("%s: %d events have been dropped while it was disconnected", tp.Egress, n)))
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		conn.Close()
 	}()
 
 	return nil
 }
 
 func dialOpts(gCfg *grpcConf) ([]grpc.DialOption, error) {
-	var opts []grpc.DialOption
+	// the pb records are encoded by the generated codec, it's forced
+	// per connection thus the proto codec of the process isn't replaced.
+	opts := []grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(serialization.Codec{})),
+	}
 
 	if gCfg.TLSConfig.Enable {
 		creds, err := config.GetCreds(&gCfg.TLSConfig)
@@ -194,5 +341,31 @@ func dialOpts(gCfg *grpcConf) ([]grpc.DialOption, error) {
 		opts = append(opts, grpc.WithInsecure())
 	}
 
+	if gCfg.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(gCfg.KeepaliveTime) * time.Second,
+			Timeout:             time.Duration(gCfg.KeepaliveTimeout) * time.Second,
+			PermitWithoutStream: gCfg.PermitWithoutStream,
+		}))
+	}
+
+	if gCfg.Balancer != "" {
+		opts = append(opts,
+			grpc.WithResolvers(&dnsBuilder{
+				server:  gCfg.Server,
+				refresh: time.Duration(gCfg.ResolverRefresh) * time.Second,
+			}),
+			grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, gCfg.Balancer)),
+		)
+	}
+
 	return opts, nil
 }
+
+func target(gCfg *grpcConf) string {
+	if gCfg.Balancer != "" {
+		return dnsScheme + ":///" + gCfg.Server
+	}
+
+	return gCfg.Server
+}
@@ -3,18 +3,27 @@ package grpc
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/fault"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/spool"
 )
 
 var (
@@ -23,6 +32,8 @@ var (
 )
 
 type server struct {
+	pb.UnimplementedTCPDogServer
+
 	ch1 *pb.Fields
 	ch2 *pb.FieldsSPB
 }
@@ -58,6 +69,9 @@ func TestGRPC(t *testing.T) {
 
 	port = l.Addr().(*net.TCPAddr).Port
 
+	// the fake server decodes the cbor content subtype
+	encoding.RegisterCodec(serialization.CBORCodec{})
+
 	srv = server{}
 	gServer := grpc.NewServer()
 	pb.RegisterTCPDogServer(gServer, &srv)
@@ -65,6 +79,7 @@ func TestGRPC(t *testing.T) {
 
 	t.Run("StructPB", testStructPB)
 	t.Run("testProtoJSON", testProtoJSON)
+	t.Run("CBOR", testCBOR)
 
 	t.Cleanup(func() { l.Close() })
 }
@@ -163,3 +178,439 @@ func testProtoJSON(t *testing.T) {
 	cancel()
 	time.Sleep(time.Second)
 }
+
+func testCBOR(t *testing.T) {
+	ch := make(chan *bytes.Buffer, 1)
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"foo": {
+				Type: "grpc-cbor",
+				Config: map[string]interface{}{
+					"server":   fmt.Sprintf(":%d", port),
+					"insecure": true,
+				},
+			},
+		},
+	}
+
+	cfg.SetMockLogger("memory3")
+
+	ctx := cfg.WithContext(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+
+	tp := config.Tracepoint{
+		Egress: "foo",
+	}
+
+	ch <- bytes.NewBufferString(`{"SRTT":7,"AdvMSS":8,"DAddr":"2001:db8::1","Timestamp":1609564926}`)
+	err := StartCBOR(ctx, tp, bufPool, ch)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	hostname, _ := os.Hostname()
+
+	assert.NotNil(t, srv.ch1)
+	assert.Equal(t, uint32(7), *srv.ch1.SRTT)
+	assert.Equal(t, uint32(8), *srv.ch1.AdvMSS)
+	assert.Equal(t, "2001:db8::1", *srv.ch1.DAddr)
+	assert.Equal(t, hostname, *srv.ch1.Hostname)
+	assert.Equal(t, uint64(1609564926), *srv.ch1.Timestamp)
+
+	cancel()
+	time.Sleep(time.Second)
+}
+
+type counter struct {
+	pb.UnimplementedTCPDogServer
+
+	n int64
+}
+
+func (c *counter) Tracepoint(srv pb.TCPDog_TracepointServer) error {
+	for {
+		_, err := srv.Recv()
+		if err != nil {
+			return err
+		}
+
+		atomic.AddInt64(&c.n, 1)
+	}
+}
+
+func (c *counter) TracepointSPB(srv pb.TCPDog_TracepointSPBServer) error {
+	return nil
+}
+
+func TestRoundRobin(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := l.Addr().(*net.TCPAddr).Port
+	listeners := []net.Listener{l}
+	for _, host := range []string{"127.0.0.2", "127.0.0.3"} {
+		l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
+		if err != nil {
+			t.Skip(err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	counters := []*counter{}
+	for _, l := range listeners {
+		c := &counter{}
+		gServer := grpc.NewServer()
+		pb.RegisterTCPDogServer(gServer, c)
+		go gServer.Serve(l)
+		counters = append(counters, c)
+		t.Cleanup(gServer.Stop)
+	}
+
+	// fake resolver
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}, nil
+	}
+	t.Cleanup(func() { lookupHost = net.DefaultResolver.LookupHost })
+
+	ch := make(chan *bytes.Buffer, 300)
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"foo": {
+				Type: "grpc",
+				Config: map[string]interface{}{
+					"server":   fmt.Sprintf("backends.tcpdog:%d", port),
+					"balancer": "round_robin",
+					"streams":  6,
+				},
+			},
+		},
+	}
+
+	cfg.SetMockLogger("memory3")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = cfg.WithContext(ctx)
+	defer cancel()
+
+	err = Start(ctx, config.Tracepoint{Egress: "foo"}, bufPool, ch)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	for i := 0; i < 300; i++ {
+		ch <- bytes.NewBufferString(`{"SRTT":5,"AdvMSS":6,"Timestamp":1609564925}`)
+	}
+
+	time.Sleep(time.Second)
+
+	total := int64(0)
+	for _, c := range counters {
+		n := atomic.LoadInt64(&c.n)
+		assert.Greater(t, n, int64(0))
+		total += n
+	}
+	assert.Equal(t, int64(300), total)
+}
+
+// failedStream fails to send the records
+type failedStream struct {
+	pb.TCPDog_TracepointClient
+}
+
+func (failedStream) Send(*pb.Fields) error {
+	return errors.New("transport is closing")
+}
+
+func TestProtobufDrop(t *testing.T) {
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+	ch := make(chan *bytes.Buffer, 1)
+	ch <- bytes.NewBufferString(`{"SRTT":5}`)
+
+	tp := config.Tracepoint{Egress: "drop"}
+	err := protobuf(context.Background(), failedStream{}, tp, nil, newPending("drop", 0), bufPool, ch)
+	assert.EqualError(t, err, "transport is closing")
+	assert.Equal(t, uint64(1), drops.Count(drops.EgressPublish, "drop"))
+}
+
+func TestProtobufSpill(t *testing.T) {
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+	ch := make(chan *bytes.Buffer, 1)
+	ch <- bytes.NewBufferString(`{"SRTT":5}`)
+
+	s, err := spool.Open("spill", &config.Spool{Path: t.TempDir()})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// the failed event is spooled instead of the drop
+	tp := config.Tracepoint{Egress: "spill"}
+	err = protobuf(spool.WithContext(context.Background(), s), failedStream{}, tp, nil, newPending("spill", 10), bufPool, ch)
+	assert.EqualError(t, err, "transport is closing")
+	assert.Equal(t, uint64(0), drops.Count(drops.EgressPublish, "spill"))
+	assert.Equal(t, 1, s.Len())
+}
+
+// recvServer hands the received records to the channel
+type recvServer struct {
+	pb.UnimplementedTCPDogServer
+
+	ch      chan *pb.Fields
+	streams int32
+	replays int32
+}
+
+func (s *recvServer) Tracepoint(srv pb.TCPDog_TracepointServer) error {
+	atomic.AddInt32(&s.streams, 1)
+	if md, ok := metadata.FromIncomingContext(srv.Context()); ok && len(md.Get(replayKey)) > 0 {
+		atomic.AddInt32(&s.replays, 1)
+	}
+
+	for {
+		f, err := srv.Recv()
+		if err != nil {
+			return err
+		}
+
+		s.ch <- f
+	}
+}
+
+func TestSendFault(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &recvServer{ch: make(chan *pb.Fields, 10)}
+	gServer := grpc.NewServer()
+	pb.RegisterTCPDogServer(gServer, rs)
+	go gServer.Serve(l)
+	defer gServer.Stop()
+
+	// the stream is reset until the injection expires
+	fault.Allow(true)
+	defer fault.Allow(false)
+
+	err = fault.Inject(config.Fault{Point: fault.GRPCSend, Kind: fault.Reset, Probability: 1, Duration: time.Second})
+	assert.NoError(t, err)
+
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	tp := config.Tracepoint{Egress: "fault"}
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"fault": {
+				// the reset events are dropped and the stream
+				// is reconnected once the injection expires.
+				Config: map[string]interface{}{
+					"server":            l.Addr().String(),
+					"insecure":          true,
+					"max-pending":       0,
+					"reconnect-backoff": 2000,
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("fault")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan *bytes.Buffer, 2)
+	ch <- bytes.NewBufferString(`{"SRTT":1}`)
+
+	err = Start(ctx, tp, bufPool, ch)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.EgressPublish, "fault") == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the egress reconnects after the backoff
+	ch <- bytes.NewBufferString(`{"SRTT":2}`)
+
+	select {
+	case f := <-rs.ch:
+		assert.Equal(t, uint32(2), f.GetSRTT())
+	case <-time.After(5 * time.Second):
+		t.Fatal("egress has not been recovered")
+	}
+
+	assert.Len(t, fault.Status(), 0)
+}
+
+func TestReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	rs := &recvServer{ch: make(chan *pb.Fields, 10)}
+	gServer := grpc.NewServer()
+	pb.RegisterTCPDogServer(gServer, rs)
+	go gServer.Serve(l)
+
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	tp := config.Tracepoint{Egress: "reconnect"}
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"reconnect": {
+				Config: map[string]interface{}{
+					"server":                addr,
+					"insecure":              true,
+					"max-pending":           3,
+					"reconnect-backoff":     50,
+					"reconnect-max-backoff": 100,
+				},
+			},
+		},
+	}
+	ms := cfg.SetMockLogger("grpcreconnect")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan *bytes.Buffer, 10)
+	assert.NoError(t, Start(ctx, tp, bufPool, ch))
+
+	recv := func(srtt uint32) {
+		select {
+		case f := <-rs.ch:
+			assert.Equal(t, srtt, f.GetSRTT())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d has not been received", srtt)
+		}
+	}
+
+	ch <- bytes.NewBufferString(`{"SRTT":1}`)
+	recv(1)
+
+	// the server is restarted mid-stream
+	gServer.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dropped := drops.Count(drops.EgressPublish, "reconnect")
+
+	for i := 2; i <= 6; i++ {
+		ch <- bytes.NewBufferString(fmt.Sprintf(`{"SRTT":%d}`, i))
+	}
+
+	// the ring holds the newest events
+	assert.Eventually(t, func() bool {
+		return len(ch) == 0 && drops.Count(drops.EgressPublish, "reconnect")-dropped == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gServer = grpc.NewServer()
+	pb.RegisterTCPDogServer(gServer, rs)
+	go gServer.Serve(l)
+	defer gServer.Stop()
+
+	// the pending events are flushed on reconnect
+	for i := uint32(4); i <= 6; i++ {
+		recv(i)
+	}
+
+	ch <- bytes.NewBufferString(`{"SRTT":7}`)
+	recv(7)
+
+	// the replay stream has been closed once the pending events
+	// have been flushed and the event is sent by a regular stream.
+	assert.Equal(t, int32(1), atomic.LoadInt32(&rs.replays))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&rs.streams))
+
+	assert.Contains(t, ms.String(), "reconnect: 2 events have been dropped while it was disconnected")
+
+	// the stream after the replay stream isn't a new connection
+	assert.Equal(t, 2, strings.Count(ms.String(), "reconnect has been connected"))
+}
+
+func TestKeepaliveOpts(t *testing.T) {
+	gCfg, err := gRPCConfig(map[string]interface{}{
+		"keepalive-time":        30,
+		"keepalive-timeout":     5,
+		"permit-without-stream": true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 30, gCfg.KeepaliveTime)
+	assert.Equal(t, 5, gCfg.KeepaliveTimeout)
+	assert.True(t, gCfg.PermitWithoutStream)
+
+	opts, err := dialOpts(gCfg)
+	assert.NoError(t, err)
+	assert.Len(t, opts, 3)
+
+	// the keepalive is disabled by default
+	gCfg, err = gRPCConfig(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, gCfg.MaxPending)
+
+	opts, err = dialOpts(gCfg)
+	assert.NoError(t, err)
+	assert.Len(t, opts, 2)
+}
+
+func TestResolverConfig(t *testing.T) {
+	_, err := gRPCConfig(map[string]interface{}{
+		"balancer":        "round_robin",
+		"resolverRefresh": 0,
+	})
+	assert.EqualError(t, err, "wrong resolver refresh:0")
+
+	gCfg, err := gRPCConfig(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gCfg.Streams)
+
+	// the streams are the resolved addresses with the balancer
+	gCfg, err = gRPCConfig(map[string]interface{}{"balancer": "round_robin"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, gCfg.Streams)
+
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}, nil
+	}
+	t.Cleanup(func() { lookupHost = net.DefaultResolver.LookupHost })
+
+	assert.Equal(t, 3, streams(context.Background(), "backends.tcpdog:8085"))
+	assert.Equal(t, 1, streams(context.Background(), "backends.tcpdog"))
+
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+	assert.Equal(t, 1, streams(context.Background(), "backends.tcpdog:8085"))
+}
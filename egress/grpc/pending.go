@@ -0,0 +1,225 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/priority"
+	"github.com/mehrdadrad/tcpdog/spool"
+)
+
+// replayKey marks the stream which replays the held or the spooled
+// events, the ingress limits the simultaneous replaying agents by it.
+const replayKey = "tcpdog-replay"
+
+// errReplayed closes the replay stream once the held and the spooled
+// events have been sent, the ingress releases its replay slot then
+// and the stream is reconnected as a regular one without the backoff.
+var errReplayed = errors.New("replay has been done")
+
+// replayStream is the context key of a replay stream
+type replayStream struct{}
+
+// pending holds the events which haven't been sent while the streams
+// are disconnected, they're sent first once a stream is reconnected.
+// the oldest event is dropped once the ring of the max pending events
+// is full, the events are dropped if the max pending is zero.
+type pending struct {
+	sync.Mutex
+
+	egress  string
+	bufs    []*bytes.Buffer
+	head    int
+	n       int
+	dropped uint64
+
+	// inflight counts the events which have been handed to the
+	// egress and haven't been sent yet, the held ones too.
+	inflight *helper.Inflight
+
+	// sent is set once an event has been sent, the streams
+	// reset their reconnect backoff by it.
+	sent uint32
+}
+
+func newPending(egress string, size int) *pending {
+	if size < 0 {
+		size = 0
+	}
+
+	return &pending{egress: egress, bufs: make([]*bytes.Buffer, size)}
+}
+
+// hold holds the event which has been failed to send,
+// the event is spooled if the egress has a spool.
+func (p *pending) hold(ctx context.Context, buf *bytes.Buffer) {
+	if spool.FromContext(ctx).Spill(buf) {
+		p.inflight.Add(-1)
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.bufs) == 0 {
+		p.dropped++
+		p.inflight.Add(-1)
+		drops.Add(drops.EgressPublish, p.egress, 1)
+		return
+	}
+
+	if p.n == len(p.bufs) {
+		p.head = (p.head + 1) % len(p.bufs)
+		p.n--
+		p.dropped++
+		p.inflight.Add(-1)
+		drops.Add(drops.EgressPublish, p.egress, 1)
+	}
+
+	p.bufs[(p.head+p.n)%len(p.bufs)] = buf
+	p.n++
+}
+
+// next returns the oldest pending event
+func (p *pending) next() *bytes.Buffer {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.n == 0 {
+		return nil
+	}
+
+	buf := p.bufs[p.head]
+	p.bufs[p.head] = nil
+	p.head = (p.head + 1) % len(p.bufs)
+	p.n--
+
+	return buf
+}
+
+// len returns the number of the pending events
+func (p *pending) len() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.n
+}
+
+// takeDropped returns the dropped events since the last call
+func (p *pending) takeDropped() uint64 {
+	p.Lock()
+	defer p.Unlock()
+
+	n := p.dropped
+	p.dropped = 0
+
+	return n
+}
+
+// delivered marks an event has been sent
+func (p *pending) delivered() {
+	if atomic.LoadUint32(&p.sent) == 0 {
+		atomic.StoreUint32(&p.sent, 1)
+	}
+}
+
+// takeDelivered returns true if an event has been sent since the last call
+func (p *pending) takeDelivered() bool {
+	return atomic.SwapUint32(&p.sent, 0) == 1
+}
+
+// recv returns the pending events first then the events of the channel
+func (p *pending) recv(ctx context.Context, lane *priority.Lane, ch chan *bytes.Buffer) (*bytes.Buffer, priority.Class, bool) {
+	if buf := p.next(); buf != nil {
+		return buf, priority.Normal, true
+	}
+
+	buf, c, ok := lane.RecvBuffer(ctx, ch)
+	if ok {
+		p.inflight.Recv(c)
+	}
+
+	return buf, c, ok
+}
+
+// release returns the event which has been sent or skipped to the pool
+func (p *pending) release(bufpool *sync.Pool, buf *bytes.Buffer) {
+	p.inflight.Add(-1)
+	bufpool.Put(buf)
+}
+
+// withReplay marks the stream as a replay stream if there
+// are events which have been held or spooled meanwhile.
+func (p *pending) withReplay(ctx context.Context) context.Context {
+	if p.len() == 0 && spool.FromContext(ctx).Len() == 0 {
+		return ctx
+	}
+
+	ctx = context.WithValue(ctx, replayStream{}, true)
+
+	return metadata.AppendToOutgoingContext(ctx, replayKey, "true")
+}
+
+// replayed returns true if it's a replay stream and there
+// isn't any held or spooled event anymore.
+func (p *pending) replayed(ctx context.Context) bool {
+	if ctx.Value(replayStream{}) == nil {
+		return false
+	}
+
+	return p.len() == 0 && spool.FromContext(ctx).Len() == 0
+}
+
+// wait waits for the reconnect delay, the events of the channel are held
+// meanwhile unless the egress has a spool which holds them by itself.
+// it returns false once the context is done.
+func (p *pending) wait(ctx context.Context, d time.Duration, ch chan *bytes.Buffer) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	if len(p.bufs) == 0 || spool.FromContext(ctx) != nil {
+		ch = nil
+	}
+
+	for {
+		select {
+		case buf := <-ch:
+			p.hold(ctx, buf)
+		case <-timer.C:
+			return ctx.Err() == nil
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// backoff is the exponential reconnect delay with the jitter, the
+// delay is a random duration between the half and the full backoff.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else if b.current *= 2; b.current > b.max {
+		b.current = b.max
+	}
+
+	return b.current/2 + time.Duration(rand.Int63n(int64(b.current/2)+1))
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
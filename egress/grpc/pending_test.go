@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+func TestPending(t *testing.T) {
+	ctx := context.Background()
+	p := newPending("pending", 3)
+	dropped := drops.Count(drops.EgressPublish, "pending")
+
+	for i := 1; i <= 5; i++ {
+		p.hold(ctx, bytes.NewBufferString(string(rune('0'+i))))
+	}
+
+	// the oldest events have been dropped
+	assert.Equal(t, 3, p.len())
+	assert.Equal(t, uint64(2), p.takeDropped())
+	assert.Equal(t, uint64(0), p.takeDropped())
+	assert.Equal(t, uint64(2), drops.Count(drops.EgressPublish, "pending")-dropped)
+
+	ch := make(chan *bytes.Buffer, 1)
+	ch <- bytes.NewBufferString("6")
+
+	var events []string
+	for i := 0; i < 4; i++ {
+		buf, _, ok := p.recv(ctx, nil, ch)
+		assert.True(t, ok)
+		events = append(events, buf.String())
+	}
+	assert.Equal(t, []string{"3", "4", "5", "6"}, events)
+	assert.Nil(t, p.next())
+
+	// the events are dropped without the ring
+	p = newPending("pending", 0)
+	p.hold(ctx, bytes.NewBufferString("1"))
+	assert.Equal(t, 0, p.len())
+	assert.Equal(t, uint64(1), p.takeDropped())
+}
+
+func TestPendingWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := newPending("pending-wait", 10)
+
+	ch := make(chan *bytes.Buffer, 2)
+	ch <- bytes.NewBufferString("1")
+	ch <- bytes.NewBufferString("2")
+
+	// the events are held while it's waiting
+	assert.True(t, p.wait(ctx, 50*time.Millisecond, ch))
+	assert.Equal(t, 2, p.len())
+
+	cancel()
+	assert.False(t, p.wait(ctx, time.Minute, ch))
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: 100 * time.Millisecond, max: time.Second}
+
+	for _, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		d := b.next()
+		assert.GreaterOrEqual(t, int64(d), int64(max*time.Millisecond/2))
+		assert.LessOrEqual(t, int64(d), int64(max*time.Millisecond))
+	}
+
+	b.reset()
+	assert.LessOrEqual(t, int64(b.next()), int64(100*time.Millisecond))
+}
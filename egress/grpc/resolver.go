@@ -0,0 +1,158 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+)
+
+const dnsScheme = "tcpdog-dns"
+
+// balancerSettle is the delay after the first connected backend which
+// the other backends get connected in, the balancer picks a backend
+// just once a stream is opened.
+var balancerSettle = 200 * time.Millisecond
+
+var lookupHost = net.DefaultResolver.LookupHost
+
+// dnsBuilder builds a dns resolver which resolves the server name
+// periodically so the balancer picks up the backends changes.
+type dnsBuilder struct {
+	server  string
+	refresh time.Duration
+}
+
+type dnsResolver struct {
+	host    string
+	port    string
+	refresh time.Duration
+	cc      resolver.ClientConn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	rn      chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (b *dnsBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := net.SplitHostPort(b.server)
+	if err != nil {
+		return nil, err
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &dnsResolver{
+		host:    host,
+		port:    port,
+		refresh: b.refresh,
+		cc:      cc,
+		ctx:     ctx,
+		cancel:  cancel,
+		rn:      make(chan struct{}, 1),
+	}
+
+	r.wg.Add(1)
+	go r.watcher()
+
+	return r, nil
+}
+
+func (b *dnsBuilder) Scheme() string {
+	return dnsScheme
+}
+
+func (r *dnsResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.rn <- struct{}{}:
+	default:
+	}
+}
+
+func (r *dnsResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *dnsResolver) watcher() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.refresh)
+	defer ticker.Stop()
+
+	for {
+		r.resolve()
+
+		select {
+		case <-ticker.C:
+		case <-r.rn:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// streams returns the number of the resolved addresses of the
+// server thus each backend gets a stream, it's one if the server
+// can't be resolved.
+func streams(ctx context.Context, server string) int {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return 1
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	addrs, err := lookupHost(ctx, host)
+	if err != nil || len(addrs) < 1 {
+		return 1
+	}
+
+	return len(addrs)
+}
+
+// settle waits for the backends to be connected, otherwise the round
+// robin picker has only the first connected backend thus all of the
+// streams are opened to it. it returns false once the context is done.
+func settle(ctx context.Context, conn *grpc.ClientConn) bool {
+	for s := conn.GetState(); s != connectivity.Ready; s = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, s) {
+			return false
+		}
+	}
+
+	timer := time.NewTimer(balancerSettle)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *dnsResolver) resolve() {
+	addrs, err := lookupHost(r.ctx, r.host)
+	if err != nil || len(addrs) < 1 {
+		return
+	}
+
+	state := resolver.State{}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{
+			Addr: net.JoinHostPort(addr, r.port),
+		})
+	}
+
+	r.cc.UpdateState(state)
+}
@@ -0,0 +1,133 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	pbstruct "github.com/golang/protobuf/ptypes/struct"
+)
+
+const (
+	ceSpecVersion = "1.0"
+	// CEDefaultSource is the default CloudEvents source template
+	CEDefaultSource = "/tcpdog/{{.Hostname}}"
+)
+
+// CloudEvents wraps the json records in the CloudEvents
+// structured mode envelope (specversion 1.0).
+type CloudEvents struct {
+	head []byte
+}
+
+// NewCloudEvents returns a CloudEvents envelope, the source is a
+// template of the hostname and the type is derived from the
+// tracepoint name if it's empty, e.g. io.tcpdog.tcp.tcp_probe.
+func NewCloudEvents(source, typ, tracepoint string) (*CloudEvents, error) {
+	if source == "" {
+		source = CEDefaultSource
+	}
+
+	tmpl, err := template.New("source").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, struct{ Hostname string }{hostname})
+	if err != nil {
+		return nil, err
+	}
+
+	if typ == "" {
+		typ = "io.tcpdog." + strings.Replace(tracepoint, ":", ".", 1)
+	}
+
+	head, err := json.Marshal(map[string]string{
+		"specversion":     ceSpecVersion,
+		"source":          buf.String(),
+		"type":            typ,
+		"datacontenttype": "application/json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CloudEvents{head: head[:len(head)-1]}, nil
+}
+
+// Append appends the CloudEvent of the json record to the dst, the
+// id is the hash of the record and the time is the record Timestamp.
+func (c *CloudEvents) Append(dst, record []byte) []byte {
+	dst = append(dst, c.head...)
+	dst = append(dst, `,"id":"`...)
+	dst = append(dst, fmt.Sprintf("%016x", xxhash.Sum64(record))...)
+	dst = append(dst, '"')
+
+	if ts, ok := RecordTimestamp(record); ok {
+		dst = append(dst, `,"time":"`...)
+		dst = time.Unix(ts, 0).UTC().AppendFormat(dst, time.RFC3339)
+		dst = append(dst, '"')
+	}
+
+	dst = append(dst, `,"data":`...)
+	dst = append(dst, record...)
+
+	return append(dst, '}')
+}
+
+// RecordTimestamp returns the Timestamp of a json record
+func RecordTimestamp(record []byte) (int64, bool) {
+	key := []byte(`"Timestamp":`)
+
+	i := bytes.LastIndex(record, key)
+	if i < 0 {
+		return 0, false
+	}
+
+	b := record[i+len(key):]
+	end := 0
+	for end < len(b) && b[end] >= '0' && b[end] <= '9' {
+		end++
+	}
+
+	ts, err := strconv.ParseInt(string(b[:end]), 10, 64)
+
+	return ts, err == nil
+}
+
+// UnwrapCloudEvent returns the data of a CloudEvent in the structured
+// mode, the other json records return as they're.
+func UnwrapCloudEvent(m map[string]interface{}) map[string]interface{} {
+	if _, ok := m["specversion"]; !ok {
+		return m
+	}
+
+	if data, ok := m["data"].(map[string]interface{}); ok {
+		return data
+	}
+
+	return m
+}
+
+// UnwrapCloudEventSPB returns the data of a CloudEvent which has
+// been carried as a struct protobuf, e.g. the gRPC ingress of a
+// relay, the other records return as they're.
+func UnwrapCloudEventSPB(s *pbstruct.Struct) *pbstruct.Struct {
+	if _, ok := s.GetFields()["specversion"]; !ok {
+		return s
+	}
+
+	if data := s.Fields["data"].GetStructValue(); data != nil {
+		return data
+	}
+
+	return s
+}
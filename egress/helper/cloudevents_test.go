@@ -0,0 +1,67 @@
+package helper
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestCloudEvents(t *testing.T) {
+	hostname, _ := os.Hostname()
+	record := []byte(`{"RTT":5,"Timestamp":1611634115,"Hostname":"foo"}`)
+
+	ce, err := NewCloudEvents("", "", "sock:inet_sock_set_state")
+	assert.NoError(t, err)
+
+	m := map[string]interface{}{}
+	err = json.Unmarshal(ce.Append(nil, record), &m)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "1.0", m["specversion"])
+	assert.Equal(t, "/tcpdog/"+hostname, m["source"])
+	assert.Equal(t, "io.tcpdog.sock.inet_sock_set_state", m["type"])
+	assert.Equal(t, "2021-01-26T04:08:35Z", m["time"])
+	assert.Equal(t, "application/json", m["datacontenttype"])
+	assert.Len(t, m["id"], 16)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(5), "Timestamp": float64(1611634115), "Hostname": "foo"}, m["data"])
+	assert.Equal(t, m["data"], UnwrapCloudEvent(m))
+
+	// the same record has the same id
+	m2 := map[string]interface{}{}
+	json.Unmarshal(ce.Append(nil, record), &m2)
+	assert.Equal(t, m["id"], m2["id"])
+
+	// configured source and type
+	ce, err = NewCloudEvents("tcpdog://{{.Hostname}}/agent", "io.tcpdog.tcp.state_change", "sock:inet_sock_set_state")
+	assert.NoError(t, err)
+	m = map[string]interface{}{}
+	json.Unmarshal(ce.Append(nil, record), &m)
+	assert.Equal(t, "tcpdog://"+hostname+"/agent", m["source"])
+	assert.Equal(t, "io.tcpdog.tcp.state_change", m["type"])
+
+	_, err = NewCloudEvents("{{.Foo", "", "")
+	assert.Error(t, err)
+
+	// not a CloudEvent
+	m = map[string]interface{}{"RTT": 5}
+	assert.Equal(t, m, UnwrapCloudEvent(m))
+}
+
+func TestUnwrapCloudEventSPB(t *testing.T) {
+	s, err := structpb.NewStruct(map[string]interface{}{
+		"specversion": "1.0",
+		"id":          "2fd4e1c67a2d28fc",
+		"data":        map[string]interface{}{"RTT": 5},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(5)}, UnwrapCloudEventSPB(s).AsMap())
+
+	// not a CloudEvent
+	s, _ = structpb.NewStruct(map[string]interface{}{"RTT": 5, "data": "foo"})
+	assert.Equal(t, s, UnwrapCloudEventSPB(s))
+
+	assert.Nil(t, UnwrapCloudEventSPB(nil))
+}
@@ -0,0 +1,213 @@
+package helper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// the payload codec header, 0x01-0x07 can't be the first byte of
+// a json document or a protobuf message (field number zero) so
+// the uncompressed payloads don't need any header.
+const (
+	codecGzip byte = iota + 1
+	codecZstd
+)
+
+// zstdMagic is the magic number of a zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// DefaultMaxPayloadSize is the default max size of a decompressed
+// payload, a small hostile payload can't expand beyond it.
+const DefaultMaxPayloadSize = 4 << 20
+
+// zstdWindowSize is the zstd encoder default window size, the decoders
+// need at least the window memory to decode the larger payloads.
+const zstdWindowSize = 4 << 20
+
+var errZstdPayload = errors.New("invalid zstd payload")
+
+var (
+	gzipPool = sync.Pool{
+		New: func() interface{} {
+			return gzip.NewWriter(nil)
+		},
+	}
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+
+	// zstdDecoders are the decoders per max payload size
+	zstdDecoders sync.Map
+)
+
+// PayloadCompressor returns a function which compresses a serialized
+// payload and prefixes it by the codec header, the codec can be
+// none, gzip or zstd. the returned function is safe for concurrent use.
+func PayloadCompressor(codec string) (func([]byte) ([]byte, error), error) {
+	switch codec {
+	case "", "none":
+		return func(b []byte) ([]byte, error) { return b, nil }, nil
+	case "gzip":
+		return compressGzip, nil
+	case "zstd":
+		zstdInit()
+		return compressZstd, nil
+	}
+
+	return nil, fmt.Errorf("unknown payload compression: %s", codec)
+}
+
+// DecompressPayload decompresses the payload based on its codec header,
+// the payload returns as it's if there is no header. it returns an error
+// if the decompressed payload exceeds the max size (DefaultMaxPayloadSize
+// if it's not positive).
+func DecompressPayload(b []byte, max int) ([]byte, error) {
+	if len(b) < 1 {
+		return b, nil
+	}
+
+	if max <= 0 {
+		max = DefaultMaxPayloadSize
+	}
+
+	var (
+		p   []byte
+		err error
+	)
+
+	switch b[0] {
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		p, err = ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+		if err != nil {
+			return nil, err
+		}
+	case codecZstd:
+		if !bytes.HasPrefix(b[1:], zstdMagic) {
+			return nil, errZstdPayload
+		}
+
+		p, err = zstdDecoder(max).DecodeAll(b[1:], nil)
+		if err == zstd.ErrDecoderSizeExceeded || err == zstd.ErrWindowSizeExceeded {
+			return nil, payloadSizeError(max)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return b, nil
+	}
+
+	if len(p) > max {
+		return nil, payloadSizeError(max)
+	}
+
+	return p, nil
+}
+
+func payloadSizeError(max int) error {
+	return fmt.Errorf("decompressed payload exceeds %d bytes", max)
+}
+
+// LineCompressor returns a function which compresses a text line of the
+// line delimited egresses (console, csv and jsonl) by the payload codec,
+// the compressed payload is base64 encoded thus a line is still a message
+// and DecompressLine decodes it. it's nil for the none codec.
+func LineCompressor(codec string) (func([]byte) []byte, error) {
+	compress, err := PayloadCompressor(codec)
+	if err != nil || codec == "" || codec == "none" {
+		return nil, err
+	}
+
+	return func(b []byte) []byte {
+		// the in memory compression doesn't fail
+		p, _ := compress(b)
+		line := make([]byte, base64.StdEncoding.EncodedLen(len(p)))
+		base64.StdEncoding.Encode(line, p)
+		return line
+	}, nil
+}
+
+// LineCompressorFrom returns the line compressor of the
+// payloadCompression option of an egress configuration.
+func LineCompressorFrom(conf map[string]interface{}) (func([]byte) []byte, error) {
+	codec, _ := conf["payloadCompression"].(string)
+	return LineCompressor(codec)
+}
+
+// DecompressLine decodes a line which has been compressed by
+// the line compressor.
+func DecompressLine(line []byte) ([]byte, error) {
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(b, bytes.TrimSpace(line))
+	if err != nil {
+		return nil, err
+	}
+
+	return DecompressPayload(b[:n], DefaultMaxPayloadSize)
+}
+
+func compressGzip(b []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(b)/2))
+	buf.WriteByte(codecGzip)
+
+	w := gzipPool.Get().(*gzip.Writer)
+	defer gzipPool.Put(w)
+
+	w.Reset(buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func compressZstd(b []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(b, []byte{codecZstd}), nil
+}
+
+func zstdInit() {
+	zstdOnce.Do(func() {
+		// it never fails without options
+		zstdEncoder, _ = zstd.NewWriter(nil)
+	})
+}
+
+// zstdDecoder returns the decoder of the max payload size,
+// the max sizes are configured per ingress thus they're a few.
+func zstdDecoder(max int) *zstd.Decoder {
+	if d, ok := zstdDecoders.Load(max); ok {
+		return d.(*zstd.Decoder)
+	}
+
+	mem := max
+	if mem < zstdWindowSize {
+		mem = zstdWindowSize
+	}
+
+	// it never fails with a positive max memory
+	d, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(mem)))
+	if v, loaded := zstdDecoders.LoadOrStore(max, d); loaded {
+		d.Close()
+		return v.(*zstd.Decoder)
+	}
+
+	return d
+}
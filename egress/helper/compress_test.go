@@ -0,0 +1,114 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var samplePayload = []byte(`{"PID":123456,"Task":"curl","RTT":12345,"TotalRetrans":0,"AdvMSS":1460,"SAddr":"10.0.2.15","DAddr":"172.217.5.196","DPort":80,"Timestamp":1611634115,"Hostname":"foo"}`)
+
+func TestPayloadCompressor(t *testing.T) {
+	for _, codec := range []string{"none", "gzip", "zstd"} {
+		compress, err := PayloadCompressor(codec)
+		assert.NoError(t, err)
+
+		b, err := compress(samplePayload)
+		assert.NoError(t, err, codec)
+
+		if codec != "none" {
+			assert.NotEqual(t, samplePayload, b, codec)
+		}
+
+		r, err := DecompressPayload(b, 0)
+		assert.NoError(t, err, codec)
+		assert.Equal(t, samplePayload, r, codec)
+	}
+
+	_, err := PayloadCompressor("lz4")
+	assert.Error(t, err)
+}
+
+func TestDecompressPayload(t *testing.T) {
+	// no header
+	b, err := DecompressPayload([]byte{0x8, 0x5}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x8, 0x5}, b)
+
+	b, err = DecompressPayload(nil, 0)
+	assert.NoError(t, err)
+	assert.Len(t, b, 0)
+
+	_, err = DecompressPayload([]byte{codecGzip, 0x1, 0x2}, 0)
+	assert.Error(t, err)
+
+	_, err = DecompressPayload([]byte{codecZstd, 0x1, 0x2}, 0)
+	assert.Error(t, err)
+
+	_, err = DecompressPayload([]byte{codecZstd}, 0)
+	assert.Error(t, err)
+
+	// a corrupt frame
+	_, err = DecompressPayload([]byte{codecZstd, 0x28, 0xb5, 0x2f, 0xfd, 0x1}, 0)
+	assert.Error(t, err)
+}
+
+func TestDecompressPayloadMax(t *testing.T) {
+	bomb := bytes.Repeat([]byte{'a'}, 1<<20)
+
+	for _, codec := range []string{"gzip", "zstd"} {
+		compress, err := PayloadCompressor(codec)
+		assert.NoError(t, err)
+
+		b, err := compress(bomb)
+		assert.NoError(t, err, codec)
+
+		_, err = DecompressPayload(b, 1<<10)
+		assert.EqualError(t, err, "decompressed payload exceeds 1024 bytes", codec)
+
+		r, err := DecompressPayload(b, 1<<20)
+		assert.NoError(t, err, codec)
+		assert.Len(t, r, 1<<20, codec)
+	}
+}
+
+func TestLineCompressor(t *testing.T) {
+	for _, codec := range []string{"gzip", "zstd"} {
+		compress, err := LineCompressorFrom(map[string]interface{}{"payloadCompression": codec})
+		assert.NoError(t, err)
+
+		line := compress(samplePayload)
+		assert.NotContains(t, string(line), "\n", codec)
+
+		b, err := DecompressLine(append(line, '\n'))
+		assert.NoError(t, err, codec)
+		assert.Equal(t, samplePayload, b, codec)
+	}
+
+	compress, err := LineCompressorFrom(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Nil(t, compress)
+
+	_, err = LineCompressorFrom(map[string]interface{}{"payloadCompression": "lz4"})
+	assert.EqualError(t, err, "unknown payload compression: lz4")
+
+	_, err = DecompressLine([]byte("!"))
+	assert.Error(t, err)
+}
+
+func benchmarkPayloadCompressor(b *testing.B, codec string) {
+	compress, _ := PayloadCompressor(codec)
+
+	var size int
+	for n := 0; n < b.N; n++ {
+		r, _ := compress(samplePayload)
+		size = len(r)
+	}
+
+	b.ReportMetric(float64(size)/float64(len(samplePayload)), "ratio")
+}
+
+func BenchmarkPayloadNone(b *testing.B) { benchmarkPayloadCompressor(b, "none") }
+func BenchmarkPayloadGzip(b *testing.B) { benchmarkPayloadCompressor(b, "gzip") }
+func BenchmarkPayloadZstd(b *testing.B) { benchmarkPayloadCompressor(b, "zstd") }
@@ -2,8 +2,10 @@ package helper
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"hash/fnv"
 	"os"
 	"strconv"
 	"time"
@@ -12,10 +14,15 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/priority"
 )
 
 var comma = []byte(",")[0]
 
+// connKeyFields are the fields which identify a connection
+// regardless of the tracepoint that emitted the event.
+var connKeyFields = []string{"SAddr", "LPort", "DAddr", "DPort"}
+
 // Backoff represents backoff strategy
 type Backoff struct {
 	duration time.Duration
@@ -27,6 +34,7 @@ type Backoff struct {
 // json bytes to StructPB.
 type StructPB struct {
 	fieldsLen  []int
+	fieldsKey  [][]byte
 	fieldsName []string
 	isString   map[string]bool
 	hostname   string
@@ -40,37 +48,47 @@ func NewStructPB(fields []config.Field) *StructPB {
 }
 
 func (s *StructPB) init(fields []config.Field) {
-	var err error
 	s.isString = map[string]bool{
-		"Task":  true,
-		"SAddr": true,
-		"DAddr": true,
+		"Task":        true,
+		"SAddr":       true,
+		"DAddr":       true,
+		"CloseReason": true,
+		"FailReason":  true,
+		"VRFName":     true,
+		"DSCPName":    true,
+		"RetransType": true,
+		"EventID":     true,
 	}
 
-	s.hostname, err = os.Hostname()
-	if err != nil {
-		log.Fatal(err)
-	}
+	// the hostname is empty if it's not available as the other encoders
+	s.hostname, _ = os.Hostname()
 
 	for _, f := range fields {
 		s.fieldsLen = append(s.fieldsLen, len(f.Name)+3)
+		s.fieldsKey = append(s.fieldsKey, []byte(`"`+f.Name+`":`))
 		s.fieldsName = append(s.fieldsName, f.Name)
 	}
 }
 
-// Unmarshal decode bytes to protobuf struct
-func (s *StructPB) Unmarshal(buf *bytes.Buffer) *pbstruct.Struct {
+// Unmarshal decode bytes to protobuf struct, it returns the error
+// if the event isn't the agent json encoding.
+func (s *StructPB) Unmarshal(buf *bytes.Buffer) (*pbstruct.Struct, error) {
 	r := &pbstruct.Struct{Fields: make(map[string]*pbstruct.Value)}
 
 	buf.Next(1) // skip bracket
 	for i, l := range s.fieldsLen {
+		// the field is omitted, e.g. the ipv6 only fields of an ipv4 event
+		if !bytes.HasPrefix(buf.Bytes(), s.fieldsKey[i]) {
+			continue
+		}
+
 		buf.Next(l)
 		name := s.fieldsName[i]
 
 		if s.isString[name] {
 			v, err := buf.ReadBytes(comma)
-			if err != nil {
-				log.Fatal(err)
+			if err != nil || len(v) < 3 {
+				return nil, fmt.Errorf("invalid %s value", name)
 			}
 			r.Fields[name] = &pbstruct.Value{
 				Kind: &pbstruct.Value_StringValue{StringValue: string(v[1 : len(v)-2])},
@@ -78,11 +96,11 @@ func (s *StructPB) Unmarshal(buf *bytes.Buffer) *pbstruct.Struct {
 		} else {
 			v, err := buf.ReadBytes(comma)
 			if err != nil {
-				log.Fatal(err)
+				return nil, fmt.Errorf("invalid %s value", name)
 			}
 			vi, err := strconv.Atoi(string(v[:len(v)-1]))
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
 			r.Fields[name] = &pbstruct.Value{
 				Kind: &pbstruct.Value_NumberValue{NumberValue: float64(vi)},
@@ -90,11 +108,21 @@ func (s *StructPB) Unmarshal(buf *bytes.Buffer) *pbstruct.Struct {
 		}
 	}
 
+	// the agent fields which aren't configured, e.g. the Synthetic
+	// and UID of the existing connections records.
+	for buf.Len() > 0 && !bytes.HasPrefix(buf.Bytes(), timestampKey) {
+		name, v := agentField(buf)
+		if name == "" {
+			break
+		}
+		r.Fields[name] = v
+	}
+
 	// timestamp
 	buf.Next(12)
 	vv, err := strconv.Atoi(string(buf.Next(10)))
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 	r.Fields["Hostname"] = &pbstruct.Value{
 		Kind: &pbstruct.Value_StringValue{StringValue: s.hostname},
@@ -103,7 +131,42 @@ func (s *StructPB) Unmarshal(buf *bytes.Buffer) *pbstruct.Struct {
 		Kind: &pbstruct.Value_NumberValue{NumberValue: float64(vv)},
 	}
 
-	return r
+	return r, nil
+}
+
+var timestampKey = []byte(`"Timestamp":`)
+
+// agentField reads a "key":value pair of the encoded event
+func agentField(buf *bytes.Buffer) (string, *pbstruct.Value) {
+	b := buf.Bytes()
+
+	i := bytes.Index(b, []byte(`":`))
+	if i < 1 || b[0] != '"' {
+		return "", nil
+	}
+
+	name := string(b[1:i])
+	buf.Next(i + 2)
+
+	v, err := buf.ReadBytes(comma)
+	if err != nil {
+		return "", nil
+	}
+	v = v[:len(v)-1]
+
+	switch {
+	case len(v) > 1 && v[0] == '"':
+		return name, &pbstruct.Value{Kind: &pbstruct.Value_StringValue{StringValue: string(v[1 : len(v)-1])}}
+	case string(v) == "true" || string(v) == "false":
+		return name, &pbstruct.Value{Kind: &pbstruct.Value_BoolValue{BoolValue: string(v) == "true"}}
+	}
+
+	f, err := strconv.ParseFloat(string(v), 64)
+	if err != nil {
+		return "", nil
+	}
+
+	return name, &pbstruct.Value{Kind: &pbstruct.Value_NumberValue{NumberValue: f}}
 }
 
 // NewBackoff constructs a new backoff
@@ -113,14 +176,20 @@ func NewBackoff(logger *zap.Logger) *Backoff {
 
 // Next waits for a specific backoff time
 func (b *Backoff) Next() {
+	b.Wait(context.Background())
+}
+
+// Wait waits for a specific backoff time like Next but it returns
+// false without waiting the rest of the delay once the context is done.
+func (b *Backoff) Wait(ctx context.Context) bool {
 	if b.duration == 0 {
 		b.reset()
-		return
+		return ctx.Err() == nil
 	}
 
 	if time.Since(b.last).Minutes() > 30 {
 		b.reset()
-		return
+		return ctx.Err() == nil
 	}
 
 	if b.duration.Minutes() < 2 {
@@ -129,10 +198,200 @@ func (b *Backoff) Next() {
 	}
 
 	b.logger.Debug("backoff", zap.String("delay", fmt.Sprintf("%.2fs", b.duration.Seconds())))
-	time.Sleep(b.duration)
+
+	timer := time.NewTimer(b.duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return ctx.Err() == nil
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func (b *Backoff) reset() {
 	b.last = time.Now()
 	b.duration = time.Duration(2 * time.Second)
 }
+
+// ConnKey returns the connection tuple (SAddr, LPort, DAddr, DPort)
+// of an encoded event. the missing fields are left empty so events
+// of the same fields set still get the same key.
+func ConnKey(b []byte) []byte {
+	key := make([]byte, 0, 64)
+	fields := rawFields(b)
+
+	for i, f := range connKeyFields {
+		if i > 0 {
+			key = append(key, '|')
+		}
+		key = append(key, bytes.Trim(fields.value(f), `"`)...)
+	}
+
+	return key
+}
+
+// Shard returns the worker index which owns the connection key.
+func Shard(key []byte, n int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// Route forwards the encoded events to the workers based on the
+// connection tuple, all the events of a connection (from any
+// tracepoint) are handled by one worker in the order they arrived.
+// the high priority events are routed first, they're in order too.
+// the routed lane events are in-flight until the workers have sent them.
+func Route(ctx context.Context, ch chan *bytes.Buffer, workers []chan *bytes.Buffer) {
+	var (
+		lane     = priority.FromContext(ctx)
+		inflight = InflightFrom(ctx)
+	)
+
+	for {
+		buf, c, ok := lane.RecvBuffer(ctx, ch)
+		if !ok {
+			return
+		}
+		inflight.Recv(c)
+
+		select {
+		case workers[Shard(ConnKey(buf.Bytes()), len(workers))] <- buf:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// FieldOrder represents the output order of an event values which
+// follows the configured fields list, the Timestamp always comes
+// right after the configured fields and the enrichment fields like
+// Hostname are appended by the egress after that.
+type FieldOrder struct {
+	names     []string
+	jsonNames []string
+	jsonKeys  [][]byte
+	known     map[string]struct{}
+}
+
+// agentFields are the fields which the agent adds to some of the
+// events regardless of the fields list, the json keeps them.
+var agentFields = []string{"EventID", "UID", "Synthetic"}
+
+// NewFieldOrder constructs a field order based on the fields list.
+func NewFieldOrder(fields []config.Field) *FieldOrder {
+	f := &FieldOrder{}
+	for _, field := range fields {
+		f.names = append(f.names, field.Name)
+	}
+	f.names = append(f.names, "Timestamp")
+
+	n := len(f.names) - 1
+	f.jsonNames = append(f.jsonNames, f.names[:n]...)
+	f.jsonNames = append(f.jsonNames, agentFields...)
+	f.jsonNames = append(f.jsonNames, f.names[n])
+
+	f.known = make(map[string]struct{}, len(f.jsonNames))
+	for _, name := range f.jsonNames {
+		f.jsonKeys = append(f.jsonKeys, []byte(`"`+name+`":`))
+		f.known[name] = struct{}{}
+	}
+
+	return f
+}
+
+// Values appends the raw values of an encoded event to dst in order,
+// a missing field has an empty value.
+func (f *FieldOrder) Values(dst [][]byte, b []byte) [][]byte {
+	fields := rawFields(b)
+	for _, name := range f.names {
+		dst = append(dst, fields.value(name))
+	}
+
+	return dst
+}
+
+// AppendJSON appends the encoded event to dst as a json object in order,
+// the missing fields are skipped. the fields which aren't in the fields
+// list follow them as they are, e.g. the events of another tracepoint
+// which shares the egress with a different fields list.
+func (f *FieldOrder) AppendJSON(dst []byte, b []byte) []byte {
+	start := len(dst)
+	dst = append(dst, '{')
+
+	appendField := func(key, v []byte) {
+		if len(dst) > start+1 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, key...)
+		dst = append(dst, v...)
+	}
+
+	fields := rawFields(b)
+	for i, key := range f.jsonKeys {
+		if v := fields.value(f.jsonNames[i]); v != nil {
+			appendField(key, v)
+		}
+	}
+
+	for _, field := range fields {
+		if _, ok := f.known[field.name]; !ok {
+			key, _ := json.Marshal(field.name)
+			appendField(append(key, ':'), field.value)
+		}
+	}
+
+	return append(dst, '}')
+}
+
+// rawField is a top-level field of an encoded event
+type rawField struct {
+	name  string
+	value json.RawMessage
+}
+
+type rawFieldList []rawField
+
+// rawFields returns the top-level fields of an encoded event in
+// order, the values are as they're encoded e.g. the quoted strings
+// and the nested objects. a malformed event returns the fields
+// which have been parsed before the error.
+func rawFields(b []byte) rawFieldList {
+	dec := json.NewDecoder(bytes.NewReader(b))
+
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+
+	var fields rawFieldList
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return fields
+		}
+		name, _ := t.(string)
+
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return fields
+		}
+
+		fields = append(fields, rawField{name: name, value: v})
+	}
+
+	return fields
+}
+
+// value returns the raw value of the field, it's nil if
+// the field doesn't exist.
+func (l rawFieldList) value(name string) []byte {
+	for _, f := range l {
+		if f.name == name {
+			return f.value
+		}
+	}
+
+	return nil
+}
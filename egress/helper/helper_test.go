@@ -2,12 +2,14 @@ package helper
 
 import (
 	"bytes"
+	"context"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/priority"
 )
 
 var cfg = config.Config{
@@ -29,13 +31,38 @@ func TestPBStructUnmarshal(t *testing.T) {
 	spb := NewStructPB(cfg.Fields["myfields"])
 	spb.hostname = "fakehost"
 	buf := bytes.NewBufferString(`{"Task":"curl","Fake1":1,"Fake2":2,"Timestamp":1609720926}`)
-	r := spb.Unmarshal(buf)
+	r, err := spb.Unmarshal(buf)
+	assert.NoError(t, err)
 
 	assert.Equal(t, "curl", r.Fields["Task"].GetStringValue())
 	assert.Equal(t, 1.0, r.Fields["Fake1"].GetNumberValue())
 	assert.Equal(t, 2.0, r.Fields["Fake2"].GetNumberValue())
 	assert.Equal(t, "fakehost", r.Fields["Hostname"].GetStringValue())
 
+	// omitted field
+	buf = bytes.NewBufferString(`{"Task":"curl","Fake2":2,"Timestamp":1609720926}`)
+	r, err = spb.Unmarshal(buf)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2.0, r.Fields["Fake2"].GetNumberValue())
+	assert.Equal(t, 1609720926.0, r.Fields["Timestamp"].GetNumberValue())
+	assert.NotContains(t, r.Fields, "Fake1")
+
+	// agent fields
+	buf = bytes.NewBufferString(`{"Task":"curl","UID":1000,"Synthetic":true,"Timestamp":1609720926}`)
+	r, err = spb.Unmarshal(buf)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1000.0, r.Fields["UID"].GetNumberValue())
+	assert.True(t, r.Fields["Synthetic"].GetBoolValue())
+	assert.Equal(t, 1609720926.0, r.Fields["Timestamp"].GetNumberValue())
+
+	// the malformed event is returned instead of exiting
+	_, err = spb.Unmarshal(bytes.NewBufferString(`{"Task":"curl","Fake1":"x","Timestamp":1609720926}`))
+	assert.Error(t, err)
+
+	_, err = spb.Unmarshal(bytes.NewBufferString(`{"Task":"curl"}`))
+	assert.Error(t, err)
 }
 
 func TestBackoff(t *testing.T) {
@@ -59,6 +86,140 @@ func TestBackoff(t *testing.T) {
 	assert.Less(t, time.Since(now).Milliseconds(), int64(100))
 }
 
+func TestBackoffWait(t *testing.T) {
+	cfg := config.Config{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := NewBackoff(cfg.Logger())
+	assert.True(t, b.Wait(ctx))
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	now := time.Now()
+	assert.False(t, b.Wait(ctx))
+	assert.Less(t, time.Since(now).Milliseconds(), int64(1000))
+
+	// the done context never waits
+	b = NewBackoff(cfg.Logger())
+	assert.False(t, b.Wait(ctx))
+}
+
+func TestConnKey(t *testing.T) {
+	b := []byte(`{"RTT":5,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":1609720926}`)
+	assert.Equal(t, "10.0.0.1|5000|10.0.0.2|443", string(ConnKey(b)))
+
+	b = []byte(`{"RTT":5,"Timestamp":1609720926}`)
+	assert.Equal(t, "|||", string(ConnKey(b)))
+
+	// the key text in a string value isn't a field
+	b = []byte(`{"Task":"x,\"SAddr\":1}","SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000}`)
+	assert.Equal(t, "10.0.0.1|5000|10.0.0.2|443", string(ConnKey(b)))
+}
+
+func TestRawFields(t *testing.T) {
+	b := []byte(`{"Task":"a,b}","Labels":{"env":"prod","zone":"a"},"SAddr":"10.0.0.1","RTT":5}`)
+
+	fields := rawFields(b)
+	assert.Equal(t, []byte(`"a,b}"`), fields.value("Task"))
+	assert.Equal(t, []byte(`{"env":"prod","zone":"a"}`), fields.value("Labels"))
+	assert.Equal(t, []byte("5"), fields.value("RTT"))
+	assert.Nil(t, fields.value("env"))
+
+	// the fields before the syntax error
+	fields = rawFields([]byte(`{"RTT":5,"SAddr":`))
+	assert.Equal(t, []byte("5"), fields.value("RTT"))
+	assert.Nil(t, fields.value("SAddr"))
+
+	assert.Nil(t, rawFields([]byte(`[1,2]`)))
+}
+
+func TestRoute(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan *bytes.Buffer, 10)
+	workers := []chan *bytes.Buffer{
+		make(chan *bytes.Buffer, 10),
+		make(chan *bytes.Buffer, 10),
+		make(chan *bytes.Buffer, 10),
+	}
+
+	// connection A events from two tracepoints interleaved with connection B
+	events := []string{
+		`{"NewState":1,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":1}`,
+		`{"TotalRetrans":1,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":2}`,
+		`{"NewState":1,"SAddr":"10.0.0.1","DAddr":"10.0.0.3","DPort":443,"LPort":5001,"Timestamp":3}`,
+		`{"TotalRetrans":2,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":4}`,
+		`{"NewState":7,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":5}`,
+	}
+
+	for _, e := range events {
+		ch <- bytes.NewBufferString(e)
+	}
+
+	go Route(ctx, ch, workers)
+
+	connA := Shard(ConnKey([]byte(events[0])), len(workers))
+	for _, i := range []int{0, 1, 3, 4} {
+		select {
+		case buf := <-workers[connA]:
+			if buf.String() == events[2] {
+				buf = <-workers[connA]
+			}
+			assert.Equal(t, events[i], buf.String())
+		case <-time.After(time.Second):
+			t.Fatal("time exceeded")
+		}
+	}
+}
+
+func TestFieldOrder(t *testing.T) {
+	order := NewFieldOrder([]config.Field{{Name: "RTT"}, {Name: "SAddr"}, {Name: "AdvMSS"}})
+
+	events := []string{
+		`{"RTT":5,"SAddr":"10.0.0.1","AdvMSS":1400,"Timestamp":1609720926}`,
+		`{"AdvMSS":1400,"SAddr":"10.0.0.1","RTT":5,"Timestamp":1609720926}`,
+		`{"Timestamp":1609720926,"SAddr":"10.0.0.1","AdvMSS":1400,"RTT":5}`,
+	}
+
+	for _, e := range events {
+		b := order.AppendJSON(nil, []byte(e))
+		assert.Equal(t, events[0], string(b))
+
+		v := order.Values(nil, []byte(e))
+		assert.Equal(t, [][]byte{[]byte("5"), []byte(`"10.0.0.1"`), []byte("1400"), []byte("1609720926")}, v)
+	}
+
+	b := order.AppendJSON(nil, []byte(`{"SAddr":"10.0.0.1","Timestamp":1609720926}`))
+	assert.Equal(t, `{"SAddr":"10.0.0.1","Timestamp":1609720926}`, string(b))
+
+	// the agent fields are kept
+	b = order.AppendJSON(nil, []byte(`{"SAddr":"10.0.0.1","EventID":"2fd4e1c67a2d28fc","Timestamp":1609720926}`))
+	assert.Equal(t, `{"SAddr":"10.0.0.1","EventID":"2fd4e1c67a2d28fc","Timestamp":1609720926}`, string(b))
+
+	// dst is appended
+	b = order.AppendJSON([]byte("- "), []byte(`{"RTT":5,"Timestamp":1609720926}`))
+	assert.Equal(t, `- {"RTT":5,"Timestamp":1609720926}`, string(b))
+
+	// the commas and the braces of the values
+	b = order.AppendJSON(nil, []byte(`{"Labels":{"a":1,"b":2},"Task":"x,}","RTT":5,"Timestamp":1609720926}`))
+	assert.Equal(t, `{"RTT":5,"Timestamp":1609720926,"Labels":{"a":1,"b":2},"Task":"x,}"}`, string(b))
+}
+
+func TestFieldOrderTracepoints(t *testing.T) {
+	// the egress order is built by the first tracepoint fields
+	order := NewFieldOrder([]config.Field{{Name: "RTT"}, {Name: "SAddr"}})
+
+	b := order.AppendJSON(nil, []byte(`{"SAddr":"10.0.0.1","RTT":5,"Timestamp":1609720926}`))
+	assert.Equal(t, `{"RTT":5,"SAddr":"10.0.0.1","Timestamp":1609720926}`, string(b))
+
+	// the second tracepoint fields aren't lost
+	b = order.AppendJSON(nil, []byte(`{"Task":"curl","OldState":"TCP_SYN_SENT","SAddr":"10.0.0.2","SRTT":40,"Timestamp":1609720927}`))
+	assert.Equal(t, `{"SAddr":"10.0.0.2","Timestamp":1609720927,"Task":"curl","OldState":"TCP_SYN_SENT","SRTT":40}`, string(b))
+}
+
 func BenchmarkPBStructUnmarshal(b *testing.B) {
 	spb := NewStructPB(cfg.Fields["myfields"])
 
@@ -67,3 +228,27 @@ func BenchmarkPBStructUnmarshal(b *testing.B) {
 		spb.Unmarshal(buf)
 	}
 }
+
+func TestInflight(t *testing.T) {
+	var i *Inflight
+
+	// nil is no-op
+	i.Add(1)
+	i.Recv(priority.High)
+	assert.Equal(t, 0, i.Len())
+
+	i = &Inflight{}
+	ctx := WithInflight(context.Background(), i)
+	assert.Equal(t, i, InflightFrom(ctx))
+	assert.Nil(t, InflightFrom(context.Background()))
+
+	// the channel events have been counted by the agent
+	i.Recv(priority.Normal)
+	assert.Equal(t, 0, i.Len())
+
+	i.Recv(priority.High)
+	assert.Equal(t, 1, i.Len())
+
+	i.Add(-1)
+	assert.Equal(t, 0, i.Len())
+}
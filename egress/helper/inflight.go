@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/mehrdadrad/tcpdog/priority"
+)
+
+// Inflight counts the events which have been handed to an egress
+// but haven't been sent or dropped yet, the agent counts them before
+// it sends them to the egress channel and it waits for them before it
+// cancels the old egress of a swap. the methods of a nil Inflight are
+// no-op thus the egress doesn't check it.
+type Inflight struct {
+	n int64
+}
+
+type inflightKey struct{}
+
+// WithInflight returns a copy of the context with the inflight counter
+func WithInflight(ctx context.Context, i *Inflight) context.Context {
+	return context.WithValue(ctx, inflightKey{}, i)
+}
+
+// InflightFrom returns the inflight counter of the egress,
+// it's nil if the egress isn't tracked.
+func InflightFrom(ctx context.Context) *Inflight {
+	i, _ := ctx.Value(inflightKey{}).(*Inflight)
+	return i
+}
+
+// Add adds the taken (positive) or the sent (negative) events
+func (i *Inflight) Add(n int) {
+	if i == nil {
+		return
+	}
+
+	atomic.AddInt64(&i.n, int64(n))
+}
+
+// Recv counts the high priority event which the egress has taken
+// from the lane itself, the channel events have been counted already.
+func (i *Inflight) Recv(c priority.Class) {
+	if c == priority.High {
+		i.Add(1)
+	}
+}
+
+// Len returns the number of the inflight events
+func (i *Inflight) Len() int {
+	if i == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt64(&i.n))
+}
@@ -0,0 +1,370 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/safewriter"
+)
+
+// PartitionConfig represents the time partitioned files of a file egress,
+// the Partition is the period in seconds (disabled by default) and the
+// PartitionGrace is the record time in seconds which a period keeps
+// accepting the out of order records after its end (default 60). The
+// Sync is the fsync policy of the files: none (default), always or
+// interval which syncs at most every SyncInterval milliseconds.
+type PartitionConfig struct {
+	Partition       int
+	PartitionLayout string
+	PartitionGrace  int
+	Manifest        bool
+	Sync            string
+	SyncInterval    int
+}
+
+// Manifest represents the manifest of a closed partition file
+type Manifest struct {
+	File         string `json:"file"`
+	Records      uint64 `json:"records"`
+	MinTimestamp int64  `json:"minTimestamp"`
+	MaxTimestamp int64  `json:"maxTimestamp"`
+	Bytes        uint64 `json:"bytes"`
+	SHA256       string `json:"sha256"`
+}
+
+// maxLateFiles is the max open late files, the oldest one is closed
+const maxLateFiles = 8
+
+// Partitioner writes the records to the time aligned files by the record
+// timestamp (UTC) thus a file never has the records of two periods. The
+// file of a period is dir/<layout>/name and it's closed once the latest
+// record timestamp passes the period end plus the grace, the records of
+// a closed period are appended to dir/late/<layout>/name. The latest
+// record timestamp is clamped to the wall clock plus the grace thus a
+// record from the future doesn't close the current periods.
+type Partitioner struct {
+	dir    string
+	name   string
+	header []byte
+	period int64
+	grace  int64
+	layout string
+
+	manifest  bool
+	opts      safewriter.Options
+	parts     map[int64]*partition
+	late      map[int64]*safewriter.Writer
+	closed    int64
+	watermark int64
+	now       func() time.Time
+}
+
+type partition struct {
+	path     string
+	file     *safewriter.Writer
+	hash     hash.Hash
+	records  uint64
+	bytes    uint64
+	min, max int64
+}
+
+// PartitionConfigFrom returns the partition config of a file egress
+func PartitionConfigFrom(conf map[string]interface{}) (*PartitionConfig, error) {
+	p := &PartitionConfig{
+		PartitionLayout: "2006/01/02/15",
+		PartitionGrace:  60,
+		Sync:            safewriter.SyncNone,
+		SyncInterval:    1000,
+	}
+
+	if err := config.Transform(conf, p); err != nil {
+		return nil, err
+	}
+
+	if p.Partition < 0 || p.PartitionGrace < 0 {
+		return nil, fmt.Errorf("invalid partition %ds grace %ds", p.Partition, p.PartitionGrace)
+	}
+
+	if p.Partition > 0 && strings.Contains(p.PartitionLayout, "..") {
+		return nil, fmt.Errorf("invalid partition layout %s", p.PartitionLayout)
+	}
+
+	switch p.Sync {
+	case safewriter.SyncNone, safewriter.SyncAlways, safewriter.SyncInterval:
+	default:
+		return nil, fmt.Errorf("sync %s is not supported", p.Sync)
+	}
+
+	return p, nil
+}
+
+// WriterOptions returns the line writer options of the files
+func (p *PartitionConfig) WriterOptions() safewriter.Options {
+	return safewriter.Options{
+		Mode:         safewriter.Line,
+		Sync:         p.Sync,
+		SyncInterval: time.Duration(p.SyncInterval) * time.Millisecond,
+	}
+}
+
+// NewPartitioner constructs a new partitioner of the filename
+func NewPartitioner(cfg *PartitionConfig, filename string) *Partitioner {
+	return &Partitioner{
+		dir:      filepath.Dir(filename),
+		name:     filepath.Base(filename),
+		period:   int64(cfg.Partition),
+		grace:    int64(cfg.PartitionGrace),
+		layout:   cfg.PartitionLayout,
+		manifest: cfg.Manifest,
+		opts:     cfg.WriterOptions(),
+		parts:    map[int64]*partition{},
+		late:     map[int64]*safewriter.Writer{},
+		now:      time.Now,
+	}
+}
+
+// SetHeader sets the header which is written at the beginning of the files
+func (p *Partitioner) SetHeader(header []byte) {
+	p.header = append(p.header[:0], header...)
+}
+
+// Write writes the record line to the partition of the timestamp
+// (unix seconds), the line should be terminated by a newline.
+func (p *Partitioner) Write(ts int64, line []byte) error {
+	if w := p.clamp(ts); w > p.watermark {
+		p.watermark = w
+		if err := p.closeBefore(w - p.grace); err != nil {
+			return err
+		}
+	}
+
+	start := ts - ts%p.period
+	if start+p.period <= p.closed {
+		return p.writeLate(start, line)
+	}
+
+	part, ok := p.parts[start]
+	if !ok {
+		var err error
+		part, err = p.open(start)
+		if err != nil {
+			return err
+		}
+		p.parts[start] = part
+	}
+
+	if err := part.file.Write(line); err != nil {
+		return err
+	}
+
+	part.hash.Write(line)
+	part.bytes += uint64(len(line))
+	part.records++
+
+	if part.records == 1 || ts < part.min {
+		part.min = ts
+	}
+	if ts > part.max {
+		part.max = ts
+	}
+
+	return nil
+}
+
+// clamp returns the timestamp up to the wall clock plus the grace
+func (p *Partitioner) clamp(ts int64) int64 {
+	if max := p.now().Unix() + p.grace; ts > max {
+		return max
+	}
+
+	return ts
+}
+
+// Flush flushes the buffered records of the open partitions
+// and the late files.
+func (p *Partitioner) Flush() error {
+	var err error
+
+	for _, part := range p.parts {
+		if e := part.file.Flush(); e != nil {
+			err = e
+		}
+	}
+
+	for _, f := range p.late {
+		if e := f.Flush(); e != nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Close closes all the partitions and the late files, e.g. at the shutdown
+func (p *Partitioner) Close() error {
+	err := p.closeBefore(1<<63 - 1)
+
+	for start, f := range p.late {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+
+		delete(p.late, start)
+	}
+
+	return err
+}
+
+// closeBefore closes the partitions which have been ended by the time
+func (p *Partitioner) closeBefore(t int64) error {
+	var err error
+
+	for start, part := range p.parts {
+		end := start + p.period
+		if end > t {
+			continue
+		}
+
+		if e := p.finish(part); e != nil {
+			err = e
+		}
+
+		delete(p.parts, start)
+
+		if end > p.closed {
+			p.closed = end
+		}
+	}
+
+	return err
+}
+
+// open creates the file of the period, an existing file (e.g. after
+// a restart) is never appended since its manifest has been written.
+func (p *Partitioner) open(start int64) (*partition, error) {
+	dir := filepath.Join(p.dir, time.Unix(start, 0).UTC().Format(p.layout))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ext := filepath.Ext(p.name)
+	base := strings.TrimSuffix(p.name, ext)
+	path := filepath.Join(dir, p.name)
+
+	for i := 1; ; i++ {
+		f, err := safewriter.Create(path, p.opts)
+		if os.IsExist(err) {
+			path = filepath.Join(dir, fmt.Sprintf("%s.%d%s", base, i, ext))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		part := &partition{path: path, file: f, hash: sha256.New()}
+		if len(p.header) > 0 {
+			if err := f.Write(p.header); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+
+		part.hash.Write(p.header)
+		part.bytes = uint64(len(p.header))
+
+		return part, nil
+	}
+}
+
+// finish closes the partition file and writes its manifest
+func (p *Partitioner) finish(part *partition) error {
+	if err := part.file.Close(); err != nil {
+		return err
+	}
+
+	if !p.manifest {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(Manifest{
+		File:         filepath.Base(part.path),
+		Records:      part.records,
+		MinTimestamp: part.min,
+		MaxTimestamp: part.max,
+		Bytes:        part.bytes,
+		SHA256:       hex.EncodeToString(part.hash.Sum(nil)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := part.path + ".manifest.json.tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, part.path+".manifest.json")
+}
+
+// writeLate appends the record to the late file of the period, the
+// late files are kept open up to the maxLateFiles.
+func (p *Partitioner) writeLate(start int64, line []byte) error {
+	f, ok := p.late[start]
+	if !ok {
+		var err error
+		f, err = p.openLate(start)
+		if err != nil {
+			return err
+		}
+		p.late[start] = f
+	}
+
+	return f.Write(line)
+}
+
+// openLate opens the late file of the period, the oldest
+// late file is closed if there are the maxLateFiles already.
+func (p *Partitioner) openLate(start int64) (*safewriter.Writer, error) {
+	if len(p.late) >= maxLateFiles {
+		oldest := int64(1<<63 - 1)
+		for s := range p.late {
+			if s < oldest {
+				oldest = s
+			}
+		}
+
+		err := p.late[oldest].Close()
+		delete(p.late, oldest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Join(p.dir, "late", time.Unix(start, 0).UTC().Format(p.layout))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := safewriter.Open(filepath.Join(dir, p.name), p.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Size() == 0 && len(p.header) > 0 {
+		if err := f.Write(p.header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
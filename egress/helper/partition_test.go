@@ -0,0 +1,144 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionConfigFrom(t *testing.T) {
+	pCfg, err := PartitionConfigFrom(map[string]interface{}{"filename": "/tmp/tcpdog.csv"})
+	assert.NoError(t, err)
+	assert.Equal(t, &PartitionConfig{PartitionLayout: "2006/01/02/15", PartitionGrace: 60, Sync: "none", SyncInterval: 1000}, pCfg)
+
+	pCfg, err = PartitionConfigFrom(map[string]interface{}{
+		"partition":       3600,
+		"partitionLayout": "dt=2006-01-02/hr=15",
+		"partitionGrace":  10,
+		"manifest":        true,
+		"sync":            "always",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &PartitionConfig{3600, "dt=2006-01-02/hr=15", 10, true, "always", 1000}, pCfg)
+
+	_, err = PartitionConfigFrom(map[string]interface{}{"partition": -1})
+	assert.Error(t, err)
+
+	_, err = PartitionConfigFrom(map[string]interface{}{"partition": 60, "partitionLayout": "../15"})
+	assert.Error(t, err)
+
+	_, err = PartitionConfigFrom(map[string]interface{}{"sync": "never"})
+	assert.EqualError(t, err, "sync never is not supported")
+}
+
+func TestPartitioner(t *testing.T) {
+	dir := t.TempDir()
+
+	p := NewPartitioner(&PartitionConfig{
+		Partition:       60,
+		PartitionLayout: "2006/01/02/15-04",
+		PartitionGrace:  10,
+		Manifest:        true,
+	}, filepath.Join(dir, "tcpdog.csv"))
+	p.SetHeader([]byte("F1,timestamp\n"))
+
+	// 1611634140 is 2021-01-26T04:09:00Z
+	for _, r := range []struct {
+		ts   int64
+		line string
+	}{
+		{1611634135, "1,1611634135\n"},
+		{1611634141, "2,1611634141\n"}, // the next period
+		{1611634138, "3,1611634138\n"}, // out of order in the grace
+		{1611634150, "4,1611634150\n"}, // closes 04:08
+		{1611634139, "5,1611634139\n"}, // late
+		{1611634145, "6,1611634145\n"},
+	} {
+		assert.NoError(t, p.Write(r.ts, []byte(r.line)))
+	}
+
+	assert.NoError(t, p.Close())
+
+	read := func(path string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, path))
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	first := "F1,timestamp\n1,1611634135\n3,1611634138\n"
+	assert.Equal(t, first, read("2021/01/26/04-08/tcpdog.csv"))
+	assert.Equal(t, "F1,timestamp\n2,1611634141\n4,1611634150\n6,1611634145\n", read("2021/01/26/04-09/tcpdog.csv"))
+	assert.Equal(t, "F1,timestamp\n5,1611634139\n", read("late/2021/01/26/04-08/tcpdog.csv"))
+
+	m := Manifest{}
+	assert.NoError(t, json.Unmarshal([]byte(read("2021/01/26/04-08/tcpdog.csv.manifest.json")), &m))
+	sum := sha256.Sum256([]byte(first))
+	assert.Equal(t, Manifest{
+		File:         "tcpdog.csv",
+		Records:      2,
+		MinTimestamp: 1611634135,
+		MaxTimestamp: 1611634138,
+		Bytes:        uint64(len(first)),
+		SHA256:       hex.EncodeToString(sum[:]),
+	}, m)
+
+	m = Manifest{}
+	assert.NoError(t, json.Unmarshal([]byte(read("2021/01/26/04-09/tcpdog.csv.manifest.json")), &m))
+	assert.Equal(t, uint64(3), m.Records)
+	assert.Equal(t, int64(1611634141), m.MinTimestamp)
+	assert.Equal(t, int64(1611634150), m.MaxTimestamp)
+
+	// a restart doesn't append to a closed partition file
+	p = NewPartitioner(&PartitionConfig{Partition: 60, PartitionLayout: "2006/01/02/15-04"}, filepath.Join(dir, "tcpdog.csv"))
+	assert.NoError(t, p.Write(1611634142, []byte("7,1611634142\n")))
+	assert.NoError(t, p.Close())
+	assert.Equal(t, "7,1611634142\n", read("2021/01/26/04-09/tcpdog.1.csv"))
+
+	_, err := os.Stat(filepath.Join(dir, "2021/01/26/04-09/tcpdog.1.csv.manifest.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPartitionerWatermark(t *testing.T) {
+	dir := t.TempDir()
+
+	p := NewPartitioner(&PartitionConfig{
+		Partition:       60,
+		PartitionLayout: "2006/01/02/15-04",
+		PartitionGrace:  10,
+	}, filepath.Join(dir, "tcpdog.jsonl"))
+
+	// the wall clock is 04:08:50
+	now := int64(1611634130)
+	p.now = func() time.Time { return time.Unix(now, 0) }
+
+	assert.NoError(t, p.Write(1611634125, []byte("1\n")))
+	// the record from the future doesn't close 04:08
+	assert.NoError(t, p.Write(1611640000, []byte("2\n")))
+	assert.NoError(t, p.Write(1611634128, []byte("3\n")))
+
+	// the late records are appended to the open late file
+	now = 1611634200
+	assert.NoError(t, p.Write(1611634200, []byte("4\n")))
+	assert.NoError(t, p.Write(1611634120, []byte("5\n")))
+	assert.NoError(t, p.Write(1611634121, []byte("6\n")))
+	assert.Len(t, p.late, 1)
+
+	assert.NoError(t, p.Close())
+	assert.Len(t, p.late, 0)
+
+	read := func(path string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, path))
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	assert.Equal(t, "1\n3\n", read("2021/01/26/04-08/tcpdog.jsonl"))
+	assert.Equal(t, "5\n6\n", read("late/2021/01/26/04-08/tcpdog.jsonl"))
+}
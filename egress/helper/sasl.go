@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// SASL sets the kafka SASL authentication of the sarama configuration,
+// the mechanism is PLAIN (default), SCRAM-SHA-256 or SCRAM-SHA-512.
+func SASL(sConfig *sarama.Config, cfg *config.SASLConfig) error {
+	if !cfg.Enable {
+		return nil
+	}
+
+	switch strings.ToUpper(cfg.Mechanism) {
+	case "", sarama.SASLTypePlaintext:
+		sConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256:
+		sConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		sConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: sha256.New}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		sConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		sConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: sha512.New}
+		}
+	default:
+		return fmt.Errorf("kafka sasl mechanism %s is not supported", cfg.Mechanism)
+	}
+
+	password, err := saslPassword(cfg)
+	if err != nil {
+		return err
+	}
+
+	sConfig.Net.SASL.Enable = true
+	sConfig.Net.SASL.Handshake = true
+	sConfig.Net.SASL.User = cfg.Username
+	sConfig.Net.SASL.Password = password
+
+	return nil
+}
+
+// saslPassword returns the password of the PasswordFile, the
+// PasswordEnv environment variable or the Password in this order.
+func saslPassword(cfg *config.SASLConfig) (string, error) {
+	if cfg.PasswordFile != "" {
+		b, err := ioutil.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	if cfg.PasswordEnv != "" {
+		password, ok := os.LookupEnv(cfg.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("kafka sasl password environment variable %s is not set", cfg.PasswordEnv)
+		}
+
+		return password, nil
+	}
+
+	return cfg.Password, nil
+}
+
+// scramClient implements the sarama SCRAM client
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (s *scramClient) Begin(username, password, authzID string) error {
+	client, err := s.hash.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	s.conv = client.NewConversation()
+
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.conv.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.conv.Done()
+}
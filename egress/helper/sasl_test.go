@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestSASL(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		expected  sarama.SASLMechanism
+		scram     bool
+	}{
+		{"", sarama.SASLTypePlaintext, false},
+		{"plain", sarama.SASLTypePlaintext, false},
+		{"SCRAM-SHA-256", sarama.SASLTypeSCRAMSHA256, true},
+		{"scram-sha-512", sarama.SASLTypeSCRAMSHA512, true},
+	}
+
+	for _, test := range tests {
+		sConfig := sarama.NewConfig()
+		err := SASL(sConfig, &config.SASLConfig{
+			Enable:    true,
+			Mechanism: test.mechanism,
+			Username:  "tcpdog",
+			Password:  "secret",
+		})
+		assert.NoError(t, err, test.mechanism)
+
+		assert.True(t, sConfig.Net.SASL.Enable)
+		assert.True(t, sConfig.Net.SASL.Handshake)
+		assert.Equal(t, test.expected, sConfig.Net.SASL.Mechanism)
+		assert.Equal(t, "tcpdog", sConfig.Net.SASL.User)
+		assert.Equal(t, "secret", sConfig.Net.SASL.Password)
+
+		if test.scram {
+			assert.IsType(t, &scramClient{}, sConfig.Net.SASL.SCRAMClientGeneratorFunc())
+			assert.NoError(t, sConfig.Validate())
+		} else {
+			assert.Nil(t, sConfig.Net.SASL.SCRAMClientGeneratorFunc)
+		}
+	}
+
+	// disabled
+	sConfig := sarama.NewConfig()
+	err := SASL(sConfig, &config.SASLConfig{Username: "tcpdog"})
+	assert.NoError(t, err)
+	assert.False(t, sConfig.Net.SASL.Enable)
+
+	// unknown mechanism
+	err = SASL(sarama.NewConfig(), &config.SASLConfig{Enable: true, Mechanism: "GSSAPI"})
+	assert.EqualError(t, err, "kafka sasl mechanism GSSAPI is not supported")
+}
+
+func TestSASLPassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	ioutil.WriteFile(file, []byte("fromfile\n"), 0600)
+
+	os.Setenv("TCPDOG_TEST_SASL_PASSWORD", "fromenv")
+	defer os.Unsetenv("TCPDOG_TEST_SASL_PASSWORD")
+
+	password, err := saslPassword(&config.SASLConfig{Password: "plain", PasswordFile: file, PasswordEnv: "TCPDOG_TEST_SASL_PASSWORD"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fromfile", password)
+
+	password, err = saslPassword(&config.SASLConfig{Password: "plain", PasswordEnv: "TCPDOG_TEST_SASL_PASSWORD"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fromenv", password)
+
+	password, err = saslPassword(&config.SASLConfig{Password: "plain"})
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", password)
+
+	_, err = saslPassword(&config.SASLConfig{PasswordEnv: "TCPDOG_TEST_SASL_UNSET"})
+	assert.EqualError(t, err, "kafka sasl password environment variable TCPDOG_TEST_SASL_UNSET is not set")
+
+	_, err = saslPassword(&config.SASLConfig{PasswordFile: filepath.Join(t.TempDir(), "notexist")})
+	assert.Error(t, err)
+}
+
+func TestSCRAMClient(t *testing.T) {
+	sConfig := sarama.NewConfig()
+	err := SASL(sConfig, &config.SASLConfig{Enable: true, Mechanism: "SCRAM-SHA-512", Username: "tcpdog", Password: "secret"})
+	assert.NoError(t, err)
+
+	c := sConfig.Net.SASL.SCRAMClientGeneratorFunc()
+	assert.NoError(t, c.Begin("tcpdog", "secret", ""))
+
+	// client-first message
+	msg, err := c.Step("")
+	assert.NoError(t, err)
+	assert.Contains(t, msg, "n=tcpdog,r=")
+	assert.False(t, c.Done())
+}
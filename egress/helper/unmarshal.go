@@ -0,0 +1,60 @@
+package helper
+
+import (
+	"encoding/json"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// Unmarshaler returns the unmarshal function of the serialization for
+// the ingresses, the compressed payloads are decompressed based on their
+// header up to the max size and the json cloudevents are unwrapped.
+func Unmarshaler(ser string, maxSize int) func(b []byte) (interface{}, error) {
+	unmarshal := unmarshaler(ser)
+	if unmarshal == nil {
+		return nil
+	}
+
+	return func(b []byte) (interface{}, error) {
+		b, err := DecompressPayload(b, maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		return unmarshal(b)
+	}
+}
+
+func unmarshaler(ser string) func(b []byte) (interface{}, error) {
+	switch ser {
+	case "json":
+		return func(b []byte) (interface{}, error) {
+			m := map[string]interface{}{}
+			err := json.Unmarshal(b, &m)
+			return UnwrapCloudEvent(m), err
+		}
+	case "spb":
+		return func(b []byte) (interface{}, error) {
+			p := pb.FieldsSPB{}
+			err := serialization.Unmarshal(b, &p)
+			return &p, err
+		}
+	case "pb":
+		return func(b []byte) (interface{}, error) {
+			p := pb.Fields{}
+			err := serialization.Unmarshal(b, &p)
+			return &p, err
+		}
+	case "msgpack":
+		return func(b []byte) (interface{}, error) {
+			return serialization.UnmarshalMsgpack(b)
+		}
+	case "cbor":
+		return func(b []byte) (interface{}, error) {
+			return serialization.UnmarshalCBOR(b)
+		}
+	}
+
+	return nil
+}
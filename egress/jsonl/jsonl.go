@@ -4,19 +4,24 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/safewriter"
 )
 
 type jsonl struct {
-	fieldsLen  []int
 	fieldsName []string
-	file       io.WriteCloser
+	order      *helper.FieldOrder
+	values     [][]byte
+	file       *safewriter.Writer
 	buffer     *bytes.Buffer
+	parts      *helper.Partitioner
+	ts         int64
+	compress   func([]byte) []byte
 }
 
 var comma = []byte(",")[0]
@@ -25,33 +30,56 @@ func (j *jsonl) init(conf map[string]interface{}, fields []config.Field) error {
 	var err error
 
 	for _, f := range fields {
-		j.fieldsLen = append(j.fieldsLen, len(f.Name)+3)
 		j.fieldsName = append(j.fieldsName, f.Name)
 	}
 
+	j.order = helper.NewFieldOrder(fields)
+
+	j.compress, err = helper.LineCompressorFrom(conf)
+	if err != nil {
+		return err
+	}
+
 	filename, ok := conf["filename"].(string)
 	if !ok {
 		return fmt.Errorf("file has not been configured")
 	}
 
-	j.file, err = os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	pCfg, err := helper.PartitionConfigFrom(conf)
+	if err != nil {
+		return err
+	}
+
+	if pCfg.Partition > 0 {
+		j.parts = helper.NewPartitioner(pCfg, filename)
+		return nil
+	}
+
+	j.file, err = safewriter.Open(filename, pCfg.WriterOptions())
 
 	return err
 }
 
 func (j *jsonl) marshal(buf *bytes.Buffer) {
-	buf.Next(1) // skip bracket
+	if j.parts != nil {
+		var ok bool
+		if j.ts, ok = helper.RecordTimestamp(buf.Bytes()); !ok {
+			j.ts = time.Now().Unix()
+		}
+	}
 
 	j.buffer.WriteRune('[')
-	for _, l := range j.fieldsLen {
-		buf.Next(l)
-		v, _ := buf.ReadBytes(comma)
+	j.values = j.order.Values(j.values[:0], buf.Bytes())
+	for i, v := range j.values {
+		if i > 0 {
+			j.buffer.WriteByte(comma)
+		}
+		if v == nil {
+			j.buffer.WriteString("null")
+			continue
+		}
 		j.buffer.Write(v)
 	}
-
-	buf.Next(12)                 // skip timestamp key
-	j.buffer.Write(buf.Next(10)) // write timestamp
-
 	j.buffer.WriteRune(']')
 }
 
@@ -60,12 +88,41 @@ func (j *jsonl) header() {
 	j.buffer.WriteString(fmt.Sprintf("[%s,timestamp]", m))
 }
 func (j *jsonl) flush() {
-	j.buffer.WriteRune('\n')
-	j.file.Write(j.buffer.Bytes())
+	line := j.line()
+	if j.parts != nil {
+		j.parts.Write(j.ts, line)
+	} else {
+		j.file.Write(line)
+	}
 	j.buffer.Reset()
 }
 
+// line returns the buffered line, it's compressed if
+// the payloadCompression has been configured.
+func (j *jsonl) line() []byte {
+	if j.compress != nil {
+		return append(j.compress(j.buffer.Bytes()), '\n')
+	}
+
+	j.buffer.WriteByte('\n')
+
+	return j.buffer.Bytes()
+}
+
+// sync writes the buffered records to the file(s)
+func (j *jsonl) sync() {
+	if j.parts != nil {
+		j.parts.Flush()
+		return
+	}
+	j.file.Flush()
+}
+
 func (j *jsonl) cleanup() {
+	if j.parts != nil {
+		j.parts.Close()
+		return
+	}
 	j.file.Close()
 }
 
@@ -83,11 +140,19 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 	}
 
 	j.header()
-	j.flush()
+	if j.parts != nil {
+		// the header is written at the beginning of each partition file
+		j.parts.SetHeader(j.line())
+		j.buffer.Reset()
+	} else {
+		j.flush()
+		j.sync()
+	}
 
 	go func() {
 		defer j.cleanup()
 		var buf *bytes.Buffer
+		inflight := helper.InflightFrom(ctx)
 
 		for {
 			select {
@@ -100,6 +165,11 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 			j.flush()
 
 			bufpool.Put(buf)
+			inflight.Add(-1)
+
+			if len(ch) == 0 {
+				j.sync()
+			}
 		}
 	}()
 
@@ -5,11 +5,14 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -77,3 +80,52 @@ func TestStart(t *testing.T) {
 	err = Start(ctx, tp, bufPool, ch)
 	assert.Error(t, err)
 }
+
+func TestStartPayloadCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tp := config.Tracepoint{Egress: "myegress", Fields: "myfields"}
+	ch := make(chan *bytes.Buffer, 1)
+	bufPool := &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+	filename := filepath.Join(t.TempDir(), "testfile.jsonl")
+
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"myegress": {
+				Type: "jsonl",
+				Config: map[string]interface{}{
+					"filename":           filename,
+					"payloadCompression": "zstd",
+				},
+			},
+		},
+		Fields: map[string][]config.Field{
+			"myfields": {{Name: "F1"}, {Name: "F2"}},
+		},
+	}
+
+	assert.NoError(t, Start(cfg.WithContext(ctx), tp, bufPool, ch))
+
+	b := new(bytes.Buffer)
+	b.WriteString(`{"F1":5,"F2":6,"Timestamp":1609564925}`)
+	ch <- b
+
+	var lines []string
+	assert.Eventually(t, func() bool {
+		fb, _ := ioutil.ReadFile(filename)
+		lines = strings.Split(strings.TrimSpace(string(fb)), "\n")
+		return len(lines) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// a line per message
+	for i, expected := range []string{"[F1,F2,timestamp]", "[5,6,1609564925]"} {
+		line, err := helper.DecompressLine([]byte(lines[i]))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(line))
+	}
+
+	cfg.Egress["myegress"].Config["payloadCompression"] = "lz4"
+	assert.EqualError(t, Start(cfg.WithContext(ctx), tp, bufPool, ch), "unknown payload compression: lz4")
+}
@@ -1,11 +1,12 @@
 package kafka
 
 import (
-	"log"
 	"time"
 
 	"github.com/Shopify/sarama"
+
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
 )
 
 // Config represents Kafka configuration
@@ -19,13 +20,54 @@ type Config struct {
 	RequestSizeMax int32
 	RetryBackoff   int
 
+	// Ordered routes all the events of a connection, from any tracepoint
+	// which shares this egress, through one worker and one partition.
+	// it keeps the per-connection order at the cost of throughput since
+	// a busy connection can't be spread across the workers and only one
+	// in-flight request per broker is allowed.
+	Ordered bool
+
+	// DeadLetterTopic receives the messages of the ordered mode which
+	// the producer has given up after the retries, they can't be retried
+	// in order since the next events of the connection may have been
+	// produced already. they're dropped if it's not set.
+	DeadLetterTopic string
+
+	// OrderedJSON re-encodes the json events in the order of the
+	// fields list, Timestamp and Hostname are appended in this order.
+	OrderedJSON bool
+
+	// PayloadCompression compresses each serialized message with a
+	// codec header (none, gzip or zstd), it's independent of the kafka
+	// compression and the kafka ingress decompresses it per message.
+	PayloadCompression string
+
+	// CloudEvents is the envelope configuration of the
+	// cloudevents serialization (structured mode).
+	CloudEvents CloudEventsConfig
+
+	// Version is the kafka version e.g. 2.1.0, the tracing
+	// traceparent header requires 0.11.0 or later.
+	Version string
+
+	// SASLUsername and SASLPassword are the PLAIN authentication
+	// if the SASL isn't configured, they're deprecated.
 	SASLUsername string
 	SASLPassword string
 
+	SASL      config.SASLConfig
 	TLSConfig config.TLSConfig
 }
 
-func kafkaConfig(cfg map[string]interface{}) *Config {
+// CloudEventsConfig represents the CloudEvents attributes, the source
+// is a template of the hostname e.g. /tcpdog/{{.Hostname}} and the type
+// is derived from the tracepoint name if it's not set.
+type CloudEventsConfig struct {
+	Source string
+	Type   string
+}
+
+func kafkaConfig(cfg map[string]interface{}) (*Config, error) {
 	c := &Config{
 		Brokers:        []string{"localhost:9092"},
 		Topic:          "tcpdog",
@@ -37,10 +79,14 @@ func kafkaConfig(cfg map[string]interface{}) *Config {
 	}
 
 	if err := config.Transform(cfg, c); err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if !c.SASL.Enable && c.SASLUsername != "" {
+		c.SASL = config.SASLConfig{Enable: true, Username: c.SASLUsername, Password: c.SASLPassword}
 	}
 
-	return c
+	return c, nil
 }
 
 func saramaConfig(kCfg *Config) (*sarama.Config, error) {
@@ -51,6 +97,18 @@ func saramaConfig(kCfg *Config) (*sarama.Config, error) {
 	sConfig.Producer.Retry.Backoff = time.Duration(kCfg.RetryBackoff) * time.Millisecond
 	sarama.MaxRequestSize = kCfg.RequestSizeMax
 
+	if kCfg.Ordered {
+		sConfig.Net.MaxOpenRequests = 1
+	}
+
+	if kCfg.Version != "" {
+		version, err := sarama.ParseKafkaVersion(kCfg.Version)
+		if err != nil {
+			return nil, err
+		}
+		sConfig.Version = version
+	}
+
 	if kCfg.TLSConfig.Enable {
 		tlsConfig, err := config.GetTLS(&kCfg.TLSConfig)
 		if err != nil {
@@ -61,6 +119,10 @@ func saramaConfig(kCfg *Config) (*sarama.Config, error) {
 		sConfig.Net.TLS.Config = tlsConfig
 	}
 
+	if err := helper.SASL(sConfig, &kCfg.SASL); err != nil {
+		return nil, err
+	}
+
 	switch kCfg.Compression {
 	case "gzip":
 		sConfig.Producer.Compression = sarama.CompressionGZIP
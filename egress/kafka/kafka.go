@@ -6,31 +6,59 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/fault"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/spool"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
 type kafka struct {
+	name     string
 	producer sarama.AsyncProducer
 	bufpool  *sync.Pool
 	dCh      chan *bytes.Buffer
-	bCh      chan []byte
+	bCh      chan event
+	hCh      chan event
+	lane     *priority.Lane
 	jsonTail []byte
+	order    *helper.FieldOrder
+	ce       *helper.CloudEvents
+	compress func([]byte) ([]byte, error)
+	inflight *helper.Inflight
+	spool    *spool.Spool
+
+	// wg waits for the loops which produce to the producer
+	wg sync.WaitGroup
+}
+
+// event is a marshaled event of the producer loop, the raw is the
+// encoded event which is spooled once the producer fails to deliver
+// it, it's kept only if the egress has a spool.
+type event struct {
+	b   []byte
+	raw []byte
 }
 
 // Start starts producing the requested fields to kafka cluster.
 func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
-	var (
-		cfg  = config.FromContext(ctx)
-		kCfg = kafkaConfig(cfg.Egress[tp.Egress].Config)
-	)
+	cfg := config.FromContext(ctx)
+
+	kCfg, err := kafkaConfig(cfg.Egress[tp.Egress].Config)
+	if err != nil {
+		return err
+	}
 
 	sCfg, err := saramaConfig(kCfg)
 	if err != nil {
@@ -42,9 +70,24 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 		return err
 	}
 
+	if cfg.Tracing.Enabled() && !sCfg.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return fmt.Errorf("kafka tracing requires the version 0.11.0 or later (%s)", tp.Egress)
+	}
+
 	k := kafka{
+		name:    tp.Egress,
 		bufpool: bufpool,
 		dCh:     ch,
+		hCh:     make(chan event, priority.Reserved),
+		lane:    priority.FromContext(ctx),
+
+		inflight: helper.InflightFrom(ctx),
+		spool:    spool.FromContext(ctx),
+	}
+
+	k.compress, err = helper.PayloadCompressor(kCfg.PayloadCompression)
+	if err != nil {
+		return err
 	}
 
 	k.producer, err = sarama.NewAsyncProducer(kCfg.Brokers, sCfg)
@@ -52,24 +95,63 @@ func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch cha
 		return err
 	}
 
+	defer func() {
+		if err != nil {
+			k.producer.Close()
+			return
+		}
+
+		go k.close(ctx)
+	}()
+
 	k.hostname()
 
+	if kCfg.OrderedJSON {
+		k.order = helper.NewFieldOrder(cfg.Fields[tp.Fields])
+	}
+
+	if kCfg.Serialization == "cloudevents" {
+		k.ce, err = helper.NewCloudEvents(kCfg.CloudEvents.Source, kCfg.CloudEvents.Type, tp.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if kCfg.Ordered {
+		k.orderedLoop(ctx, kCfg, cfg.Fields[tp.Fields])
+		return nil
+	}
+
 	switch kCfg.Serialization {
 	case "spb":
-		k.bCh = make(chan []byte, 1000)
+		k.bCh = make(chan event, 1000)
 		for i := 0; i < kCfg.Workers; i++ {
 			go k.workerSPB(ctx, cfg.Fields[tp.Fields])
 		}
 		k.protobufLoop(ctx, kCfg.Topic)
 
 	case "pb":
-		k.bCh = make(chan []byte, 1000)
+		k.bCh = make(chan event, 1000)
 		for i := 0; i < kCfg.Workers; i++ {
 			go k.workerPB(ctx, cfg.Fields[tp.Fields])
 		}
 		k.protobufLoop(ctx, kCfg.Topic)
 
-	case "json":
+	case "msgpack":
+		k.bCh = make(chan event, 1000)
+		for i := 0; i < kCfg.Workers; i++ {
+			go k.workerEncode(ctx, serialization.JSONToMsgpack)
+		}
+		k.protobufLoop(ctx, kCfg.Topic)
+
+	case "cbor":
+		k.bCh = make(chan event, 1000)
+		for i := 0; i < kCfg.Workers; i++ {
+			go k.workerEncode(ctx, serialization.JSONToCBOR)
+		}
+		k.protobufLoop(ctx, kCfg.Topic)
+
+	case "json", "cloudevents":
 		k.jsonLoop(ctx, kCfg.Topic)
 	}
 
@@ -82,20 +164,23 @@ func (k *kafka) workerSPB(ctx context.Context, fields []config.Field) {
 	logger := config.FromContext(ctx).Logger()
 
 	for {
-		select {
-		case buf := <-k.dCh:
-			a := &pb.FieldsSPB{
-				Fields: spb.Unmarshal(buf),
-			}
+		buf, c, ok := k.lane.RecvBuffer(ctx, k.dCh)
+		if !ok {
+			return
+		}
+		k.inflight.Recv(c)
 
-			b, err := proto.Marshal(a)
-			if err != nil {
-				logger.Error("kafka", zap.Error(err))
-			}
+		b, err := marshalSPB(spb, buf)
+		if err == nil {
+			b, err = k.payload(b)
+		}
+		if err != nil {
+			logger.Error("kafka", zap.Error(err))
+		}
 
-			k.bCh <- b
-			k.bufpool.Put(buf)
-		case <-ctx.Done():
+		e := event{b: b, raw: k.keep(buf)}
+		k.bufpool.Put(buf)
+		if !k.send(ctx, c, e) {
 			return
 		}
 	}
@@ -108,18 +193,248 @@ func (k *kafka) workerPB(ctx context.Context, fields []config.Field) {
 	hostname, _ := os.Hostname()
 
 	for {
+		buf, c, ok := k.lane.RecvBuffer(ctx, k.dCh)
+		if !ok {
+			return
+		}
+		k.inflight.Recv(c)
+
+		b, err := marshalPB(buf, hostname)
+		if err == nil {
+			b, err = k.payload(b)
+		}
+		if err != nil {
+			logger.Error("kafka", zap.Error(err))
+		}
+
+		e := event{b: b, raw: k.keep(buf)}
+		k.bufpool.Put(buf)
+		if !k.send(ctx, c, e) {
+			return
+		}
+	}
+}
+
+// msgpack and cbor worker, the json is encoded with the hostname
+func (k *kafka) workerEncode(ctx context.Context, encode func([]byte) ([]byte, error)) {
+	logger := config.FromContext(ctx).Logger()
+
+	for {
+		buf, c, ok := k.lane.RecvBuffer(ctx, k.dCh)
+		if !ok {
+			return
+		}
+		k.inflight.Recv(c)
+
+		b, err := encode(k.addHostname(buf))
+		if err == nil {
+			b, err = k.payload(b)
+		}
+		if err != nil {
+			logger.Error("kafka", zap.Error(err))
+		}
+
+		e := event{b: b, raw: k.keep(buf)}
+		k.bufpool.Put(buf)
+		if !k.send(ctx, c, e) {
+			return
+		}
+	}
+}
+
+// close closes the producer once the loops have been stopped, the
+// producer flushes its buffered messages and the failed ones are dropped.
+func (k *kafka) close(ctx context.Context) {
+	<-ctx.Done()
+	k.wg.Wait()
+
+	if errs, ok := k.producer.Close().(sarama.ProducerErrors); ok {
+		drops.Add(drops.EgressPublish, k.name, uint64(len(errs)))
+	}
+}
+
+// send sends the marshaled event to the producer loop, the high
+// events keep their priority up to the reserved capacity. it returns
+// false once the context is done and the event is dropped as the
+// producer loop doesn't drain the channels at the shutdown.
+func (k *kafka) send(ctx context.Context, c priority.Class, e event) bool {
+	if c == priority.High {
 		select {
-		case buf := <-k.dCh:
-			m := pb.Fields{}
-			protojson.Unmarshal(buf.Bytes(), &m)
-			m.Hostname = &hostname
-			b, err := proto.Marshal(&m)
+		case k.hCh <- e:
+			return true
+		default:
+		}
+	}
+
+	select {
+	case k.bCh <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// failed logs the producer error, the message has been
+// dropped once the producer gives up the retries.
+func (k *kafka) failed(logger *zap.Logger, err error) {
+	drops.Add(drops.EgressPublish, k.name, 1)
+	metrics.EgressError(k.name)
+	logger.Error("kafka", zap.Error(err))
+}
+
+// keep returns a copy of the encoded event if the egress has a spool
+func (k *kafka) keep(buf *bytes.Buffer) []byte {
+	if k.spool == nil {
+		return nil
+	}
+
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// spill spools the encoded event of a message which has been failed,
+// it's replayed once the producer delivers again. it returns false
+// if the message doesn't have the encoded event or it can't be spooled.
+func (k *kafka) spill(raw interface{}) bool {
+	b, ok := raw.([]byte)
+	if !ok || b == nil || !k.spool.Spill(bytes.NewBuffer(b)) {
+		return false
+	}
+
+	metrics.EgressError(k.name)
+
+	return true
+}
+
+// errored spools the message which the producer has failed to
+// deliver, it's dropped if it can't be spooled.
+func (k *kafka) errored(logger *zap.Logger, pErr *sarama.ProducerError) {
+	if !k.spill(pErr.Msg.Metadata) {
+		k.failed(logger, pErr)
+	}
+}
+
+// orderedLoop routes the events by connection tuple to the workers,
+// each worker marshals and produces its events in order with the
+// connection tuple as message key so they land on one partition.
+func (k *kafka) orderedLoop(ctx context.Context, kCfg *Config, fields []config.Field) {
+	workers := make([]chan *bytes.Buffer, kCfg.Workers)
+	for i := range workers {
+		workers[i] = make(chan *bytes.Buffer, 1000)
+		k.wg.Add(1)
+		go k.orderedWorker(ctx, workers[i], kCfg, fields)
+	}
+
+	k.wg.Add(1)
+	go k.orderedErrors(ctx, kCfg.DeadLetterTopic)
+
+	go helper.Route(ctx, k.dCh, workers)
+}
+
+// orderedErrors handles the failed messages of the ordered workers in
+// the order which the producer gives them up, the workers don't read the
+// errors since an error may belong to the connection of another worker.
+// the failed message is spooled if the egress has a spool otherwise it's
+// produced to the dead letter topic if it's set.
+func (k *kafka) orderedErrors(ctx context.Context, topic string) {
+	defer k.wg.Done()
+
+	logger := config.FromContext(ctx).Logger()
+
+	for {
+		select {
+		case pErr := <-k.producer.Errors():
+			if k.spill(pErr.Msg.Metadata) {
+				continue
+			}
+
+			if topic == "" || pErr.Msg.Topic == topic {
+				k.failed(logger, pErr)
+				continue
+			}
+
+			m := &sarama.ProducerMessage{Topic: topic, Key: pErr.Msg.Key, Value: pErr.Msg.Value}
+
+			select {
+			case k.producer.Input() <- m:
+				metrics.EgressError(k.name)
+				logger.Warn("kafka", zap.String("msg", "message has been dead-lettered"), zap.Error(pErr))
+			case <-ctx.Done():
+				k.failed(logger, pErr)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (k *kafka) orderedWorker(ctx context.Context, ch chan *bytes.Buffer, kCfg *Config, fields []config.Field) {
+	defer k.wg.Done()
+
+	var (
+		logger      = config.FromContext(ctx).Logger()
+		spb         = helper.NewStructPB(fields)
+		hostname, _ = os.Hostname()
+	)
+
+	for {
+		select {
+		case buf := <-ch:
+			var (
+				b   []byte
+				err error
+			)
+
+			key := helper.ConnKey(buf.Bytes())
+
+			switch kCfg.Serialization {
+			case "spb":
+				b, err = marshalSPB(spb, buf)
+			case "pb":
+				b, err = marshalPB(buf, hostname)
+			case "msgpack":
+				b, err = serialization.JSONToMsgpack(k.addHostname(buf))
+			case "cbor":
+				b, err = serialization.JSONToCBOR(k.addHostname(buf))
+			default:
+				b = k.addHostname(buf)
+			}
+
+			raw := k.keep(buf)
+			k.bufpool.Put(buf)
+
+			if err == nil {
+				b, err = k.payload(b)
+			}
+
 			if err != nil {
 				logger.Error("kafka", zap.Error(err))
+				k.inflight.Add(-1)
+				continue
 			}
 
-			k.bCh <- b
-			k.bufpool.Put(buf)
+			m, tr := k.message(kCfg.Topic, sarama.ByteEncoder(key), b, raw)
+
+			if err := fault.Check(fault.KafkaPublish); err != nil {
+				tr.Finish(tracing.ErrDropped)
+				if !k.spill(raw) {
+					k.failed(logger, err)
+				}
+				k.inflight.Add(-1)
+				continue
+			}
+
+			select {
+			case k.producer.Input() <- m:
+				tr.Finish(nil)
+				metrics.EgressBytes(k.name, len(b))
+			case <-ctx.Done():
+				tr.Finish(tracing.ErrDropped)
+				return
+			}
+
+			k.inflight.Add(-1)
+
 		case <-ctx.Done():
 			return
 		}
@@ -129,24 +444,51 @@ func (k *kafka) workerPB(ctx context.Context, fields []config.Field) {
 func (k *kafka) jsonLoop(ctx context.Context, topic string) {
 	logger := config.FromContext(ctx).Logger()
 
+	k.wg.Add(1)
 	go func() {
+		defer k.wg.Done()
+
 		for {
-			select {
-			case buf := <-k.dCh:
-				select {
-				case k.producer.Input() <- &sarama.ProducerMessage{
-					Topic: topic,
-					Value: sarama.ByteEncoder(k.addHostname(buf)),
-				}:
-				case err := <-k.producer.Errors():
-					logger.Error("kafka", zap.Error(err))
-				}
+			buf, c, ok := k.lane.RecvBuffer(ctx, k.dCh)
+			if !ok {
+				return
+			}
+			k.inflight.Recv(c)
+
+			b, err := k.payload(k.addHostname(buf))
+			raw := k.keep(buf)
+			k.bufpool.Put(buf)
 
-				k.bufpool.Put(buf)
+			if err != nil {
+				logger.Error("kafka", zap.Error(err))
+				k.inflight.Add(-1)
+				continue
+			}
+
+			m, tr := k.message(topic, nil, b, raw)
+
+			if err := fault.Check(fault.KafkaPublish); err != nil {
+				tr.Finish(tracing.ErrDropped)
+				if !k.spill(raw) {
+					k.failed(logger, err)
+				}
+				k.inflight.Add(-1)
+				continue
+			}
 
+			select {
+			case k.producer.Input() <- m:
+				tr.Finish(nil)
+				metrics.EgressBytes(k.name, len(b))
+			case err := <-k.producer.Errors():
+				tr.Finish(tracing.ErrDropped)
+				k.errored(logger, err)
 			case <-ctx.Done():
+				tr.Finish(tracing.ErrDropped)
 				return
 			}
+
+			k.inflight.Add(-1)
 		}
 	}()
 }
@@ -154,35 +496,113 @@ func (k *kafka) jsonLoop(ctx context.Context, topic string) {
 func (k *kafka) protobufLoop(ctx context.Context, topic string) {
 	logger := config.FromContext(ctx).Logger()
 
+	k.wg.Add(1)
 	go func() {
+		defer k.wg.Done()
+
+		var e event
+
 		for {
+			// the high events are produced first
 			select {
-			//  protobuf (pb) and struct protobuf (spb) serializations
-			case b := <-k.bCh:
+			case e = <-k.hCh:
+			default:
 				select {
-				case k.producer.Input() <- &sarama.ProducerMessage{
-					Topic: topic,
-					Value: sarama.ByteEncoder(b),
-				}:
-				case err := <-k.producer.Errors():
-					logger.Error("kafka", zap.Error(err))
+				// protobuf (pb), struct protobuf (spb), msgpack and cbor serializations
+				case e = <-k.hCh:
+				case e = <-k.bCh:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			m, tr := k.message(topic, nil, e.b, e.raw)
+
+			if err := fault.Check(fault.KafkaPublish); err != nil {
+				tr.Finish(tracing.ErrDropped)
+				if !k.spill(e.raw) {
+					k.failed(logger, err)
 				}
+				k.inflight.Add(-1)
+				continue
+			}
 
+			select {
+			case k.producer.Input() <- m:
+				tr.Finish(nil)
+				metrics.EgressBytes(k.name, len(e.b))
+			case err := <-k.producer.Errors():
+				tr.Finish(tracing.ErrDropped)
+				k.errored(logger, err)
 			case <-ctx.Done():
+				tr.Finish(tracing.ErrDropped)
 				return
 			}
+
+			k.inflight.Add(-1)
 		}
 	}()
 }
 
+// message returns the producer message, a sampled message starts
+// the trace which the server continues by the traceparent header.
+// the raw encoded event is kept as the metadata to be spooled.
+func (k *kafka) message(topic string, key sarama.Encoder, b, raw []byte) (*sarama.ProducerMessage, *tracing.Trace) {
+	m := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   key,
+		Value: sarama.ByteEncoder(b),
+	}
+
+	if raw != nil {
+		m.Metadata = raw
+	}
+
+	if !tracing.Enabled() {
+		return m, nil
+	}
+
+	tr := tracing.Sample(nil, "kafka.produce", "", time.Now())
+	if tr != nil {
+		m.Headers = []sarama.RecordHeader{{Key: []byte(tracing.Header), Value: []byte(tr.Traceparent())}}
+	}
+
+	return m, tr
+}
+
+func marshalSPB(spb *helper.StructPB, buf *bytes.Buffer) ([]byte, error) {
+	fields, err := spb.Unmarshal(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return serialization.Marshal(&pb.FieldsSPB{Fields: fields})
+}
+
+func marshalPB(buf *bytes.Buffer, hostname string) ([]byte, error) {
+	m := pb.Fields{}
+	protojson.Unmarshal(buf.Bytes(), &m)
+	m.Hostname = &hostname
+	return serialization.Marshal(&m)
+}
+
 // addHostname adds hostname to encoded json and returns
-// a fresh byte slice.
+// a fresh byte slice, it's wrapped in a CloudEvent if the
+// serialization is cloudevents.
 func (k *kafka) addHostname(buf *bytes.Buffer) []byte {
 	b := make([]byte, 0, buf.Len()+len(k.jsonTail))
-	b = append(b, buf.Bytes()...)
+	if k.order != nil {
+		b = k.order.AppendJSON(b, buf.Bytes())
+	} else {
+		b = append(b, buf.Bytes()...)
+	}
 	b[len(b)-1] = ','
 	b = append(b, k.jsonTail...)
 
+	if k.ce != nil {
+		return k.ce.Append(make([]byte, 0, len(b)+256), b)
+	}
+
 	return b
 }
 
@@ -190,3 +610,12 @@ func (k *kafka) hostname() {
 	hostname, _ := os.Hostname()
 	k.jsonTail = []byte(fmt.Sprintf("\"Hostname\":\"%s\"}", hostname))
 }
+
+// payload applies the payload compression if it's configured
+func (k *kafka) payload(b []byte) ([]byte, error) {
+	if k.compress == nil {
+		return b, nil
+	}
+
+	return k.compress(b)
+}
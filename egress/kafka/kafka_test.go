@@ -8,13 +8,33 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/spool"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
+// mockBroker returns a broker which is the leader of the topic and
+// accepts the messages thus the producer is closed right away.
+func mockBroker(t *testing.T) *sarama.MockBroker {
+	b := sarama.NewMockBroker(t, 1)
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader("tcpdog", 0, b.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+
+	return b
+}
+
 func TestStartJSON(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -29,9 +49,8 @@ func TestStartJSON(t *testing.T) {
 		},
 	}
 
-	seedBroker := sarama.NewMockBroker(t, 1)
+	seedBroker := mockBroker(t)
 	defer seedBroker.Close()
-	seedBroker.Returns(new(sarama.MetadataResponse))
 
 	cfg := config.Config{
 		Egress: map[string]config.EgressConfig{
@@ -97,9 +116,8 @@ func TestStartSPB(t *testing.T) {
 		},
 	}
 
-	seedBroker := sarama.NewMockBroker(t, 1)
+	seedBroker := mockBroker(t)
 	defer seedBroker.Close()
-	seedBroker.Returns(new(sarama.MetadataResponse))
 
 	cfg := config.Config{
 		Egress: map[string]config.EgressConfig{
@@ -155,9 +173,8 @@ func TestStartPB(t *testing.T) {
 		},
 	}
 
-	seedBroker := sarama.NewMockBroker(t, 1)
+	seedBroker := mockBroker(t)
 	defer seedBroker.Close()
-	seedBroker.Returns(new(sarama.MetadataResponse))
 
 	cfg := config.Config{
 		Egress: map[string]config.EgressConfig{
@@ -208,19 +225,18 @@ func TestWorkerSPB(t *testing.T) {
 
 	k := kafka{
 		dCh:     make(chan *bytes.Buffer, 1),
-		bCh:     make(chan []byte, 1),
+		bCh:     make(chan event, 1),
 		bufpool: bufPool,
 	}
 
 	cfg := config.Config{}
-	ctx := cfg.WithContext(context.Background())
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
 
 	go k.workerSPB(ctx, []config.Field{{Name: "F1"}, {Name: "F2"}})
 	k.dCh <- bytes.NewBufferString(`{"F1":5,"F2":6,"Timestamp":1609564925}`)
 
-	time.Sleep(time.Second)
-
-	b := <-k.bCh
+	b := (<-k.bCh).b
 	spb := pb.FieldsSPB{}
 	err := proto.Unmarshal(b, &spb)
 	assert.NoError(t, err)
@@ -244,19 +260,18 @@ func TestWorkerPB(t *testing.T) {
 
 	k := kafka{
 		dCh:     make(chan *bytes.Buffer, 1),
-		bCh:     make(chan []byte, 1),
+		bCh:     make(chan event, 1),
 		bufpool: bufPool,
 	}
 
 	cfg := config.Config{}
-	ctx := cfg.WithContext(context.Background())
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
 
 	go k.workerPB(ctx, []config.Field{{Name: "RTT"}, {Name: "AdvMSS"}})
 	k.dCh <- bytes.NewBufferString(`{"RTT":5,"AdvMSS":1400,"Timestamp":1609564925}`)
 
-	time.Sleep(time.Second)
-
-	b := <-k.bCh
+	b := (<-k.bCh).b
 	p := pb.Fields{}
 	err := proto.Unmarshal(b, &p)
 	assert.NoError(t, err)
@@ -265,3 +280,357 @@ func TestWorkerPB(t *testing.T) {
 	assert.Equal(t, uint32(1400), *p.AdvMSS)
 	assert.Equal(t, uint64(1609564925), *p.Timestamp)
 }
+
+func TestWorkerMsgpack(t *testing.T) {
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	k := kafka{
+		dCh:     make(chan *bytes.Buffer, 1),
+		bCh:     make(chan event, 1),
+		bufpool: bufPool,
+	}
+	k.hostname()
+
+	cfg := config.Config{}
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	go k.workerEncode(ctx, serialization.JSONToMsgpack)
+	k.dCh <- bytes.NewBufferString(`{"RTT":5,"DAddr":"10.0.0.1","Timestamp":1609564925}`)
+
+	m, err := serialization.UnmarshalMsgpack((<-k.bCh).b)
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(5), m["RTT"])
+	assert.Equal(t, "10.0.0.1", m["DAddr"])
+	assert.Equal(t, int64(1609564925), m["Timestamp"])
+	assert.Contains(t, m, "Hostname")
+}
+
+func TestWorkerCBOR(t *testing.T) {
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	k := kafka{
+		dCh:     make(chan *bytes.Buffer, 1),
+		bCh:     make(chan event, 1),
+		bufpool: bufPool,
+	}
+	k.hostname()
+
+	cfg := config.Config{}
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	go k.workerEncode(ctx, serialization.JSONToCBOR)
+	k.dCh <- bytes.NewBufferString(`{"RTT":5,"DAddr":"10.0.0.1","Rate":0.5,"Timestamp":1609564925}`)
+
+	m, err := serialization.UnmarshalCBOR((<-k.bCh).b)
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(5), m["RTT"])
+	assert.Equal(t, 0.5, m["Rate"])
+	assert.Equal(t, "10.0.0.1", m["DAddr"])
+	assert.Equal(t, int64(1609564925), m["Timestamp"])
+	assert.Contains(t, m, "Hostname")
+}
+
+func TestOrderedLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.Config{}
+	ctx = cfg.WithContext(ctx)
+
+	sCfg := sarama.NewConfig()
+	sCfg.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, sCfg)
+
+	events := []string{
+		`{"NewState":1,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":1}`,
+		`{"TotalRetrans":1,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":2}`,
+		`{"NewState":1,"SAddr":"10.0.0.1","DAddr":"10.0.0.3","DPort":443,"LPort":5001,"Timestamp":3}`,
+		`{"TotalRetrans":2,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":4}`,
+		`{"NewState":7,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":5}`,
+	}
+
+	for range events {
+		producer.ExpectInputAndSucceed()
+	}
+
+	k := kafka{
+		producer: producer,
+		dCh:      make(chan *bytes.Buffer, len(events)),
+		bufpool:  &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}
+	k.hostname()
+
+	k.orderedLoop(ctx, &Config{Topic: "tcpdog", Workers: 4}, nil)
+
+	for _, e := range events {
+		k.dCh <- bytes.NewBufferString(e)
+	}
+
+	got := []string{}
+	for range events {
+		select {
+		case msg := <-producer.Successes():
+			key, _ := msg.Key.Encode()
+			if string(key) != "10.0.0.1|5000|10.0.0.2|443" {
+				continue
+			}
+			v, _ := msg.Value.Encode()
+			got = append(got, string(v))
+		case <-time.After(time.Second):
+			t.Fatal("time exceeded")
+		}
+	}
+
+	assert.Len(t, got, 4)
+	for i, ts := range []string{"1", "2", "4", "5"} {
+		assert.Contains(t, got[i], `"Timestamp":`+ts)
+	}
+
+	// the producer is closed once the workers have been stopped
+	cancel()
+	k.close(ctx)
+}
+
+func TestOrderedDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.Config{}
+	ms := cfg.SetMockLogger("kafka-dead-letter")
+	ctx = cfg.WithContext(ctx)
+
+	sCfg := sarama.NewConfig()
+	sCfg.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, sCfg)
+
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrMessageSizeTooLarge)
+	producer.ExpectInputAndSucceed()
+
+	k := kafka{
+		name:     "dead-letter",
+		producer: producer,
+		dCh:      make(chan *bytes.Buffer, 2),
+		bufpool:  &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}
+	k.hostname()
+
+	k.orderedLoop(ctx, &Config{Topic: "tcpdog", DeadLetterTopic: "tcpdog-dead", Workers: 1}, nil)
+
+	k.dCh <- bytes.NewBufferString(`{"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":1}`)
+	k.dCh <- bytes.NewBufferString(`{"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":2}`)
+
+	// the failed message keeps its key on the dead letter topic
+	for _, e := range []struct{ topic, ts string }{{"tcpdog", "1"}, {"tcpdog-dead", "2"}} {
+		select {
+		case msg := <-producer.Successes():
+			key, _ := msg.Key.Encode()
+			v, _ := msg.Value.Encode()
+			assert.Equal(t, e.topic, msg.Topic)
+			assert.Equal(t, "10.0.0.1|5000|10.0.0.2|443", string(key))
+			assert.Contains(t, string(v), `"Timestamp":`+e.ts)
+		case <-time.After(time.Second):
+			t.Fatal("time exceeded")
+		}
+	}
+
+	// the error handler has been finished once it's closed
+	cancel()
+	k.close(ctx)
+
+	assert.Contains(t, ms.String(), "message has been dead-lettered")
+	assert.Equal(t, uint64(0), drops.Count(drops.EgressPublish, "dead-letter"))
+}
+
+func TestOrderedSpill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.Config{}
+	cfg.SetMockLogger("kafka-spill")
+
+	s, err := spool.Open("spill", &config.Spool{Path: t.TempDir()})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	ctx = spool.WithContext(cfg.WithContext(ctx), s)
+
+	sCfg := sarama.NewConfig()
+	sCfg.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, sCfg)
+
+	producer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+
+	k := kafka{
+		name:     "spill",
+		producer: producer,
+		dCh:      make(chan *bytes.Buffer, 1),
+		bufpool:  &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+		spool:    s,
+	}
+	k.hostname()
+
+	// the spool takes precedence over the dead letter topic
+	k.orderedLoop(ctx, &Config{Topic: "tcpdog", DeadLetterTopic: "tcpdog-dead", Workers: 1}, nil)
+
+	k.dCh <- bytes.NewBufferString(`{"SAddr":"10.0.0.1","DAddr":"10.0.0.2","DPort":443,"LPort":5000,"Timestamp":1}`)
+
+	assert.Eventually(t, func() bool { return s.Len() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(0), drops.Count(drops.EgressPublish, "spill"))
+
+	cancel()
+	k.close(ctx)
+}
+
+func TestErrored(t *testing.T) {
+	cfg := config.Config{}
+	cfg.SetMockLogger("kafka-errored")
+
+	s, err := spool.Open("errored", &config.Spool{Path: t.TempDir()})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	k := kafka{name: "errored", spool: s}
+	raw := k.keep(bytes.NewBufferString(`{"RTT":5}`))
+
+	// the failed message is spooled with its encoded event
+	m, _ := k.message("tcpdog", nil, []byte("foo"), raw)
+	k.errored(cfg.Logger(), &sarama.ProducerError{Msg: m, Err: sarama.ErrOutOfBrokers})
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, uint64(0), drops.Count(drops.EgressPublish, "errored"))
+
+	// it's dropped without the encoded event
+	m, _ = k.message("tcpdog", nil, []byte("foo"), nil)
+	k.errored(cfg.Logger(), &sarama.ProducerError{Msg: m, Err: sarama.ErrOutOfBrokers})
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, uint64(1), drops.Count(drops.EgressPublish, "errored"))
+
+	// the raw event isn't kept without a spool
+	assert.Nil(t, (&kafka{}).keep(bytes.NewBufferString(`{"RTT":5}`)))
+}
+
+func TestFailed(t *testing.T) {
+	cfg := config.Config{}
+	ms := cfg.SetMockLogger("kafka-failed")
+
+	k := kafka{name: "drop"}
+	k.failed(cfg.Logger(), sarama.ErrOutOfBrokers)
+
+	assert.Equal(t, uint64(1), drops.Count(drops.EgressPublish, "drop"))
+	assert.Contains(t, ms.String(), "kafka: client has run out of available brokers")
+}
+
+func TestMessageTrace(t *testing.T) {
+	k := kafka{}
+
+	m, tr := k.message("tcpdog", nil, []byte("foo"), nil)
+	assert.Nil(t, tr)
+	assert.Nil(t, m.Headers)
+	assert.Nil(t, m.Key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := tracing.Start(ctx, config.Tracing{Endpoint: "http://127.0.0.1:4318/v1/traces", SampleRate: 1}, "tcpdog-agent", zap.NewNop())
+	assert.NoError(t, err)
+
+	m, tr = k.message("tcpdog", sarama.ByteEncoder("key"), []byte("foo"), nil)
+	assert.NotNil(t, tr)
+	assert.Equal(t, []sarama.RecordHeader{{Key: []byte("traceparent"), Value: []byte(tr.Traceparent())}}, m.Headers)
+	assert.Equal(t, sarama.ByteEncoder("key"), m.Key)
+}
+
+func TestWorkerLeak(t *testing.T) {
+	k := kafka{
+		dCh:     make(chan *bytes.Buffer, 1),
+		bCh:     make(chan event),
+		bufpool: &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}
+
+	cfg := config.Config{}
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	// the worker is blocked on the producer loop channel which isn't read
+	go k.workerPB(ctx, []config.Field{{Name: "RTT"}})
+	k.dCh <- bytes.NewBufferString(`{"RTT":5,"Timestamp":1609564925}`)
+
+	// the goroutines are checked by the TestMain
+	assert.Eventually(t, func() bool { return len(k.dCh) == 0 }, time.Second, time.Millisecond)
+	cancel()
+}
+
+func TestSaramaConfigSASL(t *testing.T) {
+	// deprecated username and password
+	kCfg, err := kafkaConfig(map[string]interface{}{
+		"saslUsername": "tcpdog",
+		"saslPassword": "secret",
+	})
+	assert.NoError(t, err)
+
+	sConfig, err := saramaConfig(kCfg)
+	assert.NoError(t, err)
+	assert.True(t, sConfig.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), sConfig.Net.SASL.Mechanism)
+	assert.Equal(t, "tcpdog", sConfig.Net.SASL.User)
+	assert.Equal(t, "secret", sConfig.Net.SASL.Password)
+
+	kCfg, err = kafkaConfig(map[string]interface{}{
+		"version": "2.0.0",
+		"sasl": map[string]interface{}{
+			"enable":    true,
+			"mechanism": "SCRAM-SHA-512",
+			"username":  "tcpdog",
+			"password":  "secret",
+		},
+	})
+	assert.NoError(t, err)
+
+	sConfig, err = saramaConfig(kCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), sConfig.Net.SASL.Mechanism)
+	assert.NotNil(t, sConfig.Net.SASL.SCRAMClientGeneratorFunc)
+	assert.NoError(t, sConfig.Validate())
+
+	// unknown mechanism
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"myegress": {
+				Type: "kafka",
+				Config: map[string]interface{}{
+					"sasl": map[string]interface{}{
+						"enable":    true,
+						"mechanism": "GSSAPI",
+					},
+				},
+			},
+		},
+	}
+
+	ctx := cfg.WithContext(context.Background())
+	err = Start(ctx, config.Tracepoint{Egress: "myegress"}, nil, nil)
+	assert.EqualError(t, err, "kafka sasl mechanism GSSAPI is not supported")
+}
+
+func TestStartConfigError(t *testing.T) {
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"myegress": {Type: "kafka", Config: map[string]interface{}{"workers": "two"}},
+		},
+	}
+
+	// the wrong configuration is returned instead of exiting
+	err := Start(cfg.WithContext(context.Background()), config.Tracepoint{Egress: "myegress"}, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package nats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Config represents NATS configuration
+type Config struct {
+	Servers       []string
+	Subject       string
+	Serialization string
+	Workers       int
+
+	// MaxPending is the bounded buffer of the messages which wait for
+	// the publish, the events are dropped if it's full. ReconnectBufSize
+	// is the client buffer in bytes while it's reconnecting.
+	MaxPending       int
+	ReconnectBufSize int
+
+	// ReconnectWait is the first reconnect backoff in milliseconds, it's
+	// doubled per attempt up to the MaxReconnectWait in milliseconds.
+	ReconnectWait    int
+	MaxReconnectWait int
+
+	// Stream publishes the messages to the JetStream stream, each
+	// message waits for its ack up to the AckWait in milliseconds.
+	Stream  string
+	AckWait int
+
+	// CredsFile is the user credentials (JWT and NKey seed) file
+	CredsFile string
+
+	TLSConfig config.TLSConfig
+}
+
+func natsConfig(cfg map[string]interface{}) (*Config, error) {
+	// default configuration
+	c := &Config{
+		Servers:          []string{nats.DefaultURL},
+		Subject:          "tcpdog",
+		Serialization:    "json",
+		Workers:          2,
+		MaxPending:       10000,
+		ReconnectBufSize: nats.DefaultReconnectBufSize,
+		ReconnectWait:    500,
+		MaxReconnectWait: 30000,
+		AckWait:          5000,
+	}
+
+	if err := config.Transform(cfg, c); err != nil {
+		return nil, err
+	}
+
+	switch c.Serialization {
+	case "json", "pb", "spb", "msgpack", "cbor":
+	default:
+		return nil, fmt.Errorf("nats doesn't support %s serialization", c.Serialization)
+	}
+
+	if c.Subject == "" || strings.ContainsAny(c.Subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid nats subject: %q", c.Subject)
+	}
+
+	if c.Workers < 1 || c.MaxPending < 1 {
+		return nil, fmt.Errorf("invalid nats workers or maxPending")
+	}
+
+	if c.Stream != "" && (strings.ContainsAny(c.Stream, ". *>\t\r\n") || c.AckWait < 1) {
+		return nil, fmt.Errorf("invalid nats stream or ackWait: %q, %d", c.Stream, c.AckWait)
+	}
+
+	return c, nil
+}
+
+// options returns the client options, the client reconnects forever
+// with the backoff and the connection events are logged.
+func (c *Config) options(name string, logger *zap.Logger) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name("tcpdog-" + name),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.ReconnectBufSize(c.ReconnectBufSize),
+		nats.CustomReconnectDelay(c.backoff),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warn("nats", zap.String("egress", name), zap.Error(err))
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Info("nats", zap.String("msg", fmt.Sprintf("%s has been reconnected to %s", name, nc.ConnectedUrl())))
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			logger.Error("nats", zap.String("egress", name), zap.Error(err))
+		}),
+	}
+
+	if c.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(c.CredsFile))
+	}
+
+	if c.TLSConfig.Enable {
+		tlsConfig, err := config.GetTLS(&c.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	return opts, nil
+}
+
+// backoff returns the reconnect delay of the attempt
+func (c *Config) backoff(attempts int) time.Duration {
+	d := time.Duration(c.ReconnectWait) * time.Millisecond
+	max := time.Duration(c.MaxReconnectWait) * time.Millisecond
+
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d
+}
@@ -0,0 +1,234 @@
+// Package nats publishes the events to a NATS subject, they're
+// published to the JetStream stream if it's configured.
+package nats
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/priority"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+const statsInterval = time.Minute
+
+type natsEgress struct {
+	name     string
+	subject  string
+	conn     *nats.Conn
+	send     func(b []byte) error
+	bufpool  *sync.Pool
+	dCh      chan *bytes.Buffer
+	pending  chan []byte
+	lane     *priority.Lane
+	inflight *helper.Inflight
+	hostname string
+	jsonTail []byte
+	logger   *zap.Logger
+	dropped  uint64
+}
+
+// Start starts publishing the requested fields to the NATS subject,
+// the client connects in the background and reconnects forever.
+func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+	cfg := config.FromContext(ctx)
+
+	nCfg, err := natsConfig(cfg.Egress[tp.Egress].Config)
+	if err != nil {
+		return err
+	}
+
+	opts, err := nCfg.options(tp.Egress, cfg.Logger())
+	if err != nil {
+		return err
+	}
+
+	conn, err := nats.Connect(strings.Join(nCfg.Servers, ","), opts...)
+	if err != nil {
+		return err
+	}
+
+	n := &natsEgress{
+		name:     tp.Egress,
+		subject:  nCfg.Subject,
+		conn:     conn,
+		bufpool:  bufpool,
+		dCh:      ch,
+		pending:  make(chan []byte, nCfg.MaxPending),
+		lane:     priority.FromContext(ctx),
+		inflight: helper.InflightFrom(ctx),
+		logger:   cfg.Logger(),
+	}
+
+	n.send = func(b []byte) error {
+		return conn.Publish(n.subject, b)
+	}
+
+	if nCfg.Stream != "" {
+		n.send, err = jetStreamSender(conn, nCfg)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	n.hostname, _ = os.Hostname()
+	n.jsonTail = []byte(fmt.Sprintf("\"Hostname\":\"%s\"}", n.hostname))
+
+	for i := 0; i < nCfg.Workers; i++ {
+		switch nCfg.Serialization {
+		case "spb":
+			go n.worker(ctx, n.marshalSPB(cfg.Fields[tp.Fields]))
+		case "pb":
+			go n.worker(ctx, n.marshalPB)
+		case "msgpack":
+			go n.worker(ctx, n.marshalMsgpack)
+		case "cbor":
+			go n.worker(ctx, n.marshalCBOR)
+		default:
+			go n.worker(ctx, n.marshalJSON)
+		}
+	}
+
+	go n.publish(ctx)
+
+	return nil
+}
+
+// worker marshals the events to the pending buffer, the
+// events are dropped if the buffer is full.
+func (n *natsEgress) worker(ctx context.Context, marshal func(*bytes.Buffer) ([]byte, error)) {
+	for {
+		buf, c, ok := n.lane.RecvBuffer(ctx, n.dCh)
+		if !ok {
+			return
+		}
+		n.inflight.Recv(c)
+
+		b, err := marshal(buf)
+		n.bufpool.Put(buf)
+
+		if err != nil {
+			n.logger.Error("nats", zap.Error(err))
+			n.inflight.Add(-1)
+			continue
+		}
+
+		select {
+		case n.pending <- b:
+		default:
+			n.drop()
+			n.inflight.Add(-1)
+		}
+	}
+}
+
+// publish publishes the pending messages, the client buffers them
+// and it drains the connection once the context is canceled.
+func (n *natsEgress) publish(ctx context.Context) {
+	var dropped uint64
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case b := <-n.pending:
+			if err := n.send(b); err != nil {
+				n.drop()
+			}
+			n.inflight.Add(-1)
+		case <-ticker.C:
+			if d := atomic.LoadUint64(&n.dropped); d != dropped {
+				n.logger.Warn("nats", zap.String("egress", n.name),
+					zap.String("msg", "events have been dropped"), zap.Uint64("events", d-dropped))
+				dropped = d
+			}
+		case <-ctx.Done():
+			if err := n.conn.Drain(); err != nil {
+				n.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// jetStreamSender returns the publisher of the stream, a message is
+// dropped if the stream hasn't acked it in the ack wait.
+func jetStreamSender(conn *nats.Conn, nCfg *Config) (func(b []byte) error, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("nats jetstream: %w", err)
+	}
+
+	opts := []nats.PubOpt{
+		nats.ExpectStream(nCfg.Stream),
+		nats.AckWait(time.Duration(nCfg.AckWait) * time.Millisecond),
+	}
+
+	return func(b []byte) error {
+		_, err := js.Publish(nCfg.Subject, b, opts...)
+		return err
+	}, nil
+}
+
+func (n *natsEgress) drop() {
+	atomic.AddUint64(&n.dropped, 1)
+	drops.Add(drops.EgressPublish, n.name, 1)
+}
+
+// marshalJSON adds the hostname to the encoded json
+func (n *natsEgress) marshalJSON(buf *bytes.Buffer) ([]byte, error) {
+	b := make([]byte, 0, buf.Len()+len(n.jsonTail))
+	b = append(b, buf.Bytes()...)
+	b[len(b)-1] = ','
+
+	return append(b, n.jsonTail...), nil
+}
+
+func (n *natsEgress) marshalSPB(fields []config.Field) func(*bytes.Buffer) ([]byte, error) {
+	spb := helper.NewStructPB(fields)
+
+	return func(buf *bytes.Buffer) ([]byte, error) {
+		fields, err := spb.Unmarshal(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return serialization.Marshal(&pb.FieldsSPB{Fields: fields})
+	}
+}
+
+// marshalMsgpack encodes the json with the hostname by msgpack
+func (n *natsEgress) marshalMsgpack(buf *bytes.Buffer) ([]byte, error) {
+	b, _ := n.marshalJSON(buf)
+	return serialization.JSONToMsgpack(b)
+}
+
+// marshalCBOR encodes the json with the hostname by cbor
+func (n *natsEgress) marshalCBOR(buf *bytes.Buffer) ([]byte, error) {
+	b, _ := n.marshalJSON(buf)
+	return serialization.JSONToCBOR(b)
+}
+
+func (n *natsEgress) marshalPB(buf *bytes.Buffer) ([]byte, error) {
+	m := pb.Fields{}
+	protojson.Unmarshal(buf.Bytes(), &m)
+	m.Hostname = &n.hostname
+
+	return serialization.Marshal(&m)
+}
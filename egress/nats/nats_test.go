@@ -0,0 +1,330 @@
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/priority"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+type message struct {
+	subject string
+	data    []byte
+}
+
+// natsServer is a minimal NATS server which hands the published messages to the channel
+func natsServer(t *testing.T) (string, chan message) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan message, 10)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(c net.Conn) {
+				defer c.Close()
+
+				fmt.Fprint(c, "INFO {\"server_id\":\"test\",\"version\":\"2.2.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(c)
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					switch {
+					case strings.HasPrefix(line, "PING"):
+						fmt.Fprint(c, "PONG\r\n")
+					case strings.HasPrefix(line, "PUB "):
+						args := strings.Fields(line)
+						size, _ := strconv.Atoi(args[len(args)-1])
+						data := make([]byte, size+2)
+						if _, err := io.ReadFull(r, data); err != nil {
+							return
+						}
+						ch <- message{args[1], data[:size]}
+					}
+				}
+			}(c)
+		}
+	}()
+
+	return "nats://" + l.Addr().String(), ch
+}
+
+func TestStart(t *testing.T) {
+	url, msgCh := natsServer(t)
+
+	bufPool := &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	for _, ser := range []string{"json", "spb", "pb"} {
+		tp := config.Tracepoint{Egress: "nats01", Fields: "fields01"}
+		cfg := config.Config{
+			Fields: map[string][]config.Field{
+				"fields01": {{Name: "RTT"}, {Name: "DAddr"}},
+			},
+			Egress: map[string]config.EgressConfig{
+				"nats01": {
+					Type: "nats",
+					Config: map[string]interface{}{
+						"servers":       []string{url},
+						"subject":       "tcpdog." + ser,
+						"serialization": ser,
+					},
+				},
+			},
+		}
+		cfg.SetMockLogger("nats")
+
+		ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+		ch := make(chan *bytes.Buffer, 1)
+		ch <- bytes.NewBufferString(`{"RTT":5,"DAddr":"10.0.0.1","Timestamp":1609564925}`)
+
+		err := Start(ctx, tp, bufPool, ch)
+		assert.NoError(t, err)
+
+		var m message
+		select {
+		case m = <-msgCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("message has not been published", ser)
+		}
+
+		assert.Equal(t, "tcpdog."+ser, m.subject)
+
+		switch ser {
+		case "json":
+			assert.Contains(t, string(m.data), `"RTT":5,"DAddr":"10.0.0.1","Timestamp":1609564925,"Hostname":`)
+		case "spb":
+			f := &pb.FieldsSPB{}
+			assert.NoError(t, proto.Unmarshal(m.data, f))
+			assert.Equal(t, 5.0, f.Fields.Fields["RTT"].GetNumberValue())
+			assert.Equal(t, "10.0.0.1", f.Fields.Fields["DAddr"].GetStringValue())
+		case "pb":
+			f := &pb.Fields{}
+			assert.NoError(t, proto.Unmarshal(m.data, f))
+			assert.Equal(t, uint32(5), f.GetRTT())
+			assert.NotEmpty(t, f.GetHostname())
+		}
+
+		cancel()
+	}
+}
+
+func TestWorkerDrop(t *testing.T) {
+	cfg := config.Config{}
+	cfg.SetMockLogger("nats-drop")
+
+	n := &natsEgress{
+		name:    "drop",
+		bufpool: &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+		dCh:     make(chan *bytes.Buffer, 1),
+		pending: make(chan []byte, 1),
+		lane:    priority.FromContext(context.Background()),
+		logger:  cfg.Logger(),
+	}
+
+	// the pending buffer is full
+	n.pending <- []byte("{}")
+	n.dCh <- bytes.NewBufferString(`{"RTT":5}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go n.worker(ctx, n.marshalJSON)
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.EgressPublish, "drop") == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConfig(t *testing.T) {
+	c, err := natsConfig(map[string]interface{}{"servers": []string{"nats://10.0.0.1:4222"}, "serialization": "spb"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nats://10.0.0.1:4222"}, c.Servers)
+	assert.Equal(t, "tcpdog", c.Subject)
+	assert.Equal(t, 10000, c.MaxPending)
+
+	_, err = natsConfig(map[string]interface{}{"serialization": "csv"})
+	assert.EqualError(t, err, "nats doesn't support csv serialization")
+
+	_, err = natsConfig(map[string]interface{}{"subject": "tcp dog"})
+	assert.EqualError(t, err, `invalid nats subject: "tcp dog"`)
+
+	// the backoff is doubled up to the max
+	c.ReconnectWait, c.MaxReconnectWait = 500, 3000
+	assert.Equal(t, 500*time.Millisecond, c.backoff(1))
+	assert.Equal(t, time.Second, c.backoff(2))
+	assert.Equal(t, 2*time.Second, c.backoff(3))
+	assert.Equal(t, 3*time.Second, c.backoff(10))
+
+	c, err = natsConfig(map[string]interface{}{"stream": "TCPDOG", "credsFile": "/etc/tcpdog/nats.creds"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, c.AckWait)
+
+	opts, err := c.options("nats01", zap.NewNop())
+	assert.NoError(t, err)
+	assert.Len(t, opts, 9)
+
+	_, err = natsConfig(map[string]interface{}{"stream": "TCPDOG", "ackWait": 0})
+	assert.EqualError(t, err, `invalid nats stream or ackWait: "TCPDOG", 0`)
+}
+
+// jetStreamServer is a minimal NATS server with a JetStream stream, it
+// acks the messages which are published to the stream and it hands them
+// with their headers to the channel.
+func jetStreamServer(t *testing.T, stream string) (string, chan message) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan message, 10)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(c net.Conn) {
+				defer c.Close()
+
+				var (
+					muxSID string
+					seq    int
+				)
+
+				reply := func(subject, resp string) {
+					fmt.Fprintf(c, "MSG %s %s %d\r\n%s\r\n", subject, muxSID, len(resp), resp)
+				}
+
+				fmt.Fprint(c, "INFO {\"server_id\":\"test\",\"version\":\"2.2.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(c)
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					args := strings.Fields(line)
+
+					switch {
+					case strings.HasPrefix(line, "PING"):
+						fmt.Fprint(c, "PONG\r\n")
+					case strings.HasPrefix(line, "SUB _INBOX."):
+						muxSID = args[len(args)-1]
+					case strings.HasPrefix(line, "PUB $JS.API.INFO "):
+						io.ReadFull(r, make([]byte, 2))
+						reply(args[2], `{"type":"io.nats.jetstream.api.v1.account_info_response","streams":1}`)
+					case strings.HasPrefix(line, "HPUB "):
+						// HPUB <subject> <reply> <header size> <total size>
+						hsize, _ := strconv.Atoi(args[3])
+						size, _ := strconv.Atoi(args[4])
+						data := make([]byte, size+2)
+						if _, err := io.ReadFull(r, data); err != nil {
+							return
+						}
+
+						header := string(data[:hsize])
+						ch <- message{args[1], data[hsize:size]}
+
+						if strings.Contains(header, "Nats-Expected-Stream: "+stream+"\r\n") {
+							seq++
+							reply(args[2], fmt.Sprintf(`{"stream":%q,"seq":%d}`, stream, seq))
+						}
+					}
+				}
+			}(c)
+		}
+	}()
+
+	return "nats://" + l.Addr().String(), ch
+}
+
+func TestStartJetStream(t *testing.T) {
+	url, msgCh := jetStreamServer(t, "TCPDOG")
+
+	bufPool := &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+	for _, stream := range []string{"TCPDOG", "OTHER"} {
+		egress := "nats-js-" + stream
+		tp := config.Tracepoint{Egress: egress, Fields: "fields01"}
+		cfg := config.Config{
+			Egress: map[string]config.EgressConfig{
+				egress: {
+					Type: "nats",
+					Config: map[string]interface{}{
+						"servers": []string{url},
+						"subject": "tcpdog.events",
+						"stream":  stream,
+						"ackWait": 200,
+					},
+				},
+			},
+		}
+		cfg.SetMockLogger("nats-js")
+
+		ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+		ch := make(chan *bytes.Buffer, 1)
+		ch <- bytes.NewBufferString(`{"RTT":5,"Timestamp":1609564925}`)
+
+		assert.NoError(t, Start(ctx, tp, bufPool, ch))
+
+		var m message
+		select {
+		case m = <-msgCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("message has not been published", stream)
+		}
+
+		assert.Equal(t, "tcpdog.events", m.subject)
+		assert.Contains(t, string(m.data), `"RTT":5,"Timestamp":1609564925,"Hostname":`)
+
+		// the message is dropped if it's not acked in the ack wait
+		if stream == "OTHER" {
+			assert.Eventually(t, func() bool {
+				return drops.Count(drops.EgressPublish, egress) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+		} else {
+			time.Sleep(300 * time.Millisecond)
+			assert.Equal(t, uint64(0), drops.Count(drops.EgressPublish, egress))
+		}
+
+		cancel()
+	}
+}
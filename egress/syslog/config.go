@@ -0,0 +1,99 @@
+package syslog
+
+import (
+	"fmt"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Config represents syslog configuration
+type Config struct {
+	// Network can be udp, tcp or unix (datagram), the tcp
+	// messages are framed by octet counting (RFC6587).
+	Network  string
+	Addr     string
+	Facility string
+	Severity string
+	AppName  string
+	// StructuredData sends the fields as the RFC5424 structured
+	// data element SDID instead of the json message body.
+	StructuredData bool
+	SDID           string
+
+	TLSConfig config.TLSConfig
+}
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+var severities = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"warning": 4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+func syslogConfig(cfg map[string]interface{}) (*Config, error) {
+	// default config
+	c := &Config{
+		Network:  "udp",
+		Addr:     "localhost:514",
+		Facility: "local0",
+		Severity: "info",
+		AppName:  "tcpdog",
+		SDID:     "tcpdog@32473",
+	}
+
+	if err := config.Transform(cfg, c); err != nil {
+		return nil, err
+	}
+
+	if _, ok := facilities[c.Facility]; !ok {
+		return nil, fmt.Errorf("invalid syslog facility: %s", c.Facility)
+	}
+
+	if _, ok := severities[c.Severity]; !ok {
+		return nil, fmt.Errorf("invalid syslog severity: %s", c.Severity)
+	}
+
+	switch c.Network {
+	case "udp", "tcp", "unix":
+	default:
+		return nil, fmt.Errorf("invalid syslog network: %s", c.Network)
+	}
+
+	if c.TLSConfig.Enable && c.Network != "tcp" {
+		return nil, fmt.Errorf("syslog TLS requires tcp network")
+	}
+
+	return c, nil
+}
+
+// priority returns the PRI part of the message
+func (c *Config) priority() int {
+	return facilities[c.Facility]*8 + severities[c.Severity]
+}
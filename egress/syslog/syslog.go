@@ -0,0 +1,240 @@
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+)
+
+const rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+
+type syslog struct {
+	cfg       *Config
+	tlsConfig *tls.Config
+	order     *helper.FieldOrder
+	names     []string
+	header    []byte
+	values    [][]byte
+	msg       []byte
+	now       func() time.Time
+}
+
+func newSyslog(cfg *Config, fields []config.Field, msgID string) (*syslog, error) {
+	var err error
+
+	s := &syslog{
+		cfg:   cfg,
+		order: helper.NewFieldOrder(fields),
+		now:   time.Now,
+	}
+
+	for _, f := range fields {
+		s.names = append(s.names, f.Name)
+	}
+	s.names = append(s.names, "Timestamp")
+
+	if cfg.TLSConfig.Enable {
+		s.tlsConfig, err = config.GetTLS(&cfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	// the time is the only variable part of the header:
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	s.header = []byte(fmt.Sprintf(" %s %s %d %s ", nilValue(hostname, 255), nilValue(cfg.AppName, 48),
+		os.Getpid(), nilValue(msgID, 32)))
+
+	return s, nil
+}
+
+// format returns the event as an RFC5424 message
+func (s *syslog) format(b []byte) []byte {
+	s.msg = append(s.msg[:0], '<')
+	s.msg = strconv.AppendInt(s.msg, int64(s.cfg.priority()), 10)
+	s.msg = append(s.msg, ">1 "...)
+	s.msg = s.now().UTC().AppendFormat(s.msg, rfc5424Time)
+	s.msg = append(s.msg, s.header...)
+
+	if !s.cfg.StructuredData {
+		s.msg = append(s.msg, "- "...)
+		s.msg = s.order.AppendJSON(s.msg, b)
+		return s.msg
+	}
+
+	s.msg = append(s.msg, '[')
+	s.msg = append(s.msg, s.cfg.SDID...)
+
+	s.values = s.order.Values(s.values[:0], b)
+	for i, v := range s.values {
+		if v == nil {
+			continue
+		}
+
+		s.msg = append(s.msg, ' ')
+		s.msg = append(s.msg, s.names[i]...)
+		s.msg = append(s.msg, '=', '"')
+		s.msg = appendParamValue(s.msg, unquote(v))
+		s.msg = append(s.msg, '"')
+	}
+
+	s.msg = append(s.msg, ']')
+
+	return s.msg
+}
+
+func (s *syslog) dial() (net.Conn, error) {
+	switch {
+	case s.tlsConfig != nil:
+		return tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", s.cfg.Addr, s.tlsConfig)
+	case s.cfg.Network == "unix":
+		return net.DialTimeout("unixgram", s.cfg.Addr, 5*time.Second)
+	}
+
+	return net.DialTimeout(s.cfg.Network, s.cfg.Addr, 5*time.Second)
+}
+
+// write sends a message, the stream messages are prefixed
+// by their length (octet counting).
+func (s *syslog) write(conn net.Conn, msg []byte) error {
+	if s.cfg.Network == "tcp" {
+		frame := make([]byte, 0, len(msg)+8)
+		frame = strconv.AppendInt(frame, int64(len(msg)), 10)
+		frame = append(frame, ' ')
+		msg = append(frame, msg...)
+	}
+
+	_, err := conn.Write(msg)
+
+	return err
+}
+
+func (s *syslog) send(ctx context.Context, conn net.Conn, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+	inflight := helper.InflightFrom(ctx)
+
+	for {
+		select {
+		case buf := <-ch:
+			err := s.write(conn, s.format(buf.Bytes()))
+			bufpool.Put(buf)
+			inflight.Add(-1)
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Start sends the events to a syslog server as RFC5424 messages,
+// it reconnects with backoff once the connection fails.
+func Start(ctx context.Context, tp config.Tracepoint, bufpool *sync.Pool, ch chan *bytes.Buffer) error {
+	cfg := config.FromContext(ctx)
+	logger := cfg.Logger()
+
+	sCfg, err := syslogConfig(cfg.Egress[tp.Egress].Config)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSyslog(sCfg, cfg.Fields[tp.Fields], tp.Name)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		backoff := helper.NewBackoff(logger)
+		for {
+			if !backoff.Wait(ctx) {
+				return
+			}
+
+			conn, err := s.dial()
+			if err != nil {
+				logger.Warn("syslog", zap.Error(err))
+				continue
+			}
+
+			logger.Info("syslog", zap.String("msg",
+				fmt.Sprintf("%s has been connected to %s", tp.Egress, sCfg.Addr)))
+
+			err = s.send(ctx, conn, bufpool, ch)
+			conn.Close()
+			if err != nil {
+				logger.Warn("syslog", zap.Error(err))
+				continue
+			}
+
+			return
+		}
+	}()
+
+	return nil
+}
+
+// nilValue returns the RFC5424 nil value for an empty header field
+// and truncates it to its max length.
+func nilValue(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, v)
+
+	if v == "" {
+		return "-"
+	}
+
+	if len(v) > max {
+		return v[:max]
+	}
+
+	return v
+}
+
+// unquote returns the json string value as it's or the
+// value itself if it's not a string
+func unquote(v []byte) []byte {
+	if len(v) < 2 || v[0] != '"' {
+		return v
+	}
+
+	if bytes.IndexByte(v, '\\') < 0 {
+		return v[1 : len(v)-1]
+	}
+
+	u, err := strconv.Unquote(string(v))
+	if err != nil {
+		return bytes.Trim(v, `"`)
+	}
+
+	return []byte(u)
+}
+
+// appendParamValue escapes '"', '\' and ']' in a param value
+func appendParamValue(dst, v []byte) []byte {
+	for _, c := range v {
+		if c == '"' || c == '\\' || c == ']' {
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, c)
+	}
+
+	return dst
+}
@@ -0,0 +1,164 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+var rfc5424 = regexp.MustCompile(`^<(\d{1,3})>1 (\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z) (\S+) (\S+) (\d+) (\S+) (-|\[.*\])(?: (.*))?$`)
+
+const event = `{"RTT":12345,"Task":"cu\"r]l","DAddr":"10.0.0.2","Timestamp":1611634115}`
+
+func testConfig(egress map[string]interface{}) (config.Config, config.Tracepoint) {
+	cfg := config.Config{
+		Egress: map[string]config.EgressConfig{
+			"syslog": {Type: "syslog", Config: egress},
+		},
+		Fields: map[string][]config.Field{
+			"fields": {{Name: "RTT"}, {Name: "Task"}, {Name: "DAddr"}},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	return cfg, config.Tracepoint{Name: "tcp:tcp_retransmit_skb", Egress: "syslog", Fields: "fields"}
+}
+
+func testBufPool() *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+}
+
+func TestStartUDP(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	cfg, tp := testConfig(map[string]interface{}{
+		"addr":     l.LocalAddr().String(),
+		"facility": "local3",
+		"severity": "notice",
+	})
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan *bytes.Buffer, 1)
+	err = Start(ctx, tp, testBufPool(), ch)
+	assert.NoError(t, err)
+
+	ch <- bytes.NewBufferString(event)
+
+	b := make([]byte, 1024)
+	l.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := l.ReadFrom(b)
+	assert.NoError(t, err)
+
+	m := rfc5424.FindStringSubmatch(string(b[:n]))
+	assert.Len(t, m, 9)
+
+	hostname, _ := os.Hostname()
+
+	assert.Equal(t, "157", m[1]) // local3 * 8 + notice
+	assert.Equal(t, hostname, m[3])
+	assert.Equal(t, "tcpdog", m[4])
+	assert.Equal(t, strconv.Itoa(os.Getpid()), m[5])
+	assert.Equal(t, "tcp:tcp_retransmit_skb", m[6])
+	assert.Equal(t, "-", m[7])
+	assert.Equal(t, event, m[8])
+}
+
+func TestStartTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	cfg, tp := testConfig(map[string]interface{}{
+		"network":        "tcp",
+		"addr":           l.Addr().String(),
+		"appName":        "foo",
+		"structuredData": true,
+	})
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan *bytes.Buffer, 2)
+	err = Start(ctx, tp, testBufPool(), ch)
+	assert.NoError(t, err)
+
+	ch <- bytes.NewBufferString(event)
+	ch <- bytes.NewBufferString(`{"RTT":5,"Timestamp":1611634116}`)
+
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(conn)
+
+	expected := []string{
+		`[tcpdog@32473 RTT="12345" Task="cu\"r\]l" DAddr="10.0.0.2" Timestamp="1611634115"]`,
+		`[tcpdog@32473 RTT="5" Timestamp="1611634116"]`,
+	}
+
+	for _, sd := range expected {
+		// octet counting framing
+		size, err := r.ReadString(' ')
+		assert.NoError(t, err)
+
+		n, err := strconv.Atoi(size[:len(size)-1])
+		assert.NoError(t, err)
+
+		msg := make([]byte, n)
+		_, err = io.ReadFull(r, msg)
+		assert.NoError(t, err)
+
+		m := rfc5424.FindStringSubmatch(string(msg))
+		assert.Len(t, m, 9)
+		assert.Equal(t, "134", m[1]) // local0 * 8 + info
+		assert.Equal(t, "foo", m[4])
+		assert.Equal(t, sd, m[7])
+		assert.Equal(t, "", m[8])
+	}
+}
+
+func TestSyslogConfig(t *testing.T) {
+	_, err := syslogConfig(map[string]interface{}{"facility": "foo"})
+	assert.EqualError(t, err, "invalid syslog facility: foo")
+
+	_, err = syslogConfig(map[string]interface{}{"severity": "foo"})
+	assert.EqualError(t, err, "invalid syslog severity: foo")
+
+	_, err = syslogConfig(map[string]interface{}{"network": "foo"})
+	assert.EqualError(t, err, "invalid syslog network: foo")
+
+	_, err = syslogConfig(map[string]interface{}{"tlsConfig": map[string]interface{}{"enable": true}})
+	assert.EqualError(t, err, "syslog TLS requires tcp network")
+
+	c, err := syslogConfig(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 134, c.priority())
+}
+
+func TestNilValue(t *testing.T) {
+	assert.Equal(t, "-", nilValue("", 48))
+	assert.Equal(t, "foobar", nilValue("foo bar", 48))
+	assert.Equal(t, "foo", nilValue("foobar", 3))
+}
@@ -0,0 +1,260 @@
+// Package fault injects the delays, the errors or the connection resets
+// at the named points of the pipeline to test the retries and the
+// failovers under the realistic failures. the injections are refused
+// unless the unsafeFaultInjection is set or the binary is built with the
+// faultinjection tag and they expire automatically. a check costs an
+// atomic load if there isn't any active injection.
+package fault
+
+import (
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// the injection points
+const (
+	// KafkaPublish is the kafka producer input of the egress and the ingestion
+	KafkaPublish = "kafka.publish"
+	// ESBulk is the elasticsearch bulk request
+	ESBulk = "elasticsearch.bulk"
+	// GRPCSend is the gRPC egress stream send
+	GRPCSend = "grpc.send"
+	// GeoLookup is the geo provider lookup
+	GeoLookup = "geo.lookup"
+)
+
+// the injection kinds
+const (
+	// Delay delays the point
+	Delay = "delay"
+	// Error fails the point
+	Error = "error"
+	// Reset fails the point by a connection reset
+	Reset = "reset"
+)
+
+const (
+	defaultDuration = time.Minute
+	maxDuration     = time.Hour
+	maxDelay        = time.Minute
+)
+
+// Points is the injection points
+var Points = []string{KafkaPublish, ESBulk, GRPCSend, GeoLookup}
+
+var (
+	// ErrInjected is the error of an error injection
+	ErrInjected = errors.New("injected fault")
+	// ErrReset is the error of a reset injection
+	ErrReset = fmt.Errorf("injected fault: %w", syscall.ECONNRESET)
+	// ErrDisabled is the error of an injection without the unsafe flag
+	ErrDisabled = errors.New("fault injection is disabled, unsafeFaultInjection isn't set")
+)
+
+var (
+	allowed    int32
+	active     int32
+	mu         sync.RWMutex
+	injections = map[string]*injection{}
+)
+
+type injection struct {
+	config.Fault
+	expires  time.Time
+	injected uint64
+}
+
+// State represents an active injection
+type State struct {
+	Point       string    `json:"point"`
+	Kind        string    `json:"kind"`
+	Probability float64   `json:"probability"`
+	Delay       string    `json:"delay,omitempty"`
+	Expires     time.Time `json:"expires"`
+	Injected    uint64    `json:"injected"`
+}
+
+// Allow allows the injections if the unsafe is true or the binary
+// has been built with the faultinjection tag, it returns the result.
+func Allow(unsafe bool) bool {
+	if unsafe || buildTag {
+		atomic.StoreInt32(&allowed, 1)
+		return true
+	}
+
+	atomic.StoreInt32(&allowed, 0)
+	Clear("")
+
+	return false
+}
+
+// Allowed returns true if the injections are allowed
+func Allowed() bool {
+	return atomic.LoadInt32(&allowed) == 1
+}
+
+// Validate validates the fault
+func Validate(f config.Fault) error {
+	if !contains(Points, f.Point) {
+		return fmt.Errorf("fault point %s is not supported", f.Point)
+	}
+
+	switch f.Kind {
+	case Delay:
+		if f.Delay <= 0 || f.Delay > maxDelay {
+			return fmt.Errorf("fault delay %s is out of range (0, %s]", f.Delay, maxDelay)
+		}
+	case Error, Reset:
+	default:
+		return fmt.Errorf("fault kind %s is not supported", f.Kind)
+	}
+
+	if f.Probability <= 0 || f.Probability > 1 {
+		return fmt.Errorf("fault probability %v is out of range (0, 1]", f.Probability)
+	}
+
+	if f.Duration < 0 || f.Duration > maxDuration {
+		return fmt.Errorf("fault duration %s is out of range [0, %s]", f.Duration, maxDuration)
+	}
+
+	return nil
+}
+
+// Inject activates the fault at its point, it replaces the active
+// injection of the point and expires after the duration (default 1m).
+func Inject(f config.Fault) error {
+	if !Allowed() {
+		return ErrDisabled
+	}
+
+	if err := Validate(f); err != nil {
+		return err
+	}
+
+	if f.Duration == 0 {
+		f.Duration = defaultDuration
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := injections[f.Point]; !ok {
+		atomic.AddInt32(&active, 1)
+	}
+	injections[f.Point] = &injection{Fault: f, expires: time.Now().Add(f.Duration)}
+
+	return nil
+}
+
+// Clear deactivates the injection of the point, all of them if it's empty
+func Clear(point string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for p := range injections {
+		if point == "" || p == point {
+			delete(injections, p)
+			atomic.AddInt32(&active, -1)
+		}
+	}
+}
+
+// Check injects the active fault of the point, a delay returns
+// nil after the delay and an error or a reset returns its error.
+func Check(point string) error {
+	if atomic.LoadInt32(&active) == 0 {
+		return nil
+	}
+
+	mu.RLock()
+	i, ok := injections[point]
+	mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	if time.Now().After(i.expires) {
+		expire(point, i)
+		return nil
+	}
+
+	if mrand.Float64() >= i.Probability {
+		return nil
+	}
+
+	atomic.AddUint64(&i.injected, 1)
+
+	switch i.Kind {
+	case Delay:
+		time.Sleep(i.Delay)
+	case Error:
+		return ErrInjected
+	case Reset:
+		return ErrReset
+	}
+
+	return nil
+}
+
+// expire removes the injection unless it has been replaced
+func expire(point string, i *injection) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if injections[point] == i {
+		delete(injections, point)
+		atomic.AddInt32(&active, -1)
+	}
+}
+
+// Status returns the active injections
+func Status() []State {
+	now := time.Now()
+	states := []State{}
+
+	mu.RLock()
+	for _, i := range injections {
+		if now.After(i.expires) {
+			continue
+		}
+
+		s := State{
+			Point:       i.Point,
+			Kind:        i.Kind,
+			Probability: i.Probability,
+			Expires:     i.expires,
+			Injected:    atomic.LoadUint64(&i.injected),
+		}
+		if i.Kind == Delay {
+			s.Delay = i.Delay.String()
+		}
+
+		states = append(states, s)
+	}
+	mu.RUnlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Point < states[j].Point
+	})
+
+	return states
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
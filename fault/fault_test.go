@@ -0,0 +1,115 @@
+package fault
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		fault config.Fault
+		err   string
+	}{
+		{config.Fault{Point: KafkaPublish, Kind: Error, Probability: 1}, ""},
+		{config.Fault{Point: GeoLookup, Kind: Delay, Probability: 0.5, Delay: time.Second}, ""},
+		{config.Fault{Point: "foo", Kind: Error, Probability: 1}, "fault point foo is not supported"},
+		{config.Fault{Point: GRPCSend, Kind: "foo", Probability: 1}, "fault kind foo is not supported"},
+		{config.Fault{Point: GRPCSend, Kind: Delay, Probability: 1}, "fault delay 0s is out of range (0, 1m0s]"},
+		{config.Fault{Point: GRPCSend, Kind: Reset, Probability: 0}, "fault probability 0 is out of range (0, 1]"},
+		{config.Fault{Point: ESBulk, Kind: Reset, Probability: 1, Duration: 2 * time.Hour}, "fault duration 2h0m0s is out of range [0, 1h0m0s]"},
+	}
+
+	for _, tt := range tests {
+		err := Validate(tt.fault)
+		if tt.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if Allow(false) {
+		t.Skip("built with the faultinjection tag")
+	}
+
+	err := Inject(config.Fault{Point: KafkaPublish, Kind: Error, Probability: 1})
+	assert.Equal(t, ErrDisabled, err)
+	assert.NoError(t, Check(KafkaPublish))
+	assert.Len(t, Status(), 0)
+}
+
+func TestInject(t *testing.T) {
+	assert.True(t, Allow(true))
+	defer Allow(false)
+
+	assert.NoError(t, Inject(config.Fault{Point: KafkaPublish, Kind: Error, Probability: 1}))
+	assert.NoError(t, Inject(config.Fault{Point: GRPCSend, Kind: Reset, Probability: 1}))
+	assert.NoError(t, Inject(config.Fault{Point: GeoLookup, Kind: Delay, Probability: 1, Delay: 20 * time.Millisecond}))
+
+	assert.Equal(t, ErrInjected, Check(KafkaPublish))
+	assert.True(t, errors.Is(Check(GRPCSend), syscall.ECONNRESET))
+
+	start := time.Now()
+	assert.NoError(t, Check(GeoLookup))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	// the other points aren't affected
+	assert.NoError(t, Check(ESBulk))
+
+	s := Status()
+	assert.Len(t, s, 3)
+	assert.Equal(t, GeoLookup, s[0].Point)
+	assert.Equal(t, "20ms", s[0].Delay)
+	assert.Equal(t, uint64(1), s[1].Injected)
+
+	Clear(GeoLookup)
+	assert.Len(t, Status(), 2)
+
+	Clear("")
+	assert.Len(t, Status(), 0)
+	assert.NoError(t, Check(KafkaPublish))
+}
+
+func TestExpire(t *testing.T) {
+	Allow(true)
+	defer Allow(false)
+
+	assert.NoError(t, Inject(config.Fault{Point: ESBulk, Kind: Error, Probability: 1, Duration: 10 * time.Millisecond}))
+	assert.Error(t, Check(ESBulk))
+
+	time.Sleep(20 * time.Millisecond)
+
+	assert.NoError(t, Check(ESBulk))
+	assert.Len(t, Status(), 0)
+}
+
+func TestTransport(t *testing.T) {
+	Allow(true)
+	defer Allow(false)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: Transport(ESBulk, nil, func(r *http.Request) bool {
+		return r.URL.Path == "/_bulk"
+	})}
+
+	assert.NoError(t, Inject(config.Fault{Point: ESBulk, Kind: Reset, Probability: 1}))
+
+	_, err := client.Post(ts.URL+"/_bulk", "application/x-ndjson", nil)
+	assert.True(t, errors.Is(err, syscall.ECONNRESET))
+
+	resp, err := client.Get(ts.URL + "/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+}
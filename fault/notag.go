@@ -0,0 +1,6 @@
+//go:build !faultinjection
+// +build !faultinjection
+
+package fault
+
+const buildTag = false
@@ -0,0 +1,7 @@
+//go:build faultinjection
+// +build faultinjection
+
+package fault
+
+// buildTag allows the injections without the unsafe config
+const buildTag = true
@@ -0,0 +1,31 @@
+package fault
+
+import "net/http"
+
+// transport injects the faults of the point into the matched requests
+type transport struct {
+	point string
+	next  http.RoundTripper
+	match func(*http.Request) bool
+}
+
+// Transport returns the round tripper which injects the faults of the
+// point into the requests which match, e.g. the bulk requests. the next
+// is the default transport if it's nil.
+func Transport(point string, next http.RoundTripper, match func(*http.Request) bool) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &transport{point: point, next: next, match: match}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.match(req) {
+		if err := Check(t.point); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
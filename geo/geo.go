@@ -1,19 +1,64 @@
 package geo
 
 import (
+	"context"
+
 	"github.com/mehrdadrad/tcpdog/geo/maxmind"
+	"github.com/mehrdadrad/tcpdog/geo/rest"
 	"go.uber.org/zap"
 )
 
-// Reg represents geo registry
-var Reg = map[string]Geoer{}
-
 // Geoer represents an IP to Geo provider
 type Geoer interface {
-	Init(*zap.Logger, map[string]string)
+	Init(*zap.Logger, map[string]string) error
 	Get(string) map[string]string
 }
 
-func init() {
-	Reg["maxmind"] = maxmind.New()
+type geoKey struct{}
+
+// New returns a new geo provider of the type, it's
+// nil if the type isn't supported.
+func New(typ string) Geoer {
+	switch typ {
+	case "maxmind":
+		return maxmind.New()
+	case "http":
+		return rest.New()
+	}
+
+	return nil
+}
+
+// Start initializes a new geo provider of the type, the provider
+// stops its background work once the context is done.
+func Start(ctx context.Context, typ string, logger *zap.Logger, cfg map[string]string) (Geoer, error) {
+	g := New(typ)
+	if g == nil {
+		return nil, nil
+	}
+
+	if err := g.Init(logger, cfg); err != nil {
+		return nil, err
+	}
+
+	if c, ok := g.(interface{ Close() }); ok {
+		go func() {
+			<-ctx.Done()
+			c.Close()
+		}()
+	}
+
+	return g, nil
+}
+
+// WithContext returns a copy of the context with the geo provider
+func WithContext(ctx context.Context, g Geoer) context.Context {
+	return context.WithValue(ctx, geoKey{}, g)
+}
+
+// FromContext returns the geo provider of the context,
+// it's nil if the geo isn't configured.
+func FromContext(ctx context.Context) Geoer {
+	g, _ := ctx.Value(geoKey{}).(Geoer)
+	return g
 }
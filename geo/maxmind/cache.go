@@ -0,0 +1,158 @@
+package maxmind
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+const cacheShards = 16
+
+// cache is a sharded LRU cache of the resolved IPs, it's safe for the
+// concurrent use. a shard evicts its least recently used IP once it's
+// full and the cached maps are shared thus they shouldn't be modified.
+type cache struct {
+	shards [cacheShards]cacheShard
+	max    int
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	hit  func()
+	miss func()
+}
+
+type cacheShard struct {
+	sync.Mutex
+	ll *list.List
+	m  map[string]*list.Element
+}
+
+type cacheEntry struct {
+	ip string
+	r  map[string]string
+}
+
+// CacheStats represents the cache counters
+type CacheStats struct {
+	Entries   int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// newCache constructs a cache which holds up to size IPs
+func newCache(size int) *cache {
+	c := &cache{
+		max:  size / cacheShards,
+		hit:  metrics.GeoCacheLookup("maxmind", metrics.GeoHit),
+		miss: metrics.GeoCacheLookup("maxmind", metrics.GeoMiss),
+	}
+
+	if c.max < 1 {
+		c.max = 1
+	}
+
+	for i := range c.shards {
+		c.shards[i].ll = list.New()
+		c.shards[i].m = make(map[string]*list.Element)
+	}
+
+	return c
+}
+
+func (c *cache) shard(ip string) *cacheShard {
+	return &c.shards[xxhash.Sum64String(ip)%cacheShards]
+}
+
+// get returns the cached lookup of the IP
+func (c *cache) get(ip string) (map[string]string, bool) {
+	sh := c.shard(ip)
+
+	sh.Lock()
+	e, ok := sh.m[ip]
+	if ok {
+		sh.ll.MoveToFront(e)
+	}
+	sh.Unlock()
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		c.miss()
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	c.hit()
+
+	return e.Value.(*cacheEntry).r, true
+}
+
+// add caches the lookup of the IP
+func (c *cache) add(ip string, r map[string]string) {
+	sh := c.shard(ip)
+
+	sh.Lock()
+	defer sh.Unlock()
+
+	if e, ok := sh.m[ip]; ok {
+		e.Value.(*cacheEntry).r = r
+		sh.ll.MoveToFront(e)
+		return
+	}
+
+	if sh.ll.Len() >= c.max {
+		e := sh.ll.Back()
+		sh.ll.Remove(e)
+		delete(sh.m, e.Value.(*cacheEntry).ip)
+		atomic.AddUint64(&c.evictions, 1)
+	}
+
+	sh.m[ip] = sh.ll.PushFront(&cacheEntry{ip: ip, r: r})
+}
+
+// purge removes all of the cached lookups e.g. once a database
+// has been reloaded, the counters are kept.
+func (c *cache) purge() {
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.Lock()
+		sh.ll.Init()
+		sh.m = make(map[string]*list.Element)
+		sh.Unlock()
+	}
+}
+
+func (c *cache) stats() CacheStats {
+	s := CacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+
+	for i := range c.shards {
+		c.shards[i].Lock()
+		s.Entries += c.shards[i].ll.Len()
+		c.shards[i].Unlock()
+	}
+
+	return s
+}
+
+// CacheStats returns the lookups cache counters, they're zero
+// if the cache hasn't been enabled (cache-size).
+func (g *Geo) CacheStats() CacheStats {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.cache == nil {
+		return CacheStats{}
+	}
+
+	return g.cache.stats()
+}
@@ -0,0 +1,151 @@
+package maxmind
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestCacheLRU(t *testing.T) {
+	c := newCache(cacheShards)
+	assert.Equal(t, 1, c.max)
+
+	// the IPs of a shard
+	var ips []string
+	sh := c.shard("10.0.0.1")
+	for i := 1; len(ips) < 3; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		if c.shard(ip) == sh {
+			ips = append(ips, ip)
+		}
+	}
+
+	c.add(ips[0], map[string]string{"CCode": "GB"})
+	r, ok := c.get(ips[0])
+	assert.True(t, ok)
+	assert.Equal(t, "GB", r["CCode"])
+
+	// the least recently used IP is evicted
+	c.add(ips[1], map[string]string{"CCode": "US"})
+	_, ok = c.get(ips[0])
+	assert.False(t, ok)
+
+	c = newCache(2 * cacheShards)
+	c.add(ips[0], nil)
+	c.add(ips[1], nil)
+	c.get(ips[0])
+	c.add(ips[2], nil)
+
+	_, ok = c.get(ips[0])
+	assert.True(t, ok)
+	_, ok = c.get(ips[1])
+	assert.False(t, ok)
+
+	s := c.stats()
+	assert.Equal(t, CacheStats{Entries: 2, Hits: 2, Misses: 1, Evictions: 1}, s)
+
+	c.purge()
+	assert.Equal(t, 0, c.stats().Entries)
+}
+
+func TestGetCached(t *testing.T) {
+	city, err := ioutil.ReadFile("./test_data/GeoLite2-City-Test.mmdb")
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	assert.NoError(t, ioutil.WriteFile(path, city, 0644))
+
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":      "city",
+		"path-city":  path,
+		"cache-size": "1024",
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Equal(t, "Boxford", g.Get("2.125.160.217")["City"])
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := g.CacheStats()
+	assert.Equal(t, 1, s.Entries)
+	assert.Equal(t, uint64(800), s.Hits+s.Misses)
+	assert.GreaterOrEqual(t, s.Hits, uint64(792))
+
+	// the reload purges the cache
+	replace(t, path, city)
+	g.refresh()
+	assert.Equal(t, 0, g.CacheStats().Entries)
+
+	assert.Equal(t, "Boxford", g.Get("2.125.160.217")["City"])
+	assert.Equal(t, 1, g.CacheStats().Entries)
+
+	// it's disabled by default
+	g.Init(c.Logger(), map[string]string{"level": "city", "path-city": path})
+	g.Get("2.125.160.217")
+	assert.Equal(t, CacheStats{}, g.CacheStats())
+
+	err = g.validate(map[string]string{"level": "city", "path-city": path, "cache-size": "-1"})
+	assert.EqualError(t, err, "wrong maxmind cache-size:-1")
+}
+
+// stream replays the lookups of the agents which mostly talk
+// to a small set of the remote addresses.
+func stream(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.2, 1, 255)
+
+	ips := make([]string, n)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("81.2.69.%d", z.Uint64())
+	}
+
+	return ips
+}
+
+func benchmarkReplay(b *testing.B, cacheSize string) {
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":      "city-loc-asn",
+		"path-city":  "./test_data/GeoLite2-City-Test.mmdb",
+		"path-asn":   "./test_data/GeoLite2-ASN-Test.mmdb",
+		"cache-size": cacheSize,
+	})
+
+	ips := stream(10000)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			g.Get(ips[i%len(ips)])
+		}
+	})
+}
+
+func BenchmarkReplayUncached(b *testing.B) {
+	benchmarkReplay(b, "")
+}
+
+func BenchmarkReplayCached(b *testing.B) {
+	benchmarkReplay(b, "1024")
+}
@@ -6,12 +6,20 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/oschwald/geoip2-golang"
 	"github.com/oschwald/maxminddb-golang"
 	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/fault"
+	"github.com/mehrdadrad/tcpdog/metrics"
 )
 
+const defaultLocale = "en"
+
 const (
 	// LevelASN : resolve IP to ASN information
 	LevelASN = iota + 1
@@ -23,8 +31,19 @@ const (
 	LevelCityLoc
 	// LevelCityLocASN : resolve IP to city/country/lat/lon and ASN information
 	LevelCityLocASN
+	// LevelCountry : resolve IP to country information
+	LevelCountry
+	// LevelCountryASN : resolve IP to country and ASN information
+	LevelCountryASN
 )
 
+type countryRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
 type cityRecord struct {
 	Country struct {
 		ISOCode string            `maxminddb:"iso_code"`
@@ -60,14 +79,24 @@ type cityLocRecord struct {
 	} `maxminddb:"location"`
 }
 
-// Geo represents Maxmind
+// Geo represents Maxmind, the databases are swapped under the
+// lock once they've been changed on disk (refresh-interval) and
+// the lookups cache (cache-size) is purged along with them.
 type Geo struct {
 	logger *zap.Logger
+	mu     sync.RWMutex
 	cityDB *maxminddb.Reader
 	asnDB  *geoip2.Reader
+	epochs map[string]*epoch
+	cache  *cache
 	fn     func(string) map[string]string
 	level  int
 	isASN  bool
+	locale atomic.Value
+
+	reload sync.Mutex
+	files  []*dbFile
+	stop   chan struct{}
 }
 
 var str2Level = map[string]int{
@@ -76,6 +105,8 @@ var str2Level = map[string]int{
 	"city-asn":     LevelCityASN,
 	"city-loc":     LevelCityLoc,
 	"city-loc-asn": LevelCityLocASN,
+	"country":      LevelCountry,
+	"country-asn":  LevelCountryASN,
 }
 
 // New constructs new Maxmind Geo
@@ -83,36 +114,65 @@ func New() *Geo {
 	return &Geo{}
 }
 
-// Init initializes MaxMind database
-func (g *Geo) Init(logger *zap.Logger, cfg map[string]string) {
-	var err error
-
+// Init initializes MaxMind database, the databases files are checked
+// per refresh-interval (e.g. 1h) if it's configured and they're reopened
+// once they've been changed e.g. by geoipupdate. the cache-size enables
+// the LRU cache of the resolved IPs, it's disabled by default.
+func (g *Geo) Init(logger *zap.Logger, cfg map[string]string) error {
 	g.level = str2Level[strings.ToLower(cfg["level"])]
+	g.SetLocale(cfg["locale"])
+	g.logger = logger
 
 	if err := g.validate(cfg); err != nil {
-		logger.Fatal("maxmind", zap.Error(err))
+		return err
 	}
 
-	if g.level != LevelASN {
-		g.cityDB, err = maxminddb.Open(cfg["path-city"])
-		if err != nil {
-			logger.Fatal("maxmind", zap.Error(err))
+	var files []*dbFile
+
+	switch g.level {
+	case LevelASN:
+	case LevelCountry, LevelCountryASN:
+		// the city database is a superset of the country database
+		path := cfg["path-country"]
+		if len(path) < 1 {
+			path = cfg["path-city"]
 		}
+		files = append(files, &dbFile{kind: cityKind, path: path})
+	default:
+		files = append(files, &dbFile{kind: cityKind, path: cfg["path-city"]})
 	}
 
 	if g.level%2 == 1 {
 		g.isASN = true
-		g.asnDB, err = geoip2.Open(cfg["path-asn"])
-		if err != nil {
-			logger.Fatal("maxmind", zap.Error(err))
+		files = append(files, &dbFile{kind: asnKind, path: cfg["path-asn"]})
+	}
+
+	for _, f := range files {
+		if err := g.open(f); err != nil {
+			return err
 		}
 	}
 
 	g.fn = g.getFunc()
 
-	g.logger = logger
+	g.mu.Lock()
+	g.cache = nil
+	if size, _ := strconv.Atoi(cfg["cache-size"]); size > 0 {
+		g.cache = newCache(size)
+	}
+	g.mu.Unlock()
+
+	interval, _ := time.ParseDuration(cfg["refresh-interval"])
+	g.watch(files, interval)
 
 	logger.Info("geo", zap.String("msg", "maxmind has been initialized"))
+
+	return nil
+}
+
+// Close stops watching the database files
+func (g *Geo) Close() {
+	g.watch(nil, 0)
 }
 
 func (g *Geo) getASN(ipStr string) map[string]string {
@@ -132,6 +192,36 @@ func (g *Geo) getASN(ipStr string) map[string]string {
 	return r
 }
 
+func (g *Geo) getCountryASN(ipStr string) map[string]string {
+	var (
+		cRecord countryRecord
+		r       = map[string]string{}
+	)
+
+	ip := net.ParseIP(ipStr)
+	err := g.cityDB.Lookup(ip, &cRecord)
+	if err != nil {
+		g.logger.Error("maxmind", zap.Error(err))
+	} else {
+		r["CCode"] = cRecord.Country.ISOCode
+		r["Country"] = g.name(cRecord.Country.Names)
+	}
+
+	if !g.isASN {
+		return r
+	}
+
+	asn, err := g.asnDB.ASN(ip)
+	if err != nil {
+		g.logger.Error("maxmind", zap.Error(err))
+	} else {
+		r["ASN"] = strconv.Itoa(int(asn.AutonomousSystemNumber))
+		r["ASNOrg"] = asn.AutonomousSystemOrganization
+	}
+
+	return r
+}
+
 func (g *Geo) getCityASN(ipStr string) map[string]string {
 	var (
 		cRecord cityRecord
@@ -144,13 +234,13 @@ func (g *Geo) getCityASN(ipStr string) map[string]string {
 		g.logger.Error("maxmind", zap.Error(err))
 	} else {
 		r["CCode"] = cRecord.Country.ISOCode
-		r["Country"] = cRecord.Country.Names["en"]
-		r["City"] = cRecord.City.Names["en"]
+		r["Country"] = g.name(cRecord.Country.Names)
+		r["City"] = g.name(cRecord.City.Names)
 	}
 
 	if len(cRecord.Subdivisions) > 0 {
 		r["CSCode"] = cRecord.Subdivisions[0].IsoCode
-		r["Region"] = cRecord.Subdivisions[0].Names["en"]
+		r["Region"] = g.name(cRecord.Subdivisions[0].Names)
 	}
 
 	if !g.isASN {
@@ -180,14 +270,14 @@ func (g *Geo) getCityLocASN(ipStr string) map[string]string {
 		g.logger.Error("maxmind", zap.Error(err))
 	} else {
 		r["CCode"] = cRecord.Country.ISOCode
-		r["Country"] = cRecord.Country.Names["en"]
-		r["City"] = cRecord.City.Names["en"]
+		r["Country"] = g.name(cRecord.Country.Names)
+		r["City"] = g.name(cRecord.City.Names)
 		r["GeoLocation"] = fmt.Sprintf("%f,%f", cRecord.Location.Latitude, cRecord.Location.Longitude)
 	}
 
 	if len(cRecord.Subdivisions) > 0 {
 		r["CSCode"] = cRecord.Subdivisions[0].IsoCode
-		r["Region"] = cRecord.Subdivisions[0].Names["en"]
+		r["Region"] = g.name(cRecord.Subdivisions[0].Names)
 	}
 
 	if !g.isASN {
@@ -205,15 +295,87 @@ func (g *Geo) getCityLocASN(ipStr string) map[string]string {
 	return r
 }
 
+// SetLocale sets the names locale e.g. zh-CN, it's safe to
+// switch the locale while the geo is in use (hot reload). the
+// cached lookups of the old locale are purged under the lock.
+func (g *Geo) SetLocale(locale string) {
+	if locale == "" {
+		locale = defaultLocale
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.locale.Store(locale)
+	if g.cache != nil {
+		g.cache.purge()
+	}
+}
+
+// name returns the localized name, it falls back to the
+// english name if the locale is missing for the record.
+func (g *Geo) name(names map[string]string) string {
+	if v, ok := names[g.locale.Load().(string)]; ok && v != "" {
+		return v
+	}
+
+	return names[defaultLocale]
+}
+
 // Get returns Geo information
 func (g *Geo) Get(ipStr string) map[string]string {
-	return g.fn(ipStr)
+	if err := fault.Check(fault.GeoLookup); err != nil {
+		metrics.GeoLookup("maxmind", metrics.GeoMiss)
+		return nil
+	}
+
+	g.mu.RLock()
+	r := g.lookup(ipStr)
+	g.served()
+	g.mu.RUnlock()
+
+	switch {
+	case net.ParseIP(ipStr) == nil:
+		metrics.GeoLookup("maxmind", metrics.GeoMiss)
+	case found(r):
+		metrics.GeoLookup("maxmind", metrics.GeoHit)
+	default:
+		metrics.GeoLookup("maxmind", metrics.GeoNotFound)
+	}
+
+	return r
+}
+
+// lookup returns the cached or the resolved geo of the IP, the
+// caller holds the read lock thus the cache isn't filled by the
+// old database once it's been purged.
+func (g *Geo) lookup(ipStr string) map[string]string {
+	if g.cache == nil {
+		return g.fn(ipStr)
+	}
+
+	if r, ok := g.cache.get(ipStr); ok {
+		return r
+	}
+
+	r := g.fn(ipStr)
+	g.cache.add(ipStr, r)
+
+	return r
+}
+
+// found returns true if the ip exists in the country, the city
+// or the asn database, the missing ip has the zero record.
+func found(r map[string]string) bool {
+	return r["CCode"] != "" || (r["ASN"] != "" && r["ASN"] != "0")
 }
 
 func (g *Geo) getFunc() func(string) map[string]string {
 	switch g.level {
 	case LevelASN:
 		return g.getASN
+	case LevelCountry, LevelCountryASN:
+		return g.getCountryASN
 	case LevelCity, LevelCityASN:
 		return g.getCityASN
 	case LevelCityLoc, LevelCityLocASN:
@@ -233,6 +395,17 @@ func (g *Geo) validate(cfg map[string]string) error {
 		if v, isPathASN := cfg["path-asn"]; !isPathASN || len(v) < 1 {
 			return errors.New("the maxmind path-asn has not configured")
 		}
+	case LevelCountry:
+		if len(cfg["path-country"]) < 1 && len(cfg["path-city"]) < 1 {
+			return errors.New("the maxmind path-country has not configured")
+		}
+	case LevelCountryASN:
+		if v, isPathASN := cfg["path-asn"]; !isPathASN || len(v) < 1 {
+			return errors.New("the maxmind path-asn has not configured")
+		}
+		if len(cfg["path-country"]) < 1 && len(cfg["path-city"]) < 1 {
+			return errors.New("the maxmind path-country has not configured")
+		}
 	case LevelCity, LevelCityLoc:
 		if v, isPathCity := cfg["path-city"]; !isPathCity || len(v) < 1 {
 			return errors.New("the maxmind path-city has not configured")
@@ -248,5 +421,17 @@ func (g *Geo) validate(cfg map[string]string) error {
 		return errors.New("unknown maxmind/geo level")
 	}
 
+	if v, ok := cfg["cache-size"]; ok && v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			return fmt.Errorf("wrong maxmind cache-size:%s", v)
+		}
+	}
+
+	if v, ok := cfg["refresh-interval"]; ok && v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			return fmt.Errorf("wrong maxmind refresh-interval:%s", v)
+		}
+	}
+
 	return nil
 }
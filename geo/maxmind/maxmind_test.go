@@ -29,6 +29,15 @@ func TestGetCity(t *testing.T) {
 	assert.Equal(t, "England", r["Region"])
 }
 
+func TestInitError(t *testing.T) {
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	err := g.Init(c.Logger(), map[string]string{"level": "city", "path-city": "./test_data/notfound.mmdb"})
+	assert.Error(t, err)
+}
+
 func TestGetASN(t *testing.T) {
 	cfg["level"] = "asn"
 
@@ -87,6 +96,53 @@ func TestGetCityLocASN(t *testing.T) {
 	assert.Equal(t, "22773", r["ASN"])
 	assert.Equal(t, "Cox Communications Inc.", r["ASNOrg"])
 }
+func TestGetCountry(t *testing.T) {
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":        "country",
+		"path-country": "./test_data/GeoLite2-City-Test.mmdb",
+	})
+
+	r := g.Get("2.125.160.217")
+	assert.Equal(t, map[string]string{"CCode": "GB", "Country": "United Kingdom"}, r)
+}
+
+func TestGetCountryASN(t *testing.T) {
+	cfg["level"] = "country-asn"
+
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), cfg)
+
+	r := g.Get("2.125.160.217")
+	assert.Equal(t, "GB", r["CCode"])
+	assert.Equal(t, "United Kingdom", r["Country"])
+	assert.NotContains(t, r, "City")
+
+	r = g.Get("70.160.0.1")
+	assert.Equal(t, "22773", r["ASN"])
+	assert.Equal(t, "Cox Communications Inc.", r["ASNOrg"])
+}
+
+func TestASNWithoutCity(t *testing.T) {
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":    "asn",
+		"path-asn": "./test_data/GeoLite2-ASN-Test.mmdb",
+	})
+
+	assert.Nil(t, g.cityDB)
+	assert.Equal(t, "22773", g.Get("70.160.0.1")["ASN"])
+}
+
 func BenchmarkMaxmindParallel(b *testing.B) {
 	c := config.Config{}
 	c.SetMockLogger("memory")
@@ -112,3 +168,53 @@ func BenchmarkMaxmind(b *testing.B) {
 		g.Get("68.170.74.242")
 	}
 }
+
+func TestGetLocale(t *testing.T) {
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":     "city",
+		"locale":    "fr",
+		"path-city": "./test_data/GeoLite2-City-Test.mmdb",
+	})
+
+	r := g.Get("2.125.160.217")
+	assert.Equal(t, "Royaume-Uni", r["Country"])
+	assert.Equal(t, "Angleterre", r["Region"])
+	// falls back to en
+	assert.Equal(t, "Boxford", r["City"])
+
+	// switch on reload
+	g.SetLocale("de")
+	r = g.Get("2.125.160.217")
+	assert.Equal(t, "Vereinigtes Königreich", r["Country"])
+	assert.Equal(t, "England", r["Region"])
+
+	g.SetLocale("")
+	assert.Equal(t, "United Kingdom", g.Get("2.125.160.217")["Country"])
+}
+
+func TestGetLocaleCached(t *testing.T) {
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":      "city",
+		"locale":     "fr",
+		"path-city":  "./test_data/GeoLite2-City-Test.mmdb",
+		"cache-size": "1024",
+	})
+
+	assert.Equal(t, "Royaume-Uni", g.Get("2.125.160.217")["Country"])
+	assert.Equal(t, 1, g.CacheStats().Entries)
+
+	// the cached lookup of the old locale is purged
+	g.SetLocale("de")
+	assert.Equal(t, 0, g.CacheStats().Entries)
+	assert.Equal(t, "Vereinigtes Königreich", g.Get("2.125.160.217")["Country"])
+	assert.Equal(t, "Vereinigtes Königreich", g.Get("2.125.160.217")["Country"])
+	assert.Equal(t, 1, g.CacheStats().Entries)
+}
@@ -0,0 +1,207 @@
+package maxmind
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+// the kinds of the database files
+const (
+	cityKind = "city"
+	asnKind  = "asn"
+)
+
+// dbFile is a database file which is watched for the changes
+type dbFile struct {
+	kind    string
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// epoch counts the lookups which a database build has served
+type epoch struct {
+	database string
+	build    uint
+	lookups  uint64
+	inc      func()
+}
+
+// Epoch represents the lookups which a database build has served
+type Epoch struct {
+	Kind     string
+	Database string
+	Build    uint
+	Lookups  uint64
+}
+
+// Epochs returns the build epochs of the current databases and
+// the lookups which they've served, e.g. to verify a reload.
+func (g *Geo) Epochs() []Epoch {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var epochs []Epoch
+	for _, kind := range []string{cityKind, asnKind} {
+		if e, ok := g.epochs[kind]; ok {
+			epochs = append(epochs, Epoch{
+				Kind:     kind,
+				Database: e.database,
+				Build:    e.build,
+				Lookups:  atomic.LoadUint64(&e.lookups),
+			})
+		}
+	}
+
+	return epochs
+}
+
+// served counts a lookup of the current databases, the
+// caller holds the read lock.
+func (g *Geo) served() {
+	for _, e := range g.epochs {
+		atomic.AddUint64(&e.lookups, 1)
+		e.inc()
+	}
+}
+
+// open opens the database file and swaps it with the current one, the
+// old database is closed once the in-flight lookups have been done.
+func (g *Geo) open(f *dbFile) error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	var closeOld func() error
+
+	switch f.kind {
+	case cityKind:
+		db, err := maxminddb.Open(f.path)
+		if err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		if old := g.cityDB; old != nil {
+			closeOld = old.Close
+		}
+		g.cityDB = db
+		g.setEpoch(f.kind, db.Metadata)
+		g.mu.Unlock()
+	case asnKind:
+		db, err := geoip2.Open(f.path)
+		if err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		if old := g.asnDB; old != nil {
+			closeOld = old.Close
+		}
+		g.asnDB = db
+		g.setEpoch(f.kind, db.Metadata())
+		g.mu.Unlock()
+	}
+
+	if closeOld != nil {
+		closeOld()
+	}
+
+	f.modTime, f.size = info.ModTime(), info.Size()
+
+	return nil
+}
+
+// setEpoch sets the epoch of the database and it purges the
+// cached lookups of the old database, the caller holds the lock.
+func (g *Geo) setEpoch(kind string, md maxminddb.Metadata) {
+	if g.cache != nil {
+		g.cache.purge()
+	}
+
+	if g.epochs == nil {
+		g.epochs = map[string]*epoch{}
+	}
+
+	g.epochs[kind] = &epoch{
+		database: md.DatabaseType,
+		build:    md.BuildEpoch,
+		inc:      metrics.GeoEpochLookup("maxmind", md.DatabaseType, md.BuildEpoch),
+	}
+}
+
+// watch refreshes the databases per interval, the previous
+// watch of the geo stops e.g. once it's initialized again.
+func (g *Geo) watch(files []*dbFile, interval time.Duration) {
+	g.reload.Lock()
+	defer g.reload.Unlock()
+
+	g.files = files
+
+	if g.stop != nil {
+		close(g.stop)
+		g.stop = nil
+	}
+
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	g.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				g.refresh()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// refresh reopens the databases files which have been changed on disk.
+// a replacement which can't be opened e.g. a missing, a corrupt or a
+// partially written file is logged and the current database is kept,
+// it's tried again by the next refresh.
+func (g *Geo) refresh() {
+	g.reload.Lock()
+	defer g.reload.Unlock()
+
+	for _, f := range g.files {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			g.logger.Error("maxmind", zap.String("msg", "the database is kept"), zap.Error(err))
+			continue
+		}
+
+		if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+			continue
+		}
+
+		if err := g.open(f); err != nil {
+			g.logger.Error("maxmind", zap.String("msg", "the database is kept"),
+				zap.String("path", f.path), zap.Error(err))
+			continue
+		}
+
+		g.mu.RLock()
+		build := g.epochs[f.kind].build
+		g.mu.RUnlock()
+
+		g.logger.Info("maxmind", zap.String("msg", f.path+" has been reloaded"), zap.Uint("epoch", build))
+	}
+}
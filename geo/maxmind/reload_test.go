@@ -0,0 +1,140 @@
+package maxmind
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// replace replaces the file by rename like geoipupdate
+func replace(t *testing.T, path string, b []byte) {
+	tmp := path + ".tmp"
+	assert.NoError(t, ioutil.WriteFile(tmp, b, 0644))
+	assert.NoError(t, os.Rename(tmp, path))
+	// the mtime resolution of some file systems is coarse
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, future, future))
+}
+
+func TestRefresh(t *testing.T) {
+	city, err := ioutil.ReadFile("./test_data/GeoLite2-City-Test.mmdb")
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	assert.NoError(t, ioutil.WriteFile(path, city, 0644))
+
+	c := config.Config{}
+	ms := c.SetMockLogger("maxmind-refresh")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":     "city",
+		"path-city": path,
+	})
+
+	assert.Equal(t, "GB", g.Get("2.125.160.217")["CCode"])
+
+	epochs := g.Epochs()
+	assert.Len(t, epochs, 1)
+	assert.Equal(t, "GeoLite2-City", epochs[0].Database)
+	assert.Equal(t, uint64(1), epochs[0].Lookups)
+	build := epochs[0].Build
+
+	// nothing has been changed
+	g.refresh()
+	assert.Equal(t, uint64(1), g.Epochs()[0].Lookups)
+
+	// a corrupt replacement keeps the running database
+	replace(t, path, []byte("corrupt"))
+	g.refresh()
+	assert.Contains(t, ms.String(), "the database is kept")
+	assert.Equal(t, "GB", g.Get("2.125.160.217")["CCode"])
+	assert.Equal(t, uint64(2), g.Epochs()[0].Lookups)
+
+	// a missing file keeps it as well
+	assert.NoError(t, os.Remove(path))
+	g.refresh()
+	assert.Equal(t, "GB", g.Get("2.125.160.217")["CCode"])
+
+	// the valid replacement is a new epoch
+	replace(t, path, city)
+	g.refresh()
+	assert.Contains(t, ms.String(), path+" has been reloaded")
+
+	epochs = g.Epochs()
+	assert.Equal(t, build, epochs[0].Build)
+	assert.Equal(t, uint64(0), epochs[0].Lookups)
+
+	assert.Equal(t, "GB", g.Get("2.125.160.217")["CCode"])
+	assert.Equal(t, uint64(1), g.Epochs()[0].Lookups)
+}
+
+func TestRefreshInterval(t *testing.T) {
+	asn, err := ioutil.ReadFile("./test_data/GeoLite2-ASN-Test.mmdb")
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	assert.NoError(t, ioutil.WriteFile(path, asn, 0644))
+
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"level":            "asn",
+		"path-asn":         path,
+		"refresh-interval": "10ms",
+	})
+	defer g.watch(nil, 0)
+
+	// the lookups aren't dropped while it's reloading
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					assert.Equal(t, "22773", g.Get("70.160.0.1")["ASN"])
+				}
+			}
+		}()
+	}
+
+	replace(t, path, asn)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		g.reload.Lock()
+		defer g.reload.Unlock()
+		return g.files[0].modTime.Equal(info.ModTime())
+	}, 5*time.Second, 5*time.Millisecond)
+
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, "22773", g.Get("70.160.0.1")["ASN"])
+}
+
+func TestRefreshIntervalConfig(t *testing.T) {
+	g := New()
+	g.level = LevelCity
+
+	err := g.validate(map[string]string{"level": "city", "path-city": "foo", "refresh-interval": "1x"})
+	assert.EqualError(t, err, "wrong maxmind refresh-interval:1x")
+
+	err = g.validate(map[string]string{"level": "city", "path-city": "foo", "refresh-interval": "24h"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,254 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/fault"
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+// Geo represents an external HTTP enrichment service.
+//
+// the unique IPs are sent in batches as a json array of strings
+// to the configured url with POST method:
+//
+//	["10.0.0.1", "10.0.0.2"]
+//
+// and the service responds a json object which maps the IPs to
+// their attributes, the unknown IPs can be left out:
+//
+//	{"10.0.0.1": {"Service": "web", "Owner": "infra"}}
+//
+// the lookups never block the ingestion, an IP which is not in
+// the cache yet returns no attributes and it's queued for the next
+// batch. the attributes (or lack of them) are cached for the ttl.
+type Geo struct {
+	logger    *zap.Logger
+	client    *http.Client
+	url       string
+	ttl       time.Duration
+	interval  time.Duration
+	batchSize int
+
+	mu      sync.RWMutex
+	cache   map[string]*entry
+	pending map[string]struct{}
+	once    sync.Once
+	err     error
+	queue   chan string
+	cancel  context.CancelFunc
+}
+
+type entry struct {
+	attrs   map[string]string
+	expires time.Time
+}
+
+// New constructs new http enrichment geo
+func New() *Geo {
+	return &Geo{}
+}
+
+// Init initializes the http enrichment
+func (g *Geo) Init(logger *zap.Logger, cfg map[string]string) error {
+	g.once.Do(func() {
+		if g.err = g.init(cfg); g.err != nil {
+			return
+		}
+
+		g.logger = logger
+
+		var ctx context.Context
+		ctx, g.cancel = context.WithCancel(context.Background())
+		go g.batcher(ctx)
+
+		logger.Info("geo", zap.String("msg", "http enrichment has been initialized"))
+	})
+
+	return g.err
+}
+
+// Close stops the batches, the cached attributes are still served
+func (g *Geo) Close() {
+	if g.cancel != nil {
+		g.cancel()
+	}
+}
+
+func (g *Geo) init(cfg map[string]string) error {
+	var err error
+
+	g.url = cfg["url"]
+	if g.url == "" {
+		return errors.New("the http url has not configured")
+	}
+
+	params := map[string]int{
+		"ttl":        300,
+		"timeout":    2,
+		"batch-size": 100,
+		"interval":   1,
+	}
+
+	for k := range params {
+		v, ok := cfg[k]
+		if !ok {
+			continue
+		}
+
+		params[k], err = strconv.Atoi(v)
+		if err != nil || params[k] < 1 {
+			return fmt.Errorf("invalid http %s: %s", k, v)
+		}
+	}
+
+	g.ttl = time.Duration(params["ttl"]) * time.Second
+	g.interval = time.Duration(params["interval"]) * time.Second
+	g.batchSize = params["batch-size"]
+	g.client = &http.Client{Timeout: time.Duration(params["timeout"]) * time.Second}
+	g.cache = map[string]*entry{}
+	g.pending = map[string]struct{}{}
+	g.queue = make(chan string, 10000)
+
+	return nil
+}
+
+// Get returns the cached attributes of an IP
+func (g *Geo) Get(ip string) map[string]string {
+	g.mu.RLock()
+	e, ok := g.cache[ip]
+	_, isPending := g.pending[ip]
+	g.mu.RUnlock()
+
+	switch {
+	case !ok:
+		metrics.GeoLookup("http", metrics.GeoMiss)
+	case len(e.attrs) == 0:
+		metrics.GeoLookup("http", metrics.GeoNotFound)
+	default:
+		metrics.GeoLookup("http", metrics.GeoHit)
+	}
+
+	if ok && time.Now().Before(e.expires) {
+		return e.attrs
+	}
+
+	if !isPending {
+		g.mu.Lock()
+		g.pending[ip] = struct{}{}
+		g.mu.Unlock()
+
+		select {
+		case g.queue <- ip:
+		default:
+			g.mu.Lock()
+			delete(g.pending, ip)
+			g.mu.Unlock()
+		}
+	}
+
+	// the expired attributes are still better than nothing
+	if ok {
+		return e.attrs
+	}
+
+	return nil
+}
+
+func (g *Geo) batcher(ctx context.Context) {
+	var (
+		batch  = make([]string, 0, g.batchSize)
+		ticker = time.NewTicker(g.interval)
+	)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ip := <-g.queue:
+			batch = append(batch, ip)
+			if len(batch) < g.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) < 1 {
+				continue
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		g.flush(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (g *Geo) flush(ctx context.Context, batch []string) {
+	attrs, err := g.fetch(ctx, batch)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, ip := range batch {
+		delete(g.pending, ip)
+	}
+
+	if err != nil {
+		g.logger.Error("http", zap.Error(err))
+		return
+	}
+
+	expires := time.Now().Add(g.ttl)
+	for _, ip := range batch {
+		g.cache[ip] = &entry{attrs: attrs[ip], expires: expires}
+	}
+
+	// clean up the expired entries
+	for ip, e := range g.cache {
+		if time.Now().After(e.expires.Add(g.ttl)) {
+			delete(g.cache, ip)
+		}
+	}
+}
+
+func (g *Geo) fetch(ctx context.Context, batch []string) (map[string]map[string]string, error) {
+	if err := fault.Check(fault.GeoLookup); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("enrichment service returned %s", resp.Status)
+	}
+
+	attrs := map[string]map[string]string{}
+	err = json.NewDecoder(resp.Body).Decode(&attrs)
+
+	return attrs, err
+}
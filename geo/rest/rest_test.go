@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestGet(t *testing.T) {
+	var requests int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		ips := []string{}
+		json.NewDecoder(r.Body).Decode(&ips)
+
+		resp := map[string]map[string]string{}
+		for _, ip := range ips {
+			if ip == "10.0.0.1" {
+				resp[ip] = map[string]string{"Service": "web", "Owner": "infra"}
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"url":        ts.URL,
+		"batch-size": "2",
+	})
+
+	// not cached yet
+	assert.Nil(t, g.Get("10.0.0.1"))
+	assert.Nil(t, g.Get("10.0.0.2"))
+
+	time.Sleep(500 * time.Millisecond)
+
+	assert.Equal(t, map[string]string{"Service": "web", "Owner": "infra"}, g.Get("10.0.0.1"))
+	assert.Nil(t, g.Get("10.0.0.2"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestInitError(t *testing.T) {
+	c := config.Config{}
+	c.SetMockLogger("memory")
+
+	err := New().Init(c.Logger(), map[string]string{"ttl": "0"})
+	assert.EqualError(t, err, "the http url has not configured")
+
+	err = New().Init(c.Logger(), map[string]string{"url": "http://127.0.0.1", "ttl": "0"})
+	assert.EqualError(t, err, "invalid http ttl: 0")
+}
+
+func TestGetFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+	defer ts.Close()
+
+	c := config.Config{}
+	ms := c.SetMockLogger("memory1")
+
+	g := New()
+	g.Init(c.Logger(), map[string]string{
+		"url":        ts.URL,
+		"batch-size": "1",
+		"timeout":    "1",
+	})
+	ms.Reset()
+
+	start := time.Now()
+	assert.Nil(t, g.Get("10.0.0.1"))
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	time.Sleep(1500 * time.Millisecond)
+
+	assert.Nil(t, g.Get("10.0.0.1"))
+	assert.Contains(t, ms.Unmarshal()["error"], "Timeout")
+}
+
+func TestInit(t *testing.T) {
+	g := New()
+	assert.Error(t, g.init(map[string]string{}))
+	assert.Error(t, g.init(map[string]string{"url": "http://localhost", "ttl": "abc"}))
+	assert.NoError(t, g.init(map[string]string{"url": "http://localhost", "ttl": "60"}))
+	assert.Equal(t, time.Minute, g.ttl)
+}
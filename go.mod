@@ -1,24 +1,32 @@
 module github.com/mehrdadrad/tcpdog
 
-go 1.15
+go 1.16
 
 require (
 	github.com/Shopify/sarama v1.26.3
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/elastic/go-elasticsearch/v8 v8.0.0-20201229214741-2366c2514674
-	github.com/golang/protobuf v1.4.2
+	github.com/fxamacker/cbor/v2 v2.2.0
+	github.com/golang/protobuf v1.5.2
 	github.com/influxdata/influxdb-client-go/v2 v2.2.1
 	github.com/iovisor/gobpf v0.0.0-20210109143822-fb892541d416
 	github.com/ip2location/ip2location-go v8.3.0+incompatible
+	github.com/klauspost/compress v1.9.8
+	github.com/nats-io/nats.go v1.11.0
 	github.com/oschwald/geoip2-golang v1.4.0
 	github.com/oschwald/maxminddb-golang v1.8.0
+	github.com/prometheus/client_golang v1.12.2
 	github.com/sethvargo/go-signalcontext v0.1.0
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
+	go.uber.org/goleak v1.1.11
 	go.uber.org/zap v1.16.0
-	golang.org/x/net v0.0.0-20200625001655-4c5254603344 // indirect
-	golang.org/x/sys v0.0.0-20200625212154-ddb9806d33ae // indirect
-	golang.org/x/tools v0.0.0-20200103221440-774c71fcf114 // indirect
-	google.golang.org/grpc v1.27.0
-	google.golang.org/protobuf v1.25.0
+	golang.org/x/net v0.0.0-20210525063256-abc453219eb5
+	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9
+	golang.org/x/tools v0.1.5 // indirect
+	google.golang.org/grpc v1.31.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
@@ -0,0 +1,237 @@
+// Package health reports the health of the server components to the
+// liveness and the readiness probes e.g. kubernetes. the ingresses and
+// the ingestions register their checkers to the registry of the context
+// and the http endpoints report the components states.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// checkTimeout is the max time of a readiness check
+const checkTimeout = 3 * time.Second
+
+// the component states
+const (
+	StateHealthy   = "healthy"
+	StateUnhealthy = "unhealthy"
+)
+
+// ErrStarting is the state of a component which hasn't been started yet
+var ErrStarting = errors.New("starting")
+
+// Checker represents a component health, it returns
+// the error of the component if it's unhealthy.
+type Checker interface {
+	Health(ctx context.Context) error
+}
+
+// CheckerFunc is a function checker e.g. the ping of a database
+type CheckerFunc func(ctx context.Context) error
+
+// Health calls the function
+func (f CheckerFunc) Health(ctx context.Context) error {
+	return f(ctx)
+}
+
+// State is a checker which the component sets by its
+// events e.g. a consumer group has been joined.
+type State struct {
+	mu  sync.Mutex
+	err error
+}
+
+// NewState returns a state by its initial error
+func NewState(err error) *State {
+	return &State{err: err}
+}
+
+// Set sets the component error, nil is healthy
+func (s *State) Set(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// Health returns the last error of the component
+func (s *State) Health(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Status represents a component state
+type Status struct {
+	Component string `json:"component"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+	Optional  bool   `json:"optional,omitempty"`
+}
+
+type component struct {
+	component string
+	name      string
+	checker   Checker
+	optional  bool
+}
+
+// Registry keeps the checkers of the components
+type Registry struct {
+	mu         sync.Mutex
+	components map[string]component
+}
+
+// registryKey is the context key of the registry
+type registryKey struct{}
+
+// optionalKey is the context key of the optional components
+type optionalKey struct{}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{components: map[string]component{}}
+}
+
+// WithRegistry returns a copy of the context with the registry
+func WithRegistry(ctx context.Context, r *Registry) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// WithOptional returns a copy of the context which registers its
+// components as optional, they're reported but the readiness doesn't
+// depend on them e.g. the mirror ingestions.
+func WithOptional(ctx context.Context) context.Context {
+	return context.WithValue(ctx, optionalKey{}, true)
+}
+
+// Register registers the checker to the registry of the context, it's
+// ignored if the health endpoint isn't enabled.
+func Register(ctx context.Context, component, name string, c Checker) {
+	if r, ok := ctx.Value(registryKey{}).(*Registry); ok {
+		optional, _ := ctx.Value(optionalKey{}).(bool)
+		r.register(component, name, c, optional)
+	}
+}
+
+// Register registers the component checker, the checker of a
+// component which has been registered already is replaced.
+func (r *Registry) Register(kind, name string, c Checker) {
+	r.register(kind, name, c, false)
+}
+
+func (r *Registry) register(kind, name string, c Checker, optional bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.components[kind+"/"+name] = component{component: kind, name: name, checker: c, optional: optional}
+}
+
+// Check checks the components concurrently, it returns true if all
+// of the required ones are healthy and their states by their names.
+func (r *Registry) Check(ctx context.Context) (bool, []Status) {
+	r.mu.Lock()
+	components := make([]component, 0, len(r.components))
+	for _, c := range r.components {
+		components = append(components, c)
+	}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		status = make([]Status, len(components))
+	)
+
+	for i, c := range components {
+		wg.Add(1)
+		go func(i int, c component) {
+			defer wg.Done()
+
+			status[i] = Status{Component: c.component, Name: c.name, State: StateHealthy, Optional: c.optional}
+			if err := c.checker.Health(ctx); err != nil {
+				status[i].State, status[i].Error = StateUnhealthy, err.Error()
+			}
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	sort.Slice(status, func(i, j int) bool {
+		if status[i].Component != status[j].Component {
+			return status[i].Component < status[j].Component
+		}
+		return status[i].Name < status[j].Name
+	})
+
+	healthy := true
+	for _, s := range status {
+		healthy = healthy && (s.State == StateHealthy || s.Optional)
+	}
+
+	return healthy, status
+}
+
+// Handler returns the health http handler, /healthz reports the process
+// is alive and /readyz reports the components, it's 503 if one of them
+// is unhealthy.
+func Handler(r *Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"state": StateHealthy})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		healthy, status := r.Check(req.Context())
+
+		state, code := StateHealthy, http.StatusOK
+		if !healthy {
+			state, code = StateUnhealthy, http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			State      string   `json:"state"`
+			Components []Status `json:"components"`
+		}{state, status})
+	})
+
+	return mux
+}
+
+// Start serves the health endpoints on the address until the
+// context is done, it returns the error if it can't listen.
+func Start(ctx context.Context, addr string, r *Registry, logger *zap.Logger) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: Handler(r)}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Error("health", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyz(t *testing.T) {
+	r := NewRegistry()
+	state := NewState(ErrStarting)
+
+	ctx := WithRegistry(context.Background(), r)
+	Register(ctx, "ingress", "kafka01", state)
+	Register(ctx, "ingestion", "es01", CheckerFunc(func(context.Context) error {
+		return errors.New("connection refused")
+	}))
+
+	srv := httptest.NewServer(Handler(r))
+	defer srv.Close()
+
+	readyz := func() (int, map[string]interface{}) {
+		resp, err := http.Get(srv.URL + "/readyz")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		body := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		return resp.StatusCode, body
+	}
+
+	code, body := readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]interface{}{
+		"state": "unhealthy",
+		"components": []interface{}{
+			map[string]interface{}{"component": "ingestion", "name": "es01", "state": "unhealthy", "error": "connection refused"},
+			map[string]interface{}{"component": "ingress", "name": "kafka01", "state": "unhealthy", "error": "starting"},
+		},
+	}, body)
+
+	// the group has been joined but the ingestion is still unreachable
+	state.Set(nil)
+	code, body = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]interface{}{"component": "ingress", "name": "kafka01", "state": "healthy"},
+		body["components"].([]interface{})[1])
+
+	// the checker is replaced
+	r.Register("ingestion", "es01", NewState(nil))
+	code, body = readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "healthy", body["state"])
+	assert.Len(t, body["components"], 2)
+}
+
+func TestCheckOptional(t *testing.T) {
+	r := NewRegistry()
+
+	ctx := WithRegistry(context.Background(), r)
+	Register(ctx, "ingestion", "es01", NewState(nil))
+	Register(WithOptional(ctx), "ingestion", "mirror01", NewState(errors.New("connection refused")))
+
+	// the optional component doesn't affect the readiness
+	healthy, status := r.Check(context.Background())
+	assert.True(t, healthy)
+	assert.Equal(t, Status{Component: "ingestion", Name: "mirror01", State: StateUnhealthy, Error: "connection refused", Optional: true}, status[1])
+
+	r.Register("ingestion", "es01", NewState(ErrStarting))
+	healthy, _ = r.Check(context.Background())
+	assert.False(t, healthy)
+}
+
+func TestHealthz(t *testing.T) {
+	r := NewRegistry()
+	r.Register("ingress", "grpc01", NewState(ErrStarting))
+
+	srv := httptest.NewServer(Handler(r))
+	defer srv.Close()
+
+	// the process is alive even if a component isn't ready
+	resp, err := http.Get(srv.URL + "/healthz")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRegisterWithoutRegistry(t *testing.T) {
+	assert.NotPanics(t, func() {
+		Register(context.Background(), "ingress", "grpc01", NewState(nil))
+	})
+}
@@ -3,32 +3,52 @@ package clickhouse
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	chgo "github.com/ClickHouse/clickhouse-go"
 	"go.uber.org/zap"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/egress/helper"
 	"github.com/mehrdadrad/tcpdog/geo"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
 type clickhouse struct {
+	name          string
+	label         string
 	geo           geo.Geoer
 	cfg           *chConfig
 	serialization string
 
 	vFields reflect.Value
+	retryCh chan []row
+}
+
+// driverName is the database/sql driver of the ingestion
+var driverName = "clickhouse"
+
+var errRetryQueueFull = errors.New("retry queue is full")
+
+// row represents the values of a record and its trace if it's sampled
+type row struct {
+	values []interface{}
+	trace  *tracing.Trace
 }
 
 // Start starts ingestion data to clickhouse
 func Start(ctx context.Context, name string, ser string, ch chan interface{}) error {
-	var g geo.Geoer
-
 	cfg := config.FromContextServer(ctx)
 
 	cCfg, err := clickhouseConfig(cfg.Ingestion[name].Config)
@@ -36,59 +56,108 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 		return err
 	}
 
-	connect, err := sql.Open("clickhouse", cCfg.DSName)
+	if len(cCfg.Fields) == 0 {
+		return fmt.Errorf("clickhouse %s fields have not been configured", name)
+	}
+
+	connect, err := sql.Open(driverName, cCfg.DSName)
 	if err != nil {
 		return err
 	}
 
 	if err := connect.Ping(); err != nil {
+		connect.Close()
 		if exception, ok := err.(*chgo.Exception); ok {
 			return fmt.Errorf("[%d] %s %s", exception.Code, exception.Message, exception.StackTrace)
 		}
 		return err
 	}
 
-	// if geo is available
-	if v, ok := geo.Reg[cfg.Geo.Type]; ok {
-		g = v
-		g.Init(cfg.Logger(), cfg.Geo.Config)
+	if err := provisionTable(ctx, name, cCfg, connect); err != nil {
+		connect.Close()
+		return err
+	}
+
+	health.Register(ctx, "ingestion", name, health.CheckerFunc(connect.PingContext))
+
+	c := clickhouse{
+		name:          name,
+		label:         metrics.Flow(ctx),
+		geo:           geo.FromContext(ctx),
+		cfg:           cCfg,
+		serialization: ser,
+		vFields:       reflect.ValueOf(&pb.Fields{}).Elem(),
+		retryCh:       make(chan []row, cCfg.RetryQueue),
 	}
+	iCh := make(chan row, 1000)
+
+	var wg sync.WaitGroup
 
-	c := clickhouse{geo: g, cfg: cCfg, serialization: ser, vFields: reflect.ValueOf(&pb.Fields{}).Elem()}
-	iCh := make(chan []interface{}, 1000)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.retry(ctx, connect)
+	}()
 
 	for i := 0; i < c.cfg.Workers; i++ {
 		go c.iWorker(ctx, ch, iCh)
 	}
 
 	for i := 0; i < c.cfg.Connections; i++ {
-		go c.ingest(ctx, connect, iCh)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.ingest(ctx, connect, iCh)
+		}()
 	}
 
+	// the connections are closed once the pending batches
+	// have been committed and the retries have been given up.
+	go func() {
+		wg.Wait()
+		connect.Close()
+	}()
+
 	return nil
 }
 
-func (c *clickhouse) iWorker(ctx context.Context, ch chan interface{}, iCh chan []interface{}) {
+func (c *clickhouse) iWorker(ctx context.Context, ch chan interface{}, iCh chan row) {
 	fn := c.getSliceIfMaker()
 	logger := config.FromContextServer(ctx).Logger()
+	lane := priority.FromContext(ctx)
 
 	for {
-		select {
-		case data := <-ch:
-			s, err := fn(data)
-			if err != nil {
-				logger.Error("clickhouse", zap.Error(err))
-				continue
-			}
+		data, ok := lane.Recv(ctx, ch)
+		if !ok {
+			return
+		}
+
+		tr := tracing.From(data)
+		tr.Begin("encode")
 
-			iCh <- s
+		s, err := fn(data)
+		if err != nil {
+			tr.Finish(err)
+			c.deadLetter(1)
+			logger.Error("clickhouse", zap.Error(err))
+			continue
+		}
+
+		tr.End("encode")
+		tr.Begin("queue")
+
+		// the ingest commits its pending batch once the context is
+		// done, the row which isn't queued yet is dropped.
+		select {
+		case iCh <- row{values: s, trace: tr}:
 		case <-ctx.Done():
+			tr.Finish(tracing.ErrDropped)
 			return
 		}
 	}
 }
 
-func (c *clickhouse) ingest(ctx context.Context, connect *sql.DB, iCh chan []interface{}) {
+func (c *clickhouse) ingest(ctx context.Context, connect *sql.DB, iCh chan row) {
 	query := c.getQuery()
 	logger := config.FromContextServer(ctx).Logger()
 	timer := time.NewTimer(time.Second * 10)
@@ -96,6 +165,8 @@ func (c *clickhouse) ingest(ctx context.Context, connect *sql.DB, iCh chan []int
 	interval := time.Second * time.Duration(c.cfg.FlushInterval)
 	counter := 0
 	timeoutCounter := 0
+	// batch is the rows of the transaction, it's retried if the commit fails
+	var batch []row
 
 OUTERLOOP:
 	for {
@@ -103,28 +174,41 @@ OUTERLOOP:
 		tx, err := connect.Begin()
 		if err != nil {
 			logger.Error("clickhouse-1", zap.Error(err))
-			backoff.Next()
+			if !backoff.Wait(ctx) {
+				return
+			}
 			continue
 		}
 
 		stmt, err := tx.Prepare(query)
 		if err != nil {
+			tx.Rollback()
 			logger.Error("clickhouse-2", zap.Error(err))
-			backoff.Next()
+			if !backoff.Wait(ctx) {
+				return
+			}
 			continue
 		}
 
 		counter = 0
 		timeoutCounter = 0
+		batch = nil
 		timer.Reset(interval)
 
 	INNERLOOP:
 		for {
 			select {
-			case fields := <-iCh:
-				_, err := stmt.ExecContext(ctx, fields...)
+			case r := <-iCh:
+				r.trace.End("queue")
+
+				_, err := stmt.ExecContext(ctx, r.values...)
 				if err != nil {
+					r.trace.Finish(err)
+					c.deadLetter(1)
 					logger.Error("clickhouse-3", zap.Error(err))
+				} else {
+					r.trace.Begin("batch wait")
+					batch = append(batch, r)
 				}
 
 				counter++
@@ -137,16 +221,22 @@ OUTERLOOP:
 				}
 				timer.Reset(interval)
 				if timeoutCounter++; timeoutCounter >= (300/c.cfg.FlushInterval)-1 {
+					tx.Rollback()
 					continue OUTERLOOP
 				}
 			case <-ctx.Done():
-				tx.Commit()
+				commit(tx, batch)
 				return
 			}
 		}
 
-		if err := tx.Commit(); err != nil {
+		start := time.Now()
+		if err := commit(tx, batch); err != nil {
 			logger.Error("clickhouse", zap.Error(err))
+			c.queueRetry(batch, logger)
+		} else {
+			metrics.ObserveBatch(c.label, c.name, start)
+			metrics.IngestionDocuments(c.label, c.name, len(batch))
 		}
 
 		if !timer.Stop() {
@@ -158,15 +248,136 @@ OUTERLOOP:
 	}
 }
 
+// queueRetry queues the failed batch for the retry, the ingest doesn't
+// wait for the retry thus the batch is dropped if the queue is full.
+func (c *clickhouse) queueRetry(batch []row, logger *zap.Logger) {
+	if len(batch) < 1 {
+		return
+	}
+
+	select {
+	case c.retryCh <- batch:
+	default:
+		finish(batch, time.Now(), errRetryQueueFull)
+		c.deadLetter(len(batch))
+		logger.Error("clickhouse", zap.Error(errRetryQueueFull), zap.Int("rows", len(batch)))
+	}
+}
+
+// retry inserts the failed batches again with the exponential backoff,
+// a batch is dropped once it has been failed maxRetries times.
+func (c *clickhouse) retry(ctx context.Context, connect *sql.DB) {
+	var (
+		query  = c.getQuery()
+		logger = config.FromContextServer(ctx).Logger()
+		batch  []row
+	)
+
+	for {
+		select {
+		case batch = <-c.retryCh:
+		case <-ctx.Done():
+			return
+		}
+
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-time.After(c.cfg.retryBackoff(attempt)):
+			case <-ctx.Done():
+				c.deadLetter(len(batch))
+				return
+			}
+
+			metrics.IngestionRetry(c.label, c.name)
+
+			err := insert(ctx, connect, query, batch)
+			if err == nil {
+				metrics.IngestionDocuments(c.label, c.name, len(batch))
+				logger.Info("clickhouse", zap.String("msg", fmt.Sprintf("%s batch has been retried", c.name)),
+					zap.Int("rows", len(batch)), zap.Int("attempt", attempt))
+				break
+			}
+
+			if attempt >= c.cfg.MaxRetries {
+				finish(batch, time.Now(), err)
+				c.deadLetter(len(batch))
+				logger.Error("clickhouse", zap.String("msg", c.name+" batch has been dropped"),
+					zap.Int("rows", len(batch)), zap.Error(err))
+				break
+			}
+
+			logger.Warn("clickhouse", zap.Int("attempt", attempt), zap.Error(err))
+		}
+	}
+}
+
+// deadLetter counts the rows which have been given up
+func (c *clickhouse) deadLetter(n int) {
+	drops.Add(drops.IngestionDeadLetter, c.name, uint64(n))
+	metrics.IngestionError(c.label, c.name, n)
+}
+
+// insert inserts the rows in a transaction
+func insert(ctx context.Context, connect *sql.DB, query string, rows []row) error {
+	tx, err := connect.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, r := range rows {
+		if _, err := stmt.ExecContext(ctx, r.values...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return commit(tx, rows)
+}
+
+// commit commits the transaction and finishes the traces of its rows,
+// the traces of a failed commit are finished once it's retried.
+func commit(tx *sql.Tx, rows []row) error {
+	start := time.Now()
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	finish(rows, start, nil)
+
+	return nil
+}
+
+// finish finishes the traces of the rows
+func finish(rows []row, start time.Time, err error) {
+	for _, r := range rows {
+		if r.trace == nil {
+			continue
+		}
+		r.trace.End("batch wait")
+		r.trace.Span("clickhouse.commit", start, time.Now())
+		r.trace.Finish(err)
+	}
+}
+
 func (c *clickhouse) JSON(fi interface{}) ([]interface{}, error) {
 	f := fi.(map[string]interface{})
 
 	if c.geo != nil {
-		if gv, ok := f[c.cfg.GeoField]; ok {
-			for k, v := range c.geo.Get(gv.(string)) {
+		tr := tracing.From(fi)
+		tr.Begin("geo")
+		if gv, ok := f[c.cfg.GeoField].(string); ok {
+			for k, v := range c.geo.Get(gv) {
 				f[k] = v
 			}
 		}
+		tr.End("geo")
 	}
 
 	a := make([]interface{}, len(c.cfg.Fields))
@@ -174,9 +385,29 @@ func (c *clickhouse) JSON(fi interface{}) ([]interface{}, error) {
 	for i, name := range c.cfg.Fields {
 		switch c.vFields.FieldByName(name).Type().Elem().Kind() {
 		case reflect.Uint32:
-			a[i] = uint32(f[name].(float64))
+			v, ok, err := number(f, name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				a[i] = uint32(v)
+			}
 		case reflect.Uint64:
-			a[i] = uint64(f[name].(float64))
+			v, ok, err := number(f, name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				a[i] = uint64(v)
+			}
+		case reflect.Float64:
+			v, ok, err := number(f, name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				a[i] = v
+			}
 		case reflect.String:
 			a[i] = f[name]
 		}
@@ -185,16 +416,33 @@ func (c *clickhouse) JSON(fi interface{}) ([]interface{}, error) {
 	return a, nil
 }
 
+// number returns the numeric field of a json record, it returns
+// false if the field is missing thus it's NULL, not zero.
+func number(f map[string]interface{}, name string) (float64, bool, error) {
+	if f[name] == nil {
+		return 0, false, nil
+	}
+
+	if v, ok := serialization.Number(f[name]); ok {
+		return v, true, nil
+	}
+
+	return 0, false, fmt.Errorf("invalid %s value: %v", name, f[name])
+}
+
 func (c *clickhouse) PB(fi interface{}) ([]interface{}, error) {
 	a := make([]interface{}, len(c.cfg.Fields))
 	v := reflect.ValueOf(fi.(*pb.Fields)).Elem()
 
 	geoKV := map[string]string{}
 	if c.geo != nil {
+		tr := tracing.From(fi)
+		tr.Begin("geo")
 		gv := v.FieldByName(c.cfg.GeoField)
 		if gv.IsValid() {
 			geoKV = c.geo.Get(gv.Elem().String())
 		}
+		tr.End("geo")
 	}
 
 	for i, name := range c.cfg.Fields {
@@ -204,6 +452,8 @@ func (c *clickhouse) PB(fi interface{}) ([]interface{}, error) {
 				a[i] = uint32(v.FieldByName(name).Elem().Uint())
 			case reflect.Uint64:
 				a[i] = v.FieldByName(name).Elem().Uint()
+			case reflect.Float64:
+				a[i] = v.FieldByName(name).Elem().Float()
 			case reflect.String:
 				a[i] = v.FieldByName(name).Elem().String()
 			}
@@ -216,28 +466,39 @@ func (c *clickhouse) PB(fi interface{}) ([]interface{}, error) {
 }
 
 func (c *clickhouse) SPB(fi interface{}) ([]interface{}, error) {
-	f := fi.(*pb.FieldsSPB)
+	f := fi.(*pb.FieldsSPB).GetFields().GetFields()
 	a := make([]interface{}, len(c.cfg.Fields))
 
 	geoKV := map[string]string{}
 	if c.geo != nil {
-		gv, ok := f.Fields.Fields[c.cfg.GeoField]
+		tr := tracing.From(fi)
+		tr.Begin("geo")
+		gv, ok := f[c.cfg.GeoField]
 		if ok {
 			geoKV = c.geo.Get(gv.GetStringValue())
 		}
+		tr.End("geo")
 	}
 
 	for i, name := range c.cfg.Fields {
 		switch c.vFields.FieldByName(name).Type().Elem().Kind() {
 		case reflect.Uint32:
-			a[i] = uint32(f.Fields.Fields[name].GetNumberValue())
+			if value, ok := f[name]; ok {
+				a[i] = uint32(value.GetNumberValue())
+			}
 		case reflect.Uint64:
-			a[i] = uint64(f.Fields.Fields[name].GetNumberValue())
+			if value, ok := f[name]; ok {
+				a[i] = uint64(value.GetNumberValue())
+			}
+		case reflect.Float64:
+			if value, ok := f[name]; ok {
+				a[i] = value.GetNumberValue()
+			}
 		case reflect.String:
-			if value, ok := f.Fields.Fields[name]; ok {
+			if value, ok := f[name]; ok {
 				a[i] = value.GetStringValue()
-			} else {
-				a[i] = geoKV[name]
+			} else if g, ok := geoKV[name]; ok {
+				a[i] = g
 			}
 		}
 	}
@@ -258,7 +519,7 @@ func (c *clickhouse) getQuery() string {
 
 func (c *clickhouse) getSliceIfMaker() func(fi interface{}) ([]interface{}, error) {
 	switch c.serialization {
-	case "json":
+	case "json", "msgpack", "cbor":
 		return c.JSON
 	case "spb":
 		return c.SPB
@@ -1,14 +1,19 @@
 package clickhouse
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"net"
 	"reflect"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/geo"
 	pb "github.com/mehrdadrad/tcpdog/proto"
 	"github.com/stretchr/testify/assert"
@@ -20,13 +25,13 @@ import (
 
 type geoMock struct{}
 
-func (g *geoMock) Init(l *zap.Logger, cfg map[string]string) {}
-func (g *geoMock) Get(s string) map[string]string            { return map[string]string{"City": "Los_Angeles"} }
+func (g *geoMock) Init(l *zap.Logger, cfg map[string]string) error { return nil }
+func (g *geoMock) Get(s string) map[string]string                  { return map[string]string{"City": "Los_Angeles"} }
 
 func TestStart(t *testing.T) {
-	geo.Reg["foo"] = &geoMock{}
-
+	// the mock server doesn't answer the provisioning queries
 	cfg := &config.ServerConfig{
+		Provisioning: "off",
 		Geo: config.Geo{
 			Type: "foo",
 		},
@@ -84,7 +89,7 @@ func TestStart(t *testing.T) {
 	time.Sleep(time.Second)
 
 	ctx, cancel := context.WithCancel(context.Background())
-	ctx = cfg.WithContext(ctx)
+	ctx = geo.WithContext(cfg.WithContext(ctx), &geoMock{})
 
 	ch := make(chan interface{}, 10)
 
@@ -131,6 +136,38 @@ func TestJSON(t *testing.T) {
 	assert.Equal(t, "Los_Angeles", r[4].(string))
 }
 
+func TestIWorkerDeadLetter(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	c := clickhouse{
+		name:          "ch-deadletter",
+		cfg:           &chConfig{Fields: []string{"RTT", "SAddr"}},
+		serialization: "json",
+		vFields:       reflect.ValueOf(&pb.Fields{}).Elem(),
+	}
+	n := drops.Count(drops.IngestionDeadLetter, "ch-deadletter")
+	ch := make(chan interface{}, 2)
+	iCh := make(chan row, 1)
+
+	go c.iWorker(ctx, ch, iCh)
+
+	// the bad record is dead-lettered and the next one is queued
+	ch <- map[string]interface{}{"RTT": "foo", "SAddr": "10.0.0.1"}
+	ch <- map[string]interface{}{"RTT": 5.0, "SAddr": "10.0.0.1"}
+
+	select {
+	case r := <-iCh:
+		assert.Equal(t, []interface{}{uint32(5), "10.0.0.1"}, r.values)
+	case <-time.After(time.Second):
+		t.Fatal("time exceeded")
+	}
+	assert.Equal(t, n+1, drops.Count(drops.IngestionDeadLetter, "ch-deadletter"))
+}
+
 func TestPB(t *testing.T) {
 	c := clickhouse{
 		geo:           &geoMock{},
@@ -209,4 +246,289 @@ func TestClickhouseConfig(t *testing.T) {
 	chCfg, err := clickhouseConfig(cfg)
 	assert.NoError(t, err)
 	assert.Contains(t, chCfg.DSName, "tls_config=tcpdog")
+
+	chCfg, err = clickhouseConfig(map[string]interface{}{
+		"addr":     "10.0.0.1:9000",
+		"database": "tcpdog",
+		"username": "foo",
+		"password": "bar",
+		"fields":   []string{"RTT", "SAddr"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://10.0.0.1:9000?database=tcpdog&password=bar&username=foo", chCfg.DSName)
+	assert.Equal(t, []string{"RTT", "SAddr"}, chCfg.Columns)
+
+	_, err = clickhouseConfig(map[string]interface{}{"fields": []string{"RTT"}, "columns": []string{"rtt", "saddr"}})
+	assert.EqualError(t, err, "table tcpdog columns and fields are not matched")
+}
+
+func TestMissingFields(t *testing.T) {
+	c := clickhouse{
+		cfg:     &chConfig{Fields: []string{"RTT", "BytesSent", "SAddr", "TotalRetrans"}},
+		vFields: reflect.ValueOf(&pb.Fields{}).Elem(),
+	}
+
+	b := []byte(`{"RTT":0,"Timestamp":1611118090}`)
+	expected := []interface{}{uint32(0), nil, nil, nil}
+
+	m := map[string]interface{}{}
+	json.Unmarshal(b, &m)
+	r, err := c.JSON(m)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, r)
+
+	spb, _ := structpb.NewStruct(m)
+	r, err = c.SPB(&pb.FieldsSPB{Fields: spb})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, r)
+
+	p := pb.Fields{}
+	protojson.Unmarshal(b, &p)
+	r, err = c.PB(&p)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, r)
+}
+
+// mockDriver records the prepared queries and the executed arguments
+type mockDriver struct {
+	sync.Mutex
+	queries []string
+	args    [][]interface{}
+	commits int
+	fails   int
+}
+
+type mockConn struct{ d *mockDriver }
+type mockStmt struct{ d *mockDriver }
+
+// mockProxy is the registered driver which opens the mock driver of the test
+type mockProxy struct {
+	sync.Mutex
+	d *mockDriver
+}
+
+var mock = &mockProxy{}
+
+func init() {
+	sql.Register("clickhouse-mock", mock)
+}
+
+func (p *mockProxy) Open(string) (driver.Conn, error) {
+	p.Lock()
+	defer p.Unlock()
+	return &mockConn{p.d}, nil
+}
+
+// useMock sets the mock driver of the ingestion
+func useMock(d *mockDriver) func() {
+	mock.Lock()
+	mock.d = d
+	mock.Unlock()
+
+	driverName = "clickhouse-mock"
+
+	return func() { driverName = "clickhouse" }
+}
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.Lock()
+	defer c.d.Unlock()
+	c.d.queries = append(c.d.queries, query)
+	return &mockStmt{c.d}, nil
+}
+func (c *mockConn) Close() error              { return nil }
+func (c *mockConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *mockConn) Commit() error {
+	c.d.Lock()
+	defer c.d.Unlock()
+	if c.d.fails != 0 {
+		c.d.fails--
+		return errors.New("connection reset by peer")
+	}
+	c.d.commits++
+	return nil
+}
+func (c *mockConn) Rollback() error { return nil }
+
+func (s *mockStmt) Close() error                              { return nil }
+func (s *mockStmt) NumInput() int                             { return -1 }
+func (s *mockStmt) CheckNamedValue(*driver.NamedValue) error  { return nil }
+func (s *mockStmt) Query([]driver.Value) (driver.Rows, error) { return nil, driver.ErrSkip }
+func (s *mockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.Lock()
+	defer s.d.Unlock()
+	a := make([]interface{}, len(args))
+	for i := range args {
+		a[i] = args[i]
+	}
+	s.d.args = append(s.d.args, a)
+	return driver.RowsAffected(1), nil
+}
+
+func TestIngest(t *testing.T) {
+	d := &mockDriver{}
+	defer useMock(d)()
+
+	cfg := &config.ServerConfig{
+		Provisioning: "off",
+		Ingestion: map[string]config.Ingestion{
+			"ch01": {
+				Config: map[string]interface{}{
+					"addr":      "127.0.0.1:9000",
+					"database":  "tcpdog",
+					"batchSize": 3,
+					"workers":   1,
+					"fields":    []string{"RTT", "SAddr", "TotalRetrans"},
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan interface{}, 3)
+	assert.NoError(t, Start(ctx, "ch01", "json", ch))
+
+	for _, b := range []string{
+		`{"RTT":5,"SAddr":"10.0.0.1","TotalRetrans":1}`,
+		`{"RTT":7,"SAddr":"10.0.0.2"}`,
+		`{"RTT":9,"SAddr":"10.0.0.3","TotalRetrans":0}`,
+	} {
+		m := map[string]interface{}{}
+		json.Unmarshal([]byte(b), &m)
+		ch <- m
+	}
+
+	assert.Eventually(t, func() bool {
+		d.Lock()
+		defer d.Unlock()
+		return d.commits > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d.Lock()
+	defer d.Unlock()
+
+	assert.Equal(t, "INSERT INTO tcpdog (RTT,SAddr,TotalRetrans) VALUES (?,?,?)", d.queries[0])
+	assert.Equal(t, [][]interface{}{
+		{uint32(5), "10.0.0.1", uint32(1)},
+		{uint32(7), "10.0.0.2", nil},
+		{uint32(9), "10.0.0.3", uint32(0)},
+	}, d.args)
+
+	// the fields are required
+	cfg.Ingestion["ch01"].Config["fields"] = nil
+	assert.EqualError(t, Start(ctx, "ch01", "json", ch), "clickhouse ch01 fields have not been configured")
+}
+
+func TestRetry(t *testing.T) {
+	d := &mockDriver{fails: 2}
+	defer useMock(d)()
+
+	cfg := &config.ServerConfig{
+		Provisioning: "off",
+		Ingestion: map[string]config.Ingestion{
+			"ch01": {
+				Config: map[string]interface{}{
+					"batchSize":    2,
+					"workers":      1,
+					"retryBackoff": 10,
+					"maxRetries":   2,
+					"fields":       []string{"RTT"},
+				},
+			},
+			"ch02": {
+				Config: map[string]interface{}{
+					"batchSize":    1,
+					"workers":      1,
+					"retryBackoff": 10,
+					"maxRetries":   2,
+					"fields":       []string{"RTT"},
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	// the batch commit and the first retry fail
+	ch := make(chan interface{}, 2)
+	assert.NoError(t, Start(ctx, "ch01", "json", ch))
+
+	ch <- map[string]interface{}{"RTT": 5.0}
+	ch <- map[string]interface{}{"RTT": 7.0}
+
+	assert.Eventually(t, func() bool {
+		d.Lock()
+		defer d.Unlock()
+		return d.commits == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d.Lock()
+	assert.Len(t, d.args, 6)
+	assert.Equal(t, []interface{}{uint32(5)}, d.args[4])
+	assert.Equal(t, []interface{}{uint32(7)}, d.args[5])
+	d.fails = -1
+	d.Unlock()
+
+	assert.Equal(t, uint64(0), drops.Count(drops.IngestionDeadLetter, "ch01"))
+
+	// the batch is dropped after the max retries
+	ch = make(chan interface{}, 1)
+	assert.NoError(t, Start(ctx, "ch02", "json", ch))
+
+	ch <- map[string]interface{}{"RTT": 5.0}
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.IngestionDeadLetter, "ch02") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c := &chConfig{RetryBackoff: 1000, MaxRetryBackoff: 3000}
+	assert.Equal(t, time.Second, c.retryBackoff(1))
+	assert.Equal(t, 2*time.Second, c.retryBackoff(2))
+	assert.Equal(t, 3*time.Second, c.retryBackoff(3))
+}
+
+func TestStartLeak(t *testing.T) {
+	d := &mockDriver{}
+	defer useMock(d)()
+
+	cfg := &config.ServerConfig{
+		Provisioning: "off",
+		Ingestion: map[string]config.Ingestion{
+			"ch01": {
+				Config: map[string]interface{}{
+					"batchSize":   10,
+					"workers":     2,
+					"connections": 2,
+					"fields":      []string{"RTT"},
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	ch := make(chan interface{}, 1)
+	assert.NoError(t, Start(ctx, "ch01", "json", ch))
+
+	ch <- map[string]interface{}{"RTT": 5.0}
+	assert.Eventually(t, func() bool { return len(ch) == 0 }, time.Second, time.Millisecond)
+
+	// the connections commit their pending batches at the shutdown
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		d.Lock()
+		defer d.Unlock()
+		return d.commits == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d.Lock()
+	defer d.Unlock()
+	assert.Equal(t, [][]interface{}{{uint32(5)}}, d.args)
 }
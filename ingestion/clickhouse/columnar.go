@@ -0,0 +1,221 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	chgo "github.com/ClickHouse/clickhouse-go"
+	"github.com/ClickHouse/clickhouse-go/lib/data"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/columnar"
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/geo"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+// Fields returns the fields of the ingestion, they're
+// the schema of the flow's columnar batches.
+func Fields(ctx context.Context, name string) ([]string, error) {
+	cfg := config.FromContextServer(ctx)
+
+	cCfg, err := clickhouseConfig(cfg.Ingestion[name].Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return cCfg.Fields, nil
+}
+
+// StartColumnar starts ingestion of the columnar batches to clickhouse,
+// a batch is written as a native block column by column.
+func StartColumnar(ctx context.Context, name string, ch chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+
+	cCfg, err := clickhouseConfig(cfg.Ingestion[name].Config)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("clickhouse", cCfg.DSName)
+	if err != nil {
+		return err
+	}
+
+	if err = provisionTable(ctx, name, cCfg, db); err != nil {
+		db.Close()
+		return err
+	}
+
+	// the database is kept for the health checks
+	health.Register(ctx, "ingestion", name, health.CheckerFunc(db.PingContext))
+
+	go func() {
+		<-ctx.Done()
+		db.Close()
+	}()
+
+	c := clickhouse{name: name, label: metrics.Flow(ctx), geo: geo.FromContext(ctx), cfg: cCfg}
+
+	for i := 0; i < c.cfg.Connections; i++ {
+		connect, err := chgo.OpenDirect(cCfg.DSName)
+		if err != nil {
+			return err
+		}
+
+		go c.ingestBlocks(ctx, connect, ch)
+	}
+
+	return nil
+}
+
+func (c *clickhouse) ingestBlocks(ctx context.Context, connect chgo.Clickhouse, ch chan interface{}) {
+	query := c.getQuery()
+	logger := config.FromContextServer(ctx).Logger()
+	backoff := helper.NewBackoff(logger)
+
+	defer connect.Close()
+
+	for {
+		select {
+		case b := <-ch:
+			batch, ok := b.(*columnar.Batch)
+			if !ok || batch.Len() < 1 {
+				continue
+			}
+
+			start := time.Now()
+			if err := c.insert(connect, query, batch); err != nil {
+				c.deadLetter(batch.Len())
+				logger.Error("clickhouse", zap.Error(err), zap.Int("dropped", batch.Len()))
+				connect.Rollback()
+				if !backoff.Wait(ctx) {
+					return
+				}
+				continue
+			}
+
+			metrics.ObserveBatch(c.label, c.name, start)
+			metrics.IngestionDocuments(c.label, c.name, batch.Len())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *clickhouse) insert(connect chgo.Clickhouse, query string, batch *columnar.Batch) error {
+	if _, err := connect.Begin(); err != nil {
+		return err
+	}
+
+	if _, err := connect.Prepare(query); err != nil {
+		return err
+	}
+
+	block, err := connect.Block()
+	if err != nil {
+		return err
+	}
+
+	if err := c.writeBlock(block, batch); err != nil {
+		return err
+	}
+
+	return connect.Commit()
+}
+
+// writeBlock maps the batch columns to the block columns, the
+// missing values are NULL if the column is Nullable.
+func (c *clickhouse) writeBlock(block *data.Block, batch *columnar.Batch) error {
+	if len(block.Columns) != len(batch.Columns) {
+		return fmt.Errorf("block: expected %d columns, got %d", len(block.Columns), len(batch.Columns))
+	}
+
+	block.Reserve()
+	block.NumRows = uint64(batch.Len())
+
+	var geos []map[string]string
+	if g := batch.Column(c.cfg.GeoField); c.geo != nil && g != nil {
+		geos = make([]map[string]string, batch.Len())
+		for i := range geos {
+			if !g.IsNull(i) {
+				geos[i] = c.geo.Get(g.String(i))
+			}
+		}
+	}
+
+	for i, col := range batch.Columns {
+		nullable := strings.HasPrefix(block.Columns[i].CHType(), "Nullable(")
+		if err := writeColumn(block, i, col, nullable, geos); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeColumn(block *data.Block, i int, col *columnar.Column, nullable bool, geos []map[string]string) error {
+	var err error
+
+	for row := 0; row < col.Len() && err == nil; row++ {
+		null := col.IsNull(row)
+
+		switch col.Type {
+		case columnar.Uint32:
+			v := col.Uint32(row)
+			switch {
+			case !nullable:
+				err = block.WriteUInt32(i, v)
+			case null:
+				err = block.WriteUInt32Nullable(i, nil)
+			default:
+				err = block.WriteUInt32Nullable(i, &v)
+			}
+		case columnar.Uint64:
+			v := col.Uint64(row)
+			switch {
+			case !nullable:
+				err = block.WriteUInt64(i, v)
+			case null:
+				err = block.WriteUInt64Nullable(i, nil)
+			default:
+				err = block.WriteUInt64Nullable(i, &v)
+			}
+		case columnar.String:
+			v := col.String(row)
+			if null && geos != nil {
+				if g, ok := geos[row][col.Name]; ok {
+					v, null = g, false
+				}
+			}
+			switch {
+			case !nullable:
+				err = block.WriteString(i, v)
+			case null:
+				err = block.WriteStringNullable(i, nil)
+			default:
+				err = block.WriteStringNullable(i, &v)
+			}
+		case columnar.Bool:
+			var v uint8
+			if col.Bool(row) {
+				v = 1
+			}
+			switch {
+			case !nullable:
+				err = block.WriteUInt8(i, v)
+			case null:
+				err = block.WriteUInt8Nullable(i, nil)
+			default:
+				err = block.WriteUInt8Nullable(i, &v)
+			}
+		}
+	}
+
+	return err
+}
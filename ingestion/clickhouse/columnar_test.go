@@ -0,0 +1,186 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	chgo "github.com/ClickHouse/clickhouse-go"
+	"github.com/ClickHouse/clickhouse-go/lib/binary"
+	"github.com/ClickHouse/clickhouse-go/lib/column"
+	"github.com/ClickHouse/clickhouse-go/lib/data"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/columnar"
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+var (
+	benchFields  = []string{"RTT", "BytesSent", "SAddr", "DAddr", "TotalRetrans", "Timestamp", "City"}
+	benchTypes   = []string{"UInt32", "Nullable(UInt64)", "String", "String", "Nullable(UInt32)", "UInt64", "Nullable(String)"}
+	benchRecords = []string{
+		`{"RTT":12345,"BytesSent":0,"SAddr":"10.0.0.1","DAddr":"10.0.0.2","TotalRetrans":2,"Timestamp":1611118090}`,
+		`{"RTT":0,"SAddr":"10.0.0.3","DAddr":"10.0.0.4","Timestamp":1611118091}`,
+	}
+)
+
+func newBlock(t testing.TB, fields, types []string) *data.Block {
+	block := &data.Block{NumColumns: uint64(len(fields))}
+	for i, name := range fields {
+		c, err := column.Factory(name, types[i], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Columns = append(block.Columns, c)
+	}
+
+	return block
+}
+
+func encode(t testing.TB, block *data.Block) []byte {
+	buf := &bytes.Buffer{}
+	if err := block.Write(&data.ServerInfo{}, binary.NewEncoder(buf)); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func records(n int) []map[string]interface{} {
+	r := make([]map[string]interface{}, n)
+	for i := range r {
+		r[i] = map[string]interface{}{}
+		json.Unmarshal([]byte(benchRecords[i%len(benchRecords)]), &r[i])
+	}
+
+	return r
+}
+
+func TestWriteBlock(t *testing.T) {
+	c := &clickhouse{
+		geo:     &geoMock{},
+		cfg:     &chConfig{GeoField: "SAddr", Fields: benchFields},
+		vFields: reflect.ValueOf(&pb.Fields{}).Elem(),
+	}
+
+	// the rows block
+	rows := newBlock(t, benchFields, benchTypes)
+	for _, r := range records(4) {
+		a, err := c.JSON(r)
+		assert.NoError(t, err)
+
+		v := make([]driver.Value, len(a))
+		for i := range a {
+			v[i] = a[i]
+		}
+		assert.NoError(t, rows.AppendRow(v))
+	}
+
+	// the columnar block
+	schema, err := columnar.NewSchema(benchFields)
+	assert.NoError(t, err)
+	b := columnar.NewBuilder(schema, 4)
+	for _, r := range records(4) {
+		assert.NoError(t, b.Append(r))
+	}
+
+	columns := newBlock(t, benchFields, benchTypes)
+	assert.NoError(t, c.writeBlock(columns, b.NewBatch()))
+
+	assert.Equal(t, encode(t, rows), encode(t, columns))
+
+	err = c.writeBlock(newBlock(t, benchFields[:2], benchTypes[:2]), b.NewBatch())
+	assert.EqualError(t, err, "block: expected 2 columns, got 7")
+}
+
+func BenchmarkRows(b *testing.B) {
+	c := &clickhouse{
+		cfg:     &chConfig{Fields: benchFields},
+		vFields: reflect.ValueOf(&pb.Fields{}).Elem(),
+	}
+
+	rs := records(1000)
+	v := make([]driver.Value, len(benchFields))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		block := newBlock(b, benchFields, benchTypes)
+		for _, r := range rs {
+			a, _ := c.JSON(r)
+			for j := range a {
+				v[j] = a[j]
+			}
+			block.AppendRow(v)
+		}
+	}
+}
+
+func BenchmarkColumnar(b *testing.B) {
+	c := &clickhouse{cfg: &chConfig{Fields: benchFields}}
+
+	rs := records(1000)
+	schema, _ := columnar.NewSchema(benchFields)
+	builder := columnar.NewBuilder(schema, len(rs))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, r := range rs {
+			builder.Append(r)
+		}
+		c.writeBlock(newBlock(b, benchFields, benchTypes), builder.NewBatch())
+	}
+}
+
+// failedConn fails to begin the transactions
+type failedConn struct {
+	chgo.Clickhouse
+	closed chan struct{}
+}
+
+func (failedConn) Begin() (driver.Tx, error) { return nil, errors.New("connection refused") }
+
+func (failedConn) Rollback() error { return nil }
+
+func (f failedConn) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestIngestBlocksDrop(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("columnar")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	c := &clickhouse{name: "drop", cfg: &chConfig{Fields: benchFields}}
+	schema, err := columnar.NewSchema(benchFields)
+	assert.NoError(t, err)
+	b := columnar.NewBuilder(schema, 4)
+	for _, r := range records(3) {
+		assert.NoError(t, b.Append(r))
+	}
+
+	ch := make(chan interface{}, 1)
+	ch <- b.NewBatch()
+
+	conn := failedConn{closed: make(chan struct{})}
+	go c.ingestBlocks(ctx, conn, ch)
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.IngestionDeadLetter, "drop") == 3
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-conn.closed
+}
@@ -1,7 +1,9 @@
 package clickhouse
 
 import (
+	"fmt"
 	"net/url"
+	"time"
 
 	chgo "github.com/ClickHouse/clickhouse-go"
 
@@ -13,6 +15,17 @@ type chConfig struct {
 	Table    string
 	GeoField string
 
+	// Addr is the native protocol address e.g. 127.0.0.1:9000,
+	// the data source name is made of it and the credentials
+	// if it's configured.
+	Addr     string
+	Database string
+	Username string
+	Password string
+
+	// the missing fields are NULL, the Nullable columns
+	// keep them apart from the measured zero values. the
+	// columns are named after the fields if they're not set.
 	Columns []string
 	Fields  []string
 
@@ -23,6 +36,14 @@ type chConfig struct {
 	FlushInterval int
 	ConnTimeout   int
 
+	// the failed batches are retried up to MaxRetries times with
+	// the backoff (milliseconds) which is doubled per attempt up to
+	// the MaxRetryBackoff, up to RetryQueue batches wait for the retry.
+	MaxRetries      int
+	RetryBackoff    int
+	MaxRetryBackoff int
+	RetryQueue      int
+
 	TLSConfig config.TLSConfig // TLS configuration
 }
 
@@ -37,12 +58,33 @@ func clickhouseConfig(cfg map[string]interface{}) (*chConfig, error) {
 		BatchSize:     100,
 		FlushInterval: 2,
 		ConnTimeout:   300,
+
+		MaxRetries:      5,
+		RetryBackoff:    1000,
+		MaxRetryBackoff: 30000,
+		RetryQueue:      10,
 	}
 
 	if err := config.Transform(cfg, chConfig); err != nil {
 		return nil, err
 	}
 
+	if chConfig.Addr != "" {
+		chConfig.DSName = dsName(chConfig)
+	}
+
+	if len(chConfig.Columns) == 0 {
+		chConfig.Columns = chConfig.Fields
+	}
+
+	if chConfig.RetryQueue < 1 {
+		chConfig.RetryQueue = 1
+	}
+
+	if len(chConfig.Columns) != len(chConfig.Fields) {
+		return nil, fmt.Errorf("table %s columns and fields are not matched", chConfig.Table)
+	}
+
 	if chConfig.TLSConfig.Enable {
 		tlsConfig, err := config.GetTLS(&chConfig.TLSConfig)
 		if err != nil {
@@ -61,6 +103,40 @@ func clickhouseConfig(cfg map[string]interface{}) (*chConfig, error) {
 	return chConfig, nil
 }
 
+// retryBackoff returns the backoff of the retry attempt
+func (c *chConfig) retryBackoff(attempt int) time.Duration {
+	d := time.Duration(c.RetryBackoff) * time.Millisecond
+	max := time.Duration(c.MaxRetryBackoff) * time.Millisecond
+
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d
+}
+
+// dsName returns the data source name of the address
+func dsName(c *chConfig) string {
+	q := url.Values{}
+	if c.Database != "" {
+		q.Set("database", c.Database)
+	}
+	if c.Username != "" {
+		q.Set("username", c.Username)
+	}
+	if c.Password != "" {
+		q.Set("password", c.Password)
+	}
+
+	u := url.URL{Scheme: "tcp", Host: c.Addr, RawQuery: q.Encode()}
+
+	return u.String()
+}
+
 func addQString(dsName, key, value string) (string, error) {
 	u, err := url.Parse(dsName)
 	if err != nil {
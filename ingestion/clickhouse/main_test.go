@@ -0,0 +1,13 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+// TestMain checks the goroutines which have been left by the tests,
+// the clickhouse driver starts a global goroutine at init.
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m, goleak.IgnoreTopFunction("github.com/ClickHouse/clickhouse-go.init.0.func1"))
+}
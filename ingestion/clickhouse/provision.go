@@ -0,0 +1,94 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/provision"
+)
+
+// chTypes maps the fields types to the clickhouse types
+var chTypes = map[reflect.Kind]string{
+	reflect.Uint32:  "UInt32",
+	reflect.Uint64:  "UInt64",
+	reflect.String:  "String",
+	reflect.Bool:    "UInt8",
+	reflect.Float64: "Float64",
+}
+
+// provisionTable creates or verifies the table of the ingestion
+// based on the server provisioning mode.
+func provisionTable(ctx context.Context, name string, cCfg *chConfig, db *sql.DB) error {
+	cfg := config.FromContextServer(ctx)
+	objects := []provision.Object{tableObject(cCfg, db)}
+
+	return provision.Ensure(ctx, cfg.Provisioning, name, objects, cfg.Logger())
+}
+
+// tableObject returns the table object, the table is created with
+// the configured columns and it's sorted by the timestamp if it has.
+func tableObject(cCfg *chConfig, db *sql.DB) provision.Object {
+	o := provision.Object{
+		Kind: "table",
+		Name: cCfg.Table,
+		Exists: func(ctx context.Context) (bool, error) {
+			var exists uint8
+			err := db.QueryRowContext(ctx, "EXISTS TABLE "+cCfg.Table).Scan(&exists)
+			return exists == 1, err
+		},
+	}
+
+	ddl, err := createTable(cCfg)
+	if err != nil {
+		return o
+	}
+
+	o.Create = func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, ddl)
+		return err
+	}
+
+	return o
+}
+
+// createTable returns the table DDL, the columns are Nullable
+// except the timestamp which is the sorting key.
+func createTable(cCfg *chConfig) (string, error) {
+	if len(cCfg.Columns) < 1 || len(cCfg.Columns) != len(cCfg.Fields) {
+		return "", fmt.Errorf("table %s columns and fields are not matched", cCfg.Table)
+	}
+
+	var (
+		t       = reflect.TypeOf(pb.Fields{})
+		columns = make([]string, len(cCfg.Columns))
+		orderBy = "tuple()"
+	)
+
+	for i, field := range cCfg.Fields {
+		sf, ok := t.FieldByName(field)
+		if !ok || sf.Type.Kind() != reflect.Ptr {
+			return "", fmt.Errorf("field %s is not available", field)
+		}
+
+		chType, ok := chTypes[sf.Type.Elem().Kind()]
+		if !ok {
+			return "", fmt.Errorf("field %s type is not supported", field)
+		}
+
+		if field == "Timestamp" {
+			orderBy = cCfg.Columns[i]
+		} else {
+			chType = "Nullable(" + chType + ")"
+		}
+
+		columns[i] = cCfg.Columns[i] + " " + chType
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = MergeTree() ORDER BY %s",
+		cCfg.Table, strings.Join(columns, ", "), orderBy), nil
+}
@@ -0,0 +1,149 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/provision"
+)
+
+// fakeDriver answers the EXISTS TABLE queries and records the DDLs
+type fakeDriver struct {
+	sync.Mutex
+	tables map[string]bool
+	ddls   []string
+}
+
+var fake = &fakeDriver{}
+
+func init() {
+	sql.Register("clickhouse-fake", fake)
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+func (d *fakeDriver) reset(tables ...string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.tables, d.ddls = map[string]bool{}, nil
+	for _, t := range tables {
+		d.tables[t] = true
+	}
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.d, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.d.Lock()
+	defer s.d.Unlock()
+
+	s.d.ddls = append(s.d.ddls, s.query)
+
+	return driver.RowsAffected(0), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.d.Lock()
+	defer s.d.Unlock()
+
+	table := strings.TrimPrefix(s.query, "EXISTS TABLE ")
+	if s.d.tables[table] {
+		return &fakeRows{v: 1}, nil
+	}
+
+	return &fakeRows{v: 0}, nil
+}
+
+type fakeRows struct {
+	v    int64
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"result"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.v
+
+	return nil
+}
+
+func TestProvisionTable(t *testing.T) {
+	cCfg := &chConfig{
+		Table:   "tcpdog",
+		Fields:  []string{"RTT", "SAddr", "Timestamp"},
+		Columns: []string{"rtt", "saddr", "ts"},
+	}
+
+	db, err := sql.Open("clickhouse-fake", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ddl := "CREATE TABLE IF NOT EXISTS tcpdog (rtt Nullable(UInt32), saddr Nullable(String), ts UInt64) " +
+		"ENGINE = MergeTree() ORDER BY ts"
+
+	for _, tc := range []struct {
+		mode   string
+		tables []string
+		err    string
+		ddls   []string
+	}{
+		{mode: provision.Auto, ddls: []string{ddl}},
+		{mode: provision.Auto, tables: []string{"tcpdog"}},
+		{mode: provision.Verify, err: "ingestion foo: missing table tcpdog (would create)"},
+		{mode: provision.Verify, tables: []string{"tcpdog"}},
+		{mode: provision.Off},
+	} {
+		fake.reset(tc.tables...)
+
+		cfg := &config.ServerConfig{Provisioning: tc.mode}
+		cfg.SetMockLogger("provision")
+		ctx := cfg.WithContext(context.Background())
+
+		err := provisionTable(ctx, "foo", cCfg, db)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.mode)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.mode)
+		}
+		assert.Equal(t, tc.ddls, fake.ddls, tc.mode)
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	ddl, err := createTable(&chConfig{Table: "t", Fields: []string{"RTT", "Task"}, Columns: []string{"rtt", "task"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS t (rtt Nullable(UInt32), task Nullable(String)) ENGINE = MergeTree() ORDER BY tuple()", ddl)
+
+	_, err = createTable(&chConfig{Table: "t", Fields: []string{"RTT"}})
+	assert.EqualError(t, err, "table t columns and fields are not matched")
+
+	_, err = createTable(&chConfig{Table: "t", Fields: []string{"Foo"}, Columns: []string{"foo"}})
+	assert.EqualError(t, err, "field Foo is not available")
+}
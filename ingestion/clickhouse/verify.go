@@ -0,0 +1,59 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	chgo "github.com/ClickHouse/clickhouse-go"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Verify verifies the connectivity of the clickhouse ingestion and
+// the table columns by an empty select, it doesn't write anything.
+func Verify(ctx context.Context, name string, report config.VerifyFunc) error {
+	cfg := config.FromContextServer(ctx)
+
+	cCfg, err := clickhouseConfig(cfg.Ingestion[name].Config)
+	if err != nil {
+		report("config", "", err)
+		return err
+	}
+
+	report("config", "table "+cCfg.Table, nil)
+
+	connect, err := sql.Open("clickhouse", cCfg.DSName)
+	if err != nil {
+		report("connectivity", "", err)
+		return err
+	}
+	defer connect.Close()
+
+	if err := connect.PingContext(ctx); err != nil {
+		if exception, ok := err.(*chgo.Exception); ok {
+			err = fmt.Errorf("[%d] %s", exception.Code, exception.Message)
+		}
+		report("connectivity", "", err)
+		return err
+	}
+
+	report("connectivity", "ping", nil)
+
+	columns := "*"
+	if len(cCfg.Columns) > 0 {
+		columns = strings.Join(cCfg.Columns, ",")
+	}
+
+	rows, err := connect.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s LIMIT 0", columns, cCfg.Table))
+	if err != nil {
+		report("table", "", err)
+		return err
+	}
+	rows.Close()
+
+	report("table", fmt.Sprintf("columns %s exist", columns), nil)
+
+	return nil
+}
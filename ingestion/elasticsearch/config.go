@@ -1,14 +1,32 @@
 package elasticsearch
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/fault"
 )
 
+// maxRetryBackoff is the max backoff of the rejected requests
+const maxRetryBackoff = 30 * time.Second
+
+// aliases are the hyphenated keys of the bulk settings
+var aliases = map[string]string{
+	"flush-bytes":    "FlushBytes",
+	"flush-interval": "FlushInterval",
+	"max-retries":    "MaxRetries",
+	"retry-backoff":  "RetryBackoff",
+	"dead-letter":    "DeadLetter",
+	"index-pattern":  "IndexPattern",
+	"data-stream":    "DataStream",
+	"setup-template": "SetupTemplate",
+}
+
 type esConfig struct {
 	URLs          []string // elasticsearch cluster ip addresses
 	Username      string   // Username for HTTP Basic Authentication.
@@ -16,14 +34,26 @@ type esConfig struct {
 	CloudID       string   // Endpoint for the Elastic Service (https://elastic.co/cloud).
 	APIKey        string   // Base64-encoded token for authorization; if set, overrides username and password.
 	Index         string   // elasticsearch index name
-	Workers       int      // number of marshaler workers
+	Workers       int      // number of marshaler and bulk indexer workers
 	FlushBytes    int      // flush threshold in bytes
 	FlushInterval int      // periodic flush interval
+	MaxRetries    int      // max retries of the rejected (429) bulk requests and items
+	RetryBackoff  int      // backoff of the first retry in milliseconds, it's doubled per attempt
+	DeadLetter    string   // file which the permanently failed documents are written to
 	GeoField      string   // field supposed to resolve to Geo
+	DocumentID    string   // auto or a list of fields to hash e.g. hash(SAddr, LPort, Timestamp)
+	OpType        string   // bulk action: index or create
+	ILMPolicy     string   // lifecycle policy which is attached to the index template
+	IndexPattern  string   // time suffixed index name e.g. tcpdog-{2006.01.02} by the record time
+	DataStream    bool     // the index is a data stream, the documents are created
+	SetupTemplate bool     // installs the index template with the mappings of all the fields at startup
 
 	TLSConfig config.TLSConfig // TLS configuration
 
-	clientConfig elasticsearch.Config // elasticsearch HTTP client configuration
+	clientConfig elasticsearch.Config   // elasticsearch HTTP client configuration
+	idFields     []string               // document id fields
+	indexName    func(time.Time) string // index name of the index pattern
+	indexGlob    string                 // index template pattern of the index pattern
 }
 
 func elasticSearchConfig(cfg map[string]interface{}) (*esConfig, error) {
@@ -36,9 +66,47 @@ func elasticSearchConfig(cfg map[string]interface{}) (*esConfig, error) {
 		Workers:       2,
 		FlushBytes:    5 * 1 << 20,
 		FlushInterval: 1,
+		MaxRetries:    5,
+		RetryBackoff:  500,
+		DocumentID:    "auto",
+		OpType:        "index",
 	}
 
-	if err := config.Transform(cfg, es); err != nil {
+	if err := config.Transform(withAliases(cfg), es); err != nil {
+		return nil, err
+	}
+
+	if es.Workers < 1 || es.FlushBytes < 1 || es.FlushInterval < 1 {
+		return nil, fmt.Errorf("invalid elasticsearch bulk config: workers=%d flush-bytes=%d flush-interval=%d",
+			es.Workers, es.FlushBytes, es.FlushInterval)
+	}
+
+	if es.MaxRetries < 0 || es.RetryBackoff < 1 {
+		return nil, fmt.Errorf("invalid elasticsearch retry config: max-retries=%d retry-backoff=%d",
+			es.MaxRetries, es.RetryBackoff)
+	}
+
+	if es.OpType != "index" && es.OpType != "create" {
+		return nil, fmt.Errorf("invalid elasticsearch opType: %s", es.OpType)
+	}
+
+	if es.DataStream {
+		if es.IndexPattern != "" {
+			return nil, fmt.Errorf("elasticsearch data-stream doesn't support index-pattern")
+		}
+		// the data streams accept only the create action
+		es.OpType = "create"
+	}
+
+	if es.IndexPattern != "" {
+		es.indexName, es.indexGlob, err = indexPattern(es.IndexPattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	es.idFields, err = documentIDFields(es.DocumentID)
+	if err != nil {
 		return nil, err
 	}
 
@@ -48,6 +116,106 @@ func elasticSearchConfig(cfg map[string]interface{}) (*esConfig, error) {
 	return es, err
 }
 
+// withAliases returns the config with the hyphenated keys of the bulk
+// settings e.g. flush-bytes renamed to their field names.
+func withAliases(cfg map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(cfg))
+
+	for k, v := range cfg {
+		if alias, ok := aliases[k]; ok {
+			k = alias
+		}
+		m[k] = v
+	}
+
+	return m
+}
+
+// retryBackoff returns the backoff of the retry attempt
+func (c *esConfig) retryBackoff(attempt int) time.Duration {
+	d := time.Duration(c.RetryBackoff) * time.Millisecond
+
+	for i := 1; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+
+	return d
+}
+
+// indexPattern parses the index pattern, the Go time layouts in the
+// braces are formatted by the record time e.g. tcpdog-{2006.01.02}
+// and they're the wildcards of the index template pattern.
+func indexPattern(pattern string) (func(time.Time) string, string, error) {
+	var (
+		parts  []string
+		layout []bool
+		glob   strings.Builder
+		rest   = pattern
+	)
+
+	for rest != "" {
+		i := strings.IndexAny(rest, "{}")
+		if i < 0 {
+			parts, layout = append(parts, rest), append(layout, false)
+			glob.WriteString(rest)
+			break
+		}
+
+		j := strings.IndexByte(rest[i:], '}') + i
+		if rest[i] == '}' || j <= i+1 || strings.ContainsRune(rest[i+1:j], '{') {
+			return nil, "", fmt.Errorf("invalid elasticsearch index-pattern: %s", pattern)
+		}
+
+		parts, layout = append(parts, rest[:i], rest[i+1:j]), append(layout, false, true)
+		glob.WriteString(rest[:i] + "*")
+		rest = rest[j+1:]
+	}
+
+	name := func(t time.Time) string {
+		var b strings.Builder
+		t = t.UTC()
+		for i, p := range parts {
+			if layout[i] {
+				p = t.Format(p)
+			}
+			b.WriteString(p)
+		}
+		// the index names are lowercase e.g. Jan
+		return strings.ToLower(b.String())
+	}
+
+	return name, strings.ReplaceAll(glob.String(), "**", "*"), nil
+}
+
+// documentIDFields parses the document id recipe, it can be
+// auto, hash(f1, f2, ...) or just the fields list f1, f2, ...
+func documentIDFields(recipe string) ([]string, error) {
+	var fields []string
+
+	recipe = strings.TrimSpace(recipe)
+	if recipe == "auto" || recipe == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(recipe, "hash(") && strings.HasSuffix(recipe, ")") {
+		recipe = recipe[5 : len(recipe)-1]
+	}
+
+	for _, f := range strings.Split(recipe, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || strings.ContainsAny(f, "() ") {
+			return nil, fmt.Errorf("invalid elasticsearch documentID: %s", recipe)
+		}
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
 func clientConfig(c *esConfig) (elasticsearch.Config, error) {
 	cfg := elasticsearch.Config{
 		Addresses: c.URLs,
@@ -72,5 +240,17 @@ func clientConfig(c *esConfig) (elasticsearch.Config, error) {
 
 	}
 
+	if fault.Allowed() {
+		cfg.Transport = fault.Transport(fault.ESBulk, cfg.Transport, isBulk)
+	}
+
+	// the rejected bulk requests are retried before the client
+	// retries the failed requests e.g. 502, 503 and 504.
+	cfg.Transport = &retryTransport{
+		next:       cfg.Transport,
+		maxRetries: c.MaxRetries,
+		backoff:    c.retryBackoff,
+	}
+
 	return cfg, nil
 }
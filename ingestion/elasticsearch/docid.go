@@ -0,0 +1,91 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/recordid"
+)
+
+// documentID returns the hex xxhash of the id fields values, the
+// values are normalized so a record has the same id regardless of
+// its serialization. it returns empty if an id field is missing.
+func (e *elastic) documentID(get func(string) (interface{}, bool)) string {
+	if len(e.cfg.idFields) < 1 {
+		return ""
+	}
+
+	h := xxhash.New()
+
+	for _, name := range e.cfg.idFields {
+		v, ok := get(name)
+		if !ok || v == nil {
+			return ""
+		}
+
+		h.WriteString(normalize(v))
+		h.Write([]byte{0})
+	}
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// recordID returns the EventID of the record
+func recordID(get func(string) (interface{}, bool)) string {
+	v, _ := get(recordid.Field)
+	id, _ := v.(string)
+	return id
+}
+
+// normalize formats the numbers as json numbers (float64)
+func normalize(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case uint32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case uint64:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case int64:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func getJSON(f map[string]interface{}) func(string) (interface{}, bool) {
+	return func(name string) (interface{}, bool) {
+		v, ok := f[name]
+		return v, ok
+	}
+}
+
+func getSPB(f *structpb.Struct) func(string) (interface{}, bool) {
+	return func(name string) (interface{}, bool) {
+		v, ok := f.GetFields()[name]
+		if !ok {
+			return nil, false
+		}
+		return v.AsInterface(), true
+	}
+}
+
+func getPB(f *pb.Fields) func(string) (interface{}, bool) {
+	return func(name string) (interface{}, bool) {
+		m := f.ProtoReflect()
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil || !m.Has(fd) {
+			return nil, false
+		}
+		return m.Get(fd).Interface(), true
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"reflect"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
@@ -14,22 +18,40 @@ import (
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/geo"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
 type elastic struct {
 	geo           geo.Geoer
+	name          string
+	label         string
 	cfg           *esConfig
 	serialization string
+	indexer       esutil.BulkIndexer
+	deadLetters   *deadLetterFile
+	logger        *zap.Logger
+
+	// idFallback counts the records which missed an id field
+	idFallback uint64
 }
 
+// timestampField is the time field of the data streams
+const timestampField = "@timestamp"
+
+// flushKey is the context key of the bulk request start time
+type flushKey struct{}
+
 // Start starts ingestion data points to influxdb
 func Start(ctx context.Context, name string, ser string, ch chan interface{}) error {
-	var g geo.Geoer
-
 	cfg := config.FromContextServer(ctx)
 	logger := cfg.Logger()
+	label := metrics.Flow(ctx)
 
 	eCfg, err := elasticSearchConfig(cfg.Ingestion[name].Config)
 	if err != nil {
@@ -41,9 +63,22 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 		return err
 	}
 
+	// the template is updated even if it exists
+	if eCfg.SetupTemplate {
+		if err := createTemplate(ctx, client, eCfg); err != nil {
+			return fmt.Errorf("index template %s: %w", eCfg.Index, err)
+		}
+		logger.Info("es", zap.String("msg", fmt.Sprintf("%s index template %s has been installed", name, eCfg.Index)))
+	}
+
+	if err := provisionIndex(ctx, name, eCfg, client); err != nil {
+		return err
+	}
+
 	indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
 		Client:        client,
 		Index:         eCfg.Index,
+		NumWorkers:    eCfg.Workers,
 		FlushBytes:    eCfg.FlushBytes,
 		FlushInterval: time.Duration(eCfg.FlushInterval) * time.Second,
 
@@ -52,18 +87,33 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 				logger.Error("es.bulkindexer", zap.Error(err))
 			}
 		},
+
+		// the bulk requests latency
+		OnFlushStart: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, flushKey{}, time.Now())
+		},
+		OnFlushEnd: func(ctx context.Context) {
+			if start, ok := ctx.Value(flushKey{}).(time.Time); ok {
+				metrics.ObserveBatch(label, name, start)
+			}
+		},
 	})
 	if err != nil {
 		return err
 	}
 
-	// if geo is available
-	if v, ok := geo.Reg[cfg.Geo.Type]; ok {
-		g = v
-		g.Init(cfg.Logger(), cfg.Geo.Config)
-	}
+	health.Register(ctx, "ingestion", name, health.CheckerFunc(func(ctx context.Context) error {
+		return ping(ctx, client)
+	}))
 
-	e := elastic{geo: g, cfg: eCfg, serialization: ser}
+	e := elastic{name: name, label: label, geo: geo.FromContext(ctx), cfg: eCfg, serialization: ser, indexer: indexer, logger: logger}
+
+	if eCfg.DeadLetter != "" {
+		e.deadLetters, err = openDeadLetter(eCfg.DeadLetter)
+		if err != nil {
+			return err
+		}
+	}
 
 	iCh := make(chan *esutil.BulkIndexerItem, 1000)
 
@@ -73,15 +123,30 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 	}
 
 	go func() {
+		var fallback uint64
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case item := <-iCh:
 				err = indexer.Add(ctx, *item)
 				if err != nil {
+					e.failed(ctx, *item, esutil.BulkIndexerResponseItem{}, err)
 					logger.Error("es.add", zap.Error(err))
 				}
+			case <-ticker.C:
+				if n := atomic.LoadUint64(&e.idFallback); n != fallback {
+					logger.Warn("es.documentID", zap.String("msg", "id fields are missing, auto id has been used"),
+						zap.Uint64("records", n-fallback))
+					fallback = n
+				}
 			case <-ctx.Done():
 				indexer.Close(ctx)
+				if e.deadLetters != nil {
+					e.deadLetters.close()
+				}
 				return
 			}
 		}
@@ -92,30 +157,151 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 
 // iWorker creates elasticsearch item
 func (e *elastic) iWorker(ctx context.Context, ch chan interface{}, iCh chan *esutil.BulkIndexerItem) {
-	var fields interface{}
-
 	logger := config.FromContextServer(ctx).Logger()
 	getItem := e.getItemMaker(e.serialization)
+	lane := priority.FromContext(ctx)
 
 	for {
+		fields, ok := lane.Recv(ctx, ch)
+		if !ok {
+			return
+		}
+
+		tr := tracing.From(fields)
+		tr.Begin("encode")
+
+		item, err := getItem(fields)
+		if err != nil {
+			tr.Finish(err)
+			e.failed(ctx, esutil.BulkIndexerItem{}, esutil.BulkIndexerResponseItem{}, err)
+			logger.Error("es.worker", zap.Error(err))
+			continue
+		}
+
+		tr.End("encode")
+
+		item.OnSuccess = e.indexed
+		item.OnFailure = e.failed
+		if tr != nil {
+			e.traced(tr, item)
+		}
+		e.retried(ctx, item)
+
+		// the indexer is closed once the context is done thus
+		// the item which isn't handed over yet is dropped.
 		select {
-		case fields = <-ch:
-			item, err := getItem(fields)
-			if err != nil {
-				logger.Error("es.worker", zap.Error(err))
-				continue
+		case iCh <- item:
+		case <-ctx.Done():
+			tr.Finish(tracing.ErrDropped)
+			return
+		}
+	}
+}
+
+// indexed counts the items which the bulk indexer has indexed
+func (e *elastic) indexed(_ context.Context, _ esutil.BulkIndexerItem, _ esutil.BulkIndexerResponseItem) {
+	metrics.IngestionDocuments(e.label, e.name, 1)
+}
+
+// failed counts the items which the bulk indexer has failed to index
+// and writes them to the dead letter file if it's configured. the
+// duplicated item of a create has been indexed already e.g. it's been
+// replayed by the agent thus it's counted as an indexed one.
+func (e *elastic) failed(ctx context.Context, item esutil.BulkIndexerItem, r esutil.BulkIndexerResponseItem, err error) {
+	if duplicated(item, r, err) {
+		e.indexed(ctx, item, r)
+		return
+	}
+
+	e.deadLetter()
+
+	if e.deadLetters != nil {
+		if err := e.deadLetters.write(item, r, err); err != nil {
+			e.logger.Error("es.deadletter", zap.Error(err))
+		}
+	}
+}
+
+// duplicated returns true if the create has been conflicted with
+// the existing document of the same id.
+func duplicated(item esutil.BulkIndexerItem, r esutil.BulkIndexerResponseItem, err error) bool {
+	return err == nil && item.Action == "create" && r.Status == http.StatusConflict &&
+		r.Error.Type == "version_conflict_engine_exception"
+}
+
+// retried re-adds the item which elasticsearch has rejected (429) with
+// the exponential backoff, the item fails once it has been rejected more
+// than max retries or the ingestion has been stopped.
+func (e *elastic) retried(ctx context.Context, item *esutil.BulkIndexerItem) {
+	var (
+		attempt   int
+		onFailure = item.OnFailure
+	)
+
+	item.OnFailure = func(c context.Context, i esutil.BulkIndexerItem, r esutil.BulkIndexerResponseItem, err error) {
+		if err != nil || r.Status != http.StatusTooManyRequests || attempt >= e.cfg.MaxRetries {
+			onFailure(c, i, r, err)
+			return
+		}
+
+		attempt++
+		backoff := e.cfg.retryBackoff(attempt)
+
+		go func() {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				onFailure(c, i, r, ctx.Err())
+				return
 			}
 
-			iCh <- item
-		case <-ctx.Done():
+			if !rewind(i) {
+				onFailure(c, i, r, nil)
+				return
+			}
+
+			metrics.IngestionRetry(e.label, e.name)
+
+			if err := e.indexer.Add(ctx, i); err != nil {
+				onFailure(c, i, r, err)
+			}
+		}()
+	}
+}
+
+// deadLetter counts an item which has been given up
+func (e *elastic) deadLetter() {
+	drops.Add(drops.IngestionDeadLetter, e.name, 1)
+	metrics.IngestionError(e.label, e.name, 1)
+}
+
+// traced finishes the trace once the bulk indexer has flushed the
+// item, the bulk span is the batch wait and the write of the item.
+func (e *elastic) traced(tr *tracing.Trace, item *esutil.BulkIndexerItem) {
+	tr.Begin("elasticsearch.bulk")
+
+	item.OnSuccess = func(ctx context.Context, i esutil.BulkIndexerItem, r esutil.BulkIndexerResponseItem) {
+		e.indexed(ctx, i, r)
+		tr.Finish(nil)
+	}
+
+	item.OnFailure = func(ctx context.Context, i esutil.BulkIndexerItem, r esutil.BulkIndexerResponseItem, err error) {
+		e.failed(ctx, i, r, err)
+		if duplicated(i, r, err) {
+			tr.Finish(nil)
 			return
 		}
+
+		if err == nil {
+			err = fmt.Errorf("%s: %s", r.Error.Type, r.Error.Reason)
+		}
+		tr.Finish(err)
 	}
 }
 
 func (e *elastic) getItemMaker(ser string) func(fi interface{}) (*esutil.BulkIndexerItem, error) {
 	switch ser {
-	case "json":
+	case "json", "msgpack", "cbor":
 		return e.itemJSON
 	case "spb":
 		return e.itemSPB
@@ -130,11 +316,14 @@ func (e *elastic) itemJSON(fi interface{}) (*esutil.BulkIndexerItem, error) {
 	f := fi.(map[string]interface{})
 
 	if e.geo != nil {
-		if gv, ok := f[e.cfg.GeoField]; ok {
-			for k, v := range e.geo.Get(gv.(string)) {
+		tr := tracing.From(fi)
+		tr.Begin("geo")
+		if gv, ok := f[e.cfg.GeoField].(string); ok {
+			for k, v := range e.geo.Get(gv) {
 				f[k] = v
 			}
 		}
+		tr.End("geo")
 	}
 
 	b, err := json.Marshal(f)
@@ -142,21 +331,21 @@ func (e *elastic) itemJSON(fi interface{}) (*esutil.BulkIndexerItem, error) {
 		return nil, err
 	}
 
-	return &esutil.BulkIndexerItem{
-		Action: "index",
-		Body:   bytes.NewReader(b),
-	}, nil
+	return e.item(b, getJSON(f)), nil
 }
 
 func (e *elastic) itemSPB(fi interface{}) (*esutil.BulkIndexerItem, error) {
 	f := fi.(*pb.FieldsSPB)
 
 	if e.geo != nil {
-		if gv, ok := f.Fields.Fields[e.cfg.GeoField]; ok {
+		tr := tracing.From(fi)
+		tr.Begin("geo")
+		if gv, ok := f.GetFields().GetFields()[e.cfg.GeoField]; ok {
 			for k, v := range e.geo.Get(gv.GetStringValue()) {
 				f.Fields.Fields[k] = structpb.NewStringValue(v)
 			}
 		}
+		tr.End("geo")
 	}
 
 	b, err := protojson.Marshal(f.Fields)
@@ -164,10 +353,7 @@ func (e *elastic) itemSPB(fi interface{}) (*esutil.BulkIndexerItem, error) {
 		return nil, err
 	}
 
-	return &esutil.BulkIndexerItem{
-		Action: "index",
-		Body:   bytes.NewReader(b),
-	}, nil
+	return e.item(b, getSPB(f.Fields)), nil
 }
 
 func (e *elastic) itemPB(fi interface{}) (*esutil.BulkIndexerItem, error) {
@@ -178,16 +364,19 @@ func (e *elastic) itemPB(fi interface{}) (*esutil.BulkIndexerItem, error) {
 	value := reflect.ValueOf(f).Elem()
 
 	if e.geo != nil {
+		tr := tracing.From(fi)
+		tr.Begin("geo")
 		gv := value.FieldByName(e.cfg.GeoField)
 		if gv.IsValid() {
 			geoKV = e.geo.Get(gv.Elem().String())
 		}
+		tr.End("geo")
 	}
 
 	for k, v := range geoKV {
 		v := v
 		fv := value.FieldByName(k)
-		if fv.IsValid() {
+		if fv.IsValid() && fv.Type() == reflect.TypeOf(&v) {
 			fv.Set(reflect.ValueOf(&v))
 		}
 	}
@@ -197,8 +386,75 @@ func (e *elastic) itemPB(fi interface{}) (*esutil.BulkIndexerItem, error) {
 		return nil, err
 	}
 
+	return e.item(b, getPB(f)), nil
+}
+
+// item returns the bulk item with the record id (EventID) if the record
+// has it or the document id if it's configured, it falls back to the
+// auto id if the record misses an id field. the index of the index
+// pattern and the data stream @timestamp are based on the record time.
+func (e *elastic) item(b []byte, get func(string) (interface{}, bool)) *esutil.BulkIndexerItem {
+	var index string
+
+	if e.cfg.indexName != nil {
+		index = e.cfg.indexName(recordTime(get))
+	}
+
+	if e.cfg.DataStream {
+		b = withTimestamp(b, recordTime(get))
+	}
+
+	id := recordID(get)
+	if id == "" {
+		id = e.documentID(get)
+		if id == "" && len(e.cfg.idFields) > 0 {
+			atomic.AddUint64(&e.idFallback, 1)
+		}
+	}
+
 	return &esutil.BulkIndexerItem{
-		Action: "index",
-		Body:   bytes.NewReader(b),
-	}, nil
+		Index:      index,
+		Action:     e.cfg.OpType,
+		DocumentID: id,
+		Body:       bytes.NewReader(b),
+	}
+}
+
+// recordTime returns the time of the record Timestamp (seconds),
+// it's the current time if the record misses the Timestamp.
+func recordTime(get func(string) (interface{}, bool)) time.Time {
+	v, _ := get("Timestamp")
+
+	switch v := v.(type) {
+	case float64:
+		return time.Unix(int64(v), 0)
+	case uint64:
+		return time.Unix(int64(v), 0)
+	case int64:
+		return time.Unix(v, 0)
+	case uint32:
+		return time.Unix(int64(v), 0)
+	}
+
+	return time.Now()
+}
+
+// withTimestamp adds the @timestamp field which the data streams require
+// to the encoded document, it's the seconds like the Timestamp.
+func withTimestamp(b []byte, t time.Time) []byte {
+	rest := bytes.TrimSpace(b)
+	if len(rest) < 2 || rest[0] != '{' {
+		return b
+	}
+
+	rest = bytes.TrimSpace(rest[1:])
+
+	doc := make([]byte, 0, len(b)+32)
+	doc = append(doc, `{"`+timestampField+`":`...)
+	doc = strconv.AppendInt(doc, t.Unix(), 10)
+	if rest[0] != '}' {
+		doc = append(doc, ',')
+	}
+
+	return append(doc, rest...)
 }
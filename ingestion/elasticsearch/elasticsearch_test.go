@@ -3,6 +3,7 @@ package elasticsearch
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -11,25 +12,26 @@ import (
 	"testing"
 	"time"
 
+	"github.com/elastic/go-elasticsearch/v8/esutil"
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/geo"
 	pb "github.com/mehrdadrad/tcpdog/proto"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
 type geoMock struct{}
 
-func (g *geoMock) Init(l *zap.Logger, cfg map[string]string) {}
-func (g *geoMock) Get(s string) map[string]string            { return map[string]string{"City": "Los_Angeles"} }
+func (g *geoMock) Init(l *zap.Logger, cfg map[string]string) error { return nil }
+func (g *geoMock) Get(s string) map[string]string                  { return map[string]string{"City": "Los_Angeles"} }
 
 func TestStart(t *testing.T) {
 	done := make(chan struct{})
 
-	geo.Reg["foo"] = &geoMock{}
-
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, _ := ioutil.ReadAll(r.Body)
 		expected := `{"index":{}}
@@ -41,7 +43,9 @@ func TestStart(t *testing.T) {
 		done <- struct{}{}
 	}))
 
+	// the mock server accepts just the bulk requests
 	cfg := &config.ServerConfig{
+		Provisioning: "off",
 		Geo: config.Geo{
 			Type: "foo",
 		},
@@ -60,7 +64,7 @@ func TestStart(t *testing.T) {
 	cfg.SetMockLogger("memory")
 
 	ctx, cancel := context.WithCancel(context.Background())
-	ctx = cfg.WithContext(ctx)
+	ctx = geo.WithContext(cfg.WithContext(ctx), &geoMock{})
 	ch := make(chan interface{}, 1)
 
 	Start(ctx, "foo", "json", ch)
@@ -171,3 +175,180 @@ func TestGetItemMaker(t *testing.T) {
 
 	assert.Nil(t, i.getItemMaker("unknown"))
 }
+
+func TestDocumentID(t *testing.T) {
+	cfg, err := elasticSearchConfig(map[string]interface{}{
+		"documentID": "hash(SAddr, LPort, Timestamp)",
+		"opType":     "create",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SAddr", "LPort", "Timestamp"}, cfg.idFields)
+
+	e := &elastic{cfg: cfg}
+	record := []byte(`{"RTT":12345,"SAddr":"10.0.0.1","LPort":443,"Timestamp":1611118090}`)
+
+	// json
+	m := map[string]interface{}{}
+	json.Unmarshal(record, &m)
+	itemJSON, err := e.itemJSON(m)
+	assert.NoError(t, err)
+
+	// struct protobuf
+	s := &structpb.Struct{}
+	protojson.Unmarshal(record, s)
+	itemSPB, err := e.itemSPB(&pb.FieldsSPB{Fields: s})
+	assert.NoError(t, err)
+
+	// protobuf
+	p := &pb.Fields{}
+	protojson.Unmarshal(record, p)
+	itemPB, err := e.itemPB(p)
+	assert.NoError(t, err)
+
+	assert.Len(t, itemJSON.DocumentID, 16)
+	assert.Equal(t, "create", itemJSON.Action)
+	assert.Equal(t, itemJSON.DocumentID, itemSPB.DocumentID)
+	assert.Equal(t, itemJSON.DocumentID, itemPB.DocumentID)
+
+	// the other fields don't change the id
+	m["RTT"] = float64(5)
+	item, _ := e.itemJSON(m)
+	assert.Equal(t, itemJSON.DocumentID, item.DocumentID)
+
+	m["LPort"] = float64(444)
+	item, _ = e.itemJSON(m)
+	assert.NotEqual(t, itemJSON.DocumentID, item.DocumentID)
+
+	// missing id field
+	delete(m, "LPort")
+	item, _ = e.itemJSON(m)
+	assert.Equal(t, "", item.DocumentID)
+	p.LPort = nil
+	item, _ = e.itemPB(p)
+	assert.Equal(t, "", item.DocumentID)
+	assert.Equal(t, uint64(2), e.idFallback)
+
+	// the record id is preferred
+	m["EventID"] = "01F8MECHZX3TBDSZ7XRADM79XE"
+	item, _ = e.itemJSON(m)
+	assert.Equal(t, "01F8MECHZX3TBDSZ7XRADM79XE", item.DocumentID)
+	id := "01F8MECHZX3TBDSZ7XRADM79XE"
+	p.EventID = &id
+	item, _ = e.itemPB(p)
+	assert.Equal(t, "01F8MECHZX3TBDSZ7XRADM79XE", item.DocumentID)
+	assert.Equal(t, uint64(2), e.idFallback)
+}
+
+func TestDocumentIDConfig(t *testing.T) {
+	tests := []struct {
+		recipe string
+		fields []string
+		err    bool
+	}{
+		{"auto", nil, false},
+		{"SAddr,DAddr", []string{"SAddr", "DAddr"}, false},
+		{"hash(SKCookie)", []string{"SKCookie"}, false},
+		{"hash(SAddr,)", nil, true},
+		{"hash(SAddr", nil, true},
+	}
+
+	for _, tt := range tests {
+		fields, err := documentIDFields(tt.recipe)
+		assert.Equal(t, tt.err, err != nil, tt.recipe)
+		assert.Equal(t, tt.fields, fields, tt.recipe)
+	}
+
+	_, err := elasticSearchConfig(map[string]interface{}{"opType": "update"})
+	assert.EqualError(t, err, "invalid elasticsearch opType: update")
+}
+
+func TestFailed(t *testing.T) {
+	e := elastic{name: "drop"}
+	item := esutil.BulkIndexerItem{Action: "index"}
+
+	e.failed(context.Background(), item, esutil.BulkIndexerResponseItem{Status: 429}, nil)
+	e.failed(context.Background(), item, esutil.BulkIndexerResponseItem{}, errors.New("EOF"))
+
+	assert.Equal(t, uint64(2), drops.Count(drops.IngestionDeadLetter, "drop"))
+}
+
+func TestWorkerLeak(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	e := &elastic{geo: &geoMock{}, cfg: &esConfig{GeoField: "SAddr"}, serialization: "json"}
+	ch := make(chan interface{}, 1)
+	iCh := make(chan *esutil.BulkIndexerItem)
+
+	// the worker is blocked on the items channel which isn't read
+	go e.iWorker(ctx, ch, iCh)
+	ch <- map[string]interface{}{"RTT": 5.0, "SAddr": "10.0.0.1"}
+
+	// the goroutines are checked by the TestMain
+	assert.Eventually(t, func() bool { return len(ch) == 0 }, time.Second, time.Millisecond)
+	cancel()
+}
+
+func TestIndexPattern(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)
+
+	for pattern, expected := range map[string][2]string{
+		"tcpdog-{2006.01.02}":        {"tcpdog-2024.05.01", "tcpdog-*"},
+		"tcpdog-{2006.01}-{02}-prod": {"tcpdog-2024.05-01-prod", "tcpdog-*-*-prod"},
+		"{2006}.tcpdog":              {"2024.tcpdog", "*.tcpdog"},
+		"tcpdog-{Jan}":               {"tcpdog-may", "tcpdog-*"},
+		"tcpdog":                     {"tcpdog", "tcpdog"},
+	} {
+		name, glob, err := indexPattern(pattern)
+		assert.NoError(t, err, pattern)
+		assert.Equal(t, expected[0], name(ts), pattern)
+		assert.Equal(t, expected[1], glob, pattern)
+	}
+
+	for _, pattern := range []string{"tcpdog-{2006", "tcpdog-}", "tcpdog-{}", "tcpdog-{{2006}}"} {
+		_, _, err := indexPattern(pattern)
+		assert.EqualError(t, err, "invalid elasticsearch index-pattern: "+pattern)
+	}
+
+	_, err := elasticSearchConfig(map[string]interface{}{"index-pattern": "tcpdog-{2006}", "data-stream": true})
+	assert.EqualError(t, err, "elasticsearch data-stream doesn't support index-pattern")
+}
+
+func TestItemIndex(t *testing.T) {
+	cfg, err := elasticSearchConfig(map[string]interface{}{"index-pattern": "tcpdog-{2006.01.02}"})
+	assert.NoError(t, err)
+
+	e := &elastic{cfg: cfg}
+
+	// the record time
+	item, err := e.itemJSON(map[string]interface{}{"RTT": 5.0, "Timestamp": 1714606200.0})
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog-2024.05.01", item.Index)
+	assert.Equal(t, "index", item.Action)
+
+	pbItem, err := e.itemPB(&pb.Fields{Timestamp: proto.Uint64(1714606200)})
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog-2024.05.01", pbItem.Index)
+
+	// the data stream
+	cfg, err = elasticSearchConfig(map[string]interface{}{"data-stream": true, "opType": "index"})
+	assert.NoError(t, err)
+
+	e = &elastic{cfg: cfg}
+
+	item, err = e.itemJSON(map[string]interface{}{"RTT": 5.0, "Timestamp": 1714606200.0})
+	assert.NoError(t, err)
+	assert.Equal(t, "", item.Index)
+	assert.Equal(t, "create", item.Action)
+
+	b, _ := ioutil.ReadAll(item.Body)
+	assert.JSONEq(t, `{"@timestamp":1714606200,"RTT":5,"Timestamp":1714606200}`, string(b))
+
+	pbItem, err = e.itemPB(&pb.Fields{})
+	assert.NoError(t, err)
+
+	b, _ = ioutil.ReadAll(pbItem.Body)
+	assert.Regexp(t, `^\{"@timestamp":\d+\}$`, string(b))
+}
@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// geoEcho returns the attributes based on the input so the
+// fuzzer controls the enrichment keys as well.
+type geoEcho struct{}
+
+func (g *geoEcho) Init(l *zap.Logger, cfg map[string]string) error { return nil }
+func (g *geoEcho) Get(s string) map[string]string                  { return map[string]string{s: s, "City": s} }
+
+func FuzzItem(f *testing.F) {
+	record := []byte(`{"PID":123456,"Task":"curl","RTT":12345,"SAddr":"10.0.0.1","Timestamp":1611118090,"Hostname":"foo"}`)
+
+	p := pb.Fields{}
+	protojson.Unmarshal(record, &p)
+	bPB, _ := proto.Marshal(&p)
+
+	s := pb.FieldsSPB{}
+	protojson.Unmarshal([]byte(`{"Fields":`+string(record)+`}`), &s)
+	bSPB, _ := proto.Marshal(&s)
+
+	f.Add("json", record)
+	f.Add("json", []byte(`{"SAddr":1,"RTT":"1","Timestamp":null,"Task":true}`))
+	f.Add("pb", bPB)
+	f.Add("spb", bSPB)
+	f.Add("spb", []byte{})
+	f.Add("json", []byte(`{"SAddr":"RTT"}`))
+	f.Add("pb", []byte{0x2a, 0x3, 'R', 'T', 'T'})
+
+	e := &elastic{geo: &geoEcho{}, cfg: &esConfig{GeoField: "SAddr"}}
+
+	f.Fuzz(func(t *testing.T, ser string, b []byte) {
+		var fields interface{}
+
+		switch ser {
+		case "json":
+			m := map[string]interface{}{}
+			if json.Unmarshal(b, &m) != nil {
+				return
+			}
+			fields = m
+		case "pb":
+			m := &pb.Fields{}
+			if proto.Unmarshal(b, m) != nil {
+				return
+			}
+			fields = m
+		case "spb":
+			m := &pb.FieldsSPB{}
+			if proto.Unmarshal(b, m) != nil {
+				return
+			}
+			fields = m
+		default:
+			return
+		}
+
+		e.getItemMaker(ser)(fields)
+	})
+}
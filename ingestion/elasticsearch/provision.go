@@ -0,0 +1,168 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/provision"
+)
+
+// templateMappings are the index template mappings of the fields
+// which the dynamic mapping can't detect, see compatibleTypes.
+var templateMappings = map[string]interface{}{
+	"Timestamp":   map[string]string{"type": "date", "format": "epoch_second"},
+	"SAddr":       map[string]string{"type": "ip"},
+	"DAddr":       map[string]string{"type": "ip"},
+	"GeoLocation": map[string]string{"type": "geo_point"},
+}
+
+// fieldMappings returns the mappings of all the pb.Fields fields, the
+// geo fields of the maxmind enrichment are strings but GeoLocation
+// which is a geo_point, see templateMappings.
+func fieldMappings() map[string]interface{} {
+	var (
+		m      = map[string]interface{}{}
+		fields = (&pb.Fields{}).ProtoReflect().Descriptor().Fields()
+	)
+
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+
+		var kind string
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			kind = "keyword"
+		case protoreflect.BoolKind:
+			kind = "boolean"
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			kind = "double"
+		case protoreflect.BytesKind:
+			kind = "binary"
+		default:
+			kind = "long"
+		}
+
+		m[string(fd.Name())] = map[string]string{"type": kind}
+	}
+
+	for k, v := range templateMappings {
+		m[k] = v
+	}
+
+	return m
+}
+
+// provisionIndex creates or verifies the index template and the
+// ILM policy of the ingestion based on the server provisioning mode.
+func provisionIndex(ctx context.Context, name string, eCfg *esConfig, p performer) error {
+	cfg := config.FromContextServer(ctx)
+
+	var objects []provision.Object
+
+	// the policy can't be created by tcpdog, it's attached to the template
+	if eCfg.ILMPolicy != "" {
+		objects = append(objects, provision.Object{
+			Kind:   "ilm policy",
+			Name:   eCfg.ILMPolicy,
+			Exists: exists(p, http.MethodGet, "/_ilm/policy/"+eCfg.ILMPolicy),
+		})
+	}
+
+	objects = append(objects, provision.Object{
+		Kind:   "index template",
+		Name:   eCfg.Index,
+		Exists: exists(p, http.MethodHead, "/_index_template/"+eCfg.Index),
+		Create: func(ctx context.Context) error {
+			return createTemplate(ctx, p, eCfg)
+		},
+	})
+
+	return provision.Ensure(ctx, cfg.Provisioning, name, objects, cfg.Logger())
+}
+
+// exists returns an exists func which requests the path
+func exists(p performer, method, path string) func(context.Context) (bool, error) {
+	return func(ctx context.Context) (bool, error) {
+		status, _, err := do(ctx, p, method, path, nil)
+		if err != nil {
+			return false, err
+		}
+
+		switch status {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNotFound:
+			return false, nil
+		}
+
+		return false, fmt.Errorf("unexpected status %d", status)
+	}
+}
+
+// createTemplate creates the index template of the index and
+// its rollover indices, the ILM policy is attached if it's set.
+// the template of the index pattern matches its time suffixed
+// indices and the data stream template matches the stream.
+func createTemplate(ctx context.Context, p performer, eCfg *esConfig) error {
+	var (
+		settings   = map[string]interface{}{}
+		properties = map[string]interface{}{}
+		patterns   = []string{eCfg.Index, eCfg.Index + "-*"}
+	)
+
+	if eCfg.ILMPolicy != "" {
+		settings["index.lifecycle.name"] = eCfg.ILMPolicy
+	}
+
+	mappings := templateMappings
+	if eCfg.SetupTemplate {
+		mappings = fieldMappings()
+	}
+
+	for k, v := range mappings {
+		properties[k] = v
+	}
+
+	if eCfg.indexGlob != "" {
+		patterns = []string{eCfg.indexGlob}
+	}
+
+	template := map[string]interface{}{
+		"index_patterns": patterns,
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": map[string]interface{}{
+				"properties": properties,
+			},
+		},
+	}
+
+	if eCfg.DataStream {
+		template["index_patterns"] = []string{eCfg.Index}
+		template["data_stream"] = map[string]interface{}{}
+		properties[timestampField] = templateMappings["Timestamp"]
+	}
+
+	b, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	status, body, err := do(ctx, p, http.MethodPut, "/_index_template/"+eCfg.Index, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", status, body)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/provision"
+)
+
+func TestProvisionIndex(t *testing.T) {
+	var (
+		templates map[string]string
+		policies  map[string]bool
+	)
+
+	p := newPerformer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_index_template/tcpdog" && r.Method == http.MethodHead:
+			if _, ok := templates["tcpdog"]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.URL.Path == "/_index_template/tcpdog" && r.Method == http.MethodPut:
+			b, _ := ioutil.ReadAll(r.Body)
+			templates["tcpdog"] = string(b)
+		case r.URL.Path == "/_ilm/policy/hot":
+			if !policies["hot"] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	template := `{"index_patterns":["tcpdog","tcpdog-*"],"template":{"mappings":{"properties":{` +
+		`"DAddr":{"type":"ip"},"GeoLocation":{"type":"geo_point"},"SAddr":{"type":"ip"},` +
+		`"Timestamp":{"format":"epoch_second","type":"date"}}},"settings":{%s}}}`
+
+	for _, tc := range []struct {
+		mode      string
+		policy    string
+		templates map[string]string
+		policies  map[string]bool
+		err       string
+		created   map[string]string
+	}{
+		{
+			mode:    provision.Auto,
+			created: map[string]string{"tcpdog": fmt.Sprintf(template, "")},
+		},
+		{
+			mode:     provision.Auto,
+			policy:   "hot",
+			policies: map[string]bool{"hot": true},
+			created:  map[string]string{"tcpdog": fmt.Sprintf(template, `"index.lifecycle.name":"hot"`)},
+		},
+		{
+			mode:    provision.Auto,
+			policy:  "hot",
+			err:     "ingestion foo: missing ilm policy hot (create it manually)",
+			created: map[string]string{"tcpdog": fmt.Sprintf(template, `"index.lifecycle.name":"hot"`)},
+		},
+		{
+			mode:      provision.Auto,
+			templates: map[string]string{"tcpdog": "{}"},
+			created:   map[string]string{"tcpdog": "{}"},
+		},
+		{
+			mode:    provision.Verify,
+			policy:  "hot",
+			err:     "ingestion foo: missing ilm policy hot (create it manually), index template tcpdog (would create)",
+			created: map[string]string{},
+		},
+		{
+			mode:      provision.Verify,
+			templates: map[string]string{"tcpdog": "{}"},
+			created:   map[string]string{"tcpdog": "{}"},
+		},
+		{
+			mode:    provision.Off,
+			policy:  "hot",
+			created: map[string]string{},
+		},
+	} {
+		templates, policies = map[string]string{}, tc.policies
+		for k, v := range tc.templates {
+			templates[k] = v
+		}
+
+		cfg := &config.ServerConfig{Provisioning: tc.mode}
+		cfg.SetMockLogger("provision")
+		ctx := cfg.WithContext(context.Background())
+
+		err := provisionIndex(ctx, "foo", &esConfig{Index: "tcpdog", ILMPolicy: tc.policy}, p)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.mode)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.mode)
+		}
+		assert.Equal(t, tc.created, templates, tc.mode)
+	}
+}
+
+func TestCreateTemplate(t *testing.T) {
+	var template map[string]interface{}
+
+	p := newPerformer(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		template = map[string]interface{}{}
+		json.Unmarshal(b, &template)
+	})
+
+	property := func(field string) interface{} {
+		return template["template"].(map[string]interface{})["mappings"].(map[string]interface{})["properties"].(map[string]interface{})[field]
+	}
+
+	// the time suffixed indices
+	eCfg, err := elasticSearchConfig(map[string]interface{}{"index-pattern": "tcpdog-{2006.01.02}", "setup-template": true})
+	assert.NoError(t, err)
+	assert.NoError(t, createTemplate(context.Background(), p, eCfg))
+
+	assert.Equal(t, []interface{}{"tcpdog-*"}, template["index_patterns"])
+	assert.Nil(t, template["data_stream"])
+	assert.Equal(t, map[string]interface{}{"type": "geo_point"}, property("GeoLocation"))
+	assert.Equal(t, map[string]interface{}{"type": "keyword"}, property("City"))
+	assert.Equal(t, map[string]interface{}{"type": "keyword"}, property("ASN"))
+	assert.Equal(t, map[string]interface{}{"type": "long"}, property("RTT"))
+	assert.Equal(t, map[string]interface{}{"type": "ip"}, property("SAddr"))
+	assert.Equal(t, map[string]interface{}{"type": "date", "format": "epoch_second"}, property("Timestamp"))
+	assert.Nil(t, property("@timestamp"))
+
+	// the data stream
+	eCfg, err = elasticSearchConfig(map[string]interface{}{"data-stream": true, "ilmPolicy": "hot"})
+	assert.NoError(t, err)
+	assert.NoError(t, createTemplate(context.Background(), p, eCfg))
+
+	assert.Equal(t, []interface{}{"tcpdog"}, template["index_patterns"])
+	assert.Equal(t, map[string]interface{}{}, template["data_stream"])
+	assert.Equal(t, map[string]interface{}{"type": "date", "format": "epoch_second"}, property("@timestamp"))
+	assert.Equal(t, map[string]interface{}{"type": "geo_point"}, property("GeoLocation"))
+	// the minimal mappings without the setup template
+	assert.Nil(t, property("RTT"))
+	assert.Equal(t, "hot", template["template"].(map[string]interface{})["settings"].(map[string]interface{})["index.lifecycle.name"])
+}
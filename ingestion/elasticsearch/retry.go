@@ -0,0 +1,152 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+
+	"github.com/mehrdadrad/tcpdog/safewriter"
+)
+
+// retryTransport retries the bulk requests which elasticsearch has
+// rejected (429) with the exponential backoff, the last rejection is
+// returned to the bulk indexer once the retries have been exhausted.
+type retryTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+	backoff    func(attempt int) time.Duration
+}
+
+// isBulk returns true if the request is a bulk request
+func isBulk(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/_bulk")
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if !isBulk(req) || t.maxRetries < 1 || req.Body == nil {
+		return next.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req.Clone(req.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		resp, err := next.RoundTrip(r)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt > t.maxRetries {
+			return resp, err
+		}
+
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		select {
+		case <-time.After(t.backoff(attempt)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// deadLetterFile writes the documents which have been failed permanently
+// e.g. the mapping conflicts as the json lines along with the reason.
+type deadLetterFile struct {
+	w *safewriter.Writer
+}
+
+// deadLetterRecord is a line of the dead letter file
+type deadLetterRecord struct {
+	Time     time.Time       `json:"time"`
+	Index    string          `json:"index,omitempty"`
+	ID       string          `json:"id,omitempty"`
+	Status   int             `json:"status,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	Reason   string          `json:"reason"`
+	Document json.RawMessage `json:"document,omitempty"`
+}
+
+// openDeadLetter opens the dead letter file for appending, the lines
+// are written immediately thus they can be inspected while it's running.
+func openDeadLetter(path string) (*deadLetterFile, error) {
+	w, err := safewriter.Open(path, safewriter.Options{Mode: safewriter.Line, BufferSize: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deadLetterFile{w: w}, nil
+}
+
+// write writes the failed item, the reason is the error of the
+// item or the error of the request if the request has failed.
+func (d *deadLetterFile) write(item esutil.BulkIndexerItem, r esutil.BulkIndexerResponseItem, err error) error {
+	rec := deadLetterRecord{
+		Time:     time.Now(),
+		Index:    r.Index,
+		ID:       item.DocumentID,
+		Status:   r.Status,
+		Type:     r.Error.Type,
+		Reason:   r.Error.Reason,
+		Document: document(item),
+	}
+
+	if err != nil {
+		rec.Reason = err.Error()
+	}
+
+	if r.Error.Cause.Reason != "" {
+		rec.Reason += ": " + r.Error.Cause.Reason
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return d.w.Write(b)
+}
+
+func (d *deadLetterFile) close() error {
+	return d.w.Close()
+}
+
+// rewind rewinds the body of the item which the bulk indexer has read
+func rewind(item esutil.BulkIndexerItem) bool {
+	s, ok := item.Body.(io.Seeker)
+	if !ok {
+		return false
+	}
+
+	_, err := s.Seek(0, io.SeekStart)
+
+	return err == nil
+}
+
+// document returns the body of the item
+func document(item esutil.BulkIndexerItem) json.RawMessage {
+	if !rewind(item) {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(item.Body)
+	if err != nil || !json.Valid(b) {
+		return nil
+	}
+
+	return b
+}
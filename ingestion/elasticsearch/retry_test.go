@@ -0,0 +1,298 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var (
+		requests int32
+		indexed  []string
+	)
+
+	// the server rejects the bulk request twice
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"type":"es_rejected_execution_exception"}}`))
+			return
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			if !strings.HasPrefix(line, `{"index"`) {
+				indexed = append(indexed, line)
+			}
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	cfg, err := elasticSearchConfig(map[string]interface{}{"urls": []string{server.URL}, "max-retries": 3, "retry-backoff": 1})
+	assert.NoError(t, err)
+
+	client := &http.Client{Transport: cfg.clientConfig.Transport}
+	docs := []string{`{"RTT":1}`, `{"RTT":2}`, `{"RTT":3}`}
+
+	body := ""
+	for _, doc := range docs {
+		body += "{\"index\":{}}\n" + doc + "\n"
+	}
+
+	resp, err := client.Post(server.URL+"/_bulk", "application/x-ndjson", strings.NewReader(body))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// no document has been lost
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(t, docs, indexed)
+
+	// the rejection is returned once the retries have been exhausted
+	cfg, err = elasticSearchConfig(map[string]interface{}{"urls": []string{server.URL}, "max-retries": 1, "retry-backoff": 1})
+	assert.NoError(t, err)
+
+	atomic.StoreInt32(&requests, 0)
+	client = &http.Client{Transport: cfg.clientConfig.Transport}
+
+	resp, err = client.Post(server.URL+"/_bulk", "application/x-ndjson", strings.NewReader(body))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+type indexerMock struct {
+	sync.Mutex
+	items []esutil.BulkIndexerItem
+}
+
+func (i *indexerMock) Add(_ context.Context, item esutil.BulkIndexerItem) error {
+	i.Lock()
+	defer i.Unlock()
+	i.items = append(i.items, item)
+	return nil
+}
+
+func (i *indexerMock) Close(context.Context) error    { return nil }
+func (i *indexerMock) Stats() esutil.BulkIndexerStats { return esutil.BulkIndexerStats{} }
+
+func (i *indexerMock) last() (esutil.BulkIndexerItem, int) {
+	i.Lock()
+	defer i.Unlock()
+	if len(i.items) == 0 {
+		return esutil.BulkIndexerItem{}, 0
+	}
+	return i.items[len(i.items)-1], len(i.items)
+}
+
+func TestRetried(t *testing.T) {
+	indexer := &indexerMock{}
+	cfg := &esConfig{MaxRetries: 2, RetryBackoff: 1}
+	e := &elastic{name: "es-retried", cfg: cfg, indexer: indexer}
+
+	var failed int32
+
+	item := &esutil.BulkIndexerItem{Action: "index", Body: bytes.NewReader([]byte(`{"RTT":5}`))}
+	item.OnFailure = func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem, error) {
+		atomic.AddInt32(&failed, 1)
+	}
+	e.retried(context.Background(), item)
+
+	rejected := esutil.BulkIndexerResponseItem{Status: http.StatusTooManyRequests}
+	ioutil.ReadAll(item.Body)
+
+	// the rejected item is re-added with its body
+	item.OnFailure(context.Background(), *item, rejected, nil)
+	assert.Eventually(t, func() bool { _, n := indexer.last(); return n == 1 }, time.Second, time.Millisecond)
+
+	retried, _ := indexer.last()
+	b, _ := ioutil.ReadAll(retried.Body)
+	assert.Equal(t, `{"RTT":5}`, string(b))
+
+	retried.OnFailure(context.Background(), retried, rejected, nil)
+	assert.Eventually(t, func() bool { _, n := indexer.last(); return n == 2 }, time.Second, time.Millisecond)
+
+	// the retries have been exhausted
+	retried, _ = indexer.last()
+	retried.OnFailure(context.Background(), retried, rejected, nil)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failed))
+
+	// the permanent failure isn't retried
+	item = &esutil.BulkIndexerItem{Action: "index", Body: bytes.NewReader([]byte(`{"RTT":6}`))}
+	item.OnFailure = func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem, error) {
+		atomic.AddInt32(&failed, 1)
+	}
+	e.retried(context.Background(), item)
+
+	item.OnFailure(context.Background(), *item, esutil.BulkIndexerResponseItem{Status: http.StatusBadRequest}, nil)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&failed))
+	_, n := indexer.last()
+	assert.Equal(t, 2, n)
+}
+
+func TestDeadLetterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "es.deadletter")
+
+	cfg, err := elasticSearchConfig(map[string]interface{}{"dead-letter": path})
+	assert.NoError(t, err)
+	assert.Equal(t, path, cfg.DeadLetter)
+
+	d, err := openDeadLetter(cfg.DeadLetter)
+	assert.NoError(t, err)
+
+	e := &elastic{name: "es-deadletter", cfg: cfg, deadLetters: d, logger: zap.NewNop()}
+	dropped := drops.Count(drops.IngestionDeadLetter, "es-deadletter")
+
+	r := esutil.BulkIndexerResponseItem{Index: "tcpdog", Status: http.StatusBadRequest}
+	r.Error.Type = "mapper_parsing_exception"
+	r.Error.Reason = "failed to parse field [RTT] of type [long]"
+
+	body := bytes.NewReader([]byte(`{"RTT":"foo"}`))
+	ioutil.ReadAll(body)
+
+	e.failed(context.Background(), esutil.BulkIndexerItem{DocumentID: "1", Body: body}, r, nil)
+	e.failed(context.Background(), esutil.BulkIndexerItem{}, esutil.BulkIndexerResponseItem{}, context.Canceled)
+	assert.NoError(t, d.close())
+
+	assert.Equal(t, uint64(2), drops.Count(drops.IngestionDeadLetter, "es-deadletter")-dropped)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 2)
+
+	rec := deadLetterRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "tcpdog", rec.Index)
+	assert.Equal(t, "1", rec.ID)
+	assert.Equal(t, http.StatusBadRequest, rec.Status)
+	assert.Equal(t, "mapper_parsing_exception", rec.Type)
+	assert.Equal(t, "failed to parse field [RTT] of type [long]", rec.Reason)
+	assert.JSONEq(t, `{"RTT":"foo"}`, string(rec.Document))
+
+	rec = deadLetterRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, "context canceled", rec.Reason)
+	assert.Nil(t, rec.Document)
+}
+
+func TestIWorkerDeadLetter(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "es.deadletter")
+	d, err := openDeadLetter(path)
+	assert.NoError(t, err)
+
+	e := &elastic{name: "es-worker", cfg: &esConfig{}, serialization: "json", deadLetters: d, logger: zap.NewNop()}
+	n := drops.Count(drops.IngestionDeadLetter, "es-worker")
+	ch := make(chan interface{}, 1)
+	iCh := make(chan *esutil.BulkIndexerItem, 1)
+
+	done := make(chan struct{})
+	go func() {
+		e.iWorker(ctx, ch, iCh)
+		close(done)
+	}()
+
+	// the record which can't be encoded
+	ch <- map[string]interface{}{"RTT": math.NaN()}
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.IngestionDeadLetter, "es-worker") == n+1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+	assert.NoError(t, d.close())
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	rec := deadLetterRecord{}
+	assert.NoError(t, json.Unmarshal(b, &rec))
+	assert.Equal(t, "json: unsupported value: NaN", rec.Reason)
+}
+
+func TestDuplicatedCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "es.deadletter")
+
+	d, err := openDeadLetter(path)
+	assert.NoError(t, err)
+
+	e := &elastic{name: "es-duplicated", deadLetters: d, logger: zap.NewNop()}
+	dropped := drops.Count(drops.IngestionDeadLetter, "es-duplicated")
+
+	r := esutil.BulkIndexerResponseItem{Index: "tcpdog", Status: http.StatusConflict}
+	r.Error.Type = "version_conflict_engine_exception"
+	r.Error.Reason = "[1]: version conflict, document already exists"
+
+	// the replayed create has been indexed already
+	e.failed(context.Background(), esutil.BulkIndexerItem{Action: "create", DocumentID: "1"}, r, nil)
+	assert.Equal(t, uint64(0), drops.Count(drops.IngestionDeadLetter, "es-duplicated")-dropped)
+
+	// the conflict of an index is a failure
+	e.failed(context.Background(), esutil.BulkIndexerItem{Action: "index", DocumentID: "1"}, r, nil)
+	assert.Equal(t, uint64(1), drops.Count(drops.IngestionDeadLetter, "es-duplicated")-dropped)
+	assert.NoError(t, d.close())
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), 1)
+}
+
+func TestBulkConfig(t *testing.T) {
+	cfg, err := elasticSearchConfig(map[string]interface{}{
+		"workers":        4,
+		"flush-bytes":    1024,
+		"flush-interval": 5,
+		"max-retries":    0,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, cfg.Workers)
+	assert.Equal(t, 1024, cfg.FlushBytes)
+	assert.Equal(t, 5, cfg.FlushInterval)
+	assert.Equal(t, 0, cfg.MaxRetries)
+
+	// the camel case keys
+	cfg, err = elasticSearchConfig(map[string]interface{}{"flushBytes": 2048, "FlushInterval": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2048, cfg.FlushBytes)
+	assert.Equal(t, 2, cfg.FlushInterval)
+	assert.Equal(t, 5, cfg.MaxRetries)
+
+	_, err = elasticSearchConfig(map[string]interface{}{"workers": 0})
+	assert.EqualError(t, err, "invalid elasticsearch bulk config: workers=0 flush-bytes=5242880 flush-interval=1")
+
+	_, err = elasticSearchConfig(map[string]interface{}{"max-retries": -1})
+	assert.EqualError(t, err, "invalid elasticsearch retry config: max-retries=-1 retry-backoff=500")
+
+	assert.Equal(t, 500*time.Millisecond, cfg.retryBackoff(1))
+	assert.Equal(t, 2*time.Second, cfg.retryBackoff(3))
+	assert.Equal(t, maxRetryBackoff, cfg.retryBackoff(20))
+}
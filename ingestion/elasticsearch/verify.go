@@ -0,0 +1,242 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// performer represents the elasticsearch transport
+type performer interface {
+	Perform(*http.Request) (*http.Response, error)
+}
+
+// compatibleTypes are the mapping types which the tcpdog values can
+// be indexed with, the other fields are numbers or dynamic strings.
+var compatibleTypes = map[string][]string{
+	"Timestamp":   {"long", "unsigned_long", "date", "date_nanos"},
+	"SAddr":       {"ip", "keyword", "text"},
+	"DAddr":       {"ip", "keyword", "text"},
+	"GeoLocation": {"geo_point", "keyword", "text"},
+}
+
+// Verify verifies the connectivity, auth, index template compatibility
+// and the write permission of the elasticsearch ingestion, it indexes a
+// test document and deletes it immediately.
+func Verify(ctx context.Context, name string, report config.VerifyFunc) error {
+	cfg := config.FromContextServer(ctx)
+
+	eCfg, err := elasticSearchConfig(cfg.Ingestion[name].Config)
+	if err != nil {
+		report("config", "", err)
+		return err
+	}
+
+	client, err := elasticsearch.NewClient(eCfg.clientConfig)
+	if err != nil {
+		report("config", "", err)
+		return err
+	}
+
+	report("config", "index "+eCfg.Index, nil)
+
+	return verify(ctx, client, eCfg.Index, eCfg.DataStream, report)
+}
+
+// verify runs the checks, the write check is skipped for the data
+// streams since their documents can't be deleted by the id.
+func verify(ctx context.Context, p performer, index string, dataStream bool, report config.VerifyFunc) error {
+	checks := []struct {
+		name string
+		fn   func(context.Context, performer, string) (string, error)
+	}{
+		{"connectivity", verifyConnectivity},
+		{"auth", verifyAuth},
+		{"index template", verifyTemplate},
+		{"write", verifyWrite},
+	}
+
+	for _, c := range checks {
+		if c.name == "write" && dataStream {
+			report(c.name, "skipped, data stream", nil)
+			continue
+		}
+
+		detail, err := c.fn(ctx, p, index)
+		report(c.name, detail, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func verifyConnectivity(ctx context.Context, p performer, _ string) (string, error) {
+	status, _, err := do(ctx, p, http.MethodHead, "/", nil)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("status %d", status), nil
+}
+
+// ping checks the cluster is reachable and the client is authorized
+func ping(ctx context.Context, p performer) error {
+	status, _, err := do(ctx, p, http.MethodHead, "/", nil)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("ping status %d", status)
+	}
+
+	return err
+}
+
+func verifyAuth(ctx context.Context, p performer, _ string) (string, error) {
+	status, body, err := do(ctx, p, http.MethodGet, "/", nil)
+	if err != nil {
+		return "", err
+	}
+
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return "", fmt.Errorf("unauthorized: status %d", status)
+	}
+
+	if status != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", status)
+	}
+
+	info := struct {
+		ClusterName string `json:"cluster_name"`
+		Version     struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}{}
+
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("cluster %s version %s", info.ClusterName, info.Version.Number), nil
+}
+
+// verifyTemplate simulates the index and checks the resolved mappings,
+// the index is created by the dynamic mapping without a template.
+func verifyTemplate(ctx context.Context, p performer, index string) (string, error) {
+	status, body, err := do(ctx, p, http.MethodPost, "/_index_template/_simulate_index/"+index, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if status == http.StatusNotFound {
+		return "no index template, dynamic mapping", nil
+	}
+
+	if status != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", status)
+	}
+
+	simulated := struct {
+		Template struct {
+			Mappings struct {
+				Properties map[string]struct {
+					Type string `json:"type"`
+				} `json:"properties"`
+			} `json:"mappings"`
+		} `json:"template"`
+	}{}
+
+	if err := json.Unmarshal(body, &simulated); err != nil {
+		return "", err
+	}
+
+	var conflicts []string
+
+	for field, types := range compatibleTypes {
+		prop, ok := simulated.Template.Mappings.Properties[field]
+		if !ok || prop.Type == "" {
+			continue
+		}
+
+		if !contains(types, prop.Type) {
+			conflicts = append(conflicts, fmt.Sprintf("%s:%s (expected %s)", field, prop.Type, strings.Join(types, "|")))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return "", fmt.Errorf("incompatible mappings: %s", strings.Join(conflicts, ", "))
+	}
+
+	return fmt.Sprintf("%d mapped fields", len(simulated.Template.Mappings.Properties)), nil
+}
+
+// verifyWrite creates a test document and deletes it right away
+func verifyWrite(ctx context.Context, p performer, index string) (string, error) {
+	id := fmt.Sprintf("tcpdog-verify-%d", time.Now().UnixNano())
+	doc := fmt.Sprintf(`{"Timestamp":%d,"Hostname":"tcpdog-verify"}`, time.Now().Unix())
+
+	status, _, err := do(ctx, p, http.MethodPut, "/"+index+"/_create/"+id, strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusCreated {
+		return "", fmt.Errorf("test document has not been created: status %d", status)
+	}
+
+	status, _, err = do(ctx, p, http.MethodDelete, "/"+index+"/_doc/"+id, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK {
+		return "", fmt.Errorf("test document %s has not been deleted: status %d", id, status)
+	}
+
+	return "test document has been created and deleted", nil
+}
+
+func do(ctx context.Context, p performer, method, path string, body io.Reader) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.Perform(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, bytes.TrimSpace(b), nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
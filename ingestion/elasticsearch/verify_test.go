@@ -0,0 +1,102 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type performerFunc func(*http.Request) (*http.Response, error)
+
+func (f performerFunc) Perform(req *http.Request) (*http.Response, error) { return f(req) }
+
+func newPerformer(t *testing.T, handler http.HandlerFunc) performer {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+
+	return performerFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+		return http.DefaultClient.Do(req)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	var (
+		mapping = `{"template":{"mappings":{"properties":{"Timestamp":{"type":"date"},"DAddr":{"type":"ip"}}}}}`
+		auth    = http.StatusOK
+		created string
+		deleted string
+	)
+
+	p := newPerformer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/" && r.Method == http.MethodHead:
+			w.WriteHeader(auth)
+		case r.URL.Path == "/":
+			w.WriteHeader(auth)
+			w.Write([]byte(`{"cluster_name":"tcpdog","version":{"number":"7.10.1"}}`))
+		case r.URL.Path == "/_index_template/_simulate_index/tcpdog":
+			w.Write([]byte(mapping))
+		case strings.HasPrefix(r.URL.Path, "/tcpdog/_create/"):
+			created = strings.TrimPrefix(r.URL.Path, "/tcpdog/_create/")
+			w.WriteHeader(http.StatusCreated)
+		case strings.HasPrefix(r.URL.Path, "/tcpdog/_doc/") && r.Method == http.MethodDelete:
+			deleted = strings.TrimPrefix(r.URL.Path, "/tcpdog/_doc/")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	var checks []string
+	report := func(check, detail string, err error) {
+		if err != nil {
+			checks = append(checks, check+": "+err.Error())
+			return
+		}
+		checks = append(checks, check+": "+detail)
+	}
+
+	assert.NoError(t, verify(context.Background(), p, "tcpdog", false, report))
+	assert.Equal(t, []string{
+		"connectivity: status 200",
+		"auth: cluster tcpdog version 7.10.1",
+		"index template: 2 mapped fields",
+		"write: test document has been created and deleted",
+	}, checks)
+	assert.NotEmpty(t, created)
+	assert.Equal(t, created, deleted)
+
+	// the data stream documents can't be deleted
+	checks, created = checks[:0], ""
+	assert.NoError(t, verify(context.Background(), p, "tcpdog", true, report))
+	assert.Equal(t, "write: skipped, data stream", checks[3])
+	assert.Empty(t, created)
+
+	// incompatible template
+	checks = checks[:0]
+	mapping = `{"template":{"mappings":{"properties":{"Timestamp":{"type":"keyword"}}}}}`
+	assert.Error(t, verify(context.Background(), p, "tcpdog", false, report))
+	assert.Equal(t, "index template: incompatible mappings: Timestamp:keyword (expected long|unsigned_long|date|date_nanos)", checks[2])
+	assert.Len(t, checks, 3)
+
+	// unauthorized
+	checks = checks[:0]
+	auth = http.StatusUnauthorized
+	assert.Error(t, verify(context.Background(), p, "tcpdog", false, report))
+	assert.Equal(t, []string{"connectivity: status 401", "auth: unauthorized: status 401"}, checks)
+
+	// no connectivity
+	checks = checks[:0]
+	p = performerFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, &url.Error{Op: "Head", URL: "/", Err: context.DeadlineExceeded}
+	})
+	assert.Error(t, verify(context.Background(), p, "tcpdog", false, report))
+	assert.Len(t, checks, 1)
+}
@@ -1,41 +1,92 @@
 package influxdb
 
 import (
-	"log"
+	"time"
 
 	"github.com/mehrdadrad/tcpdog/config"
 )
 
 type dbConfig struct {
-	URL        string
-	Org        string
-	Bucket     string
-	Token      string
+	// Version is the influxdb api version, the version 1 writes to the
+	// 1.x write endpoint of the database and the retention policy with
+	// the username and password e.g. influxdb 1.8 or victoriametrics.
+	Version int
+
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+
 	Timeout    uint
 	MaxRetries uint
 	BatchSize  uint
 	Workers    uint
 
+	// FlushInterval (milliseconds) is the max time which a batch waits
+	// before it's written, RetryInterval (milliseconds) is the backoff
+	// of the first retry which is doubled per attempt.
+	FlushInterval uint
+	RetryInterval uint
+
+	// Measurement is the measurement of the points, e.g. the
+	// tracepoint name of the flow (default tcpdog).
+	Measurement string
+
 	GeoField string // field supposed to resolve to Geo
 
 	TLSConfig config.TLSConfig // TLS configuration
 }
 
-func influxDBConfig(cfg map[string]interface{}) *dbConfig {
+func influxDBConfig(cfg map[string]interface{}) (*dbConfig, error) {
 	// default configuration
 	conf := &dbConfig{
+		Version:    2,
 		URL:        "http://localhost:8086",
 		Bucket:     "tcpdog",
+		Database:   "tcpdog",
 		Timeout:    5,
 		MaxRetries: 10,
 		BatchSize:  200,
 		Workers:    2,
 		GeoField:   "DAddr",
+
+		FlushInterval: 1000,
+		RetryInterval: 5000,
+
+		Measurement: "tcpdog",
 	}
 
 	if err := config.Transform(cfg, conf); err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if conf.BatchSize < 1 {
+		conf.BatchSize = 1
+	}
+
+	if conf.FlushInterval < 1 {
+		conf.FlushInterval = 1000
+	}
+
+	return conf, nil
+}
+
+// retryInterval returns the backoff of the retry attempt
+func (c *dbConfig) retryInterval(attempt int) time.Duration {
+	d := time.Duration(c.RetryInterval) * time.Millisecond
+
+	for i := 1; i < attempt && d < maxRetryInterval; i++ {
+		d *= 2
+	}
+
+	if d > maxRetryInterval {
+		d = maxRetryInterval
 	}
 
-	return conf
+	return d
 }
@@ -2,21 +2,47 @@ package influxdb
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	influxlog "github.com/influxdata/influxdb-client-go/v2/log"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/geo"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
 const maxChanSize = 1000
 
+var clientLogOnce sync.Once
+
+// clientLog is the influxdb client logger, the client sets the level
+// of the library wide logger per client which races with the running
+// clients of the other ingestions thus the level is kept.
+type clientLog struct {
+	influxlog.Logger
+}
+
+// SetLogLevel keeps the logger level
+func (clientLog) SetLogLevel(uint) {}
+
 type influxdb struct {
+	name          string
+	label         string
 	geo           geo.Geoer
 	cfg           *dbConfig
 	serialization string
@@ -24,26 +50,22 @@ type influxdb struct {
 
 // Start starts ingestion data points to influxdb
 func Start(ctx context.Context, name string, ser string, ch chan interface{}) error {
-	var g geo.Geoer
-
 	cfg := config.FromContextServer(ctx)
-	iCfg := influxDBConfig(cfg.Ingestion[name].Config)
+	label := metrics.Flow(ctx)
 
-	opts, err := influxdbOpts(iCfg)
+	iCfg, err := influxDBConfig(cfg.Ingestion[name].Config)
 	if err != nil {
 		return err
 	}
 
-	client := influxdb2.NewClientWithOptions(iCfg.URL, iCfg.Token, opts)
-	writeAPI := client.WriteAPI(iCfg.Org, iCfg.Bucket)
-
-	// if geo is available
-	if v, ok := geo.Reg[cfg.Geo.Type]; ok {
-		g = v
-		g.Init(cfg.Logger(), cfg.Geo.Config)
+	writer, err := newWriter(ctx, name, iCfg)
+	if err != nil {
+		return err
 	}
 
-	i := influxdb{geo: g, cfg: iCfg, serialization: ser}
+	health.Register(ctx, "ingestion", name, writer)
+
+	i := influxdb{name: name, label: label, geo: geo.FromContext(ctx), cfg: iCfg, serialization: ser}
 
 	pCh := make(chan *write.Point, maxChanSize)
 
@@ -56,8 +78,11 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 		for {
 			select {
 			case p := <-pCh:
-				writeAPI.WritePoint(p)
+				writer.WritePoint(p)
+				metrics.IngestionDocuments(label, name, 1)
 			case <-ctx.Done():
+				// the writer flushes its buffered points
+				writer.Close()
 				return
 			}
 		}
@@ -66,28 +91,120 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 	return nil
 }
 
+// newWriter returns the writer of the api version, the v2 write api
+// is provisioned and its write errors are logged.
+func newWriter(ctx context.Context, name string, iCfg *dbConfig) (pointWriter, error) {
+	switch iCfg.Version {
+	case 1:
+		return newV1Writer(ctx, name, iCfg)
+	case 2:
+	default:
+		return nil, fmt.Errorf("influxdb version %d is not supported", iCfg.Version)
+	}
+
+	opts, err := influxdbOpts(iCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	clientLogOnce.Do(func() {
+		if influxlog.Log != nil {
+			influxlog.Log = clientLog{influxlog.Log}
+		}
+	})
+
+	client := influxdb2.NewClientWithOptions(iCfg.URL, iCfg.Token, opts)
+
+	if err := provisionBucket(ctx, name, iCfg, client.BucketsAPI(), client.OrganizationsAPI()); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	writeAPI := client.WriteAPI(iCfg.Org, iCfg.Bucket)
+
+	// the errors channel is closed once the client is closed
+	logger := config.FromContextServer(ctx).Logger()
+	errs := writeAPI.Errors()
+	go func() {
+		for err := range errs {
+			logger.Error("influxdb", zap.String("ingestion", name), zap.Error(err))
+		}
+	}()
+
+	return &v2Writer{client: client, WriteAPI: writeAPI}, nil
+}
+
+// v2Writer represents the v2 write api which batches
+// and retries the points asynchronously.
+type v2Writer struct {
+	api.WriteAPI
+	client influxdb2.Client
+}
+
+// Close flushes the buffered points and closes the client
+func (w *v2Writer) Close() {
+	w.client.Close()
+}
+
+// Health checks the health of the server, it doesn't need the auth
+func (w *v2Writer) Health(ctx context.Context) error {
+	check, err := w.client.Health(ctx)
+	if err == nil && check.Status != domain.HealthCheckStatusPass {
+		err = fmt.Errorf("health status %s", check.Status)
+	}
+
+	return err
+}
+
 // pWorker creates influxdb point
 func (i *influxdb) pWorker(ctx context.Context, ch chan interface{}, pCh chan *write.Point) {
-	var fields interface{}
-
 	point := i.getPointMaker(i.serialization)
+	logger := config.FromContextServer(ctx).Logger()
+	lane := priority.FromContext(ctx)
 
 	for {
+		fields, ok := lane.Recv(ctx, ch)
+		if !ok {
+			return
+		}
+
+		tr := tracing.From(fields)
+		tr.Begin("encode")
+
+		p, err := point(fields)
+		if err != nil {
+			tr.Finish(err)
+			i.deadLetter()
+			logger.Error("influxdb", zap.Error(err))
+			continue
+		}
+
+		tr.End("encode")
+
+		// the main loop stops once the context is done
+		// thus the point which isn't handed over is dropped.
 		select {
-		case fields = <-ch:
-			p := point(fields)
-			if p != nil {
-				pCh <- p
-			}
+		case pCh <- p:
 		case <-ctx.Done():
+			tr.Finish(tracing.ErrDropped)
 			return
 		}
+
+		// the write api batches the points asynchronously
+		// thus the trace ends once the point is handed over.
+		tr.Finish(nil)
 	}
 }
 
-func (i *influxdb) getPointMaker(ser string) func(fi interface{}) *write.Point {
+// deadLetter counts a record which can't be converted to a point
+func (i *influxdb) deadLetter() {
+	drops.Add(drops.IngestionDeadLetter, i.name, 1)
+	metrics.IngestionError(i.label, i.name, 1)
+}
+
+func (i *influxdb) getPointMaker(ser string) func(fi interface{}) (*write.Point, error) {
 	switch ser {
-	case "json":
+	case "json", "msgpack", "cbor":
 		return i.pointJSON
 	case "spb":
 		return i.pointSPB
@@ -99,7 +216,7 @@ func (i *influxdb) getPointMaker(ser string) func(fi interface{}) *write.Point {
 }
 
 // pointSPB returns influxdb pointSPB with geo (if available)
-func (i *influxdb) pointSPB(fi interface{}) *write.Point {
+func (i *influxdb) pointSPB(fi interface{}) (*write.Point, error) {
 	var (
 		tags      = map[string]string{}
 		fields    = map[string]interface{}{}
@@ -108,7 +225,7 @@ func (i *influxdb) pointSPB(fi interface{}) *write.Point {
 
 	f := fi.(*pb.FieldsSPB)
 
-	for key, field := range f.Fields.Fields {
+	for key, field := range f.GetFields().GetFields() {
 		if value, ok := field.GetKind().(*structpb.Value_StringValue); ok {
 			if i.geo != nil && (key == i.cfg.GeoField) {
 				for k1, v1 := range i.geo.Get(value.StringValue) {
@@ -117,6 +234,8 @@ func (i *influxdb) pointSPB(fi interface{}) *write.Point {
 				continue
 			}
 			tags[key] = value.StringValue
+		} else if value, ok := field.GetKind().(*structpb.Value_BoolValue); ok {
+			fields[key] = value.BoolValue
 		} else if key != "Timestamp" {
 			fields[key] = field.GetNumberValue()
 		} else {
@@ -124,11 +243,11 @@ func (i *influxdb) pointSPB(fi interface{}) *write.Point {
 		}
 	}
 
-	return influxdb2.NewPoint("tcpdog", tags, fields, timestamp)
+	return i.point(tags, fields, timestamp), nil
 }
 
 // point returns influxdb point with geo (if available)
-func (i *influxdb) pointPB(fi interface{}) *write.Point {
+func (i *influxdb) pointPB(fi interface{}) (*write.Point, error) {
 	var (
 		tags      = map[string]string{}
 		fields    = map[string]interface{}{}
@@ -156,14 +275,18 @@ func (i *influxdb) pointPB(fi interface{}) *write.Point {
 				} else {
 					timestamp = time.Unix(int64(v.Field(n).Elem().Uint()), 0)
 				}
+			case reflect.Float64:
+				fields[v.Type().Field(n).Name] = v.Field(n).Elem().Float()
+			case reflect.Bool:
+				fields[v.Type().Field(n).Name] = v.Field(n).Elem().Bool()
 			}
 		}
 	}
 
-	return influxdb2.NewPoint("tcpdog", tags, fields, timestamp)
+	return i.point(tags, fields, timestamp), nil
 }
 
-func (i *influxdb) pointJSON(fi interface{}) *write.Point {
+func (i *influxdb) pointJSON(fi interface{}) (*write.Point, error) {
 	var (
 		tags      = map[string]string{}
 		fields    = map[string]interface{}{}
@@ -181,14 +304,28 @@ func (i *influxdb) pointJSON(fi interface{}) *write.Point {
 				continue
 			}
 			tags[key] = value
+		} else if value, ok := field.(bool); ok {
+			fields[key] = value
+		} else if value, ok := serialization.Number(field); !ok {
+			return nil, fmt.Errorf("invalid %s value: %v", key, field)
 		} else if key != "Timestamp" {
-			fields[key] = field.(float64)
+			fields[key] = value
 		} else {
-			timestamp = time.Unix(int64(field.(float64)), 0)
+			timestamp = time.Unix(int64(value), 0)
 		}
 	}
 
-	return influxdb2.NewPoint("tcpdog", tags, fields, timestamp)
+	return i.point(tags, fields, timestamp), nil
+}
+
+// point returns the point of the measurement, the point time is the
+// record timestamp or the current time if the record doesn't have it.
+func (i *influxdb) point(tags map[string]string, fields map[string]interface{}, timestamp time.Time) *write.Point {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return influxdb2.NewPoint(i.cfg.Measurement, tags, fields, timestamp)
 }
 
 // influxdbOpts returns influxdb options
@@ -197,6 +334,8 @@ func influxdbOpts(cfg *dbConfig) (*influxdb2.Options, error) {
 	opts.SetMaxRetries(cfg.MaxRetries)
 	opts.SetHTTPRequestTimeout(cfg.Timeout)
 	opts.SetBatchSize(cfg.BatchSize)
+	opts.SetFlushInterval(cfg.FlushInterval)
+	opts.SetRetryInterval(cfg.RetryInterval)
 
 	// TLS
 	if cfg.TLSConfig.Enable {
@@ -11,26 +11,27 @@ import (
 	"testing"
 	"time"
 
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	pb "github.com/mehrdadrad/tcpdog/proto"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	"github.com/mehrdadrad/tcpdog/geo"
 )
 
 type geoMock struct{}
 
-func (g *geoMock) Init(l *zap.Logger, cfg map[string]string) {}
-func (g *geoMock) Get(s string) map[string]string            { return map[string]string{"City": "Los_Angeles"} }
+func (g *geoMock) Init(l *zap.Logger, cfg map[string]string) error { return nil }
+func (g *geoMock) Get(s string) map[string]string                  { return map[string]string{"City": "Los_Angeles"} }
 
 func TestStart(t *testing.T) {
 	done := make(chan struct{})
 
-	geo.Reg["foo"] = &geoMock{}
-
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, _ := ioutil.ReadAll(r.Body)
 		expected := "tcpdog,Hostname=foo,Task=curl PID=123456,RTT=12345 1611118090000000000\n"
@@ -38,7 +39,9 @@ func TestStart(t *testing.T) {
 		done <- struct{}{}
 	}))
 
+	// the mock server accepts just the writes
 	cfg := &config.ServerConfig{
+		Provisioning: "off",
 		Geo: config.Geo{
 			Type: "foo",
 		},
@@ -52,7 +55,7 @@ func TestStart(t *testing.T) {
 		},
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	ctx = cfg.WithContext(ctx)
+	ctx = geo.WithContext(cfg.WithContext(ctx), &geoMock{})
 	ch := make(chan interface{}, 1)
 
 	Start(ctx, "foo", "json", ch)
@@ -69,6 +72,79 @@ func TestStart(t *testing.T) {
 	server.Close()
 }
 
+func TestStartMeasurement(t *testing.T) {
+	// the goroutines of the previous tests
+	current := goleak.IgnoreCurrent()
+	body := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body <- string(b)
+	}))
+	defer server.Close()
+
+	cfg := &config.ServerConfig{
+		Provisioning: "off",
+		Ingress:      map[string]config.Ingress{},
+		Ingestion: map[string]config.Ingestion{
+			"influx01": {
+				Config: map[string]interface{}{
+					"url":         server.URL,
+					"org":         "tcpdog",
+					"bucket":      "retransmit",
+					"token":       "secret",
+					"batchSize":   2,
+					"measurement": "tcp_retransmit_skb",
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	// the client is closed before the next test creates its client
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, goleak.Find(current))
+	})
+
+	ch := make(chan interface{}, 2)
+	assert.NoError(t, Start(ctx, "influx01", "json", ch))
+
+	for _, b := range []string{
+		`{"SAddr":"10.0.0.1","DAddr":"10.0.0.2","RTT":12345,"TotalRetrans":3,"Timestamp":1611118090}`,
+		`{"SAddr":"10.0.0.1","DAddr":"10.0.0.3","RTT":250.5,"TotalRetrans":1,"Timestamp":1611118091}`,
+	} {
+		m := map[string]interface{}{}
+		json.Unmarshal([]byte(b), &m)
+		ch <- m
+	}
+
+	expected := "tcp_retransmit_skb,DAddr=10.0.0.2,SAddr=10.0.0.1 RTT=12345,TotalRetrans=3 1611118090000000000\n" +
+		"tcp_retransmit_skb,DAddr=10.0.0.3,SAddr=10.0.0.1 RTT=250.5,TotalRetrans=1 1611118091000000000\n"
+
+	select {
+	case b := <-body:
+		assert.Equal(t, expected, b)
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch has not been written")
+	}
+}
+
+func TestPointTimestamp(t *testing.T) {
+	i := &influxdb{cfg: testConfig(t, nil)}
+
+	point, err := i.pointJSON(map[string]interface{}{"RTT": 5.0})
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog", point.Name())
+	assert.WithinDuration(t, time.Now(), point.Time(), time.Second)
+
+	point, err = i.pointJSON(map[string]interface{}{"RTT": 5.0, "Timestamp": 1611118090.0})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1611118090, 0), point.Time())
+}
+
 func TestPointJSON(t *testing.T) {
 	i := &influxdb{geo: &geoMock{}, cfg: &dbConfig{GeoField: "SAddr"}}
 
@@ -76,7 +152,8 @@ func TestPointJSON(t *testing.T) {
 	b := []byte(`{"PID":123456,"Task":"curl","RTT":12345,"SAddr":"10.0.0.1","Timestamp":1611118090,"Hostname":"foo"}`)
 	json.Unmarshal(b, &m)
 
-	point := i.pointJSON(m)
+	point, err := i.pointJSON(m)
+	assert.NoError(t, err)
 
 	assert.Len(t, point.TagList(), 3)
 	assert.Equal(t, "City", point.TagList()[0].Key)
@@ -100,7 +177,8 @@ func TestPointPB(t *testing.T) {
 	b := []byte(`{"PID":123456,"Task":"curl","RTT":12345,"SAddr":"10.0.0.1","Timestamp":1611118090,"Hostname":"foo"}`)
 	protojson.Unmarshal(b, &p)
 
-	point := i.pointPB(&p)
+	point, err := i.pointPB(&p)
+	assert.NoError(t, err)
 
 	assert.Len(t, point.TagList(), 3)
 	assert.Equal(t, "City", point.TagList()[0].Key)
@@ -126,7 +204,8 @@ func TestPointSPB(t *testing.T) {
 	json.Unmarshal(b, &m)
 	spb, err := structpb.NewStruct(m)
 	assert.NoError(t, err)
-	point := i.pointSPB(&pb.FieldsSPB{Fields: spb})
+	point, err := i.pointSPB(&pb.FieldsSPB{Fields: spb})
+	assert.NoError(t, err)
 
 	assert.Len(t, point.TagList(), 3)
 	assert.Equal(t, "City", point.TagList()[0].Key)
@@ -143,6 +222,33 @@ func TestPointSPB(t *testing.T) {
 	assert.Equal(t, float64(12345), point.FieldList()[1].Value)
 }
 
+func TestPointSynthetic(t *testing.T) {
+	i := &influxdb{cfg: &dbConfig{}}
+	b := []byte(`{"UID":1000,"Synthetic":true,"DAddr":"10.0.0.1","Timestamp":1611118090}`)
+
+	m := map[string]interface{}{}
+	json.Unmarshal(b, &m)
+	spb, err := structpb.NewStruct(m)
+	assert.NoError(t, err)
+	p := pb.Fields{}
+	protojson.Unmarshal(b, &p)
+
+	for _, point := range []func() (*write.Point, error){
+		func() (*write.Point, error) { return i.pointJSON(m) },
+		func() (*write.Point, error) { return i.pointSPB(&pb.FieldsSPB{Fields: spb}) },
+		func() (*write.Point, error) { return i.pointPB(&p) },
+	} {
+		point, err := point()
+		assert.NoError(t, err)
+
+		assert.Len(t, point.FieldList(), 2)
+		assert.Equal(t, "Synthetic", point.FieldList()[0].Key)
+		assert.Equal(t, true, point.FieldList()[0].Value)
+		assert.Equal(t, "UID", point.FieldList()[1].Key)
+		assert.Equal(t, int64(1611118090), point.Time().Unix())
+	}
+}
+
 func TestGetPointMaker(t *testing.T) {
 	i := &influxdb{}
 	expected := runtime.FuncForPC(reflect.ValueOf(i.pointSPB).Pointer()).Name()
@@ -161,7 +267,7 @@ func TestGetPointMaker(t *testing.T) {
 }
 
 func BenchmarkPointJSON(b *testing.B) {
-	i := &influxdb{}
+	i := &influxdb{cfg: testConfig(b, nil)}
 
 	m := map[string]interface{}{}
 	bb := []byte(`{"PID":123456,"Task":"curl","RTT":12345,"Timestamp":1611118090,"Hostname":"foo"}`)
@@ -173,7 +279,7 @@ func BenchmarkPointJSON(b *testing.B) {
 }
 
 func BenchmarkPointPB(b *testing.B) {
-	i := &influxdb{}
+	i := &influxdb{cfg: testConfig(b, nil)}
 
 	p := pb.Fields{}
 	bb := []byte(`{"PID":123456,"Task":"curl","RTT":12345,"Timestamp":1611118090,"Hostname":"foo"}`)
@@ -185,7 +291,7 @@ func BenchmarkPointPB(b *testing.B) {
 }
 
 func BenchmarkPointSPB(b *testing.B) {
-	i := &influxdb{}
+	i := &influxdb{cfg: testConfig(b, nil)}
 
 	m := map[string]interface{}{}
 	bb := []byte(`{"PID":123456,"Task":"curl","RTT":12345,"Timestamp":1611118090,"Hostname":"foo"}`)
@@ -196,3 +302,56 @@ func BenchmarkPointSPB(b *testing.B) {
 		i.pointSPB(&pb.FieldsSPB{Fields: spb})
 	}
 }
+
+func TestWorkerLeak(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	i := &influxdb{geo: &geoMock{}, cfg: &dbConfig{GeoField: "SAddr"}, serialization: "json"}
+	ch := make(chan interface{}, 1)
+	pCh := make(chan *write.Point)
+
+	// the worker is blocked on the points channel which isn't read
+	go i.pWorker(ctx, ch, pCh)
+	ch <- map[string]interface{}{"RTT": 5.0, "Timestamp": 1611118090.0}
+
+	// the goroutines are checked by the TestMain
+	assert.Eventually(t, func() bool { return len(ch) == 0 }, time.Second, time.Millisecond)
+	cancel()
+}
+
+func TestWorkerDeadLetter(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	i := &influxdb{name: "influx-deadletter", cfg: &dbConfig{}, serialization: "json"}
+	n := drops.Count(drops.IngestionDeadLetter, "influx-deadletter")
+	ch := make(chan interface{}, 2)
+	pCh := make(chan *write.Point, 1)
+
+	go i.pWorker(ctx, ch, pCh)
+
+	// the bad record is dead-lettered and the next one is written
+	ch <- map[string]interface{}{"RTT": []interface{}{1.0}, "Timestamp": 1611118090.0}
+	ch <- map[string]interface{}{"RTT": 5.0, "Timestamp": 1611118090.0}
+
+	select {
+	case p := <-pCh:
+		assert.Equal(t, int64(1611118090), p.Time().Unix())
+	case <-time.After(time.Second):
+		t.Fatal("time exceeded")
+	}
+	assert.Equal(t, n+1, drops.Count(drops.IngestionDeadLetter, "influx-deadletter"))
+}
+
+func testConfig(t testing.TB, cfg map[string]interface{}) *dbConfig {
+	c, err := influxDBConfig(cfg)
+	assert.NoError(t, err)
+
+	return c
+}
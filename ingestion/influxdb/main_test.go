@@ -0,0 +1,12 @@
+package influxdb
+
+import (
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+// TestMain checks the goroutines which have been left by the tests
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
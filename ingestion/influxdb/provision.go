@@ -0,0 +1,59 @@
+package influxdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/provision"
+)
+
+// bucketsAPI represents the influxdb buckets api
+type bucketsAPI interface {
+	FindBucketByName(ctx context.Context, bucketName string) (*domain.Bucket, error)
+	CreateBucketWithName(ctx context.Context, org *domain.Organization, bucketName string, rules ...domain.RetentionRule) (*domain.Bucket, error)
+}
+
+// orgsAPI represents the influxdb organizations api
+type orgsAPI interface {
+	FindOrganizationByName(ctx context.Context, orgName string) (*domain.Organization, error)
+}
+
+// provisionBucket creates or verifies the bucket of the ingestion
+// based on the server provisioning mode.
+func provisionBucket(ctx context.Context, name string, iCfg *dbConfig, buckets bucketsAPI, orgs orgsAPI) error {
+	cfg := config.FromContextServer(ctx)
+	objects := []provision.Object{bucketObject(iCfg, buckets, orgs)}
+
+	return provision.Ensure(ctx, cfg.Provisioning, name, objects, cfg.Logger())
+}
+
+// bucketObject returns the bucket object, the bucket is
+// created in the organization without a retention rule.
+func bucketObject(iCfg *dbConfig, buckets bucketsAPI, orgs orgsAPI) provision.Object {
+	return provision.Object{
+		Kind: "bucket",
+		Name: iCfg.Bucket,
+		Exists: func(ctx context.Context) (bool, error) {
+			_, err := buckets.FindBucketByName(ctx, iCfg.Bucket)
+			// the client doesn't have a typed not found error
+			if err != nil && err.Error() == fmt.Sprintf("bucket '%s' not found", iCfg.Bucket) {
+				return false, nil
+			}
+
+			return err == nil, err
+		},
+		Create: func(ctx context.Context) error {
+			org, err := orgs.FindOrganizationByName(ctx, iCfg.Org)
+			if err != nil {
+				return err
+			}
+
+			_, err = buckets.CreateBucketWithName(ctx, org, iCfg.Bucket)
+
+			return err
+		},
+	}
+}
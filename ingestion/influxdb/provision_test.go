@@ -0,0 +1,87 @@
+package influxdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/provision"
+)
+
+type fakeBuckets struct {
+	buckets map[string]string
+	created []string
+}
+
+func (f *fakeBuckets) FindBucketByName(_ context.Context, name string) (*domain.Bucket, error) {
+	if _, ok := f.buckets[name]; !ok {
+		return nil, fmt.Errorf("bucket '%s' not found", name)
+	}
+
+	return &domain.Bucket{Name: name}, nil
+}
+
+func (f *fakeBuckets) CreateBucketWithName(_ context.Context, org *domain.Organization, name string, _ ...domain.RetentionRule) (*domain.Bucket, error) {
+	f.buckets[name] = *org.Id
+	f.created = append(f.created, *org.Id+"/"+name)
+
+	return &domain.Bucket{Name: name}, nil
+}
+
+type fakeOrgs struct{}
+
+func (fakeOrgs) FindOrganizationByName(_ context.Context, name string) (*domain.Organization, error) {
+	if name != "tcpdog" {
+		return nil, fmt.Errorf("organization '%s' not found", name)
+	}
+
+	id := "0001"
+
+	return &domain.Organization{Id: &id, Name: name}, nil
+}
+
+func TestProvisionBucket(t *testing.T) {
+	iCfg := &dbConfig{Org: "tcpdog", Bucket: "tcp"}
+
+	for _, tc := range []struct {
+		mode    string
+		buckets map[string]string
+		err     string
+		created []string
+	}{
+		{mode: provision.Auto, created: []string{"0001/tcp"}},
+		{mode: provision.Auto, buckets: map[string]string{"tcp": "0001"}},
+		{mode: provision.Verify, err: "ingestion foo: missing bucket tcp (would create)"},
+		{mode: provision.Verify, buckets: map[string]string{"tcp": "0001"}},
+		{mode: provision.Off},
+	} {
+		buckets := &fakeBuckets{buckets: map[string]string{}}
+		for k, v := range tc.buckets {
+			buckets.buckets[k] = v
+		}
+
+		cfg := &config.ServerConfig{Provisioning: tc.mode}
+		cfg.SetMockLogger("provision")
+		ctx := cfg.WithContext(context.Background())
+
+		err := provisionBucket(ctx, "foo", iCfg, buckets, fakeOrgs{})
+		if tc.err == "" {
+			assert.NoError(t, err, tc.mode)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.mode)
+		}
+		assert.Equal(t, tc.created, buckets.created, tc.mode)
+	}
+
+	// the organization doesn't exist
+	cfg := &config.ServerConfig{Provisioning: provision.Auto}
+	cfg.SetMockLogger("provision")
+	ctx := cfg.WithContext(context.Background())
+
+	err := provisionBucket(ctx, "foo", &dbConfig{Org: "foo", Bucket: "tcp"}, &fakeBuckets{buckets: map[string]string{}}, fakeOrgs{})
+	assert.EqualError(t, err, "ingestion foo: create bucket tcp: organization 'foo' not found")
+}
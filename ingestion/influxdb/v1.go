@@ -0,0 +1,276 @@
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+// maxRetryInterval is the max backoff of the v1 write retries
+const maxRetryInterval = 125 * time.Second
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// pointWriter represents the write api of an influxdb version
+type pointWriter interface {
+	WritePoint(p *write.Point)
+	Close()
+	Health(ctx context.Context) error
+}
+
+// v1Writer writes the points to the influxdb 1.x write endpoint e.g.
+// influxdb 1.8 or victoriametrics, the points are batched by the batch
+// size and the flush interval like the v2 write api.
+type v1Writer struct {
+	name   string
+	label  string
+	cfg    *dbConfig
+	url    string
+	client *http.Client
+	logger *zap.Logger
+
+	pCh  chan *write.Point
+	done chan struct{}
+}
+
+func newV1Writer(ctx context.Context, name string, cfg *dbConfig) (*v1Writer, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// TLS
+	if cfg.TLSConfig.Enable {
+		tlsConfig, err := config.GetTLS(&cfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	w := &v1Writer{
+		name:   name,
+		label:  metrics.Flow(ctx),
+		cfg:    cfg,
+		url:    v1URL(cfg),
+		logger: config.FromContextServer(ctx).Logger(),
+		client: &http.Client{
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+			Transport: transport,
+		},
+		pCh:  make(chan *write.Point, cfg.BatchSize),
+		done: make(chan struct{}),
+	}
+
+	go w.run(ctx)
+
+	return w, nil
+}
+
+// v1URL returns the write endpoint of the database
+func v1URL(cfg *dbConfig) string {
+	q := url.Values{}
+	q.Set("db", cfg.Database)
+	q.Set("precision", "ns")
+	if cfg.RetentionPolicy != "" {
+		q.Set("rp", cfg.RetentionPolicy)
+	}
+
+	return strings.TrimSuffix(cfg.URL, "/") + "/write?" + q.Encode()
+}
+
+// WritePoint queues the point for the next batch
+func (w *v1Writer) WritePoint(p *write.Point) {
+	w.pCh <- p
+}
+
+// Close writes the pending points and stops the writer
+func (w *v1Writer) Close() {
+	close(w.pCh)
+	<-w.done
+}
+
+// Health checks the ping endpoint of the server
+func (w *v1Writer) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(w.cfg.URL, "/")+"/ping", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("ping status %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (w *v1Writer) run(ctx context.Context) {
+	var (
+		buf    = &bytes.Buffer{}
+		points int
+		ticker = time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Millisecond)
+	)
+
+	defer close(w.done)
+	defer ticker.Stop()
+
+	flush := func() {
+		if points > 0 {
+			w.flush(ctx, buf.Bytes(), points)
+			buf.Reset()
+			points = 0
+		}
+	}
+
+	for {
+		select {
+		case p, ok := <-w.pCh:
+			if !ok {
+				flush()
+				return
+			}
+
+			lineProtocol(buf, p)
+			if points++; points >= int(w.cfg.BatchSize) {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flush writes the batch, the failed batch is retried up to max retries
+// with the exponential backoff if the failure is temporary. the pending
+// batch at the shutdown is written once without the retry.
+func (w *v1Writer) flush(ctx context.Context, body []byte, points int) {
+	for attempt := 1; ; attempt++ {
+		retry, err := w.write(body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt > int(w.cfg.MaxRetries) || ctx.Err() != nil {
+			w.logger.Error("influxdb", zap.String("msg", w.name+" batch has been dropped"),
+				zap.Int("points", points), zap.Error(err))
+			w.deadLetter(points)
+			return
+		}
+
+		w.logger.Error("influxdb", zap.String("ingestion", w.name), zap.Int("attempt", attempt), zap.Error(err))
+
+		select {
+		case <-time.After(w.cfg.retryInterval(attempt)):
+		case <-ctx.Done():
+			w.deadLetter(points)
+			return
+		}
+
+		metrics.IngestionRetry(w.label, w.name)
+	}
+}
+
+// write posts the batch, it returns true if the failure is temporary
+// and the error contains the response body of the failed write.
+func (w *v1Writer) write(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Username != "" {
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("write failed: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// deadLetter counts the points which have been given up
+func (w *v1Writer) deadLetter(n int) {
+	drops.Add(drops.IngestionDeadLetter, w.name, uint64(n))
+	metrics.IngestionError(w.label, w.name, n)
+}
+
+// lineProtocol encodes the point by the line protocol, the unsigned
+// integers are encoded as integers since the 1.x versions don't support
+// them by default.
+func lineProtocol(buf *bytes.Buffer, p *write.Point) {
+	buf.WriteString(measurementEscaper.Replace(p.Name()))
+
+	for _, tag := range p.TagList() {
+		buf.WriteByte(',')
+		buf.WriteString(keyEscaper.Replace(tag.Key))
+		buf.WriteByte('=')
+		buf.WriteString(keyEscaper.Replace(tag.Value))
+	}
+
+	for i, field := range p.FieldList() {
+		if i == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+
+		buf.WriteString(keyEscaper.Replace(field.Key))
+		buf.WriteByte('=')
+
+		switch v := field.Value.(type) {
+		case float64:
+			buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		case int64:
+			buf.WriteString(strconv.FormatInt(v, 10) + "i")
+		case uint64:
+			if v > math.MaxInt64 {
+				v = math.MaxInt64
+			}
+			buf.WriteString(strconv.FormatUint(v, 10) + "i")
+		case bool:
+			buf.WriteString(strconv.FormatBool(v))
+		case string:
+			buf.WriteString(`"` + stringEscaper.Replace(v) + `"`)
+		default:
+			buf.WriteString(fmt.Sprintf(`"%v"`, v))
+		}
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(p.Time().UnixNano(), 10))
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,211 @@
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+func TestStartV1(t *testing.T) {
+	type request struct {
+		path, query, user, password, body string
+	}
+
+	requests := make(chan request, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		user, password, _ := r.BasicAuth()
+		requests <- request{r.URL.Path, r.URL.RawQuery, user, password, string(b)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{},
+		Ingestion: map[string]config.Ingestion{
+			"influx01": {
+				Config: map[string]interface{}{
+					"version":         1,
+					"url":             server.URL,
+					"database":        "tcpdog",
+					"retentionPolicy": "autogen",
+					"username":        "tcpdog",
+					"password":        "secret",
+					"batchSize":       2,
+					"measurement":     "tcp_retransmit_skb",
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan interface{}, 2)
+	assert.NoError(t, Start(ctx, "influx01", "json", ch))
+
+	for _, b := range []string{
+		`{"SAddr":"10.0.0.1","DAddr":"10.0.0.2","RTT":12345,"TotalRetrans":3,"Timestamp":1611118090}`,
+		`{"SAddr":"10.0.0.1","DAddr":"10.0.0.3","RTT":250.5,"TotalRetrans":1,"Timestamp":1611118091}`,
+	} {
+		m := map[string]interface{}{}
+		json.Unmarshal([]byte(b), &m)
+		ch <- m
+	}
+
+	expected := request{
+		path:     "/write",
+		query:    "db=tcpdog&precision=ns&rp=autogen",
+		user:     "tcpdog",
+		password: "secret",
+		body: "tcp_retransmit_skb,DAddr=10.0.0.2,SAddr=10.0.0.1 RTT=12345,TotalRetrans=3 1611118090000000000\n" +
+			"tcp_retransmit_skb,DAddr=10.0.0.3,SAddr=10.0.0.1 RTT=250.5,TotalRetrans=1 1611118091000000000\n",
+	}
+
+	select {
+	case r := <-requests:
+		assert.Equal(t, expected, r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch has not been written")
+	}
+}
+
+func TestV1FlushInterval(t *testing.T) {
+	body := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body <- string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	iCfg := testConfig(t, map[string]interface{}{"version": 1, "url": server.URL, "flushInterval": 20})
+	w, err := newV1Writer(ctx, "influx01", iCfg)
+	assert.NoError(t, err)
+
+	// the batch isn't full thus it's written by the flush interval
+	w.WritePoint(influxdb2.NewPoint("tcpdog", nil, map[string]interface{}{"RTT": 5.0}, time.Unix(1611118090, 0)))
+
+	select {
+	case b := <-body:
+		assert.Equal(t, "tcpdog RTT=5 1611118090000000000\n", b)
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch has not been flushed")
+	}
+
+	w.Close()
+}
+
+func TestV1Retry(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"overloaded"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := &config.ServerConfig{}
+	ms := cfg.SetMockLogger("influxv1retry")
+
+	ctx := cfg.WithContext(context.Background())
+
+	iCfg := testConfig(t, map[string]interface{}{"version": 1, "url": server.URL, "retryInterval": 1})
+	w := &v1Writer{name: "influx01", cfg: iCfg, url: v1URL(iCfg), client: http.DefaultClient, logger: cfg.Logger()}
+
+	dropped := drops.Count(drops.IngestionDeadLetter, "influx01")
+
+	w.flush(ctx, []byte("tcpdog RTT=5 1611118090000000000\n"), 1)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, uint64(0), drops.Count(drops.IngestionDeadLetter, "influx01")-dropped)
+	assert.Contains(t, ms.String(), "503 Service Unavailable: {\\\"error\\\":\\\"overloaded\\\"}")
+}
+
+func TestV1BadRequest(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"unable to parse"}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.ServerConfig{}
+	ms := cfg.SetMockLogger("influxv1bad")
+
+	ctx := cfg.WithContext(context.Background())
+
+	iCfg := testConfig(t, map[string]interface{}{"version": 1, "url": server.URL, "retryInterval": 1})
+	w := &v1Writer{name: "influx02", cfg: iCfg, url: v1URL(iCfg), client: http.DefaultClient, logger: cfg.Logger()}
+
+	dropped := drops.Count(drops.IngestionDeadLetter, "influx02")
+
+	// the bad request isn't retried
+	w.flush(ctx, []byte("tcpdog RTT= 1611118090000000000\ntcpdog RTT=5\n"), 2)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, uint64(2), drops.Count(drops.IngestionDeadLetter, "influx02")-dropped)
+	assert.Contains(t, ms.String(), "batch has been dropped")
+	assert.Contains(t, ms.String(), "unable to parse")
+}
+
+func TestLineProtocol(t *testing.T) {
+	p := influxdb2.NewPoint("tcp dog,v1",
+		map[string]string{"Task": "my app", "DAddr": "10.0.0.1"},
+		map[string]interface{}{"RTT": uint64(5), "Ratio": 0.25, "Count": int64(-3), "Ok": true, "Msg": `say "hi"`},
+		time.Unix(1611118090, 5))
+
+	buf := &bytes.Buffer{}
+	lineProtocol(buf, p)
+
+	expected := `tcp\ dog\,v1,DAddr=10.0.0.1,Task=my\ app Count=-3i,Msg="say \"hi\"",Ok=true,RTT=5i,Ratio=0.25 1611118090000000005` + "\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRetryInterval(t *testing.T) {
+	cfg := testConfig(t, map[string]interface{}{"retryInterval": 1000})
+
+	assert.Equal(t, time.Second, cfg.retryInterval(1))
+	assert.Equal(t, 4*time.Second, cfg.retryInterval(3))
+	assert.Equal(t, maxRetryInterval, cfg.retryInterval(20))
+}
+
+func TestV1URL(t *testing.T) {
+	cfg := testConfig(t, map[string]interface{}{"url": "http://localhost:8428/", "database": "metrics"})
+	assert.Equal(t, "http://localhost:8428/write?db=metrics&precision=ns", v1URL(cfg))
+}
+
+func TestWriterVersion(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.SetMockLogger("memory")
+
+	_, err := newWriter(cfg.WithContext(context.Background()), "influx01", testConfig(t, map[string]interface{}{"version": 3}))
+	assert.EqualError(t, err, "influxdb version 3 is not supported")
+}
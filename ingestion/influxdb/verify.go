@@ -0,0 +1,88 @@
+package influxdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Verify verifies the connectivity of the influxdb ingestion and the
+// token access to the bucket, the health check doesn't need the auth.
+func Verify(ctx context.Context, name string, report config.VerifyFunc) error {
+	cfg := config.FromContextServer(ctx)
+	iCfg, err := influxDBConfig(cfg.Ingestion[name].Config)
+	if err != nil {
+		report("config", "", err)
+		return err
+	}
+
+	if iCfg.Version == 1 {
+		return verifyV1(ctx, iCfg, report)
+	}
+
+	opts, err := influxdbOpts(iCfg)
+	if err != nil {
+		report("config", "", err)
+		return err
+	}
+
+	report("config", "bucket "+iCfg.Bucket, nil)
+
+	client := influxdb2.NewClientWithOptions(iCfg.URL, iCfg.Token, opts)
+	defer client.Close()
+
+	health, err := client.Health(ctx)
+	if err == nil && health.Status != domain.HealthCheckStatusPass {
+		err = fmt.Errorf("health status %s", health.Status)
+	}
+	if err != nil {
+		report("connectivity", "", err)
+		return err
+	}
+
+	report("connectivity", "health status pass", nil)
+
+	bucket, err := client.BucketsAPI().FindBucketByName(ctx, iCfg.Bucket)
+	if err != nil {
+		report("bucket", "", err)
+		return err
+	}
+
+	report("bucket", fmt.Sprintf("bucket %s (%s) is accessible", bucket.Name, *bucket.Id), nil)
+
+	return nil
+}
+
+// verifyV1 verifies the connectivity of the 1.x api by its ping endpoint
+func verifyV1(ctx context.Context, iCfg *dbConfig, report config.VerifyFunc) error {
+	report("config", "database "+iCfg.Database, nil)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(iCfg.URL, "/")+"/ping", nil)
+	if err != nil {
+		report("connectivity", "", err)
+		return err
+	}
+
+	resp, err := (&http.Client{Timeout: time.Duration(iCfg.Timeout) * time.Second}).Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("ping status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		report("connectivity", "", err)
+		return err
+	}
+
+	report("connectivity", "ping status "+resp.Status, nil)
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// Config represents the kafka re-egress configuration, the records
+// of the flow are produced to the topic with the OutputSerialization
+// (json, spb or pb) which is the flow serialization if it's not set.
+type Config struct {
+	Brokers             []string
+	Topic               string
+	OutputSerialization string
+	Compression         string
+	RetryMax            int
+	RetryBackoff        int // Millisecond
+	Workers             int
+
+	SASL      config.SASLConfig
+	TLSConfig config.TLSConfig
+}
+
+func kafkaConfig(cfg map[string]interface{}, ser string) (*Config, error) {
+	conf := &Config{
+		Brokers:      []string{"localhost:9092"},
+		Topic:        "tcpdog",
+		RetryMax:     3,
+		RetryBackoff: 250,
+		Workers:      2,
+	}
+
+	if err := config.Transform(cfg, conf); err != nil {
+		return nil, err
+	}
+
+	if conf.OutputSerialization == "" {
+		conf.OutputSerialization = ser
+	}
+
+	if !serialization.Supported(conf.OutputSerialization) {
+		return nil, fmt.Errorf("output serialization %s is not supported", conf.OutputSerialization)
+	}
+
+	return conf, nil
+}
+
+func saramaConfig(kCfg *Config) (*sarama.Config, error) {
+	sConfig := sarama.NewConfig()
+
+	sConfig.ClientID = "tcpdog"
+	sConfig.Producer.Retry.Max = kCfg.RetryMax
+	sConfig.Producer.Retry.Backoff = time.Duration(kCfg.RetryBackoff) * time.Millisecond
+
+	if kCfg.TLSConfig.Enable {
+		tlsConfig, err := config.GetTLS(&kCfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		sConfig.Net.TLS.Enable = true
+		sConfig.Net.TLS.Config = tlsConfig
+	}
+
+	if err := helper.SASL(sConfig, &kCfg.SASL); err != nil {
+		return nil, err
+	}
+
+	switch kCfg.Compression {
+	case "gzip":
+		sConfig.Producer.Compression = sarama.CompressionGZIP
+	case "lz4":
+		sConfig.Producer.Compression = sarama.CompressionLZ4
+	case "snappy":
+		sConfig.Producer.Compression = sarama.CompressionSnappy
+	default:
+		sConfig.Producer.Compression = sarama.CompressionNone
+	}
+
+	return sConfig, nil
+}
@@ -0,0 +1,200 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/fault"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/tracing"
+)
+
+// kafka re-egresses the flow records to a kafka topic, the records
+// are converted if the output serialization differs from the flow one.
+type kafka struct {
+	name   string
+	label  string
+	from   string
+	to     string
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	dropped map[string]struct{}
+}
+
+// Start starts producing the flow records to kafka
+func Start(ctx context.Context, name string, ser string, ch chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+
+	kCfg, err := kafkaConfig(cfg.Ingestion[name].Config, ser)
+	if err != nil {
+		return err
+	}
+
+	sCfg, err := saramaConfig(kCfg)
+	if err != nil {
+		return err
+	}
+
+	client, err := sarama.NewClient(kCfg.Brokers, sCfg)
+	if err != nil {
+		return err
+	}
+
+	producer, err := sarama.NewAsyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	// the topic metadata is refreshed by a reachable broker
+	health.Register(ctx, "ingestion", name, health.CheckerFunc(func(context.Context) error {
+		return client.RefreshMetadata(kCfg.Topic)
+	}))
+
+	k := &kafka{
+		name:    name,
+		label:   metrics.Flow(ctx),
+		from:    ser,
+		to:      kCfg.OutputSerialization,
+		logger:  cfg.Logger(),
+		dropped: map[string]struct{}{},
+	}
+
+	bCh := make(chan []byte, 1000)
+
+	for i := 0; i < kCfg.Workers; i++ {
+		go k.worker(ctx, ch, bCh)
+	}
+
+	go func() {
+		defer client.Close()
+		defer producer.Close()
+
+		for {
+			select {
+			case b := <-bCh:
+				if err := fault.Check(fault.KafkaPublish); err != nil {
+					k.deadLetter()
+					k.logger.Error("kafka", zap.Error(err))
+					continue
+				}
+
+				select {
+				case producer.Input() <- &sarama.ProducerMessage{
+					Topic: kCfg.Topic,
+					Value: sarama.ByteEncoder(b),
+				}:
+					metrics.IngestionDocuments(k.label, k.name, 1)
+				case err := <-producer.Errors():
+					k.deadLetter()
+					k.logger.Error("kafka", zap.Error(err))
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// deadLetter counts a message which has been given up
+func (k *kafka) deadLetter() {
+	drops.Add(drops.IngestionDeadLetter, k.name, 1)
+	metrics.IngestionError(k.label, k.name, 1)
+}
+
+func (k *kafka) worker(ctx context.Context, ch chan interface{}, bCh chan []byte) {
+	lane := priority.FromContext(ctx)
+
+	for {
+		r, ok := lane.Recv(ctx, ch)
+		if !ok {
+			return
+		}
+
+		tr := tracing.From(r)
+		tr.Begin("encode")
+
+		b, err := k.marshal(r)
+		if err != nil {
+			tr.Finish(err)
+			k.logger.Error("kafka", zap.Error(err))
+			continue
+		}
+
+		tr.End("encode")
+
+		// the producer loop stops once the context is done
+		// thus the message which isn't handed over is dropped.
+		select {
+		case bCh <- b:
+		case <-ctx.Done():
+			tr.Finish(tracing.ErrDropped)
+			return
+		}
+
+		// the trace ends once the message is handed to the producer loop
+		tr.Finish(nil)
+	}
+}
+
+// marshal converts the record to the output serialization and encodes it
+func (k *kafka) marshal(r interface{}) ([]byte, error) {
+	r, dropped, err := serialization.Convert(r, k.from, k.to)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dropped) > 0 {
+		k.warn(dropped)
+	}
+
+	if m, ok := r.(proto.Message); ok {
+		return serialization.Marshal(m)
+	}
+
+	if m, ok := r.(map[string]interface{}); ok && k.to == "msgpack" {
+		return serialization.MarshalMsgpack(m)
+	}
+
+	if m, ok := r.(map[string]interface{}); ok && k.to == "cbor" {
+		return serialization.MarshalCBOR(m)
+	}
+
+	return json.Marshal(r)
+}
+
+// warn logs the dropped fields once, a field is reported
+// only the first time it's dropped.
+func (k *kafka) warn(dropped []string) {
+	var fields []string
+
+	k.mu.Lock()
+	for _, f := range dropped {
+		if _, ok := k.dropped[f]; !ok {
+			k.dropped[f] = struct{}{}
+			fields = append(fields, f)
+		}
+	}
+	k.mu.Unlock()
+
+	if len(fields) > 0 {
+		k.logger.Warn("kafka", zap.String("msg", "fields don't exist in the "+k.to+" schema and have been dropped"),
+			zap.String("fields", strings.Join(fields, ",")))
+	}
+}
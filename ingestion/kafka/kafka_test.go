@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestKafkaConfig(t *testing.T) {
+	kCfg, err := kafkaConfig(map[string]interface{}{"topic": "legacy"}, "spb")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", kCfg.Topic)
+	assert.Equal(t, "spb", kCfg.OutputSerialization)
+
+	kCfg, err = kafkaConfig(map[string]interface{}{"outputSerialization": "pb"}, "spb")
+	assert.NoError(t, err)
+	assert.Equal(t, "pb", kCfg.OutputSerialization)
+
+	_, err = kafkaConfig(map[string]interface{}{"outputSerialization": "avro"}, "spb")
+	assert.EqualError(t, err, "output serialization avro is not supported")
+}
+
+func TestMarshal(t *testing.T) {
+	cfg := config.Config{}
+	ms := cfg.SetMockLogger("kafkaingestion")
+
+	k := &kafka{from: "json", to: "pb", logger: cfg.Logger(), dropped: map[string]struct{}{}}
+
+	record := func() map[string]interface{} {
+		return map[string]interface{}{"Task": "curl", "RTT": float64(310), "RTTP95": float64(320)}
+	}
+
+	b, err := k.marshal(record())
+	assert.NoError(t, err)
+
+	p := pb.Fields{}
+	assert.NoError(t, proto.Unmarshal(b, &p))
+	assert.Equal(t, "curl", p.GetTask())
+	assert.Equal(t, uint32(310), p.GetRTT())
+
+	// warns once
+	_, err = k.marshal(record())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(ms.String(), "RTTP95"))
+
+	// json output
+	k.to = "json"
+	b, err = k.marshal(record())
+	assert.NoError(t, err)
+
+	m := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, record(), m)
+}
+
+func TestWorkerLeak(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	k := &kafka{from: "json", to: "json", logger: zap.NewNop(), dropped: map[string]struct{}{}}
+	ch := make(chan interface{}, 1)
+	bCh := make(chan []byte)
+
+	// the worker is blocked on the producer channel which isn't read
+	go k.worker(ctx, ch, bCh)
+	ch <- map[string]interface{}{"RTT": 5.0}
+
+	// the goroutines are checked by the TestMain
+	assert.Eventually(t, func() bool { return len(ch) == 0 }, time.Second, time.Millisecond)
+	cancel()
+}
@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Verify verifies the connectivity of the kafka ingestion and the
+// topic metadata i.e. the partitions have the leaders, it doesn't
+// produce anything.
+func Verify(ctx context.Context, name string, report config.VerifyFunc) error {
+	cfg := config.FromContextServer(ctx)
+
+	kCfg, err := kafkaConfig(cfg.Ingestion[name].Config, flowSerialization(cfg, name))
+	if err != nil {
+		report("config", "", err)
+		return err
+	}
+
+	sCfg, err := saramaConfig(kCfg)
+	if err != nil {
+		report("config", "", err)
+		return err
+	}
+
+	report("config", fmt.Sprintf("topic %s (%s)", kCfg.Topic, kCfg.OutputSerialization), nil)
+
+	sCfg.Metadata.Retry.Max = 0
+	if deadline, ok := ctx.Deadline(); ok {
+		sCfg.Net.DialTimeout = time.Until(deadline)
+	}
+
+	client, err := sarama.NewClient(kCfg.Brokers, sCfg)
+	if err != nil {
+		report("connectivity", "", err)
+		return err
+	}
+	defer client.Close()
+
+	report("connectivity", fmt.Sprintf("%d brokers", len(client.Brokers())), nil)
+
+	partitions, err := client.Partitions(kCfg.Topic)
+	if err != nil {
+		report("topic", "", err)
+		return err
+	}
+
+	for _, p := range partitions {
+		if _, err := client.Leader(kCfg.Topic, p); err != nil {
+			err = fmt.Errorf("partition %d: %w", p, err)
+			report("topic", "", err)
+			return err
+		}
+	}
+
+	report("topic", fmt.Sprintf("topic %s has %d partitions", kCfg.Topic, len(partitions)), nil)
+
+	return nil
+}
+
+// flowSerialization returns the serialization of the flow which the
+// ingestion belongs to, it's the output serialization if it's not set.
+func flowSerialization(cfg *config.ServerConfig, name string) string {
+	for _, f := range cfg.Flow {
+		if f.Ingestion == name {
+			return f.Serialization
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestVerify(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetLeader("tcpdog", 0, broker.BrokerID()).
+		SetLeader("tcpdog", 1, broker.BrokerID())
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{"MetadataRequest": metadata})
+
+	cfg := &config.ServerConfig{
+		Ingestion: map[string]config.Ingestion{
+			"foo": {Type: "kafka", Config: map[string]interface{}{"brokers": []interface{}{broker.Addr()}}},
+		},
+		Flow: []config.Flow{{Ingress: "bar", Ingestion: "foo", Serialization: "spb"}},
+	}
+
+	var checks []string
+	report := func(check, detail string, err error) {
+		if err != nil {
+			checks = append(checks, check+": "+err.Error())
+			return
+		}
+		checks = append(checks, check+": "+detail)
+	}
+
+	ctx := cfg.WithContext(context.Background())
+
+	assert.NoError(t, Verify(ctx, "foo", report))
+	assert.Equal(t, []string{
+		"config: topic tcpdog (spb)",
+		"connectivity: 1 brokers",
+		"topic: topic tcpdog has 2 partitions",
+	}, checks)
+
+	// missing topic
+	checks = checks[:0]
+	cfg.Ingestion["foo"].Config["topic"] = "legacy"
+	assert.Error(t, Verify(ctx, "foo", report))
+	assert.Equal(t, "topic: kafka server: Request was for a topic or partition that does not exist on this broker.", checks[2])
+
+	// wrong serialization
+	checks = checks[:0]
+	cfg.Flow = nil
+	assert.Error(t, Verify(ctx, "foo", report))
+	assert.Equal(t, []string{"config: output serialization  is not supported"}, checks)
+}
@@ -0,0 +1,46 @@
+package file
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Config represents the file ingress configuration
+type Config struct {
+	// Path is the captured flow file, the json records are newline
+	// delimited and the other serializations are length-prefixed by
+	// a 4 bytes big-endian length and a crc32 (castagnoli) like the
+	// safewriter records.
+	Path string
+
+	// Rate is the max records per second (up to 1e9), the records
+	// are replayed as fast as possible if it's zero.
+	Rate int
+
+	// Loop replays the file from the start once it's finished
+	Loop bool
+
+	// MaxMessageSize is the max size in bytes of a decompressed
+	// message, the larger ones are dropped. it's 4MB by default.
+	MaxMessageSize int
+}
+
+func fileConfig(cfg map[string]interface{}) (*Config, error) {
+	c := &Config{}
+
+	if err := config.Transform(cfg, c); err != nil {
+		return nil, err
+	}
+
+	if c.Path == "" {
+		return nil, fmt.Errorf("file path is empty")
+	}
+
+	if c.Rate < 0 || time.Duration(c.Rate) > time.Second {
+		return nil, fmt.Errorf("invalid file rate: %d", c.Rate)
+	}
+
+	return c, nil
+}
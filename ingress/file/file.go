@@ -0,0 +1,178 @@
+// Package file replays the captured flows of a file e.g. for the
+// debugging or the load testing without a live kafka or gRPC source.
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/safewriter"
+)
+
+type replayer struct {
+	name          string
+	label         string
+	serialization string
+	cfg           *Config
+	logger        *zap.Logger
+	unmarshal     func(b []byte) (interface{}, error)
+}
+
+// Start replays the records of the file to the channel, it stops at the
+// end of the file unless the loop is set or once the context is done.
+func Start(ctx context.Context, name string, ser string, ch chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+
+	fCfg, err := fileConfig(cfg.Ingress[name].Config)
+	if err != nil {
+		return err
+	}
+
+	r := &replayer{
+		name:          name,
+		label:         metrics.Flow(ctx),
+		serialization: ser,
+		cfg:           fCfg,
+		logger:        cfg.Logger(),
+		unmarshal:     helper.Unmarshaler(ser, fCfg.MaxMessageSize),
+	}
+
+	if r.unmarshal == nil {
+		return fmt.Errorf("file doesn't support %s serialization", ser)
+	}
+
+	f, err := os.Open(fCfg.Path)
+	if err != nil {
+		return err
+	}
+
+	state := health.NewState(nil)
+	health.Register(ctx, "ingress", name, state)
+
+	go func() {
+		defer f.Close()
+
+		if err := r.replay(ctx, f, ch); err != nil {
+			r.logger.Error("file", zap.String("ingress", name), zap.Error(err))
+			state.Set(err)
+		}
+	}()
+
+	return nil
+}
+
+// replay reads the records of the file, the file is rewound at
+// the end if the loop is set.
+func (r *replayer) replay(ctx context.Context, f io.ReadSeeker, ch chan interface{}) error {
+	var tick <-chan time.Time
+
+	if r.cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(r.cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var (
+		read    = r.reader(f)
+		records int
+	)
+
+	for {
+		b, err := read()
+		if err == io.EOF {
+			if !r.cfg.Loop {
+				r.logger.Info("file", zap.String("msg", r.name+" replay has been finished"))
+				return nil
+			}
+
+			// the empty file isn't looped
+			if records == 0 {
+				return fmt.Errorf("file has no records to loop")
+			}
+
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+
+			read = r.reader(f)
+			records = 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		records++
+
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		i, err := r.unmarshal(b)
+		if err != nil {
+			metrics.UnmarshalError(r.label, r.serialization)
+			continue
+		}
+
+		select {
+		case ch <- i:
+			metrics.IngressMessage(r.label, r.name)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// reader returns the record reader of the serialization, the
+// json records are lines and the others are length-prefixed
+// records of the safewriter (length and crc32) e.g. the spool.
+func (r *replayer) reader(f io.Reader) func() ([]byte, error) {
+	if r.serialization == "json" {
+		br := bufio.NewReader(f)
+		return func() ([]byte, error) { return readLine(br) }
+	}
+
+	sr := safewriter.NewReader(f, safewriter.LengthPrefixed)
+	return func() ([]byte, error) { return readRecord(sr) }
+}
+
+// readLine returns the next non-empty line, the last
+// line doesn't need the newline.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := br.ReadBytes('\n')
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readRecord returns the next length-prefixed record, the record is
+// valid until the next call. the torn record (a truncated record or
+// a crc mismatch) is an error instead of the end of the file.
+func readRecord(sr *safewriter.Reader) ([]byte, error) {
+	b, err := sr.Next()
+	if err == io.EOF && sr.Torn() {
+		return nil, fmt.Errorf("torn record at offset %d", sr.Offset())
+	}
+
+	return b, err
+}
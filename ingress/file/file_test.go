@@ -0,0 +1,154 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/safewriter"
+)
+
+func start(t *testing.T, ser string, content []byte, fCfg map[string]interface{}) (chan interface{}, context.CancelFunc) {
+	path := filepath.Join(t.TempDir(), "flows")
+	assert.NoError(t, ioutil.WriteFile(path, content, 0644))
+
+	fCfg["path"] = path
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{"file01": {Type: "file", Config: fCfg}},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	ch := make(chan interface{}, 10)
+	assert.NoError(t, Start(ctx, "file01", ser, ch))
+
+	return ch, cancel
+}
+
+func recv(t *testing.T, ch chan interface{}) interface{} {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("record has not been replayed")
+	}
+
+	return nil
+}
+
+func TestStartJSON(t *testing.T) {
+	content := `{"RTT":5,"DAddr":"10.0.0.1"}` + "\n\n" + `{"RTT":7,"DAddr":"10.0.0.2"}` + "\n" +
+		`{"specversion":"1.0","id":"2fd4e1c67a2d28fc","data":{"RTT":9}}`
+
+	ch, cancel := start(t, "json", []byte(content), map[string]interface{}{})
+	defer cancel()
+
+	assert.Equal(t, map[string]interface{}{"RTT": float64(5), "DAddr": "10.0.0.1"}, recv(t, ch))
+	assert.Equal(t, map[string]interface{}{"RTT": float64(7), "DAddr": "10.0.0.2"}, recv(t, ch))
+	assert.Equal(t, map[string]interface{}{"RTT": float64(9)}, recv(t, ch))
+
+	// the replay stops at the end of the file
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected record: %v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// records returns the length-prefixed records of the safewriter
+func records(t *testing.T, recs ...[]byte) []byte {
+	path := filepath.Join(t.TempDir(), "records")
+	w, err := safewriter.Create(path, safewriter.Options{Mode: safewriter.LengthPrefixed})
+	assert.NoError(t, err)
+
+	for _, rec := range recs {
+		assert.NoError(t, w.Write(rec))
+	}
+	assert.NoError(t, w.Close())
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	return b
+}
+
+func TestStartPB(t *testing.T) {
+	var recs [][]byte
+
+	for _, rtt := range []uint32{5, 7} {
+		rtt := rtt
+		b, err := proto.Marshal(&pb.Fields{RTT: &rtt})
+		assert.NoError(t, err)
+		recs = append(recs, b)
+	}
+
+	content := records(t, recs...)
+
+	ch, cancel := start(t, "pb", content, map[string]interface{}{"loop": true, "rate": 100})
+	defer cancel()
+
+	// the file is replayed from the start
+	for _, rtt := range []uint32{5, 7, 5} {
+		assert.Equal(t, rtt, recv(t, ch).(*pb.Fields).GetRTT())
+	}
+}
+
+func TestReadRecord(t *testing.T) {
+	content := records(t, []byte("abc"), []byte("def"))
+	crc := append([]byte{}, content...)
+	crc[8] = 'x'
+
+	for _, tc := range []struct {
+		content []byte
+		records []string
+		err     string
+	}{
+		{content: content, records: []string{"abc", "def"}},
+		{content: content[:2], err: "torn record at offset 0"},
+		{content: content[:len(content)-1], records: []string{"abc"}, err: "torn record at offset 11"},
+		{content: crc, err: "torn record at offset 0"},
+	} {
+		sr := safewriter.NewReader(bytes.NewReader(tc.content), safewriter.LengthPrefixed)
+		for _, expected := range tc.records {
+			b, err := readRecord(sr)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(b))
+		}
+
+		_, err := readRecord(sr)
+		if tc.err == "" {
+			assert.Equal(t, io.EOF, err)
+			continue
+		}
+		assert.EqualError(t, err, tc.err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"file01": {Type: "file", Config: map[string]interface{}{}},
+			"file02": {Type: "file", Config: map[string]interface{}{"path": "/tmp/flows", "rate": -1}},
+			"file03": {Type: "file", Config: map[string]interface{}{"path": "/not-exist/flows"}},
+			"file04": {Type: "file", Config: map[string]interface{}{"path": "/tmp/flows", "rate": 2e9}},
+		},
+	}
+	cfg.SetMockLogger("memory")
+	ctx := cfg.WithContext(context.Background())
+
+	assert.EqualError(t, Start(ctx, "file01", "json", nil), "file path is empty")
+	assert.EqualError(t, Start(ctx, "file02", "json", nil), "invalid file rate: -1")
+	assert.EqualError(t, Start(ctx, "file04", "json", nil), "invalid file rate: 2000000000")
+	assert.EqualError(t, Start(ctx, "file03", "foo", nil), "file doesn't support foo serialization")
+	assert.Error(t, Start(ctx, "file03", "json", nil))
+}
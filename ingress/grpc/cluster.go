@@ -0,0 +1,415 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// forwardedKey marks the streams between the server instances
+// so a forwarded record never gets forwarded again.
+const forwardedKey = "tcpdog-forwarded"
+
+// forwardedStream is the context key of a stream which has been
+// forwarded by a trusted peer instance.
+type forwardedStream struct{}
+
+// clusterKey is the context key of the flows which route their
+// records by the cluster, their processors have opted in.
+type clusterKey struct{}
+
+// dropLogInterval is the minimum interval between the drop
+// logs of a peer, the drops in between are summed up.
+var dropLogInterval = 10 * time.Second
+
+// ClusterConfig represents the work sharing configuration between
+// multiple server instances behind one load balancer. the records
+// are routed by flow key to their owner instance with rendezvous hashing.
+type ClusterConfig struct {
+	// Self is the address of this instance as the peers see it
+	Self string
+	// Peers is the static list of the other instances
+	Peers []string
+	// StatsInterval is the peers stats logging interval in seconds
+	StatsInterval int
+	TLSConfig     *config.TLSConfig
+	// PeerNetworks are the networks (CIDR) which the peers streams
+	// come from e.g. the peers behind a NAT, the forwarded streams of
+	// the other addresses are routed like the agents streams. it's the
+	// addresses of the peers by default, their names are resolved once.
+	PeerNetworks []string
+}
+
+type cluster struct {
+	self   string
+	nodes  []string
+	peers  map[string]*peer
+	logger *zap.Logger
+	// name is the ingress name
+	name string
+	// trusted are the networks of the peers streams
+	trusted []*net.IPNet
+}
+
+type peer struct {
+	addr      string
+	ch        chan interface{}
+	up        int32
+	mUp       func(float64)
+	forwarded uint64
+	dropped   uint64
+	// logged is the dropped count at the last drop log
+	// and lastLog is its unix nano timestamp
+	logged  uint64
+	lastLog int64
+}
+
+func newCluster(ctx context.Context, name string, cCfg *ClusterConfig, logger *zap.Logger) (*cluster, error) {
+	if cCfg.Self == "" {
+		return nil, fmt.Errorf("cluster self address is not defined")
+	}
+
+	opts, err := peerDialOpts(cCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	trusted, err := peerNetworks(cCfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cluster{
+		self:    cCfg.Self,
+		nodes:   []string{cCfg.Self},
+		peers:   map[string]*peer{},
+		logger:  logger,
+		name:    name,
+		trusted: trusted,
+	}
+
+	for _, addr := range cCfg.Peers {
+		if _, ok := c.peers[addr]; ok || addr == cCfg.Self {
+			continue
+		}
+
+		p := &peer{
+			addr: addr,
+			ch:   make(chan interface{}, 1000),
+			mUp:  metrics.ClusterPeerUp(name, addr),
+		}
+		p.mUp(0)
+
+		c.peers[addr] = p
+		c.nodes = append(c.nodes, addr)
+
+		go p.run(ctx, opts, logger)
+	}
+
+	sort.Strings(c.nodes)
+
+	if cCfg.StatsInterval > 0 {
+		go c.stats(ctx, time.Duration(cCfg.StatsInterval)*time.Second)
+	}
+
+	return c, nil
+}
+
+// forward sends the record to its owner instance and returns
+// false if the record belongs to this instance.
+func (c *cluster) forward(fields interface{}) bool {
+	owner := c.owner(flowKey(fields))
+	if owner == c.self {
+		return false
+	}
+
+	p := c.peers[owner]
+
+	select {
+	case p.ch <- fields:
+		atomic.AddUint64(&p.forwarded, 1)
+		metrics.ClusterForward(c.name, owner, metrics.ClusterForwarded)
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+		drops.Add(drops.ServerChannel, c.name, 1)
+		metrics.ClusterForward(c.name, owner, metrics.ClusterDropped)
+		c.logDrops(p)
+	}
+
+	return true
+}
+
+// logDrops logs the drops of the peer at most once per dropLogInterval
+// otherwise a down peer floods the log by a line per record.
+func (c *cluster) logDrops(p *peer) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&p.lastLog)
+	if now-last < int64(dropLogInterval) || !atomic.CompareAndSwapInt64(&p.lastLog, last, now) {
+		return
+	}
+
+	dropped := atomic.LoadUint64(&p.dropped)
+	n := dropped - atomic.SwapUint64(&p.logged, dropped)
+
+	c.logger.Error("grpc", zap.String("msg", "data has been dropped"), zap.String("peer", p.addr),
+		zap.Uint64("records", n))
+}
+
+// owner returns the instance with the highest rendezvous weight
+// for the key. the unhealthy peers are skipped so their share of
+// the flows moves to the others until they come back.
+func (c *cluster) owner(key []byte) string {
+	var (
+		owner string
+		max   uint64
+	)
+
+	for _, node := range c.nodes {
+		if p, ok := c.peers[node]; ok && atomic.LoadInt32(&p.up) == 0 {
+			continue
+		}
+
+		h := fnv.New64a()
+		h.Write([]byte(node))
+		h.Write(key)
+
+		if w := h.Sum64(); owner == "" || w > max {
+			owner, max = node, w
+		}
+	}
+
+	return owner
+}
+
+func (c *cluster) stats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for addr, p := range c.peers {
+				c.logger.Info("grpc", zap.String("peer", addr),
+					zap.Bool("up", atomic.LoadInt32(&p.up) == 1),
+					zap.Uint64("forwarded", atomic.LoadUint64(&p.forwarded)),
+					zap.Uint64("dropped", atomic.LoadUint64(&p.dropped)))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *peer) run(ctx context.Context, opts []grpc.DialOption, logger *zap.Logger) {
+	backoff := helper.NewBackoff(logger)
+	ctx = metadata.AppendToOutgoingContext(ctx, forwardedKey, "true")
+
+	for {
+		if !backoff.Wait(ctx) {
+			return
+		}
+
+		conn, err := grpc.Dial(p.addr, opts...)
+		if err != nil {
+			logger.Warn("grpc", zap.String("peer", p.addr), zap.Error(err))
+			continue
+		}
+
+		err = p.send(ctx, pb.NewTCPDogClient(conn), logger)
+		p.setUp(0)
+		conn.Close()
+
+		if err != nil {
+			logger.Warn("grpc", zap.String("peer", p.addr), zap.Error(err))
+			continue
+		}
+
+		return
+	}
+}
+
+func (p *peer) send(ctx context.Context, client pb.TCPDogClient, logger *zap.Logger) error {
+	streamPB, err := client.Tracepoint(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+
+	streamSPB, err := client.TracepointSPB(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+
+	p.setUp(1)
+	logger.Info("grpc", zap.String("msg", fmt.Sprintf("peer %s is up", p.addr)))
+
+	for {
+		select {
+		case fields := <-p.ch:
+			switch v := fields.(type) {
+			case *pb.Fields:
+				err = streamPB.Send(v)
+			case *pb.FieldsSPB:
+				err = streamSPB.Send(v)
+			}
+
+			if err != nil {
+				logger.Info("grpc", zap.String("msg", fmt.Sprintf("peer %s is down", p.addr)))
+				return err
+			}
+		case <-ctx.Done():
+			streamPB.CloseAndRecv()
+			streamSPB.CloseAndRecv()
+			return nil
+		}
+	}
+}
+
+// setUp sets the peer health and its gauge
+func (p *peer) setUp(up int32) {
+	atomic.StoreInt32(&p.up, up)
+
+	if p.mUp != nil {
+		p.mUp(float64(up))
+	}
+}
+
+// flowKey returns the connection tuple of a record.
+func flowKey(fields interface{}) []byte {
+	var key []byte
+
+	switch v := fields.(type) {
+	case *pb.Fields:
+		key = append(key, v.GetSAddr()...)
+		key = strconv.AppendUint(append(key, '|'), uint64(v.GetLPort()), 10)
+		key = append(append(key, '|'), v.GetDAddr()...)
+		key = strconv.AppendUint(append(key, '|'), uint64(v.GetDPort()), 10)
+	case *pb.FieldsSPB:
+		f := v.GetFields().GetFields()
+		key = append(key, f["SAddr"].GetStringValue()...)
+		key = strconv.AppendFloat(append(key, '|'), f["LPort"].GetNumberValue(), 'f', -1, 64)
+		key = append(append(key, '|'), f["DAddr"].GetStringValue()...)
+		key = strconv.AppendFloat(append(key, '|'), f["DPort"].GetNumberValue(), 'f', -1, 64)
+	}
+
+	return key
+}
+
+// WithCluster returns a copy of the context which enables the cluster
+// routing of the ingress, the flow processor has opted in.
+func WithCluster(ctx context.Context) context.Context {
+	return context.WithValue(ctx, clusterKey{}, true)
+}
+
+// clustered returns true if the flow routes its records by the cluster
+func clustered(ctx context.Context) bool {
+	on, _ := ctx.Value(clusterKey{}).(bool)
+	return on
+}
+
+// authorize marks the stream as forwarded if it has the forwarded
+// metadata and it comes from a trusted peer, the metadata of the
+// other streams is ignored thus an agent can't bypass the routing.
+func (c *cluster) authorize(ctx context.Context) context.Context {
+	if c == nil {
+		return ctx
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(forwardedKey)) < 1 {
+		return ctx
+	}
+
+	ip := net.ParseIP(peerHost(ctx))
+	for _, n := range c.trusted {
+		if ip != nil && n.Contains(ip) {
+			return context.WithValue(ctx, forwardedStream{}, true)
+		}
+	}
+
+	c.logger.Warn("grpc", zap.String("msg", "forwarded stream from an untrusted peer"),
+		zap.String("peer", peerHost(ctx)))
+
+	return ctx
+}
+
+// isForwarded returns true if the stream comes from a trusted peer instance.
+func isForwarded(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(forwardedStream{}).(bool)
+	return forwarded
+}
+
+// peerNetworks returns the configured peer networks or the
+// networks of the peers addresses, a name is resolved once.
+func peerNetworks(cCfg *ClusterConfig, logger *zap.Logger) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	if len(cCfg.PeerNetworks) > 0 {
+		for _, cidr := range cCfg.PeerNetworks {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, n)
+		}
+
+		return nets, nil
+	}
+
+	for _, addr := range cCfg.Peers {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+
+		ips := []net.IP{net.ParseIP(host)}
+		if ips[0] == nil {
+			ips, err = net.LookupIP(host)
+			if err != nil {
+				logger.Warn("grpc", zap.String("peer", addr), zap.Error(err))
+				continue
+			}
+		}
+
+		for _, ip := range ips {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+
+	return nets, nil
+}
+
+// forceCodec encodes the forwarded records by the generated codec
+var forceCodec = grpc.WithDefaultCallOptions(grpc.ForceCodec(serialization.Codec{}))
+
+func peerDialOpts(cCfg *ClusterConfig) ([]grpc.DialOption, error) {
+	if cCfg.TLSConfig != nil && cCfg.TLSConfig.Enable {
+		creds, err := config.GetCreds(cCfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		return []grpc.DialOption{grpc.WithTransportCredentials(creds), forceCodec}, nil
+	}
+
+	return []grpc.DialOption{grpc.WithInsecure(), forceCodec}, nil
+}
@@ -0,0 +1,168 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func testCluster(self string, peers ...string) *cluster {
+	c := &cluster{
+		self:   self,
+		nodes:  []string{self},
+		peers:  map[string]*peer{},
+		logger: zap.NewNop(),
+	}
+
+	for _, addr := range peers {
+		c.peers[addr] = &peer{addr: addr, ch: make(chan interface{}, 10), up: 1}
+		c.nodes = append(c.nodes, addr)
+	}
+
+	return c
+}
+
+func TestFlowKey(t *testing.T) {
+	saddr, daddr := "10.0.0.1", "10.0.0.2"
+	lport, dport := uint32(5000), uint32(443)
+
+	pbKey := flowKey(&pb.Fields{SAddr: &saddr, DAddr: &daddr, LPort: &lport, DPort: &dport})
+	assert.Equal(t, "10.0.0.1|5000|10.0.0.2|443", string(pbKey))
+
+	spb, err := structpb.NewStruct(map[string]interface{}{
+		"SAddr": saddr,
+		"DAddr": daddr,
+		"LPort": float64(lport),
+		"DPort": float64(dport),
+		"RTT":   float64(10),
+	})
+	assert.NoError(t, err)
+
+	spbKey := flowKey(&pb.FieldsSPB{Fields: spb})
+	assert.Equal(t, pbKey, spbKey)
+}
+
+func TestClusterOwner(t *testing.T) {
+	nodes := []string{"a:8085", "b:8085", "c:8085"}
+
+	// all instances agree on the owner
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("10.0.0.1|%d|10.0.0.2|443", i))
+		owner := testCluster(nodes[0], nodes[1:]...).owner(key)
+		assert.Equal(t, owner, testCluster(nodes[1], nodes[0], nodes[2]).owner(key))
+		assert.Equal(t, owner, testCluster(nodes[2], nodes[0], nodes[1]).owner(key))
+	}
+
+	// unhealthy peer's flows move to the others
+	c := testCluster(nodes[0], nodes[1:]...)
+	c.peers[nodes[1]].up = 0
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("10.0.0.1|%d|10.0.0.2|443", i))
+		assert.NotEqual(t, nodes[1], c.owner(key))
+	}
+}
+
+func TestClusterForward(t *testing.T) {
+	c := testCluster("a:8085", "b:8085")
+	forwarded, local := 0, 0
+
+	for i := uint32(0); i < 100; i++ {
+		saddr, lport := "10.0.0.1", i
+		if c.forward(&pb.Fields{SAddr: &saddr, LPort: &lport}) {
+			forwarded++
+		} else {
+			local++
+		}
+	}
+
+	assert.Greater(t, forwarded, 0)
+	assert.Greater(t, local, 0)
+	assert.Equal(t, uint64(10), c.peers["b:8085"].forwarded)
+	assert.Len(t, c.peers["b:8085"].ch, 10)
+	assert.Equal(t, uint64(forwarded-10), c.peers["b:8085"].dropped)
+}
+
+func TestClusterDropLog(t *testing.T) {
+	cfg := config.Config{}
+	ms := cfg.SetMockLogger("cluster")
+
+	c := testCluster("a:8085", "b:8085")
+	c.name = "grpc-cluster"
+	c.logger = cfg.Logger()
+	// the peer owns all of the flows and its queue is full
+	c.nodes = []string{"b:8085"}
+	p := c.peers["b:8085"]
+	p.ch = make(chan interface{})
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, c.forward(&pb.Fields{}))
+	}
+
+	// the drops of the interval are logged once
+	assert.Equal(t, 1, strings.Count(ms.String(), "\n"))
+	assert.Contains(t, ms.String(), `"peer":"b:8085","records":1}`)
+	assert.Equal(t, uint64(10), drops.Count(drops.ServerChannel, "grpc-cluster"))
+
+	ms.Reset()
+	atomic.StoreInt64(&p.lastLog, 0)
+	c.forward(&pb.Fields{})
+
+	assert.Contains(t, ms.String(), `"peer":"b:8085","records":10}`)
+}
+
+func TestIsForwarded(t *testing.T) {
+	nets, err := peerNetworks(&ClusterConfig{Peers: []string{"10.0.0.2:8085", "[fd00::2]:8085"}}, zap.NewNop())
+	assert.NoError(t, err)
+
+	c := testCluster("10.0.0.1:8085", "10.0.0.2:8085")
+	c.trusted = nets
+
+	// the metadata is honored only from the peers
+	assert.True(t, isForwarded(c.authorize(peerContext("10.0.0.2", forwardedKey, "true"))))
+	assert.True(t, isForwarded(c.authorize(peerContext("fd00::2", forwardedKey, "true"))))
+	assert.False(t, isForwarded(c.authorize(peerContext("10.0.0.3", forwardedKey, "true"))))
+	assert.False(t, isForwarded(c.authorize(peerContext("10.0.0.2"))))
+	assert.False(t, isForwarded(c.authorize(context.Background())))
+
+	// an ingress without the cluster doesn't honor it
+	var nc *cluster
+	assert.False(t, isForwarded(nc.authorize(peerContext("10.0.0.2", forwardedKey, "true"))))
+
+	// the configured networks override the peers addresses
+	nets, err = peerNetworks(&ClusterConfig{Peers: []string{"10.0.0.2:8085"}, PeerNetworks: []string{"192.168.0.0/16"}}, zap.NewNop())
+	assert.NoError(t, err)
+	c.trusted = nets
+	assert.True(t, isForwarded(c.authorize(peerContext("192.168.1.5", forwardedKey, "true"))))
+	assert.False(t, isForwarded(c.authorize(peerContext("10.0.0.2", forwardedKey, "true"))))
+
+	_, err = peerNetworks(&ClusterConfig{PeerNetworks: []string{"10.0.0.0"}}, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestClustered(t *testing.T) {
+	assert.False(t, clustered(context.Background()))
+	assert.True(t, clustered(WithCluster(context.Background())))
+}
+
+func TestPeerUp(t *testing.T) {
+	var v float64
+	p := &peer{addr: "b:8085", mUp: func(f float64) { v = f }}
+
+	p.setUp(1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&p.up))
+	assert.Equal(t, float64(1), v)
+
+	p.setUp(0)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&p.up))
+	assert.Equal(t, float64(0), v)
+}
@@ -1,7 +1,8 @@
 package grpc
 
 import (
-	"log"
+	"fmt"
+	"net"
 
 	"github.com/mehrdadrad/tcpdog/config"
 )
@@ -11,17 +12,110 @@ type Config struct {
 	Addr             string
 	NumStreamWorkers uint32
 	TLSConfig        *config.TLSConfig
+	Cluster          *ClusterConfig
+	// Listeners overrides the Addr and the TLSConfig, all of
+	// them feed the same flow.
+	Listeners []Listener
+	// AllowInsecure allows the listeners without TLS on
+	// the non-loopback addresses.
+	AllowInsecure bool
+	// Smoothing delays the excess records per peer e.g.
+	// once many agents reconnect and replay their buffers.
+	Smoothing *SmoothingConfig
+	// FlowControl sends the backpressure hints to the
+	// subscribed agents once the flow is congested.
+	FlowControl *FlowControlConfig
+	// SharedListener serves the http endpoints of the same
+	// address (e.g. the admin http) on the gRPC listener, it's
+	// served by the net/http HTTP/2 transport thus it doesn't
+	// support the keepalive.
+	SharedListener bool
+	// Quarantine drops the malformed messages and it quarantines
+	// the peers which send too many of them.
+	Quarantine *QuarantineConfig
+	// Health configures the saturation of the gRPC health
+	// service, the service is registered by default.
+	Health *HealthConfig
+	// Reflection registers the server reflection e.g. grpcurl
+	Reflection bool
+	// Keepalive configures the keepalive pings of the servers
+	// and the enforcement policy of the agents pings.
+	Keepalive *KeepaliveConfig
+	// MaxRecvMsgSize is the max message size in bytes which
+	// the servers receive, it's 4MB by default.
+	MaxRecvMsgSize int
 }
 
-func grpcConfig(cfg map[string]interface{}) *Config {
+// Listener represents a gRPC listener
+type Listener struct {
+	Addr           string
+	TLSConfig      *config.TLSConfig
+	SharedListener bool
+}
+
+func grpcConfig(cfg map[string]interface{}) (*Config, error) {
 	// default configuration
 	conf := &Config{
 		Addr: ":8085",
 	}
 
 	if err := config.Transform(cfg, conf); err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// listeners returns the configured listeners, the Addr and the
+// TLSConfig is the only listener if the listeners are not set.
+func (c *Config) listeners() ([]Listener, error) {
+	if len(c.Listeners) < 1 {
+		l := Listener{Addr: c.Addr, TLSConfig: c.TLSConfig, SharedListener: c.SharedListener}
+		if err := c.validateShared(l); err != nil {
+			return nil, err
+		}
+
+		return []Listener{l}, nil
+	}
+
+	for _, l := range c.Listeners {
+		if err := c.validateShared(l); err != nil {
+			return nil, err
+		}
+
+		if l.TLSConfig != nil && l.TLSConfig.Enable {
+			continue
+		}
+
+		if !c.AllowInsecure && !isLoopback(l.Addr) {
+			return nil, fmt.Errorf("listener %s requires TLS or allowInsecure", l.Addr)
+		}
 	}
 
-	return conf
+	return c.Listeners, nil
+}
+
+// validateShared rejects the options which the shared listener
+// ignores, the http server handles the HTTP/2 pings itself.
+func (c *Config) validateShared(l Listener) error {
+	if l.SharedListener && c.Keepalive != nil {
+		return fmt.Errorf("shared listener %s doesn't support keepalive", l.Addr)
+	}
+
+	return nil
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
 }
@@ -0,0 +1,217 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mehrdadrad/tcpdog/metrics"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// FlowControlConfig represents the backpressure hints configuration,
+// the subscribed agents are asked to slow down once the flow channel
+// occupancy crosses the high watermark and to resume below the low one.
+type FlowControlConfig struct {
+	// HighWatermark and LowWatermark are the occupancy ratios (0-1]
+	HighWatermark float64
+	LowWatermark  float64
+	// MaxFactor is the maximum sampling divisor of the slow down,
+	// the factor is doubled per interval above the high watermark.
+	MaxFactor uint32
+	// Interval is the occupancy check interval in milliseconds
+	Interval int
+}
+
+// bufferKey is the context key of the flow channel
+type bufferKey struct{}
+
+// WithBuffer returns a copy of the context with the flow channel, the
+// flow control and the health check measure its occupancy since the
+// ingress channel isn't the flow channel e.g. a dropping overflow.
+func WithBuffer(ctx context.Context, ch chan interface{}) context.Context {
+	return context.WithValue(ctx, bufferKey{}, ch)
+}
+
+// buffer returns the flow channel of the context or the ingress channel
+func buffer(ctx context.Context, ch chan interface{}) chan interface{} {
+	if b, ok := ctx.Value(bufferKey{}).(chan interface{}); ok {
+		return b
+	}
+
+	return ch
+}
+
+type flowController struct {
+	ch        chan interface{}
+	high      float64
+	low       float64
+	maxFactor uint32
+	logger    *zap.Logger
+
+	// the throttle state metrics
+	mFactor func(float64)
+	mSubs   func(float64)
+
+	mu     sync.Mutex
+	factor uint32
+	subs   map[chan *pb.FlowControlHint]struct{}
+
+	slowdowns uint64
+}
+
+func newFlowController(ctx context.Context, name string, fCfg *FlowControlConfig, ch chan interface{}, logger *zap.Logger) (*flowController, error) {
+	f := &flowController{
+		ch:        ch,
+		high:      fCfg.HighWatermark,
+		low:       fCfg.LowWatermark,
+		maxFactor: fCfg.MaxFactor,
+		logger:    logger,
+		mFactor:   metrics.FlowControlFactor(name),
+		mSubs:     metrics.FlowControlSubscribers(name),
+		factor:    1,
+		subs:      map[chan *pb.FlowControlHint]struct{}{},
+	}
+
+	if f.high == 0 {
+		f.high = 0.8
+	}
+
+	if f.low == 0 {
+		f.low = 0.5
+	}
+
+	if f.maxFactor == 0 {
+		f.maxFactor = 8
+	}
+
+	if f.high > 1 || f.low < 0 || f.low >= f.high {
+		return nil, fmt.Errorf("wrong flow control watermarks low:%g high:%g", f.low, f.high)
+	}
+
+	interval := time.Duration(fCfg.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	f.mFactor(1)
+
+	go f.run(ctx, interval)
+
+	return f, nil
+}
+
+func (f *flowController) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check compares the flow channel occupancy with the watermarks
+// and broadcasts the hint if the throttle state has been changed.
+func (f *flowController) check() {
+	occupancy := float64(len(f.ch)) / float64(cap(f.ch))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case occupancy >= f.high && f.factor < f.maxFactor:
+		f.factor *= 2
+		if f.factor > f.maxFactor {
+			f.factor = f.maxFactor
+		}
+
+		slowdowns := atomic.AddUint64(&f.slowdowns, 1)
+		f.mFactor(float64(f.factor))
+		f.broadcast(&pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: f.factor})
+
+		f.logger.Warn("grpc", zap.String("msg", "agents have been asked to slow down"),
+			zap.Uint32("factor", f.factor), zap.Float64("occupancy", occupancy),
+			zap.Int("subscribers", len(f.subs)), zap.Uint64("slowdowns", slowdowns))
+
+	case occupancy <= f.low && f.factor > 1:
+		f.factor = 1
+		f.mFactor(1)
+		f.broadcast(&pb.FlowControlHint{Action: pb.FlowControlAction_RESUME})
+
+		f.logger.Info("grpc", zap.String("msg", "agents have been asked to resume"),
+			zap.Float64("occupancy", occupancy), zap.Int("subscribers", len(f.subs)))
+	}
+}
+
+// broadcast sends the hint to the subscribers, a slow subscriber
+// gets the latest hint only.
+func (f *flowController) broadcast(h *pb.FlowControlHint) {
+	for ch := range f.subs {
+		select {
+		case ch <- h:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- h
+		}
+	}
+}
+
+// subscribe returns the hints channel, it has the current
+// slow down hint if the agents are already throttled.
+func (f *flowController) subscribe() (chan *pb.FlowControlHint, func()) {
+	ch := make(chan *pb.FlowControlHint, 1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.subs[ch] = struct{}{}
+	f.mSubs(1)
+	if f.factor > 1 {
+		ch <- &pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: f.factor}
+	}
+
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		delete(f.subs, ch)
+		f.mSubs(-1)
+	}
+}
+
+// FlowControl streams the backpressure hints to the agent
+func (s *Server) FlowControl(req *pb.FlowControlRequest, srv pb.TCPDog_FlowControlServer) error {
+	if s.flow == nil {
+		return status.Error(codes.Unimplemented, "flow control is not enabled")
+	}
+
+	ch, cancel := s.flow.subscribe()
+	defer cancel()
+
+	s.logger.Info("grpc", zap.String("msg", req.GetHostname()+" has been subscribed to flow control"))
+
+	for {
+		select {
+		case h := <-ch:
+			if err := srv.Send(h); err != nil {
+				return err
+			}
+		case <-srv.Context().Done():
+			return nil
+		}
+	}
+}
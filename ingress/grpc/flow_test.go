@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestFlowController(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := newFlowController(ctx, "grpc01", &FlowControlConfig{HighWatermark: 0.5, LowWatermark: 0.6}, nil, zap.NewNop())
+	assert.EqualError(t, err, "wrong flow control watermarks low:0.6 high:0.5")
+
+	ch := make(chan interface{}, 10)
+	f, err := newFlowController(ctx, "grpc01", &FlowControlConfig{MaxFactor: 4, Interval: 3600000}, ch, zap.NewNop())
+	assert.NoError(t, err)
+
+	var factor, subs float64
+	f.mFactor = func(v float64) { factor = v }
+	f.mSubs = func(v float64) { subs += v }
+
+	hints, unsubscribe := f.subscribe()
+	defer unsubscribe()
+	assert.Equal(t, float64(1), subs)
+
+	// under the high watermark
+	for i := 0; i < 7; i++ {
+		ch <- i
+	}
+	f.check()
+	assert.Len(t, hints, 0)
+
+	// slow down, it's doubled up to the max factor
+	ch <- 7
+	f.check()
+	assert.Equal(t, &pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: 2}, <-hints)
+
+	f.check()
+	f.check()
+	assert.Equal(t, &pb.FlowControlHint{Action: pb.FlowControlAction_SLOW_DOWN, Factor: 4}, <-hints)
+	assert.Len(t, hints, 0)
+	assert.Equal(t, float64(4), factor)
+
+	// a new subscriber gets the current state
+	late, unsubscribeLate := f.subscribe()
+	assert.Equal(t, uint32(4), (<-late).Factor)
+	unsubscribeLate()
+
+	// resume under the low watermark
+	for i := 0; i < 4; i++ {
+		<-ch
+	}
+	f.check()
+	assert.Equal(t, pb.FlowControlAction_RESUME, (<-hints).Action)
+	assert.Equal(t, float64(1), factor)
+
+	f.check()
+	assert.Len(t, hints, 0)
+	assert.Len(t, f.subs, 1)
+	assert.Equal(t, float64(1), subs)
+}
+
+func TestBuffer(t *testing.T) {
+	in := make(chan interface{}, 64)
+	ch := make(chan interface{}, 1000)
+
+	assert.Equal(t, in, buffer(context.Background(), in))
+	assert.Equal(t, ch, buffer(WithBuffer(context.Background(), ch), in))
+}
@@ -4,56 +4,158 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/stats"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
 // Server represents gRPC server
 type Server struct {
-	ch     chan interface{}
-	logger *zap.Logger
+	name       string
+	label      string
+	ch         chan interface{}
+	logger     *zap.Logger
+	cluster    *cluster
+	smoother   *smoother
+	flow       *flowController
+	quarantine *quarantine
 }
 
 // Tracepoint receives protobuf messages
 func (s *Server) Tracepoint(srv pb.TCPDog_TracepointServer) error {
+	ctx := s.cluster.authorize(srv.Context())
+
+	b, release, err := s.smoother.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	recv := s.recv(ctx, srv)
+	defer recv.release()
+
 	for {
-		fields, err := srv.Recv()
-		if err != nil {
+		fields := &pb.Fields{}
+		if err := recv.next(fields); err != nil {
 			return err
 		}
 
-		select {
-		case s.ch <- fields:
-		default:
-			s.logger.Error("grpc", zap.String("msg", "data has been dropped"))
+		if err := b.wait(ctx); err != nil {
+			return err
 		}
+
+		s.dispatch(ctx, fields)
 	}
 }
 
 // TracepointSPB receives struct protobuf messages
 func (s *Server) TracepointSPB(srv pb.TCPDog_TracepointSPBServer) error {
+	ctx := s.cluster.authorize(srv.Context())
+
+	b, release, err := s.smoother.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	recv := s.recv(ctx, srv)
+	defer recv.release()
+
 	for {
-		fields, err := srv.Recv()
-		if err != nil {
+		fields := &pb.FieldsSPB{}
+		if err := recv.next(fields); err != nil {
+			return err
+		}
+		fields.Fields = helper.UnwrapCloudEventSPB(fields.Fields)
+
+		if err := b.wait(ctx); err != nil {
 			return err
 		}
 
-		select {
-		case s.ch <- fields:
-		default:
-			s.logger.Error("grpc", zap.String("msg", "data has been dropped"))
+		s.dispatch(ctx, fields)
+	}
+}
+
+// receiver receives the stream messages through the
+// quarantine if it's configured. the server forces the raw frame
+// codec thus the frames are decoded by the stream's content subtype.
+type receiver struct {
+	next    func(m proto.Message) error
+	release func()
+}
+
+func (s *Server) recv(ctx context.Context, stream grpc.ServerStream) receiver {
+	if s.quarantine == nil {
+		f := &frame{}
+		unmarshal := frameUnmarshaler(ctx)
+
+		return receiver{
+			next: func(m proto.Message) error {
+				if err := stream.RecvMsg(f); err != nil {
+					return err
+				}
+
+				return unmarshal(f.b, m)
+			},
+			release: func() {},
 		}
 	}
+
+	p, release := s.quarantine.acquire(ctx)
+
+	return receiver{
+		next: func(m proto.Message) error {
+			return s.quarantine.recv(ctx, p, stream, m)
+		},
+		release: release,
+	}
+}
+
+// dispatch routes the record to its owner instance in cluster
+// mode otherwise sends it to the ingestion.
+func (s *Server) dispatch(ctx context.Context, fields interface{}) {
+	if s.cluster != nil && !isForwarded(ctx) && s.cluster.forward(fields) {
+		return
+	}
+
+	var tr *tracing.Trace
+	if tracing.Enabled() {
+		tr = tracing.Sample(fields, "grpc.receive", "", time.Now())
+	}
+
+	select {
+	case s.ch <- fields:
+		metrics.IngressMessage(s.label, s.name)
+	default:
+		tr.Finish(tracing.ErrDropped)
+		drops.Add(drops.ServerChannel, s.name, 1)
+		s.logger.Error("grpc", zap.String("msg", "data has been dropped"))
+	}
 }
 
+type connKey struct{}
+
+// statsHandler keeps the connection metrics of a listener
 type statsHandler struct {
-	remoteAddr net.Addr
-	logger     *zap.Logger
+	addr   string
+	logger *zap.Logger
+	active int64
+	total  int64
 }
 
 func (h *statsHandler) TagRPC(ctx context.Context, s *stats.RPCTagInfo) context.Context {
@@ -63,61 +165,193 @@ func (h *statsHandler) TagRPC(ctx context.Context, s *stats.RPCTagInfo) context.
 func (h *statsHandler) HandleRPC(context.Context, stats.RPCStats) {}
 
 func (h *statsHandler) TagConn(ctx context.Context, s *stats.ConnTagInfo) context.Context {
-	h.remoteAddr = s.RemoteAddr
-	return ctx
+	return context.WithValue(ctx, connKey{}, s.RemoteAddr)
 }
 
 func (h *statsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {
+	remoteAddr, _ := ctx.Value(connKey{}).(net.Addr)
+
 	switch s.(type) {
 	case *stats.ConnEnd:
-		h.logger.Info("grpc", zap.String("msg", fmt.Sprintf("%s has been disconnected", h.remoteAddr)))
+		h.disconnected(remoteAddr)
 	case *stats.ConnBegin:
-		h.logger.Info("grpc", zap.String("msg", fmt.Sprintf("%s has been connected", h.remoteAddr)))
+		h.connected(remoteAddr)
+	}
+}
+
+// connState keeps the connection metrics of a shared listener,
+// the gRPC server doesn't see its connections.
+func (h *statsHandler) connState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		h.connected(c.RemoteAddr())
+	case http.StateClosed, http.StateHijacked:
+		h.disconnected(c.RemoteAddr())
 	}
 }
 
-// Start starts gRPC server
+func (h *statsHandler) connected(remoteAddr net.Addr) {
+	active := atomic.AddInt64(&h.active, 1)
+	total := atomic.AddInt64(&h.total, 1)
+	h.logger.Info("grpc", zap.String("msg", fmt.Sprintf("%s has been connected", remoteAddr)),
+		zap.String("listener", h.addr), zap.Int64("active", active), zap.Int64("total", total))
+}
+
+func (h *statsHandler) disconnected(remoteAddr net.Addr) {
+	active := atomic.AddInt64(&h.active, -1)
+	h.logger.Info("grpc", zap.String("msg", fmt.Sprintf("%s has been disconnected", remoteAddr)),
+		zap.String("listener", h.addr), zap.Int64("active", active))
+}
+
+// Start starts gRPC server, it listens on all the configured
+// listeners and they feed the same channel.
 func Start(ctx context.Context, name string, ch chan interface{}) error {
-	gCfg := grpcConfig(config.FromContextServer(ctx).Ingress[name].Config)
+	gCfg, err := grpcConfig(config.FromContextServer(ctx).Ingress[name].Config)
+	if err != nil {
+		return err
+	}
+
 	logger := config.FromContextServer(ctx).Logger()
 
-	l, err := net.Listen("tcp", gCfg.Addr)
+	listeners, err := gCfg.listeners()
 	if err != nil {
 		return err
 	}
+
+	sOpts, err := gCfg.serverOpts()
+	if err != nil {
+		return err
+	}
+
 	srv := Server{
+		name:   name,
+		label:  metrics.Flow(ctx),
 		ch:     ch,
 		logger: logger,
 	}
 
-	opts, err := getServerOpts(gCfg, logger)
+	if gCfg.Cluster != nil && clustered(ctx) {
+		srv.cluster, err = newCluster(ctx, name, gCfg.Cluster, logger)
+		if err != nil {
+			return err
+		}
+	} else if gCfg.Cluster != nil {
+		logger.Info("grpc", zap.String("msg", name+" cluster routing is disabled as the flow processor hasn't opted in"))
+	}
+
+	if gCfg.Smoothing != nil {
+		srv.smoother, err = newSmoother(ctx, name, gCfg.Smoothing, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	if gCfg.FlowControl != nil {
+		srv.flow, err = newFlowController(ctx, name, gCfg.FlowControl, buffer(ctx, ch), logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	hc, err := newHealthChecker(ctx, gCfg.Health, buffer(ctx, ch), logger)
 	if err != nil {
 		return err
 	}
 
-	gServer := grpc.NewServer(opts...)
-	pb.RegisterTCPDogServer(gServer, &srv)
+	if gCfg.Quarantine != nil {
+		srv.quarantine, err = newQuarantine(gCfg.Quarantine, logger)
+		if err != nil {
+			return err
+		}
+		quarantines.Store(name, srv.quarantine)
+	}
+
+	// the codec is forced per server, the proto codec of the
+	// process isn't replaced for the other grpc servers.
+	sOpts = append(sOpts, grpc.CustomCodec(codec{}))
+
+	var (
+		gServers    []*grpc.Server
+		httpServers []*http.Server
+		state       = health.NewState(nil)
+	)
+
+	for _, lCfg := range listeners {
+		sh := &statsHandler{addr: lCfg.Addr, logger: logger}
+		opts, err := getServerOpts(lCfg, sh)
+		if err != nil {
+			stop(gServers, httpServers)
+			return err
+		}
+
+		l, err := net.Listen("tcp", lCfg.Addr)
+		if err != nil {
+			stop(gServers, httpServers)
+			return err
+		}
+
+		gServer := grpc.NewServer(append(opts, sOpts...)...)
+		pb.RegisterTCPDogServer(gServer, &srv)
+		healthpb.RegisterHealthServer(gServer, hc.server)
+		if gCfg.Reflection {
+			reflection.Register(gServer)
+		}
+		gServers = append(gServers, gServer)
+
+		if lCfg.SharedListener {
+			hServer, err := serveShared(l, lCfg, gServer, sh, logger)
+			if err != nil {
+				l.Close()
+				stop(gServers, httpServers)
+				return err
+			}
+			httpServers = append(httpServers, hServer)
+			continue
+		}
+
+		go func() {
+			if err := gServer.Serve(l); err != nil {
+				logger.Error("grpc", zap.Error(err))
+				state.Set(err)
+			}
+		}()
+	}
+
+	// the ingress is healthy while it's listening
+	health.Register(ctx, "ingress", name, state)
 
 	go func() {
-		err := gServer.Serve(l)
-		logger.Fatal("grpc", zap.Error(err))
+		<-ctx.Done()
+		hc.shutdown()
+		stop(gServers, httpServers)
 	}()
 
 	return nil
 }
 
-func getServerOpts(gCfg *Config, logger *zap.Logger) ([]grpc.ServerOption, error) {
+// stop stops the servers, the shared listeners are closed by
+// their http servers and their gRPC streams by the gRPC servers.
+func stop(gServers []*grpc.Server, httpServers []*http.Server) {
+	for _, hServer := range httpServers {
+		hServer.Close()
+	}
+	for _, gServer := range gServers {
+		gServer.Stop()
+	}
+}
+
+func getServerOpts(lCfg Listener, sh *statsHandler) ([]grpc.ServerOption, error) {
 	var opts []grpc.ServerOption
 
-	if gCfg.TLSConfig != nil && gCfg.TLSConfig.Enable {
-		creds, err := config.GetCreds(gCfg.TLSConfig)
+	if lCfg.TLSConfig != nil && lCfg.TLSConfig.Enable {
+		creds, err := config.GetCreds(lCfg.TLSConfig)
 		if err != nil {
 			return nil, err
 		}
 		opts = append(opts, grpc.Creds(creds))
 	}
 
-	opts = append(opts, grpc.StatsHandler(&statsHandler{logger: logger}))
+	opts = append(opts, grpc.StatsHandler(sh))
 
 	return opts, nil
 }
@@ -7,10 +7,12 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
 	pb "github.com/mehrdadrad/tcpdog/proto"
 )
 
@@ -38,6 +40,7 @@ func TestStart(t *testing.T) {
 	// client
 	conn, err := grpc.Dial("localhost:8085", grpc.WithInsecure())
 	assert.NoError(t, err)
+	defer conn.Close()
 	client := pb.NewTCPDogClient(conn)
 
 	// SPB
@@ -84,6 +87,19 @@ func TestStart(t *testing.T) {
 
 	<-ch // empty the channel
 
+	// the CloudEvent is unwrapped
+	ce, err := structpb.NewStruct(map[string]interface{}{"specversion": "1.0", "data": m})
+	assert.NoError(t, err)
+	err = streamSPB.Send(&pb.FieldsSPB{Fields: ce})
+	assert.NoError(t, err)
+
+	select {
+	case a := <-ch:
+		assert.Equal(t, m, a.(*pb.FieldsSPB).Fields.AsMap())
+	case <-time.After(time.Second):
+		t.Fatal("time exceeded")
+	}
+
 	// PB
 	rtt := uint32(10)
 	task := "curl"
@@ -121,3 +137,107 @@ func TestStart(t *testing.T) {
 	cancel()
 	time.Sleep(time.Second)
 }
+
+func TestStartListeners(t *testing.T) {
+	cfg := config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"foo": {
+				Type: "grpc",
+				Config: map[string]interface{}{
+					"listeners": []interface{}{
+						map[string]interface{}{"addr": "127.0.0.1:8095"},
+						map[string]interface{}{"addr": "localhost:8096"},
+					},
+				},
+			},
+		},
+	}
+
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctx = cfg.WithContext(ctx)
+	ch := make(chan interface{}, 2)
+
+	err := Start(ctx, "foo", ch)
+	assert.NoError(t, err)
+
+	for _, addr := range []string{"127.0.0.1:8095", "localhost:8096"} {
+		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		stream, err := pb.NewTCPDogClient(conn).TracepointSPB(ctx)
+		assert.NoError(t, err)
+
+		spb, _ := structpb.NewStruct(map[string]interface{}{"SAddr": addr})
+		err = stream.Send(&pb.FieldsSPB{Fields: spb})
+		assert.NoError(t, err)
+
+		select {
+		case a := <-ch:
+			assert.Equal(t, addr, a.(*pb.FieldsSPB).Fields.AsMap()["SAddr"])
+		case <-time.After(time.Second):
+			t.Fatal("time exceeded")
+		}
+	}
+}
+
+func testConfig(t *testing.T, cfg map[string]interface{}) *Config {
+	c, err := grpcConfig(cfg)
+	assert.NoError(t, err)
+
+	return c
+}
+
+func TestListeners(t *testing.T) {
+	// legacy addr
+	cfg := testConfig(t, map[string]interface{}{"addr": ":8085"})
+	l, err := cfg.listeners()
+	assert.NoError(t, err)
+	assert.Equal(t, []Listener{{Addr: ":8085"}}, l)
+
+	// non-loopback without TLS
+	cfg = testConfig(t, map[string]interface{}{
+		"listeners": []interface{}{
+			map[string]interface{}{"addr": "127.0.0.1:8085"},
+			map[string]interface{}{"addr": ":8086"},
+		},
+	})
+	_, err = cfg.listeners()
+	assert.EqualError(t, err, "listener :8086 requires TLS or allowInsecure")
+
+	// non-loopback with TLS
+	cfg = testConfig(t, map[string]interface{}{
+		"listeners": []interface{}{
+			map[string]interface{}{"addr": "[::1]:8085"},
+			map[string]interface{}{"addr": ":8086", "tlsConfig": map[string]interface{}{"enable": true}},
+		},
+	})
+	l, err = cfg.listeners()
+	assert.NoError(t, err)
+	assert.Len(t, l, 2)
+	assert.True(t, l[1].TLSConfig.Enable)
+
+	// allow insecure
+	cfg = testConfig(t, map[string]interface{}{
+		"allowInsecure": true,
+		"listeners": []interface{}{
+			map[string]interface{}{"addr": "10.0.0.1:8086"},
+		},
+	})
+	_, err = cfg.listeners()
+	assert.NoError(t, err)
+}
+
+func TestDispatchDrop(t *testing.T) {
+	s := &Server{name: "drop", ch: make(chan interface{}, 1), logger: zap.NewNop()}
+
+	s.dispatch(context.Background(), map[string]interface{}{"RTT": 5})
+	s.dispatch(context.Background(), map[string]interface{}{"RTT": 6})
+
+	assert.Len(t, s.ch, 1)
+	assert.Equal(t, uint64(1), drops.Count(drops.ServerChannel, "drop"))
+}
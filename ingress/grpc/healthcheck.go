@@ -0,0 +1,137 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// serviceName is the tcpdog service of the health checks, the
+// empty service is the overall health of the server.
+const serviceName = "tcpdog.TCPDog"
+
+// HealthConfig represents the gRPC health service, the server is
+// NOT_SERVING once the flow channel occupancy has been above the
+// saturation ratio for the threshold or it's shutting down.
+type HealthConfig struct {
+	// Saturation is the occupancy ratio (0-1] of the flow channel
+	Saturation float64
+	// Threshold is the saturation duration in milliseconds
+	Threshold int
+	// Interval is the occupancy check interval in milliseconds
+	Interval int
+}
+
+type healthChecker struct {
+	server     *grpchealth.Server
+	ch         chan interface{}
+	saturation float64
+	threshold  time.Duration
+	logger     *zap.Logger
+
+	mu      sync.Mutex
+	since   time.Time
+	serving bool
+}
+
+func newHealthChecker(ctx context.Context, hCfg *HealthConfig, ch chan interface{}, logger *zap.Logger) (*healthChecker, error) {
+	if hCfg == nil {
+		hCfg = &HealthConfig{}
+	}
+
+	h := &healthChecker{
+		server:     grpchealth.NewServer(),
+		ch:         ch,
+		saturation: hCfg.Saturation,
+		threshold:  time.Duration(hCfg.Threshold) * time.Millisecond,
+		logger:     logger,
+	}
+
+	if h.saturation == 0 {
+		h.saturation = 0.9
+	}
+
+	if h.threshold <= 0 {
+		h.threshold = 5 * time.Second
+	}
+
+	if h.saturation < 0 || h.saturation > 1 {
+		return nil, fmt.Errorf("wrong health saturation:%g", h.saturation)
+	}
+
+	interval := time.Duration(hCfg.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	h.setServing(true)
+
+	go h.run(ctx, interval)
+
+	return h, nil
+}
+
+func (h *healthChecker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			h.check(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check flips the serving status once the channel has been saturated
+// for the threshold and it flips it back once it's not saturated.
+func (h *healthChecker) check(now time.Time) {
+	occupancy := float64(len(h.ch)) / float64(cap(h.ch))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if occupancy < h.saturation {
+		h.since = time.Time{}
+		if !h.serving {
+			h.setServing(true)
+			h.logger.Info("grpc", zap.String("msg", "health status has been changed to serving"),
+				zap.Float64("occupancy", occupancy))
+		}
+		return
+	}
+
+	if h.since.IsZero() {
+		h.since = now
+	}
+
+	if h.serving && now.Sub(h.since) >= h.threshold {
+		h.setServing(false)
+		h.logger.Warn("grpc", zap.String("msg", "health status has been changed to not serving"),
+			zap.Float64("occupancy", occupancy), zap.Duration("saturated", now.Sub(h.since)))
+	}
+}
+
+func (h *healthChecker) setServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+
+	h.serving = serving
+	h.server.SetServingStatus("", status)
+	h.server.SetServingStatus(serviceName, status)
+}
+
+// shutdown sets the services to NOT_SERVING and it ignores
+// the next updates, the load balancers drain the server.
+func (h *healthChecker) shutdown() {
+	h.server.Shutdown()
+}
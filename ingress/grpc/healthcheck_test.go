@@ -0,0 +1,155 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+func healthStatus(t *testing.T, h *healthChecker) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := h.server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: serviceName})
+	assert.NoError(t, err)
+
+	return resp.Status
+}
+
+func TestHealthSaturation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan interface{}, 4)
+	h, err := newHealthChecker(ctx, &HealthConfig{Threshold: 100, Interval: 60000}, ch, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(t, h))
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		ch <- i
+	}
+
+	// the channel is saturated but not for the threshold
+	h.check(now)
+	h.check(now.Add(50 * time.Millisecond))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(t, h))
+
+	h.check(now.Add(100 * time.Millisecond))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(t, h))
+
+	// the overall health of the server
+	resp, err := h.server.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	<-ch
+	<-ch
+	h.check(now.Add(time.Second))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(t, h))
+
+	// the saturation is measured again
+	ch <- 1
+	ch <- 2
+	h.check(now.Add(2 * time.Second))
+	h.check(now.Add(2*time.Second + 50*time.Millisecond))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(t, h))
+
+	// the shutdown isn't changed by the checks
+	h.shutdown()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(t, h))
+
+	<-ch
+	h.check(now.Add(3 * time.Second))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(t, h))
+
+	_, err = newHealthChecker(ctx, &HealthConfig{Saturation: 1.5}, ch, zap.NewNop())
+	assert.EqualError(t, err, "wrong health saturation:1.5")
+}
+
+func TestStartHealth(t *testing.T) {
+	// the codec is forced per server, the proto
+	// codec of the process isn't replaced.
+	assert.NotEqual(t, serialization.Codec{}, encoding.GetCodec("proto"))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"grpc-health": {
+				Type: "grpc",
+				Config: map[string]interface{}{
+					"addr":       addr,
+					"reflection": true,
+					"health":     map[string]interface{}{"threshold": 20, "interval": 10},
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan interface{}, 2)
+	assert.NoError(t, Start(ctx, "grpc-health", ch))
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: serviceName})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return resp.Status
+	}
+
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	// the flow channel is saturated
+	ch <- 1
+	ch <- 2
+
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	<-ch
+
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	// the reflection lists the services
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	}))
+
+	resp, err := stream.Recv()
+	assert.NoError(t, err)
+
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.Name)
+	}
+	assert.Contains(t, services, serviceName)
+	assert.Contains(t, services, "grpc.health.v1.Health")
+}
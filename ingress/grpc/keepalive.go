@@ -0,0 +1,92 @@
+package grpc
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// the gRPC defaults of the server keepalive and the enforcement policy
+const (
+	defaultKeepaliveTime    = 2 * time.Hour
+	defaultKeepaliveTimeout = 20 * time.Second
+	defaultKeepaliveMinTime = 5 * time.Minute
+)
+
+// KeepaliveConfig represents the keepalive pings of the server and the
+// enforcement policy of the agents pings, the durations are the Go
+// duration strings e.g. 30s and the unset ones are the gRPC defaults.
+type KeepaliveConfig struct {
+	// Time is the idle time of a connection which the server pings after
+	Time string
+	// Timeout is the wait for the ping ack before closing the connection
+	Timeout string
+	// MinTime is the min interval of the agents pings, the agents which
+	// ping more often are disconnected (too_many_pings).
+	MinTime string
+	// PermitWithoutStream allows the agents pings without any stream
+	PermitWithoutStream bool
+}
+
+// keepaliveParams returns the server parameters and the enforcement
+// policy of the keepalive configuration.
+func keepaliveParams(kCfg *KeepaliveConfig) (keepalive.ServerParameters, keepalive.EnforcementPolicy, error) {
+	var (
+		params = keepalive.ServerParameters{Time: defaultKeepaliveTime, Timeout: defaultKeepaliveTimeout}
+		policy = keepalive.EnforcementPolicy{MinTime: defaultKeepaliveMinTime}
+	)
+
+	if kCfg == nil {
+		return params, policy, nil
+	}
+
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"time", kCfg.Time, &params.Time},
+		{"timeout", kCfg.Timeout, &params.Timeout},
+		{"minTime", kCfg.MinTime, &policy.MinTime},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return params, policy, fmt.Errorf("wrong keepalive %s:%s", d.name, d.value)
+		}
+		*d.dst = v
+	}
+
+	policy.PermitWithoutStream = kCfg.PermitWithoutStream
+
+	return params, policy, nil
+}
+
+// serverOpts returns the keepalive and the message size options of
+// the servers, they're the gRPC defaults if they're not configured.
+func (c *Config) serverOpts() ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+
+	if c.Keepalive != nil {
+		params, policy, err := keepaliveParams(c.Keepalive)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.KeepaliveParams(params), grpc.KeepaliveEnforcementPolicy(policy))
+	}
+
+	if c.MaxRecvMsgSize < 0 {
+		return nil, fmt.Errorf("wrong maxRecvMsgSize:%d", c.MaxRecvMsgSize)
+	}
+
+	if c.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(c.MaxRecvMsgSize))
+	}
+
+	return opts, nil
+}
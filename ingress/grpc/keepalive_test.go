@@ -0,0 +1,71 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestKeepaliveParams(t *testing.T) {
+	cfg := testConfig(t, map[string]interface{}{
+		"keepalive": map[string]interface{}{
+			"time":                "30s",
+			"timeout":             "5s",
+			"minTime":             "10s",
+			"permitWithoutStream": true,
+		},
+		"maxRecvMsgSize": 16 << 20,
+	})
+
+	params, policy, err := keepaliveParams(cfg.Keepalive)
+	assert.NoError(t, err)
+	assert.Equal(t, keepalive.ServerParameters{Time: 30 * time.Second, Timeout: 5 * time.Second}, params)
+	assert.Equal(t, keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}, policy)
+
+	opts, err := cfg.serverOpts()
+	assert.NoError(t, err)
+	assert.Len(t, opts, 3)
+
+	// the unset values are the gRPC defaults
+	params, policy, err = keepaliveParams(&KeepaliveConfig{Time: "1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, keepalive.ServerParameters{Time: time.Minute, Timeout: defaultKeepaliveTimeout}, params)
+	assert.Equal(t, keepalive.EnforcementPolicy{MinTime: defaultKeepaliveMinTime}, policy)
+
+	// the current behavior if they're not configured
+	opts, err = testConfig(t, map[string]interface{}{}).serverOpts()
+	assert.NoError(t, err)
+	assert.Len(t, opts, 0)
+
+	_, _, err = keepaliveParams(&KeepaliveConfig{Timeout: "5"})
+	assert.EqualError(t, err, "wrong keepalive timeout:5")
+
+	_, err = testConfig(t, map[string]interface{}{"maxRecvMsgSize": -1}).serverOpts()
+	assert.EqualError(t, err, "wrong maxRecvMsgSize:-1")
+}
+
+func TestStartKeepaliveError(t *testing.T) {
+	cfg := config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"foo": {
+				Type: "grpc",
+				Config: map[string]interface{}{
+					"addr":      "localhost:0",
+					"keepalive": map[string]interface{}{"time": "1x"},
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	err := Start(ctx, "foo", make(chan interface{}, 1))
+	assert.EqualError(t, err, "wrong keepalive time:1x")
+}
@@ -0,0 +1,320 @@
+package grpc
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+const (
+	// agentUserAgent is the user agent prefix of the agents
+	agentUserAgent = "tcpdog-agent/"
+	// sampleSize is the max logged bytes of an offending payload
+	sampleSize = 64
+)
+
+// QuarantineConfig represents the poison peers quarantine, the messages
+// which fail to decode are dropped and a peer is quarantined once its
+// decode error ratio exceeds the ErrorRatio over the Window. it's kept
+// in quarantine until it reconnects with another agent version.
+type QuarantineConfig struct {
+	// ErrorRatio is the decode errors to the messages ratio
+	ErrorRatio float64
+	// Window is the error ratio window in seconds
+	Window int
+	// MinMessages is the min messages of a window to evaluate it
+	MinMessages int
+	// Action is drop (default) which drains and drops the peer
+	// messages or disconnect which closes its streams.
+	Action string
+}
+
+// QuarantinedPeer represents a quarantined peer
+type QuarantinedPeer struct {
+	Peer    string    `json:"peer"`
+	Version string    `json:"version"`
+	Since   time.Time `json:"since"`
+	Ratio   float64   `json:"ratio"`
+	Dropped uint64    `json:"dropped"`
+}
+
+// frame is a raw message, the quarantine decodes it by itself
+// thus a malformed message doesn't break the stream.
+type frame struct {
+	b []byte
+}
+
+// codec is the proto codec which keeps the frames raw, the other
+// messages e.g. the health and the reflection are encoded by the
+// generated codec.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return serialization.Codec{}.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	if f, ok := v.(*frame); ok {
+		f.b = append(f.b[:0], data...)
+		return nil
+	}
+
+	return serialization.Codec{}.Unmarshal(data, v)
+}
+
+func (codec) String() string {
+	return "proto"
+}
+
+type quarantine struct {
+	ratio       float64
+	window      time.Duration
+	minMessages uint64
+	disconnect  bool
+	logger      *zap.Logger
+
+	mu    sync.Mutex
+	peers map[string]*peerState
+}
+
+// peerState represents the decode stats of a peer, it's shared
+// between the peer's streams.
+type peerState struct {
+	refs     int
+	version  string
+	start    time.Time
+	messages uint64
+	failures uint64
+
+	quarantined bool
+	since       time.Time
+	lastRatio   float64
+	dropped     uint64
+}
+
+func newQuarantine(qCfg *QuarantineConfig, logger *zap.Logger) (*quarantine, error) {
+	q := &quarantine{
+		ratio:       qCfg.ErrorRatio,
+		window:      time.Duration(qCfg.Window) * time.Second,
+		minMessages: uint64(qCfg.MinMessages),
+		logger:      logger,
+		peers:       map[string]*peerState{},
+	}
+
+	if q.ratio == 0 {
+		q.ratio = 0.5
+	}
+	if q.window <= 0 {
+		q.window = time.Minute
+	}
+	if q.minMessages < 1 {
+		q.minMessages = 100
+	}
+
+	switch qCfg.Action {
+	case "", "drop":
+	case "disconnect":
+		q.disconnect = true
+	default:
+		return nil, fmt.Errorf("quarantine action %s is not supported", qCfg.Action)
+	}
+
+	if q.ratio < 0 || q.ratio > 1 {
+		return nil, fmt.Errorf("wrong quarantine error ratio:%g", q.ratio)
+	}
+
+	return q, nil
+}
+
+// acquire returns the peer state of the stream, the quarantine of
+// the peer is lifted if it reconnects with another agent version.
+func (q *quarantine) acquire(ctx context.Context) (*peerState, func()) {
+	key := peerHost(ctx)
+	version := agentVersion(ctx)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	p, ok := q.peers[key]
+	if ok && p.quarantined && p.version != version {
+		q.logger.Info("grpc", zap.String("msg", key+" has been released from quarantine"),
+			zap.String("version", version))
+		ok = false
+	}
+
+	if !ok {
+		p = &peerState{version: version, start: time.Now()}
+		q.peers[key] = p
+	}
+	p.refs++
+
+	release := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		// the quarantined peers are kept for their reconnection
+		if p.refs--; p.refs < 1 && !p.quarantined && q.peers[key] == p {
+			delete(q.peers, key)
+		}
+	}
+
+	return p, release
+}
+
+// recv receives the next message which decodes, the malformed
+// messages are dropped and the quarantined peer's messages are
+// drained or its stream is closed.
+func (q *quarantine) recv(ctx context.Context, p *peerState, stream grpc.ServerStream, m proto.Message) error {
+	f := &frame{}
+	unmarshal := frameUnmarshaler(ctx)
+
+	for {
+		if err := stream.RecvMsg(f); err != nil {
+			return err
+		}
+
+		if q.isQuarantined(p) {
+			if q.disconnect {
+				return status.Error(codes.PermissionDenied, "peer has been quarantined due to the decode errors")
+			}
+			atomic.AddUint64(&p.dropped, 1)
+			continue
+		}
+
+		err := unmarshal(f.b, m)
+		q.observe(ctx, p, err, f.b)
+		if err == nil {
+			return nil
+		}
+	}
+}
+
+func (q *quarantine) isQuarantined(p *peerState) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return p.quarantined
+}
+
+// observe counts the message of the peer and it quarantines
+// the peer if the error ratio has been exceeded.
+func (q *quarantine) observe(ctx context.Context, p *peerState, err error, b []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.start) > q.window {
+		p.start, p.messages, p.failures = now, 0, 0
+	}
+
+	p.messages++
+	if err == nil {
+		return
+	}
+
+	p.failures++
+
+	ratio := float64(p.failures) / float64(p.messages)
+	if p.messages < q.minMessages || ratio <= q.ratio {
+		return
+	}
+
+	p.quarantined, p.since, p.lastRatio = true, now, ratio
+
+	sample := b
+	if len(sample) > sampleSize {
+		sample = sample[:sampleSize]
+	}
+
+	q.logger.Warn("grpc", zap.String("msg", peerHost(ctx)+" has been quarantined"),
+		zap.String("version", p.version), zap.Float64("ratio", ratio),
+		zap.String("sample", hex.EncodeToString(sample)), zap.Error(err))
+}
+
+var quarantines sync.Map
+
+// Quarantined returns the quarantined peers of the ingress
+func Quarantined(name string) []QuarantinedPeer {
+	q, ok := quarantines.Load(name)
+	if !ok {
+		return []QuarantinedPeer{}
+	}
+
+	return q.(*quarantine).quarantined()
+}
+
+// quarantined returns the quarantined peers
+func (q *quarantine) quarantined() []QuarantinedPeer {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	peers := []QuarantinedPeer{}
+	for key, p := range q.peers {
+		if !p.quarantined {
+			continue
+		}
+
+		peers = append(peers, QuarantinedPeer{
+			Peer:    key,
+			Version: p.version,
+			Since:   p.since,
+			Ratio:   p.lastRatio,
+			Dropped: atomic.LoadUint64(&p.dropped),
+		})
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Peer < peers[j].Peer
+	})
+
+	return peers
+}
+
+// frameUnmarshaler returns the decoder of the stream's content subtype,
+// the frames are kept raw by the custom codec thus the cbor frames are
+// decoded by the cbor codec here.
+func frameUnmarshaler(ctx context.Context) func([]byte, proto.Message) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, ct := range md.Get("content-type") {
+		if strings.HasSuffix(ct, "+cbor") {
+			return func(b []byte, m proto.Message) error {
+				return serialization.CBORCodec{}.Unmarshal(b, m)
+			}
+		}
+	}
+
+	return serialization.Unmarshal
+}
+
+// agentVersion returns the agent version of the stream's user agent
+func agentVersion(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	for _, ua := range md.Get("user-agent") {
+		for _, part := range strings.Fields(ua) {
+			if strings.HasPrefix(part, agentUserAgent) {
+				return strings.TrimPrefix(part, agentUserAgent)
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,145 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// rawStream is a server stream which receives the raw payloads
+type rawStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	payloads [][]byte
+}
+
+func (s *rawStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *rawStream) RecvMsg(m interface{}) error {
+	if len(s.payloads) < 1 {
+		return io.EOF
+	}
+
+	b := s.payloads[0]
+	s.payloads = s.payloads[1:]
+
+	return codec{}.Unmarshal(b, m)
+}
+
+func TestQuarantineConfig(t *testing.T) {
+	q, err := newQuarantine(&QuarantineConfig{}, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, q.ratio)
+	assert.Equal(t, uint64(100), q.minMessages)
+	assert.False(t, q.disconnect)
+
+	_, err = newQuarantine(&QuarantineConfig{Action: "reject"}, zap.NewNop())
+	assert.EqualError(t, err, "quarantine action reject is not supported")
+
+	_, err = newQuarantine(&QuarantineConfig{ErrorRatio: 2}, zap.NewNop())
+	assert.EqualError(t, err, "wrong quarantine error ratio:2")
+}
+
+func TestQuarantineDrop(t *testing.T) {
+	q, err := newQuarantine(&QuarantineConfig{ErrorRatio: 0.5, MinMessages: 4}, zap.NewNop())
+	assert.NoError(t, err)
+
+	good, _ := proto.Marshal(&pb.Fields{PID: proto.Uint32(5)})
+	bad := []byte{0xff, 0xff, 0xff}
+
+	ctx := peerContext("10.0.0.1", "user-agent", "tcpdog-agent/1.0.0 grpc-go/1.27.0")
+	p, release := q.acquire(ctx)
+
+	stream := &rawStream{ctx: ctx, payloads: [][]byte{good, bad, good, bad, bad, good, good}}
+
+	// the malformed message is skipped
+	m := &pb.Fields{}
+	assert.NoError(t, q.recv(ctx, p, stream, m))
+	assert.Equal(t, uint32(5), m.GetPID())
+	assert.NoError(t, q.recv(ctx, p, stream, m))
+	assert.Empty(t, q.quarantined())
+
+	// 3 out of 5 are malformed, the rest is drained
+	assert.Equal(t, io.EOF, q.recv(ctx, p, stream, m))
+
+	peers := q.quarantined()
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, "10.0.0.1", peers[0].Peer)
+		assert.Equal(t, "1.0.0", peers[0].Version)
+		assert.Equal(t, 0.6, peers[0].Ratio)
+		assert.Equal(t, uint64(2), peers[0].Dropped)
+	}
+
+	// it's kept once the stream is closed
+	release()
+	p, release = q.acquire(ctx)
+	stream.payloads = [][]byte{good}
+	assert.Equal(t, io.EOF, q.recv(ctx, p, stream, m))
+	release()
+	assert.Len(t, q.quarantined(), 1)
+
+	// the new agent version lifts the quarantine
+	ctx = peerContext("10.0.0.1", "user-agent", "tcpdog-agent/1.0.1 grpc-go/1.27.0")
+	p, release = q.acquire(ctx)
+	defer release()
+	stream = &rawStream{ctx: ctx, payloads: [][]byte{good}}
+	assert.NoError(t, q.recv(ctx, p, stream, m))
+	assert.Empty(t, q.quarantined())
+}
+
+func TestQuarantineDisconnect(t *testing.T) {
+	q, err := newQuarantine(&QuarantineConfig{ErrorRatio: 0.1, MinMessages: 1, Action: "disconnect"}, zap.NewNop())
+	assert.NoError(t, err)
+
+	good, _ := proto.Marshal(&pb.Fields{PID: proto.Uint32(5)})
+	ctx := peerContext("10.0.0.2")
+	p, release := q.acquire(ctx)
+	defer release()
+
+	stream := &rawStream{ctx: ctx, payloads: [][]byte{{0xff}, good}}
+	err = q.recv(ctx, p, stream, &pb.Fields{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// the other peers aren't affected
+	ctx2 := peerContext("10.0.0.3")
+	p2, release2 := q.acquire(ctx2)
+	defer release2()
+
+	stream = &rawStream{ctx: ctx2, payloads: [][]byte{good}}
+	assert.NoError(t, q.recv(ctx2, p2, stream, &pb.Fields{}))
+}
+
+func TestQuarantineCBOR(t *testing.T) {
+	q, err := newQuarantine(&QuarantineConfig{ErrorRatio: 0.5, MinMessages: 4}, zap.NewNop())
+	assert.NoError(t, err)
+
+	good, _ := serialization.JSONToCBOR([]byte(`{"PID":5,"DAddr":"10.0.0.1"}`))
+	ctx := peerContext("10.0.0.4", "content-type", "application/grpc+cbor")
+	p, release := q.acquire(ctx)
+	defer release()
+
+	// the frames are decoded by the stream's content subtype
+	stream := &rawStream{ctx: ctx, payloads: [][]byte{good}}
+	m := &pb.Fields{}
+	assert.NoError(t, q.recv(ctx, p, stream, m))
+	assert.Equal(t, uint32(5), m.GetPID())
+	assert.Equal(t, "10.0.0.1", m.GetDAddr())
+}
+
+func TestAgentVersion(t *testing.T) {
+	assert.Equal(t, "", agentVersion(context.Background()))
+	assert.Equal(t, "", agentVersion(peerContext("10.0.0.1", "user-agent", "grpc-go/1.27.0")))
+	assert.Equal(t, "0.4.1", agentVersion(peerContext("10.0.0.1", "user-agent", "tcpdog-agent/0.4.1 grpc-go/1.27.0")))
+}
@@ -0,0 +1,68 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/sharedhttp"
+)
+
+// sharedHandler routes the HTTP/2 gRPC requests to the gRPC server
+// and the rest of them, e.g. the HTTP/1.1 health checks of the load
+// balancers, to the http handler which is registered for the address.
+func sharedHandler(addr string, gServer *grpc.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+
+		switch {
+		case strings.HasPrefix(contentType, "application/grpc-web"):
+			// the gRPC-Web probes get a definite answer
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Grpc-Status", "12")
+			w.Header().Set("Grpc-Message", "grpc-web is not supported")
+			w.WriteHeader(http.StatusOK)
+		case r.ProtoMajor == 2 && strings.HasPrefix(contentType, "application/grpc"):
+			gServer.ServeHTTP(w, r)
+		default:
+			sharedhttp.Handler(addr).ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveShared serves the gRPC and the http requests on the listener, the
+// TLS is terminated once and the plain text HTTP/2 is served as h2c. the
+// HTTP/2 transport is the net/http one thus the gRPC keepalive doesn't
+// apply (it's rejected by the config) and the concurrent streams per
+// connection are limited by the http2 default, the connection stats are
+// kept by the connection states of the http server.
+func serveShared(l net.Listener, lCfg Listener, gServer *grpc.Server, sh *statsHandler, logger *zap.Logger) (*http.Server, error) {
+	if lCfg.TLSConfig != nil && lCfg.TLSConfig.Enable {
+		tlsConfig, err := config.GetTLS(lCfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	srv := &http.Server{
+		Handler:   h2c.NewHandler(sharedHandler(lCfg.Addr, gServer), &http2.Server{}),
+		ConnState: sh.connState,
+	}
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Error("grpc", zap.Error(err))
+		}
+	}()
+
+	return srv, nil
+}
@@ -0,0 +1,138 @@
+package grpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/sharedhttp"
+)
+
+func TestStartShared(t *testing.T) {
+	addr := "127.0.0.1:8097"
+
+	cfg := config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"foo": {
+				Type:   "grpc",
+				Config: map[string]interface{}{"addr": addr, "sharedListener": true},
+			},
+		},
+	}
+
+	cfg.SetMockLogger("memory")
+	assert.True(t, cfg.SharedListener(addr))
+	assert.False(t, cfg.SharedListener(":8085"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = cfg.WithContext(ctx)
+
+	sharedhttp.Handle(ctx, addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	ch := make(chan interface{}, 1)
+
+	err := Start(ctx, "foo", ch)
+	assert.NoError(t, err)
+
+	// gRPC
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := pb.NewTCPDogClient(conn).TracepointSPB(ctx)
+	assert.NoError(t, err)
+
+	spb, _ := structpb.NewStruct(map[string]interface{}{"Task": "curl"})
+	err = stream.Send(&pb.FieldsSPB{Fields: spb})
+	assert.NoError(t, err)
+
+	select {
+	case r := <-ch:
+		assert.Equal(t, "curl", r.(*pb.FieldsSPB).Fields.AsMap()["Task"])
+	case <-time.After(time.Second):
+		t.Fatal("time exceeded")
+	}
+
+	// HTTP/1.1 health check
+	resp, err := http.Get("http://" + addr + "/health")
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "ok", string(b))
+
+	// gRPC-Web probe
+	resp, err = http.Post("http://"+addr+"/tcpdog.TCPDog/TracepointSPB", "application/grpc-web+proto", strings.NewReader(""))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "12", resp.Header.Get("Grpc-Status"))
+
+	// both of them are stopped
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = http.Get("http://" + addr + "/health")
+	assert.Error(t, err)
+
+	http.DefaultClient.CloseIdleConnections()
+}
+
+func TestSharedKeepalive(t *testing.T) {
+	cfg := testConfig(t, map[string]interface{}{
+		"sharedListener": true,
+		"keepalive":      map[string]interface{}{"time": "1m"},
+	})
+
+	_, err := cfg.listeners()
+	assert.EqualError(t, err, "shared listener :8085 doesn't support keepalive")
+
+	cfg = testConfig(t, map[string]interface{}{
+		"listeners": []interface{}{
+			map[string]interface{}{"addr": "127.0.0.1:8098"},
+			map[string]interface{}{"addr": "127.0.0.1:8099", "sharedListener": true},
+		},
+		"keepalive": map[string]interface{}{"time": "1m"},
+	})
+
+	_, err = cfg.listeners()
+	assert.EqualError(t, err, "shared listener 127.0.0.1:8099 doesn't support keepalive")
+}
+
+func TestSharedConnStats(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	sh := &statsHandler{addr: l.Addr().String(), logger: zap.NewNop()}
+	gServer := grpc.NewServer()
+	defer gServer.Stop()
+
+	hServer, err := serveShared(l, Listener{Addr: sh.addr}, gServer, sh, zap.NewNop())
+	assert.NoError(t, err)
+	defer hServer.Close()
+
+	conn, err := net.Dial("tcp", sh.addr)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&sh.active) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&sh.active) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&sh.total))
+}
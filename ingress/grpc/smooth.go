@@ -0,0 +1,246 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+// replayKey marks the streams which replay the agent buffer
+// e.g. right after a restart, they're limited by MaxReplaying.
+const replayKey = "tcpdog-replay"
+
+// SmoothingConfig represents the ingest smoothing configuration.
+// the excess records of a peer are delayed by its token bucket,
+// the backpressure applies to that peer's streams only.
+type SmoothingConfig struct {
+	// Rate is the records per second per peer
+	Rate float64
+	// Burst is the bucket size, it's the Rate by default
+	Burst int
+	// MaxReplaying is the max simultaneous replaying peers
+	MaxReplaying int
+	// StatsInterval is the smoothing stats logging interval in seconds
+	StatsInterval int
+}
+
+type smoother struct {
+	name    string
+	rate    float64
+	burst   float64
+	replays chan struct{}
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	smoothed    uint64
+	passthrough uint64
+	replaying   int64
+
+	mSmoothed    func()
+	mPassthrough func()
+	mReplaying   func(float64)
+	mPeers       func(float64)
+}
+
+// bucket represents a peer's token bucket, it's shared
+// between all the streams of the peer.
+type bucket struct {
+	s      *smoother
+	refs   int
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newSmoother(ctx context.Context, name string, sCfg *SmoothingConfig, logger *zap.Logger) (*smoother, error) {
+	if sCfg.Rate <= 0 {
+		return nil, fmt.Errorf("wrong smoothing rate:%g", sCfg.Rate)
+	}
+
+	s := &smoother{
+		name:    name,
+		rate:    sCfg.Rate,
+		burst:   float64(sCfg.Burst),
+		buckets: map[string]*bucket{},
+
+		mSmoothed:    metrics.SmoothingRecord(name, metrics.SmoothingSmoothed),
+		mPassthrough: metrics.SmoothingRecord(name, metrics.SmoothingPassthrough),
+		mReplaying:   metrics.SmoothingReplaying(name),
+		mPeers:       metrics.SmoothingPeers(name),
+	}
+
+	if s.burst < 1 {
+		s.burst = sCfg.Rate
+	}
+
+	if sCfg.MaxReplaying > 0 {
+		s.replays = make(chan struct{}, sCfg.MaxReplaying)
+	}
+
+	if sCfg.StatsInterval > 0 {
+		go s.stats(ctx, time.Duration(sCfg.StatsInterval)*time.Second, logger)
+	}
+
+	return s, nil
+}
+
+// acquire returns the peer's bucket of the stream, a replaying
+// stream waits for a replay slot. the release should be called
+// once the stream is closed, the agent closes its replay stream
+// once the replay is done thus the slot is released by then and
+// the agent reconnects by a regular stream.
+func (s *smoother) acquire(ctx context.Context) (*bucket, func(), error) {
+	if s == nil || isForwarded(ctx) {
+		return nil, func() {}, nil
+	}
+
+	replay := isReplay(ctx) && s.replays != nil
+	if replay {
+		select {
+		case s.replays <- struct{}{}:
+			atomic.AddInt64(&s.replaying, 1)
+			s.mReplaying(1)
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	key := peerHost(ctx)
+
+	s.mu.Lock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{s: s, tokens: s.burst, last: time.Now()}
+		s.buckets[key] = b
+		s.mPeers(1)
+	}
+	b.refs++
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		if b.refs--; b.refs < 1 {
+			delete(s.buckets, key)
+			s.mPeers(-1)
+		}
+		s.mu.Unlock()
+
+		if replay {
+			atomic.AddInt64(&s.replaying, -1)
+			s.mReplaying(-1)
+			<-s.replays
+		}
+	}
+
+	return b, release, nil
+}
+
+// wait takes a token and blocks until it's available, meanwhile
+// the stream isn't read and the flow control slows down the peer.
+func (b *bucket) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	d := b.take(time.Now())
+	if d <= 0 {
+		atomic.AddUint64(&b.s.passthrough, 1)
+		b.s.mPassthrough()
+		return nil
+	}
+
+	atomic.AddUint64(&b.s.smoothed, 1)
+	b.s.mSmoothed()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take reserves a token and returns the delay until the token
+// is available, the tokens go negative for the reserved ones.
+func (b *bucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.s.rate
+		if b.tokens > b.s.burst {
+			b.tokens = b.s.burst
+		}
+		b.last = now
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.s.rate * float64(time.Second))
+}
+
+func (s *smoother) stats(ctx context.Context, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			peers := len(s.buckets)
+			s.mu.Unlock()
+
+			logger.Info("grpc", zap.String("msg", "smoothing stats"),
+				zap.Uint64("smoothed", atomic.LoadUint64(&s.smoothed)),
+				zap.Uint64("passthrough", atomic.LoadUint64(&s.passthrough)),
+				zap.Int64("replaying", atomic.LoadInt64(&s.replaying)),
+				zap.Int("peers", peers))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// isReplay returns true if the stream replays the agent buffer.
+func isReplay(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	v := md.Get(replayKey)
+
+	return len(v) > 0 && v[0] == "true"
+}
+
+// peerHost returns the host of the stream's remote address,
+// the streams of an agent share the same bucket.
+func peerHost(ctx context.Context) string {
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
@@ -0,0 +1,121 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+)
+
+func peerContext(addr string, md ...string) context.Context {
+	ctx := grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 50000},
+	})
+
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(md...))
+}
+
+func TestSmootherBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := newSmoother(ctx, "grpc01", &SmoothingConfig{}, zap.NewNop())
+	assert.EqualError(t, err, "wrong smoothing rate:0")
+
+	s, err := newSmoother(ctx, "grpc01", &SmoothingConfig{Rate: 10, Burst: 2}, zap.NewNop())
+	assert.NoError(t, err)
+
+	b, release, err := s.acquire(peerContext("10.0.0.1"))
+	assert.NoError(t, err)
+
+	// the streams of a peer share the bucket
+	b2, release2, err := s.acquire(peerContext("10.0.0.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, b, b2)
+
+	now := b.last
+	assert.Equal(t, time.Duration(0), b.take(now))
+	assert.Equal(t, time.Duration(0), b.take(now))
+	assert.Equal(t, 100*time.Millisecond, b.take(now))
+	assert.Equal(t, 200*time.Millisecond, b.take(now))
+	// refilled two tokens after 200ms
+	assert.Equal(t, 100*time.Millisecond, b.take(now.Add(200*time.Millisecond)))
+
+	// the other peers aren't delayed
+	b3, release3, err := s.acquire(peerContext("10.0.0.2"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, b, b3)
+	assert.NoError(t, b3.wait(ctx))
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&s.passthrough))
+
+	start := time.Now()
+	assert.NoError(t, b.wait(ctx))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&s.smoothed))
+
+	release()
+	release2()
+	release3()
+	assert.Len(t, s.buckets, 0)
+
+	// forwarded streams are not smoothed
+	b, _, err = s.acquire(context.WithValue(peerContext("10.0.0.3"), forwardedStream{}, true))
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+	assert.NoError(t, b.wait(ctx))
+}
+
+func TestSmootherReplaying(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := newSmoother(ctx, "grpc01", &SmoothingConfig{Rate: 1000, MaxReplaying: 1}, zap.NewNop())
+	assert.NoError(t, err)
+
+	_, release, err := s.acquire(peerContext("10.0.0.1", replayKey, "true"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.replaying))
+
+	// the regular streams don't need a replay slot
+	_, release2, err := s.acquire(peerContext("10.0.0.2"))
+	assert.NoError(t, err)
+	release2()
+
+	acquired := make(chan struct{})
+	go func() {
+		_, release, err := s.acquire(peerContext("10.0.0.3", replayKey, "true"))
+		assert.NoError(t, err)
+		close(acquired)
+		release()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("replay limit exceeded")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("time exceeded")
+	}
+
+	// canceled while waiting for a slot
+	_, release, err = s.acquire(peerContext("10.0.0.1", replayKey, "true"))
+	assert.NoError(t, err)
+	defer release()
+
+	wCtx, wCancel := context.WithTimeout(peerContext("10.0.0.4", replayKey, "true"), 10*time.Millisecond)
+	defer wCancel()
+	_, _, err = s.acquire(wCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
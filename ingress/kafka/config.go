@@ -1,12 +1,14 @@
 package kafka
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/Shopify/sarama"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
 )
 
 var kafkaVersion = map[string]sarama.KafkaVersion{
@@ -44,10 +46,27 @@ type Config struct {
 	Workers      int
 	Version      string
 
+	// GroupID is the consumer group id, the servers which have
+	// the same group id split the topic partitions. the rebalance
+	// strategy is range (default), roundrobin or sticky. group is
+	// an alias of the group-id, they can't be set together.
+	GroupID           string `json:"group-id"`
+	Group             string `json:"group"`
+	RebalanceStrategy string `json:"rebalance-strategy"`
+
+	// Offset is the initial offset of the group which hasn't
+	// committed an offset yet, oldest (default) or newest.
+	Offset string `json:"offset"`
+
+	SASL      config.SASLConfig
 	TLSConfig config.TLSConfig
+
+	// MaxMessageSize is the max size in bytes of a decompressed
+	// message, the larger ones are dropped. it's 4MB by default.
+	MaxMessageSize int
 }
 
-func kafkaConfig(cfg map[string]interface{}) *Config {
+func kafkaConfig(cfg map[string]interface{}) (*Config, error) {
 	// default configuration
 	conf := &Config{
 		Brokers:      []string{"localhost:9092"},
@@ -55,22 +74,58 @@ func kafkaConfig(cfg map[string]interface{}) *Config {
 		RetryBackoff: 2,
 		Workers:      2,
 		Version:      "0.10.2.1",
+
+		RebalanceStrategy: "range",
+		Offset:            "oldest",
 	}
 
 	if err := config.Transform(cfg, conf); err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// groupID returns the consumer group id, it's tcpdog by default
+func (c *Config) groupID() (string, error) {
+	switch {
+	case c.Group != "" && c.GroupID != "":
+		return "", errors.New("kafka group and group-id are exclusive")
+	case c.Group != "":
+		return c.Group, nil
+	case c.GroupID != "":
+		return c.GroupID, nil
 	}
 
-	return conf
+	return "tcpdog", nil
 }
 
 func saramaConfig(kCfg *Config) (*sarama.Config, error) {
 	sConfig := sarama.NewConfig()
 	sConfig.ClientID = "tcpdog"
-	sConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
 	sConfig.Consumer.Retry.Backoff = time.Duration(kCfg.RetryBackoff) * time.Second
 	sConfig.Version = kafkaVersion[kCfg.Version]
 
+	switch kCfg.RebalanceStrategy {
+	case "", "range":
+		sConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	case "roundrobin":
+		sConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	case "sticky":
+		sConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky
+	default:
+		return nil, fmt.Errorf("kafka rebalance strategy %s is not supported", kCfg.RebalanceStrategy)
+	}
+
+	switch kCfg.Offset {
+	case "", "oldest":
+		sConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case "newest":
+		sConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		return nil, fmt.Errorf("kafka offset %s is not supported", kCfg.Offset)
+	}
+
 	if kCfg.TLSConfig.Enable {
 		tlsConfig, err := config.GetTLS(&kCfg.TLSConfig)
 		if err != nil {
@@ -81,5 +136,9 @@ func saramaConfig(kCfg *Config) (*sarama.Config, error) {
 		sConfig.Net.TLS.Config = tlsConfig
 	}
 
+	if err := helper.SASL(sConfig, &kCfg.SASL); err != nil {
+		return nil, err
+	}
+
 	return sConfig, nil
 }
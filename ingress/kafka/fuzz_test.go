@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+package kafka
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func FuzzGetUnmarshal(f *testing.F) {
+	record := []byte(`{"PID":123456,"Task":"curl","RTT":12345,"SAddr":"10.0.0.1","Timestamp":1611118090,"Hostname":"foo"}`)
+
+	p := pb.Fields{}
+	protojson.Unmarshal(record, &p)
+	bPB, _ := proto.Marshal(&p)
+
+	s := pb.FieldsSPB{}
+	protojson.Unmarshal([]byte(`{"Fields":`+string(record)+`}`), &s)
+	bSPB, _ := proto.Marshal(&s)
+
+	for _, b := range [][]byte{record, bPB, bSPB, {}, []byte("null")} {
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for _, ser := range []string{"json", "pb", "spb"} {
+			getUnmarshal(ser, 0)(b)
+		}
+	})
+}
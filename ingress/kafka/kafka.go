@@ -2,46 +2,130 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/mehrdadrad/tcpdog/config"
 	"github.com/mehrdadrad/tcpdog/egress/helper"
-	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
 type consumerGroup struct {
+	name          string
+	label         string
 	group         sarama.ConsumerGroup
 	logger        *zap.Logger
 	serialization string
+	// maxMessageSize is the max decompressed message size
+	maxMessageSize int
 }
 
 type handler struct {
-	ch chan []byte
+	ch    chan *pending
+	state *health.State
+}
+
+// pending represents a message which has been handed to the workers
+type pending struct {
+	*sarama.ConsumerMessage
+	offsets *offsets
+}
+
+// offsets marks the delivered messages of a claim in order, a message
+// is marked once it and the messages before it have been delivered
+// thus the committed offset never skips an undelivered message.
+type offsets struct {
+	sync.Mutex
+	session   sarama.ConsumerGroupSession
+	handed    []*sarama.ConsumerMessage
+	delivered map[int64]bool
+}
+
+// errNotJoined is the state of the consumer group before its first session
+var errNotJoined = errors.New("consumer group hasn't been joined")
+
+// Setup reports the group has been joined, the claims have been assigned
+func (h handler) Setup(_ sarama.ConsumerGroupSession) error {
+	h.state.Set(nil)
+	return nil
 }
 
-func (h handler) Setup(_ sarama.ConsumerGroupSession) error   { return nil }
 func (h handler) Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim hands the messages to the workers, a message is marked
+// once it's been delivered to the flow thus the message which is
+// pending at the end of the session isn't committed and it's consumed
+// again later.
 func (h handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	o := &offsets{session: session, delivered: map[int64]bool{}}
+
 	for message := range claim.Messages() {
-		h.ch <- message.Value
-		session.MarkMessage(message, "")
+		o.hand(message)
+
+		select {
+		case h.ch <- &pending{ConsumerMessage: message, offsets: o}:
+		case <-session.Context().Done():
+			return nil
+		}
 	}
 	return nil
 }
 
+func (o *offsets) hand(m *sarama.ConsumerMessage) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.handed = append(o.handed, m)
+}
+
+// done marks the last message which all the messages before it have
+// been delivered as well.
+func (o *offsets) done(m *sarama.ConsumerMessage) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.delivered[m.Offset] = true
+
+	var last *sarama.ConsumerMessage
+	for len(o.handed) > 0 && o.delivered[o.handed[0].Offset] {
+		last = o.handed[0]
+		delete(o.delivered, last.Offset)
+		o.handed = o.handed[1:]
+	}
+
+	if last != nil {
+		o.session.MarkMessage(last, "")
+	}
+}
+
+// done marks the message if it belongs to a claim
+func (p *pending) done() {
+	if p.offsets != nil {
+		p.offsets.done(p.ConsumerMessage)
+	}
+}
+
 func newConsumerGroup(logger *zap.Logger, kCfg *Config) (*consumerGroup, error) {
 	var err error
 
+	kCfg.GroupID, err = kCfg.groupID()
+	if err != nil {
+		return nil, err
+	}
+	kCfg.Group = ""
+
 	sConfig, err := saramaConfig(kCfg)
 	if err != nil {
 		return nil, err
 	}
 
-	group, err := sarama.NewConsumerGroup(kCfg.Brokers, "tcpdog", sConfig)
+	group, err := newSaramaGroup(kCfg.Brokers, kCfg.GroupID, sConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +138,11 @@ func newConsumerGroup(logger *zap.Logger, kCfg *Config) (*consumerGroup, error)
 
 // Start starts a consumer group
 func Start(ctx context.Context, name string, ser string, ch chan interface{}) error {
-	kCfg := kafkaConfig(config.FromContextServer(ctx).Ingress[name].Config)
+	kCfg, err := kafkaConfig(config.FromContextServer(ctx).Ingress[name].Config)
+	if err != nil {
+		return err
+	}
+
 	logger := config.FromContextServer(ctx).Logger()
 
 	cg, err := newConsumerGroup(logger, kCfg)
@@ -62,41 +150,72 @@ func Start(ctx context.Context, name string, ser string, ch chan interface{}) er
 		return err
 	}
 
+	cg.name = name
+	cg.label = metrics.Flow(ctx)
 	cg.serialization = ser
+	cg.maxMessageSize = kCfg.MaxMessageSize
 
 	// error handling
 	go func() {
 		for err := range cg.group.Errors() {
-			logger.Error("kafka", zap.Error(err))
+			logger.Error("kafka", zap.String("group", kCfg.GroupID), zap.Error(err))
 		}
 	}()
 
 	handler := handler{
-		ch: make(chan []byte, 1),
+		ch:    make(chan *pending, 1),
+		state: health.NewState(errNotJoined),
 	}
 
+	health.Register(ctx, "ingress", name, handler.state)
+
+	var wg sync.WaitGroup
+
 	// consumer group
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
+
 		backoff := helper.NewBackoff(logger)
 
 		for {
-			backoff.Next()
+			if !backoff.Wait(ctx) {
+				return
+			}
 
 			err := cg.group.Consume(ctx, []string{kCfg.Topic}, handler)
+
+			// the session has been released and its marked offsets have been committed
+			if ctx.Err() != nil {
+				return
+			}
+
 			if err != nil {
-				logger.Error("kafka", zap.Error(err))
+				logger.Error("kafka", zap.String("group", kCfg.GroupID), zap.Error(err))
+				handler.state.Set(err)
 			} else {
-				logger.Warn("kafka", zap.String("msg", "consumer group has been terminated"))
-				cg.consumerGroupCleanup()
+				logger.Warn("kafka", zap.String("msg", "consumer group has been terminated"), zap.String("group", kCfg.GroupID))
 				return
 			}
 		}
 	}()
 
 	for i := 0; i < kCfg.Workers; i++ {
-		go cg.worker(ctx, ch, handler.ch)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cg.worker(ctx, ch, handler.ch)
+		}()
 	}
 
+	// the group is closed once the consumer and the workers have been
+	// stopped, it stops the error handling goroutine as well. the
+	// pending messages haven't been marked thus they're consumed again.
+	go func() {
+		wg.Wait()
+		cg.consumerGroupCleanup()
+	}()
+
 	return nil
 }
 
@@ -104,42 +223,58 @@ func (k *consumerGroup) consumerGroupCleanup() {
 	k.group.Close()
 }
 
-func (k *consumerGroup) worker(ctx context.Context, ch chan interface{}, bCh chan []byte) {
-	unmarshal := getUnmarshal(k.serialization)
+func (k *consumerGroup) worker(ctx context.Context, ch chan interface{}, mCh chan *pending) {
+	unmarshal := getUnmarshal(k.serialization, k.maxMessageSize)
 
 	for {
-		b := <-bCh
-		i, err := unmarshal(b)
+		var m *pending
+
+		select {
+		case m = <-mCh:
+		case <-ctx.Done():
+			return
+		}
+
+		start := time.Now()
+
+		i, err := unmarshal(m.Value)
 		if err != nil {
-			k.logger.Error("kafka", zap.String("event", "marshal"), zap.Error(err))
+			metrics.UnmarshalError(k.label, k.serialization)
+			m.done()
 			continue
 		}
 
-		ch <- i
+		if tracing.Enabled() {
+			tr := tracing.Sample(i, "kafka.consume", traceparent(m.Headers), start)
+			tr.Span("unmarshal", start, time.Now())
+		}
+
+		// the message isn't marked if the server is shutting
+		// down thus it's consumed again.
+		select {
+		case ch <- i:
+			metrics.IngressMessage(k.label, k.name)
+			m.done()
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func getUnmarshal(ser string) func(b []byte) (interface{}, error) {
-	switch ser {
-	case "json":
-		return func(b []byte) (interface{}, error) {
-			m := map[string]interface{}{}
-			err := json.Unmarshal(b, &m)
-			return m, err
-		}
-	case "spb":
-		return func(b []byte) (interface{}, error) {
-			p := pb.FieldsSPB{}
-			err := proto.Unmarshal(b, &p)
-			return &p, err
-		}
-	case "pb":
-		return func(b []byte) (interface{}, error) {
-			p := pb.Fields{}
-			err := proto.Unmarshal(b, &p)
-			return &p, err
+// traceparent returns the trace context header of the message,
+// the headers require the kafka version 0.11 or later.
+func traceparent(headers []*sarama.RecordHeader) string {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == tracing.Header {
+			return string(h.Value)
 		}
 	}
 
-	return nil
+	return ""
 }
+
+// getUnmarshal returns the unmarshal function of the serialization
+var getUnmarshal = helper.Unmarshaler
+
+// newSaramaGroup creates the sarama consumer group
+var newSaramaGroup = sarama.NewConsumerGroup
@@ -2,20 +2,30 @@ package kafka
 
 import (
 	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/metrics"
 	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+	"github.com/mehrdadrad/tcpdog/tracing"
 )
 
 func TestGetUnmarshalJSON(t *testing.T) {
-	f := getUnmarshal("json")
+	f := getUnmarshal("json", 0)
 	b := []byte(`{"F1":5,"Timestamp":1611634115,"Hostname":"foo"}`)
 	v, err := f(b)
 	assert.NoError(t, err)
@@ -26,8 +36,23 @@ func TestGetUnmarshalJSON(t *testing.T) {
 	assert.Equal(t, "foo", m["Hostname"])
 }
 
+func TestGetUnmarshalCloudEvents(t *testing.T) {
+	ce, err := helper.NewCloudEvents("", "", "tcp:tcp_probe")
+	assert.NoError(t, err)
+
+	f := getUnmarshal("json", 0)
+	b := ce.Append(nil, []byte(`{"F1":5,"Timestamp":1611634115,"Hostname":"foo"}`))
+	v, err := f(b)
+	assert.NoError(t, err)
+
+	m := v.(map[string]interface{})
+	assert.Equal(t, float64(5), m["F1"])
+	assert.Equal(t, "foo", m["Hostname"])
+	assert.NotContains(t, m, "specversion")
+}
+
 func TestGetUnmarshalPB(t *testing.T) {
-	f := getUnmarshal("pb")
+	f := getUnmarshal("pb", 0)
 	r := uint32(5)
 	s := "foo"
 	p := pb.Fields{RTT: &r, Hostname: &s}
@@ -42,8 +67,81 @@ func TestGetUnmarshalPB(t *testing.T) {
 	assert.Equal(t, "foo", *m.Hostname)
 }
 
+func TestGetUnmarshalMsgpack(t *testing.T) {
+	f := getUnmarshal("msgpack", 0)
+	rtt, timestamp, task := uint32(310), uint64(1611634115), "curl"
+	p := pb.Fields{RTT: &rtt, Timestamp: &timestamp, Task: &task}
+
+	r, _, err := serialization.Convert(&p, "pb", "msgpack")
+	assert.NoError(t, err)
+	b, err := serialization.MarshalMsgpack(r.(map[string]interface{}))
+	assert.NoError(t, err)
+
+	v, err := f(b)
+	assert.NoError(t, err)
+
+	m := v.(map[string]interface{})
+	assert.Equal(t, int64(310), m["RTT"])
+	assert.Equal(t, int64(1611634115), m["Timestamp"])
+	assert.Equal(t, "curl", m["Task"])
+
+	// the record converts to the pb back
+	r, dropped, err := serialization.Convert(m, "msgpack", "pb")
+	assert.NoError(t, err)
+	assert.Empty(t, dropped)
+	assert.True(t, proto.Equal(&p, r.(*pb.Fields)))
+
+	_, err = f([]byte{0xc1})
+	assert.Error(t, err)
+}
+
+func TestGetUnmarshalCBOR(t *testing.T) {
+	f := getUnmarshal("cbor", 0)
+	rtt, timestamp, task, daddr := uint32(310), uint64(1611634115), "curl", "2001:db8::1"
+	p := pb.Fields{RTT: &rtt, Timestamp: &timestamp, Task: &task, DAddr: &daddr}
+
+	r, _, err := serialization.Convert(&p, "pb", "cbor")
+	assert.NoError(t, err)
+	b, err := serialization.MarshalCBOR(r.(map[string]interface{}))
+	assert.NoError(t, err)
+
+	v, err := f(b)
+	assert.NoError(t, err)
+
+	m := v.(map[string]interface{})
+	assert.Equal(t, int64(310), m["RTT"])
+	assert.Equal(t, int64(1611634115), m["Timestamp"])
+	assert.Equal(t, "curl", m["Task"])
+	assert.Equal(t, "2001:db8::1", m["DAddr"])
+
+	// the record converts to the pb back
+	r, dropped, err := serialization.Convert(m, "cbor", "pb")
+	assert.NoError(t, err)
+	assert.Empty(t, dropped)
+	assert.True(t, proto.Equal(&p, r.(*pb.Fields)))
+
+	_, err = f([]byte{0xff})
+	assert.Error(t, err)
+}
+
+func TestGetUnmarshalCompressed(t *testing.T) {
+	f := getUnmarshal("json", 0)
+	b := []byte(`{"F1":5,"Timestamp":1611634115,"Hostname":"foo"}`)
+
+	for _, codec := range []string{"gzip", "zstd"} {
+		compress, err := helper.PayloadCompressor(codec)
+		assert.NoError(t, err)
+		cb, err := compress(b)
+		assert.NoError(t, err)
+
+		v, err := f(cb)
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", v.(map[string]interface{})["Hostname"])
+	}
+}
+
 func TestGetUnmarshalSPB(t *testing.T) {
-	f := getUnmarshal("spb")
+	f := getUnmarshal("spb", 0)
 	b := []byte(`{"F1":5,"Timestamp":1611634115,"Hostname":"foo"}`)
 	p := structpb.Struct{}
 	protojson.Unmarshal(b, &p)
@@ -60,7 +158,7 @@ func TestGetUnmarshalSPB(t *testing.T) {
 	assert.Equal(t, "foo", m.Fields.Fields["Hostname"].GetStringValue())
 
 	// cover nil
-	f = getUnmarshal("unknown")
+	f = getUnmarshal("unknown", 0)
 	assert.Nil(t, f)
 }
 
@@ -120,3 +218,400 @@ func TestSaramaConfig(t *testing.T) {
 	_, err := saramaConfig(conf)
 	assert.NoError(t, err)
 }
+
+func TestSaramaConfigSASL(t *testing.T) {
+	conf := &Config{
+		Brokers: []string{"localhost:9092"},
+		Version: "2.0.0",
+		SASL: config.SASLConfig{
+			Enable:    true,
+			Mechanism: "SCRAM-SHA-256",
+			Username:  "tcpdog",
+			Password:  "secret",
+		},
+		TLSConfig: config.TLSConfig{
+			Enable:             true,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	sConfig, err := saramaConfig(conf)
+	assert.NoError(t, err)
+	assert.True(t, sConfig.Net.TLS.Enable)
+	assert.True(t, sConfig.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA256), sConfig.Net.SASL.Mechanism)
+	assert.Equal(t, "tcpdog", sConfig.Net.SASL.User)
+	assert.Equal(t, "secret", sConfig.Net.SASL.Password)
+
+	// unknown mechanism
+	conf.SASL.Mechanism = "OAUTHBEARER"
+	_, err = newConsumerGroup(zap.NewNop(), conf)
+	assert.EqualError(t, err, "kafka sasl mechanism OAUTHBEARER is not supported")
+}
+
+func TestSaramaConfigSASLMechanisms(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		expected  sarama.SASLMechanism
+	}{
+		{"PLAIN", sarama.SASLTypePlaintext},
+		{"SCRAM-SHA-256", sarama.SASLTypeSCRAMSHA256},
+		{"SCRAM-SHA-512", sarama.SASLTypeSCRAMSHA512},
+	}
+
+	for _, test := range tests {
+		kCfg := testConfig(t, map[string]interface{}{
+			"version": "2.5.0.0",
+			"sasl": map[string]interface{}{
+				"enable":    true,
+				"mechanism": test.mechanism,
+				"username":  "tcpdog",
+				"password":  "secret",
+			},
+			"tlsConfig": map[string]interface{}{
+				"enable":             true,
+				"insecureSkipVerify": true,
+			},
+		})
+
+		sConfig, err := saramaConfig(kCfg)
+		assert.NoError(t, err, test.mechanism)
+		assert.NoError(t, sConfig.Validate(), test.mechanism)
+
+		assert.True(t, sConfig.Net.TLS.Enable)
+		assert.True(t, sConfig.Net.SASL.Enable)
+		assert.Equal(t, test.expected, sConfig.Net.SASL.Mechanism)
+		assert.Equal(t, "tcpdog", sConfig.Net.SASL.User)
+		assert.Equal(t, "secret", sConfig.Net.SASL.Password)
+	}
+}
+
+func TestWorkerTrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := tracing.Start(ctx, config.Tracing{Endpoint: "http://127.0.0.1:4318/v1/traces"}, "tcpdog-server", zap.NewNop())
+	assert.NoError(t, err)
+
+	cg := &consumerGroup{logger: zap.NewNop(), serialization: "json"}
+	ch := make(chan interface{}, 1)
+	mCh := make(chan *pending, 1)
+	go cg.worker(ctx, ch, mCh)
+
+	// the agent sampled it, the server sample rate is zero
+	mCh <- &pending{ConsumerMessage: &sarama.ConsumerMessage{
+		Value: []byte(`{"F1":5,"Timestamp":1611634115}`),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("traceparent"), Value: []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")},
+		},
+	}}
+
+	r := <-ch
+	tr := tracing.From(r)
+	assert.NotNil(t, tr)
+	assert.Contains(t, tr.Traceparent(), "0af7651916cd43dd8448eb211c80319c")
+
+	spans := tr.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "kafka.consume", spans[0].Name)
+	assert.Equal(t, "unmarshal", spans[1].Name)
+
+	mCh <- &pending{ConsumerMessage: &sarama.ConsumerMessage{Value: []byte(`{"F1":5,"Timestamp":1611634115}`)}}
+	assert.Nil(t, tracing.From(<-ch))
+}
+
+func TestWorkerLeak(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cg := &consumerGroup{name: "foo", logger: zap.NewNop(), serialization: "json"}
+	ch := make(chan interface{})
+	mCh := make(chan *pending, 1)
+
+	// a worker waits for the message and the other one
+	// is blocked on the ingress channel which isn't read.
+	go cg.worker(ctx, ch, mCh)
+	go cg.worker(ctx, ch, mCh)
+	mCh <- &pending{ConsumerMessage: &sarama.ConsumerMessage{Value: []byte(`{"F1":5}`)}}
+
+	// the goroutines are checked by the TestMain
+	assert.Eventually(t, func() bool { return len(mCh) == 0 }, time.Second, time.Millisecond)
+	cancel()
+}
+
+func TestWorkerMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cg := &consumerGroup{name: "kafka-metrics", label: "kafka-metrics/es01", logger: zap.NewNop(), serialization: "json"}
+	ch := make(chan interface{}, 1)
+	mCh := make(chan *pending, 1)
+	go cg.worker(ctx, ch, mCh)
+
+	mCh <- &pending{ConsumerMessage: &sarama.ConsumerMessage{Value: []byte(`{"F1":`)}}
+	mCh <- &pending{ConsumerMessage: &sarama.ConsumerMessage{Value: []byte(`{"F1":5}`)}}
+	<-ch
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Contains(t, rec.Body.String(), `tcpdog_ingress_messages_total{flow="kafka-metrics/es01",ingress="kafka-metrics"} 1`)
+	assert.Regexp(t, `tcpdog_unmarshal_errors_total{flow="kafka-metrics/es01",serialization="json"} [1-9]`, rec.Body.String())
+}
+
+func TestConsumerGroupID(t *testing.T) {
+	var groupID string
+
+	newSaramaGroup = func(addrs []string, id string, sConfig *sarama.Config) (sarama.ConsumerGroup, error) {
+		groupID = id
+		return nil, nil
+	}
+	defer func() { newSaramaGroup = sarama.NewConsumerGroup }()
+
+	// default
+	kCfg := testConfig(t, map[string]interface{}{})
+	_, err := newConsumerGroup(zap.NewNop(), kCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog", groupID)
+
+	sConfig, err := saramaConfig(kCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.BalanceStrategyRange, sConfig.Consumer.Group.Rebalance.Strategy)
+
+	// override
+	kCfg = testConfig(t, map[string]interface{}{
+		"group-id":           "tcpdog-dc2",
+		"rebalance-strategy": "sticky",
+	})
+	_, err = newConsumerGroup(zap.NewNop(), kCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog-dc2", groupID)
+
+	sConfig, err = saramaConfig(kCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.BalanceStrategySticky, sConfig.Consumer.Group.Rebalance.Strategy)
+
+	// invalid strategy
+	groupID = ""
+	kCfg = testConfig(t, map[string]interface{}{"rebalance-strategy": "random"})
+	_, err = newConsumerGroup(zap.NewNop(), kCfg)
+	assert.EqualError(t, err, "kafka rebalance strategy random is not supported")
+	assert.Equal(t, "", groupID)
+}
+
+func TestConsumerGroupOffset(t *testing.T) {
+	var (
+		groupID string
+		offset  int64
+	)
+
+	newSaramaGroup = func(addrs []string, id string, sConfig *sarama.Config) (sarama.ConsumerGroup, error) {
+		groupID, offset = id, sConfig.Consumer.Offsets.Initial
+		return nil, nil
+	}
+	defer func() { newSaramaGroup = sarama.NewConsumerGroup }()
+
+	// default
+	_, err := newConsumerGroup(zap.NewNop(), testConfig(t, map[string]interface{}{}))
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog", groupID)
+	assert.Equal(t, sarama.OffsetOldest, offset)
+
+	// the group is an alias of the group-id
+	_, err = newConsumerGroup(zap.NewNop(), testConfig(t, map[string]interface{}{
+		"group":  "tcpdog-prod",
+		"offset": "newest",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog-prod", groupID)
+	assert.Equal(t, sarama.OffsetNewest, offset)
+
+	_, err = newConsumerGroup(zap.NewNop(), testConfig(t, map[string]interface{}{"group-id": "tcpdog-dc2"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog-dc2", groupID)
+
+	groupID = ""
+	_, err = newConsumerGroup(zap.NewNop(), testConfig(t, map[string]interface{}{
+		"group-id": "tcpdog-dc2",
+		"group":    "tcpdog-prod",
+	}))
+	assert.EqualError(t, err, "kafka group and group-id are exclusive")
+	assert.Equal(t, "", groupID)
+
+	// invalid offset
+	groupID = ""
+	_, err = newConsumerGroup(zap.NewNop(), testConfig(t, map[string]interface{}{"offset": "latest"}))
+	assert.EqualError(t, err, "kafka offset latest is not supported")
+	assert.Equal(t, "", groupID)
+}
+
+// fakeGroup is a consumer group which hands its messages by a claim
+type fakeGroup struct {
+	sync.Mutex
+
+	messages []*sarama.ConsumerMessage
+	marked   []int64
+	errors   chan error
+	closed   chan struct{}
+	once     sync.Once
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx   context.Context
+	group *fakeGroup
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	ch chan *sarama.ConsumerMessage
+}
+
+func newFakeGroup(messages ...*sarama.ConsumerMessage) *fakeGroup {
+	return &fakeGroup{
+		messages: messages,
+		errors:   make(chan error),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (g *fakeGroup) Consume(ctx context.Context, _ []string, h sarama.ConsumerGroupHandler) error {
+	session := &fakeSession{ctx: ctx, group: g}
+	claim := &fakeClaim{ch: make(chan *sarama.ConsumerMessage, len(g.messages))}
+	for _, m := range g.messages {
+		claim.ch <- m
+	}
+
+	// the claim is closed once the session has been done
+	go func() {
+		<-ctx.Done()
+		close(claim.ch)
+	}()
+
+	h.Setup(session)
+	err := h.ConsumeClaim(session, claim)
+	h.Cleanup(session)
+
+	return err
+}
+
+func (g *fakeGroup) Errors() <-chan error { return g.errors }
+
+func (g *fakeGroup) Close() error {
+	g.once.Do(func() {
+		close(g.errors)
+		close(g.closed)
+	})
+
+	return nil
+}
+
+func (g *fakeGroup) offsets() []int64 {
+	g.Lock()
+	defer g.Unlock()
+
+	return append([]int64{}, g.marked...)
+}
+
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) MarkMessage(m *sarama.ConsumerMessage, _ string) {
+	s.group.Lock()
+	defer s.group.Unlock()
+
+	s.group.marked = append(s.group.marked, m.Offset)
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.ch }
+
+func TestStartShutdown(t *testing.T) {
+	var messages []*sarama.ConsumerMessage
+	for i := 0; i < 5; i++ {
+		messages = append(messages, &sarama.ConsumerMessage{Offset: int64(i), Value: []byte(fmt.Sprintf(`{"F1":%d}`, i))})
+	}
+
+	group := newFakeGroup(messages...)
+	dropped := drops.Count(drops.ServerChannel, "kafka-shutdown")
+
+	newSaramaGroup = func(addrs []string, id string, sConfig *sarama.Config) (sarama.ConsumerGroup, error) {
+		return group, nil
+	}
+	defer func() { newSaramaGroup = sarama.NewConsumerGroup }()
+
+	cfg := config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"kafka-shutdown": {
+				Config: map[string]interface{}{
+					"workers": 2,
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("kafka-shutdown")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = cfg.WithContext(ctx)
+
+	ch := make(chan interface{})
+	err := Start(ctx, "kafka-shutdown", "json", ch)
+	assert.NoError(t, err)
+
+	// the messages are marked once they and the messages
+	// before them have been delivered.
+	delivered := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		r := <-ch
+		delivered[fmt.Sprint(r.(map[string]interface{})["F1"])] = true
+	}
+
+	last := int64(-1)
+	for delivered[fmt.Sprint(last+1)] {
+		last++
+	}
+
+	assert.Eventually(t, func() bool {
+		offsets := group.offsets()
+		return last < 0 || len(offsets) > 0 && offsets[len(offsets)-1] == last
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-group.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer group has not been closed")
+	}
+
+	// the undelivered messages aren't marked thus they're consumed again
+	offsets := append([]int64{-1}, group.offsets()...)
+	assert.Equal(t, last, offsets[len(offsets)-1])
+	assert.Equal(t, uint64(0), drops.Count(drops.ServerChannel, "kafka-shutdown")-dropped)
+}
+
+func TestOffsetsInOrder(t *testing.T) {
+	group := newFakeGroup()
+	o := &offsets{session: &fakeSession{group: group}, delivered: map[int64]bool{}}
+
+	var messages []*sarama.ConsumerMessage
+	for i := 0; i < 4; i++ {
+		messages = append(messages, &sarama.ConsumerMessage{Offset: int64(i)})
+		o.hand(messages[i])
+	}
+
+	// the message isn't marked until the messages before it have been delivered
+	o.done(messages[1])
+	o.done(messages[3])
+	assert.Len(t, group.offsets(), 0)
+
+	o.done(messages[0])
+	assert.Equal(t, []int64{1}, group.offsets())
+
+	o.done(messages[2])
+	assert.Equal(t, []int64{1, 3}, group.offsets())
+}
+
+func testConfig(t *testing.T, cfg map[string]interface{}) *Config {
+	c, err := kafkaConfig(cfg)
+	assert.NoError(t, err)
+
+	return c
+}
@@ -0,0 +1,123 @@
+package nats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Config represents NATS subscriber configuration
+type Config struct {
+	Servers []string
+	Subject string
+
+	// QueueGroup shares the messages of the subject between the
+	// subscribers of the group e.g. the tcpdog servers.
+	QueueGroup string
+
+	Workers int
+
+	// PendingMsgs is the buffered messages of the subscription, the
+	// messages beyond it are dropped by the client (slow consumer).
+	PendingMsgs int
+
+	// ReconnectWait is the reconnect backoff in milliseconds
+	ReconnectWait int
+
+	// Stream consumes the subject by the Durable consumer of the
+	// JetStream stream, the messages are acked once they've been
+	// handed to the flow and they're redelivered after AckWait in
+	// milliseconds if they're not acked e.g. at a crash.
+	Stream  string
+	Durable string
+	AckWait int
+
+	// CredsFile is the user credentials (JWT and NKey seed) file
+	CredsFile string
+
+	TLSConfig config.TLSConfig
+
+	// MaxMessageSize is the max size in bytes of a decompressed
+	// message, the larger ones are dropped. it's 4MB by default.
+	MaxMessageSize int
+}
+
+func natsConfig(cfg map[string]interface{}) (*Config, error) {
+	// default configuration
+	c := &Config{
+		Servers:       []string{nats.DefaultURL},
+		Subject:       "tcpdog",
+		Workers:       2,
+		PendingMsgs:   65536,
+		ReconnectWait: 2000,
+		AckWait:       30000,
+	}
+
+	if err := config.Transform(cfg, c); err != nil {
+		return nil, err
+	}
+
+	if c.Subject == "" || strings.ContainsAny(c.Subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid nats subject: %q", c.Subject)
+	}
+
+	if c.Workers < 1 || c.PendingMsgs < 1 {
+		return nil, fmt.Errorf("invalid nats workers or pendingMsgs")
+	}
+
+	if c.Stream != "" {
+		if c.Durable == "" {
+			c.Durable = "tcpdog"
+		}
+
+		if strings.ContainsAny(c.Stream+c.Durable, ". *>\t\r\n") {
+			return nil, fmt.Errorf("invalid nats stream or durable: %q, %q", c.Stream, c.Durable)
+		}
+
+		if c.AckWait < 1 {
+			return nil, fmt.Errorf("invalid nats ackWait: %d", c.AckWait)
+		}
+	}
+
+	return c, nil
+}
+
+// options returns the client options, the client reconnects forever
+func (c *Config) options(name string) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name("tcpdog-" + name),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.ReconnectWait(time.Duration(c.ReconnectWait) * time.Millisecond),
+	}
+
+	if c.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(c.CredsFile))
+	}
+
+	if c.TLSConfig.Enable {
+		tlsConfig, err := config.GetTLS(&c.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	return opts, nil
+}
+
+// subOptions returns the JetStream consumer options, the consumer is
+// bound to the stream and the messages are acked by the workers.
+func (c *Config) subOptions() []nats.SubOpt {
+	return []nats.SubOpt{
+		nats.BindStream(c.Stream),
+		nats.Durable(c.Durable),
+		nats.ManualAck(),
+		nats.AckWait(time.Duration(c.AckWait) * time.Millisecond),
+		nats.MaxAckPending(c.PendingMsgs),
+	}
+}
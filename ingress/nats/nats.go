@@ -0,0 +1,226 @@
+// Package nats consumes the events of a NATS subject, the subject
+// is consumed by a durable consumer if the JetStream stream is set.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/tracing"
+)
+
+type subscriber struct {
+	name          string
+	label         string
+	serialization string
+	logger        *zap.Logger
+	unmarshal     func(b []byte) (interface{}, error)
+
+	// ack acks the JetStream messages
+	ack bool
+}
+
+// Start subscribes to the subject, the messages are decoded by the
+// workers. the subscription is drained once the context is canceled,
+// the workers hand the drained messages to the flow until the
+// connection has been closed.
+func Start(ctx context.Context, name string, ser string, ch chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+
+	nCfg, err := natsConfig(cfg.Ingress[name].Config)
+	if err != nil {
+		return err
+	}
+
+	s := &subscriber{
+		name:          name,
+		label:         metrics.Flow(ctx),
+		serialization: ser,
+		logger:        cfg.Logger(),
+		unmarshal:     helper.Unmarshaler(ser, nCfg.MaxMessageSize),
+		ack:           nCfg.Stream != "",
+	}
+
+	if s.unmarshal == nil {
+		return fmt.Errorf("nats doesn't support %s serialization", ser)
+	}
+
+	opts, err := nCfg.options(name)
+	if err != nil {
+		return err
+	}
+
+	closed := make(chan struct{})
+
+	opts = append(opts, s.handlers()...)
+	opts = append(opts, nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+
+	conn, err := nats.Connect(strings.Join(nCfg.Servers, ","), opts...)
+	if err != nil {
+		return err
+	}
+
+	mCh := make(chan *nats.Msg, nCfg.PendingMsgs)
+
+	// the queue group is optional
+	if nCfg.Stream != "" {
+		err = subscribeJetStream(ctx, conn, nCfg, mCh)
+	} else {
+		_, err = conn.ChanQueueSubscribe(nCfg.Subject, nCfg.QueueGroup, mCh)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	health.Register(ctx, "ingress", name, health.CheckerFunc(func(context.Context) error {
+		return connected(conn)
+	}))
+
+	for i := 0; i < nCfg.Workers; i++ {
+		go s.worker(ch, mCh, closed)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := conn.Drain(); err != nil {
+			conn.Close()
+		}
+	}()
+
+	return nil
+}
+
+// subscribeJetStream subscribes to the subject by the durable consumer,
+// the messages aren't dropped once the workers are behind, they wait in
+// the client and the server redelivers the unacked ones.
+func subscribeJetStream(ctx context.Context, conn *nats.Conn, nCfg *Config, mCh chan *nats.Msg) error {
+	js, err := conn.JetStream()
+	if err != nil {
+		return fmt.Errorf("nats jetstream: %w", err)
+	}
+
+	_, err = js.QueueSubscribe(nCfg.Subject, nCfg.QueueGroup, func(m *nats.Msg) {
+		select {
+		case mCh <- m:
+		case <-ctx.Done():
+		}
+	}, nCfg.subOptions()...)
+
+	return err
+}
+
+// connected returns the last error of the connection if it's
+// not connected e.g. it's reconnecting to the servers.
+func connected(conn *nats.Conn) error {
+	if conn.IsConnected() {
+		return nil
+	}
+
+	if err := conn.LastError(); err != nil {
+		return err
+	}
+
+	return errors.New("not connected")
+}
+
+// handlers logs the connection events and the slow consumer
+func (s *subscriber) handlers() []nats.Option {
+	return []nats.Option{
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				s.logger.Warn("nats", zap.String("ingress", s.name), zap.Error(err))
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			s.logger.Info("nats", zap.String("msg", fmt.Sprintf("%s has been reconnected to %s", s.name, nc.ConnectedUrl())))
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if err == nats.ErrSlowConsumer && sub != nil {
+				dropped, _ := sub.Dropped()
+				s.logger.Warn("nats", zap.String("ingress", s.name), zap.Error(err), zap.Int("dropped", dropped))
+				return
+			}
+			s.logger.Error("nats", zap.String("ingress", s.name), zap.Error(err))
+		}),
+	}
+}
+
+// worker decodes the messages until the connection has been closed,
+// the messages which have been delivered by the drain are decoded too.
+func (s *subscriber) worker(ch chan interface{}, mCh chan *nats.Msg, closed chan struct{}) {
+	for {
+		var m *nats.Msg
+
+		select {
+		case m = <-mCh:
+		case <-closed:
+			select {
+			case m = <-mCh:
+			default:
+				return
+			}
+		}
+
+		start := time.Now()
+
+		i, err := s.unmarshal(m.Data)
+		if err != nil {
+			metrics.UnmarshalError(s.label, s.serialization)
+			s.logger.Error("nats", zap.String("event", "marshal"), zap.Error(err))
+			// the malformed message isn't redelivered
+			s.acked(m, m.Term)
+			continue
+		}
+
+		if tracing.Enabled() {
+			tr := tracing.Sample(i, "nats.consume", "", start)
+			tr.Span("unmarshal", start, time.Now())
+		}
+
+		if !deliver(ch, i, closed) {
+			return
+		}
+
+		metrics.IngressMessage(s.label, s.name)
+		s.acked(m, m.Ack)
+	}
+}
+
+// deliver hands the record to the flow, it gives up once the
+// connection has been closed and the flow doesn't receive it.
+func deliver(ch chan interface{}, i interface{}, closed chan struct{}) bool {
+	select {
+	case ch <- i:
+		return true
+	default:
+	}
+
+	select {
+	case ch <- i:
+		return true
+	case <-closed:
+		return false
+	}
+}
+
+// acked acks the JetStream message by the ack function
+func (s *subscriber) acked(m *nats.Msg, ack func(...nats.AckOpt) error) {
+	if !s.ack {
+		return
+	}
+
+	if err := ack(); err != nil {
+		s.logger.Error("nats", zap.String("ingress", s.name), zap.String("event", "ack"), zap.Error(err))
+	}
+}
@@ -0,0 +1,343 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// natsServer is a minimal NATS server, it hands the client
+// protocol lines to the channel and the test publishes the
+// messages to the subscription by the returned function.
+func natsServer(t *testing.T) (string, chan string, func(sid string, data []byte)) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var (
+		lines = make(chan string, 100)
+		conns = make(chan net.Conn, 1)
+	)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conns <- c
+
+			go func(c net.Conn) {
+				defer c.Close()
+
+				fmt.Fprint(c, "INFO {\"server_id\":\"test\",\"version\":\"2.2.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(c)
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					if strings.HasPrefix(line, "PING") {
+						fmt.Fprint(c, "PONG\r\n")
+						continue
+					}
+
+					lines <- strings.TrimSpace(line)
+				}
+			}(c)
+		}
+	}()
+
+	publish := func(sid string, data []byte) {
+		c := <-conns
+		fmt.Fprintf(c, "MSG tcpdog %s %d\r\n%s\r\n", sid, len(data), data)
+		conns <- c
+	}
+
+	return "nats://" + l.Addr().String(), lines, publish
+}
+
+func expect(t *testing.T, lines chan string, prefix string) string {
+	for {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected", prefix)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	url, lines, publish := natsServer(t)
+
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"nats01": {
+				Type: "nats",
+				Config: map[string]interface{}{
+					"servers":    []string{url},
+					"queueGroup": "tcpdog-servers",
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan interface{}, 1)
+	err := Start(ctx, "nats01", "json", ch)
+	assert.NoError(t, err)
+
+	// SUB <subject> <queue> <sid>
+	sub := strings.Fields(expect(t, lines, "SUB "))
+	assert.Equal(t, []string{"SUB", "tcpdog", "tcpdog-servers"}, sub[:3])
+
+	publish(sub[3], []byte(`{"RTT":5,"Hostname":"foo"}`))
+
+	select {
+	case i := <-ch:
+		assert.Equal(t, map[string]interface{}{"RTT": 5.0, "Hostname": "foo"}, i)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message has not been received")
+	}
+
+	// the CloudEvent is unwrapped
+	publish(sub[3], []byte(`{"specversion":"1.0","id":"2fd4e1c67a2d28fc","data":{"RTT":7,"Hostname":"foo"}}`))
+
+	select {
+	case i := <-ch:
+		assert.Equal(t, map[string]interface{}{"RTT": 7.0, "Hostname": "foo"}, i)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message has not been received")
+	}
+
+	// the subscription is drained
+	cancel()
+	assert.Equal(t, "UNSUB "+sub[3], expect(t, lines, "UNSUB"))
+}
+
+func TestStartSPB(t *testing.T) {
+	url, lines, publish := natsServer(t)
+
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"nats01": {Type: "nats", Config: map[string]interface{}{"servers": []string{url}}},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan interface{}, 1)
+	assert.NoError(t, Start(ctx, "nats01", "spb", ch))
+
+	// SUB <subject> <sid>
+	sub := strings.Fields(expect(t, lines, "SUB "))
+	assert.Len(t, sub, 3)
+
+	fields, _ := structpb.NewStruct(map[string]interface{}{"RTT": 5})
+	b, _ := proto.Marshal(&pb.FieldsSPB{Fields: fields})
+	publish(sub[2], b)
+
+	select {
+	case i := <-ch:
+		assert.Equal(t, 5.0, i.(*pb.FieldsSPB).Fields.Fields["RTT"].GetNumberValue())
+	case <-time.After(5 * time.Second):
+		t.Fatal("message has not been received")
+	}
+
+	err := Start(ctx, "nats01", "csv", ch)
+	assert.EqualError(t, err, "nats doesn't support csv serialization")
+}
+
+func TestConfig(t *testing.T) {
+	c, err := natsConfig(map[string]interface{}{"subject": "tcpdog.>", "queueGroup": "g1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "g1", c.QueueGroup)
+	assert.Equal(t, 2, c.Workers)
+
+	_, err = natsConfig(map[string]interface{}{"subject": ""})
+	assert.EqualError(t, err, `invalid nats subject: ""`)
+
+	_, err = natsConfig(map[string]interface{}{"workers": 0})
+	assert.Error(t, err)
+
+	c, err = natsConfig(map[string]interface{}{"stream": "TCPDOG", "credsFile": "/etc/tcpdog/nats.creds"})
+	assert.NoError(t, err)
+	assert.Equal(t, "tcpdog", c.Durable)
+	assert.Equal(t, 30000, c.AckWait)
+	assert.Len(t, c.subOptions(), 5)
+
+	_, err = natsConfig(map[string]interface{}{"stream": "TCPDOG", "durable": "tcpdog.servers"})
+	assert.EqualError(t, err, `invalid nats stream or durable: "TCPDOG", "tcpdog.servers"`)
+}
+
+// jetStreamServer is a minimal NATS server with a JetStream durable
+// consumer, it replies the JetStream API requests, it hands the SUB
+// lines and the acks to the channel and the test delivers a stream
+// message to the consumer by the returned function.
+func jetStreamServer(t *testing.T) (string, chan string, func(sid string, seq int, data []byte)) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var (
+		lines = make(chan string, 100)
+		conns = make(chan net.Conn, 1)
+	)
+
+	api := map[string]string{
+		"$JS.API.INFO": `{"type":"io.nats.jetstream.api.v1.account_info_response","memory":0,"storage":0,"streams":1,"consumers":1}`,
+		"$JS.API.CONSUMER.INFO.TCPDOG.servers": `{"type":"io.nats.jetstream.api.v1.consumer_info_response","stream_name":"TCPDOG","name":"servers",` +
+			`"config":{"durable_name":"servers","deliver_subject":"tcpdog.deliver","ack_policy":"explicit","filter_subject":"tcpdog"}}`,
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conns <- c
+
+			go func(c net.Conn) {
+				defer c.Close()
+
+				var muxSID string
+
+				fmt.Fprint(c, "INFO {\"server_id\":\"test\",\"version\":\"2.2.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(c)
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					args := strings.Fields(line)
+
+					switch {
+					case strings.HasPrefix(line, "PING"):
+						fmt.Fprint(c, "PONG\r\n")
+					case strings.HasPrefix(line, "SUB _INBOX."):
+						muxSID = args[len(args)-1]
+					case strings.HasPrefix(line, "PUB "):
+						// PUB <subject> [reply] <size>
+						size, _ := strconv.Atoi(args[len(args)-1])
+						data := make([]byte, size+2)
+						io.ReadFull(r, data)
+
+						if resp, ok := api[args[1]]; ok {
+							fmt.Fprintf(c, "MSG %s %s %d\r\n%s\r\n", args[2], muxSID, len(resp), resp)
+							continue
+						}
+
+						lines <- fmt.Sprintf("PUB %s %s", args[1], data[:size])
+					default:
+						lines <- strings.TrimSpace(line)
+					}
+				}
+			}(c)
+		}
+	}()
+
+	deliver := func(sid string, seq int, data []byte) {
+		c := <-conns
+		fmt.Fprintf(c, "MSG tcpdog.deliver %s $JS.ACK.TCPDOG.servers.1.%d.%d.1622316222000000000.0 %d\r\n%s\r\n", sid, seq, seq, len(data), data)
+		conns <- c
+	}
+
+	return "nats://" + l.Addr().String(), lines, deliver
+}
+
+func TestStartJetStream(t *testing.T) {
+	url, lines, deliver := jetStreamServer(t)
+
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"nats01": {
+				Type: "nats",
+				Config: map[string]interface{}{
+					"servers": []string{url},
+					"stream":  "TCPDOG",
+					"durable": "servers",
+				},
+			},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	defer cancel()
+
+	ch := make(chan interface{}, 1)
+	assert.NoError(t, Start(ctx, "nats01", "json", ch))
+
+	// the consumer has been attached to its deliver subject
+	sub := strings.Fields(expect(t, lines, "SUB tcpdog.deliver"))
+	assert.Len(t, sub, 3)
+
+	deliver(sub[2], 1, []byte(`{"RTT":5,"Hostname":"foo"}`))
+
+	select {
+	case i := <-ch:
+		assert.Equal(t, map[string]interface{}{"RTT": 5.0, "Hostname": "foo"}, i)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message has not been received")
+	}
+
+	// the message has been acked once it's been handed to the flow
+	assert.Equal(t, "PUB $JS.ACK.TCPDOG.servers.1.1.1.1622316222000000000.0 +ACK", expect(t, lines, "PUB $JS.ACK"))
+
+	// the malformed message isn't redelivered
+	deliver(sub[2], 2, []byte(`{"RTT":`))
+	assert.Equal(t, "PUB $JS.ACK.TCPDOG.servers.1.2.2.1622316222000000000.0 +TERM", expect(t, lines, "PUB $JS.ACK"))
+}
+
+func TestWorkerDrain(t *testing.T) {
+	s := &subscriber{name: "nats01", logger: zap.NewNop(), serialization: "json", unmarshal: helper.Unmarshaler("json", 0)}
+
+	ch := make(chan interface{}, 2)
+	mCh := make(chan *nats.Msg, 2)
+	closed := make(chan struct{})
+
+	// the drained messages are handed to the flow once the connection has been closed
+	mCh <- &nats.Msg{Data: []byte(`{"RTT":5}`)}
+	mCh <- &nats.Msg{Data: []byte(`{"RTT":6}`)}
+	close(closed)
+
+	s.worker(ch, mCh, closed)
+
+	assert.Len(t, ch, 2)
+	assert.Equal(t, map[string]interface{}{"RTT": 5.0}, <-ch)
+}
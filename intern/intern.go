@@ -0,0 +1,142 @@
+// Package intern shares the repeated string values of the records,
+// e.g. the hostnames, the comm names and the country codes. the pool
+// is bounded, once a shard is full an arbitrary entry is evicted thus
+// a cardinality explosion costs the hit rate but never the memory.
+package intern
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const shards = 16
+
+// Pool represents a bounded strings interning pool, it's safe
+// for the concurrent use.
+type Pool struct {
+	shards [shards]shard
+	max    int
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	saved     uint64
+}
+
+type shard struct {
+	sync.RWMutex
+	m map[string]string
+}
+
+// Stats represents the pool counters, the Saved is the
+// bytes which haven't been retained thanks to the hits.
+type Stats struct {
+	Entries   int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Saved     uint64
+}
+
+// HitRate returns the hits to the lookups ratio
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// New constructs a pool which holds up to maxEntries strings
+func New(maxEntries int) *Pool {
+	p := &Pool{max: maxEntries / shards}
+	if p.max < 1 {
+		p.max = 1
+	}
+
+	for i := range p.shards {
+		p.shards[i].m = make(map[string]string)
+	}
+
+	return p
+}
+
+// String returns the interned copy of s, the pool keeps its own
+// copy thus the caller's string backing memory is never retained.
+func (p *Pool) String(s string) string {
+	sh := &p.shards[xxhash.Sum64String(s)%shards]
+
+	sh.RLock()
+	v, ok := sh.m[s]
+	sh.RUnlock()
+
+	if ok {
+		atomic.AddUint64(&p.hits, 1)
+		atomic.AddUint64(&p.saved, uint64(len(s)))
+		return v
+	}
+
+	return p.add(sh, string(append([]byte(nil), s...)))
+}
+
+// add stores the string, it should be an owned copy
+func (p *Pool) add(sh *shard, v string) string {
+	atomic.AddUint64(&p.misses, 1)
+
+	sh.Lock()
+	defer sh.Unlock()
+
+	if e, ok := sh.m[v]; ok {
+		return e
+	}
+
+	if len(sh.m) >= p.max {
+		// the map iteration order is random
+		for k := range sh.m {
+			delete(sh.m, k)
+			atomic.AddUint64(&p.evictions, 1)
+			break
+		}
+	}
+
+	sh.m[v] = v
+
+	return v
+}
+
+// Bytes returns the interned string of b, it doesn't allocate on a hit
+func (p *Pool) Bytes(b []byte) string {
+	sh := &p.shards[xxhash.Sum64(b)%shards]
+
+	sh.RLock()
+	v, ok := sh.m[string(b)]
+	sh.RUnlock()
+
+	if ok {
+		atomic.AddUint64(&p.hits, 1)
+		atomic.AddUint64(&p.saved, uint64(len(b)))
+		return v
+	}
+
+	return p.add(sh, string(b))
+}
+
+// Stats returns the pool counters
+func (p *Pool) Stats() Stats {
+	s := Stats{
+		Hits:      atomic.LoadUint64(&p.hits),
+		Misses:    atomic.LoadUint64(&p.misses),
+		Evictions: atomic.LoadUint64(&p.evictions),
+		Saved:     atomic.LoadUint64(&p.saved),
+	}
+
+	for i := range p.shards {
+		p.shards[i].RLock()
+		s.Entries += len(p.shards[i].m)
+		p.shards[i].RUnlock()
+	}
+
+	return s
+}
@@ -0,0 +1,169 @@
+package intern
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func data(s string) uintptr {
+	return *(*uintptr)(unsafe.Pointer(&s))
+}
+
+func TestString(t *testing.T) {
+	p := New(100)
+
+	b := []byte("host01")
+	s1 := p.Bytes(b)
+	s2 := p.String(string(b))
+	assert.Equal(t, "host01", s1)
+	assert.Equal(t, data(s1), data(s2))
+
+	// the pool keeps its own copy
+	b[0] = 'X'
+	assert.Equal(t, "host01", p.String("host01"))
+
+	s := p.Stats()
+	assert.Equal(t, 1, s.Entries)
+	assert.Equal(t, uint64(2), s.Hits)
+	assert.Equal(t, uint64(1), s.Misses)
+	assert.Equal(t, uint64(12), s.Saved)
+	assert.InDelta(t, 0.66, s.HitRate(), 0.01)
+	assert.Equal(t, float64(0), Stats{}.HitRate())
+}
+
+func TestStringBound(t *testing.T) {
+	p := New(shards * 2)
+
+	for i := 0; i < 10000; i++ {
+		p.String(fmt.Sprintf("host%d", i))
+	}
+
+	s := p.Stats()
+	assert.LessOrEqual(t, s.Entries, shards*2)
+	assert.Equal(t, uint64(10000-s.Entries), s.Evictions)
+}
+
+func TestStringConcurrent(t *testing.T) {
+	p := New(1000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				v := fmt.Sprintf("host%d", j%50)
+				assert.Equal(t, v, p.String(v))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, p.Stats().Entries)
+}
+
+func TestRecord(t *testing.T) {
+	p := New(100)
+	fields := []string{"Hostname", "Task", "RTT"}
+
+	m1 := map[string]interface{}{"Hostname": "host01", "RTT": float64(5)}
+	m2 := map[string]interface{}{"Hostname": string([]byte("host01")), "RTT": float64(5)}
+	p.Record(m1, fields)
+	p.Record(m2, fields)
+	assert.Equal(t, data(m1["Hostname"].(string)), data(m2["Hostname"].(string)))
+	assert.Equal(t, float64(5), m2["RTT"])
+
+	s, _ := structpb.NewStruct(map[string]interface{}{"Task": string([]byte("curl"))})
+	spb := &pb.FieldsSPB{Fields: s}
+	p.Record(spb, fields)
+	assert.Equal(t, "curl", spb.Fields.Fields["Task"].GetStringValue())
+
+	task := string([]byte("curl"))
+	f := &pb.Fields{Task: &task}
+	p.Record(f, fields)
+	assert.Equal(t, "curl", f.GetTask())
+	assert.Equal(t, data(spb.Fields.Fields["Task"].GetStringValue()), data(f.GetTask()))
+	assert.Nil(t, f.Hostname)
+}
+
+// records returns the encoded records with a zipf distribution of
+// the hostnames and the comm names as the agents send them.
+func records(n int) [][]byte {
+	r := rand.New(rand.NewSource(1))
+	hosts := rand.NewZipf(r, 1.1, 1, 500)
+	tasks := rand.NewZipf(r, 1.3, 1, 200)
+
+	rs := make([][]byte, n)
+	for i := range rs {
+		rs[i] = []byte(fmt.Sprintf(`{"Hostname":"web-%04d.dc1.example.com","Task":"worker-%03d","RTT":%d}`,
+			hosts.Uint64(), tasks.Uint64(), r.Intn(1000)))
+	}
+
+	return rs
+}
+
+func benchmarkRecord(b *testing.B, p *Pool) {
+	var (
+		rs       = records(1000)
+		fields   = []string{"Hostname", "Task"}
+		retained = make([]map[string]interface{}, b.N)
+		before   runtime.MemStats
+		after    runtime.MemStats
+	)
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m := map[string]interface{}{}
+		json.Unmarshal(rs[i%len(rs)], &m)
+		if p != nil {
+			p.Record(m, fields)
+		}
+		retained[i] = m
+	}
+
+	b.StopTimer()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "retained-B/record")
+	runtime.KeepAlive(retained)
+}
+
+func BenchmarkRecordDisabled(b *testing.B) {
+	benchmarkRecord(b, nil)
+}
+
+func BenchmarkRecordInterned(b *testing.B) {
+	benchmarkRecord(b, New(100000))
+}
+
+func BenchmarkStringParallel(b *testing.B) {
+	p := New(100000)
+	rs := make([]string, 1000)
+	for i := range rs {
+		rs[i] = fmt.Sprintf("web-%04d.dc1.example.com", i%500)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			p.String(rs[i%len(rs)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package intern
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+var fieldsDesc = (&pb.Fields{}).ProtoReflect().Descriptor().Fields()
+
+// Record interns the string fields of the decoded
+// record (json, spb or pb) in place.
+func (p *Pool) Record(record interface{}, fields []string) {
+	switch r := record.(type) {
+	case map[string]interface{}:
+		for _, f := range fields {
+			if s, ok := r[f].(string); ok {
+				r[f] = p.String(s)
+			}
+		}
+	case *pb.FieldsSPB:
+		values := r.GetFields().GetFields()
+		for _, f := range fields {
+			if s, ok := values[f].GetKind().(*structpb.Value_StringValue); ok {
+				s.StringValue = p.String(s.StringValue)
+			}
+		}
+	case *pb.Fields:
+		m := r.ProtoReflect()
+		for _, f := range fields {
+			fd := fieldsDesc.ByName(protoreflect.Name(f))
+			if fd == nil || fd.Kind() != protoreflect.StringKind || !m.Has(fd) {
+				continue
+			}
+			m.Set(fd, protoreflect.ValueOfString(p.String(m.Get(fd).String())))
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+// the agent counters are atomic thus they're cheap on the hot path,
+// they're read once the metrics endpoint is scraped.
+var (
+	egressBytes  sync.Map
+	egressErrors sync.Map
+
+	// the throttle gauges of the tracepoints
+	throttleFactor sync.Map
+	throttlePaused sync.Map
+
+	sources = struct {
+		sync.RWMutex
+		events  func() map[string]uint64
+		backlog func() map[string]int
+		skipped func() int
+		delay   func() (bounds, counts []uint64, count, sum uint64)
+
+		// owners are the last registrations of the sources
+		owners map[string]*int
+	}{owners: map[string]*int{}}
+)
+
+var (
+	eventsDesc = prometheus.NewDesc("tcpdog_agent_events_total",
+		"The number of the events which the tracepoint has been received.", []string{"tracepoint"}, nil)
+	egressBytesDesc = prometheus.NewDesc("tcpdog_agent_egress_bytes_total",
+		"The bytes of the events which the egress has been sent.", []string{"egress"}, nil)
+	egressErrorsDesc = prometheus.NewDesc("tcpdog_agent_egress_errors_total",
+		"The number of the events which the egress has been failed to send.", []string{"egress"}, nil)
+	backlogDesc = prometheus.NewDesc("tcpdog_agent_egress_backlog",
+		"The number of the events which are waiting in the egress channel.", []string{"egress"}, nil)
+	skippedDesc = prometheus.NewDesc("tcpdog_agent_skipped_tracepoints",
+		"The number of the tracepoints which have been skipped and they're retried, the agent is degraded if it's not zero.", nil, nil)
+	delayDesc = prometheus.NewDesc("tcpdog_agent_delay_seconds",
+		"The time which the events have been waited in the perf buffers before the agent reads them.", nil, nil)
+	throttleFactorDesc = prometheus.NewDesc("tcpdog_agent_throttle_factor",
+		"The sampling divisor which the tracepoint has been throttled by the server hints, it's 1 if it's not throttled.", []string{"tracepoint"}, nil)
+	throttlePausedDesc = prometheus.NewDesc("tcpdog_agent_throttle_paused",
+		"It's 1 if the tracepoint events are dropped by the pause policy of the server hints.", []string{"tracepoint"}, nil)
+	dropsDesc = prometheus.NewDesc("tcpdog_drops_total",
+		"The number of the drops per category, e.g. the kernel lost samples.", []string{"category", "name"}, nil)
+)
+
+func init() {
+	registry.MustRegister(collector{})
+}
+
+// EgressBytes counts the bytes which the egress has been sent
+func EgressBytes(name string, n int) {
+	atomic.AddUint64(load(&egressBytes, name), uint64(n))
+}
+
+// EgressError counts an event which the egress has been failed to send
+func EgressError(name string) {
+	atomic.AddUint64(load(&egressErrors, name), 1)
+}
+
+// Throttle sets the throttle gauges of the tracepoint
+func Throttle(tracepoint string, factor uint32, paused bool) {
+	atomic.StoreUint64(load(&throttleFactor, tracepoint), uint64(factor))
+
+	var p uint64
+	if paused {
+		p = 1
+	}
+	atomic.StoreUint64(load(&throttlePaused, tracepoint), p)
+}
+
+// SetEvents sets the source of the events per tracepoint, it
+// returns the func which unsets it once the agent stops.
+func SetEvents(f func() map[string]uint64) func() {
+	return setSource("events", func(on bool) {
+		sources.events = nil
+		if on {
+			sources.events = f
+		}
+	})
+}
+
+// SetBacklog sets the source of the egress channels length
+func SetBacklog(f func() map[string]int) func() {
+	return setSource("backlog", func(on bool) {
+		sources.backlog = nil
+		if on {
+			sources.backlog = f
+		}
+	})
+}
+
+// SetSkipped sets the source of the skipped tracepoints number
+func SetSkipped(f func() int) func() {
+	return setSource("skipped", func(on bool) {
+		sources.skipped = nil
+		if on {
+			sources.skipped = f
+		}
+	})
+}
+
+// SetAgentDelay sets the source of the perf buffer dwell time
+// histogram, the bounds and the sum are in usecs and the last
+// count belongs to the values greater than the last bound.
+func SetAgentDelay(f func() (bounds, counts []uint64, count, sum uint64)) func() {
+	return setSource("delay", func(on bool) {
+		sources.delay = nil
+		if on {
+			sources.delay = f
+		}
+	})
+}
+
+// setSource sets the source and returns the func which unsets it
+// unless the source has been set again meanwhile, e.g. by the next
+// agent run of the process.
+func setSource(name string, apply func(on bool)) func() {
+	sources.Lock()
+	defer sources.Unlock()
+
+	owner := new(int)
+	sources.owners[name] = owner
+	apply(true)
+
+	return func() {
+		sources.Lock()
+		defer sources.Unlock()
+
+		if sources.owners[name] == owner {
+			delete(sources.owners, name)
+			apply(false)
+		}
+	}
+}
+
+func load(m *sync.Map, name string) *uint64 {
+	v, ok := m.Load(name)
+	if !ok {
+		v, _ = m.LoadOrStore(name, new(uint64))
+	}
+
+	return v.(*uint64)
+}
+
+// collector collects the atomic counters and the sources at the scrape
+type collector struct{}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventsDesc
+	ch <- egressBytesDesc
+	ch <- egressErrorsDesc
+	ch <- backlogDesc
+	ch <- skippedDesc
+	ch <- delayDesc
+	ch <- throttleFactorDesc
+	ch <- throttlePausedDesc
+	ch <- dropsDesc
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	values := func(desc *prometheus.Desc, typ prometheus.ValueType, m *sync.Map) {
+		m.Range(func(k, v interface{}) bool {
+			ch <- prometheus.MustNewConstMetric(desc, typ,
+				float64(atomic.LoadUint64(v.(*uint64))), k.(string))
+			return true
+		})
+	}
+
+	values(egressBytesDesc, prometheus.CounterValue, &egressBytes)
+	values(egressErrorsDesc, prometheus.CounterValue, &egressErrors)
+	values(throttleFactorDesc, prometheus.GaugeValue, &throttleFactor)
+	values(throttlePausedDesc, prometheus.GaugeValue, &throttlePaused)
+
+	sources.RLock()
+	events, backlog, skipped, delay := sources.events, sources.backlog, sources.skipped, sources.delay
+	sources.RUnlock()
+
+	if events != nil {
+		for name, n := range events() {
+			ch <- prometheus.MustNewConstMetric(eventsDesc, prometheus.CounterValue, float64(n), name)
+		}
+	}
+
+	if backlog != nil {
+		for name, n := range backlog() {
+			ch <- prometheus.MustNewConstMetric(backlogDesc, prometheus.GaugeValue, float64(n), name)
+		}
+	}
+
+	if skipped != nil {
+		ch <- prometheus.MustNewConstMetric(skippedDesc, prometheus.GaugeValue, float64(skipped()))
+	}
+
+	if delay != nil {
+		ch <- delayHistogram(delay())
+	}
+
+	for _, d := range drops.Snapshot().Drops {
+		ch <- prometheus.MustNewConstMetric(dropsDesc, prometheus.CounterValue, float64(d.Count), d.Category, d.Name)
+	}
+}
+
+// delayHistogram converts the usecs histogram to the cumulative
+// buckets in seconds, the last count is in the +Inf bucket.
+func delayHistogram(bounds, counts []uint64, count, sum uint64) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(bounds))
+
+	var cumulative uint64
+	for i, bound := range bounds {
+		cumulative += counts[i]
+		buckets[float64(bound)/1e6] = cumulative
+	}
+
+	return prometheus.MustNewConstHistogram(delayDesc, count, float64(sum)/1e6, buckets)
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+func TestAgentCollector(t *testing.T) {
+	EgressBytes("kafka01", 100)
+	EgressBytes("kafka01", 20)
+	EgressError("grpc01")
+	drops.Add(drops.KernelLost, "tcp:tcp_metrics", 3)
+
+	unsetEvents := SetEvents(func() map[string]uint64 { return map[string]uint64{"tcp:tcp_retransmit_skb": 7} })
+	defer SetBacklog(func() map[string]int { return map[string]int{"kafka01": 12} })()
+	defer SetSkipped(func() int { return 2 })()
+	defer SetAgentDelay(func() ([]uint64, []uint64, uint64, uint64) {
+		return []uint64{10, 500}, []uint64{3, 1, 2}, 6, 2500
+	})()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `tcpdog_agent_egress_bytes_total{egress="kafka01"} 120`)
+	assert.Contains(t, body, `tcpdog_agent_egress_errors_total{egress="grpc01"} 1`)
+	assert.Contains(t, body, `tcpdog_agent_events_total{tracepoint="tcp:tcp_retransmit_skb"} 7`)
+	assert.Contains(t, body, `tcpdog_agent_egress_backlog{egress="kafka01"} 12`)
+	assert.Contains(t, body, `tcpdog_agent_skipped_tracepoints 2`)
+	assert.Contains(t, body, `tcpdog_agent_delay_seconds_bucket{le="1e-05"} 3`)
+	assert.Contains(t, body, `tcpdog_agent_delay_seconds_bucket{le="0.0005"} 4`)
+	assert.Contains(t, body, `tcpdog_agent_delay_seconds_bucket{le="+Inf"} 6`)
+	assert.Contains(t, body, `tcpdog_agent_delay_seconds_sum 0.0025`)
+	assert.Contains(t, body, `tcpdog_drops_total{category="kernel_lost",name="tcp:tcp_metrics"} 3`)
+
+	// the source of a stopped agent isn't collected, the stale
+	// unset doesn't remove the source which has been set again.
+	unsetEvents()
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), "tcpdog_agent_events_total")
+
+	unsetSkipped := SetSkipped(func() int { return 1 })
+	defer SetSkipped(func() int { return 3 })()
+	unsetSkipped()
+
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `tcpdog_agent_skipped_tracepoints 3`)
+}
+
+func BenchmarkEgressBytes(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		EgressBytes("kafka01", 100)
+	}
+}
@@ -0,0 +1,337 @@
+// Package metrics keeps the prometheus metrics of the server and the
+// agent, e.g. the ingress messages per ingress, the ingestion batches
+// latency or the egress bytes. the metrics are registered to the package
+// registry and they're exposed by the metrics http endpoint if it's enabled.
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	ingressMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_ingress_messages_total",
+		Help: "The number of the messages which the ingress has been handed to its flow.",
+	}, []string{"flow", "ingress"})
+
+	unmarshalErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_unmarshal_errors_total",
+		Help: "The number of the messages which have been failed to unmarshal.",
+	}, []string{"flow", "serialization"})
+
+	geoLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_geo_lookups_total",
+		Help: "The number of the geo lookups per result: hit, miss or not_found.",
+	}, []string{"geo", "result"})
+
+	geoEpochLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_geo_epoch_lookups_total",
+		Help: "The number of the geo lookups which a database build epoch has served.",
+	}, []string{"geo", "database", "epoch"})
+
+	geoCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_geo_cache_lookups_total",
+		Help: "The number of the geo cache lookups per result: hit or miss.",
+	}, []string{"geo", "result"})
+
+	clusterForwards = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_cluster_forwards_total",
+		Help: "The number of the records which the ingress has been routed to the peer per result: forwarded or dropped.",
+	}, []string{"ingress", "peer", "result"})
+
+	clusterPeerUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tcpdog_cluster_peer_up",
+		Help: "The health of the cluster peer, it's 1 once the peer streams are up.",
+	}, []string{"ingress", "peer"})
+
+	smoothingRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_smoothing_records_total",
+		Help: "The number of the records which the ingress smoothing has been received per result: smoothed or passthrough.",
+	}, []string{"ingress", "result"})
+
+	smoothingReplaying = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tcpdog_smoothing_replaying",
+		Help: "The number of the streams which are replaying the agent buffer.",
+	}, []string{"ingress"})
+
+	smoothingPeers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tcpdog_smoothing_peers",
+		Help: "The number of the peers which have a token bucket.",
+	}, []string{"ingress"})
+
+	flowControlFactor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tcpdog_flow_control_factor",
+		Help: "The slow down factor which the ingress has been asked the agents, it's 1 once they're resumed.",
+	}, []string{"ingress"})
+
+	flowControlSubscribers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tcpdog_flow_control_subscribers",
+		Help: "The number of the agents which have been subscribed to the flow control hints.",
+	}, []string{"ingress"})
+
+	ingestionDocuments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_ingestion_documents_total",
+		Help: "The number of the records which the ingestion has been written.",
+	}, []string{"flow", "ingestion"})
+
+	ingestionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_ingestion_errors_total",
+		Help: "The number of the records which the ingestion has been failed to write.",
+	}, []string{"flow", "ingestion"})
+
+	ingestionRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_ingestion_retries_total",
+		Help: "The number of the batches which the ingestion has been retried.",
+	}, []string{"flow", "ingestion"})
+
+	watchdogStalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tcpdog_watchdog_stalls_total",
+		Help: "The number of the stalls which the watchdog has been detected per flow stage.",
+	}, []string{"stage"})
+
+	checkpointAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tcpdog_checkpoint_restored_age_seconds",
+		Help: "The age of the state which the checkpoint has been restored at the startup.",
+	}, []string{"name"})
+
+	ingestionBatch = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tcpdog_ingestion_batch_seconds",
+		Help:    "The latency of the ingestion batch writes.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"flow", "ingestion"})
+)
+
+// the geo lookup results
+const (
+	// GeoHit is a lookup which has been found
+	GeoHit = "hit"
+	// GeoMiss is a lookup which hasn't been done e.g. an invalid
+	// ip or a pending lookup of the http geo.
+	GeoMiss = "miss"
+	// GeoNotFound is an ip which doesn't exist in the geo database
+	GeoNotFound = "not_found"
+)
+
+// the cluster forward results
+const (
+	// ClusterForwarded is a record which has been queued for the peer
+	ClusterForwarded = "forwarded"
+	// ClusterDropped is a record which has been dropped since
+	// the queue of the peer is full e.g. the peer is down.
+	ClusterDropped = "dropped"
+)
+
+// the smoothing results
+const (
+	// SmoothingSmoothed is a record which has been delayed by the bucket
+	SmoothingSmoothed = "smoothed"
+	// SmoothingPassthrough is a record which hasn't been delayed
+	SmoothingPassthrough = "passthrough"
+)
+
+// flowKey is the context key of the flow label
+type flowKey struct{}
+
+// errorHooks are the ingestion errors hooks by the flow labels
+var errorHooks = struct {
+	sync.RWMutex
+	m map[string]*func(int)
+}{m: map[string]*func(int){}}
+
+func init() {
+	registry.MustRegister(ingressMessages, unmarshalErrors, geoLookups, geoEpochLookups, geoCacheLookups,
+		clusterForwards, clusterPeerUp, smoothingRecords, smoothingReplaying, smoothingPeers, flowControlFactor, flowControlSubscribers, ingestionDocuments, ingestionErrors, ingestionRetries, ingestionBatch, watchdogStalls, checkpointAge)
+}
+
+// WithFlow returns a copy of the context with the flow label, the
+// ingresses and the ingestions label their metrics by it.
+func WithFlow(ctx context.Context, flow string) context.Context {
+	return context.WithValue(ctx, flowKey{}, flow)
+}
+
+// Flow returns the flow label of the context
+func Flow(ctx context.Context) string {
+	flow, _ := ctx.Value(flowKey{}).(string)
+	return flow
+}
+
+// AddFlow exposes the ingress and the ingestion metrics of the
+// flow at zero thus they're scraped before the first record. it
+// returns the func which removes them once the server stops.
+func AddFlow(flow, ingress, ingestion string) func() {
+	ingressMessages.WithLabelValues(flow, ingress)
+	ingestionDocuments.WithLabelValues(flow, ingestion)
+	ingestionErrors.WithLabelValues(flow, ingestion)
+	ingestionRetries.WithLabelValues(flow, ingestion)
+	ingestionBatch.WithLabelValues(flow, ingestion)
+
+	return func() {
+		ingressMessages.DeleteLabelValues(flow, ingress)
+		ingestionDocuments.DeleteLabelValues(flow, ingestion)
+		ingestionErrors.DeleteLabelValues(flow, ingestion)
+		ingestionRetries.DeleteLabelValues(flow, ingestion)
+		ingestionBatch.DeleteLabelValues(flow, ingestion)
+	}
+}
+
+// IngressMessage counts a message of the ingress
+func IngressMessage(flow, name string) {
+	ingressMessages.WithLabelValues(flow, name).Inc()
+}
+
+// UnmarshalError counts an unmarshal error of the serialization
+func UnmarshalError(flow, serialization string) {
+	unmarshalErrors.WithLabelValues(flow, serialization).Inc()
+}
+
+// GeoLookup counts a geo lookup by its result
+func GeoLookup(geo, result string) {
+	geoLookups.WithLabelValues(geo, result).Inc()
+}
+
+// GeoEpochLookup returns the lookups counter of a geo database build
+// epoch, it's held by the database thus a lookup doesn't resolve it.
+func GeoEpochLookup(geo, database string, epoch uint) func() {
+	return geoEpochLookups.WithLabelValues(geo, database, strconv.FormatUint(uint64(epoch), 10)).Inc
+}
+
+// GeoCacheLookup returns the geo cache lookups counter of the result
+func GeoCacheLookup(geo, result string) func() {
+	return geoCacheLookups.WithLabelValues(geo, result).Inc
+}
+
+// ClusterForward counts a record which has been routed to the peer by its result
+func ClusterForward(ingress, peer, result string) {
+	clusterForwards.WithLabelValues(ingress, peer, result).Inc()
+}
+
+// ClusterPeerUp returns the health gauge of the cluster peer
+func ClusterPeerUp(ingress, peer string) func(float64) {
+	return clusterPeerUp.WithLabelValues(ingress, peer).Set
+}
+
+// SmoothingRecord returns the smoothing records counter of the result
+func SmoothingRecord(ingress, result string) func() {
+	return smoothingRecords.WithLabelValues(ingress, result).Inc
+}
+
+// SmoothingReplaying returns the replaying streams gauge of the ingress
+func SmoothingReplaying(ingress string) func(float64) {
+	return smoothingReplaying.WithLabelValues(ingress).Add
+}
+
+// SmoothingPeers returns the smoothing peers gauge of the ingress
+func SmoothingPeers(ingress string) func(float64) {
+	return smoothingPeers.WithLabelValues(ingress).Add
+}
+
+// FlowControlFactor returns the slow down factor gauge of the ingress
+func FlowControlFactor(ingress string) func(float64) {
+	return flowControlFactor.WithLabelValues(ingress).Set
+}
+
+// FlowControlSubscribers returns the flow control subscribers gauge of the ingress
+func FlowControlSubscribers(ingress string) func(float64) {
+	return flowControlSubscribers.WithLabelValues(ingress).Add
+}
+
+// IngestionDocuments counts the records which the ingestion has been written
+func IngestionDocuments(flow, name string, n int) {
+	ingestionDocuments.WithLabelValues(flow, name).Add(float64(n))
+}
+
+// IngestionError counts the records which the ingestion has been failed to write
+func IngestionError(flow, name string, n int) {
+	ingestionErrors.WithLabelValues(flow, name).Add(float64(n))
+
+	errorHooks.RLock()
+	hook := errorHooks.m[flow]
+	errorHooks.RUnlock()
+
+	if hook != nil {
+		(*hook)(n)
+	}
+}
+
+// OnIngestionError calls the hook on the ingestion errors of the
+// flow as well, e.g. the mirror counts its ingestion errors. it
+// returns the func which removes the hook unless it's been replaced.
+func OnIngestionError(flow string, hook func(n int)) func() {
+	h := &hook
+
+	errorHooks.Lock()
+	errorHooks.m[flow] = h
+	errorHooks.Unlock()
+
+	return func() {
+		errorHooks.Lock()
+		defer errorHooks.Unlock()
+
+		if errorHooks.m[flow] == h {
+			delete(errorHooks.m, flow)
+		}
+	}
+}
+
+// IngestionRetry counts a batch retry of the ingestion
+func IngestionRetry(flow, name string) {
+	ingestionRetries.WithLabelValues(flow, name).Inc()
+}
+
+// ObserveBatch observes the latency of an ingestion batch since the start
+func ObserveBatch(flow, name string, start time.Time) {
+	ingestionBatch.WithLabelValues(flow, name).Observe(time.Since(start).Seconds())
+}
+
+// WatchdogStall returns the stalls counter of the flow stage
+func WatchdogStall(stage string) func() {
+	return watchdogStalls.WithLabelValues(stage).Inc
+}
+
+// CheckpointAge returns the restored state age gauge of the checkpoint
+func CheckpointAge(name string) func(float64) {
+	return checkpointAge.WithLabelValues(name).Set
+}
+
+// Handler returns the metrics http handler
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Start serves the metrics on the address at /metrics until the
+// context is done, it returns the error if it can't listen.
+func Start(ctx context.Context, addr string, logger *zap.Logger) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Error("metrics", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestHandler(t *testing.T) {
+	IngressMessage("kafka01/clickhouse01", "kafka01")
+	IngressMessage("kafka01/clickhouse01", "kafka01")
+	UnmarshalError("kafka01/clickhouse01", "spb")
+	ObserveBatch("kafka01/clickhouse01", "clickhouse01", time.Now().Add(-time.Millisecond))
+	IngestionDocuments("kafka01/clickhouse01", "clickhouse01", 10)
+	IngestionError("kafka01/clickhouse01", "clickhouse01", 2)
+	IngestionRetry("kafka01/clickhouse01", "clickhouse01")
+	GeoLookup("maxmind", GeoHit)
+	GeoEpochLookup("maxmind", "GeoLite2-City", 1609263880)()
+	GeoCacheLookup("maxmind", GeoMiss)()
+	ClusterForward("grpc01", "10.0.0.2:8085", ClusterDropped)
+	ClusterPeerUp("grpc01", "10.0.0.2:8085")(1)
+	SmoothingRecord("grpc01", SmoothingSmoothed)()
+	SmoothingReplaying("grpc01")(1)
+	SmoothingPeers("grpc01")(2)
+	FlowControlFactor("grpc01")(4)
+	FlowControlSubscribers("grpc01")(3)
+	WatchdogStall("grpc01/es01")()
+	CheckpointAge("grpc01/dedup")(12.5)
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	body := string(b)
+	assert.Contains(t, body, `tcpdog_ingress_messages_total{flow="kafka01/clickhouse01",ingress="kafka01"} 2`)
+	assert.Contains(t, body, `tcpdog_unmarshal_errors_total{flow="kafka01/clickhouse01",serialization="spb"} 1`)
+	assert.Contains(t, body, `tcpdog_ingestion_batch_seconds_count{flow="kafka01/clickhouse01",ingestion="clickhouse01"} 1`)
+	assert.Contains(t, body, `tcpdog_ingestion_batch_seconds_bucket{flow="kafka01/clickhouse01",ingestion="clickhouse01",le="0.005"} 1`)
+	assert.Contains(t, body, `tcpdog_ingestion_documents_total{flow="kafka01/clickhouse01",ingestion="clickhouse01"} 10`)
+	assert.Contains(t, body, `tcpdog_ingestion_errors_total{flow="kafka01/clickhouse01",ingestion="clickhouse01"} 2`)
+	assert.Contains(t, body, `tcpdog_ingestion_retries_total{flow="kafka01/clickhouse01",ingestion="clickhouse01"} 1`)
+	assert.Contains(t, body, `tcpdog_geo_lookups_total{geo="maxmind",result="hit"} 1`)
+	assert.Contains(t, body, `tcpdog_geo_epoch_lookups_total{database="GeoLite2-City",epoch="1609263880",geo="maxmind"} 1`)
+	assert.Contains(t, body, `tcpdog_geo_cache_lookups_total{geo="maxmind",result="miss"} 1`)
+	assert.Contains(t, body, `tcpdog_cluster_forwards_total{ingress="grpc01",peer="10.0.0.2:8085",result="dropped"} 1`)
+	assert.Contains(t, body, `tcpdog_cluster_peer_up{ingress="grpc01",peer="10.0.0.2:8085"} 1`)
+	assert.Contains(t, body, `tcpdog_smoothing_records_total{ingress="grpc01",result="smoothed"} 1`)
+	assert.Contains(t, body, `tcpdog_smoothing_replaying{ingress="grpc01"} 1`)
+	assert.Contains(t, body, `tcpdog_smoothing_peers{ingress="grpc01"} 2`)
+	assert.Contains(t, body, `tcpdog_flow_control_factor{ingress="grpc01"} 4`)
+	assert.Contains(t, body, `tcpdog_flow_control_subscribers{ingress="grpc01"} 3`)
+	assert.Contains(t, body, `tcpdog_watchdog_stalls_total{stage="grpc01/es01"} 1`)
+	assert.Contains(t, body, `tcpdog_checkpoint_restored_age_seconds{name="grpc01/dedup"} 12.5`)
+}
+
+func TestAddFlow(t *testing.T) {
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+
+	remove := AddFlow("nats01/es01", "nats01", "es01")
+	assert.Contains(t, scrape(), `tcpdog_ingestion_documents_total{flow="nats01/es01",ingestion="es01"} 0`)
+
+	var n int
+	removeHook := OnIngestionError("nats01/es01", func(i int) { n += i })
+	IngestionError("nats01/es01", "es01", 2)
+	assert.Equal(t, 2, n)
+
+	// the stopped server flow isn't exposed and hooked anymore
+	remove()
+	removeHook()
+	IngestionError("nats01/es01", "es01", 2)
+	assert.Equal(t, 2, n)
+	assert.NotContains(t, scrape(), `tcpdog_ingestion_documents_total{flow="nats01/es01"`)
+}
+
+func TestFlow(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", Flow(ctx))
+
+	ctx = WithFlow(ctx, "grpc01/es01")
+	assert.Equal(t, "grpc01/es01", Flow(ctx))
+}
+
+func TestStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the address is in use
+	l := httptest.NewServer(http.NotFoundHandler())
+	defer l.Close()
+
+	err := Start(ctx, l.Listener.Addr().String(), zap.NewNop())
+	assert.Error(t, err)
+
+	err = Start(ctx, "127.0.0.1:0", zap.NewNop())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,192 @@
+// Package priority implements the high priority lane of a channel, the
+// control records (e.g. the synthetic records) bypass the data backlog
+// of the channel up to the reserved capacity of the lane and the normal
+// records keep the channel semantics. the producer sets the record class
+// and the lane is carried by the context of the producer and consumer.
+package priority
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+)
+
+// Class represents the priority class of a record
+type Class int
+
+const (
+	// Normal is the class of the data records
+	Normal Class = iota
+	// High is the class of the control records
+	High
+)
+
+// Reserved is the default capacity of a lane
+const Reserved = 100
+
+// Lane represents the high priority lane of a channel, the
+// methods of a nil lane use the channel only.
+type Lane struct {
+	ch chan interface{}
+
+	high     uint64
+	fallback uint64
+	normal   uint64
+}
+
+// Stats represents the records stats per class
+type Stats struct {
+	// High is the high records which have bypassed the backlog
+	High uint64
+	// Fallback is the high records which have been sent
+	// to the channel as the lane was full.
+	Fallback uint64
+	// Normal is the received normal records
+	Normal uint64
+}
+
+type ctxKey struct{}
+
+// NewLane constructs a new lane
+func NewLane(reserved int) *Lane {
+	if reserved < 1 {
+		reserved = Reserved
+	}
+
+	return &Lane{ch: make(chan interface{}, reserved)}
+}
+
+// WithLane returns a copy of the context with the lane
+func WithLane(ctx context.Context, l *Lane) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the lane of the context, it's nil if there isn't
+func FromContext(ctx context.Context) *Lane {
+	l, _ := ctx.Value(ctxKey{}).(*Lane)
+	return l
+}
+
+// C returns the lane channel, it's nil for a nil lane
+func (l *Lane) C() chan interface{} {
+	if l == nil {
+		return nil
+	}
+
+	return l.ch
+}
+
+// Stats returns the lane stats
+func (l *Lane) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+
+	return Stats{
+		High:     atomic.LoadUint64(&l.high),
+		Fallback: atomic.LoadUint64(&l.fallback),
+		Normal:   atomic.LoadUint64(&l.normal),
+	}
+}
+
+// trySend sends the high record to the lane if there's room
+func (l *Lane) trySend(r interface{}, c Class) bool {
+	if l == nil || c != High {
+		return false
+	}
+
+	select {
+	case l.ch <- r:
+		atomic.AddUint64(&l.high, 1)
+		return true
+	default:
+		atomic.AddUint64(&l.fallback, 1)
+		return false
+	}
+}
+
+// Send sends the record, a high record goes to the lane if there's
+// room otherwise it waits in the channel like a normal record.
+func (l *Lane) Send(ctx context.Context, ch chan interface{}, r interface{}, c Class) bool {
+	if l.trySend(r, c) {
+		return true
+	}
+
+	select {
+	case ch <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Recv receives the next record, the lane records first
+func (l *Lane) Recv(ctx context.Context, ch chan interface{}) (interface{}, bool) {
+	if l == nil {
+		select {
+		case r := <-ch:
+			return r, true
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+
+	select {
+	case r := <-l.ch:
+		return r, true
+	default:
+	}
+
+	select {
+	case r := <-l.ch:
+		return r, true
+	case r := <-ch:
+		atomic.AddUint64(&l.normal, 1)
+		return r, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// SendBuffer sends the encoded event like Send
+func (l *Lane) SendBuffer(ctx context.Context, ch chan *bytes.Buffer, buf *bytes.Buffer, c Class) bool {
+	if l.trySend(buf, c) {
+		return true
+	}
+
+	select {
+	case ch <- buf:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// RecvBuffer receives the next encoded event like Recv, it returns
+// the class of the event thus the egress keeps its priority.
+func (l *Lane) RecvBuffer(ctx context.Context, ch chan *bytes.Buffer) (*bytes.Buffer, Class, bool) {
+	if l == nil {
+		select {
+		case buf := <-ch:
+			return buf, Normal, true
+		case <-ctx.Done():
+			return nil, Normal, false
+		}
+	}
+
+	select {
+	case r := <-l.ch:
+		return r.(*bytes.Buffer), High, true
+	default:
+	}
+
+	select {
+	case r := <-l.ch:
+		return r.(*bytes.Buffer), High, true
+	case buf := <-ch:
+		atomic.AddUint64(&l.normal, 1)
+		return buf, Normal, true
+	case <-ctx.Done():
+		return nil, Normal, false
+	}
+}
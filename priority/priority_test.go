@@ -0,0 +1,94 @@
+package priority
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaneSaturated(t *testing.T) {
+	ctx := context.Background()
+	ch := make(chan interface{}, 10)
+	l := NewLane(2)
+
+	// the channel is full and there isn't any consumer
+	for i := 0; i < cap(ch); i++ {
+		assert.True(t, l.Send(ctx, ch, i, Normal))
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- l.Send(ctx, ch, "heartbeat", High)
+	}()
+
+	select {
+	case ok := <-done:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("high record has been blocked")
+	}
+
+	r, ok := l.Recv(ctx, ch)
+	assert.True(t, ok)
+	assert.Equal(t, "heartbeat", r)
+
+	// the normal records are in order
+	for i := 0; i < cap(ch); i++ {
+		r, ok := l.Recv(ctx, ch)
+		assert.True(t, ok)
+		assert.Equal(t, i, r)
+	}
+
+	assert.Equal(t, Stats{High: 1, Normal: 10}, l.Stats())
+}
+
+func TestLaneFallback(t *testing.T) {
+	ctx := context.Background()
+	ch := make(chan *bytes.Buffer, 10)
+	l := NewLane(1)
+
+	b1, b2 := bytes.NewBufferString("1"), bytes.NewBufferString("2")
+	assert.True(t, l.SendBuffer(ctx, ch, b1, High))
+	assert.True(t, l.SendBuffer(ctx, ch, b2, High))
+	assert.Len(t, ch, 1)
+
+	buf, c, ok := l.RecvBuffer(ctx, ch)
+	assert.True(t, ok)
+	assert.Equal(t, High, c)
+	assert.Equal(t, b1, buf)
+
+	buf, c, ok = l.RecvBuffer(ctx, ch)
+	assert.True(t, ok)
+	assert.Equal(t, Normal, c)
+	assert.Equal(t, b2, buf)
+
+	assert.Equal(t, Stats{High: 1, Fallback: 1, Normal: 1}, l.Stats())
+}
+
+func TestLaneNil(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan interface{}, 1)
+
+	l := FromContext(ctx)
+	assert.Nil(t, l)
+	assert.Nil(t, l.C())
+
+	assert.True(t, l.Send(ctx, ch, "a", High))
+	assert.Len(t, ch, 1)
+
+	r, ok := l.Recv(ctx, ch)
+	assert.True(t, ok)
+	assert.Equal(t, "a", r)
+
+	cancel()
+	_, ok = l.Recv(ctx, ch)
+	assert.False(t, ok)
+	assert.False(t, l.Send(ctx, make(chan interface{}), "b", High))
+
+	l = NewLane(0)
+	assert.Equal(t, l, FromContext(WithLane(context.Background(), l)))
+	assert.Equal(t, Reserved, cap(l.C()))
+}
@@ -0,0 +1,223 @@
+package anomaly
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/checkpoint"
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/priority"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+// RecordType is the type of the synthetic anomaly records
+const RecordType = "tcpdog.anomaly"
+
+// detector keeps the rolling statistics of a field rate per key
+// and reports the windows which deviate from the baseline.
+type detector struct {
+	cfg           *anomalyConfig
+	serialization string
+
+	ll   *list.List
+	keys map[string]*list.Element
+}
+
+type stats struct {
+	key      string
+	values   map[string]interface{}
+	sum      float64
+	mean     float64
+	variance float64
+	windows  int
+}
+
+// Start starts the anomaly processor, the records pass through
+// from the in channel to the out channel and the anomaly records
+// are added to the out channel at the end of each window.
+func Start(ctx context.Context, name string, ser string, in, out chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+	logger := cfg.Logger()
+
+	aCfg, err := anomalyConf(cfg.Processor[name].Config)
+	if err != nil {
+		return err
+	}
+
+	if ser != "json" && ser != "spb" && ser != "msgpack" && ser != "cbor" {
+		return fmt.Errorf("anomaly processor doesn't support %s serialization", ser)
+	}
+
+	d := newDetector(aCfg, ser)
+	lane := priority.FromContext(ctx)
+	cp := checkpoint.FromContext(ctx)
+
+	cp.Restore(ctx, d)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(aCfg.Window) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case r := <-in:
+				d.add(r)
+
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					cp.Flush(d)
+					return
+				}
+			case now := <-ticker.C:
+				records := d.evaluate(now)
+				if len(records) > 0 {
+					logger.Info("anomaly", zap.String("name", name), zap.Int("records", len(records)))
+				}
+
+				// the anomaly records aren't held behind the data records
+				for _, r := range records {
+					if !lane.Send(ctx, out, r, priority.High) {
+						cp.Flush(d)
+						return
+					}
+				}
+			case <-cp.C():
+				cp.Save(d)
+			case <-ctx.Done():
+				cp.Flush(d)
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func newDetector(cfg *anomalyConfig, ser string) *detector {
+	return &detector{
+		cfg:           cfg,
+		serialization: ser,
+		ll:            list.New(),
+		keys:          map[string]*list.Element{},
+	}
+}
+
+// add accumulates the field value of a record to its key
+func (d *detector) add(r interface{}) {
+	var (
+		fields map[string]interface{}
+		parts  = make([]string, len(d.cfg.Keys))
+	)
+
+	switch v := r.(type) {
+	case map[string]interface{}:
+		fields = v
+	case *pb.FieldsSPB:
+		fields = v.GetFields().AsMap()
+	default:
+		return
+	}
+
+	value, ok := serialization.Number(fields[d.cfg.Field])
+	if !ok || fields["Type"] == RecordType {
+		return
+	}
+
+	for i, k := range d.cfg.Keys {
+		parts[i] = fmt.Sprintf("%s=%v", k, fields[k])
+	}
+
+	key := strings.Join(parts, ",")
+
+	if e, ok := d.keys[key]; ok {
+		d.ll.MoveToFront(e)
+		e.Value.(*stats).sum += value
+		return
+	}
+
+	s := &stats{key: key, values: map[string]interface{}{}, sum: value}
+	for _, k := range d.cfg.Keys {
+		if v, ok := fields[k]; ok {
+			s.values[k] = v
+		}
+	}
+
+	d.keys[key] = d.ll.PushFront(s)
+
+	if d.ll.Len() > d.cfg.MaxKeys {
+		e := d.ll.Back()
+		d.ll.Remove(e)
+		delete(d.keys, e.Value.(*stats).key)
+	}
+}
+
+// evaluate closes the current window, it returns the anomaly records
+// and updates the EWMA mean and variance of all keys.
+func (d *detector) evaluate(now time.Time) []interface{} {
+	var records []interface{}
+
+	for e := d.ll.Front(); e != nil; e = e.Next() {
+		s := e.Value.(*stats)
+		observed := s.sum / float64(d.cfg.Window)
+		stdDev := math.Sqrt(s.variance)
+
+		if s.windows >= d.cfg.Warmup {
+			dev := math.Abs(observed - s.mean)
+			if dev > 0 && dev > d.cfg.Sigma*stdDev {
+				if r := d.record(s, observed, stdDev, now); r != nil {
+					records = append(records, r)
+				}
+			}
+		}
+
+		diff := observed - s.mean
+		if s.windows == 0 {
+			s.mean = observed
+		} else {
+			incr := d.cfg.Alpha * diff
+			s.mean += incr
+			s.variance = (1 - d.cfg.Alpha) * (s.variance + diff*incr)
+		}
+
+		s.sum = 0
+		s.windows++
+	}
+
+	return records
+}
+
+func (d *detector) record(s *stats, observed, stdDev float64, now time.Time) interface{} {
+	r := map[string]interface{}{
+		"Type":      RecordType,
+		"Field":     d.cfg.Field,
+		"Key":       s.key,
+		"Baseline":  s.mean,
+		"StdDev":    stdDev,
+		"Observed":  observed,
+		"Timestamp": float64(now.Unix()),
+	}
+
+	for k, v := range s.values {
+		r[k] = v
+	}
+
+	if d.serialization == "spb" {
+		f, err := structpb.NewStruct(r)
+		if err != nil {
+			return nil
+		}
+
+		return &pb.FieldsSPB{Fields: f}
+	}
+
+	return r
+}
@@ -0,0 +1,247 @@
+package anomaly
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/checkpoint"
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func record(daddr string, retrans float64) map[string]interface{} {
+	return map[string]interface{}{"DAddr": daddr, "TotalRetrans": retrans, "Hostname": "foo"}
+}
+
+func TestDetector(t *testing.T) {
+	cfg, err := anomalyConf(map[string]interface{}{"window": 1, "warmup": 3})
+	assert.NoError(t, err)
+
+	d := newDetector(cfg, "json")
+	now := time.Now()
+
+	// spike during the cold start
+	for _, v := range []float64{10, 100, 10} {
+		d.add(record("10.0.0.2", v))
+		assert.Len(t, d.evaluate(now), 0)
+	}
+
+	d = newDetector(cfg, "json")
+
+	for _, v := range []float64{10, 12, 11, 10, 12, 11, 10, 11} {
+		d.add(record("10.0.0.1", v))
+		assert.Len(t, d.evaluate(now), 0)
+	}
+
+	d.add(record("10.0.0.1", 60))
+	d.add(record("10.0.0.1", 40))
+	records := d.evaluate(now)
+	assert.Len(t, records, 1)
+
+	r := records[0].(map[string]interface{})
+	assert.Equal(t, RecordType, r["Type"])
+	assert.Equal(t, "TotalRetrans", r["Field"])
+	assert.Equal(t, "DAddr=10.0.0.1", r["Key"])
+	assert.Equal(t, "10.0.0.1", r["DAddr"])
+	assert.Equal(t, float64(100), r["Observed"])
+	assert.InDelta(t, 11, r["Baseline"], 5)
+	assert.Equal(t, float64(now.Unix()), r["Timestamp"])
+
+	// synthetic records don't feed back
+	d.add(r)
+	assert.Equal(t, float64(0), d.keys["DAddr=10.0.0.1"].Value.(*stats).sum)
+}
+
+func TestDetectorLRU(t *testing.T) {
+	cfg, err := anomalyConf(map[string]interface{}{"maxkeys": 2})
+	assert.NoError(t, err)
+
+	d := newDetector(cfg, "json")
+
+	d.add(record("10.0.0.1", 1))
+	d.add(record("10.0.0.2", 1))
+	d.add(record("10.0.0.1", 1))
+	d.add(record("10.0.0.3", 1))
+
+	assert.Equal(t, 2, d.ll.Len())
+	assert.Contains(t, d.keys, "DAddr=10.0.0.1")
+	assert.Contains(t, d.keys, "DAddr=10.0.0.3")
+	assert.NotContains(t, d.keys, "DAddr=10.0.0.2")
+}
+
+func TestDetectorSPB(t *testing.T) {
+	cfg, err := anomalyConf(map[string]interface{}{"window": 1, "warmup": 1, "keys": []string{"DAddr", "DPort"}})
+	assert.NoError(t, err)
+
+	d := newDetector(cfg, "spb")
+
+	add := func(v float64) {
+		s, err := structpb.NewStruct(map[string]interface{}{"DAddr": "10.0.0.1", "DPort": 443, "TotalRetrans": v})
+		assert.NoError(t, err)
+		d.add(&pb.FieldsSPB{Fields: s})
+	}
+
+	add(0)
+	assert.Len(t, d.evaluate(time.Now()), 0)
+
+	add(5)
+	records := d.evaluate(time.Now())
+	assert.Len(t, records, 1)
+
+	f := records[0].(*pb.FieldsSPB).GetFields().GetFields()
+	assert.Equal(t, "DAddr=10.0.0.1,DPort=443", f["Key"].GetStringValue())
+	assert.Equal(t, float64(443), f["DPort"].GetNumberValue())
+	assert.Equal(t, float64(5), f["Observed"].GetNumberValue())
+	assert.Equal(t, float64(0), f["Baseline"].GetNumberValue())
+}
+
+func TestStart(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Processor: map[string]config.Processor{
+			"anomaly01": {Type: "anomaly", Config: map[string]interface{}{"window": 1, "warmup": 0}},
+		},
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = cfg.WithContext(ctx)
+
+	in := make(chan interface{}, 1)
+	out := make(chan interface{}, 1)
+
+	err := Start(ctx, "anomaly01", "pb", in, out)
+	assert.Error(t, err)
+
+	err = Start(ctx, "anomaly01", "json", in, out)
+	assert.NoError(t, err)
+
+	in <- record("10.0.0.1", 5)
+	assert.Equal(t, record("10.0.0.1", 5), <-out)
+
+	select {
+	case r := <-out:
+		assert.Equal(t, RecordType, r.(map[string]interface{})["Type"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("anomaly record didn't emit")
+	}
+}
+
+func TestAnomalyConf(t *testing.T) {
+	_, err := anomalyConf(map[string]interface{}{"alpha": 2})
+	assert.Error(t, err)
+
+	_, err = anomalyConf(map[string]interface{}{"keys": []string{}})
+	assert.Error(t, err)
+
+	cfg, err := anomalyConf(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"DAddr"}, cfg.Keys)
+}
+
+func TestDetectorRestart(t *testing.T) {
+	cfg, err := anomalyConf(map[string]interface{}{"window": 1, "warmup": 3})
+	assert.NoError(t, err)
+
+	windows := [][]float64{{5, 6}, {6, 5}, {5, 5}, {6, 6}, {5, 6}, {60, 40}}
+	now := time.Now()
+
+	run := func(restart int) []interface{} {
+		var records []interface{}
+
+		d := newDetector(cfg, "json")
+		for i, w := range windows {
+			d.add(record("10.0.0.1", w[0]))
+			d.add(record("10.0.0.2", w[0]/2))
+
+			// killed and restarted in the middle of the window
+			if i == restart {
+				b, err := d.Snapshot()
+				assert.NoError(t, err)
+
+				d = newDetector(cfg, "json")
+				assert.NoError(t, d.Restore(b))
+			}
+
+			d.add(record("10.0.0.1", w[1]))
+			records = append(records, d.evaluate(now)...)
+		}
+
+		return records
+	}
+
+	expected := run(-1)
+	assert.Len(t, expected, 2)
+
+	for i := range windows {
+		records := run(i)
+		assert.Len(t, records, len(expected))
+
+		for j := range records {
+			e, r := expected[j].(map[string]interface{}), records[j].(map[string]interface{})
+			for k, v := range e {
+				if f, ok := v.(float64); ok {
+					assert.InDelta(t, f, r[k], 1e-9, k)
+				} else {
+					assert.Equal(t, v, r[k], k)
+				}
+			}
+		}
+	}
+
+	// a different config
+	d := newDetector(cfg, "json")
+	b, _ := d.Snapshot()
+	cfg2, _ := anomalyConf(map[string]interface{}{"keys": []string{"DAddr", "DPort"}})
+	assert.Error(t, newDetector(cfg2, "json").Restore(b))
+	assert.Error(t, d.Restore([]byte("{")))
+}
+
+func TestStartCheckpoint(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Processor: map[string]config.Processor{
+			"anomaly01": {Type: "anomaly", Config: map[string]interface{}{"window": 10}},
+		},
+		Standby: config.Standby{Dir: t.TempDir()},
+	}
+	cfg.SetDefault()
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+	cp := checkpoint.New(ctx, "grpc/anomaly01", cfg.Standby, nil, cfg.Logger())
+
+	in := make(chan interface{}, 1)
+	out := make(chan interface{}, 10)
+
+	err := Start(checkpoint.WithContext(ctx, cp), "anomaly01", "json", in, out)
+	assert.NoError(t, err)
+
+	in <- record("10.0.0.1", 5)
+	<-out
+
+	// the last checkpoint is written once it's stopped
+	cancel()
+
+	path := filepath.Join(cfg.Standby.Dir, "grpc%2Fanomaly01.state")
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	aCfg, _ := anomalyConf(map[string]interface{}{"window": 10})
+	d := newDetector(aCfg, "json")
+	checkpoint.New(ctx, "grpc/anomaly01", cfg.Standby, nil, cfg.Logger()).Restore(ctx, d)
+
+	assert.Contains(t, d.keys, "DAddr=10.0.0.1")
+	assert.Equal(t, float64(5), d.keys["DAddr=10.0.0.1"].Value.(*stats).sum)
+}
@@ -0,0 +1,48 @@
+package anomaly
+
+import (
+	"errors"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+type anomalyConfig struct {
+	Field   string   // numeric field which its rate is tracked
+	Keys    []string // fields which make the key e.g. DAddr
+	Window  int      // window in seconds
+	Alpha   float64  // EWMA smoothing factor
+	Sigma   float64  // deviation threshold in standard deviations
+	Warmup  int      // number of windows before any alert per key
+	MaxKeys int      // maximum number of tracked keys (LRU)
+}
+
+func anomalyConf(cfg map[string]interface{}) (*anomalyConfig, error) {
+	// default configuration
+	conf := &anomalyConfig{
+		Field:   "TotalRetrans",
+		Keys:    []string{"DAddr"},
+		Window:  10,
+		Alpha:   0.3,
+		Sigma:   3,
+		Warmup:  5,
+		MaxKeys: 10000,
+	}
+
+	if err := config.Transform(cfg, conf); err != nil {
+		return nil, err
+	}
+
+	if len(conf.Keys) < 1 {
+		return nil, errors.New("anomaly keys are not defined")
+	}
+
+	if conf.Window < 1 || conf.MaxKeys < 1 || conf.Sigma <= 0 {
+		return nil, errors.New("invalid anomaly window, maxkeys or sigma")
+	}
+
+	if conf.Alpha <= 0 || conf.Alpha > 1 {
+		return nil, errors.New("anomaly alpha should be in (0, 1]")
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,91 @@
+package anomaly
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// state is the serialized detector, the keys stats are in the
+// LRU order (the most recently used first) and the current
+// window sums are kept.
+type state struct {
+	Field string
+	Keys  []string
+	Stats []keyState
+}
+
+type keyState struct {
+	Key      string
+	Values   map[string]interface{}
+	Sum      float64
+	Mean     float64
+	Variance float64
+	Windows  int
+}
+
+// Snapshot serializes the detector state
+func (d *detector) Snapshot() ([]byte, error) {
+	s := state{
+		Field: d.cfg.Field,
+		Keys:  d.cfg.Keys,
+		Stats: make([]keyState, 0, d.ll.Len()),
+	}
+
+	for e := d.ll.Front(); e != nil; e = e.Next() {
+		st := e.Value.(*stats)
+		s.Stats = append(s.Stats, keyState{
+			Key:      st.key,
+			Values:   st.values,
+			Sum:      st.sum,
+			Mean:     st.mean,
+			Variance: st.variance,
+			Windows:  st.windows,
+		})
+	}
+
+	return json.Marshal(s)
+}
+
+// Restore replaces the detector state, the state of a different
+// field or keys is rejected.
+func (d *detector) Restore(b []byte) error {
+	var s state
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	if s.Field != d.cfg.Field || !reflect.DeepEqual(s.Keys, d.cfg.Keys) {
+		return errors.New("anomaly state field or keys have been changed")
+	}
+
+	d.ll = list.New()
+	d.keys = map[string]*list.Element{}
+
+	for _, ks := range s.Stats {
+		if d.ll.Len() >= d.cfg.MaxKeys {
+			break
+		}
+
+		if _, ok := d.keys[ks.Key]; ok {
+			continue
+		}
+
+		if ks.Values == nil {
+			ks.Values = map[string]interface{}{}
+		}
+
+		d.keys[ks.Key] = d.ll.PushBack(&stats{
+			key:      ks.Key,
+			values:   ks.Values,
+			sum:      ks.Sum,
+			mean:     ks.Mean,
+			variance: ks.Variance,
+			windows:  ks.Windows,
+		})
+	}
+
+	return nil
+}
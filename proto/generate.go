@@ -0,0 +1,3 @@
+package tcpdog
+
+//go:generate go run ./vtgen -out tcpdog_vt.pb.go
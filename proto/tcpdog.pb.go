@@ -30,6 +30,52 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+type FlowControlAction int32
+
+const (
+	FlowControlAction_RESUME    FlowControlAction = 0
+	FlowControlAction_SLOW_DOWN FlowControlAction = 1
+)
+
+// Enum value maps for FlowControlAction.
+var (
+	FlowControlAction_name = map[int32]string{
+		0: "RESUME",
+		1: "SLOW_DOWN",
+	}
+	FlowControlAction_value = map[string]int32{
+		"RESUME":    0,
+		"SLOW_DOWN": 1,
+	}
+)
+
+func (x FlowControlAction) Enum() *FlowControlAction {
+	p := new(FlowControlAction)
+	*p = x
+	return p
+}
+
+func (x FlowControlAction) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FlowControlAction) Descriptor() protoreflect.EnumDescriptor {
+	return file_tcpdog_proto_enumTypes[0].Descriptor()
+}
+
+func (FlowControlAction) Type() protoreflect.EnumType {
+	return &file_tcpdog_proto_enumTypes[0]
+}
+
+func (x FlowControlAction) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FlowControlAction.Descriptor instead.
+func (FlowControlAction) EnumDescriptor() ([]byte, []int) {
+	return file_tcpdog_proto_rawDescGZIP(), []int{0}
+}
+
 type FieldsSPB struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -82,67 +128,93 @@ type Fields struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Task          *string `protobuf:"bytes,1,opt,name=Task,proto3,oneof" json:"Task,omitempty"`
-	PID           *uint32 `protobuf:"varint,2,opt,name=PID,proto3,oneof" json:"PID,omitempty"`
-	TCPHeaderLen  *uint32 `protobuf:"varint,3,opt,name=TCPHeaderLen,proto3,oneof" json:"TCPHeaderLen,omitempty"`
-	TotalRetrans  *uint32 `protobuf:"varint,4,opt,name=TotalRetrans,proto3,oneof" json:"TotalRetrans,omitempty"`
-	SAddr         *string `protobuf:"bytes,5,opt,name=SAddr,proto3,oneof" json:"SAddr,omitempty"`
-	DAddr         *string `protobuf:"bytes,6,opt,name=DAddr,proto3,oneof" json:"DAddr,omitempty"`
-	DPort         *uint32 `protobuf:"varint,7,opt,name=DPort,proto3,oneof" json:"DPort,omitempty"`
-	LPort         *uint32 `protobuf:"varint,8,opt,name=LPort,proto3,oneof" json:"LPort,omitempty"`
-	BytesReceived *uint64 `protobuf:"varint,9,opt,name=BytesReceived,proto3,oneof" json:"BytesReceived,omitempty"`
-	BytesSent     *uint64 `protobuf:"varint,10,opt,name=BytesSent,proto3,oneof" json:"BytesSent,omitempty"`
-	BytesAcked    *uint64 `protobuf:"varint,11,opt,name=BytesAcked,proto3,oneof" json:"BytesAcked,omitempty"`
-	NumSAcks      *uint32 `protobuf:"varint,12,opt,name=NumSAcks,proto3,oneof" json:"NumSAcks,omitempty"`
-	UserMSS       *uint32 `protobuf:"varint,13,opt,name=UserMSS,proto3,oneof" json:"UserMSS,omitempty"`
-	MSSClamp      *uint32 `protobuf:"varint,14,opt,name=MSSClamp,proto3,oneof" json:"MSSClamp,omitempty"`
-	AdvMSS        *uint32 `protobuf:"varint,15,opt,name=AdvMSS,proto3,oneof" json:"AdvMSS,omitempty"`
-	RTT           *uint32 `protobuf:"varint,16,opt,name=RTT,proto3,oneof" json:"RTT,omitempty"`
-	SRTT          *uint32 `protobuf:"varint,17,opt,name=SRTT,proto3,oneof" json:"SRTT,omitempty"`
-	RTTVar        *uint32 `protobuf:"varint,18,opt,name=RTTVar,proto3,oneof" json:"RTTVar,omitempty"`
-	RcvRTT        *uint32 `protobuf:"varint,19,opt,name=RcvRTT,proto3,oneof" json:"RcvRTT,omitempty"`
-	RACKRTT       *uint32 `protobuf:"varint,20,opt,name=RACKRTT,proto3,oneof" json:"RACKRTT,omitempty"`
-	MDev          *uint32 `protobuf:"varint,21,opt,name=MDev,proto3,oneof" json:"MDev,omitempty"`
-	MDevMax       *uint32 `protobuf:"varint,22,opt,name=MDevMax,proto3,oneof" json:"MDevMax,omitempty"`
-	SegsIn        *uint32 `protobuf:"varint,23,opt,name=SegsIn,proto3,oneof" json:"SegsIn,omitempty"`
-	SegsOut       *uint32 `protobuf:"varint,24,opt,name=SegsOut,proto3,oneof" json:"SegsOut,omitempty"`
-	GSOSegs       *uint32 `protobuf:"varint,25,opt,name=GSOSegs,proto3,oneof" json:"GSOSegs,omitempty"`
-	DataSegsIn    *uint32 `protobuf:"varint,26,opt,name=DataSegsIn,proto3,oneof" json:"DataSegsIn,omitempty"`
-	MaxWindow     *uint32 `protobuf:"varint,27,opt,name=MaxWindow,proto3,oneof" json:"MaxWindow,omitempty"`
-	SndWnd        *uint32 `protobuf:"varint,28,opt,name=SndWnd,proto3,oneof" json:"SndWnd,omitempty"`
-	WindowClamp   *uint32 `protobuf:"varint,29,opt,name=WindowClamp,proto3,oneof" json:"WindowClamp,omitempty"`
-	RcvSSThresh   *uint32 `protobuf:"varint,30,opt,name=RcvSSThresh,proto3,oneof" json:"RcvSSThresh,omitempty"`
-	ECNFlags      *uint32 `protobuf:"varint,31,opt,name=ECNFlags,proto3,oneof" json:"ECNFlags,omitempty"`
-	SndCwnd       *uint32 `protobuf:"varint,32,opt,name=SndCwnd,proto3,oneof" json:"SndCwnd,omitempty"`
-	PrrOut        *uint32 `protobuf:"varint,33,opt,name=PrrOut,proto3,oneof" json:"PrrOut,omitempty"`
-	Delivered     *uint32 `protobuf:"varint,34,opt,name=Delivered,proto3,oneof" json:"Delivered,omitempty"`
-	DeliveredCe   *uint32 `protobuf:"varint,35,opt,name=DeliveredCe,proto3,oneof" json:"DeliveredCe,omitempty"`
-	Lost          *uint32 `protobuf:"varint,36,opt,name=Lost,proto3,oneof" json:"Lost,omitempty"`
-	LostOut       *uint32 `protobuf:"varint,37,opt,name=LostOut,proto3,oneof" json:"LostOut,omitempty"`
-	PriorSSThresh *uint32 `protobuf:"varint,38,opt,name=PriorSSThresh,proto3,oneof" json:"PriorSSThresh,omitempty"`
-	DataSegsOut   *uint32 `protobuf:"varint,39,opt,name=DataSegsOut,proto3,oneof" json:"DataSegsOut,omitempty"`
-	RcvSpace      *uint32 `protobuf:"varint,40,opt,name=RcvSpace,proto3,oneof" json:"RcvSpace,omitempty"`
-	UnAcked       *uint32 `protobuf:"varint,41,opt,name=UnAcked,proto3,oneof" json:"UnAcked,omitempty"`
-	SAcked        *uint32 `protobuf:"varint,42,opt,name=SAcked,proto3,oneof" json:"SAcked,omitempty"`
-	RTO           *uint32 `protobuf:"varint,43,opt,name=RTO,proto3,oneof" json:"RTO,omitempty"`
-	DsackDups     *uint32 `protobuf:"varint,44,opt,name=DsackDups,proto3,oneof" json:"DsackDups,omitempty"`
-	RateDelivered *uint32 `protobuf:"varint,45,opt,name=RateDelivered,proto3,oneof" json:"RateDelivered,omitempty"`
-	RateInterval  *uint32 `protobuf:"varint,46,opt,name=RateInterval,proto3,oneof" json:"RateInterval,omitempty"`
-	SndSSThresh   *uint32 `protobuf:"varint,47,opt,name=SndSSThresh,proto3,oneof" json:"SndSSThresh,omitempty"`
-	PacketsOut    *uint32 `protobuf:"varint,48,opt,name=PacketsOut,proto3,oneof" json:"PacketsOut,omitempty"`
-	RetransOut    *uint32 `protobuf:"varint,49,opt,name=RetransOut,proto3,oneof" json:"RetransOut,omitempty"`
-	MaxPacketsOut *uint32 `protobuf:"varint,50,opt,name=MaxPacketsOut,proto3,oneof" json:"MaxPacketsOut,omitempty"`
-	MaxPacketsSeq *uint32 `protobuf:"varint,51,opt,name=MaxPacketsSeq,proto3,oneof" json:"MaxPacketsSeq,omitempty"`
-	GeoLocation   *string `protobuf:"bytes,52,opt,name=GeoLocation,proto3,oneof" json:"GeoLocation,omitempty"`
-	CCode         *string `protobuf:"bytes,53,opt,name=CCode,proto3,oneof" json:"CCode,omitempty"`
-	CSCode        *string `protobuf:"bytes,54,opt,name=CSCode,proto3,oneof" json:"CSCode,omitempty"`
-	Country       *string `protobuf:"bytes,55,opt,name=Country,proto3,oneof" json:"Country,omitempty"`
-	City          *string `protobuf:"bytes,56,opt,name=City,proto3,oneof" json:"City,omitempty"`
-	Region        *string `protobuf:"bytes,57,opt,name=Region,proto3,oneof" json:"Region,omitempty"`
-	ASN           *string `protobuf:"bytes,58,opt,name=ASN,proto3,oneof" json:"ASN,omitempty"`
-	ASNOrg        *string `protobuf:"bytes,59,opt,name=ASNOrg,proto3,oneof" json:"ASNOrg,omitempty"`
-	Hostname      *string `protobuf:"bytes,60,opt,name=Hostname,proto3,oneof" json:"Hostname,omitempty"`
-	Timestamp     *uint64 `protobuf:"varint,61,opt,name=Timestamp,proto3,oneof" json:"Timestamp,omitempty"`
+	Task          *string  `protobuf:"bytes,1,opt,name=Task,proto3,oneof" json:"Task,omitempty"`
+	PID           *uint32  `protobuf:"varint,2,opt,name=PID,proto3,oneof" json:"PID,omitempty"`
+	TCPHeaderLen  *uint32  `protobuf:"varint,3,opt,name=TCPHeaderLen,proto3,oneof" json:"TCPHeaderLen,omitempty"`
+	TotalRetrans  *uint32  `protobuf:"varint,4,opt,name=TotalRetrans,proto3,oneof" json:"TotalRetrans,omitempty"`
+	SAddr         *string  `protobuf:"bytes,5,opt,name=SAddr,proto3,oneof" json:"SAddr,omitempty"`
+	DAddr         *string  `protobuf:"bytes,6,opt,name=DAddr,proto3,oneof" json:"DAddr,omitempty"`
+	DPort         *uint32  `protobuf:"varint,7,opt,name=DPort,proto3,oneof" json:"DPort,omitempty"`
+	LPort         *uint32  `protobuf:"varint,8,opt,name=LPort,proto3,oneof" json:"LPort,omitempty"`
+	BytesReceived *uint64  `protobuf:"varint,9,opt,name=BytesReceived,proto3,oneof" json:"BytesReceived,omitempty"`
+	BytesSent     *uint64  `protobuf:"varint,10,opt,name=BytesSent,proto3,oneof" json:"BytesSent,omitempty"`
+	BytesAcked    *uint64  `protobuf:"varint,11,opt,name=BytesAcked,proto3,oneof" json:"BytesAcked,omitempty"`
+	NumSAcks      *uint32  `protobuf:"varint,12,opt,name=NumSAcks,proto3,oneof" json:"NumSAcks,omitempty"`
+	UserMSS       *uint32  `protobuf:"varint,13,opt,name=UserMSS,proto3,oneof" json:"UserMSS,omitempty"`
+	MSSClamp      *uint32  `protobuf:"varint,14,opt,name=MSSClamp,proto3,oneof" json:"MSSClamp,omitempty"`
+	AdvMSS        *uint32  `protobuf:"varint,15,opt,name=AdvMSS,proto3,oneof" json:"AdvMSS,omitempty"`
+	RTT           *uint32  `protobuf:"varint,16,opt,name=RTT,proto3,oneof" json:"RTT,omitempty"`
+	SRTT          *uint32  `protobuf:"varint,17,opt,name=SRTT,proto3,oneof" json:"SRTT,omitempty"`
+	RTTVar        *uint32  `protobuf:"varint,18,opt,name=RTTVar,proto3,oneof" json:"RTTVar,omitempty"`
+	RcvRTT        *uint32  `protobuf:"varint,19,opt,name=RcvRTT,proto3,oneof" json:"RcvRTT,omitempty"`
+	RACKRTT       *uint32  `protobuf:"varint,20,opt,name=RACKRTT,proto3,oneof" json:"RACKRTT,omitempty"`
+	MDev          *uint32  `protobuf:"varint,21,opt,name=MDev,proto3,oneof" json:"MDev,omitempty"`
+	MDevMax       *uint32  `protobuf:"varint,22,opt,name=MDevMax,proto3,oneof" json:"MDevMax,omitempty"`
+	SegsIn        *uint32  `protobuf:"varint,23,opt,name=SegsIn,proto3,oneof" json:"SegsIn,omitempty"`
+	SegsOut       *uint32  `protobuf:"varint,24,opt,name=SegsOut,proto3,oneof" json:"SegsOut,omitempty"`
+	GSOSegs       *uint32  `protobuf:"varint,25,opt,name=GSOSegs,proto3,oneof" json:"GSOSegs,omitempty"`
+	DataSegsIn    *uint32  `protobuf:"varint,26,opt,name=DataSegsIn,proto3,oneof" json:"DataSegsIn,omitempty"`
+	MaxWindow     *uint32  `protobuf:"varint,27,opt,name=MaxWindow,proto3,oneof" json:"MaxWindow,omitempty"`
+	SndWnd        *uint32  `protobuf:"varint,28,opt,name=SndWnd,proto3,oneof" json:"SndWnd,omitempty"`
+	WindowClamp   *uint32  `protobuf:"varint,29,opt,name=WindowClamp,proto3,oneof" json:"WindowClamp,omitempty"`
+	RcvSSThresh   *uint32  `protobuf:"varint,30,opt,name=RcvSSThresh,proto3,oneof" json:"RcvSSThresh,omitempty"`
+	ECNFlags      *uint32  `protobuf:"varint,31,opt,name=ECNFlags,proto3,oneof" json:"ECNFlags,omitempty"`
+	SndCwnd       *uint32  `protobuf:"varint,32,opt,name=SndCwnd,proto3,oneof" json:"SndCwnd,omitempty"`
+	PrrOut        *uint32  `protobuf:"varint,33,opt,name=PrrOut,proto3,oneof" json:"PrrOut,omitempty"`
+	Delivered     *uint32  `protobuf:"varint,34,opt,name=Delivered,proto3,oneof" json:"Delivered,omitempty"`
+	DeliveredCe   *uint32  `protobuf:"varint,35,opt,name=DeliveredCe,proto3,oneof" json:"DeliveredCe,omitempty"`
+	Lost          *uint32  `protobuf:"varint,36,opt,name=Lost,proto3,oneof" json:"Lost,omitempty"`
+	LostOut       *uint32  `protobuf:"varint,37,opt,name=LostOut,proto3,oneof" json:"LostOut,omitempty"`
+	PriorSSThresh *uint32  `protobuf:"varint,38,opt,name=PriorSSThresh,proto3,oneof" json:"PriorSSThresh,omitempty"`
+	DataSegsOut   *uint32  `protobuf:"varint,39,opt,name=DataSegsOut,proto3,oneof" json:"DataSegsOut,omitempty"`
+	RcvSpace      *uint32  `protobuf:"varint,40,opt,name=RcvSpace,proto3,oneof" json:"RcvSpace,omitempty"`
+	UnAcked       *uint32  `protobuf:"varint,41,opt,name=UnAcked,proto3,oneof" json:"UnAcked,omitempty"`
+	SAcked        *uint32  `protobuf:"varint,42,opt,name=SAcked,proto3,oneof" json:"SAcked,omitempty"`
+	RTO           *uint32  `protobuf:"varint,43,opt,name=RTO,proto3,oneof" json:"RTO,omitempty"`
+	DsackDups     *uint32  `protobuf:"varint,44,opt,name=DsackDups,proto3,oneof" json:"DsackDups,omitempty"`
+	RateDelivered *uint32  `protobuf:"varint,45,opt,name=RateDelivered,proto3,oneof" json:"RateDelivered,omitempty"`
+	RateInterval  *uint32  `protobuf:"varint,46,opt,name=RateInterval,proto3,oneof" json:"RateInterval,omitempty"`
+	SndSSThresh   *uint32  `protobuf:"varint,47,opt,name=SndSSThresh,proto3,oneof" json:"SndSSThresh,omitempty"`
+	PacketsOut    *uint32  `protobuf:"varint,48,opt,name=PacketsOut,proto3,oneof" json:"PacketsOut,omitempty"`
+	RetransOut    *uint32  `protobuf:"varint,49,opt,name=RetransOut,proto3,oneof" json:"RetransOut,omitempty"`
+	MaxPacketsOut *uint32  `protobuf:"varint,50,opt,name=MaxPacketsOut,proto3,oneof" json:"MaxPacketsOut,omitempty"`
+	MaxPacketsSeq *uint32  `protobuf:"varint,51,opt,name=MaxPacketsSeq,proto3,oneof" json:"MaxPacketsSeq,omitempty"`
+	GeoLocation   *string  `protobuf:"bytes,52,opt,name=GeoLocation,proto3,oneof" json:"GeoLocation,omitempty"`
+	CCode         *string  `protobuf:"bytes,53,opt,name=CCode,proto3,oneof" json:"CCode,omitempty"`
+	CSCode        *string  `protobuf:"bytes,54,opt,name=CSCode,proto3,oneof" json:"CSCode,omitempty"`
+	Country       *string  `protobuf:"bytes,55,opt,name=Country,proto3,oneof" json:"Country,omitempty"`
+	City          *string  `protobuf:"bytes,56,opt,name=City,proto3,oneof" json:"City,omitempty"`
+	Region        *string  `protobuf:"bytes,57,opt,name=Region,proto3,oneof" json:"Region,omitempty"`
+	ASN           *string  `protobuf:"bytes,58,opt,name=ASN,proto3,oneof" json:"ASN,omitempty"`
+	ASNOrg        *string  `protobuf:"bytes,59,opt,name=ASNOrg,proto3,oneof" json:"ASNOrg,omitempty"`
+	Hostname      *string  `protobuf:"bytes,60,opt,name=Hostname,proto3,oneof" json:"Hostname,omitempty"`
+	Timestamp     *uint64  `protobuf:"varint,61,opt,name=Timestamp,proto3,oneof" json:"Timestamp,omitempty"`
+	InitCwnd      *uint32  `protobuf:"varint,62,opt,name=InitCwnd,proto3,oneof" json:"InitCwnd,omitempty"`
+	Cwnd          *uint32  `protobuf:"varint,63,opt,name=Cwnd,proto3,oneof" json:"Cwnd,omitempty"`
+	CloseReason   *string  `protobuf:"bytes,64,opt,name=CloseReason,proto3,oneof" json:"CloseReason,omitempty"`
+	SampleWeight  *uint32  `protobuf:"varint,65,opt,name=SampleWeight,proto3,oneof" json:"SampleWeight,omitempty"`
+	FailReason    *string  `protobuf:"bytes,66,opt,name=FailReason,proto3,oneof" json:"FailReason,omitempty"`
+	VRF           *uint32  `protobuf:"varint,67,opt,name=VRF,proto3,oneof" json:"VRF,omitempty"`
+	VRFName       *string  `protobuf:"bytes,68,opt,name=VRFName,proto3,oneof" json:"VRFName,omitempty"`
+	SynRetrans    *uint32  `protobuf:"varint,69,opt,name=SynRetrans,proto3,oneof" json:"SynRetrans,omitempty"`
+	Count         *uint64  `protobuf:"varint,70,opt,name=Count,proto3,oneof" json:"Count,omitempty"`
+	Window        *uint32  `protobuf:"varint,71,opt,name=Window,proto3,oneof" json:"Window,omitempty"`
+	FlowLabel     *uint32  `protobuf:"varint,72,opt,name=FlowLabel,proto3,oneof" json:"FlowLabel,omitempty"`
+	TClass        *uint32  `protobuf:"varint,73,opt,name=TClass,proto3,oneof" json:"TClass,omitempty"`
+	TSRTT         *uint32  `protobuf:"varint,74,opt,name=TSRTT,proto3,oneof" json:"TSRTT,omitempty"`
+	RxQueue       *uint32  `protobuf:"varint,75,opt,name=RxQueue,proto3,oneof" json:"RxQueue,omitempty"`
+	TxQueue       *uint32  `protobuf:"varint,76,opt,name=TxQueue,proto3,oneof" json:"TxQueue,omitempty"`
+	KTime         *uint64  `protobuf:"varint,77,opt,name=KTime,proto3,oneof" json:"KTime,omitempty"`
+	ReadTime      *uint64  `protobuf:"varint,78,opt,name=ReadTime,proto3,oneof" json:"ReadTime,omitempty"`
+	AgentDelay    *uint64  `protobuf:"varint,79,opt,name=AgentDelay,proto3,oneof" json:"AgentDelay,omitempty"`
+	UID           *uint32  `protobuf:"varint,80,opt,name=UID,proto3,oneof" json:"UID,omitempty"`
+	Synthetic     *bool    `protobuf:"varint,81,opt,name=Synthetic,proto3,oneof" json:"Synthetic,omitempty"`
+	TOS           *uint32  `protobuf:"varint,82,opt,name=TOS,proto3,oneof" json:"TOS,omitempty"`
+	DSCP          *uint32  `protobuf:"varint,83,opt,name=DSCP,proto3,oneof" json:"DSCP,omitempty"`
+	DSCPName      *string  `protobuf:"bytes,84,opt,name=DSCPName,proto3,oneof" json:"DSCPName,omitempty"`
+	EventID       *string  `protobuf:"bytes,85,opt,name=EventID,proto3,oneof" json:"EventID,omitempty"`
+	RetransType   *string  `protobuf:"bytes,86,opt,name=RetransType,proto3,oneof" json:"RetransType,omitempty"`
+	RetransRate   *float64 `protobuf:"fixed64,87,opt,name=RetransRate,proto3,oneof" json:"RetransRate,omitempty"`
 }
 
 func (x *Fields) Reset() {
@@ -604,6 +676,188 @@ func (x *Fields) GetTimestamp() uint64 {
 	return 0
 }
 
+func (x *Fields) GetInitCwnd() uint32 {
+	if x != nil && x.InitCwnd != nil {
+		return *x.InitCwnd
+	}
+	return 0
+}
+
+func (x *Fields) GetCwnd() uint32 {
+	if x != nil && x.Cwnd != nil {
+		return *x.Cwnd
+	}
+	return 0
+}
+
+func (x *Fields) GetCloseReason() string {
+	if x != nil && x.CloseReason != nil {
+		return *x.CloseReason
+	}
+	return ""
+}
+
+func (x *Fields) GetSampleWeight() uint32 {
+	if x != nil && x.SampleWeight != nil {
+		return *x.SampleWeight
+	}
+	return 0
+}
+
+func (x *Fields) GetFailReason() string {
+	if x != nil && x.FailReason != nil {
+		return *x.FailReason
+	}
+	return ""
+}
+
+func (x *Fields) GetVRF() uint32 {
+	if x != nil && x.VRF != nil {
+		return *x.VRF
+	}
+	return 0
+}
+
+func (x *Fields) GetVRFName() string {
+	if x != nil && x.VRFName != nil {
+		return *x.VRFName
+	}
+	return ""
+}
+
+func (x *Fields) GetSynRetrans() uint32 {
+	if x != nil && x.SynRetrans != nil {
+		return *x.SynRetrans
+	}
+	return 0
+}
+
+func (x *Fields) GetCount() uint64 {
+	if x != nil && x.Count != nil {
+		return *x.Count
+	}
+	return 0
+}
+
+func (x *Fields) GetWindow() uint32 {
+	if x != nil && x.Window != nil {
+		return *x.Window
+	}
+	return 0
+}
+
+func (x *Fields) GetFlowLabel() uint32 {
+	if x != nil && x.FlowLabel != nil {
+		return *x.FlowLabel
+	}
+	return 0
+}
+
+func (x *Fields) GetTClass() uint32 {
+	if x != nil && x.TClass != nil {
+		return *x.TClass
+	}
+	return 0
+}
+
+func (x *Fields) GetTSRTT() uint32 {
+	if x != nil && x.TSRTT != nil {
+		return *x.TSRTT
+	}
+	return 0
+}
+
+func (x *Fields) GetRxQueue() uint32 {
+	if x != nil && x.RxQueue != nil {
+		return *x.RxQueue
+	}
+	return 0
+}
+
+func (x *Fields) GetTxQueue() uint32 {
+	if x != nil && x.TxQueue != nil {
+		return *x.TxQueue
+	}
+	return 0
+}
+
+func (x *Fields) GetKTime() uint64 {
+	if x != nil && x.KTime != nil {
+		return *x.KTime
+	}
+	return 0
+}
+
+func (x *Fields) GetReadTime() uint64 {
+	if x != nil && x.ReadTime != nil {
+		return *x.ReadTime
+	}
+	return 0
+}
+
+func (x *Fields) GetAgentDelay() uint64 {
+	if x != nil && x.AgentDelay != nil {
+		return *x.AgentDelay
+	}
+	return 0
+}
+
+func (x *Fields) GetUID() uint32 {
+	if x != nil && x.UID != nil {
+		return *x.UID
+	}
+	return 0
+}
+
+func (x *Fields) GetSynthetic() bool {
+	if x != nil && x.Synthetic != nil {
+		return *x.Synthetic
+	}
+	return false
+}
+
+func (x *Fields) GetTOS() uint32 {
+	if x != nil && x.TOS != nil {
+		return *x.TOS
+	}
+	return 0
+}
+
+func (x *Fields) GetDSCP() uint32 {
+	if x != nil && x.DSCP != nil {
+		return *x.DSCP
+	}
+	return 0
+}
+
+func (x *Fields) GetDSCPName() string {
+	if x != nil && x.DSCPName != nil {
+		return *x.DSCPName
+	}
+	return ""
+}
+
+func (x *Fields) GetEventID() string {
+	if x != nil && x.EventID != nil {
+		return *x.EventID
+	}
+	return ""
+}
+
+func (x *Fields) GetRetransType() string {
+	if x != nil && x.RetransType != nil {
+		return *x.RetransType
+	}
+	return ""
+}
+
+func (x *Fields) GetRetransRate() float64 {
+	if x != nil && x.RetransRate != nil {
+		return *x.RetransRate
+	}
+	return 0
+}
+
 type Response struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -651,6 +905,257 @@ func (x *Response) GetCode() int32 {
 	return 0
 }
 
+type FlowControlRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hostname string `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
+}
+
+func (x *FlowControlRequest) Reset() {
+	*x = FlowControlRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tcpdog_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FlowControlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowControlRequest) ProtoMessage() {}
+
+func (x *FlowControlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tcpdog_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowControlRequest.ProtoReflect.Descriptor instead.
+func (*FlowControlRequest) Descriptor() ([]byte, []int) {
+	return file_tcpdog_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *FlowControlRequest) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+type FlowControlHint struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Action FlowControlAction `protobuf:"varint,1,opt,name=action,proto3,enum=tcpdog.FlowControlAction" json:"action,omitempty"`
+	Factor uint32            `protobuf:"varint,2,opt,name=factor,proto3" json:"factor,omitempty"`
+}
+
+func (x *FlowControlHint) Reset() {
+	*x = FlowControlHint{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tcpdog_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FlowControlHint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowControlHint) ProtoMessage() {}
+
+func (x *FlowControlHint) ProtoReflect() protoreflect.Message {
+	mi := &file_tcpdog_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowControlHint.ProtoReflect.Descriptor instead.
+func (*FlowControlHint) Descriptor() ([]byte, []int) {
+	return file_tcpdog_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FlowControlHint) GetAction() FlowControlAction {
+	if x != nil {
+		return x.Action
+	}
+	return FlowControlAction_RESUME
+}
+
+func (x *FlowControlHint) GetFactor() uint32 {
+	if x != nil {
+		return x.Factor
+	}
+	return 0
+}
+
+type TailRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Sample uint32 `protobuf:"varint,1,opt,name=sample,proto3" json:"sample,omitempty"`
+}
+
+func (x *TailRequest) Reset() {
+	*x = TailRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tcpdog_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TailRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TailRequest) ProtoMessage() {}
+
+func (x *TailRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tcpdog_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TailRequest.ProtoReflect.Descriptor instead.
+func (*TailRequest) Descriptor() ([]byte, []int) {
+	return file_tcpdog_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TailRequest) GetSample() uint32 {
+	if x != nil {
+		return x.Sample
+	}
+	return 0
+}
+
+type State struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *State) Reset() {
+	*x = State{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tcpdog_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *State) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*State) ProtoMessage() {}
+
+func (x *State) ProtoReflect() protoreflect.Message {
+	mi := &file_tcpdog_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use State.ProtoReflect.Descriptor instead.
+func (*State) Descriptor() ([]byte, []int) {
+	return file_tcpdog_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *State) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *State) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type StateRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (x *StateRequest) Reset() {
+	*x = StateRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_tcpdog_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateRequest) ProtoMessage() {}
+
+func (x *StateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tcpdog_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateRequest.ProtoReflect.Descriptor instead.
+func (*StateRequest) Descriptor() ([]byte, []int) {
+	return file_tcpdog_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StateRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
 var File_tcpdog_proto protoreflect.FileDescriptor
 
 var file_tcpdog_proto_rawDesc = []byte{
@@ -661,7 +1166,7 @@ var file_tcpdog_proto_rawDesc = []byte{
 	0x42, 0x12, 0x2f, 0x0a, 0x06, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28,
 	0x0b, 0x32, 0x17, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
 	0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x75, 0x63, 0x74, 0x52, 0x06, 0x66, 0x69, 0x65, 0x6c,
-	0x64, 0x73, 0x22, 0xce, 0x15, 0x0a, 0x06, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x12, 0x17, 0x0a,
+	0x64, 0x73, 0x22, 0xc1, 0x1e, 0x0a, 0x06, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x12, 0x17, 0x0a,
 	0x04, 0x54, 0x61, 0x73, 0x6b, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x04, 0x54,
 	0x61, 0x73, 0x6b, 0x88, 0x01, 0x01, 0x12, 0x15, 0x0a, 0x03, 0x50, 0x49, 0x44, 0x18, 0x02, 0x20,
 	0x01, 0x28, 0x0d, 0x48, 0x01, 0x52, 0x03, 0x50, 0x49, 0x44, 0x88, 0x01, 0x01, 0x12, 0x27, 0x0a,
@@ -785,66 +1290,171 @@ var file_tcpdog_proto_rawDesc = []byte{
 	0x6d, 0x65, 0x18, 0x3c, 0x20, 0x01, 0x28, 0x09, 0x48, 0x3b, 0x52, 0x08, 0x48, 0x6f, 0x73, 0x74,
 	0x6e, 0x61, 0x6d, 0x65, 0x88, 0x01, 0x01, 0x12, 0x21, 0x0a, 0x09, 0x54, 0x69, 0x6d, 0x65, 0x73,
 	0x74, 0x61, 0x6d, 0x70, 0x18, 0x3d, 0x20, 0x01, 0x28, 0x04, 0x48, 0x3c, 0x52, 0x09, 0x54, 0x69,
-	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x88, 0x01, 0x01, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x54,
-	0x61, 0x73, 0x6b, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x50, 0x49, 0x44, 0x42, 0x0f, 0x0a, 0x0d, 0x5f,
-	0x54, 0x43, 0x50, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x4c, 0x65, 0x6e, 0x42, 0x0f, 0x0a, 0x0d,
-	0x5f, 0x54, 0x6f, 0x74, 0x61, 0x6c, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x42, 0x08, 0x0a,
-	0x06, 0x5f, 0x53, 0x41, 0x64, 0x64, 0x72, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x44, 0x41, 0x64, 0x64,
-	0x72, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x44, 0x50, 0x6f, 0x72, 0x74, 0x42, 0x08, 0x0a, 0x06, 0x5f,
-	0x4c, 0x50, 0x6f, 0x72, 0x74, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x42, 0x79, 0x74, 0x65, 0x73, 0x52,
-	0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x42, 0x79, 0x74, 0x65,
-	0x73, 0x53, 0x65, 0x6e, 0x74, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x42, 0x79, 0x74, 0x65, 0x73, 0x41,
-	0x63, 0x6b, 0x65, 0x64, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x4e, 0x75, 0x6d, 0x53, 0x41, 0x63, 0x6b,
-	0x73, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x55, 0x73, 0x65, 0x72, 0x4d, 0x53, 0x53, 0x42, 0x0b, 0x0a,
-	0x09, 0x5f, 0x4d, 0x53, 0x53, 0x43, 0x6c, 0x61, 0x6d, 0x70, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x41,
-	0x64, 0x76, 0x4d, 0x53, 0x53, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x52, 0x54, 0x54, 0x42, 0x07, 0x0a,
-	0x05, 0x5f, 0x53, 0x52, 0x54, 0x54, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x52, 0x54, 0x54, 0x56, 0x61,
-	0x72, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x52, 0x63, 0x76, 0x52, 0x54, 0x54, 0x42, 0x0a, 0x0a, 0x08,
-	0x5f, 0x52, 0x41, 0x43, 0x4b, 0x52, 0x54, 0x54, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x4d, 0x44, 0x65,
-	0x76, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x4d, 0x44, 0x65, 0x76, 0x4d, 0x61, 0x78, 0x42, 0x09, 0x0a,
-	0x07, 0x5f, 0x53, 0x65, 0x67, 0x73, 0x49, 0x6e, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x53, 0x65, 0x67,
-	0x73, 0x4f, 0x75, 0x74, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x47, 0x53, 0x4f, 0x53, 0x65, 0x67, 0x73,
-	0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x44, 0x61, 0x74, 0x61, 0x53, 0x65, 0x67, 0x73, 0x49, 0x6e, 0x42,
-	0x0c, 0x0a, 0x0a, 0x5f, 0x4d, 0x61, 0x78, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x42, 0x09, 0x0a,
-	0x07, 0x5f, 0x53, 0x6e, 0x64, 0x57, 0x6e, 0x64, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x57, 0x69, 0x6e,
-	0x64, 0x6f, 0x77, 0x43, 0x6c, 0x61, 0x6d, 0x70, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x52, 0x63, 0x76,
-	0x53, 0x53, 0x54, 0x68, 0x72, 0x65, 0x73, 0x68, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x45, 0x43, 0x4e,
-	0x46, 0x6c, 0x61, 0x67, 0x73, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x53, 0x6e, 0x64, 0x43, 0x77, 0x6e,
-	0x64, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x50, 0x72, 0x72, 0x4f, 0x75, 0x74, 0x42, 0x0c, 0x0a, 0x0a,
-	0x5f, 0x44, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65, 0x64, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x44,
-	0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65, 0x64, 0x43, 0x65, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x4c,
-	0x6f, 0x73, 0x74, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x4c, 0x6f, 0x73, 0x74, 0x4f, 0x75, 0x74, 0x42,
-	0x10, 0x0a, 0x0e, 0x5f, 0x50, 0x72, 0x69, 0x6f, 0x72, 0x53, 0x53, 0x54, 0x68, 0x72, 0x65, 0x73,
-	0x68, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x44, 0x61, 0x74, 0x61, 0x53, 0x65, 0x67, 0x73, 0x4f, 0x75,
-	0x74, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x52, 0x63, 0x76, 0x53, 0x70, 0x61, 0x63, 0x65, 0x42, 0x0a,
-	0x0a, 0x08, 0x5f, 0x55, 0x6e, 0x41, 0x63, 0x6b, 0x65, 0x64, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x53,
-	0x41, 0x63, 0x6b, 0x65, 0x64, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x52, 0x54, 0x4f, 0x42, 0x0c, 0x0a,
-	0x0a, 0x5f, 0x44, 0x73, 0x61, 0x63, 0x6b, 0x44, 0x75, 0x70, 0x73, 0x42, 0x10, 0x0a, 0x0e, 0x5f,
-	0x52, 0x61, 0x74, 0x65, 0x44, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65, 0x64, 0x42, 0x0f, 0x0a,
-	0x0d, 0x5f, 0x52, 0x61, 0x74, 0x65, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x42, 0x0e,
-	0x0a, 0x0c, 0x5f, 0x53, 0x6e, 0x64, 0x53, 0x53, 0x54, 0x68, 0x72, 0x65, 0x73, 0x68, 0x42, 0x0d,
-	0x0a, 0x0b, 0x5f, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x4f, 0x75, 0x74, 0x42, 0x0d, 0x0a,
-	0x0b, 0x5f, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x4f, 0x75, 0x74, 0x42, 0x10, 0x0a, 0x0e,
-	0x5f, 0x4d, 0x61, 0x78, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x4f, 0x75, 0x74, 0x42, 0x10,
-	0x0a, 0x0e, 0x5f, 0x4d, 0x61, 0x78, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x53, 0x65, 0x71,
-	0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x47, 0x65, 0x6f, 0x4c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e,
-	0x42, 0x08, 0x0a, 0x06, 0x5f, 0x43, 0x43, 0x6f, 0x64, 0x65, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x43,
-	0x53, 0x43, 0x6f, 0x64, 0x65, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x72,
-	0x79, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x43, 0x69, 0x74, 0x79, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x52,
-	0x65, 0x67, 0x69, 0x6f, 0x6e, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x41, 0x53, 0x4e, 0x42, 0x09, 0x0a,
-	0x07, 0x5f, 0x41, 0x53, 0x4e, 0x4f, 0x72, 0x67, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x48, 0x6f, 0x73,
-	0x74, 0x6e, 0x61, 0x6d, 0x65, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
-	0x61, 0x6d, 0x70, 0x22, 0x1e, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
-	0x12, 0x0a, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x63,
-	0x6f, 0x64, 0x65, 0x32, 0x76, 0x0a, 0x06, 0x54, 0x43, 0x50, 0x44, 0x6f, 0x67, 0x12, 0x32, 0x0a,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x88, 0x01, 0x01, 0x12, 0x1f, 0x0a, 0x08, 0x49, 0x6e,
+	0x69, 0x74, 0x43, 0x77, 0x6e, 0x64, 0x18, 0x3e, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x3d, 0x52, 0x08,
+	0x49, 0x6e, 0x69, 0x74, 0x43, 0x77, 0x6e, 0x64, 0x88, 0x01, 0x01, 0x12, 0x17, 0x0a, 0x04, 0x43,
+	0x77, 0x6e, 0x64, 0x18, 0x3f, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x3e, 0x52, 0x04, 0x43, 0x77, 0x6e,
+	0x64, 0x88, 0x01, 0x01, 0x12, 0x25, 0x0a, 0x0b, 0x43, 0x6c, 0x6f, 0x73, 0x65, 0x52, 0x65, 0x61,
+	0x73, 0x6f, 0x6e, 0x18, 0x40, 0x20, 0x01, 0x28, 0x09, 0x48, 0x3f, 0x52, 0x0b, 0x43, 0x6c, 0x6f,
+	0x73, 0x65, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x88, 0x01, 0x01, 0x12, 0x27, 0x0a, 0x0c, 0x53,
+	0x61, 0x6d, 0x70, 0x6c, 0x65, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x41, 0x20, 0x01, 0x28,
+	0x0d, 0x48, 0x40, 0x52, 0x0c, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x57, 0x65, 0x69, 0x67, 0x68,
+	0x74, 0x88, 0x01, 0x01, 0x12, 0x23, 0x0a, 0x0a, 0x46, 0x61, 0x69, 0x6c, 0x52, 0x65, 0x61, 0x73,
+	0x6f, 0x6e, 0x18, 0x42, 0x20, 0x01, 0x28, 0x09, 0x48, 0x41, 0x52, 0x0a, 0x46, 0x61, 0x69, 0x6c,
+	0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x88, 0x01, 0x01, 0x12, 0x15, 0x0a, 0x03, 0x56, 0x52, 0x46,
+	0x18, 0x43, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x42, 0x52, 0x03, 0x56, 0x52, 0x46, 0x88, 0x01, 0x01,
+	0x12, 0x1d, 0x0a, 0x07, 0x56, 0x52, 0x46, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x44, 0x20, 0x01, 0x28,
+	0x09, 0x48, 0x43, 0x52, 0x07, 0x56, 0x52, 0x46, 0x4e, 0x61, 0x6d, 0x65, 0x88, 0x01, 0x01, 0x12,
+	0x23, 0x0a, 0x0a, 0x53, 0x79, 0x6e, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x18, 0x45, 0x20,
+	0x01, 0x28, 0x0d, 0x48, 0x44, 0x52, 0x0a, 0x53, 0x79, 0x6e, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e,
+	0x73, 0x88, 0x01, 0x01, 0x12, 0x19, 0x0a, 0x05, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x46, 0x20,
+	0x01, 0x28, 0x04, 0x48, 0x45, 0x52, 0x05, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x88, 0x01, 0x01, 0x12,
+	0x1b, 0x0a, 0x06, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x18, 0x47, 0x20, 0x01, 0x28, 0x0d, 0x48,
+	0x46, 0x52, 0x06, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x88, 0x01, 0x01, 0x12, 0x21, 0x0a, 0x09,
+	0x46, 0x6c, 0x6f, 0x77, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x18, 0x48, 0x20, 0x01, 0x28, 0x0d, 0x48,
+	0x47, 0x52, 0x09, 0x46, 0x6c, 0x6f, 0x77, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x88, 0x01, 0x01, 0x12,
+	0x1b, 0x0a, 0x06, 0x54, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x49, 0x20, 0x01, 0x28, 0x0d, 0x48,
+	0x48, 0x52, 0x06, 0x54, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x88, 0x01, 0x01, 0x12, 0x19, 0x0a, 0x05,
+	0x54, 0x53, 0x52, 0x54, 0x54, 0x18, 0x4a, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x49, 0x52, 0x05, 0x54,
+	0x53, 0x52, 0x54, 0x54, 0x88, 0x01, 0x01, 0x12, 0x1d, 0x0a, 0x07, 0x52, 0x78, 0x51, 0x75, 0x65,
+	0x75, 0x65, 0x18, 0x4b, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x4a, 0x52, 0x07, 0x52, 0x78, 0x51, 0x75,
+	0x65, 0x75, 0x65, 0x88, 0x01, 0x01, 0x12, 0x1d, 0x0a, 0x07, 0x54, 0x78, 0x51, 0x75, 0x65, 0x75,
+	0x65, 0x18, 0x4c, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x4b, 0x52, 0x07, 0x54, 0x78, 0x51, 0x75, 0x65,
+	0x75, 0x65, 0x88, 0x01, 0x01, 0x12, 0x19, 0x0a, 0x05, 0x4b, 0x54, 0x69, 0x6d, 0x65, 0x18, 0x4d,
+	0x20, 0x01, 0x28, 0x04, 0x48, 0x4c, 0x52, 0x05, 0x4b, 0x54, 0x69, 0x6d, 0x65, 0x88, 0x01, 0x01,
+	0x12, 0x1f, 0x0a, 0x08, 0x52, 0x65, 0x61, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x18, 0x4e, 0x20, 0x01,
+	0x28, 0x04, 0x48, 0x4d, 0x52, 0x08, 0x52, 0x65, 0x61, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x88, 0x01,
+	0x01, 0x12, 0x23, 0x0a, 0x0a, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x18,
+	0x4f, 0x20, 0x01, 0x28, 0x04, 0x48, 0x4e, 0x52, 0x0a, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x44, 0x65,
+	0x6c, 0x61, 0x79, 0x88, 0x01, 0x01, 0x12, 0x15, 0x0a, 0x03, 0x55, 0x49, 0x44, 0x18, 0x50, 0x20,
+	0x01, 0x28, 0x0d, 0x48, 0x4f, 0x52, 0x03, 0x55, 0x49, 0x44, 0x88, 0x01, 0x01, 0x12, 0x21, 0x0a,
+	0x09, 0x53, 0x79, 0x6e, 0x74, 0x68, 0x65, 0x74, 0x69, 0x63, 0x18, 0x51, 0x20, 0x01, 0x28, 0x08,
+	0x48, 0x50, 0x52, 0x09, 0x53, 0x79, 0x6e, 0x74, 0x68, 0x65, 0x74, 0x69, 0x63, 0x88, 0x01, 0x01,
+	0x12, 0x15, 0x0a, 0x03, 0x54, 0x4f, 0x53, 0x18, 0x52, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x51, 0x52,
+	0x03, 0x54, 0x4f, 0x53, 0x88, 0x01, 0x01, 0x12, 0x17, 0x0a, 0x04, 0x44, 0x53, 0x43, 0x50, 0x18,
+	0x53, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x52, 0x52, 0x04, 0x44, 0x53, 0x43, 0x50, 0x88, 0x01, 0x01,
+	0x12, 0x1f, 0x0a, 0x08, 0x44, 0x53, 0x43, 0x50, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x54, 0x20, 0x01,
+	0x28, 0x09, 0x48, 0x53, 0x52, 0x08, 0x44, 0x53, 0x43, 0x50, 0x4e, 0x61, 0x6d, 0x65, 0x88, 0x01,
+	0x01, 0x12, 0x1d, 0x0a, 0x07, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x55, 0x20, 0x01,
+	0x28, 0x09, 0x48, 0x54, 0x52, 0x07, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x88, 0x01, 0x01,
+	0x12, 0x25, 0x0a, 0x0b, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x54, 0x79, 0x70, 0x65, 0x18,
+	0x56, 0x20, 0x01, 0x28, 0x09, 0x48, 0x55, 0x52, 0x0b, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73,
+	0x54, 0x79, 0x70, 0x65, 0x88, 0x01, 0x01, 0x12, 0x25, 0x0a, 0x0b, 0x52, 0x65, 0x74, 0x72, 0x61,
+	0x6e, 0x73, 0x52, 0x61, 0x74, 0x65, 0x18, 0x57, 0x20, 0x01, 0x28, 0x01, 0x48, 0x56, 0x52, 0x0b,
+	0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x52, 0x61, 0x74, 0x65, 0x88, 0x01, 0x01, 0x42, 0x07,
+	0x0a, 0x05, 0x5f, 0x54, 0x61, 0x73, 0x6b, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x50, 0x49, 0x44, 0x42,
+	0x0f, 0x0a, 0x0d, 0x5f, 0x54, 0x43, 0x50, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x4c, 0x65, 0x6e,
+	0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x54, 0x6f, 0x74, 0x61, 0x6c, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e,
+	0x73, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x53, 0x41, 0x64, 0x64, 0x72, 0x42, 0x08, 0x0a, 0x06, 0x5f,
+	0x44, 0x41, 0x64, 0x64, 0x72, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x44, 0x50, 0x6f, 0x72, 0x74, 0x42,
+	0x08, 0x0a, 0x06, 0x5f, 0x4c, 0x50, 0x6f, 0x72, 0x74, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x42, 0x79,
+	0x74, 0x65, 0x73, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x42, 0x0c, 0x0a, 0x0a, 0x5f,
+	0x42, 0x79, 0x74, 0x65, 0x73, 0x53, 0x65, 0x6e, 0x74, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x42, 0x79,
+	0x74, 0x65, 0x73, 0x41, 0x63, 0x6b, 0x65, 0x64, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x4e, 0x75, 0x6d,
+	0x53, 0x41, 0x63, 0x6b, 0x73, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x55, 0x73, 0x65, 0x72, 0x4d, 0x53,
+	0x53, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x4d, 0x53, 0x53, 0x43, 0x6c, 0x61, 0x6d, 0x70, 0x42, 0x09,
+	0x0a, 0x07, 0x5f, 0x41, 0x64, 0x76, 0x4d, 0x53, 0x53, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x52, 0x54,
+	0x54, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x53, 0x52, 0x54, 0x54, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x52,
+	0x54, 0x54, 0x56, 0x61, 0x72, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x52, 0x63, 0x76, 0x52, 0x54, 0x54,
+	0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x52, 0x41, 0x43, 0x4b, 0x52, 0x54, 0x54, 0x42, 0x07, 0x0a, 0x05,
+	0x5f, 0x4d, 0x44, 0x65, 0x76, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x4d, 0x44, 0x65, 0x76, 0x4d, 0x61,
+	0x78, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x53, 0x65, 0x67, 0x73, 0x49, 0x6e, 0x42, 0x0a, 0x0a, 0x08,
+	0x5f, 0x53, 0x65, 0x67, 0x73, 0x4f, 0x75, 0x74, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x47, 0x53, 0x4f,
+	0x53, 0x65, 0x67, 0x73, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x44, 0x61, 0x74, 0x61, 0x53, 0x65, 0x67,
+	0x73, 0x49, 0x6e, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x4d, 0x61, 0x78, 0x57, 0x69, 0x6e, 0x64, 0x6f,
+	0x77, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x53, 0x6e, 0x64, 0x57, 0x6e, 0x64, 0x42, 0x0e, 0x0a, 0x0c,
+	0x5f, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x43, 0x6c, 0x61, 0x6d, 0x70, 0x42, 0x0e, 0x0a, 0x0c,
+	0x5f, 0x52, 0x63, 0x76, 0x53, 0x53, 0x54, 0x68, 0x72, 0x65, 0x73, 0x68, 0x42, 0x0b, 0x0a, 0x09,
+	0x5f, 0x45, 0x43, 0x4e, 0x46, 0x6c, 0x61, 0x67, 0x73, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x53, 0x6e,
+	0x64, 0x43, 0x77, 0x6e, 0x64, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x50, 0x72, 0x72, 0x4f, 0x75, 0x74,
+	0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x44, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65, 0x64, 0x42, 0x0e,
+	0x0a, 0x0c, 0x5f, 0x44, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65, 0x64, 0x43, 0x65, 0x42, 0x07,
+	0x0a, 0x05, 0x5f, 0x4c, 0x6f, 0x73, 0x74, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x4c, 0x6f, 0x73, 0x74,
+	0x4f, 0x75, 0x74, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x50, 0x72, 0x69, 0x6f, 0x72, 0x53, 0x53, 0x54,
+	0x68, 0x72, 0x65, 0x73, 0x68, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x44, 0x61, 0x74, 0x61, 0x53, 0x65,
+	0x67, 0x73, 0x4f, 0x75, 0x74, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x52, 0x63, 0x76, 0x53, 0x70, 0x61,
+	0x63, 0x65, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x55, 0x6e, 0x41, 0x63, 0x6b, 0x65, 0x64, 0x42, 0x09,
+	0x0a, 0x07, 0x5f, 0x53, 0x41, 0x63, 0x6b, 0x65, 0x64, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x52, 0x54,
+	0x4f, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x44, 0x73, 0x61, 0x63, 0x6b, 0x44, 0x75, 0x70, 0x73, 0x42,
+	0x10, 0x0a, 0x0e, 0x5f, 0x52, 0x61, 0x74, 0x65, 0x44, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65,
+	0x64, 0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x52, 0x61, 0x74, 0x65, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76,
+	0x61, 0x6c, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x53, 0x6e, 0x64, 0x53, 0x53, 0x54, 0x68, 0x72, 0x65,
+	0x73, 0x68, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x4f, 0x75,
+	0x74, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x4f, 0x75, 0x74,
+	0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x4d, 0x61, 0x78, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x4f,
+	0x75, 0x74, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x4d, 0x61, 0x78, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74,
+	0x73, 0x53, 0x65, 0x71, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x47, 0x65, 0x6f, 0x4c, 0x6f, 0x63, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x43, 0x43, 0x6f, 0x64, 0x65, 0x42, 0x09,
+	0x0a, 0x07, 0x5f, 0x43, 0x53, 0x43, 0x6f, 0x64, 0x65, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x43, 0x6f,
+	0x75, 0x6e, 0x74, 0x72, 0x79, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x43, 0x69, 0x74, 0x79, 0x42, 0x09,
+	0x0a, 0x07, 0x5f, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x41, 0x53,
+	0x4e, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x41, 0x53, 0x4e, 0x4f, 0x72, 0x67, 0x42, 0x0b, 0x0a, 0x09,
+	0x5f, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x49, 0x6e, 0x69, 0x74,
+	0x43, 0x77, 0x6e, 0x64, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x43, 0x77, 0x6e, 0x64, 0x42, 0x0e, 0x0a,
+	0x0c, 0x5f, 0x43, 0x6c, 0x6f, 0x73, 0x65, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x42, 0x0f, 0x0a,
+	0x0d, 0x5f, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x42, 0x0d,
+	0x0a, 0x0b, 0x5f, 0x46, 0x61, 0x69, 0x6c, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x42, 0x06, 0x0a,
+	0x04, 0x5f, 0x56, 0x52, 0x46, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x56, 0x52, 0x46, 0x4e, 0x61, 0x6d,
+	0x65, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x53, 0x79, 0x6e, 0x52, 0x65, 0x74, 0x72, 0x61, 0x6e, 0x73,
+	0x42, 0x08, 0x0a, 0x06, 0x5f, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x57,
+	0x69, 0x6e, 0x64, 0x6f, 0x77, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x46, 0x6c, 0x6f, 0x77, 0x4c, 0x61,
+	0x62, 0x65, 0x6c, 0x42, 0x09, 0x0a, 0x07, 0x5f, 0x54, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x42, 0x08,
+	0x0a, 0x06, 0x5f, 0x54, 0x53, 0x52, 0x54, 0x54, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x52, 0x78, 0x51,
+	0x75, 0x65, 0x75, 0x65, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x54, 0x78, 0x51, 0x75, 0x65, 0x75, 0x65,
+	0x42, 0x08, 0x0a, 0x06, 0x5f, 0x4b, 0x54, 0x69, 0x6d, 0x65, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x52,
+	0x65, 0x61, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x41, 0x67, 0x65, 0x6e,
+	0x74, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x42, 0x06, 0x0a, 0x04, 0x5f, 0x55, 0x49, 0x44, 0x42, 0x0c,
+	0x0a, 0x0a, 0x5f, 0x53, 0x79, 0x6e, 0x74, 0x68, 0x65, 0x74, 0x69, 0x63, 0x42, 0x06, 0x0a, 0x04,
+	0x5f, 0x54, 0x4f, 0x53, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x44, 0x53, 0x43, 0x50, 0x42, 0x0b, 0x0a,
+	0x09, 0x5f, 0x44, 0x53, 0x43, 0x50, 0x4e, 0x61, 0x6d, 0x65, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x52, 0x65, 0x74, 0x72, 0x61,
+	0x6e, 0x73, 0x54, 0x79, 0x70, 0x65, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x52, 0x65, 0x74, 0x72, 0x61,
+	0x6e, 0x73, 0x52, 0x61, 0x74, 0x65, 0x22, 0x1e, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x22, 0x30, 0x0a, 0x12, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f,
+	0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08,
+	0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x5c, 0x0a, 0x0f, 0x46, 0x6c, 0x6f, 0x77,
+	0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x48, 0x69, 0x6e, 0x74, 0x12, 0x31, 0x0a, 0x06, 0x61,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x19, 0x2e, 0x74, 0x63,
+	0x70, 0x64, 0x6f, 0x67, 0x2e, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c,
+	0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x16,
+	0x0a, 0x06, 0x66, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06,
+	0x66, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x22, 0x25, 0x0a, 0x0b, 0x54, 0x61, 0x69, 0x6c, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x22, 0x2f, 0x0a,
+	0x05, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x22,
+	0x0a, 0x0c, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12,
+	0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61,
+	0x6d, 0x65, 0x2a, 0x2e, 0x0a, 0x11, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f,
+	0x6c, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x0a, 0x0a, 0x06, 0x52, 0x45, 0x53, 0x55, 0x4d,
+	0x45, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x53, 0x4c, 0x4f, 0x57, 0x5f, 0x44, 0x4f, 0x57, 0x4e,
+	0x10, 0x01, 0x32, 0xbe, 0x01, 0x0a, 0x06, 0x54, 0x43, 0x50, 0x44, 0x6f, 0x67, 0x12, 0x32, 0x0a,
 	0x0a, 0x54, 0x72, 0x61, 0x63, 0x65, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x0e, 0x2e, 0x74, 0x63,
 	0x70, 0x64, 0x6f, 0x67, 0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x1a, 0x10, 0x2e, 0x74, 0x63,
 	0x70, 0x64, 0x6f, 0x67, 0x2e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x28,
 	0x01, 0x12, 0x38, 0x0a, 0x0d, 0x54, 0x72, 0x61, 0x63, 0x65, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x53,
 	0x50, 0x42, 0x12, 0x11, 0x2e, 0x74, 0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e, 0x46, 0x69, 0x65, 0x6c,
 	0x64, 0x73, 0x53, 0x50, 0x42, 0x1a, 0x10, 0x2e, 0x74, 0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e, 0x52,
-	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x28, 0x01, 0x62, 0x06, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x33,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x28, 0x01, 0x12, 0x46, 0x0a, 0x0b, 0x46,
+	0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x12, 0x1a, 0x2e, 0x74, 0x63, 0x70,
+	0x64, 0x6f, 0x67, 0x2e, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x74, 0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e,
+	0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x48, 0x69, 0x6e, 0x74, 0x22,
+	0x00, 0x30, 0x01, 0x32, 0x9d, 0x01, 0x0a, 0x05, 0x41, 0x64, 0x6d, 0x69, 0x6e, 0x12, 0x32, 0x0a,
+	0x04, 0x54, 0x61, 0x69, 0x6c, 0x12, 0x13, 0x2e, 0x74, 0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e, 0x54,
+	0x61, 0x69, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e, 0x74, 0x63, 0x70,
+	0x64, 0x6f, 0x67, 0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x53, 0x50, 0x42, 0x22, 0x00, 0x30,
+	0x01, 0x12, 0x2d, 0x0a, 0x08, 0x50, 0x75, 0x74, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x0d, 0x2e,
+	0x74, 0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65, 0x1a, 0x10, 0x2e, 0x74,
+	0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00,
+	0x12, 0x31, 0x0a, 0x08, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x14, 0x2e, 0x74,
+	0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x0d, 0x2e, 0x74, 0x63, 0x70, 0x64, 0x6f, 0x67, 0x2e, 0x53, 0x74, 0x61, 0x74,
+	0x65, 0x22, 0x00, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -859,24 +1469,40 @@ func file_tcpdog_proto_rawDescGZIP() []byte {
 	return file_tcpdog_proto_rawDescData
 }
 
-var file_tcpdog_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_tcpdog_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_tcpdog_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_tcpdog_proto_goTypes = []interface{}{
-	(*FieldsSPB)(nil),      // 0: tcpdog.FieldsSPB
-	(*Fields)(nil),         // 1: tcpdog.Fields
-	(*Response)(nil),       // 2: tcpdog.Response
-	(*_struct.Struct)(nil), // 3: google.protobuf.Struct
+	(FlowControlAction)(0),     // 0: tcpdog.FlowControlAction
+	(*FieldsSPB)(nil),          // 1: tcpdog.FieldsSPB
+	(*Fields)(nil),             // 2: tcpdog.Fields
+	(*Response)(nil),           // 3: tcpdog.Response
+	(*FlowControlRequest)(nil), // 4: tcpdog.FlowControlRequest
+	(*FlowControlHint)(nil),    // 5: tcpdog.FlowControlHint
+	(*TailRequest)(nil),        // 6: tcpdog.TailRequest
+	(*State)(nil),              // 7: tcpdog.State
+	(*StateRequest)(nil),       // 8: tcpdog.StateRequest
+	(*_struct.Struct)(nil),     // 9: google.protobuf.Struct
 }
 var file_tcpdog_proto_depIdxs = []int32{
-	3, // 0: tcpdog.FieldsSPB.fields:type_name -> google.protobuf.Struct
-	1, // 1: tcpdog.TCPDog.Tracepoint:input_type -> tcpdog.Fields
-	0, // 2: tcpdog.TCPDog.TracepointSPB:input_type -> tcpdog.FieldsSPB
-	2, // 3: tcpdog.TCPDog.Tracepoint:output_type -> tcpdog.Response
-	2, // 4: tcpdog.TCPDog.TracepointSPB:output_type -> tcpdog.Response
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	9, // 0: tcpdog.FieldsSPB.fields:type_name -> google.protobuf.Struct
+	0, // 1: tcpdog.FlowControlHint.action:type_name -> tcpdog.FlowControlAction
+	2, // 2: tcpdog.TCPDog.Tracepoint:input_type -> tcpdog.Fields
+	1, // 3: tcpdog.TCPDog.TracepointSPB:input_type -> tcpdog.FieldsSPB
+	4, // 4: tcpdog.TCPDog.FlowControl:input_type -> tcpdog.FlowControlRequest
+	6, // 5: tcpdog.Admin.Tail:input_type -> tcpdog.TailRequest
+	7, // 6: tcpdog.Admin.PutState:input_type -> tcpdog.State
+	8, // 7: tcpdog.Admin.GetState:input_type -> tcpdog.StateRequest
+	3, // 8: tcpdog.TCPDog.Tracepoint:output_type -> tcpdog.Response
+	3, // 9: tcpdog.TCPDog.TracepointSPB:output_type -> tcpdog.Response
+	5, // 10: tcpdog.TCPDog.FlowControl:output_type -> tcpdog.FlowControlHint
+	1, // 11: tcpdog.Admin.Tail:output_type -> tcpdog.FieldsSPB
+	3, // 12: tcpdog.Admin.PutState:output_type -> tcpdog.Response
+	7, // 13: tcpdog.Admin.GetState:output_type -> tcpdog.State
+	8, // [8:14] is the sub-list for method output_type
+	2, // [2:8] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_tcpdog_proto_init() }
@@ -921,6 +1547,66 @@ func file_tcpdog_proto_init() {
 				return nil
 			}
 		}
+		file_tcpdog_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FlowControlRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tcpdog_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FlowControlHint); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tcpdog_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TailRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tcpdog_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*State); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_tcpdog_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StateRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	file_tcpdog_proto_msgTypes[1].OneofWrappers = []interface{}{}
 	type x struct{}
@@ -928,13 +1614,14 @@ func file_tcpdog_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_tcpdog_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      1,
+			NumMessages:   8,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_tcpdog_proto_goTypes,
 		DependencyIndexes: file_tcpdog_proto_depIdxs,
+		EnumInfos:         file_tcpdog_proto_enumTypes,
 		MessageInfos:      file_tcpdog_proto_msgTypes,
 	}.Build()
 	File_tcpdog_proto = out.File
@@ -957,6 +1644,7 @@ const _ = grpc.SupportPackageIsVersion6
 type TCPDogClient interface {
 	Tracepoint(ctx context.Context, opts ...grpc.CallOption) (TCPDog_TracepointClient, error)
 	TracepointSPB(ctx context.Context, opts ...grpc.CallOption) (TCPDog_TracepointSPBClient, error)
+	FlowControl(ctx context.Context, in *FlowControlRequest, opts ...grpc.CallOption) (TCPDog_FlowControlClient, error)
 }
 
 type tCPDogClient struct {
@@ -1035,10 +1723,43 @@ func (x *tCPDogTracepointSPBClient) CloseAndRecv() (*Response, error) {
 	return m, nil
 }
 
+func (c *tCPDogClient) FlowControl(ctx context.Context, in *FlowControlRequest, opts ...grpc.CallOption) (TCPDog_FlowControlClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_TCPDog_serviceDesc.Streams[2], "/tcpdog.TCPDog/FlowControl", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tCPDogFlowControlClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TCPDog_FlowControlClient interface {
+	Recv() (*FlowControlHint, error)
+	grpc.ClientStream
+}
+
+type tCPDogFlowControlClient struct {
+	grpc.ClientStream
+}
+
+func (x *tCPDogFlowControlClient) Recv() (*FlowControlHint, error) {
+	m := new(FlowControlHint)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // TCPDogServer is the server API for TCPDog service.
 type TCPDogServer interface {
 	Tracepoint(TCPDog_TracepointServer) error
 	TracepointSPB(TCPDog_TracepointSPBServer) error
+	FlowControl(*FlowControlRequest, TCPDog_FlowControlServer) error
 }
 
 // UnimplementedTCPDogServer can be embedded to have forward compatible implementations.
@@ -1051,6 +1772,9 @@ func (*UnimplementedTCPDogServer) Tracepoint(TCPDog_TracepointServer) error {
 func (*UnimplementedTCPDogServer) TracepointSPB(TCPDog_TracepointSPBServer) error {
 	return status.Errorf(codes.Unimplemented, "method TracepointSPB not implemented")
 }
+func (*UnimplementedTCPDogServer) FlowControl(*FlowControlRequest, TCPDog_FlowControlServer) error {
+	return status.Errorf(codes.Unimplemented, "method FlowControl not implemented")
+}
 
 func RegisterTCPDogServer(s *grpc.Server, srv TCPDogServer) {
 	s.RegisterService(&_TCPDog_serviceDesc, srv)
@@ -1108,6 +1832,27 @@ func (x *tCPDogTracepointSPBServer) Recv() (*FieldsSPB, error) {
 	return m, nil
 }
 
+func _TCPDog_FlowControl_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FlowControlRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TCPDogServer).FlowControl(m, &tCPDogFlowControlServer{stream})
+}
+
+type TCPDog_FlowControlServer interface {
+	Send(*FlowControlHint) error
+	grpc.ServerStream
+}
+
+type tCPDogFlowControlServer struct {
+	grpc.ServerStream
+}
+
+func (x *tCPDogFlowControlServer) Send(m *FlowControlHint) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _TCPDog_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "tcpdog.TCPDog",
 	HandlerType: (*TCPDogServer)(nil),
@@ -1123,6 +1868,183 @@ var _TCPDog_serviceDesc = grpc.ServiceDesc{
 			Handler:       _TCPDog_TracepointSPB_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "FlowControl",
+			Handler:       _TCPDog_FlowControl_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tcpdog.proto",
+}
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AdminClient interface {
+	Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (Admin_TailClient, error)
+	PutState(ctx context.Context, in *State, opts ...grpc.CallOption) (*Response, error)
+	GetState(ctx context.Context, in *StateRequest, opts ...grpc.CallOption) (*State, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (Admin_TailClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Admin_serviceDesc.Streams[0], "/tcpdog.Admin/Tail", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminTailClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_TailClient interface {
+	Recv() (*FieldsSPB, error)
+	grpc.ClientStream
+}
+
+type adminTailClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminTailClient) Recv() (*FieldsSPB, error) {
+	m := new(FieldsSPB)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) PutState(ctx context.Context, in *State, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/tcpdog.Admin/PutState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetState(ctx context.Context, in *StateRequest, opts ...grpc.CallOption) (*State, error) {
+	out := new(State)
+	err := c.cc.Invoke(ctx, "/tcpdog.Admin/GetState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+type AdminServer interface {
+	Tail(*TailRequest, Admin_TailServer) error
+	PutState(context.Context, *State) (*Response, error)
+	GetState(context.Context, *StateRequest) (*State, error)
+}
+
+// UnimplementedAdminServer can be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (*UnimplementedAdminServer) Tail(*TailRequest, Admin_TailServer) error {
+	return status.Errorf(codes.Unimplemented, "method Tail not implemented")
+}
+func (*UnimplementedAdminServer) PutState(context.Context, *State) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutState not implemented")
+}
+func (*UnimplementedAdminServer) GetState(context.Context, *StateRequest) (*State, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetState not implemented")
+}
+
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
+
+func _Admin_Tail_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).Tail(m, &adminTailServer{stream})
+}
+
+type Admin_TailServer interface {
+	Send(*FieldsSPB) error
+	grpc.ServerStream
+}
+
+type adminTailServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminTailServer) Send(m *FieldsSPB) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_PutState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(State)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).PutState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tcpdog.Admin/PutState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).PutState(ctx, req.(*State))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tcpdog.Admin/GetState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetState(ctx, req.(*StateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "tcpdog.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PutState",
+			Handler:    _Admin_PutState_Handler,
+		},
+		{
+			MethodName: "GetState",
+			Handler:    _Admin_GetState_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Tail",
+			Handler:       _Admin_Tail_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "tcpdog.proto",
 }
@@ -0,0 +1,1767 @@
+// Code generated by vtgen. DO NOT EDIT.
+
+//go:build !novtproto
+// +build !novtproto
+
+package tcpdog
+
+import (
+	"errors"
+	"io"
+	"math"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var errInvalidUTF8 = errors.New("proto: string field contains invalid UTF-8")
+
+// fieldsValues backs the decoded Fields values
+type fieldsValues struct {
+	str [18]string
+	u32 [59]uint32
+	u64 [8]uint64
+	b   [1]bool
+	f64 [1]float64
+}
+
+// SizeVT returns the encoded size of the message
+func (m *Fields) SizeVT() (n int) {
+	if m == nil {
+		return 0
+	}
+	if m.Task != nil {
+		n += 1 + protowire.SizeBytes(len(*m.Task))
+	}
+	if m.PID != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.PID))
+	}
+	if m.TCPHeaderLen != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.TCPHeaderLen))
+	}
+	if m.TotalRetrans != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.TotalRetrans))
+	}
+	if m.SAddr != nil {
+		n += 1 + protowire.SizeBytes(len(*m.SAddr))
+	}
+	if m.DAddr != nil {
+		n += 1 + protowire.SizeBytes(len(*m.DAddr))
+	}
+	if m.DPort != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.DPort))
+	}
+	if m.LPort != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.LPort))
+	}
+	if m.BytesReceived != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.BytesReceived))
+	}
+	if m.BytesSent != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.BytesSent))
+	}
+	if m.BytesAcked != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.BytesAcked))
+	}
+	if m.NumSAcks != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.NumSAcks))
+	}
+	if m.UserMSS != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.UserMSS))
+	}
+	if m.MSSClamp != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.MSSClamp))
+	}
+	if m.AdvMSS != nil {
+		n += 1 + protowire.SizeVarint(uint64(*m.AdvMSS))
+	}
+	if m.RTT != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RTT))
+	}
+	if m.SRTT != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SRTT))
+	}
+	if m.RTTVar != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RTTVar))
+	}
+	if m.RcvRTT != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RcvRTT))
+	}
+	if m.RACKRTT != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RACKRTT))
+	}
+	if m.MDev != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.MDev))
+	}
+	if m.MDevMax != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.MDevMax))
+	}
+	if m.SegsIn != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SegsIn))
+	}
+	if m.SegsOut != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SegsOut))
+	}
+	if m.GSOSegs != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.GSOSegs))
+	}
+	if m.DataSegsIn != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.DataSegsIn))
+	}
+	if m.MaxWindow != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.MaxWindow))
+	}
+	if m.SndWnd != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SndWnd))
+	}
+	if m.WindowClamp != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.WindowClamp))
+	}
+	if m.RcvSSThresh != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RcvSSThresh))
+	}
+	if m.ECNFlags != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.ECNFlags))
+	}
+	if m.SndCwnd != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SndCwnd))
+	}
+	if m.PrrOut != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.PrrOut))
+	}
+	if m.Delivered != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.Delivered))
+	}
+	if m.DeliveredCe != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.DeliveredCe))
+	}
+	if m.Lost != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.Lost))
+	}
+	if m.LostOut != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.LostOut))
+	}
+	if m.PriorSSThresh != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.PriorSSThresh))
+	}
+	if m.DataSegsOut != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.DataSegsOut))
+	}
+	if m.RcvSpace != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RcvSpace))
+	}
+	if m.UnAcked != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.UnAcked))
+	}
+	if m.SAcked != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SAcked))
+	}
+	if m.RTO != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RTO))
+	}
+	if m.DsackDups != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.DsackDups))
+	}
+	if m.RateDelivered != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RateDelivered))
+	}
+	if m.RateInterval != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RateInterval))
+	}
+	if m.SndSSThresh != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SndSSThresh))
+	}
+	if m.PacketsOut != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.PacketsOut))
+	}
+	if m.RetransOut != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RetransOut))
+	}
+	if m.MaxPacketsOut != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.MaxPacketsOut))
+	}
+	if m.MaxPacketsSeq != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.MaxPacketsSeq))
+	}
+	if m.GeoLocation != nil {
+		n += 2 + protowire.SizeBytes(len(*m.GeoLocation))
+	}
+	if m.CCode != nil {
+		n += 2 + protowire.SizeBytes(len(*m.CCode))
+	}
+	if m.CSCode != nil {
+		n += 2 + protowire.SizeBytes(len(*m.CSCode))
+	}
+	if m.Country != nil {
+		n += 2 + protowire.SizeBytes(len(*m.Country))
+	}
+	if m.City != nil {
+		n += 2 + protowire.SizeBytes(len(*m.City))
+	}
+	if m.Region != nil {
+		n += 2 + protowire.SizeBytes(len(*m.Region))
+	}
+	if m.ASN != nil {
+		n += 2 + protowire.SizeBytes(len(*m.ASN))
+	}
+	if m.ASNOrg != nil {
+		n += 2 + protowire.SizeBytes(len(*m.ASNOrg))
+	}
+	if m.Hostname != nil {
+		n += 2 + protowire.SizeBytes(len(*m.Hostname))
+	}
+	if m.Timestamp != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.Timestamp))
+	}
+	if m.InitCwnd != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.InitCwnd))
+	}
+	if m.Cwnd != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.Cwnd))
+	}
+	if m.CloseReason != nil {
+		n += 2 + protowire.SizeBytes(len(*m.CloseReason))
+	}
+	if m.SampleWeight != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SampleWeight))
+	}
+	if m.FailReason != nil {
+		n += 2 + protowire.SizeBytes(len(*m.FailReason))
+	}
+	if m.VRF != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.VRF))
+	}
+	if m.VRFName != nil {
+		n += 2 + protowire.SizeBytes(len(*m.VRFName))
+	}
+	if m.SynRetrans != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.SynRetrans))
+	}
+	if m.Count != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.Count))
+	}
+	if m.Window != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.Window))
+	}
+	if m.FlowLabel != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.FlowLabel))
+	}
+	if m.TClass != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.TClass))
+	}
+	if m.TSRTT != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.TSRTT))
+	}
+	if m.RxQueue != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.RxQueue))
+	}
+	if m.TxQueue != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.TxQueue))
+	}
+	if m.KTime != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.KTime))
+	}
+	if m.ReadTime != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.ReadTime))
+	}
+	if m.AgentDelay != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.AgentDelay))
+	}
+	if m.UID != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.UID))
+	}
+	if m.Synthetic != nil {
+		n += 3
+	}
+	if m.TOS != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.TOS))
+	}
+	if m.DSCP != nil {
+		n += 2 + protowire.SizeVarint(uint64(*m.DSCP))
+	}
+	if m.DSCPName != nil {
+		n += 2 + protowire.SizeBytes(len(*m.DSCPName))
+	}
+	if m.EventID != nil {
+		n += 2 + protowire.SizeBytes(len(*m.EventID))
+	}
+	if m.RetransType != nil {
+		n += 2 + protowire.SizeBytes(len(*m.RetransType))
+	}
+	if m.RetransRate != nil {
+		n += 10
+	}
+	return n + len(m.unknownFields)
+}
+
+// MarshalVT encodes the message
+func (m *Fields) MarshalVT() ([]byte, error) {
+	return m.AppendVT(make([]byte, 0, m.SizeVT()))
+}
+
+// MarshalToSizedBufferVT encodes the message to the end of b and returns
+// the encoded size, b is sized by the SizeVT.
+func (m *Fields) MarshalToSizedBufferVT(b []byte) (int, error) {
+	i := len(b) - m.SizeVT()
+	if i < 0 {
+		return 0, io.ErrShortBuffer
+	}
+
+	e, err := m.AppendVT(b[i:i])
+	return len(e), err
+}
+
+// AppendVT appends the encoded message to b, e.g. a pooled buffer
+func (m *Fields) AppendVT(b []byte) ([]byte, error) {
+	if m == nil {
+		return b, nil
+	}
+	if m.Task != nil {
+		if !utf8.ValidString(*m.Task) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 10)
+		b = protowire.AppendString(b, *m.Task)
+	}
+	if m.PID != nil {
+		b = protowire.AppendVarint(b, 16)
+		b = protowire.AppendVarint(b, uint64(*m.PID))
+	}
+	if m.TCPHeaderLen != nil {
+		b = protowire.AppendVarint(b, 24)
+		b = protowire.AppendVarint(b, uint64(*m.TCPHeaderLen))
+	}
+	if m.TotalRetrans != nil {
+		b = protowire.AppendVarint(b, 32)
+		b = protowire.AppendVarint(b, uint64(*m.TotalRetrans))
+	}
+	if m.SAddr != nil {
+		if !utf8.ValidString(*m.SAddr) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 42)
+		b = protowire.AppendString(b, *m.SAddr)
+	}
+	if m.DAddr != nil {
+		if !utf8.ValidString(*m.DAddr) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 50)
+		b = protowire.AppendString(b, *m.DAddr)
+	}
+	if m.DPort != nil {
+		b = protowire.AppendVarint(b, 56)
+		b = protowire.AppendVarint(b, uint64(*m.DPort))
+	}
+	if m.LPort != nil {
+		b = protowire.AppendVarint(b, 64)
+		b = protowire.AppendVarint(b, uint64(*m.LPort))
+	}
+	if m.BytesReceived != nil {
+		b = protowire.AppendVarint(b, 72)
+		b = protowire.AppendVarint(b, uint64(*m.BytesReceived))
+	}
+	if m.BytesSent != nil {
+		b = protowire.AppendVarint(b, 80)
+		b = protowire.AppendVarint(b, uint64(*m.BytesSent))
+	}
+	if m.BytesAcked != nil {
+		b = protowire.AppendVarint(b, 88)
+		b = protowire.AppendVarint(b, uint64(*m.BytesAcked))
+	}
+	if m.NumSAcks != nil {
+		b = protowire.AppendVarint(b, 96)
+		b = protowire.AppendVarint(b, uint64(*m.NumSAcks))
+	}
+	if m.UserMSS != nil {
+		b = protowire.AppendVarint(b, 104)
+		b = protowire.AppendVarint(b, uint64(*m.UserMSS))
+	}
+	if m.MSSClamp != nil {
+		b = protowire.AppendVarint(b, 112)
+		b = protowire.AppendVarint(b, uint64(*m.MSSClamp))
+	}
+	if m.AdvMSS != nil {
+		b = protowire.AppendVarint(b, 120)
+		b = protowire.AppendVarint(b, uint64(*m.AdvMSS))
+	}
+	if m.RTT != nil {
+		b = protowire.AppendVarint(b, 128)
+		b = protowire.AppendVarint(b, uint64(*m.RTT))
+	}
+	if m.SRTT != nil {
+		b = protowire.AppendVarint(b, 136)
+		b = protowire.AppendVarint(b, uint64(*m.SRTT))
+	}
+	if m.RTTVar != nil {
+		b = protowire.AppendVarint(b, 144)
+		b = protowire.AppendVarint(b, uint64(*m.RTTVar))
+	}
+	if m.RcvRTT != nil {
+		b = protowire.AppendVarint(b, 152)
+		b = protowire.AppendVarint(b, uint64(*m.RcvRTT))
+	}
+	if m.RACKRTT != nil {
+		b = protowire.AppendVarint(b, 160)
+		b = protowire.AppendVarint(b, uint64(*m.RACKRTT))
+	}
+	if m.MDev != nil {
+		b = protowire.AppendVarint(b, 168)
+		b = protowire.AppendVarint(b, uint64(*m.MDev))
+	}
+	if m.MDevMax != nil {
+		b = protowire.AppendVarint(b, 176)
+		b = protowire.AppendVarint(b, uint64(*m.MDevMax))
+	}
+	if m.SegsIn != nil {
+		b = protowire.AppendVarint(b, 184)
+		b = protowire.AppendVarint(b, uint64(*m.SegsIn))
+	}
+	if m.SegsOut != nil {
+		b = protowire.AppendVarint(b, 192)
+		b = protowire.AppendVarint(b, uint64(*m.SegsOut))
+	}
+	if m.GSOSegs != nil {
+		b = protowire.AppendVarint(b, 200)
+		b = protowire.AppendVarint(b, uint64(*m.GSOSegs))
+	}
+	if m.DataSegsIn != nil {
+		b = protowire.AppendVarint(b, 208)
+		b = protowire.AppendVarint(b, uint64(*m.DataSegsIn))
+	}
+	if m.MaxWindow != nil {
+		b = protowire.AppendVarint(b, 216)
+		b = protowire.AppendVarint(b, uint64(*m.MaxWindow))
+	}
+	if m.SndWnd != nil {
+		b = protowire.AppendVarint(b, 224)
+		b = protowire.AppendVarint(b, uint64(*m.SndWnd))
+	}
+	if m.WindowClamp != nil {
+		b = protowire.AppendVarint(b, 232)
+		b = protowire.AppendVarint(b, uint64(*m.WindowClamp))
+	}
+	if m.RcvSSThresh != nil {
+		b = protowire.AppendVarint(b, 240)
+		b = protowire.AppendVarint(b, uint64(*m.RcvSSThresh))
+	}
+	if m.ECNFlags != nil {
+		b = protowire.AppendVarint(b, 248)
+		b = protowire.AppendVarint(b, uint64(*m.ECNFlags))
+	}
+	if m.SndCwnd != nil {
+		b = protowire.AppendVarint(b, 256)
+		b = protowire.AppendVarint(b, uint64(*m.SndCwnd))
+	}
+	if m.PrrOut != nil {
+		b = protowire.AppendVarint(b, 264)
+		b = protowire.AppendVarint(b, uint64(*m.PrrOut))
+	}
+	if m.Delivered != nil {
+		b = protowire.AppendVarint(b, 272)
+		b = protowire.AppendVarint(b, uint64(*m.Delivered))
+	}
+	if m.DeliveredCe != nil {
+		b = protowire.AppendVarint(b, 280)
+		b = protowire.AppendVarint(b, uint64(*m.DeliveredCe))
+	}
+	if m.Lost != nil {
+		b = protowire.AppendVarint(b, 288)
+		b = protowire.AppendVarint(b, uint64(*m.Lost))
+	}
+	if m.LostOut != nil {
+		b = protowire.AppendVarint(b, 296)
+		b = protowire.AppendVarint(b, uint64(*m.LostOut))
+	}
+	if m.PriorSSThresh != nil {
+		b = protowire.AppendVarint(b, 304)
+		b = protowire.AppendVarint(b, uint64(*m.PriorSSThresh))
+	}
+	if m.DataSegsOut != nil {
+		b = protowire.AppendVarint(b, 312)
+		b = protowire.AppendVarint(b, uint64(*m.DataSegsOut))
+	}
+	if m.RcvSpace != nil {
+		b = protowire.AppendVarint(b, 320)
+		b = protowire.AppendVarint(b, uint64(*m.RcvSpace))
+	}
+	if m.UnAcked != nil {
+		b = protowire.AppendVarint(b, 328)
+		b = protowire.AppendVarint(b, uint64(*m.UnAcked))
+	}
+	if m.SAcked != nil {
+		b = protowire.AppendVarint(b, 336)
+		b = protowire.AppendVarint(b, uint64(*m.SAcked))
+	}
+	if m.RTO != nil {
+		b = protowire.AppendVarint(b, 344)
+		b = protowire.AppendVarint(b, uint64(*m.RTO))
+	}
+	if m.DsackDups != nil {
+		b = protowire.AppendVarint(b, 352)
+		b = protowire.AppendVarint(b, uint64(*m.DsackDups))
+	}
+	if m.RateDelivered != nil {
+		b = protowire.AppendVarint(b, 360)
+		b = protowire.AppendVarint(b, uint64(*m.RateDelivered))
+	}
+	if m.RateInterval != nil {
+		b = protowire.AppendVarint(b, 368)
+		b = protowire.AppendVarint(b, uint64(*m.RateInterval))
+	}
+	if m.SndSSThresh != nil {
+		b = protowire.AppendVarint(b, 376)
+		b = protowire.AppendVarint(b, uint64(*m.SndSSThresh))
+	}
+	if m.PacketsOut != nil {
+		b = protowire.AppendVarint(b, 384)
+		b = protowire.AppendVarint(b, uint64(*m.PacketsOut))
+	}
+	if m.RetransOut != nil {
+		b = protowire.AppendVarint(b, 392)
+		b = protowire.AppendVarint(b, uint64(*m.RetransOut))
+	}
+	if m.MaxPacketsOut != nil {
+		b = protowire.AppendVarint(b, 400)
+		b = protowire.AppendVarint(b, uint64(*m.MaxPacketsOut))
+	}
+	if m.MaxPacketsSeq != nil {
+		b = protowire.AppendVarint(b, 408)
+		b = protowire.AppendVarint(b, uint64(*m.MaxPacketsSeq))
+	}
+	if m.GeoLocation != nil {
+		if !utf8.ValidString(*m.GeoLocation) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 418)
+		b = protowire.AppendString(b, *m.GeoLocation)
+	}
+	if m.CCode != nil {
+		if !utf8.ValidString(*m.CCode) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 426)
+		b = protowire.AppendString(b, *m.CCode)
+	}
+	if m.CSCode != nil {
+		if !utf8.ValidString(*m.CSCode) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 434)
+		b = protowire.AppendString(b, *m.CSCode)
+	}
+	if m.Country != nil {
+		if !utf8.ValidString(*m.Country) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 442)
+		b = protowire.AppendString(b, *m.Country)
+	}
+	if m.City != nil {
+		if !utf8.ValidString(*m.City) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 450)
+		b = protowire.AppendString(b, *m.City)
+	}
+	if m.Region != nil {
+		if !utf8.ValidString(*m.Region) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 458)
+		b = protowire.AppendString(b, *m.Region)
+	}
+	if m.ASN != nil {
+		if !utf8.ValidString(*m.ASN) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 466)
+		b = protowire.AppendString(b, *m.ASN)
+	}
+	if m.ASNOrg != nil {
+		if !utf8.ValidString(*m.ASNOrg) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 474)
+		b = protowire.AppendString(b, *m.ASNOrg)
+	}
+	if m.Hostname != nil {
+		if !utf8.ValidString(*m.Hostname) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 482)
+		b = protowire.AppendString(b, *m.Hostname)
+	}
+	if m.Timestamp != nil {
+		b = protowire.AppendVarint(b, 488)
+		b = protowire.AppendVarint(b, uint64(*m.Timestamp))
+	}
+	if m.InitCwnd != nil {
+		b = protowire.AppendVarint(b, 496)
+		b = protowire.AppendVarint(b, uint64(*m.InitCwnd))
+	}
+	if m.Cwnd != nil {
+		b = protowire.AppendVarint(b, 504)
+		b = protowire.AppendVarint(b, uint64(*m.Cwnd))
+	}
+	if m.CloseReason != nil {
+		if !utf8.ValidString(*m.CloseReason) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 514)
+		b = protowire.AppendString(b, *m.CloseReason)
+	}
+	if m.SampleWeight != nil {
+		b = protowire.AppendVarint(b, 520)
+		b = protowire.AppendVarint(b, uint64(*m.SampleWeight))
+	}
+	if m.FailReason != nil {
+		if !utf8.ValidString(*m.FailReason) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 530)
+		b = protowire.AppendString(b, *m.FailReason)
+	}
+	if m.VRF != nil {
+		b = protowire.AppendVarint(b, 536)
+		b = protowire.AppendVarint(b, uint64(*m.VRF))
+	}
+	if m.VRFName != nil {
+		if !utf8.ValidString(*m.VRFName) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 546)
+		b = protowire.AppendString(b, *m.VRFName)
+	}
+	if m.SynRetrans != nil {
+		b = protowire.AppendVarint(b, 552)
+		b = protowire.AppendVarint(b, uint64(*m.SynRetrans))
+	}
+	if m.Count != nil {
+		b = protowire.AppendVarint(b, 560)
+		b = protowire.AppendVarint(b, uint64(*m.Count))
+	}
+	if m.Window != nil {
+		b = protowire.AppendVarint(b, 568)
+		b = protowire.AppendVarint(b, uint64(*m.Window))
+	}
+	if m.FlowLabel != nil {
+		b = protowire.AppendVarint(b, 576)
+		b = protowire.AppendVarint(b, uint64(*m.FlowLabel))
+	}
+	if m.TClass != nil {
+		b = protowire.AppendVarint(b, 584)
+		b = protowire.AppendVarint(b, uint64(*m.TClass))
+	}
+	if m.TSRTT != nil {
+		b = protowire.AppendVarint(b, 592)
+		b = protowire.AppendVarint(b, uint64(*m.TSRTT))
+	}
+	if m.RxQueue != nil {
+		b = protowire.AppendVarint(b, 600)
+		b = protowire.AppendVarint(b, uint64(*m.RxQueue))
+	}
+	if m.TxQueue != nil {
+		b = protowire.AppendVarint(b, 608)
+		b = protowire.AppendVarint(b, uint64(*m.TxQueue))
+	}
+	if m.KTime != nil {
+		b = protowire.AppendVarint(b, 616)
+		b = protowire.AppendVarint(b, uint64(*m.KTime))
+	}
+	if m.ReadTime != nil {
+		b = protowire.AppendVarint(b, 624)
+		b = protowire.AppendVarint(b, uint64(*m.ReadTime))
+	}
+	if m.AgentDelay != nil {
+		b = protowire.AppendVarint(b, 632)
+		b = protowire.AppendVarint(b, uint64(*m.AgentDelay))
+	}
+	if m.UID != nil {
+		b = protowire.AppendVarint(b, 640)
+		b = protowire.AppendVarint(b, uint64(*m.UID))
+	}
+	if m.Synthetic != nil {
+		b = protowire.AppendVarint(b, 648)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(*m.Synthetic))
+	}
+	if m.TOS != nil {
+		b = protowire.AppendVarint(b, 656)
+		b = protowire.AppendVarint(b, uint64(*m.TOS))
+	}
+	if m.DSCP != nil {
+		b = protowire.AppendVarint(b, 664)
+		b = protowire.AppendVarint(b, uint64(*m.DSCP))
+	}
+	if m.DSCPName != nil {
+		if !utf8.ValidString(*m.DSCPName) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 674)
+		b = protowire.AppendString(b, *m.DSCPName)
+	}
+	if m.EventID != nil {
+		if !utf8.ValidString(*m.EventID) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 682)
+		b = protowire.AppendString(b, *m.EventID)
+	}
+	if m.RetransType != nil {
+		if !utf8.ValidString(*m.RetransType) {
+			return b, errInvalidUTF8
+		}
+		b = protowire.AppendVarint(b, 690)
+		b = protowire.AppendString(b, *m.RetransType)
+	}
+	if m.RetransRate != nil {
+		b = protowire.AppendVarint(b, 697)
+		b = protowire.AppendFixed64(b, math.Float64bits(*m.RetransRate))
+	}
+	return append(b, m.unknownFields...), nil
+}
+
+// UnmarshalVT decodes the message, the fields of the wrong
+// wire type are kept as the unknown fields.
+func (m *Fields) UnmarshalVT(b []byte) error {
+	m.Reset()
+
+	if len(b) < 1 {
+		return nil
+	}
+
+	vals := &fieldsValues{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		tag := b[:n]
+		b = b[n:]
+
+		switch num {
+		case 1:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[0] = v
+				m.Task = &vals.str[0]
+				b = b[n:]
+				continue
+			}
+		case 2:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[0] = uint32(v)
+				m.PID = &vals.u32[0]
+				b = b[n:]
+				continue
+			}
+		case 3:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[1] = uint32(v)
+				m.TCPHeaderLen = &vals.u32[1]
+				b = b[n:]
+				continue
+			}
+		case 4:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[2] = uint32(v)
+				m.TotalRetrans = &vals.u32[2]
+				b = b[n:]
+				continue
+			}
+		case 5:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[1] = v
+				m.SAddr = &vals.str[1]
+				b = b[n:]
+				continue
+			}
+		case 6:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[2] = v
+				m.DAddr = &vals.str[2]
+				b = b[n:]
+				continue
+			}
+		case 7:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[3] = uint32(v)
+				m.DPort = &vals.u32[3]
+				b = b[n:]
+				continue
+			}
+		case 8:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[4] = uint32(v)
+				m.LPort = &vals.u32[4]
+				b = b[n:]
+				continue
+			}
+		case 9:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[0] = v
+				m.BytesReceived = &vals.u64[0]
+				b = b[n:]
+				continue
+			}
+		case 10:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[1] = v
+				m.BytesSent = &vals.u64[1]
+				b = b[n:]
+				continue
+			}
+		case 11:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[2] = v
+				m.BytesAcked = &vals.u64[2]
+				b = b[n:]
+				continue
+			}
+		case 12:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[5] = uint32(v)
+				m.NumSAcks = &vals.u32[5]
+				b = b[n:]
+				continue
+			}
+		case 13:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[6] = uint32(v)
+				m.UserMSS = &vals.u32[6]
+				b = b[n:]
+				continue
+			}
+		case 14:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[7] = uint32(v)
+				m.MSSClamp = &vals.u32[7]
+				b = b[n:]
+				continue
+			}
+		case 15:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[8] = uint32(v)
+				m.AdvMSS = &vals.u32[8]
+				b = b[n:]
+				continue
+			}
+		case 16:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[9] = uint32(v)
+				m.RTT = &vals.u32[9]
+				b = b[n:]
+				continue
+			}
+		case 17:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[10] = uint32(v)
+				m.SRTT = &vals.u32[10]
+				b = b[n:]
+				continue
+			}
+		case 18:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[11] = uint32(v)
+				m.RTTVar = &vals.u32[11]
+				b = b[n:]
+				continue
+			}
+		case 19:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[12] = uint32(v)
+				m.RcvRTT = &vals.u32[12]
+				b = b[n:]
+				continue
+			}
+		case 20:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[13] = uint32(v)
+				m.RACKRTT = &vals.u32[13]
+				b = b[n:]
+				continue
+			}
+		case 21:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[14] = uint32(v)
+				m.MDev = &vals.u32[14]
+				b = b[n:]
+				continue
+			}
+		case 22:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[15] = uint32(v)
+				m.MDevMax = &vals.u32[15]
+				b = b[n:]
+				continue
+			}
+		case 23:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[16] = uint32(v)
+				m.SegsIn = &vals.u32[16]
+				b = b[n:]
+				continue
+			}
+		case 24:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[17] = uint32(v)
+				m.SegsOut = &vals.u32[17]
+				b = b[n:]
+				continue
+			}
+		case 25:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[18] = uint32(v)
+				m.GSOSegs = &vals.u32[18]
+				b = b[n:]
+				continue
+			}
+		case 26:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[19] = uint32(v)
+				m.DataSegsIn = &vals.u32[19]
+				b = b[n:]
+				continue
+			}
+		case 27:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[20] = uint32(v)
+				m.MaxWindow = &vals.u32[20]
+				b = b[n:]
+				continue
+			}
+		case 28:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[21] = uint32(v)
+				m.SndWnd = &vals.u32[21]
+				b = b[n:]
+				continue
+			}
+		case 29:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[22] = uint32(v)
+				m.WindowClamp = &vals.u32[22]
+				b = b[n:]
+				continue
+			}
+		case 30:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[23] = uint32(v)
+				m.RcvSSThresh = &vals.u32[23]
+				b = b[n:]
+				continue
+			}
+		case 31:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[24] = uint32(v)
+				m.ECNFlags = &vals.u32[24]
+				b = b[n:]
+				continue
+			}
+		case 32:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[25] = uint32(v)
+				m.SndCwnd = &vals.u32[25]
+				b = b[n:]
+				continue
+			}
+		case 33:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[26] = uint32(v)
+				m.PrrOut = &vals.u32[26]
+				b = b[n:]
+				continue
+			}
+		case 34:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[27] = uint32(v)
+				m.Delivered = &vals.u32[27]
+				b = b[n:]
+				continue
+			}
+		case 35:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[28] = uint32(v)
+				m.DeliveredCe = &vals.u32[28]
+				b = b[n:]
+				continue
+			}
+		case 36:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[29] = uint32(v)
+				m.Lost = &vals.u32[29]
+				b = b[n:]
+				continue
+			}
+		case 37:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[30] = uint32(v)
+				m.LostOut = &vals.u32[30]
+				b = b[n:]
+				continue
+			}
+		case 38:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[31] = uint32(v)
+				m.PriorSSThresh = &vals.u32[31]
+				b = b[n:]
+				continue
+			}
+		case 39:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[32] = uint32(v)
+				m.DataSegsOut = &vals.u32[32]
+				b = b[n:]
+				continue
+			}
+		case 40:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[33] = uint32(v)
+				m.RcvSpace = &vals.u32[33]
+				b = b[n:]
+				continue
+			}
+		case 41:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[34] = uint32(v)
+				m.UnAcked = &vals.u32[34]
+				b = b[n:]
+				continue
+			}
+		case 42:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[35] = uint32(v)
+				m.SAcked = &vals.u32[35]
+				b = b[n:]
+				continue
+			}
+		case 43:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[36] = uint32(v)
+				m.RTO = &vals.u32[36]
+				b = b[n:]
+				continue
+			}
+		case 44:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[37] = uint32(v)
+				m.DsackDups = &vals.u32[37]
+				b = b[n:]
+				continue
+			}
+		case 45:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[38] = uint32(v)
+				m.RateDelivered = &vals.u32[38]
+				b = b[n:]
+				continue
+			}
+		case 46:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[39] = uint32(v)
+				m.RateInterval = &vals.u32[39]
+				b = b[n:]
+				continue
+			}
+		case 47:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[40] = uint32(v)
+				m.SndSSThresh = &vals.u32[40]
+				b = b[n:]
+				continue
+			}
+		case 48:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[41] = uint32(v)
+				m.PacketsOut = &vals.u32[41]
+				b = b[n:]
+				continue
+			}
+		case 49:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[42] = uint32(v)
+				m.RetransOut = &vals.u32[42]
+				b = b[n:]
+				continue
+			}
+		case 50:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[43] = uint32(v)
+				m.MaxPacketsOut = &vals.u32[43]
+				b = b[n:]
+				continue
+			}
+		case 51:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[44] = uint32(v)
+				m.MaxPacketsSeq = &vals.u32[44]
+				b = b[n:]
+				continue
+			}
+		case 52:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[3] = v
+				m.GeoLocation = &vals.str[3]
+				b = b[n:]
+				continue
+			}
+		case 53:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[4] = v
+				m.CCode = &vals.str[4]
+				b = b[n:]
+				continue
+			}
+		case 54:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[5] = v
+				m.CSCode = &vals.str[5]
+				b = b[n:]
+				continue
+			}
+		case 55:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[6] = v
+				m.Country = &vals.str[6]
+				b = b[n:]
+				continue
+			}
+		case 56:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[7] = v
+				m.City = &vals.str[7]
+				b = b[n:]
+				continue
+			}
+		case 57:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[8] = v
+				m.Region = &vals.str[8]
+				b = b[n:]
+				continue
+			}
+		case 58:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[9] = v
+				m.ASN = &vals.str[9]
+				b = b[n:]
+				continue
+			}
+		case 59:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[10] = v
+				m.ASNOrg = &vals.str[10]
+				b = b[n:]
+				continue
+			}
+		case 60:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[11] = v
+				m.Hostname = &vals.str[11]
+				b = b[n:]
+				continue
+			}
+		case 61:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[3] = v
+				m.Timestamp = &vals.u64[3]
+				b = b[n:]
+				continue
+			}
+		case 62:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[45] = uint32(v)
+				m.InitCwnd = &vals.u32[45]
+				b = b[n:]
+				continue
+			}
+		case 63:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[46] = uint32(v)
+				m.Cwnd = &vals.u32[46]
+				b = b[n:]
+				continue
+			}
+		case 64:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[12] = v
+				m.CloseReason = &vals.str[12]
+				b = b[n:]
+				continue
+			}
+		case 65:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[47] = uint32(v)
+				m.SampleWeight = &vals.u32[47]
+				b = b[n:]
+				continue
+			}
+		case 66:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[13] = v
+				m.FailReason = &vals.str[13]
+				b = b[n:]
+				continue
+			}
+		case 67:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[48] = uint32(v)
+				m.VRF = &vals.u32[48]
+				b = b[n:]
+				continue
+			}
+		case 68:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[14] = v
+				m.VRFName = &vals.str[14]
+				b = b[n:]
+				continue
+			}
+		case 69:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[49] = uint32(v)
+				m.SynRetrans = &vals.u32[49]
+				b = b[n:]
+				continue
+			}
+		case 70:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[4] = v
+				m.Count = &vals.u64[4]
+				b = b[n:]
+				continue
+			}
+		case 71:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[50] = uint32(v)
+				m.Window = &vals.u32[50]
+				b = b[n:]
+				continue
+			}
+		case 72:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[51] = uint32(v)
+				m.FlowLabel = &vals.u32[51]
+				b = b[n:]
+				continue
+			}
+		case 73:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[52] = uint32(v)
+				m.TClass = &vals.u32[52]
+				b = b[n:]
+				continue
+			}
+		case 74:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[53] = uint32(v)
+				m.TSRTT = &vals.u32[53]
+				b = b[n:]
+				continue
+			}
+		case 75:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[54] = uint32(v)
+				m.RxQueue = &vals.u32[54]
+				b = b[n:]
+				continue
+			}
+		case 76:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[55] = uint32(v)
+				m.TxQueue = &vals.u32[55]
+				b = b[n:]
+				continue
+			}
+		case 77:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[5] = v
+				m.KTime = &vals.u64[5]
+				b = b[n:]
+				continue
+			}
+		case 78:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[6] = v
+				m.ReadTime = &vals.u64[6]
+				b = b[n:]
+				continue
+			}
+		case 79:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u64[7] = v
+				m.AgentDelay = &vals.u64[7]
+				b = b[n:]
+				continue
+			}
+		case 80:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[56] = uint32(v)
+				m.UID = &vals.u32[56]
+				b = b[n:]
+				continue
+			}
+		case 81:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.b[0] = protowire.DecodeBool(v)
+				m.Synthetic = &vals.b[0]
+				b = b[n:]
+				continue
+			}
+		case 82:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[57] = uint32(v)
+				m.TOS = &vals.u32[57]
+				b = b[n:]
+				continue
+			}
+		case 83:
+			if typ == protowire.VarintType {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.u32[58] = uint32(v)
+				m.DSCP = &vals.u32[58]
+				b = b[n:]
+				continue
+			}
+		case 84:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[15] = v
+				m.DSCPName = &vals.str[15]
+				b = b[n:]
+				continue
+			}
+		case 85:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[16] = v
+				m.EventID = &vals.str[16]
+				b = b[n:]
+				continue
+			}
+		case 86:
+			if typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				if !utf8.ValidString(v) {
+					return errInvalidUTF8
+				}
+				vals.str[17] = v
+				m.RetransType = &vals.str[17]
+				b = b[n:]
+				continue
+			}
+		case 87:
+			if typ == protowire.Fixed64Type {
+				v, n := protowire.ConsumeFixed64(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				vals.f64[0] = math.Float64frombits(v)
+				m.RetransRate = &vals.f64[0]
+				b = b[n:]
+				continue
+			}
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		m.unknownFields = append(m.unknownFields, tag...)
+		m.unknownFields = append(m.unknownFields, b[:n]...)
+		b = b[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+// vtgen generates the reflection free codec (SizeVT, MarshalVT, AppendVT
+// and UnmarshalVT) of the Fields message, the encoding is identical to
+// the standard codec: the fields are in the number order, the unknown
+// fields are kept and the strings are validated. it runs by go generate
+// once the tcpdog.proto fields have been changed.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+const header = `// Code generated by vtgen. DO NOT EDIT.
+
+//go:build !novtproto
+// +build !novtproto
+
+package tcpdog
+
+import (
+	"errors"
+	"io"
+	"math"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var errInvalidUTF8 = errors.New("proto: string field contains invalid UTF-8")
+
+`
+
+type field struct {
+	name string
+	num  protowire.Number
+	kind protoreflect.Kind
+	slot int
+}
+
+// slots are the values arrays of the kinds, a decoded message
+// allocates its values at once instead of one by one.
+var slots = map[protoreflect.Kind]string{
+	protoreflect.StringKind: "str",
+	protoreflect.Uint32Kind: "u32",
+	protoreflect.Uint64Kind: "u64",
+	protoreflect.BoolKind:   "b",
+	protoreflect.DoubleKind: "f64",
+}
+
+func (f field) wireType() protowire.Type {
+	switch f.kind {
+	case protoreflect.StringKind:
+		return protowire.BytesType
+	case protoreflect.DoubleKind:
+		return protowire.Fixed64Type
+	}
+
+	return protowire.VarintType
+}
+
+var wireTypes = map[protowire.Type]string{
+	protowire.VarintType:  "VarintType",
+	protowire.BytesType:   "BytesType",
+	protowire.Fixed64Type: "Fixed64Type",
+}
+
+func (f field) tag() uint64 {
+	return protowire.EncodeTag(f.num, f.wireType())
+}
+
+func main() {
+	out := flag.String("out", "tcpdog_vt.pb.go", "output file")
+	flag.Parse()
+
+	desc := (&pb.Fields{}).ProtoReflect().Descriptor().Fields()
+
+	var fields []field
+	for i := 0; i < desc.Len(); i++ {
+		fd := desc.Get(i)
+		switch fd.Kind() {
+		case protoreflect.StringKind, protoreflect.Uint32Kind,
+			protoreflect.Uint64Kind, protoreflect.BoolKind, protoreflect.DoubleKind:
+		default:
+			log.Fatalf("%s kind %s is not supported", fd.Name(), fd.Kind())
+		}
+
+		if !fd.HasPresence() || fd.IsList() {
+			log.Fatalf("%s should be optional", fd.Name())
+		}
+
+		fields = append(fields, field{name: string(fd.Name()), num: fd.Number(), kind: fd.Kind()})
+	}
+
+	// the descriptor is in the declaration order
+	for i := 1; i < len(fields); i++ {
+		for j := i; j > 0 && fields[j].num < fields[j-1].num; j-- {
+			fields[j], fields[j-1] = fields[j-1], fields[j]
+		}
+	}
+
+	count := map[protoreflect.Kind]int{}
+	for i := range fields {
+		fields[i].slot = count[fields[i].kind]
+		count[fields[i].kind]++
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString(header)
+	values(b, count)
+	size(b, fields)
+	marshal(b, fields)
+	unmarshal(b, fields)
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func values(b *bytes.Buffer, count map[protoreflect.Kind]int) {
+	b.WriteString("// fieldsValues backs the decoded Fields values\n")
+	b.WriteString("type fieldsValues struct {\n")
+	fmt.Fprintf(b, "str [%d]string\n", count[protoreflect.StringKind])
+	fmt.Fprintf(b, "u32 [%d]uint32\n", count[protoreflect.Uint32Kind])
+	fmt.Fprintf(b, "u64 [%d]uint64\n", count[protoreflect.Uint64Kind])
+	fmt.Fprintf(b, "b   [%d]bool\n", count[protoreflect.BoolKind])
+	fmt.Fprintf(b, "f64 [%d]float64\n", count[protoreflect.DoubleKind])
+	b.WriteString("}\n\n")
+}
+
+func size(b *bytes.Buffer, fields []field) {
+	b.WriteString("// SizeVT returns the encoded size of the message\n")
+	b.WriteString("func (m *Fields) SizeVT() (n int) {\n")
+	b.WriteString("if m == nil {\nreturn 0\n}\n")
+
+	for _, f := range fields {
+		t := protowire.SizeTag(f.num)
+		fmt.Fprintf(b, "if m.%s != nil {\n", f.name)
+		switch f.kind {
+		case protoreflect.StringKind:
+			fmt.Fprintf(b, "n += %d + protowire.SizeBytes(len(*m.%s))\n", t, f.name)
+		case protoreflect.BoolKind:
+			fmt.Fprintf(b, "n += %d\n", t+1)
+		case protoreflect.DoubleKind:
+			fmt.Fprintf(b, "n += %d\n", t+8)
+		default:
+			fmt.Fprintf(b, "n += %d + protowire.SizeVarint(uint64(*m.%s))\n", t, f.name)
+		}
+		b.WriteString("}\n")
+	}
+
+	b.WriteString("return n + len(m.unknownFields)\n}\n\n")
+}
+
+func marshal(b *bytes.Buffer, fields []field) {
+	b.WriteString(`// MarshalVT encodes the message
+func (m *Fields) MarshalVT() ([]byte, error) {
+	return m.AppendVT(make([]byte, 0, m.SizeVT()))
+}
+
+// MarshalToSizedBufferVT encodes the message to the end of b and returns
+// the encoded size, b is sized by the SizeVT.
+func (m *Fields) MarshalToSizedBufferVT(b []byte) (int, error) {
+	i := len(b) - m.SizeVT()
+	if i < 0 {
+		return 0, io.ErrShortBuffer
+	}
+
+	e, err := m.AppendVT(b[i:i])
+	return len(e), err
+}
+
+`)
+	b.WriteString("// AppendVT appends the encoded message to b, e.g. a pooled buffer\n")
+	b.WriteString("func (m *Fields) AppendVT(b []byte) ([]byte, error) {\n")
+	b.WriteString("if m == nil {\nreturn b, nil\n}\n")
+
+	for _, f := range fields {
+		fmt.Fprintf(b, "if m.%s != nil {\n", f.name)
+		if f.kind == protoreflect.StringKind {
+			fmt.Fprintf(b, "if !utf8.ValidString(*m.%s) {\nreturn b, errInvalidUTF8\n}\n", f.name)
+		}
+		fmt.Fprintf(b, "b = protowire.AppendVarint(b, %d)\n", f.tag())
+		switch f.kind {
+		case protoreflect.StringKind:
+			fmt.Fprintf(b, "b = protowire.AppendString(b, *m.%s)\n", f.name)
+		case protoreflect.BoolKind:
+			fmt.Fprintf(b, "b = protowire.AppendVarint(b, protowire.EncodeBool(*m.%s))\n", f.name)
+		case protoreflect.DoubleKind:
+			fmt.Fprintf(b, "b = protowire.AppendFixed64(b, math.Float64bits(*m.%s))\n", f.name)
+		default:
+			fmt.Fprintf(b, "b = protowire.AppendVarint(b, uint64(*m.%s))\n", f.name)
+		}
+		b.WriteString("}\n")
+	}
+
+	b.WriteString("return append(b, m.unknownFields...), nil\n}\n\n")
+}
+
+func unmarshal(b *bytes.Buffer, fields []field) {
+	b.WriteString(`// UnmarshalVT decodes the message, the fields of the wrong
+// wire type are kept as the unknown fields.
+func (m *Fields) UnmarshalVT(b []byte) error {
+	m.Reset()
+
+	if len(b) < 1 {
+		return nil
+	}
+
+	vals := &fieldsValues{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		tag := b[:n]
+		b = b[n:]
+
+		switch num {
+`)
+
+	for _, f := range fields {
+		fmt.Fprintf(b, "case %d:\n", f.num)
+		fmt.Fprintf(b, "if typ == protowire.%s {\n", wireTypes[f.wireType()])
+		switch f.kind {
+		case protoreflect.StringKind:
+			b.WriteString("v, n := protowire.ConsumeString(b)\n")
+			b.WriteString("if n < 0 {\nreturn protowire.ParseError(n)\n}\n")
+			b.WriteString("if !utf8.ValidString(v) {\nreturn errInvalidUTF8\n}\n")
+			fmt.Fprintf(b, "vals.str[%d] = v\n", f.slot)
+		case protoreflect.DoubleKind:
+			b.WriteString("v, n := protowire.ConsumeFixed64(b)\n")
+			b.WriteString("if n < 0 {\nreturn protowire.ParseError(n)\n}\n")
+			fmt.Fprintf(b, "vals.f64[%d] = math.Float64frombits(v)\n", f.slot)
+		default:
+			b.WriteString("v, n := protowire.ConsumeVarint(b)\n")
+			b.WriteString("if n < 0 {\nreturn protowire.ParseError(n)\n}\n")
+			switch f.kind {
+			case protoreflect.BoolKind:
+				fmt.Fprintf(b, "vals.b[%d] = protowire.DecodeBool(v)\n", f.slot)
+			case protoreflect.Uint32Kind:
+				fmt.Fprintf(b, "vals.u32[%d] = uint32(v)\n", f.slot)
+			default:
+				fmt.Fprintf(b, "vals.u64[%d] = v\n", f.slot)
+			}
+		}
+		fmt.Fprintf(b, "m.%s = &vals.%s[%d]\n", f.name, slots[f.kind], f.slot)
+		b.WriteString("b = b[n:]\ncontinue\n}\n")
+	}
+
+	b.WriteString(`}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		m.unknownFields = append(m.unknownFields, tag...)
+		m.unknownFields = append(m.unknownFields, b[:n]...)
+		b = b[n:]
+	}
+
+	return nil
+}
+`)
+}
@@ -0,0 +1,103 @@
+// Package provision creates or verifies the backend objects which the
+// ingestions need e.g. the index templates, buckets and tables. the
+// provisioning mode is auto (default) which creates the missing objects,
+// verify which only checks them and reports the missing objects or off
+// which skips all the checks, e.g. the DBAs manage the objects.
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// the provisioning modes
+const (
+	Auto   = "auto"
+	Verify = "verify"
+	Off    = "off"
+)
+
+// Object represents a backend object of an ingestion
+type Object struct {
+	// Kind is the object kind e.g. table
+	Kind string
+	Name string
+	// Exists returns true if the object exists
+	Exists func(context.Context) (bool, error)
+	// Create creates the object, it's nil if the object
+	// can't be created by tcpdog e.g. an ILM policy.
+	Create func(context.Context) error
+}
+
+func (o Object) String() string {
+	return o.Kind + " " + o.Name
+}
+
+// MissingError represents the missing objects of an ingestion
+type MissingError struct {
+	Ingestion string
+	Missing   []string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("ingestion %s: missing %s", e.Ingestion, strings.Join(e.Missing, ", "))
+}
+
+// Validate returns an error if the mode is not supported
+func Validate(mode string) error {
+	switch mode {
+	case "", Auto, Verify, Off:
+		return nil
+	}
+
+	return fmt.Errorf("provisioning %s is not supported", mode)
+}
+
+// Ensure checks the objects in order and creates the missing ones
+// in the auto mode. in the verify mode it logs what would have been
+// created and it returns a MissingError which lists all the missing
+// objects, an object which can't be created is missing in both modes.
+func Ensure(ctx context.Context, mode, ingestion string, objects []Object, logger *zap.Logger) error {
+	if mode == Off {
+		return nil
+	}
+
+	var missing []string
+
+	for _, o := range objects {
+		ok, err := o.Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("ingestion %s: %s: %w", ingestion, o, err)
+		}
+
+		if ok {
+			continue
+		}
+
+		if o.Create == nil {
+			missing = append(missing, o.String()+" (create it manually)")
+			continue
+		}
+
+		if mode == Verify {
+			logger.Warn("provision", zap.String("ingestion", ingestion), zap.String("msg", "would create "+o.String()))
+			missing = append(missing, o.String()+" (would create)")
+			continue
+		}
+
+		if err := o.Create(ctx); err != nil {
+			return fmt.Errorf("ingestion %s: create %s: %w", ingestion, o, err)
+		}
+
+		logger.Info("provision", zap.String("ingestion", ingestion), zap.String("msg", o.String()+" has been created"))
+	}
+
+	if len(missing) > 0 {
+		return &MissingError{Ingestion: ingestion, Missing: missing}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeObject struct {
+	exists  bool
+	created bool
+}
+
+func (f *fakeObject) object(kind, name string, creatable bool) Object {
+	o := Object{
+		Kind: kind,
+		Name: name,
+		Exists: func(context.Context) (bool, error) {
+			return f.exists, nil
+		},
+	}
+
+	if creatable {
+		o.Create = func(context.Context) error {
+			f.created, f.exists = true, true
+			return nil
+		}
+	}
+
+	return o
+}
+
+func TestEnsure(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		mode    string
+		err     string
+		created bool
+	}{
+		{Auto, "", true},
+		{"", "", true},
+		{Verify, "ingestion foo: missing table tcpdog (would create)", false},
+		{Off, "", false},
+	} {
+		existing, table := &fakeObject{exists: true}, &fakeObject{}
+		objects := []Object{
+			existing.object("database", "default", true),
+			table.object("table", "tcpdog", true),
+		}
+
+		err := Ensure(ctx, tc.mode, "foo", objects, zap.NewNop())
+		if tc.err == "" {
+			assert.NoError(t, err, tc.mode)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.mode)
+		}
+		assert.Equal(t, tc.created, table.created, tc.mode)
+		assert.False(t, existing.created, tc.mode)
+	}
+
+	// an object which can't be created
+	policy, template := &fakeObject{}, &fakeObject{}
+	objects := []Object{
+		policy.object("ilm policy", "hot", false),
+		template.object("index template", "tcpdog", true),
+	}
+
+	err := Ensure(ctx, Auto, "foo", objects, zap.NewNop())
+	assert.EqualError(t, err, "ingestion foo: missing ilm policy hot (create it manually)")
+	assert.True(t, template.created)
+
+	template.exists, template.created = false, false
+	err = Ensure(ctx, Verify, "foo", objects, zap.NewNop())
+	assert.Equal(t, &MissingError{Ingestion: "foo", Missing: []string{
+		"ilm policy hot (create it manually)",
+		"index template tcpdog (would create)",
+	}}, err)
+
+	// the check has been failed
+	objects = []Object{{Kind: "table", Name: "tcpdog", Exists: func(context.Context) (bool, error) {
+		return false, errors.New("connection refused")
+	}}}
+	err = Ensure(ctx, Auto, "foo", objects, zap.NewNop())
+	assert.EqualError(t, err, "ingestion foo: table tcpdog: connection refused")
+}
+
+func TestValidate(t *testing.T) {
+	for _, mode := range []string{"", Auto, Verify, Off} {
+		assert.NoError(t, Validate(mode))
+	}
+
+	assert.EqualError(t, Validate("create"), "provisioning create is not supported")
+}
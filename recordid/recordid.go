@@ -0,0 +1,210 @@
+// Package recordid generates the unique per-record EventID, the agent
+// stamps the encoded events at emit time and the server generates it
+// for the records which arrive without one. the supported kinds are
+// uuid4, ulid (lexicographically sortable by time, it's preferred for
+// the index locality) and hash which is the content hash of the event.
+package recordid
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// Field is the record id field name
+const Field = "EventID"
+
+var (
+	key   = []byte(`"` + Field + `":"`)
+	tsKey = []byte(`"Timestamp":`)
+)
+
+// Generator returns the id of the encoded event
+type Generator func(b []byte) string
+
+// random is a buffered crypto random source
+var random = struct {
+	sync.Mutex
+	r io.Reader
+}{r: bufio.NewReaderSize(rand.Reader, 4096)}
+
+// New returns the generator of the kind, none returns nil
+func New(kind string) (Generator, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "uuid4":
+		return uuid4, nil
+	case "ulid":
+		return ulid, nil
+	case "hash":
+		return hash, nil
+	}
+
+	return nil, fmt.Errorf("record id %s is not supported", kind)
+}
+
+func read(b []byte) {
+	random.Lock()
+	io.ReadFull(random.r, b)
+	random.Unlock()
+}
+
+// uuid4 returns a random (version 4) uuid
+func uuid4(_ []byte) string {
+	var (
+		u [16]byte
+		s [36]byte
+	)
+
+	read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	hex.Encode(s[0:8], u[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], u[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], u[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], u[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], u[10:])
+
+	return string(s[:])
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulid returns a ulid, 48 bits of the unix time in milliseconds
+// and 80 random bits in crockford base32.
+func ulid(_ []byte) string {
+	var (
+		u [16]byte
+		s [26]byte
+	)
+
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		u[i] = byte(ms >> (40 - 8*uint(i)))
+	}
+	read(u[6:])
+
+	// 128 bits to 26 base32 chars, the first char holds 3 bits
+	hi := uint64(u[0])<<56 | uint64(u[1])<<48 | uint64(u[2])<<40 | uint64(u[3])<<32 |
+		uint64(u[4])<<24 | uint64(u[5])<<16 | uint64(u[6])<<8 | uint64(u[7])
+	lo := uint64(u[8])<<56 | uint64(u[9])<<48 | uint64(u[10])<<40 | uint64(u[11])<<32 |
+		uint64(u[12])<<24 | uint64(u[13])<<16 | uint64(u[14])<<8 | uint64(u[15])
+
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(s[:])
+}
+
+// hash returns the content hash of the event, the emission
+// timestamp is excluded.
+func hash(b []byte) string {
+	if i := bytes.LastIndex(b, tsKey); i > 0 {
+		b = b[:i]
+	}
+
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], xxhash.Sum64(b))
+	return hex.EncodeToString(h[:])
+}
+
+// Stamp inserts the id into the encoded event right before the Timestamp
+func Stamp(buf *bytes.Buffer, gen Generator) {
+	b := buf.Bytes()
+
+	i := bytes.LastIndex(b, tsKey)
+	if i < 0 || bytes.Contains(b[:i], key) {
+		return
+	}
+
+	var tail [32]byte
+	n := copy(tail[:], b[i:])
+	if n < len(b)-i {
+		return
+	}
+
+	id := gen(b)
+
+	buf.Truncate(i)
+	buf.Write(key)
+	buf.WriteString(id)
+	buf.WriteString(`",`)
+	buf.Write(tail[:n])
+}
+
+// Get returns the id of the decoded record (json, spb or pb)
+func Get(record interface{}) (string, bool) {
+	switch r := record.(type) {
+	case map[string]interface{}:
+		id, ok := r[Field].(string)
+		return id, ok && id != ""
+	case *pb.FieldsSPB:
+		id := r.GetFields().GetFields()[Field].GetStringValue()
+		return id, id != ""
+	case *pb.Fields:
+		return r.GetEventID(), r.EventID != nil
+	}
+
+	return "", false
+}
+
+// Set sets the id of the decoded record if it doesn't have one, the hash
+// kind hashes the json encoding of the record without the Timestamp.
+func Set(record interface{}, gen Generator) {
+	if _, ok := Get(record); ok {
+		return
+	}
+
+	switch r := record.(type) {
+	case map[string]interface{}:
+		r[Field] = gen(content(r))
+	case *pb.FieldsSPB:
+		if r.Fields == nil {
+			r.Fields = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		}
+		r.Fields.Fields[Field] = structpb.NewStringValue(gen(content(r.Fields.AsMap())))
+	case *pb.Fields:
+		ts := r.Timestamp
+		r.Timestamp = nil
+		b, _ := json.Marshal(r)
+		r.Timestamp = ts
+
+		id := gen(b)
+		r.EventID = &id
+	}
+}
+
+// content returns the json encoding of the record without the Timestamp
+func content(m map[string]interface{}) []byte {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if k != "Timestamp" {
+			c[k] = v
+		}
+	}
+
+	b, _ := json.Marshal(c)
+
+	return b
+}
@@ -0,0 +1,162 @@
+package recordid
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+var event = []byte(`{"Task":"curl","RTT":310,"DAddr":"10.0.0.1","Timestamp":1622316222}`)
+
+func TestNew(t *testing.T) {
+	gen, err := New("none")
+	assert.NoError(t, err)
+	assert.Nil(t, gen)
+
+	_, err = New("snowflake")
+	assert.EqualError(t, err, "record id snowflake is not supported")
+
+	formats := map[string]string{
+		"uuid4": `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		"ulid":  `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`,
+		"hash":  `^[0-9a-f]{16}$`,
+	}
+
+	for kind, format := range formats {
+		gen, err := New(kind)
+		assert.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(format), gen(event), kind)
+	}
+
+	// ulids are sortable by time
+	a := ulid(nil)
+	b := ulid(nil)
+	assert.True(t, a[:10] <= b[:10])
+
+	// the hash excludes the timestamp
+	assert.Equal(t, hash(event), hash(bytes.Replace(event, []byte("1622316222"), []byte("1622316290"), 1)))
+	assert.NotEqual(t, uuid4(event), uuid4(event))
+}
+
+func TestStamp(t *testing.T) {
+	buf := bytes.NewBuffer(append([]byte{}, event...))
+	Stamp(buf, func([]byte) string { return "id1" })
+	assert.Equal(t, `{"Task":"curl","RTT":310,"DAddr":"10.0.0.1","EventID":"id1","Timestamp":1622316222}`, buf.String())
+
+	// stamped already
+	Stamp(buf, func([]byte) string { return "id2" })
+	assert.Contains(t, buf.String(), `"EventID":"id1"`)
+	assert.NotContains(t, buf.String(), "id2")
+}
+
+// TestRoundTrip verifies the id survives the agent encodings
+// and the conversions between all the serializations.
+func TestRoundTrip(t *testing.T) {
+	buf := bytes.NewBuffer(append([]byte{}, event...))
+	Stamp(buf, ulid)
+	id := regexp.MustCompile(`"EventID":"(\w+)"`).FindStringSubmatch(buf.String())[1]
+
+	// json
+	m := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+
+	// struct protobuf
+	spb := helper.NewStructPB([]config.Field{{Name: "Task"}, {Name: "RTT"}, {Name: "DAddr"}})
+	fields, err := spb.Unmarshal(bytes.NewBuffer(buf.Bytes()))
+	assert.NoError(t, err)
+	s := &pb.FieldsSPB{Fields: fields}
+
+	records := map[string]interface{}{"json": m, "spb": s}
+
+	for from, r := range records {
+		v, ok := Get(r)
+		assert.True(t, ok, from)
+		assert.Equal(t, id, v, from)
+
+		for _, to := range []string{"json", "spb", "pb"} {
+			c, _, err := serialization.Convert(r, from, to)
+			assert.NoError(t, err)
+
+			if msg, ok := c.(proto.Message); ok {
+				b, err := proto.Marshal(msg)
+				assert.NoError(t, err)
+				msg = msg.ProtoReflect().New().Interface()
+				assert.NoError(t, proto.Unmarshal(b, msg))
+				c = msg
+			}
+
+			v, ok := Get(c)
+			assert.True(t, ok, from+"->"+to)
+			assert.Equal(t, id, v, from+"->"+to)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	gen, _ := New("hash")
+
+	m := map[string]interface{}{"Task": "curl", "RTT": float64(310), "Timestamp": float64(1622316222)}
+	s, _ := structpb.NewStruct(m)
+	p, _, _ := serialization.Convert(m, "json", "pb")
+
+	records := []interface{}{m, &pb.FieldsSPB{Fields: s}, p}
+	for _, r := range records {
+		Set(r, gen)
+	}
+
+	idJSON, _ := Get(m)
+	idSPB, _ := Get(records[1])
+	assert.Len(t, idJSON, 16)
+	assert.Equal(t, idJSON, idSPB)
+
+	idPB, ok := Get(p)
+	assert.True(t, ok)
+	assert.Len(t, idPB, 16)
+
+	// the hash excludes the timestamp
+	m2 := map[string]interface{}{"Task": "curl", "RTT": float64(310), "Timestamp": float64(1622316290)}
+	Set(m2, gen)
+	assert.Equal(t, m["EventID"], m2["EventID"])
+
+	// an existing id is kept
+	Set(m, func([]byte) string { return "new" })
+	assert.Equal(t, idJSON, m["EventID"])
+}
+
+func BenchmarkUUID4(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		uuid4(event)
+	}
+}
+
+func BenchmarkULID(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ulid(event)
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		hash(event)
+	}
+}
+
+func BenchmarkStamp(b *testing.B) {
+	buf := new(bytes.Buffer)
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		buf.Write(event)
+		Stamp(buf, ulid)
+	}
+}
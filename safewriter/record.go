@@ -0,0 +1,205 @@
+package safewriter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// the length prefixed record header: length and crc32 (castagnoli)
+const headerSize = 8
+
+// MaxRecordSize is the max length of a length prefixed record
+const MaxRecordSize = 64 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func appendRecord(b []byte, mode Mode, rec []byte) []byte {
+	if mode == LengthPrefixed {
+		var h [headerSize]byte
+		binary.BigEndian.PutUint32(h[:4], uint32(len(rec)))
+		binary.BigEndian.PutUint32(h[4:], crc32.Checksum(rec, crcTable))
+		b = append(b, h[:]...)
+		return append(b, rec...)
+	}
+
+	b = append(b, rec...)
+	if len(rec) == 0 || rec[len(rec)-1] != '\n' {
+		b = append(b, '\n')
+	}
+
+	return b
+}
+
+// Reader reads the complete records, it stops at a torn
+// record which is the tail of a crashed writer.
+type Reader struct {
+	r      *bufio.Reader
+	mode   Mode
+	offset int64
+	torn   bool
+	buf    []byte
+}
+
+// NewReader constructs a new reader of the mode
+func NewReader(r io.Reader, mode Mode) *Reader {
+	return &Reader{r: bufio.NewReader(r), mode: mode}
+}
+
+// Next returns the next record without its framing, it returns io.EOF
+// at the end or at a torn record. the record is valid until the next
+// call for the length prefixed mode.
+func (r *Reader) Next() ([]byte, error) {
+	if r.torn {
+		return nil, io.EOF
+	}
+
+	if r.mode == LengthPrefixed {
+		return r.nextPrefixed()
+	}
+
+	line, err := r.r.ReadBytes('\n')
+	if err == io.EOF {
+		r.torn = len(line) > 0
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.offset += int64(len(line))
+
+	return line[:len(line)-1], nil
+}
+
+func (r *Reader) nextPrefixed() ([]byte, error) {
+	var h [headerSize]byte
+
+	n, err := io.ReadFull(r.r, h[:])
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		r.torn = n > 0
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(h[:4])
+	if size == 0 || size > MaxRecordSize {
+		r.torn = true
+		return nil, io.EOF
+	}
+
+	if cap(r.buf) < int(size) {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+
+	if _, err := io.ReadFull(r.r, r.buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		r.torn = true
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(r.buf, crcTable) != binary.BigEndian.Uint32(h[4:]) {
+		r.torn = true
+		return nil, io.EOF
+	}
+
+	r.offset += int64(headerSize + size)
+
+	return r.buf, nil
+}
+
+// Offset returns the end offset of the last complete record
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Torn returns true if the reader has stopped at a torn record
+func (r *Reader) Torn() bool {
+	return r.torn
+}
+
+// Recover scans the file and truncates a torn final record, it returns
+// the number of the complete records of the length prefixed file. a line
+// file is only scanned backward to its last newline.
+func Recover(path string, mode Mode) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if mode == Line {
+		return 0, recoverLine(f)
+	}
+
+	var (
+		r = NewReader(f, mode)
+		n int
+	)
+
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if r.Torn() {
+		return n, f.Truncate(r.Offset())
+	}
+
+	return n, nil
+}
+
+// recoverLine truncates the bytes after the last newline,
+// the file is scanned backward from its end.
+func recoverLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		end = info.Size()
+		buf = make([]byte, 32<<10)
+	)
+
+	for off := end; off > 0; {
+		n := int64(len(buf))
+		if off < n {
+			n = off
+		}
+		off -= n
+
+		if _, err := f.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			if off+int64(i)+1 == end {
+				return nil
+			}
+			return f.Truncate(off + int64(i) + 1)
+		}
+	}
+
+	if end > 0 {
+		return f.Truncate(0)
+	}
+
+	return nil
+}
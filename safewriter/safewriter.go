@@ -0,0 +1,266 @@
+// Package safewriter writes the records to a file in a way which survives
+// a crash: a record is either complete on the disk or it's a torn tail
+// which is detected and truncated at the next open. The records are
+// newline terminated (Line) or length prefixed with a checksum
+// (LengthPrefixed) for the binary records e.g. the spool.
+package safewriter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mode is the record framing of the file
+type Mode int
+
+const (
+	// Line records are terminated by a newline
+	Line Mode = iota
+	// LengthPrefixed records have a length and a crc32 header
+	LengthPrefixed
+)
+
+// the fsync policies
+const (
+	// SyncNone leaves the sync to the OS (default)
+	SyncNone = "none"
+	// SyncAlways syncs the file after each flush
+	SyncAlways = "always"
+	// SyncInterval syncs the file at a flush once the interval passed
+	SyncInterval = "interval"
+)
+
+// ErrClosed is returned once the writer has been closed
+var ErrClosed = errors.New("safewriter: closed")
+
+// Options represents the writer options
+type Options struct {
+	Mode Mode
+
+	// Sync is the fsync policy: none, always or interval
+	Sync         string
+	SyncInterval time.Duration
+
+	// BufferSize is the buffered bytes which trigger a flush (default 64KB)
+	BufferSize int
+
+	// OnRotate is called with the rotated file path
+	OnRotate func(path string)
+}
+
+// file is the underlying file, it's replaceable by the tests
+type file interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// Writer is a buffered writer of the records, Flush writes only the
+// complete records and a failed write is truncated back to the last
+// complete record. It's safe for concurrent use.
+type Writer struct {
+	sync.Mutex
+
+	path     string
+	opts     Options
+	file     file
+	buf      []byte
+	size     int64 // the committed bytes of the file
+	lastSync time.Time
+	closed   bool
+}
+
+// Open opens the file for appending, it's created if it doesn't exist
+// and a torn final record of a crash is truncated.
+func Open(path string, opts Options) (*Writer, error) {
+	if _, err := Recover(path, opts.Mode); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return open(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, opts)
+}
+
+// Create creates a new file, it fails if the file exists
+func Create(path string, opts Options) (*Writer, error) {
+	return open(path, os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_WRONLY, opts)
+}
+
+func open(path string, flag int, opts Options) (*Writer, error) {
+	switch opts.Sync {
+	case "":
+		opts.Sync = SyncNone
+	case SyncNone, SyncAlways, SyncInterval:
+	default:
+		return nil, fmt.Errorf("sync %s is not supported", opts.Sync)
+	}
+
+	if opts.BufferSize < 1 {
+		opts.BufferSize = 64 << 10
+	}
+
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Writer{
+		path:     path,
+		opts:     opts,
+		file:     f,
+		size:     info.Size(),
+		lastSync: time.Now(),
+	}, nil
+}
+
+// Write appends the record to the buffer, a line record is terminated
+// by a newline if it's not. the buffer is flushed once it's full.
+func (w *Writer) Write(rec []byte) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	w.buf = appendRecord(w.buf, w.opts.Mode, rec)
+
+	if len(w.buf) >= w.opts.BufferSize {
+		return w.flush()
+	}
+
+	return nil
+}
+
+// Flush writes the buffered records to the file
+func (w *Writer) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	if len(w.buf) > 0 {
+		n, err := w.file.Write(w.buf)
+		if err != nil {
+			// the partial write is truncated, the buffer
+			// is kept thus the next flush retries it.
+			if n > 0 {
+				w.file.Truncate(w.size)
+			}
+			return err
+		}
+
+		w.size += int64(n)
+		w.buf = w.buf[:0]
+	}
+
+	switch w.opts.Sync {
+	case SyncAlways:
+		return w.sync()
+	case SyncInterval:
+		if time.Since(w.lastSync) >= w.opts.SyncInterval {
+			return w.sync()
+		}
+	}
+
+	return nil
+}
+
+func (w *Writer) sync() error {
+	w.lastSync = time.Now()
+	return w.file.Sync()
+}
+
+// Size returns the file size including the buffered records
+func (w *Writer) Size() int64 {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.size + int64(len(w.buf))
+}
+
+// Path returns the file path
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// Rotate flushes and syncs the file, then renames it to the dst
+// and continues with a new file at the path.
+func (w *Writer) Rotate(dst string) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	if err := w.sync(); err != nil {
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(w.path, dst); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		w.closed = true
+		return err
+	}
+
+	w.file, w.size = f, 0
+
+	if w.opts.OnRotate != nil {
+		w.opts.OnRotate(dst)
+	}
+
+	return nil
+}
+
+// Close flushes and closes the file, it's synced unless the
+// sync policy is none.
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	err := w.flush()
+	if err == nil && w.opts.Sync != SyncNone {
+		err = w.sync()
+	}
+
+	if e := w.file.Close(); err == nil {
+		err = e
+	}
+
+	return err
+}
@@ -0,0 +1,203 @@
+package safewriter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// crashFile writes the first n bytes then the writer is killed
+type crashFile struct {
+	*os.File
+	n int
+}
+
+func (f *crashFile) Write(b []byte) (int, error) {
+	if len(b) > f.n {
+		f.File.Write(b[:f.n])
+		runtime.Goexit()
+	}
+
+	f.n -= len(b)
+	return f.File.Write(b)
+}
+
+// failFile writes half of the bytes and fails once
+type failFile struct {
+	*os.File
+	fail bool
+}
+
+func (f *failFile) Write(b []byte) (int, error) {
+	if f.fail {
+		f.fail = false
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errors.New("no space left on device")
+	}
+
+	return f.File.Write(b)
+}
+
+func readAll(t *testing.T, path string, mode Mode) ([]string, bool) {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var (
+		r    = NewReader(f, mode)
+		recs []string
+	)
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		recs = append(recs, string(rec))
+	}
+
+	return recs, r.Torn()
+}
+
+func TestCrash(t *testing.T) {
+	for _, mode := range []Mode{Line, LengthPrefixed} {
+		path := filepath.Join(t.TempDir(), "records")
+
+		w, err := Open(path, Options{Mode: mode, BufferSize: 1})
+		assert.NoError(t, err)
+
+		// the writer is killed in the middle of the 6th record
+		size := len(appendRecord(nil, mode, []byte("record-0")))
+		w.file = &crashFile{File: w.file.(*os.File), n: 5*size + size/2}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				w.Write([]byte(fmt.Sprintf("record-%d", i)))
+			}
+		}()
+		wg.Wait()
+
+		recs, torn := readAll(t, path, mode)
+		assert.True(t, torn, mode)
+		assert.Equal(t, []string{"record-0", "record-1", "record-2", "record-3", "record-4"}, recs)
+
+		// the torn record is truncated at the next open
+		w, err = Open(path, Options{Mode: mode})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5*size), w.Size())
+		assert.NoError(t, w.Write([]byte("record-5")))
+		assert.NoError(t, w.Close())
+
+		recs, torn = readAll(t, path, mode)
+		assert.False(t, torn, mode)
+		assert.Len(t, recs, 6)
+		assert.Equal(t, "record-5", recs[5])
+	}
+}
+
+func TestPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records")
+
+	w, err := Open(path, Options{Mode: LengthPrefixed})
+	assert.NoError(t, err)
+
+	f := &failFile{File: w.file.(*os.File)}
+	w.file = f
+
+	assert.NoError(t, w.Write([]byte("record-0")))
+	assert.NoError(t, w.Flush())
+
+	// the partial write is truncated back to the complete records
+	f.fail = true
+	assert.NoError(t, w.Write([]byte("record-1")))
+	assert.Error(t, w.Flush())
+
+	recs, torn := readAll(t, path, LengthPrefixed)
+	assert.False(t, torn)
+	assert.Equal(t, []string{"record-0"}, recs)
+
+	// the buffered record is retried
+	assert.NoError(t, w.Close())
+
+	recs, _ = readAll(t, path, LengthPrefixed)
+	assert.Equal(t, []string{"record-0", "record-1"}, recs)
+
+	assert.Equal(t, ErrClosed, w.Write(nil))
+}
+
+func TestRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records")
+
+	// a corrupted record
+	b := appendRecord(nil, LengthPrefixed, []byte("record-0"))
+	b = appendRecord(b, LengthPrefixed, []byte("record-1"))
+	b[len(b)-1] ^= 0xff
+	assert.NoError(t, ioutil.WriteFile(path, b, 0644))
+
+	n, err := Recover(path, LengthPrefixed)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, appendRecord(nil, LengthPrefixed, []byte("record-0")), b)
+
+	// a line without newline
+	assert.NoError(t, ioutil.WriteFile(path, []byte("a,b\n1,2\n3,"), 0644))
+	_, err = Recover(path, Line)
+	assert.NoError(t, err)
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "a,b\n1,2\n", string(b))
+
+	assert.NoError(t, ioutil.WriteFile(path, bytes.Repeat([]byte("x"), 40<<10), 0644))
+	_, err = Recover(path, Line)
+	assert.NoError(t, err)
+	b, _ = ioutil.ReadFile(path)
+	assert.Len(t, b, 0)
+
+	_, err = Recover(filepath.Join(t.TempDir(), "none"), Line)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotate(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		path    = filepath.Join(dir, "tcpdog.log")
+		rotated []string
+	)
+
+	w, err := Open(path, Options{Sync: SyncAlways, OnRotate: func(p string) {
+		rotated = append(rotated, p)
+	}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.Write([]byte("1")))
+	assert.NoError(t, w.Rotate(path+".1"))
+	assert.NoError(t, w.Write([]byte("2\n")))
+	assert.NoError(t, w.Close())
+
+	assert.Equal(t, []string{path + ".1"}, rotated)
+
+	b, _ := ioutil.ReadFile(path + ".1")
+	assert.Equal(t, "1\n", string(b))
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "2\n", string(b))
+
+	_, err = Open(path, Options{Sync: "never"})
+	assert.EqualError(t, err, "sync never is not supported")
+
+	_, err = Create(path, Options{})
+	assert.True(t, os.IsExist(err))
+}
@@ -0,0 +1,38 @@
+#include <uapi/linux/ptrace.h>
+#include <linux/tcp.h>
+#include <net/sock.h>
+
+// the output struct layout is declared by the schema in agent.yml
+struct event_t {
+    u32 pid;       // offset 0
+    u16 sport;     // offset 4
+    u16 dport;     // offset 6
+    u32 saddr;     // offset 8
+    u32 daddr;     // offset 12
+    u8 oldstate;   // offset 16
+    u8 newstate;   // offset 17
+    char comm[16]; // offset 18, the struct size is 36 (4 bytes aligned)
+};
+
+BPF_PERF_OUTPUT(events);
+
+int trace_state(struct tracepoint__sock__inet_sock_set_state *args)
+{
+    if (args->protocol != IPPROTO_TCP || args->family != AF_INET)
+        return 0;
+
+    struct event_t event = {};
+
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+    event.sport = args->sport;
+    event.dport = args->dport;
+    __builtin_memcpy(&event.saddr, args->saddr, sizeof(event.saddr));
+    __builtin_memcpy(&event.daddr, args->daddr, sizeof(event.daddr));
+    event.oldstate = args->oldstate;
+    event.newstate = args->newstate;
+    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+
+    events.perf_submit(args, &event, sizeof(event));
+
+    return 0;
+}
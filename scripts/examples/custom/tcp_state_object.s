@@ -0,0 +1,80 @@
+# tcp_state_object is the precompiled variant of tcp_state.c, the
+# events have the same layout thus the schema in agent.yml applies.
+# the tracepoint fields are read at the fixed offsets of the
+# sock:inet_sock_set_state format (4.16+), check them by:
+#   cat /sys/kernel/debug/tracing/events/sock/inet_sock_set_state/format
+#
+# build: llvm-mc -triple bpf -filetype=obj -o tcp_state_object.o tcp_state_object.s
+
+	.section	"tracepoint/sock/inet_sock_set_state","ax",@progbits
+	.globl	trace_state
+	.p2align	3
+trace_state:
+	r6 = r1
+	r1 = *(u8 *)(r6 + 30)              # protocol
+	if r1 != 6 goto out                # IPPROTO_TCP
+	r1 = *(u16 *)(r6 + 28)             # family
+	if r1 != 2 goto out                # AF_INET
+
+	# struct event_t at r10 - 40, the struct size is 36
+	r1 = 0
+	*(u64 *)(r10 - 40) = r1
+	*(u64 *)(r10 - 32) = r1
+	*(u64 *)(r10 - 24) = r1
+	*(u64 *)(r10 - 16) = r1
+	*(u64 *)(r10 - 8) = r1
+
+	call 14                            # bpf_get_current_pid_tgid
+	r0 >>= 32
+	*(u32 *)(r10 - 40) = r0            # pid, offset 0
+
+	r1 = *(u16 *)(r6 + 24)
+	*(u16 *)(r10 - 36) = r1            # sport, offset 4
+	r1 = *(u16 *)(r6 + 26)
+	*(u16 *)(r10 - 34) = r1            # dport, offset 6
+	r1 = *(u32 *)(r6 + 32)
+	*(u32 *)(r10 - 32) = r1            # saddr, offset 8
+	r1 = *(u32 *)(r6 + 36)
+	*(u32 *)(r10 - 28) = r1            # daddr, offset 12
+	r1 = *(u32 *)(r6 + 16)
+	*(u8 *)(r10 - 24) = r1             # oldstate, offset 16
+	r1 = *(u32 *)(r6 + 20)
+	*(u8 *)(r10 - 23) = r1             # newstate, offset 17
+
+	r1 = r10
+	r1 += -22
+	r2 = 16
+	call 16                            # bpf_get_current_comm, offset 18
+
+	r1 = r6
+	r2 = events ll
+	r3 = 4294967295 ll                 # BPF_F_CURRENT_CPU
+	r4 = r10
+	r4 += -40
+	r5 = 36
+	call 25                            # bpf_perf_event_output
+out:
+	r0 = 0
+	exit
+
+# struct bpf_map_def of the gobpf elf loader: type (perf event array),
+# key size, value size, max entries, flags, pinning and namespace
+	.section	"maps/events","aw",@progbits
+	.globl	events
+	.p2align	2
+events:
+	.long	4
+	.long	4
+	.long	4
+	.long	1024
+	.long	0
+	.long	0
+	.zero	256
+
+	.section	license,"aw",@progbits
+	.asciz	"GPL"
+
+# the loader replaces it by the running kernel version
+	.section	version,"aw",@progbits
+	.p2align	2
+	.long	0xFFFFFFFE
@@ -0,0 +1,109 @@
+package serialization
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+)
+
+// registry maps the field names to their bits, the protobuf field
+// numbers never change so the bit ordering is stable across the
+// agents and the servers versions.
+var (
+	registry     = map[string]int{}
+	registryName = map[int]string{}
+)
+
+func init() {
+	for i := 0; i < fieldsDesc.Len(); i++ {
+		fd := fieldsDesc.Get(i)
+		registry[string(fd.Name())] = int(fd.Number()) - 1
+		registryName[int(fd.Number())-1] = string(fd.Name())
+	}
+}
+
+// Bitmap represents the presence of the fields
+type Bitmap []uint64
+
+// Set sets the field bit, it returns false if the field is unknown
+func (b *Bitmap) Set(name string) bool {
+	bit, ok := registry[name]
+	if !ok {
+		return false
+	}
+
+	for len(*b) <= bit/64 {
+		*b = append(*b, 0)
+	}
+
+	(*b)[bit/64] |= 1 << uint(bit%64)
+
+	return true
+}
+
+// Has returns true if the field bit is set
+func (b Bitmap) Has(name string) bool {
+	bit, ok := registry[name]
+	if !ok || len(b) <= bit/64 {
+		return false
+	}
+
+	return b[bit/64]&(1<<uint(bit%64)) != 0
+}
+
+// Or merges the other bitmap
+func (b *Bitmap) Or(o Bitmap) {
+	for len(*b) < len(o) {
+		*b = append(*b, 0)
+	}
+
+	for i, w := range o {
+		(*b)[i] |= w
+	}
+}
+
+// Names returns the name of the present fields in the bit order
+func (b Bitmap) Names() []string {
+	var names []string
+	for i, w := range b {
+		for j := 0; j < 64; j++ {
+			if w&(1<<uint(j)) == 0 {
+				continue
+			}
+
+			if name, ok := registryName[i*64+j]; ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// Encode returns the bitmap as a compact base64 string
+func (b Bitmap) Encode() string {
+	buf := make([]byte, len(b)*8)
+	for i, w := range b {
+		binary.LittleEndian.PutUint64(buf[i*8:], w)
+	}
+
+	for len(buf) > 0 && buf[len(buf)-1] == 0 {
+		buf = buf[:len(buf)-1]
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// DecodeBitmap decodes an encoded bitmap
+func DecodeBitmap(s string) (Bitmap, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make(Bitmap, (len(buf)+7)/8)
+	for i, c := range buf {
+		b[i/8] |= uint64(c) << uint(i%8*8)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,40 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitmap(t *testing.T) {
+	var b Bitmap
+
+	assert.True(t, b.Set("RTT"))
+	assert.True(t, b.Set("Task"))
+	assert.True(t, b.Set("FailReason"))
+	assert.False(t, b.Set("Foo"))
+
+	assert.True(t, b.Has("RTT"))
+	assert.False(t, b.Has("SAddr"))
+	assert.False(t, b.Has("Foo"))
+
+	// the bits follow the protobuf field numbers
+	assert.Equal(t, []string{"Task", "RTT", "FailReason"}, b.Names())
+	assert.Len(t, b, 2)
+
+	s := b.Encode()
+	assert.Equal(t, "AYAAAAAAAAAC", s)
+
+	d, err := DecodeBitmap(s)
+	assert.NoError(t, err)
+	assert.Equal(t, b, d)
+
+	// empty
+	assert.Equal(t, "", Bitmap{}.Encode())
+	d, err = DecodeBitmap("")
+	assert.NoError(t, err)
+	assert.Len(t, d, 0)
+
+	_, err = DecodeBitmap("!")
+	assert.Error(t, err)
+}
@@ -0,0 +1,188 @@
+package serialization
+
+import (
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// the keys are sorted and the floats are encoded by their
+// shortest lossless size thus the payloads are compact.
+var cborEnc, _ = cbor.EncOptions{
+	Sort:          cbor.SortCanonical,
+	ShortestFloat: cbor.ShortestFloat16,
+}.EncMode()
+
+// addrFields are the address fields which the agents may encode
+// as the byte arrays of the addresses e.g. the ipv6 addresses.
+var addrFields = map[string]bool{
+	"SAddr": true,
+	"DAddr": true,
+}
+
+// RawCBOR is an encoded cbor record which is sent by the CBOR codec
+// as is, the agent encodes the json events without the pb reflection.
+type RawCBOR []byte
+
+// MarshalCBOR encodes the record map by cbor
+func MarshalCBOR(m map[string]interface{}) ([]byte, error) {
+	return cborEnc.Marshal(m)
+}
+
+// UnmarshalCBOR decodes the cbor record to the json record shape like
+// the msgpack records, the integers are int64 (uint64 if they overflow),
+// the address byte arrays are converted to their string form and the
+// other byte strings are converted to strings.
+func UnmarshalCBOR(b []byte) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+
+	if err := cbor.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	for k, v := range m {
+		switch v := v.(type) {
+		case uint64:
+			if v <= math.MaxInt64 {
+				m[k] = int64(v)
+			}
+		case []byte:
+			if ip := addr(k, v); ip != "" {
+				m[k] = ip
+			} else {
+				m[k] = string(v)
+			}
+		case []interface{}:
+			if b, ok := byteArray(v); ok {
+				if ip := addr(k, b); ip != "" {
+					m[k] = ip
+				}
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// JSONToCBOR encodes the json event by cbor, the json
+// integers are encoded as the cbor integers.
+func JSONToCBOR(b []byte) ([]byte, error) {
+	m, err := jsonRecord(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return MarshalCBOR(m)
+}
+
+// addr returns the string form of the address field
+func addr(key string, b []byte) string {
+	if !addrFields[key] || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return ""
+	}
+
+	return net.IP(b).String()
+}
+
+// byteArray returns the bytes of an array of the byte values
+func byteArray(a []interface{}) ([]byte, bool) {
+	b := make([]byte, len(a))
+
+	for i, v := range a {
+		n, ok := v.(uint64)
+		if !ok || n > math.MaxUint8 {
+			return nil, false
+		}
+		b[i] = byte(n)
+	}
+
+	return b, true
+}
+
+// CBORCodec is the gRPC codec of the cbor content subtype, the
+// messages are encoded as the cbor maps of their set fields.
+type CBORCodec struct{}
+
+// Marshal encodes the message
+func (CBORCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case RawCBOR:
+		return v, nil
+	case proto.Message:
+		return MarshalCBOR(messageToMap(v.ProtoReflect()))
+	}
+
+	return nil, fmt.Errorf("cbor codec: invalid message: %T", v)
+}
+
+// Unmarshal decodes the message, the unknown fields are ignored. the
+// empty data is the empty message e.g. the response of a server which
+// forces the proto codec.
+func (CBORCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cbor codec: invalid message: %T", v)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	r, err := UnmarshalCBOR(data)
+	if err != nil {
+		return err
+	}
+
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+
+	for key, value := range r {
+		fd := fields.ByName(protoreflect.Name(key))
+		if fd == nil {
+			continue
+		}
+
+		if err := set(msg, fd, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Name returns the codec name
+func (CBORCodec) Name() string {
+	return "cbor"
+}
+
+// messageToMap returns the map of the set fields, the
+// numbers keep their integer type.
+func messageToMap(msg protoreflect.Message) map[string]interface{} {
+	m := map[string]interface{}{}
+
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			m[string(fd.Name())] = v.String()
+		case protoreflect.BoolKind:
+			m[string(fd.Name())] = v.Bool()
+		case protoreflect.Int32Kind, protoreflect.Int64Kind:
+			m[string(fd.Name())] = v.Int()
+		case protoreflect.EnumKind:
+			m[string(fd.Name())] = int64(v.Enum())
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			m[string(fd.Name())] = v.Float()
+		case protoreflect.BytesKind:
+			m[string(fd.Name())] = v.Bytes()
+		default:
+			m[string(fd.Name())] = v.Uint()
+		}
+		return true
+	})
+
+	return m
+}
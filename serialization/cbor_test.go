@@ -0,0 +1,119 @@
+package serialization
+
+import (
+	"net"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestCBOR(t *testing.T) {
+	b, err := JSONToCBOR([]byte(`{"Task":"curl","RTT":310,"Rate":0.5,"Synthetic":true}`))
+	assert.NoError(t, err)
+
+	m, err := UnmarshalCBOR(b)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Task":      "curl",
+		"RTT":       int64(310),
+		"Rate":      0.5,
+		"Synthetic": true,
+	}, m)
+
+	// the keys are sorted
+	b2, err := MarshalCBOR(m)
+	assert.NoError(t, err)
+	assert.Equal(t, b, b2)
+
+	_, err = JSONToCBOR([]byte(`{"RTT":`))
+	assert.Error(t, err)
+}
+
+func TestCBORAddr(t *testing.T) {
+	b, err := cbor.Marshal(map[string]interface{}{
+		"SAddr": []byte(net.ParseIP("10.0.0.1").To4()),
+		"DAddr": []byte(net.ParseIP("2001:db8::1")),
+		"Task":  []byte("curl"),
+	})
+	assert.NoError(t, err)
+
+	m, err := UnmarshalCBOR(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", m["SAddr"])
+	assert.Equal(t, "2001:db8::1", m["DAddr"])
+	assert.Equal(t, "curl", m["Task"])
+
+	// the byte array of a fixed size array
+	b, err = cbor.Marshal(map[string]interface{}{"DAddr": [16]int{0x20, 0x01, 0x0d, 0xb8, 15: 2}})
+	assert.NoError(t, err)
+
+	m, err = UnmarshalCBOR(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8::2", m["DAddr"])
+}
+
+func TestCBORCodec(t *testing.T) {
+	task, saddr, daddr, rtt, timestamp, synthetic := "curl", "10.0.0.1", "2001:db8::1", uint32(310), uint64(1622316222), true
+	fields := &pb.Fields{Task: &task, SAddr: &saddr, DAddr: &daddr, RTT: &rtt, Timestamp: &timestamp, Synthetic: &synthetic}
+
+	codec := CBORCodec{}
+	assert.Equal(t, "cbor", codec.Name())
+
+	b, err := codec.Marshal(fields)
+	assert.NoError(t, err)
+
+	actual := &pb.Fields{}
+	assert.NoError(t, codec.Unmarshal(b, actual))
+	assert.True(t, proto.Equal(fields, actual), actual.String())
+
+	// the agent encodes the json events
+	b, err = JSONToCBOR([]byte(`{"Task":"curl","SAddr":"10.0.0.1","DAddr":"2001:db8::1","RTT":310,"Timestamp":1622316222,"Synthetic":true}`))
+	assert.NoError(t, err)
+
+	raw, err := codec.Marshal(RawCBOR(b))
+	assert.NoError(t, err)
+	assert.Equal(t, b, raw)
+
+	actual = &pb.Fields{}
+	assert.NoError(t, codec.Unmarshal(raw, actual))
+	assert.True(t, proto.Equal(fields, actual), actual.String())
+
+	// the response of a server which forces the proto codec
+	resp := &pb.Response{}
+	assert.NoError(t, codec.Unmarshal(nil, resp))
+
+	code := &pb.Response{Code: 3}
+	b, err = codec.Marshal(code)
+	assert.NoError(t, err)
+	assert.NoError(t, codec.Unmarshal(b, resp))
+	assert.Equal(t, int32(3), resp.Code)
+
+	_, err = codec.Marshal("foo")
+	assert.EqualError(t, err, "cbor codec: invalid message: string")
+	assert.Error(t, codec.Unmarshal([]byte{0xff}, &pb.Fields{}))
+}
+
+func TestConvertCBOR(t *testing.T) {
+	m := map[string]interface{}{
+		"Task":      "curl",
+		"DAddr":     "10.0.0.1",
+		"RTT":       int64(310),
+		"Timestamp": uint64(1622316222),
+		"Synthetic": true,
+	}
+
+	r, dropped, err := Convert(m, "cbor", "pb")
+	assert.NoError(t, err)
+	assert.Nil(t, dropped)
+	equal(t, records()["pb"], r, "cbor->pb")
+
+	r, _, err = Convert(r, "pb", "cbor")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(310), r.(map[string]interface{})["RTT"])
+
+	assert.True(t, Supported("cbor"))
+}
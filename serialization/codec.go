@@ -0,0 +1,62 @@
+package serialization
+
+import (
+	"fmt"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Marshal encodes the protobuf message, the pb records are encoded by the
+// generated codec unless it's built with the novtproto tag. the encoding
+// is identical to the standard codec.
+func Marshal(m proto.Message) ([]byte, error) {
+	return marshal(m)
+}
+
+// Unmarshal decodes the protobuf message
+func Unmarshal(b []byte, m proto.Message) error {
+	return unmarshal(b, m)
+}
+
+// Codec is the gRPC proto codec which uses the generated codec, it's
+// forced per connection thus it handles the APIv1 messages as well
+// e.g. the older health and reflection services.
+type Codec struct{}
+
+// Marshal encodes the message
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	m, err := message(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshal(m)
+}
+
+// Unmarshal decodes the message
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	m, err := message(v)
+	if err != nil {
+		return err
+	}
+
+	return unmarshal(data, m)
+}
+
+// message returns the APIv2 message of v like the grpc proto codec
+func message(v interface{}) (proto.Message, error) {
+	switch m := v.(type) {
+	case proto.Message:
+		return m, nil
+	case protov1.Message:
+		return protov1.MessageV2(m), nil
+	}
+
+	return nil, fmt.Errorf("proto codec: message is %T, want proto.Message", v)
+}
+
+// Name returns the codec name
+func (Codec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,19 @@
+//go:build novtproto
+// +build novtproto
+
+package serialization
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// the standard codec for the platforms which the generated codec
+// doesn't support.
+
+func marshal(m proto.Message) ([]byte, error) {
+	return proto.Marshal(m)
+}
+
+func unmarshal(b []byte, m proto.Message) error {
+	return proto.Unmarshal(b, m)
+}
@@ -0,0 +1,280 @@
+package serialization
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+var corpusStrings = []string{"", "10.0.0.1", "fe80::1", "curl", "héllo, 世界", "TCP_CLOSE"}
+
+// corpus returns the random records, the fields are set or missing
+// randomly and the numbers cover the varint boundaries.
+func corpus(n int) []*pb.Fields {
+	r := rand.New(rand.NewSource(1))
+	numbers := []uint64{0, 1, 127, 128, 16383, 16384, math.MaxUint32, math.MaxUint64}
+
+	records := make([]*pb.Fields, n)
+	for i := range records {
+		m := &pb.Fields{}
+		fields := m.ProtoReflect()
+
+		for j := 0; j < fieldsDesc.Len(); j++ {
+			fd := fieldsDesc.Get(j)
+			if r.Intn(3) == 0 {
+				continue
+			}
+
+			switch fd.Kind() {
+			case protoreflect.StringKind:
+				fields.Set(fd, protoreflect.ValueOfString(corpusStrings[r.Intn(len(corpusStrings))]))
+			case protoreflect.BoolKind:
+				fields.Set(fd, protoreflect.ValueOfBool(r.Intn(2) == 0))
+			case protoreflect.Uint32Kind:
+				fields.Set(fd, protoreflect.ValueOfUint32(uint32(numbers[r.Intn(len(numbers))])))
+			case protoreflect.Uint64Kind:
+				fields.Set(fd, protoreflect.ValueOfUint64(numbers[r.Intn(len(numbers))]))
+			case protoreflect.DoubleKind:
+				fields.Set(fd, protoreflect.ValueOfFloat64(float64(numbers[r.Intn(len(numbers))])/3))
+			}
+		}
+
+		records[i] = m
+	}
+
+	return records
+}
+
+func TestCodecCorpus(t *testing.T) {
+	for _, m := range corpus(500) {
+		expected, err := proto.Marshal(m)
+		assert.NoError(t, err)
+
+		b, err := Marshal(m)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, b)
+
+		m2 := &pb.Fields{}
+		assert.NoError(t, Unmarshal(b, m2))
+		assert.True(t, proto.Equal(m, m2))
+	}
+}
+
+func TestCodecUnknownFields(t *testing.T) {
+	b, _ := proto.Marshal(&pb.Fields{PID: proto.Uint32(5), SAddr: proto.String("10.0.0.1")})
+
+	// an unknown field and a known field with the wrong wire type
+	b = protowire.AppendTag(b, 1000, protowire.BytesType)
+	b = protowire.AppendString(b, "foo")
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, "bar")
+
+	expected := &pb.Fields{}
+	assert.NoError(t, proto.Unmarshal(b, expected))
+
+	m := &pb.Fields{PID: proto.Uint32(6), RTT: proto.Uint32(1)}
+	assert.NoError(t, Unmarshal(b, m))
+	assert.True(t, proto.Equal(expected, m))
+	assert.Equal(t, uint32(5), m.GetPID())
+	assert.Nil(t, m.RTT)
+
+	b1, _ := proto.Marshal(m)
+	b2, err := Marshal(m)
+	assert.NoError(t, err)
+	assert.Equal(t, b1, b2)
+}
+
+func TestCodecErrors(t *testing.T) {
+	invalid := [][]byte{
+		{0x10},                   // truncated varint
+		{0x2a, 0x05, 'a'},        // truncated bytes
+		{0x2a, 0x02, 0xff, 0xfe}, // invalid utf8
+		{0x00, 0x01},             // field number zero
+		{0x0c},                   // end group
+	}
+
+	for _, b := range invalid {
+		assert.Error(t, proto.Unmarshal(b, &pb.Fields{}))
+		assert.Error(t, Unmarshal(b, &pb.Fields{}), "%x", b)
+	}
+
+	_, err := Marshal(&pb.Fields{SAddr: proto.String("\xff")})
+	assert.Error(t, err)
+}
+
+func TestCodecSPB(t *testing.T) {
+	m, _, err := Convert(map[string]interface{}{"PID": 5.0, "SAddr": "10.0.0.1"}, "json", "spb")
+	assert.NoError(t, err)
+
+	b, err := Codec{}.Marshal(m)
+	assert.NoError(t, err)
+
+	m2 := &pb.FieldsSPB{}
+	assert.NoError(t, Codec{}.Unmarshal(b, m2))
+	assert.True(t, proto.Equal(m.(proto.Message), m2))
+	assert.Equal(t, "proto", Codec{}.Name())
+}
+
+func TestCodecPool(t *testing.T) {
+	// the encoded messages don't share the pooled buffer
+	var encoded [][]byte
+	records := corpus(50)
+	for _, m := range records {
+		b, err := Marshal(m)
+		assert.NoError(t, err)
+		encoded = append(encoded, b)
+	}
+
+	for i, b := range encoded {
+		m := &pb.Fields{}
+		assert.NoError(t, Unmarshal(b, m))
+		assert.True(t, proto.Equal(records[i], m))
+	}
+
+	var spbs []proto.Message
+	encoded = encoded[:0]
+	for i := 0; i < 50; i++ {
+		m, _, err := Convert(map[string]interface{}{"PID": float64(i), "Task": strings.Repeat("a", i*10)}, "json", "spb")
+		assert.NoError(t, err)
+		b, err := Marshal(m.(proto.Message))
+		assert.NoError(t, err)
+		spbs = append(spbs, m.(proto.Message))
+		encoded = append(encoded, b)
+	}
+
+	for i, b := range encoded {
+		m := &pb.FieldsSPB{}
+		assert.NoError(t, Unmarshal(b, m))
+		assert.True(t, proto.Equal(spbs[i], m))
+	}
+}
+
+func BenchmarkMarshalStd(b *testing.B) {
+	records := corpus(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		proto.Marshal(records[i%len(records)])
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	records := corpus(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Marshal(records[i%len(records)])
+	}
+}
+
+func BenchmarkMarshalSPB(b *testing.B) {
+	m, _, _ := Convert(map[string]interface{}{"PID": 5.0, "SAddr": "10.0.0.1", "Task": "curl"}, "json", "spb")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Marshal(m.(proto.Message))
+	}
+}
+
+func encodedCorpus(n int) [][]byte {
+	records := corpus(n)
+	encoded := make([][]byte, n)
+	for i, m := range records {
+		encoded[i], _ = proto.Marshal(m)
+	}
+
+	return encoded
+}
+
+func BenchmarkUnmarshalStd(b *testing.B) {
+	encoded := encodedCorpus(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		proto.Unmarshal(encoded[i%len(encoded)], &pb.Fields{})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	encoded := encodedCorpus(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Unmarshal(encoded[i%len(encoded)], &pb.Fields{})
+	}
+}
+
+// the flow benchmarks encode the record on the agent side, decode
+// it on the server side and convert it to the json serialization.
+
+func BenchmarkFlowStd(b *testing.B) {
+	records := corpus(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf, _ := proto.Marshal(records[i%len(records)])
+		m := &pb.Fields{}
+		proto.Unmarshal(buf, m)
+		Convert(m, "pb", "json")
+	}
+}
+
+func BenchmarkFlow(b *testing.B) {
+	records := corpus(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf, _ := Marshal(records[i%len(records)])
+		m := &pb.Fields{}
+		Unmarshal(buf, m)
+		Convert(m, "pb", "json")
+	}
+}
+
+// legacyMessage is an APIv1 message, it doesn't implement ProtoReflect
+type legacyMessage struct {
+	Service string `protobuf:"bytes,1,opt,name=service,proto3"`
+}
+
+func (m *legacyMessage) Reset()         { *m = legacyMessage{} }
+func (m *legacyMessage) String() string { return m.Service }
+func (*legacyMessage) ProtoMessage()    {}
+
+func TestCodecLegacyMessage(t *testing.T) {
+	c := Codec{}
+
+	b, err := c.Marshal(&legacyMessage{Service: "tcpdog"})
+	assert.NoError(t, err)
+	assert.Equal(t, append([]byte{0x0a, 0x06}, "tcpdog"...), b)
+
+	m := &legacyMessage{}
+	assert.NoError(t, c.Unmarshal(b, m))
+	assert.Equal(t, "tcpdog", m.Service)
+
+	// the pb records still use the generated codec
+	b, err = c.Marshal(&pb.Fields{RTT: proto.Uint32(5)})
+	assert.NoError(t, err)
+	f := &pb.Fields{}
+	assert.NoError(t, c.Unmarshal(b, f))
+	assert.Equal(t, uint32(5), f.GetRTT())
+
+	// it's an error instead of a panic
+	_, err = c.Marshal("foo")
+	assert.EqualError(t, err, "proto codec: message is string, want proto.Message")
+	assert.Error(t, c.Unmarshal(b, struct{}{}))
+}
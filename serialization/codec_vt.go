@@ -0,0 +1,59 @@
+//go:build !novtproto
+// +build !novtproto
+
+package serialization
+
+import (
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// bufPool is the encoding buffers, the encoded message is copied
+// to the exact size slice since the callers keep it.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+func marshal(m proto.Message) ([]byte, error) {
+	bp := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bp)
+
+	var (
+		b   []byte
+		err error
+	)
+
+	switch f := m.(type) {
+	case *pb.Fields:
+		size := f.SizeVT()
+		if cap(*bp) < size {
+			*bp = make([]byte, size)
+		}
+		b = (*bp)[:size]
+		_, err = f.MarshalToSizedBufferVT(b)
+	default:
+		// the spb records and the other messages
+		b, err = proto.MarshalOptions{}.MarshalAppend((*bp)[:0], m)
+		*bp = b[:0]
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(make([]byte, 0, len(b)), b...), nil
+}
+
+func unmarshal(b []byte, m proto.Message) error {
+	if f, ok := m.(*pb.Fields); ok {
+		return f.UnmarshalVT(b)
+	}
+
+	return proto.Unmarshal(b, m)
+}
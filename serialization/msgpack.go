@@ -0,0 +1,100 @@
+package serialization
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// MarshalMsgpack encodes the record map by msgpack, the keys are sorted
+// thus the same record is always encoded to the same bytes.
+func MarshalMsgpack(m map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf).SortMapKeys(true)
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalMsgpack decodes the msgpack record to the json record
+// shape, the integers are int64 (uint64 if they overflow) and they
+// aren't float64.
+func UnmarshalMsgpack(b []byte) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(b)).UseDecodeInterfaceLoose(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	// the positive integers may be encoded as unsigned
+	for k, v := range m {
+		if n, ok := v.(uint64); ok && n <= math.MaxInt64 {
+			m[k] = int64(n)
+		}
+	}
+
+	return m, nil
+}
+
+// JSONToMsgpack encodes the json event by msgpack, the json
+// integers are encoded as the msgpack integers.
+func JSONToMsgpack(b []byte) ([]byte, error) {
+	m, err := jsonRecord(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return MarshalMsgpack(m)
+}
+
+// jsonRecord decodes the json event, the json integers are
+// int64 and the other numbers are float64.
+func jsonRecord(b []byte) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	for k, v := range m {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+
+		if i, err := n.Int64(); err == nil {
+			m[k] = i
+		} else if f, err := n.Float64(); err == nil {
+			m[k] = f
+		} else {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Number returns the float64 of a number of the json or
+// msgpack records, the msgpack integers are converted.
+func Number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,71 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestMsgpack(t *testing.T) {
+	b, err := JSONToMsgpack([]byte(`{"Task":"curl","RTT":310,"Rate":0.5,"Synthetic":true}`))
+	assert.NoError(t, err)
+
+	m, err := UnmarshalMsgpack(b)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Task":      "curl",
+		"RTT":       int64(310),
+		"Rate":      0.5,
+		"Synthetic": true,
+	}, m)
+
+	// the keys are sorted
+	b2, err := MarshalMsgpack(m)
+	assert.NoError(t, err)
+	assert.Equal(t, b, b2)
+
+	_, err = JSONToMsgpack([]byte(`{"RTT":`))
+	assert.Error(t, err)
+}
+
+func TestConvertMsgpack(t *testing.T) {
+	m := map[string]interface{}{
+		"Task":      "curl",
+		"DAddr":     "10.0.0.1",
+		"RTT":       int64(310),
+		"Timestamp": uint64(1622316222),
+		"Synthetic": true,
+	}
+
+	r, dropped, err := Convert(m, "msgpack", "pb")
+	assert.NoError(t, err)
+	assert.Nil(t, dropped)
+	equal(t, records()["pb"], r, "msgpack->pb")
+
+	// the integers are kept
+	r, _, err = Convert(r, "pb", "msgpack")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(310), r.(map[string]interface{})["RTT"])
+	assert.Equal(t, uint64(1622316222), r.(map[string]interface{})["Timestamp"])
+	assert.Equal(t, "curl", r.(map[string]interface{})["Task"])
+
+	r, _, err = Convert(m, "msgpack", "json")
+	assert.NoError(t, err)
+	assert.Equal(t, m, r)
+
+	_, _, err = Convert(&pb.Fields{}, "msgpack", "json")
+	assert.EqualError(t, err, "invalid msgpack record: *tcpdog.Fields")
+}
+
+func TestNumber(t *testing.T) {
+	for _, v := range []interface{}{float64(5), int64(5), uint64(5), float32(5)} {
+		n, ok := Number(v)
+		assert.True(t, ok)
+		assert.Equal(t, float64(5), n)
+	}
+
+	_, ok := Number("5")
+	assert.False(t, ok)
+}
@@ -0,0 +1,254 @@
+// Package serialization converts the decoded records between the
+// flow serializations: json, msgpack and cbor (map[string]interface{}),
+// spb (*pb.FieldsSPB) and pb (*pb.Fields). the conversions work on the
+// decoded records by reflection, a record is never encoded and decoded again.
+package serialization
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+var fieldsDesc = (&pb.Fields{}).ProtoReflect().Descriptor().Fields()
+
+// Supported returns true if the serialization is supported
+func Supported(ser string) bool {
+	switch ser {
+	case "json", "spb", "pb", "msgpack", "cbor":
+		return true
+	}
+
+	return false
+}
+
+// Convert converts the record from a serialization to another one, the
+// fields which don't exist in the target schema are dropped and their
+// names are returned sorted. the conversions to pb are the only lossy ones.
+func Convert(record interface{}, from, to string) (interface{}, []string, error) {
+	if !Supported(from) {
+		return nil, nil, fmt.Errorf("serialization %s is not supported", from)
+	}
+
+	if !Supported(to) {
+		return nil, nil, fmt.Errorf("serialization %s is not supported", to)
+	}
+
+	switch r := record.(type) {
+	case map[string]interface{}:
+		if from != "json" && from != "msgpack" && from != "cbor" {
+			break
+		}
+
+		switch to {
+		case "spb":
+			s, err := structpb.NewStruct(r)
+			return &pb.FieldsSPB{Fields: s}, nil, err
+		case "pb":
+			return mapToPB(r)
+		}
+
+		return r, nil, nil
+
+	case *pb.FieldsSPB:
+		if from != "spb" {
+			break
+		}
+
+		switch to {
+		case "json", "msgpack", "cbor":
+			return r.GetFields().AsMap(), nil, nil
+		case "pb":
+			return structToPB(r.GetFields())
+		}
+
+		return r, nil, nil
+
+	case *pb.Fields:
+		if from != "pb" {
+			break
+		}
+
+		switch to {
+		case "json":
+			return pbToMap(r, false), nil, nil
+		case "msgpack", "cbor":
+			return pbToMap(r, true), nil, nil
+		case "spb":
+			return &pb.FieldsSPB{Fields: pbToStruct(r)}, nil, nil
+		}
+
+		return r, nil, nil
+	}
+
+	return nil, nil, fmt.Errorf("invalid %s record: %T", from, record)
+}
+
+func mapToPB(m map[string]interface{}) (*pb.Fields, []string, error) {
+	var (
+		fields  = &pb.Fields{}
+		dropped []string
+	)
+
+	for key, value := range m {
+		fd := fieldsDesc.ByName(protoreflect.Name(key))
+		if fd == nil {
+			dropped = append(dropped, key)
+			continue
+		}
+
+		if err := set(fields.ProtoReflect(), fd, value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sort.Strings(dropped)
+
+	return fields, dropped, nil
+}
+
+func structToPB(s *structpb.Struct) (*pb.Fields, []string, error) {
+	var (
+		fields  = &pb.Fields{}
+		dropped []string
+	)
+
+	for key, value := range s.GetFields() {
+		fd := fieldsDesc.ByName(protoreflect.Name(key))
+		if fd == nil {
+			dropped = append(dropped, key)
+			continue
+		}
+
+		var v interface{}
+
+		switch kind := value.GetKind().(type) {
+		case *structpb.Value_StringValue:
+			v = kind.StringValue
+		case *structpb.Value_NumberValue:
+			v = kind.NumberValue
+		case *structpb.Value_BoolValue:
+			v = kind.BoolValue
+		default:
+			v = value.AsInterface()
+		}
+
+		if err := set(fields.ProtoReflect(), fd, v); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sort.Strings(dropped)
+
+	return fields, dropped, nil
+}
+
+// set sets the protobuf field, the numbers are float64 in the json
+// and spb records and they may be integers in the msgpack and cbor.
+func set(msg protoreflect.Message, fd protoreflect.FieldDescriptor, value interface{}) error {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid %s value: %v", fd.Name(), value)
+		}
+		msg.Set(fd, protoreflect.ValueOfString(s))
+
+	case protoreflect.BoolKind:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("invalid %s value: %v", fd.Name(), value)
+		}
+		msg.Set(fd, protoreflect.ValueOfBool(b))
+
+	case protoreflect.Uint32Kind:
+		f, ok := Number(value)
+		if !ok || f < 0 || f > math.MaxUint32 {
+			return fmt.Errorf("invalid %s value: %v", fd.Name(), value)
+		}
+		msg.Set(fd, protoreflect.ValueOfUint32(uint32(f)))
+
+	case protoreflect.Uint64Kind:
+		f, ok := Number(value)
+		if !ok || f < 0 {
+			return fmt.Errorf("invalid %s value: %v", fd.Name(), value)
+		}
+		msg.Set(fd, protoreflect.ValueOfUint64(uint64(f)))
+
+	case protoreflect.Int32Kind:
+		f, ok := Number(value)
+		if !ok || f < math.MinInt32 || f > math.MaxInt32 {
+			return fmt.Errorf("invalid %s value: %v", fd.Name(), value)
+		}
+		msg.Set(fd, protoreflect.ValueOfInt32(int32(f)))
+
+	case protoreflect.DoubleKind:
+		f, ok := Number(value)
+		if !ok {
+			return fmt.Errorf("invalid %s value: %v", fd.Name(), value)
+		}
+		msg.Set(fd, protoreflect.ValueOfFloat64(f))
+
+	default:
+		return fmt.Errorf("%s kind %s is not supported", fd.Name(), fd.Kind())
+	}
+
+	return nil
+}
+
+// pbToMap returns the record map, the numbers are float64 like the
+// json records unless the integers are requested e.g. for the msgpack and cbor.
+func pbToMap(fields *pb.Fields, integers bool) map[string]interface{} {
+	m := map[string]interface{}{}
+
+	fields.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if integers && fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BoolKind &&
+			fd.Kind() != protoreflect.DoubleKind {
+			m[string(fd.Name())] = v.Uint()
+			return true
+		}
+
+		m[string(fd.Name())] = value(fd, v)
+		return true
+	})
+
+	return m
+}
+
+func pbToStruct(fields *pb.Fields) *structpb.Struct {
+	s := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+
+	fields.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			s.Fields[string(fd.Name())] = structpb.NewStringValue(v.String())
+		case protoreflect.BoolKind:
+			s.Fields[string(fd.Name())] = structpb.NewBoolValue(v.Bool())
+		case protoreflect.DoubleKind:
+			s.Fields[string(fd.Name())] = structpb.NewNumberValue(v.Float())
+		default:
+			s.Fields[string(fd.Name())] = structpb.NewNumberValue(float64(v.Uint()))
+		}
+		return true
+	})
+
+	return s
+}
+
+func value(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.DoubleKind:
+		return v.Float()
+	}
+
+	return float64(v.Uint())
+}
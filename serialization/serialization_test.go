@@ -0,0 +1,141 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func records() map[string]interface{} {
+	task, daddr, rtt, timestamp, synthetic := "curl", "10.0.0.1", uint32(310), uint64(1622316222), true
+
+	return map[string]interface{}{
+		"json": map[string]interface{}{
+			"Task":      "curl",
+			"DAddr":     "10.0.0.1",
+			"RTT":       float64(310),
+			"Timestamp": float64(1622316222),
+			"Synthetic": true,
+		},
+		"spb": &pb.FieldsSPB{Fields: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"Task":      structpb.NewStringValue("curl"),
+			"DAddr":     structpb.NewStringValue("10.0.0.1"),
+			"RTT":       structpb.NewNumberValue(310),
+			"Timestamp": structpb.NewNumberValue(1622316222),
+			"Synthetic": structpb.NewBoolValue(true),
+		}}},
+		"pb": &pb.Fields{Task: &task, DAddr: &daddr, RTT: &rtt, Timestamp: &timestamp, Synthetic: &synthetic},
+	}
+}
+
+func equal(t *testing.T, expected, actual interface{}, msg string) {
+	if m, ok := expected.(proto.Message); ok {
+		assert.True(t, proto.Equal(m, actual.(proto.Message)), msg)
+		return
+	}
+
+	assert.Equal(t, expected, actual, msg)
+}
+
+func TestConvert(t *testing.T) {
+	for _, from := range []string{"json", "spb", "pb"} {
+		for _, to := range []string{"json", "spb", "pb"} {
+			r, dropped, err := Convert(records()[from], from, to)
+			assert.NoError(t, err, from+"->"+to)
+			assert.Nil(t, dropped, from+"->"+to)
+			equal(t, records()[to], r, from+"->"+to)
+
+			// round trip
+			r, _, err = Convert(r, to, from)
+			assert.NoError(t, err, to+"->"+from)
+			equal(t, records()[from], r, to+"->"+from)
+		}
+	}
+}
+
+func TestConvertLossy(t *testing.T) {
+	expected := records()["pb"]
+
+	m := records()["json"].(map[string]interface{})
+	m["RTTP95"] = float64(320)
+	m["AnomalyScore"] = float64(2)
+
+	r, dropped, err := Convert(m, "json", "pb")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AnomalyScore", "RTTP95"}, dropped)
+	equal(t, expected, r, "json->pb")
+
+	s, _ := structpb.NewStruct(m)
+	r, dropped, err = Convert(&pb.FieldsSPB{Fields: s}, "spb", "pb")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AnomalyScore", "RTTP95"}, dropped)
+	equal(t, expected, r, "spb->pb")
+
+	// json and spb keep the extra fields
+	r, dropped, err = Convert(m, "json", "spb")
+	assert.NoError(t, err)
+	assert.Nil(t, dropped)
+	assert.Len(t, r.(*pb.FieldsSPB).GetFields().GetFields(), 7)
+}
+
+func TestConvertError(t *testing.T) {
+	_, _, err := Convert(records()["json"], "json", "avro")
+	assert.EqualError(t, err, "serialization avro is not supported")
+
+	_, _, err = Convert(records()["pb"], "json", "spb")
+	assert.EqualError(t, err, "invalid json record: *tcpdog.Fields")
+
+	_, _, err = Convert(map[string]interface{}{"RTT": "fast"}, "json", "pb")
+	assert.EqualError(t, err, "invalid RTT value: fast")
+
+	_, _, err = Convert(map[string]interface{}{"RTT": float64(-1)}, "json", "pb")
+	assert.EqualError(t, err, "invalid RTT value: -1")
+}
+
+func TestConvertPresence(t *testing.T) {
+	zero := uint32(0)
+
+	// the measured zero is kept and the missing field stays missing
+	r, _, err := Convert(map[string]interface{}{"RTT": float64(0)}, "json", "pb")
+	assert.NoError(t, err)
+	assert.NotNil(t, r.(*pb.Fields).RTT)
+	assert.Nil(t, r.(*pb.Fields).TotalRetrans)
+
+	r, _, err = Convert(&pb.Fields{RTT: &zero}, "pb", "json")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(0)}, r)
+
+	r, _, err = Convert(&pb.Fields{RTT: &zero}, "pb", "spb")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(0)}, r.(*pb.FieldsSPB).GetFields().AsMap())
+}
+
+func TestWirePresence(t *testing.T) {
+	fd := fieldsDesc.ByName("RTT")
+	zero := uint32(0)
+
+	// the agent encodes the measured zero explicitly, the servers
+	// without presence decode it as zero as well.
+	b, err := proto.Marshal(&pb.Fields{RTT: &zero})
+	assert.NoError(t, err)
+	assert.Equal(t, protowire.AppendVarint(protowire.AppendTag(nil, fd.Number(), protowire.VarintType), 0), b)
+
+	f := &pb.Fields{}
+	assert.NoError(t, proto.Unmarshal(b, f))
+	assert.NotNil(t, f.RTT)
+
+	// an agent without presence omits the zero on the wire
+	// thus it's missing, never a fake zero.
+	f = &pb.Fields{}
+	assert.NoError(t, proto.Unmarshal(nil, f))
+	assert.Nil(t, f.RTT)
+
+	b = protowire.AppendVarint(protowire.AppendTag(nil, fd.Number(), protowire.VarintType), 5)
+	assert.NoError(t, proto.Unmarshal(b, f))
+	assert.Equal(t, uint32(5), f.GetRTT())
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/sethvargo/go-signalcontext"
 	"go.uber.org/zap"
 
 	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/server"
 )
 
 var version string
@@ -17,24 +19,28 @@ func main() {
 		exit(err)
 	}
 
-	err = validate(cfg)
-	if err != nil {
-		exit(err)
-	}
-
 	logger := cfg.Logger()
 	logger.Info("tcpdog", zap.String("version", version), zap.String("type", "server"))
 
 	ctx, cancel := signalcontext.OnInterrupt()
 	defer cancel()
 
-	ctx = cfg.WithContext(ctx)
+	if name := cfg.VerifyIngestion(); name != "" {
+		err = server.VerifyIngestion(ctx, cfg, name, os.Stdout)
+		if err != nil {
+			cancel()
+			exit(err)
+		}
+		return
+	}
 
-	for _, flow := range cfg.Flow {
-		ch := make(chan interface{}, 1000)
-		ingress(ctx, flow, ch)
-		ingestion(ctx, flow, ch)
+	err = server.Run(ctx, cfg)
+	if err != nil {
+		exit(err)
 	}
+}
 
-	<-ctx.Done()
+func exit(err error) {
+	fmt.Println(err)
+	os.Exit(1)
 }
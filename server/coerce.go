@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/coerce"
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/tracing"
+)
+
+const coerceStatsInterval = time.Minute
+
+// coerceFields coerces the json records fields on their way from in to
+// out, the records which the policy drops don't continue to the flow.
+func coerceFields(ctx context.Context, c *coerce.Coercer, name string, in, out chan interface{}, logger *zap.Logger) {
+	ticker := time.NewTicker(coerceStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case r := <-in:
+			if m, ok := r.(map[string]interface{}); ok && !c.Record(m) {
+				tracing.From(r).Finish(tracing.ErrDropped)
+				continue
+			}
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ticker.C:
+			failures := c.Failures()
+			if len(failures) < 1 {
+				continue
+			}
+
+			var names []string
+			for field := range failures {
+				names = append(names, field)
+			}
+			sort.Strings(names)
+
+			fields := []zap.Field{zap.String("ingress", name), zap.Uint64("dropped", c.Dropped())}
+			for _, field := range names {
+				fields = append(fields, zap.Uint64(field, failures[field]))
+			}
+			logger.Warn("coerce", fields...)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func validateCoerce(f config.Flow) error {
+	if !f.Coerce {
+		return nil
+	}
+
+	if f.Serialization != "json" {
+		return errors.New("coerce supports only json serialization")
+	}
+
+	_, err := coerce.New(f.OnCoerceError)
+
+	return err
+}
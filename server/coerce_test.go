@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/coerce"
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestCoerceFields(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, _ := coerce.New(coerce.DropRecord)
+	in := make(chan interface{}, 3)
+	out := make(chan interface{}, 3)
+
+	go coerceFields(ctx, c, "grpc", in, out, zap.NewNop())
+
+	in <- map[string]interface{}{"RTT": "foo"}
+	in <- map[string]interface{}{"RTT": "5"}
+
+	select {
+	case r := <-out:
+		assert.Equal(t, map[string]interface{}{"RTT": float64(5)}, r)
+	case <-time.After(time.Second):
+		t.Fatal("record has not been coerced")
+	}
+
+	assert.Len(t, out, 0)
+	assert.Equal(t, uint64(1), c.Dropped())
+}
+
+func TestValidateCoerce(t *testing.T) {
+	tests := []struct {
+		flow config.Flow
+		err  string
+	}{
+		{config.Flow{Serialization: "pb"}, ""},
+		{config.Flow{Coerce: true, Serialization: "json"}, ""},
+		{config.Flow{Coerce: true, Serialization: "json", OnCoerceError: "drop-record"}, ""},
+		{config.Flow{Coerce: true, Serialization: "spb"}, "coerce supports only json serialization"},
+		{config.Flow{Coerce: true, Serialization: "json", OnCoerceError: "foo"}, "onCoerceError foo is not supported"},
+	}
+
+	for _, tt := range tests {
+		err := validateCoerce(tt.flow)
+		if tt.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/columnar"
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/ingestion/clickhouse"
+	"github.com/mehrdadrad/tcpdog/tracing"
+)
+
+// columnarFields returns the schema fields of the ingestions
+// which support the columnar batches.
+var columnarFields = map[string]func(ctx context.Context, name string) ([]string, error){
+	"clickhouse": clickhouse.Fields,
+}
+
+// startColumnar starts the batches building of the flow, the
+// schema is the ingestion fields.
+func startColumnar(ctx context.Context, flow config.Flow, in chan interface{}) (chan interface{}, error) {
+	cfg := config.FromContextServer(ctx)
+
+	fields, err := columnarFields[cfg.Ingestion[flow.Ingestion].Type](ctx, flow.Ingestion)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := columnar.NewSchema(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan interface{}, 10)
+	b := columnar.NewBuilder(schema, flow.Columnar.BatchSize)
+	go buildBatches(ctx, b, flow.Columnar, in, out, cfg.Logger())
+
+	return out, nil
+}
+
+// buildBatches accumulates the records into the batches, a batch is
+// sent once it's full or the flush interval has been passed.
+func buildBatches(ctx context.Context, b *columnar.Builder, cCfg *config.Columnar, in, out chan interface{}, logger *zap.Logger) {
+	ticker := time.NewTicker(cCfg.FlushInterval)
+	defer ticker.Stop()
+
+	flush := func() bool {
+		if b.Len() < 1 {
+			return true
+		}
+
+		select {
+		case out <- b.NewBatch():
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case r := <-in:
+			// the trace of a record ends once it's appended to a batch
+			tr := tracing.From(r)
+
+			err := b.Append(r)
+			if err != nil {
+				logger.Warn("columnar", zap.Error(err))
+			}
+
+			tr.Finish(err)
+
+			if b.Len() >= cCfg.BatchSize && !flush() {
+				return
+			}
+		case <-ticker.C:
+			if !flush() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func validateColumnar(cfg *config.ServerConfig, f config.Flow) error {
+	if f.Columnar == nil {
+		return nil
+	}
+
+	if _, ok := columnarFields[cfg.Ingestion[f.Ingestion].Type]; !ok {
+		return fmt.Errorf("ingestion %s doesn't support columnar", f.Ingestion)
+	}
+
+	return nil
+}
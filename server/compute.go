@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+
+	"github.com/mehrdadrad/tcpdog/compute"
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// computeFields computes the derived fields of the
+// records on their way from in to out.
+func computeFields(ctx context.Context, c compute.Computer, in, out chan interface{}) {
+	for {
+		select {
+		case r := <-in:
+			c.Record(r)
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func validateCompute(f config.Flow) error {
+	_, err := compute.New(f.Compute)
+	return err
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/compute"
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestComputeFields(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, _ := compute.New([]string{"RetransRate"})
+	in := make(chan interface{}, 1)
+	out := make(chan interface{}, 1)
+
+	go computeFields(ctx, c, in, out)
+
+	in <- map[string]interface{}{"TotalRetrans": float64(5), "SegsOut": float64(100)}
+
+	select {
+	case r := <-out:
+		assert.Equal(t, 0.05, r.(map[string]interface{})["RetransRate"])
+	case <-time.After(time.Second):
+		t.Fatal("record has not been computed")
+	}
+}
+
+func TestValidateCompute(t *testing.T) {
+	assert.NoError(t, validateCompute(config.Flow{}))
+	assert.NoError(t, validateCompute(config.Flow{Compute: []string{"RetransRate"}}))
+	assert.EqualError(t, validateCompute(config.Flow{Compute: []string{"Foo"}}), "compute Foo is not supported")
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/intern"
+)
+
+const internStatsInterval = time.Minute
+
+// internFields interns the configured string fields of the records
+// on their way from in to out, the pool is shared by the flows.
+func internFields(ctx context.Context, pool *intern.Pool, fields []string, in, out chan interface{}) {
+	for {
+		select {
+		case r := <-in:
+			pool.Record(r, fields)
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logIntern logs the interning pool stats per interval
+func logIntern(ctx context.Context, pool *intern.Pool, logger *zap.Logger) {
+	ticker := time.NewTicker(internStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s := pool.Stats()
+			logger.Info("intern", zap.Int("entries", s.Entries),
+				zap.Float64("hitRate", s.HitRate()),
+				zap.Uint64("evictions", s.Evictions),
+				zap.Uint64("savedBytes", s.Saved))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+	"github.com/mehrdadrad/tcpdog/serialization"
+)
+
+const (
+	// maxClockSkew is the tolerance of the agents clocks, a record
+	// from the future beyond it has an untrusted timestamp.
+	maxClockSkew = time.Minute
+	// minTimestamp rejects the unset or the broken clocks (2001-09-09)
+	minTimestamp = 1000000000
+
+	lateStatsInterval = time.Minute
+)
+
+// late handles the records which are older than the max record age
+type late struct {
+	maxAge time.Duration
+	action string
+	route  chan interface{}
+	now    func() time.Time
+
+	dropped   uint64
+	tagged    uint64
+	routed    uint64
+	untrusted uint64
+	// routeFull counts the late records which are dropped
+	// as the late ingestion doesn't keep up.
+	routeFull uint64
+}
+
+func newLate(flow config.Flow) *late {
+	action := flow.LateAction
+	if action == "" {
+		action = "drop"
+	}
+
+	return &late{
+		maxAge: flow.MaxRecordAge,
+		action: action,
+		now:    time.Now,
+	}
+}
+
+// run checks the records age on their way from in to out
+func (l *late) run(ctx context.Context, in, out chan interface{}, logger *zap.Logger) {
+	ticker := time.NewTicker(lateStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case r := <-in:
+			if !l.pass(r) {
+				continue
+			}
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ticker.C:
+			logger.Info("late", zap.Uint64("dropped", atomic.LoadUint64(&l.dropped)),
+				zap.Uint64("tagged", atomic.LoadUint64(&l.tagged)),
+				zap.Uint64("routed", atomic.LoadUint64(&l.routed)),
+				zap.Uint64("routeFull", atomic.LoadUint64(&l.routeFull)),
+				zap.Uint64("untrusted", atomic.LoadUint64(&l.untrusted)))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pass returns true if the record should continue to the ingestion,
+// the late records are tagged, routed or dropped based on the action.
+// the timestamps from the future beyond the max clock skew are untrusted
+// but an agent clock which runs behind can't be told apart from the late
+// records thus its records are handled as the late ones.
+func (l *late) pass(r interface{}) bool {
+	ts, ok := timestamp(r)
+	now := l.now()
+
+	if !ok || ts < minTimestamp || time.Unix(int64(ts), 0).After(now.Add(maxClockSkew)) {
+		atomic.AddUint64(&l.untrusted, 1)
+		return true
+	}
+
+	if now.Sub(time.Unix(int64(ts), 0)) <= l.maxAge {
+		return true
+	}
+
+	switch l.action {
+	case "tag":
+		if tag(r) {
+			atomic.AddUint64(&l.tagged, 1)
+			return true
+		}
+	case "route":
+		select {
+		case l.route <- r:
+			atomic.AddUint64(&l.routed, 1)
+		default:
+			atomic.AddUint64(&l.routeFull, 1)
+		}
+		return false
+	}
+
+	atomic.AddUint64(&l.dropped, 1)
+
+	return false
+}
+
+func timestamp(r interface{}) (float64, bool) {
+	switch v := r.(type) {
+	case map[string]interface{}:
+		return serialization.Number(v["Timestamp"])
+	case *pb.FieldsSPB:
+		ts, ok := v.GetFields().GetFields()["Timestamp"]
+		return ts.GetNumberValue(), ok
+	case *pb.Fields:
+		return float64(v.GetTimestamp()), v.Timestamp != nil
+	}
+
+	return 0, false
+}
+
+// tag marks the record with late: true, it's a boolean field
+func tag(r interface{}) bool {
+	switch v := r.(type) {
+	case map[string]interface{}:
+		v["late"] = true
+		return true
+	case *pb.FieldsSPB:
+		if v.Fields == nil {
+			return false
+		}
+		v.Fields.Fields["late"] = structpb.NewBoolValue(true)
+		return true
+	}
+
+	// the protobuf doesn't have any late field
+	return false
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestLate(t *testing.T) {
+	now := time.Unix(1611118090, 0)
+
+	l := newLate(config.Flow{MaxRecordAge: time.Hour})
+	l.now = func() time.Time { return now }
+
+	fresh := float64(now.Add(-time.Minute).Unix())
+	old := float64(now.Add(-2 * time.Hour).Unix())
+	future := float64(now.Add(time.Hour).Unix())
+
+	assert.True(t, l.pass(map[string]interface{}{"Timestamp": fresh}))
+	assert.False(t, l.pass(map[string]interface{}{"Timestamp": old}))
+	assert.Equal(t, uint64(1), l.dropped)
+
+	// untrusted timestamps
+	assert.True(t, l.pass(map[string]interface{}{"Timestamp": future}))
+	assert.True(t, l.pass(map[string]interface{}{"RTT": float64(1)}))
+	assert.True(t, l.pass(map[string]interface{}{"Timestamp": float64(10)}))
+	assert.Equal(t, uint64(3), l.untrusted)
+
+	// pb
+	ts := uint64(old)
+	assert.False(t, l.pass(&pb.Fields{Timestamp: &ts}))
+
+	// tag
+	l.action = "tag"
+	m := map[string]interface{}{"Timestamp": old}
+	assert.True(t, l.pass(m))
+	assert.Equal(t, true, m["late"])
+
+	s, _ := structpb.NewStruct(map[string]interface{}{"Timestamp": old})
+	spb := &pb.FieldsSPB{Fields: s}
+	assert.True(t, l.pass(spb))
+	assert.Equal(t, true, spb.Fields.AsMap()["late"])
+	assert.Equal(t, uint64(2), l.tagged)
+
+	// route
+	l.action = "route"
+	l.route = make(chan interface{}, 1)
+	assert.False(t, l.pass(map[string]interface{}{"Timestamp": old}))
+	assert.Len(t, l.route, 1)
+	assert.Equal(t, uint64(1), l.routed)
+
+	// route is full
+	assert.False(t, l.pass(map[string]interface{}{"Timestamp": old}))
+	assert.Equal(t, uint64(1), l.routeFull)
+	assert.Equal(t, uint64(2), l.dropped)
+}
+
+func TestValidateLate(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Ingestion: map[string]config.Ingestion{"late": {Type: "influxdb"}},
+	}
+
+	tests := []struct {
+		flow config.Flow
+		err  string
+	}{
+		{config.Flow{}, ""},
+		{config.Flow{MaxRecordAge: time.Hour}, ""},
+		{config.Flow{MaxRecordAge: time.Hour, LateAction: "tag", Serialization: "json"}, ""},
+		{config.Flow{MaxRecordAge: time.Hour, LateAction: "tag", Serialization: "pb"}, "late action tag doesn't support pb serialization"},
+		{config.Flow{MaxRecordAge: time.Hour, LateAction: "route", LateIngestion: "late"}, ""},
+		{config.Flow{MaxRecordAge: time.Hour, LateAction: "route", LateIngestion: "foo"}, "late ingestion foo is not available"},
+		{config.Flow{MaxRecordAge: time.Hour, LateAction: "foo"}, "late action foo is not supported"},
+	}
+
+	for _, tt := range tests {
+		err := validateLate(cfg, tt.flow)
+		if tt.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+const mirrorStatsInterval = time.Minute
+
+// mirror tees a sample of the flow records to the mirror ingestion,
+// the records are copied to its bounded queue without blocking thus
+// the flow is never affected by the mirror ingestion. the errored are
+// the records which have been failed to copy or to write.
+type mirror struct {
+	percent float64
+	acc     float64
+	ch      chan interface{}
+
+	enabled int32
+	sent    uint64
+	dropped uint64
+	errored uint64
+}
+
+func newMirror(m *config.Mirror) *mirror {
+	return &mirror{
+		percent: m.Percent,
+		ch:      make(chan interface{}, m.QueueSize),
+		enabled: 1,
+	}
+}
+
+// Enabled returns true if the mirror is turned on
+func (m *mirror) Enabled() bool {
+	return atomic.LoadInt32(&m.enabled) == 1
+}
+
+// SetEnabled turns the mirror on or off, it's the admin kill switch
+func (m *mirror) SetEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+
+	atomic.StoreInt32(&m.enabled, v)
+}
+
+// Stats returns the mirror counters
+func (m *mirror) Stats() map[string]uint64 {
+	return map[string]uint64{
+		"sent":    atomic.LoadUint64(&m.sent),
+		"dropped": atomic.LoadUint64(&m.dropped),
+		"errored": atomic.LoadUint64(&m.errored),
+	}
+}
+
+// failed counts the records which the mirror ingestion has been failed to write
+func (m *mirror) failed(n int) {
+	atomic.AddUint64(&m.errored, uint64(n))
+}
+
+// run tees the records on their way from in to out
+func (m *mirror) run(ctx context.Context, name string, in, out chan interface{}, logger *zap.Logger) {
+	ticker := time.NewTicker(mirrorStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case r := <-in:
+			m.tee(r)
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ticker.C:
+			logger.Info("mirror", zap.String("name", name), zap.Bool("enabled", m.Enabled()),
+				zap.Uint64("sent", atomic.LoadUint64(&m.sent)),
+				zap.Uint64("dropped", atomic.LoadUint64(&m.dropped)),
+				zap.Uint64("errored", atomic.LoadUint64(&m.errored)))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tee sends a copy of the record if it's sampled, the sampling
+// accumulates the percent thus it's exact on any rate.
+func (m *mirror) tee(r interface{}) {
+	if !m.Enabled() {
+		return
+	}
+
+	m.acc += m.percent
+	if m.acc < 100 {
+		return
+	}
+	m.acc -= 100
+
+	c, ok := clone(r)
+	if !ok {
+		atomic.AddUint64(&m.errored, 1)
+		return
+	}
+
+	select {
+	case m.ch <- c:
+		atomic.AddUint64(&m.sent, 1)
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// clone copies the record since the ingestions may modify it
+func clone(r interface{}) (interface{}, bool) {
+	switch v := r.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			c[key] = value
+		}
+		return c, true
+	case *pb.FieldsSPB:
+		return proto.Clone(v), true
+	case *pb.Fields:
+		return proto.Clone(v), true
+	}
+
+	return nil, false
+}
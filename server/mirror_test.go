@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestMirrorTee(t *testing.T) {
+	m := newMirror(&config.Mirror{Percent: 5, QueueSize: 10})
+
+	for i := 0; i < 100; i++ {
+		m.tee(map[string]interface{}{"RTT": float64(i)})
+	}
+	assert.Len(t, m.ch, 5)
+	assert.Equal(t, map[string]uint64{"sent": 5, "dropped": 0, "errored": 0}, m.Stats())
+
+	// the queue overflow
+	m.percent = 100
+	for i := 0; i < 10; i++ {
+		m.tee(map[string]interface{}{"RTT": float64(i)})
+	}
+	assert.Equal(t, map[string]uint64{"sent": 10, "dropped": 5, "errored": 0}, m.Stats())
+
+	// kill switch
+	m.SetEnabled(false)
+	assert.False(t, m.Enabled())
+	m.tee(map[string]interface{}{"RTT": float64(1)})
+	assert.Equal(t, uint64(5), m.Stats()["dropped"])
+
+	// unknown record
+	m.SetEnabled(true)
+	<-m.ch
+	m.tee("foo")
+	assert.Equal(t, uint64(1), m.Stats()["errored"])
+}
+
+func TestMirrorRun(t *testing.T) {
+	m := newMirror(&config.Mirror{Percent: 50, QueueSize: 1})
+
+	in := make(chan interface{}, 10)
+	out := make(chan interface{}, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go m.run(ctx, "grpc01/ch01", in, out, zap.NewNop())
+
+	// the mirror queue is full but the flow isn't blocked
+	for i := 0; i < 6; i++ {
+		in <- map[string]interface{}{"RTT": float64(i)}
+	}
+
+	for i := 0; i < 6; i++ {
+		select {
+		case r := <-out:
+			assert.Equal(t, float64(i), r.(map[string]interface{})["RTT"])
+		case <-time.After(time.Second):
+			t.Fatal("flow has been blocked")
+		}
+	}
+
+	assert.Equal(t, map[string]uint64{"sent": 1, "dropped": 2, "errored": 0}, m.Stats())
+}
+
+func TestMirrorIngestionError(t *testing.T) {
+	m := newMirror(&config.Mirror{Percent: 100, QueueSize: 1})
+
+	// the mirror ingestion write errors are counted
+	defer metrics.OnIngestionError("grpc01/es-mirror", m.failed)()
+	metrics.IngestionError("grpc01/es-mirror", "es-mirror", 3)
+	metrics.IngestionError("grpc01/es01", "es01", 1)
+
+	assert.Equal(t, uint64(3), m.Stats()["errored"])
+}
+
+func TestMirrorClone(t *testing.T) {
+	r := map[string]interface{}{"RTT": float64(1)}
+	c, ok := clone(r)
+	assert.True(t, ok)
+	r["RTT"] = float64(2)
+	assert.Equal(t, map[string]interface{}{"RTT": float64(1)}, c)
+
+	rtt := uint32(1)
+	p := &pb.Fields{RTT: &rtt}
+	c, ok = clone(p)
+	assert.True(t, ok)
+	*p.RTT = 2
+	assert.Equal(t, uint32(1), c.(*pb.Fields).GetRTT())
+}
+
+func TestValidateMirror(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Ingestion: map[string]config.Ingestion{"es01": {}, "ch01": {}},
+	}
+
+	flow := config.Flow{Ingestion: "es01", Mirror: &config.Mirror{Ingestion: "ch01", Percent: 5}}
+	assert.NoError(t, validateMirror(cfg, flow))
+
+	flow.Mirror.Percent = 0
+	assert.Error(t, validateMirror(cfg, flow))
+
+	flow.Mirror.Percent = 5
+	flow.Mirror.OnError = "retry"
+	assert.EqualError(t, validateMirror(cfg, flow), "mirror onError retry is not supported")
+
+	flow.Mirror = &config.Mirror{Ingestion: "es01", Percent: 5}
+	assert.Error(t, validateMirror(cfg, flow))
+
+	flow.Mirror = &config.Mirror{Ingestion: "ch02", Percent: 5}
+	assert.EqualError(t, validateMirror(cfg, flow), "mirror ingestion ch02 is not available")
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/tracing"
+)
+
+// the overflow policies of a flow
+const (
+	overflowBlock      = "block"
+	overflowDropOldest = "drop-oldest"
+	overflowDropNew    = "drop-new"
+)
+
+// flowBuffer is the default capacity of the flow channel
+const flowBuffer = 1000
+
+// overflowBacklog is the ingress channel of a dropping flow,
+// it's drained continuously as the overflow never blocks.
+const overflowBacklog = 64
+
+// flowChannel returns the channel which the ingress sends to and the
+// flow channel, they're the same channel if the policy is block.
+func flowChannel(ctx context.Context, flow config.Flow) (chan interface{}, chan interface{}) {
+	size := flow.Buffer
+	if size < 1 {
+		size = flowBuffer
+	}
+
+	ch := make(chan interface{}, size)
+
+	if flow.Overflow == "" || flow.Overflow == overflowBlock {
+		return ch, ch
+	}
+
+	in := make(chan interface{}, overflowBacklog)
+	go overflow(ctx, flow.Overflow, flow.Ingress+"/"+flow.Ingestion, in, ch)
+
+	return in, ch
+}
+
+// overflow hands the records from in to out without blocking, the new
+// record (drop-new) or the oldest one (drop-oldest) is dropped once out
+// is full thus the ingress is never blocked by a slow ingestion.
+func overflow(ctx context.Context, policy, name string, in, out chan interface{}) {
+	for {
+		select {
+		case r := <-in:
+			select {
+			case out <- r:
+				continue
+			default:
+			}
+
+			if policy == overflowDropOldest {
+				select {
+				case old := <-out:
+					tracing.From(old).Finish(tracing.ErrDropped)
+					drops.Add(drops.ServerChannel, name, 1)
+				default:
+				}
+
+				select {
+				case out <- r:
+					continue
+				default:
+				}
+			}
+
+			tracing.From(r).Finish(tracing.ErrDropped)
+			drops.Add(drops.ServerChannel, name, 1)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func validateOverflow(f config.Flow) error {
+	if f.Buffer < 0 {
+		return fmt.Errorf("flow buffer %d is out of range", f.Buffer)
+	}
+
+	switch f.Overflow {
+	case "", overflowBlock, overflowDropOldest, overflowDropNew:
+	default:
+		return fmt.Errorf("overflow %s is not supported", f.Overflow)
+	}
+
+	return nil
+}
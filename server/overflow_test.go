@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+// fill sends the records to the ingress channel, it fails if the
+// ingress is blocked as the ingestion doesn't receive.
+func fill(t *testing.T, in chan interface{}, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case in <- i:
+		case <-time.After(time.Second):
+			t.Fatalf("ingress has been blocked at %d", i)
+		}
+	}
+}
+
+func TestFlowChannelBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in, ch := flowChannel(ctx, config.Flow{})
+	assert.Equal(t, ch, in)
+	assert.Equal(t, flowBuffer, cap(ch))
+
+	in, ch = flowChannel(ctx, config.Flow{Buffer: 2, Overflow: "block"})
+	assert.Equal(t, ch, in)
+
+	in <- 1
+	in <- 2
+
+	select {
+	case in <- 3:
+		t.Fatal("ingress hasn't been blocked")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestFlowChannelDropNew(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	flow := config.Flow{Ingress: "kafka01", Ingestion: "new", Buffer: 3, Overflow: "drop-new"}
+	dropped := drops.Count(drops.ServerChannel, "kafka01/new")
+
+	in, ch := flowChannel(ctx, flow)
+	fill(t, in, 10)
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.ServerChannel, "kafka01/new")-dropped == 7
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, 0, <-ch)
+	assert.Equal(t, 1, <-ch)
+	assert.Equal(t, 2, <-ch)
+}
+
+func TestFlowChannelDropOldest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	flow := config.Flow{Ingress: "kafka01", Ingestion: "oldest", Buffer: 3, Overflow: "drop-oldest"}
+	dropped := drops.Count(drops.ServerChannel, "kafka01/oldest")
+
+	in, ch := flowChannel(ctx, flow)
+	fill(t, in, 10)
+
+	assert.Eventually(t, func() bool {
+		return drops.Count(drops.ServerChannel, "kafka01/oldest")-dropped == 7
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, 7, <-ch)
+	assert.Equal(t, 8, <-ch)
+	assert.Equal(t, 9, <-ch)
+}
+
+func TestValidateOverflow(t *testing.T) {
+	tests := []struct {
+		flow config.Flow
+		err  string
+	}{
+		{config.Flow{}, ""},
+		{config.Flow{Buffer: 10000, Overflow: "block"}, ""},
+		{config.Flow{Overflow: "drop-oldest"}, ""},
+		{config.Flow{Overflow: "drop-new"}, ""},
+		{config.Flow{Buffer: -1}, "flow buffer -1 is out of range"},
+		{config.Flow{Overflow: "drop"}, "overflow drop is not supported"},
+	}
+
+	for _, tt := range tests {
+		err := validateOverflow(tt.flow)
+		if tt.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/priority"
+)
+
+const priorityStatsInterval = time.Minute
+
+// logPriority logs the flows high priority lanes stats per interval,
+// the high records bypass the mirror, tap and watchdog stages.
+func logPriority(ctx context.Context, lanes map[string]*priority.Lane, logger *zap.Logger) {
+	ticker := time.NewTicker(priorityStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for name, lane := range lanes {
+				s := lane.Stats()
+				logger.Info("priority", zap.String("flow", name), zap.Uint64("high", s.High),
+					zap.Uint64("fallback", s.Fallback), zap.Uint64("normal", s.Normal))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/admin"
+	"github.com/mehrdadrad/tcpdog/checkpoint"
+	"github.com/mehrdadrad/tcpdog/coerce"
+	"github.com/mehrdadrad/tcpdog/compute"
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/fault"
+	"github.com/mehrdadrad/tcpdog/geo"
+	"github.com/mehrdadrad/tcpdog/health"
+	"github.com/mehrdadrad/tcpdog/ingestion/clickhouse"
+	"github.com/mehrdadrad/tcpdog/ingestion/elasticsearch"
+	"github.com/mehrdadrad/tcpdog/ingestion/influxdb"
+	ikafka "github.com/mehrdadrad/tcpdog/ingestion/kafka"
+	"github.com/mehrdadrad/tcpdog/ingress/file"
+	"github.com/mehrdadrad/tcpdog/ingress/grpc"
+	"github.com/mehrdadrad/tcpdog/ingress/kafka"
+	"github.com/mehrdadrad/tcpdog/ingress/nats"
+	"github.com/mehrdadrad/tcpdog/intern"
+	"github.com/mehrdadrad/tcpdog/metrics"
+	"github.com/mehrdadrad/tcpdog/priority"
+	"github.com/mehrdadrad/tcpdog/processor/anomaly"
+	"github.com/mehrdadrad/tcpdog/provision"
+	"github.com/mehrdadrad/tcpdog/recordid"
+	"github.com/mehrdadrad/tcpdog/tracing"
+)
+
+// Option represents a server option
+type Option func(*options)
+
+type options struct {
+	status config.StatusFunc
+}
+
+// WithStatus sets a callback which receives the components
+// lifecycle events (ingresses and ingestions).
+func WithStatus(fn config.StatusFunc) Option {
+	return func(o *options) {
+		o.status = fn
+	}
+}
+
+// Run validates the configuration and starts the ingresses and
+// the ingestions of the flows. it blocks until the context is
+// canceled or a component fails to start.
+func Run(ctx context.Context, cfg *config.ServerConfig, opts ...Option) error {
+	o := &options{status: func(config.Status) {}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg.SetDefault()
+
+	err := validate(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(ctx))
+	defer cancel()
+
+	if fault.Allow(cfg.UnsafeFaultInjection) {
+		cfg.Logger().Warn("fault", zap.String("msg", "fault injection is enabled"))
+	}
+
+	if cfg.Tracing.Enabled() {
+		tracing.Start(ctx, cfg.Tracing, "tcpdog-server", cfg.Logger())
+	}
+
+	var started []config.Status
+
+	defer func() {
+		for _, s := range started {
+			s.State = config.StateStopped
+			o.status(s)
+		}
+	}()
+
+	var hub *admin.Hub
+
+	if cfg.Admin.Enable {
+		hub = admin.NewHub()
+		status := o.status
+		o.status = func(s config.Status) {
+			hub.SetStatus(s)
+			status(s)
+		}
+	}
+
+	report := func(component, name string, err error) error {
+		s := config.Status{Component: component, Name: name, State: config.StateStarted}
+		if err != nil {
+			s.State, s.Err = config.StateFailed, err
+			o.status(s)
+			return err
+		}
+
+		started = append(started, s)
+		o.status(s)
+
+		return nil
+	}
+
+	if hub != nil {
+		err = admin.Start(ctx, hub)
+		if err = report("admin", cfg.Admin.Addr, err); err != nil {
+			return err
+		}
+
+		cfg.Logger().Info("admin", zap.String("msg", cfg.Admin.Addr+" has been started"))
+	}
+
+	if cfg.Metrics.Enable {
+		err = metrics.Start(ctx, cfg.Metrics.Addr, cfg.Logger())
+		if err = report("metrics", cfg.Metrics.Addr, err); err != nil {
+			return err
+		}
+
+		cfg.Logger().Info("metrics", zap.String("msg", cfg.Metrics.Addr+" has been started"))
+	}
+
+	// the server is ready once all the flows have been started
+	var ready *health.State
+
+	if cfg.Health.Enable {
+		registry := health.NewRegistry()
+		ready = health.NewState(health.ErrStarting)
+		registry.Register("server", "flows", ready)
+		ctx = health.WithRegistry(ctx, registry)
+
+		err = health.Start(ctx, cfg.Health.Addr, registry, cfg.Logger())
+		if err = report("health", cfg.Health.Addr, err); err != nil {
+			return err
+		}
+
+		cfg.Logger().Info("health", zap.String("msg", cfg.Health.Addr+" has been started"))
+	}
+
+	var wd *watchdog
+
+	// stalled receives the error of a stage the watchdog has stopped the run for
+	stalled := make(chan error, 1)
+
+	if cfg.Watchdog.Enable {
+		wd = newWatchdog(cfg.Watchdog, cfg.Logger(), func(err error) {
+			select {
+			case stalled <- err:
+			default:
+			}
+		})
+		go wd.run(ctx)
+	}
+
+	// the geo is shared by the ingestions of the server
+	if cfg.Geo.Type != "" {
+		g, err := geo.Start(ctx, cfg.Geo.Type, cfg.Logger(), cfg.Geo.Config)
+		if err = report("geo", cfg.Geo.Type, err); err != nil {
+			return err
+		}
+		ctx = geo.WithContext(ctx, g)
+	}
+
+	var pool *intern.Pool
+
+	if len(cfg.Intern.Fields) > 0 {
+		pool = intern.New(cfg.Intern.MaxEntries)
+		go logIntern(ctx, pool, cfg.Logger())
+	}
+
+	var peer *checkpoint.Peer
+
+	if cfg.Standby.Peer != "" {
+		peer, err = checkpoint.Dial(cfg.Standby)
+		if err = report("standby", cfg.Standby.Peer, err); err != nil {
+			return err
+		}
+		defer peer.Close()
+	}
+
+	lanes := map[string]*priority.Lane{}
+
+	for _, flow := range cfg.Flow {
+		in, ch := flowChannel(ctx, flow)
+
+		if cfg.Metrics.Enable {
+			defer metrics.AddFlow(flow.Ingress+"/"+flow.Ingestion, flow.Ingress, flow.Ingestion)()
+		}
+
+		// the processor records bypass the flow backlog, the lane isn't
+		// shared with the late route and the mirror ingestions.
+		fCtx := ctx
+		if flow.Processor != "" && flow.Columnar == nil {
+			lane := priority.NewLane(priority.Reserved)
+			lanes[flow.Ingress+"/"+flow.Ingestion] = lane
+			fCtx = priority.WithLane(ctx, lane)
+		}
+
+		// the ingress measures the flow channel occupancy
+		err = ingress(grpc.WithBuffer(ctx, ch), flow, in)
+		if err = report("ingress", flow.Ingress, err); err != nil {
+			return err
+		}
+
+		if hub != nil && cfg.Ingress[flow.Ingress].Type == "grpc" {
+			name := flow.Ingress
+			hub.AddQuarantine(name, func() interface{} {
+				return grpc.Quarantined(name)
+			})
+		}
+
+		if flow.Coerce {
+			c, _ := coerce.New(flow.OnCoerceError)
+			cCh := make(chan interface{}, 1000)
+			go coerceFields(ctx, c, flow.Ingress, ch, cCh, cfg.Logger())
+			ch = cCh
+		}
+
+		if pool != nil {
+			iCh := make(chan interface{}, 1000)
+			go internFields(ctx, pool, cfg.Intern.Fields, ch, iCh)
+			ch = iCh
+		}
+
+		if len(flow.Compute) > 0 {
+			c, _ := compute.New(flow.Compute)
+			cCh := make(chan interface{}, 1000)
+			go computeFields(ctx, c, ch, cCh)
+			ch = cCh
+		}
+
+		if gen, _ := recordid.New(flow.RecordID); gen != nil {
+			idCh := make(chan interface{}, 1000)
+			go setID(ctx, gen, ch, idCh)
+			ch = idCh
+		}
+
+		if flow.MaxRecordAge > 0 {
+			l := newLate(flow)
+			if l.action == "route" {
+				lFlow := flow
+				lFlow.Ingestion = flow.LateIngestion
+				lFlow.Columnar = nil
+				l.route = make(chan interface{}, 1000)
+				err = ingestion(ctx, lFlow, l.route)
+				if err = report("ingestion", lFlow.Ingestion, err); err != nil {
+					return err
+				}
+			}
+
+			lCh := make(chan interface{}, 1000)
+			go l.run(ctx, ch, lCh, cfg.Logger())
+			ch = lCh
+		}
+
+		if flow.Processor != "" {
+			if wd != nil {
+				ch = wd.watch(ctx, flow.Ingress+"/"+flow.Processor, ch)
+			}
+
+			// the stateful processors checkpoint their state
+			pCtx := fCtx
+			if cfg.Standby.Enabled() {
+				name := flow.Ingress + "/" + flow.Processor
+				pCtx = checkpoint.WithContext(fCtx, checkpoint.New(ctx, name, cfg.Standby, peer, cfg.Logger()))
+			}
+
+			stage := "processor " + flow.Processor
+			if cfg.Tracing.Enabled() {
+				ch = traceStage(ctx, stage, ch, (*tracing.Trace).Begin)
+			}
+
+			pCh := make(chan interface{}, 1000)
+			err = processor(pCtx, flow, ch, pCh)
+			if err = report("processor", flow.Processor, err); err != nil {
+				return err
+			}
+			ch = pCh
+
+			if cfg.Tracing.Enabled() {
+				ch = traceStage(ctx, stage, ch, (*tracing.Trace).End)
+			}
+		}
+
+		if flow.Mirror != nil {
+			mCh, err := startMirror(ctx, flow, hub, ch, report)
+			if err != nil {
+				return err
+			}
+			ch = mCh
+		}
+
+		if hub != nil {
+			tCh := make(chan interface{}, 1000)
+			go tap(ctx, hub, hub.AddFlow(flow.Ingress+"/"+flow.Ingestion), ch, tCh)
+			ch = tCh
+		}
+
+		if flow.Columnar != nil {
+			bCh, err := startColumnar(ctx, flow, ch)
+			if err = report("ingestion", flow.Ingestion, err); err != nil {
+				return err
+			}
+			ch = bCh
+		}
+
+		if wd != nil {
+			ch = wd.watch(ctx, flow.Ingress+"/"+flow.Ingestion, ch)
+		}
+
+		err = ingestion(fCtx, flow, ch)
+		if err = report("ingestion", flow.Ingestion, err); err != nil {
+			return err
+		}
+	}
+
+	if len(lanes) > 0 {
+		go logPriority(ctx, lanes, cfg.Logger())
+	}
+
+	if ready != nil {
+		ready.Set(nil)
+	}
+
+	var stallErr error
+
+	select {
+	case <-ctx.Done():
+	case stallErr = <-stalled:
+		o.status(config.Status{Component: "watchdog", Name: "flows", State: config.StateFailed, Err: stallErr})
+	}
+
+	drops.Finish(cfg.DropReport, cfg.Logger())
+
+	return stallErr
+}
+
+func ingress(ctx context.Context, flow config.Flow, ch chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+	logger := cfg.Logger()
+
+	ctx = metrics.WithFlow(ctx, flow.Ingress+"/"+flow.Ingestion)
+
+	if flow.Processor != "" && cfg.Processor[flow.Processor].Cluster {
+		ctx = grpc.WithCluster(ctx)
+	}
+
+	switch cfg.Ingress[flow.Ingress].Type {
+	case "grpc":
+		err := grpc.Start(ctx, flow.Ingress, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("grpc", zap.String("msg", flow.Ingress+" has been started"))
+
+	case "kafka":
+		err := kafka.Start(ctx, flow.Ingress, flow.Serialization, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("kafka", zap.String("msg", flow.Ingress+" has been started"))
+
+	case "nats":
+		err := nats.Start(ctx, flow.Ingress, flow.Serialization, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("nats", zap.String("msg", flow.Ingress+" has been started"))
+
+	case "file":
+		err := file.Start(ctx, flow.Ingress, flow.Serialization, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("file", zap.String("msg", flow.Ingress+" has been started"))
+	}
+
+	return nil
+}
+
+func processor(ctx context.Context, flow config.Flow, in, out chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+	logger := cfg.Logger()
+
+	switch cfg.Processor[flow.Processor].Type {
+	case "anomaly":
+		err := anomaly.Start(ctx, flow.Processor, flow.Serialization, in, out)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("anomaly", zap.String("msg", flow.Processor+" has been started"))
+	default:
+		return fmt.Errorf("processor %s type is not supported", flow.Processor)
+	}
+
+	return nil
+}
+
+// startMirror starts the mirror ingestion and the tee stage of the flow,
+// a mirror ingestion failure doesn't stop the flow if onError is ignore.
+func startMirror(ctx context.Context, flow config.Flow, hub *admin.Hub, ch chan interface{}, report func(string, string, error) error) (chan interface{}, error) {
+	logger := config.FromContextServer(ctx).Logger()
+	name := flow.Ingress + "/" + flow.Mirror.Ingestion
+
+	m := newMirror(flow.Mirror)
+
+	mFlow := flow
+	mFlow.Ingestion = flow.Mirror.Ingestion
+	mFlow.Columnar = nil
+
+	// the mirror ingestion failures don't affect the flow readiness
+	remove := metrics.OnIngestionError(name, m.failed)
+	go func() {
+		<-ctx.Done()
+		remove()
+	}()
+
+	err := ingestion(health.WithOptional(ctx), mFlow, m.ch)
+	if err != nil && flow.Mirror.OnError == "ignore" {
+		report("mirror", name, err)
+		logger.Error("mirror", zap.String("msg", name+" has been skipped"), zap.Error(err))
+		return ch, nil
+	}
+
+	if err = report("mirror", name, err); err != nil {
+		return nil, err
+	}
+
+	if hub != nil {
+		hub.AddMirror(name, m)
+	}
+
+	out := make(chan interface{}, 1000)
+	go m.run(ctx, name, ch, out, logger)
+
+	return out, nil
+}
+
+// tap publishes the records to the admin hub on their way to the
+// ingestion and it counts the flow records.
+func tap(ctx context.Context, hub *admin.Hub, records *uint64, in, out chan interface{}) {
+	for {
+		select {
+		case r := <-in:
+			atomic.AddUint64(records, 1)
+			hub.Publish(r)
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// traceStage begins or ends the stage span of the sampled records
+func traceStage(ctx context.Context, stage string, in chan interface{}, fn func(*tracing.Trace, string)) chan interface{} {
+	out := make(chan interface{}, 1000)
+
+	go func() {
+		for {
+			select {
+			case r := <-in:
+				fn(tracing.From(r), stage)
+
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// setID generates the EventID of the records which arrive without one
+func setID(ctx context.Context, gen recordid.Generator, in, out chan interface{}) {
+	for {
+		select {
+		case r := <-in:
+			recordid.Set(r, gen)
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func ingestion(ctx context.Context, flow config.Flow, ch chan interface{}) error {
+	cfg := config.FromContextServer(ctx)
+	logger := cfg.Logger()
+
+	ctx = metrics.WithFlow(ctx, flow.Ingress+"/"+flow.Ingestion)
+
+	switch cfg.Ingestion[flow.Ingestion].Type {
+	case "influxdb":
+		err := influxdb.Start(ctx, flow.Ingestion, flow.Serialization, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("influxdb", zap.String("msg", flow.Ingestion+" has been started"))
+
+	case "elasticsearch":
+		err := elasticsearch.Start(ctx, flow.Ingestion, flow.Serialization, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("elasticsearch", zap.String("msg", flow.Ingestion+" has been started"))
+	case "clickhouse":
+		start := clickhouse.Start
+		if flow.Columnar != nil {
+			start = func(ctx context.Context, name, _ string, ch chan interface{}) error {
+				return clickhouse.StartColumnar(ctx, name, ch)
+			}
+		}
+
+		err := start(ctx, flow.Ingestion, flow.Serialization, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("clickhouse", zap.String("msg", flow.Ingestion+" has been started"))
+	case "kafka":
+		err := ikafka.Start(ctx, flow.Ingestion, flow.Serialization, ch)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("kafka", zap.String("msg", flow.Ingestion+" has been started"))
+	}
+
+	return nil
+}
+
+func validate(cfg *config.ServerConfig) error {
+	if err := provision.Validate(cfg.Provisioning); err != nil {
+		return err
+	}
+
+	if cfg.Tracing.Enabled() {
+		if err := tracing.Validate(cfg.Tracing); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Watchdog.Enable {
+		if err := validateWatchdog(cfg.Watchdog); err != nil {
+			return err
+		}
+	}
+
+	return validateFlow(cfg)
+}
+
+func validateFlow(cfg *config.ServerConfig) error {
+	if len(cfg.Flow) < 1 {
+		return errors.New("there isn't any flow configuration")
+	}
+
+	for _, f := range cfg.Flow {
+		if len(f.Ingestion) < 1 {
+			return errors.New("ingestion hasn't configured")
+		}
+
+		if len(f.Ingress) < 1 {
+			return errors.New("ingress hasn't configured")
+		}
+
+		if len(f.Serialization) < 1 {
+			return errors.New("serialization hasn't configured")
+		}
+
+		if _, ok := cfg.Ingestion[f.Ingestion]; !ok {
+			return fmt.Errorf("ingestion %s is not available", f.Ingestion)
+		}
+
+		if _, ok := cfg.Ingress[f.Ingress]; !ok {
+			return fmt.Errorf("ingress %s is not available", f.Ingress)
+		}
+
+		if _, ok := cfg.Processor[f.Processor]; !ok && f.Processor != "" {
+			return fmt.Errorf("processor %s is not available", f.Processor)
+		}
+
+		if _, err := recordid.New(f.RecordID); err != nil {
+			return err
+		}
+
+		if err := validateLate(cfg, f); err != nil {
+			return err
+		}
+
+		if err := validateMirror(cfg, f); err != nil {
+			return err
+		}
+
+		if err := validateColumnar(cfg, f); err != nil {
+			return err
+		}
+
+		if err := validateCoerce(f); err != nil {
+			return err
+		}
+
+		if err := validateCompute(f); err != nil {
+			return err
+		}
+
+		if err := validateOverflow(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateLate(cfg *config.ServerConfig, f config.Flow) error {
+	if f.MaxRecordAge <= 0 {
+		return nil
+	}
+
+	switch f.LateAction {
+	case "", "drop":
+	case "tag":
+		if f.Serialization == "pb" {
+			return errors.New("late action tag doesn't support pb serialization")
+		}
+	case "route":
+		if _, ok := cfg.Ingestion[f.LateIngestion]; !ok {
+			return fmt.Errorf("late ingestion %s is not available", f.LateIngestion)
+		}
+	default:
+		return fmt.Errorf("late action %s is not supported", f.LateAction)
+	}
+
+	return nil
+}
+
+func validateMirror(cfg *config.ServerConfig, f config.Flow) error {
+	if f.Mirror == nil {
+		return nil
+	}
+
+	if _, ok := cfg.Ingestion[f.Mirror.Ingestion]; !ok {
+		return fmt.Errorf("mirror ingestion %s is not available", f.Mirror.Ingestion)
+	}
+
+	if f.Mirror.Ingestion == f.Ingestion {
+		return errors.New("mirror ingestion should be different from the flow ingestion")
+	}
+
+	if f.Mirror.Percent <= 0 || f.Mirror.Percent > 100 {
+		return fmt.Errorf("mirror percent %v is out of range (0, 100]", f.Mirror.Percent)
+	}
+
+	switch f.Mirror.OnError {
+	case "", "ignore", "fail":
+	default:
+		return fmt.Errorf("mirror onError %s is not supported", f.Mirror.OnError)
+	}
+
+	return nil
+}
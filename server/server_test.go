@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestRun(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"grpc01": {Type: "grpc", Config: map[string]interface{}{"addr": "127.0.0.1:0"}},
+		},
+		Ingestion: map[string]config.Ingestion{
+			"influx01": {Type: "influxdb", Config: map[string]interface{}{"url": "http://127.0.0.1:1"}},
+		},
+		Flow: []config.Flow{{Ingress: "grpc01", Ingestion: "influx01", Serialization: "spb"}},
+		// the influxdb is unreachable
+		Provisioning: "off",
+	}
+	cfg.SetMockLogger("memory")
+
+	var (
+		mu     sync.Mutex
+		events []config.Status
+	)
+
+	status := func(s config.Status) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- Run(ctx, cfg, WithStatus(status))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("run didn't return")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	expected := []config.Status{
+		{Component: "ingress", Name: "grpc01", State: config.StateStarted},
+		{Component: "ingestion", Name: "influx01", State: config.StateStarted},
+		{Component: "ingress", Name: "grpc01", State: config.StateStopped},
+		{Component: "ingestion", Name: "influx01", State: config.StateStopped},
+	}
+	assert.Equal(t, expected, events)
+}
+
+func TestRunMetrics(t *testing.T) {
+	// the metrics endpoint address
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"grpc01": {Type: "grpc", Config: map[string]interface{}{"addr": "127.0.0.1:0"}},
+		},
+		Ingestion: map[string]config.Ingestion{
+			"influx01": {Type: "influxdb", Config: map[string]interface{}{"url": "http://127.0.0.1:1"}},
+			"influx02": {Type: "influxdb", Config: map[string]interface{}{"url": "http://127.0.0.1:1"}},
+		},
+		Flow: []config.Flow{
+			{Ingress: "grpc01", Ingestion: "influx01", Serialization: "spb"},
+			{Ingress: "grpc01", Ingestion: "influx02", Serialization: "spb"},
+		},
+		Metrics:      config.Metrics{Enable: true, Addr: addr},
+		Provisioning: "off",
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go Run(ctx, cfg)
+
+	var body string
+
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		body = string(b)
+
+		return err == nil && resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	// the flows are distinguished by their label
+	for _, flow := range []string{"grpc01/influx01", "grpc01/influx02"} {
+		ingestion := strings.TrimPrefix(flow, "grpc01/")
+
+		assert.Contains(t, body, `tcpdog_ingress_messages_total{flow="`+flow+`",ingress="grpc01"}`)
+		assert.Contains(t, body, `tcpdog_ingestion_documents_total{flow="`+flow+`",ingestion="`+ingestion+`"}`)
+		assert.Contains(t, body, `tcpdog_ingestion_errors_total{flow="`+flow+`",ingestion="`+ingestion+`"}`)
+		assert.Contains(t, body, `tcpdog_ingestion_retries_total{flow="`+flow+`",ingestion="`+ingestion+`"}`)
+		assert.Contains(t, body, `tcpdog_ingestion_batch_seconds_count{flow="`+flow+`",ingestion="`+ingestion+`"}`)
+	}
+}
+
+func TestRunHealth(t *testing.T) {
+	// the health endpoint address
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"grpc01": {Type: "grpc", Config: map[string]interface{}{"addr": "127.0.0.1:0"}},
+		},
+		Ingestion: map[string]config.Ingestion{
+			"influx01": {Type: "influxdb", Config: map[string]interface{}{"url": "http://127.0.0.1:1"}},
+		},
+		Flow:         []config.Flow{{Ingress: "grpc01", Ingestion: "influx01", Serialization: "spb"}},
+		Health:       config.Health{Enable: true, Addr: addr},
+		Provisioning: "off",
+	}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go Run(ctx, cfg)
+
+	var (
+		code int
+		body string
+	)
+
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/readyz")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		b, _ := ioutil.ReadAll(resp.Body)
+		code, body = resp.StatusCode, string(b)
+
+		return strings.Contains(body, `"name":"flows","state":"healthy"`)
+	}, time.Second, 10*time.Millisecond)
+
+	// the influxdb is unreachable
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, `{"component":"ingress","name":"grpc01","state":"healthy"}`)
+	assert.Contains(t, body, `{"component":"ingestion","name":"influx01","state":"unhealthy","error":`)
+}
+
+func TestRunFailed(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Ingress: map[string]config.Ingress{
+			"grpc01": {Type: "grpc", Config: map[string]interface{}{"addr": "127.0.0.1:-1"}},
+		},
+		Ingestion: map[string]config.Ingestion{
+			"influx01": {Type: "influxdb"},
+		},
+		Flow: []config.Flow{{Ingress: "grpc01", Ingestion: "influx01", Serialization: "spb"}},
+	}
+	cfg.SetMockLogger("memory")
+
+	var events []config.Status
+
+	err := Run(context.Background(), cfg, WithStatus(func(s config.Status) {
+		events = append(events, s)
+	}))
+	assert.Error(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, config.StateFailed, events[0].State)
+	assert.Equal(t, err, events[0].Err)
+
+	// validation
+	cfg.Provisioning = "create"
+	err = Run(context.Background(), cfg)
+	assert.EqualError(t, err, "provisioning create is not supported")
+
+	cfg.Provisioning = "verify"
+	cfg.Tracing.Endpoint = "localhost:4318"
+	err = Run(context.Background(), cfg)
+	assert.EqualError(t, err, "invalid tracing endpoint localhost:4318")
+	cfg.Tracing.Endpoint = ""
+
+	cfg.Provisioning = "verify"
+	cfg.Flow[0].Processor = "anomaly01"
+	err = Run(context.Background(), cfg)
+	assert.EqualError(t, err, "processor anomaly01 is not available")
+
+	// the geo error is returned instead of exiting
+	cfg.Flow[0].Processor = ""
+	cfg.Geo = config.Geo{Type: "http", Config: map[string]string{}}
+	events = nil
+	err = Run(context.Background(), cfg, WithStatus(func(s config.Status) {
+		events = append(events, s)
+	}))
+	assert.EqualError(t, err, "the http url has not configured")
+	assert.Equal(t, "geo", events[len(events)-1].Component)
+	cfg.Geo = config.Geo{}
+
+	cfg.Flow = nil
+	err = Run(context.Background(), cfg)
+	assert.Error(t, err)
+}
+
+func TestPresets(t *testing.T) {
+	for _, name := range config.Presets() {
+		file := filepath.Join(t.TempDir(), "server.yml")
+		assert.NoError(t, ioutil.WriteFile(file, []byte("preset: "+name), 0644))
+
+		cfg, err := config.GetServer([]string{"tcpdog", "-config", file}, "0.0.0")
+		assert.NoError(t, err, name)
+		assert.NoError(t, validate(cfg), name)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/ingestion/clickhouse"
+	"github.com/mehrdadrad/tcpdog/ingestion/elasticsearch"
+	"github.com/mehrdadrad/tcpdog/ingestion/influxdb"
+	ikafka "github.com/mehrdadrad/tcpdog/ingestion/kafka"
+)
+
+var verifyTimeout = 30 * time.Second
+
+// VerifyIngestion instantiates just the ingestion, runs its verification
+// checks and writes the pass/fail report, the flows are not started.
+func VerifyIngestion(ctx context.Context, cfg *config.ServerConfig, name string, w io.Writer) error {
+	var verify func(context.Context, string, config.VerifyFunc) error
+
+	cfg.SetDefault()
+
+	ingestion, ok := cfg.Ingestion[name]
+	if !ok {
+		return fmt.Errorf("ingestion %s is not available", name)
+	}
+
+	switch ingestion.Type {
+	case "elasticsearch":
+		verify = elasticsearch.Verify
+	case "influxdb":
+		verify = influxdb.Verify
+	case "clickhouse":
+		verify = clickhouse.Verify
+	case "kafka":
+		verify = ikafka.Verify
+	default:
+		return fmt.Errorf("ingestion %s type is not supported", name)
+	}
+
+	ctx, cancel := context.WithTimeout(cfg.WithContext(ctx), verifyTimeout)
+	defer cancel()
+
+	fmt.Fprintf(w, "verifying ingestion %s (%s)\n", name, ingestion.Type)
+
+	err := verify(ctx, name, func(check, detail string, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "  FAIL  %-16s %v\n", check, err)
+			return
+		}
+		fmt.Fprintf(w, "  PASS  %-16s %s\n", check, detail)
+	})
+
+	if err != nil {
+		fmt.Fprintf(w, "ingestion %s verification has been failed\n", name)
+		return errors.New("verification failed")
+	}
+
+	fmt.Fprintf(w, "ingestion %s verification has been passed\n", name)
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestVerifyIngestion(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Ingestion: map[string]config.Ingestion{
+			"ch":  {Type: "clickhouse", Config: map[string]interface{}{"DSName": "tcp://127.0.0.1:1?read_timeout=1"}},
+			"foo": {Type: "foo"},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+
+	err := VerifyIngestion(context.Background(), cfg, "bar", buf)
+	assert.EqualError(t, err, "ingestion bar is not available")
+
+	err = VerifyIngestion(context.Background(), cfg, "foo", buf)
+	assert.EqualError(t, err, "ingestion foo type is not supported")
+
+	err = VerifyIngestion(context.Background(), cfg, "ch", buf)
+	assert.EqualError(t, err, "verification failed")
+	assert.Contains(t, buf.String(), "verifying ingestion ch (clickhouse)")
+	assert.Contains(t, buf.String(), "PASS  config           table tcpdog")
+	assert.Contains(t, buf.String(), "FAIL  connectivity")
+	assert.Contains(t, buf.String(), "ingestion ch verification has been failed")
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/metrics"
+)
+
+// minWatchdogTimeout is the min timeout of the watchdog, the stages
+// are checked four times per timeout.
+const minWatchdogTimeout = time.Second
+
+// watchdog detects the stalled flow stages, e.g. a deadlock in an
+// ingestion. a stage is stalled if its input queue isn't empty and
+// it doesn't take any record for the timeout, the idle stages never
+// alarm since their queue is empty.
+type watchdog struct {
+	timeout time.Duration
+	restart bool
+	logger  *zap.Logger
+	now     func() time.Time
+	// fail stops the server run with the stall error
+	fail func(error)
+
+	mu     sync.Mutex
+	stages []*stage
+}
+
+// stage represents a watched flow stage
+type stage struct {
+	name string
+	in   chan interface{}
+	// last is the last progress in unix nano
+	last int64
+	// queued is the time which the queue has been found non-empty
+	queued  time.Time
+	stalled bool
+	stalls  uint64
+	// stall counts the stalls of the stage metric
+	stall func()
+}
+
+// validateWatchdog validates the watchdog timeout, a zero
+// timeout is set to the default by the config.
+func validateWatchdog(cfg config.Watchdog) error {
+	if cfg.Timeout < minWatchdogTimeout {
+		return fmt.Errorf("wrong watchdog timeout:%s, the min is %s", cfg.Timeout, minWatchdogTimeout)
+	}
+
+	return nil
+}
+
+func newWatchdog(cfg config.Watchdog, logger *zap.Logger, fail func(error)) *watchdog {
+	return &watchdog{
+		timeout: cfg.Timeout,
+		restart: cfg.Restart,
+		logger:  logger,
+		now:     time.Now,
+		fail:    fail,
+	}
+}
+
+// watch relays the records from in to the stage, the returned channel
+// is unbuffered so each hand-off is a progress of the stage itself.
+func (w *watchdog) watch(ctx context.Context, name string, in chan interface{}) chan interface{} {
+	s := &stage{name: name, in: in, last: w.now().UnixNano(), stall: metrics.WatchdogStall(name)}
+	out := make(chan interface{})
+
+	w.mu.Lock()
+	w.stages = append(w.stages, s)
+	w.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case r := <-in:
+				select {
+				case out <- r:
+					atomic.StoreInt64(&s.last, w.now().UnixNano())
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (w *watchdog) run(ctx context.Context) {
+	ticker := time.NewTicker(w.timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check alarms the stages which have queued records without any
+// progress for the timeout, once per stall.
+func (w *watchdog) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+
+	for _, s := range w.stages {
+		if len(s.in) < 1 {
+			s.queued, s.stalled = time.Time{}, false
+			continue
+		}
+
+		if s.queued.IsZero() {
+			s.queued = now
+		}
+
+		last := time.Unix(0, atomic.LoadInt64(&s.last))
+		if last.After(s.queued) {
+			s.queued, s.stalled = last, false
+		}
+
+		if now.Sub(s.queued) < w.timeout || s.stalled {
+			continue
+		}
+
+		s.stalled = true
+		stalls := atomic.AddUint64(&s.stalls, 1)
+		s.stall()
+
+		w.logger.Error("watchdog", zap.String("msg", s.name+" has been stalled"),
+			zap.Int("queue", len(s.in)), zap.Duration("since", now.Sub(last)),
+			zap.Uint64("stalls", stalls), zap.String("goroutines", goroutines()))
+
+		if w.restart {
+			w.logger.Error("watchdog", zap.String("msg", "stopping the server to be restarted"))
+			w.fail(fmt.Errorf("%s has been stalled for %s", s.name, now.Sub(last).Round(time.Second)))
+		}
+	}
+}
+
+// goroutines returns the stack traces of all the goroutines
+func goroutines() string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+func TestWatchdog(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	ms := cfg.SetMockLogger("watchdog")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	var failed []error
+	wd := newWatchdog(config.Watchdog{Timeout: time.Minute, Restart: true}, cfg.Logger(), func(err error) {
+		failed = append(failed, err)
+	})
+	wd.now = func() time.Time { return now }
+
+	in := make(chan interface{}, 10)
+	out := wd.watch(ctx, "grpc/elastic", in)
+
+	// idle for a long time
+	now = now.Add(time.Hour)
+	wd.check()
+	assert.Len(t, failed, 0)
+
+	// the records are taken
+	in <- 1
+	wd.check()
+	assert.Equal(t, 1, <-out)
+	assert.Eventually(t, func() bool { return len(in) == 0 }, time.Second, time.Millisecond)
+
+	// the stage is stuck, the first record is held by the relay
+	in <- 2
+	assert.Eventually(t, func() bool { return len(in) == 0 }, time.Second, time.Millisecond)
+	in <- 3
+	wd.check()
+
+	now = now.Add(30 * time.Second)
+	wd.check()
+	assert.Len(t, failed, 0)
+
+	// the run is stopped with the stall error instead of exiting
+	now = now.Add(31 * time.Second)
+	ms.Reset()
+	wd.check()
+	assert.Len(t, failed, 1)
+	assert.EqualError(t, failed[0], "grpc/elastic has been stalled for 1m1s")
+	assert.Contains(t, ms.String(), "grpc/elastic has been stalled")
+	assert.Contains(t, ms.String(), "goroutine")
+	assert.Contains(t, ms.String(), "stopping the server to be restarted")
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&wd.stages[0].stalls))
+
+	// alarms once per stall
+	now = now.Add(time.Minute)
+	wd.check()
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&wd.stages[0].stalls))
+	assert.Len(t, failed, 1)
+
+	// progress
+	assert.Equal(t, 2, <-out)
+	assert.Equal(t, 3, <-out)
+	wd.check()
+	assert.False(t, wd.stages[0].stalled)
+}
+
+func TestValidateWatchdog(t *testing.T) {
+	err := validateWatchdog(config.Watchdog{Enable: true, Timeout: 3})
+	assert.EqualError(t, err, "wrong watchdog timeout:3ns, the min is 1s")
+
+	err = validateWatchdog(config.Watchdog{Enable: true, Timeout: time.Second})
+	assert.NoError(t, err)
+}
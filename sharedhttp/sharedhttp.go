@@ -0,0 +1,56 @@
+// Package sharedhttp keeps the http handlers of the shared listeners,
+// a gRPC ingress with sharedListener serves the gRPC and the http
+// requests on the same port and the http components, e.g. the admin
+// http, register their handlers here instead of listening.
+package sharedhttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// entry is a registered handler, the pointer identifies the
+// registration thus a newer one isn't removed by an older one.
+type entry struct {
+	h http.Handler
+}
+
+var handlers = struct {
+	sync.RWMutex
+	m map[string]*entry
+}{m: map[string]*entry{}}
+
+// Handle registers the http handler of the shared listener address
+// until the context is done, e.g. the server run which owns it stops.
+func Handle(ctx context.Context, addr string, h http.Handler) {
+	e := &entry{h: h}
+
+	handlers.Lock()
+	handlers.m[addr] = e
+	handlers.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		handlers.Lock()
+		defer handlers.Unlock()
+
+		if handlers.m[addr] == e {
+			delete(handlers.m, addr)
+		}
+	}()
+}
+
+// Handler returns the http handler of the shared listener address,
+// it returns the not found handler if nothing has been registered.
+func Handler(addr string) http.Handler {
+	handlers.RLock()
+	defer handlers.RUnlock()
+
+	if e, ok := handlers.m[addr]; ok {
+		return e.h
+	}
+
+	return http.NotFoundHandler()
+}
@@ -0,0 +1,40 @@
+package sharedhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandle(t *testing.T) {
+	status := func(addr string) int {
+		w := httptest.NewRecorder()
+		Handler(addr).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	Handle(ctx1, ":8085", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	assert.Equal(t, http.StatusAccepted, status(":8085"))
+
+	// the next run registers its handler before the old one is done
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	Handle(ctx2, ":8085", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cancel1()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, status(":8085"))
+
+	cancel2()
+	assert.Eventually(t, func() bool {
+		return status(":8085") == http.StatusNotFound
+	}, time.Second, 10*time.Millisecond)
+}
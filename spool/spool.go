@@ -0,0 +1,652 @@
+// Package spool persists the events of an egress on the disk once the
+// egress can't keep up e.g. its collector is unreachable, and it replays
+// them in order once the egress drains again. The events are the encoded
+// buffers of the tracepoints thus the spool works for all of the egress
+// serializations. The spool is a directory of length prefixed segment
+// files which survives a restart, the segments are replayed at the next
+// start from the offset which has been replayed before the shutdown, the
+// replay is at-least-once after a crash as the offset isn't kept then.
+package spool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+	"github.com/mehrdadrad/tcpdog/egress/helper"
+	"github.com/mehrdadrad/tcpdog/safewriter"
+)
+
+const (
+	segmentExt     = ".seg"
+	offsetFile     = "offset"
+	defaultMaxSize = 1 << 30
+
+	// the segment size is a fraction of the max size thus the
+	// size limit evicts a small part of the spool at a time.
+	segmentRatio   = 16
+	minSegmentSize = 1 << 20
+	maxSegmentSize = 64 << 20
+)
+
+// checkInterval is the interval of the flush and the age limit
+var checkInterval = time.Second
+
+// the spools are shared by their path, e.g. the old and the new
+// instance of an egress which is being swapped.
+var (
+	mu     sync.Mutex
+	spools = map[string]*Spool{}
+)
+
+type spoolKey struct{}
+
+// Spool represents the segments of an egress spool, the last
+// segment is written and the first one is replayed.
+type Spool struct {
+	sync.Mutex
+
+	name        string
+	dir         string
+	maxSize     int64
+	maxAge      time.Duration
+	segmentSize int64
+
+	segments []*segment
+	writer   *safewriter.Writer
+	reader   *reader
+	size     int64
+	records  int
+	refs     int
+}
+
+type segment struct {
+	seq      uint64
+	path     string
+	size     int64
+	records  int
+	modified time.Time
+	// skip is the replayed offset of the previous run
+	skip int64
+}
+
+// reader reads the first segment, the pos is the offset of the
+// replayed events and the prev is the offset before the last one.
+type reader struct {
+	file *os.File
+	r    *safewriter.Reader
+	base int64
+	pos  int64
+	prev int64
+}
+
+// Open opens the spool of the egress, the segments of the previous
+// run are recovered and they're replayed first.
+func Open(name string, cfg *config.Spool) (*Spool, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("spool path of %s has not been configured", name)
+	}
+
+	maxSize, err := parseSize(cfg.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Clean(cfg.Path)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s, ok := spools[dir]; ok {
+		s.Lock()
+		s.refs++
+		s.limits(maxSize, cfg.MaxAge)
+		s.Unlock()
+
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{name: name, dir: dir, refs: 1}
+	s.limits(maxSize, cfg.MaxAge)
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	spools[dir] = s
+
+	return s, nil
+}
+
+// WithContext returns a copy of the context with the spool
+func WithContext(ctx context.Context, s *Spool) context.Context {
+	return context.WithValue(ctx, spoolKey{}, s)
+}
+
+// FromContext returns the spool of the egress, it's nil if
+// the egress hasn't been configured with a spool.
+func FromContext(ctx context.Context) *Spool {
+	s, _ := ctx.Value(spoolKey{}).(*Spool)
+	return s
+}
+
+func (s *Spool) limits(maxSize int64, maxAge time.Duration) {
+	s.maxSize, s.maxAge = maxSize, maxAge
+
+	s.segmentSize = maxSize / segmentRatio
+	if s.segmentSize < minSegmentSize {
+		s.segmentSize = minSegmentSize
+	}
+	if s.segmentSize > maxSegmentSize {
+		s.segmentSize = maxSegmentSize
+	}
+	if s.segmentSize > maxSize {
+		s.segmentSize = maxSize
+	}
+}
+
+// load recovers the segments of the directory, the torn record
+// of a crash is truncated and the empty segments are deleted.
+func (s *Spool) load() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(s.dir, f.Name())
+
+		n, err := safewriter.Recover(path, safewriter.LengthPrefixed)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			os.Remove(path)
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		s.segments = append(s.segments, &segment{
+			seq:      seq,
+			path:     path,
+			size:     info.Size(),
+			records:  n,
+			modified: info.ModTime(),
+		})
+		s.size += info.Size()
+		s.records += n
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+
+	return s.loadOffset()
+}
+
+// loadOffset skips the events of the first segment which have been
+// replayed before the shutdown, the offset file is removed then.
+func (s *Spool) loadOffset() error {
+	path := filepath.Join(s.dir, offsetFile)
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(path)
+
+	var (
+		seq    uint64
+		offset int64
+	)
+
+	if _, err := fmt.Sscanf(string(b), "%d %d", &seq, &offset); err != nil {
+		return nil
+	}
+
+	if len(s.segments) == 0 || s.segments[0].seq != seq {
+		return nil
+	}
+
+	f, err := os.Open(s.segments[0].path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := safewriter.NewReader(f, safewriter.LengthPrefixed)
+	for r.Offset() < offset {
+		if _, err := r.Next(); err != nil {
+			break
+		}
+
+		s.segments[0].records--
+		s.records--
+	}
+
+	s.segments[0].skip = r.Offset()
+
+	return nil
+}
+
+// Run forwards the events from the in to the out channel, an event is
+// spooled once the out channel is full and the spooled events are sent
+// before the new events thus the order is kept. the spool is closed once
+// the context is done and the pending events are spooled then.
+func (s *Spool) Run(ctx context.Context, bufpool *sync.Pool, in, out chan *bytes.Buffer) {
+	var (
+		logger   = config.FromContext(ctx).Logger()
+		inflight = helper.InflightFrom(ctx)
+		ticker   = time.NewTicker(checkInterval)
+		next     *bytes.Buffer
+	)
+
+	defer ticker.Stop()
+
+	for {
+		if next == nil && s.Len() > 0 {
+			next = bufpool.Get().(*bytes.Buffer)
+			next.Reset()
+
+			ok, err := s.next(next)
+			if err != nil {
+				logger.Error("spool", zap.String("egress", s.name), zap.Error(err))
+			}
+
+			// the replayed event is in-flight until the egress has sent it
+			if ok {
+				inflight.Add(1)
+			} else {
+				bufpool.Put(next)
+				next = nil
+			}
+		}
+
+		// the out channel is enabled only for a spooled event
+		var outC chan *bytes.Buffer
+		if next != nil {
+			outC = out
+		}
+
+		select {
+		case buf := <-in:
+			if next == nil {
+				select {
+				case out <- buf:
+					continue
+				default:
+				}
+			}
+
+			s.spool(buf, bufpool, logger)
+			inflight.Add(-1)
+		case outC <- next:
+			next = nil
+		case <-ticker.C:
+			s.check(logger)
+		case <-ctx.Done():
+			if next != nil {
+				s.unread(next, bufpool, logger)
+			}
+
+			s.drain(bufpool, logger, in, out)
+			s.Close()
+
+			return
+		}
+	}
+}
+
+// drain spools the events which haven't been sent yet
+func (s *Spool) drain(bufpool *sync.Pool, logger *zap.Logger, chs ...chan *bytes.Buffer) {
+	for _, ch := range chs {
+		for done := false; !done; {
+			select {
+			case buf := <-ch:
+				s.spool(buf, bufpool, logger)
+			default:
+				done = true
+			}
+		}
+	}
+}
+
+func (s *Spool) spool(buf *bytes.Buffer, bufpool *sync.Pool, logger *zap.Logger) {
+	if err := s.write(buf.Bytes()); err != nil {
+		drops.Add(drops.EgressSpool, s.name, 1)
+		logger.Error("spool", zap.String("egress", s.name), zap.Error(err))
+	}
+
+	bufpool.Put(buf)
+}
+
+// Spill spools an event which the egress has failed to send, it
+// returns false if the egress hasn't a spool or it can't be written.
+func (s *Spool) Spill(buf *bytes.Buffer) bool {
+	if s == nil {
+		return false
+	}
+
+	return s.write(buf.Bytes()) == nil
+}
+
+// Len returns the number of the spooled events
+func (s *Spool) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.records
+}
+
+// Size returns the bytes of the segments
+func (s *Spool) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.size
+}
+
+func (s *Spool) write(b []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// the empty record is the torn record of the segment
+	if len(b) == 0 {
+		return nil
+	}
+
+	if s.writer == nil || s.writer.Size() >= s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	size := s.writer.Size()
+	if err := s.writer.Write(b); err != nil {
+		return err
+	}
+
+	seg := s.segments[len(s.segments)-1]
+	seg.size += s.writer.Size() - size
+	seg.records++
+	seg.modified = time.Now()
+
+	s.size += s.writer.Size() - size
+	s.records++
+
+	// the oldest segments are evicted, the current one is kept
+	for len(s.segments) > 1 && s.size > s.maxSize {
+		s.evict()
+	}
+
+	return nil
+}
+
+// rotate closes the current segment and creates the next one
+func (s *Spool) rotate() error {
+	if err := s.closeWriter(); err != nil {
+		return err
+	}
+
+	var seq uint64 = 1
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+
+	w, err := safewriter.Create(path, safewriter.Options{Mode: safewriter.LengthPrefixed})
+	if err != nil {
+		return err
+	}
+
+	s.writer = w
+	s.segments = append(s.segments, &segment{seq: seq, path: path, modified: time.Now()})
+
+	return nil
+}
+
+func (s *Spool) closeWriter() error {
+	if s.writer == nil {
+		return nil
+	}
+
+	err := s.writer.Close()
+	s.writer = nil
+
+	return err
+}
+
+// next reads the oldest event to the buffer, the segment is deleted
+// once it has been read completely. a segment which can't be read is
+// evicted and the error is returned.
+func (s *Spool) next(buf *bytes.Buffer) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for len(s.segments) > 0 {
+		if s.reader == nil {
+			// the current segment is read once it's closed
+			if len(s.segments) == 1 {
+				if err := s.closeWriter(); err != nil {
+					s.evict()
+					return false, err
+				}
+			}
+
+			f, err := os.Open(s.segments[0].path)
+			if err == nil {
+				_, err = f.Seek(s.segments[0].skip, io.SeekStart)
+			}
+			if err != nil {
+				if f != nil {
+					f.Close()
+				}
+				s.evict()
+				return false, err
+			}
+
+			s.reader = &reader{
+				file: f,
+				r:    safewriter.NewReader(f, safewriter.LengthPrefixed),
+				base: s.segments[0].skip,
+				pos:  s.segments[0].skip,
+			}
+		}
+
+		s.reader.prev = s.reader.pos
+
+		rec, err := s.reader.r.Next()
+		if err == io.EOF {
+			s.remove()
+			continue
+		}
+		if err != nil {
+			s.evict()
+			return false, err
+		}
+
+		buf.Write(rec)
+
+		s.reader.pos = s.reader.base + s.reader.r.Offset()
+		s.segments[0].records--
+		s.records--
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// unread puts back the last read event which hasn't been sent, its
+// offset is kept thus it's replayed first at the next start. it's
+// spooled again if the spool is still used by another egress.
+func (s *Spool) unread(buf *bytes.Buffer, bufpool *sync.Pool, logger *zap.Logger) {
+	s.Lock()
+
+	if s.refs == 1 && s.reader != nil {
+		s.reader.pos = s.reader.prev
+		s.segments[0].records++
+		s.records++
+		s.Unlock()
+
+		bufpool.Put(buf)
+		return
+	}
+
+	s.Unlock()
+
+	s.spool(buf, bufpool, logger)
+}
+
+// check flushes the current segment and evicts the segments
+// which are older than the max age.
+func (s *Spool) check(logger *zap.Logger) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.writer != nil {
+		if err := s.writer.Flush(); err != nil {
+			logger.Error("spool", zap.String("egress", s.name), zap.Error(err))
+		}
+	}
+
+	if s.maxAge <= 0 {
+		return
+	}
+
+	for len(s.segments) > 0 && time.Since(s.segments[0].modified) > s.maxAge {
+		if len(s.segments) == 1 {
+			s.closeWriter()
+		}
+
+		s.evict()
+	}
+}
+
+// evict deletes the oldest segment and counts its events as drops
+func (s *Spool) evict() {
+	if n := s.segments[0].records; n > 0 {
+		drops.Add(drops.EgressSpool, s.name, uint64(n))
+	}
+
+	s.remove()
+}
+
+// remove deletes the oldest segment
+func (s *Spool) remove() {
+	seg := s.segments[0]
+
+	if s.reader != nil {
+		s.reader.file.Close()
+		s.reader = nil
+	}
+
+	if len(s.segments) == 1 {
+		s.closeWriter()
+	}
+
+	os.Remove(seg.path)
+
+	s.size -= seg.size
+	s.records -= seg.records
+	s.segments = s.segments[1:]
+}
+
+// Close closes the spool once all of its users have closed it,
+// the spooled events are kept on the disk for the next run.
+func (s *Spool) Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.refs--; s.refs > 0 {
+		return nil
+	}
+
+	delete(spools, s.dir)
+
+	var err error
+
+	if s.reader != nil {
+		offset := fmt.Sprintf("%d %d", s.segments[0].seq, s.reader.pos)
+		err = ioutil.WriteFile(filepath.Join(s.dir, offsetFile), []byte(offset), 0644)
+
+		s.reader.file.Close()
+		s.reader = nil
+	}
+
+	if e := s.closeWriter(); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+// parseSize parses the size with an optional unit e.g. 512MB
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return defaultMaxSize, nil
+	}
+
+	units := []struct {
+		suffix string
+		n      int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	v, unit := strings.ToUpper(strings.TrimSpace(size)), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v, unit = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("wrong spool max-size: %s", size)
+	}
+
+	return n * unit, nil
+}
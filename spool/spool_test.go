@@ -0,0 +1,226 @@
+package spool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	"github.com/mehrdadrad/tcpdog/drops"
+)
+
+var bufpool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func event(i int) *bytes.Buffer {
+	return bytes.NewBufferString(fmt.Sprintf(`{"F1":%d}`, i))
+}
+
+func readAll(t *testing.T, s *Spool) []string {
+	var events []string
+
+	for {
+		buf := &bytes.Buffer{}
+		ok, err := s.next(buf)
+		assert.NoError(t, err)
+		if !ok {
+			return events
+		}
+		events = append(events, buf.String())
+	}
+}
+
+func TestSpoolRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open("grpc01", &config.Spool{Path: dir})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, s.Spill(event(i)))
+	}
+	assert.Equal(t, 3, s.Len())
+	assert.NoError(t, s.Close())
+
+	// a crash tears the last record
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, files, 1)
+	f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	f.Write([]byte{0, 0, 0, 9, 1})
+	f.Close()
+
+	s, err = Open("grpc01", &config.Spool{Path: dir})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, 3, s.Len())
+	assert.True(t, s.Spill(event(3)))
+
+	expected := []string{`{"F1":0}`, `{"F1":1}`, `{"F1":2}`, `{"F1":3}`}
+	assert.Equal(t, expected, readAll(t, s))
+	assert.Equal(t, 0, s.Len())
+
+	// the replayed segments are deleted
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, files, 0)
+}
+
+func TestSpoolMaxSize(t *testing.T) {
+	s, err := Open("kafka-size", &config.Spool{Path: t.TempDir(), MaxSize: "1KB"})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// the small segments are evicted one at a time
+	s.segmentSize = 256
+	dropped := drops.Count(drops.EgressSpool, "kafka-size")
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, s.Spill(event(i)))
+	}
+
+	assert.LessOrEqual(t, s.Size(), int64(1024))
+	assert.Less(t, s.Len(), 100)
+	assert.Equal(t, uint64(100-s.Len()), drops.Count(drops.EgressSpool, "kafka-size")-dropped)
+
+	// the newest events are kept in order
+	events := readAll(t, s)
+	assert.Equal(t, `{"F1":99}`, events[len(events)-1])
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	s, err := Open("kafka-age", &config.Spool{Path: t.TempDir(), MaxAge: time.Minute})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	dropped := drops.Count(drops.EgressSpool, "kafka-age")
+
+	assert.True(t, s.Spill(event(1)))
+	s.segments[0].modified = time.Now().Add(-2 * time.Minute)
+
+	s.check(zap.NewNop())
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, uint64(1), drops.Count(drops.EgressSpool, "kafka-age")-dropped)
+}
+
+func TestSpoolRun(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := &config.Config{}
+	cfg.SetMockLogger("memory")
+
+	ctx, cancel := context.WithCancel(cfg.WithContext(context.Background()))
+
+	s, err := Open("grpc02", &config.Spool{Path: dir})
+	assert.NoError(t, err)
+
+	in := make(chan *bytes.Buffer, 10)
+	out := make(chan *bytes.Buffer, 2)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, &bufpool, in, out)
+		close(done)
+	}()
+
+	// the egress is stuck thus the events are spooled
+	for i := 0; i < 10; i++ {
+		in <- event(i)
+	}
+
+	assert.Eventually(t, func() bool { return len(in) == 0 && len(out) == 2 }, time.Second, time.Millisecond)
+	assert.Greater(t, s.Len(), 6)
+
+	// the egress has been recovered, the events are in order
+	for i := 0; i < 10; i++ {
+		select {
+		case buf := <-out:
+			assert.Equal(t, event(i).String(), buf.String())
+		case <-time.After(time.Second):
+			t.Fatal("spool didn't replay")
+		}
+	}
+
+	// the pending events are spooled at the shutdown
+	in <- event(10)
+	in <- event(11)
+	in <- event(12)
+	assert.Eventually(t, func() bool { return len(in) == 0 && len(out) == 2 }, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	s, err = Open("grpc02", &config.Spool{Path: dir})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// the spooled event is replayed from its offset and the
+	// events of the egress channel are appended at the shutdown.
+	events := readAll(t, s)
+	assert.Equal(t, []string{`{"F1":12}`, `{"F1":10}`, `{"F1":11}`}, events)
+}
+
+func TestSpoolShared(t *testing.T) {
+	dir := t.TempDir()
+
+	s1, err := Open("grpc03", &config.Spool{Path: dir})
+	assert.NoError(t, err)
+
+	s2, err := Open("grpc03", &config.Spool{Path: dir + "/"})
+	assert.NoError(t, err)
+	assert.True(t, s1 == s2)
+
+	s1.Spill(event(1))
+	assert.NoError(t, s1.Close())
+
+	// the spool is still open by the other user
+	assert.True(t, s2.Spill(event(2)))
+	assert.Equal(t, []string{`{"F1":1}`, `{"F1":2}`}, readAll(t, s2))
+	assert.NoError(t, s2.Close())
+
+	_, err = Open("grpc03", &config.Spool{})
+	assert.Error(t, err)
+}
+
+func TestParseSize(t *testing.T) {
+	for size, expected := range map[string]int64{
+		"":       defaultMaxSize,
+		"512":    512,
+		"64KB":   64 << 10,
+		"512 MB": 512 << 20,
+		"2gb":    2 << 30,
+	} {
+		n, err := parseSize(size)
+		assert.NoError(t, err, size)
+		assert.Equal(t, expected, n, size)
+	}
+
+	for _, size := range []string{"MB", "-1GB", "1TB"} {
+		_, err := parseSize(size)
+		assert.Error(t, err, size)
+	}
+}
+
+func TestSpoolSkipFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "foo.seg"), []byte("bar"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "00000000000000000001.seg"), nil, 0644))
+
+	s, err := Open("grpc04", &config.Spool{Path: dir})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, 0, s.Len())
+
+	// the empty segment has been deleted
+	_, err = os.Stat(filepath.Join(dir, "00000000000000000001.seg"))
+	assert.True(t, os.IsNotExist(err))
+}
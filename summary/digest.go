@@ -0,0 +1,115 @@
+package summary
+
+import (
+	"math"
+	"sort"
+)
+
+// compression bounds the centroids of a digest, the
+// accuracy is higher at the tails e.g. p99.
+const compression = 100
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// digest is a merging t-digest, the values are buffered and merged
+// into the centroids whose weights are bounded by their quantile.
+type digest struct {
+	centroids []centroid
+	buf       []centroid
+	count     float64
+	min, max  float64
+}
+
+func newDigest() *digest {
+	return &digest{min: math.Inf(1), max: math.Inf(-1)}
+}
+
+func (d *digest) add(v float64) {
+	d.buf = append(d.buf, centroid{v, 1})
+	d.count++
+
+	if v < d.min {
+		d.min = v
+	}
+	if v > d.max {
+		d.max = v
+	}
+
+	if len(d.buf) >= 5*compression {
+		d.compress()
+	}
+}
+
+func (d *digest) compress() {
+	if len(d.buf) == 0 {
+		return
+	}
+
+	all := append(d.centroids, d.buf...)
+	d.buf = d.buf[:0]
+
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	var (
+		out   = []centroid{all[0]}
+		sofar float64
+	)
+
+	for _, c := range all[1:] {
+		cur := &out[len(out)-1]
+
+		q := (sofar + (cur.weight+c.weight)/2) / d.count
+		if cur.weight+c.weight <= 4*d.count*q*(1-q)/compression {
+			cur.mean += (c.mean - cur.mean) * c.weight / (cur.weight + c.weight)
+			cur.weight += c.weight
+			continue
+		}
+
+		sofar += cur.weight
+		out = append(out, c)
+	}
+
+	d.centroids = out
+}
+
+// quantile returns the estimated value of the quantile, it's
+// interpolated between the centroids and the min and the max.
+func (d *digest) quantile(q float64) float64 {
+	d.compress()
+
+	if d.count == 0 {
+		return math.NaN()
+	}
+
+	var (
+		target = q * d.count
+		cum    float64
+	)
+
+	for i, c := range d.centroids {
+		center := cum + c.weight/2
+		if target < center {
+			if i == 0 {
+				return d.min + (c.mean-d.min)*target/center
+			}
+
+			prev := d.centroids[i-1]
+			prevCenter := cum - prev.weight/2
+
+			return prev.mean + (c.mean-prev.mean)*(target-prevCenter)/(center-prevCenter)
+		}
+
+		cum += c.weight
+	}
+
+	last := d.centroids[len(d.centroids)-1]
+	lastCenter := d.count - last.weight/2
+	if target >= d.count || d.count == lastCenter {
+		return d.max
+	}
+
+	return last.mean + (d.max-last.mean)*(target-lastCenter)/(d.count-lastCenter)
+}
@@ -0,0 +1,168 @@
+// Package summary keeps the streaming percentiles of the numeric fields
+// of the events, optionally per group e.g. DAddr, and prints them as a
+// table at the agent exit for the quick investigations.
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// Other is the group of the events beyond the max groups
+const Other = "other"
+
+// names maps the lower case field names to the registry fields
+var names = func() map[string]protoreflect.FieldDescriptor {
+	m := map[string]protoreflect.FieldDescriptor{}
+	fields := (&pb.Fields{}).ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		m[strings.ToLower(string(fields.Get(i).Name()))] = fields.Get(i)
+	}
+
+	return m
+}()
+
+// Summary represents the digests of the fields per group
+type Summary struct {
+	sync.Mutex
+
+	fields    []string
+	groupBy   string
+	maxGroups int
+	groups    map[string][]*digest
+}
+
+// New constructs a new summary of the numeric fields, the field
+// names are case insensitive and the groupBy is optional.
+func New(fields []string, groupBy string, maxGroups int) (*Summary, error) {
+	s := &Summary{maxGroups: maxGroups, groups: map[string][]*digest{}}
+
+	for _, name := range fields {
+		fd, ok := names[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("summary field %s is not available", name)
+		}
+
+		switch fd.Kind() {
+		case protoreflect.Uint32Kind, protoreflect.Uint64Kind:
+		default:
+			return nil, fmt.Errorf("summary field %s is not numeric", name)
+		}
+
+		s.fields = append(s.fields, string(fd.Name()))
+	}
+
+	if len(s.fields) < 1 {
+		return nil, fmt.Errorf("summary fields have not been configured")
+	}
+
+	if groupBy != "" {
+		fd, ok := names[strings.ToLower(groupBy)]
+		if !ok {
+			return nil, fmt.Errorf("summary group %s is not available", groupBy)
+		}
+		s.groupBy = string(fd.Name())
+	}
+
+	return s, nil
+}
+
+// Add adds the json event to the digests of its group
+func (s *Summary) Add(b []byte) {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return
+	}
+
+	group := ""
+	if s.groupBy != "" {
+		group = fmt.Sprint(m[s.groupBy])
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	digests, ok := s.groups[group]
+	if !ok {
+		if len(s.groups) >= s.maxGroups {
+			group = Other
+			digests, ok = s.groups[group]
+		}
+
+		if !ok {
+			digests = make([]*digest, len(s.fields))
+			for i := range digests {
+				digests[i] = newDigest()
+			}
+			s.groups[group] = digests
+		}
+	}
+
+	for i, name := range s.fields {
+		if v, ok := m[name].(float64); ok {
+			digests[i].add(v)
+		}
+	}
+}
+
+// Print writes the count, the percentiles and the max of the
+// fields per group, the groups are sorted by their counts.
+func (s *Summary) Print(w io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+
+	type row struct {
+		group   string
+		digests []*digest
+	}
+
+	var rows []row
+	for g, d := range s.groups {
+		rows = append(rows, row{g, d})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if (rows[i].group == Other) != (rows[j].group == Other) {
+			return rows[j].group == Other
+		}
+		if rows[i].digests[0].count != rows[j].digests[0].count {
+			return rows[i].digests[0].count > rows[j].digests[0].count
+		}
+		return rows[i].group < rows[j].group
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	header := "FIELD\tCOUNT\tP50\tP90\tP99\tMAX\t"
+	if s.groupBy != "" {
+		header = strings.ToUpper(s.groupBy) + "\t" + header
+	}
+	fmt.Fprintln(tw, header)
+
+	for _, r := range rows {
+		for i, name := range s.fields {
+			d := r.digests[i]
+			if d.count == 0 {
+				continue
+			}
+
+			if s.groupBy != "" {
+				fmt.Fprintf(tw, "%s\t", r.group)
+			}
+
+			fmt.Fprintf(tw, "%s\t%.0f\t%.1f\t%.1f\t%.1f\t%g\t\n", name, d.count,
+				d.quantile(0.5), d.quantile(0.9), d.quantile(0.99), d.max)
+		}
+	}
+
+	tw.Flush()
+}
@@ -0,0 +1,72 @@
+package summary
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigest(t *testing.T) {
+	d := newDigest()
+	assert.True(t, math.IsNaN(d.quantile(0.5)))
+
+	for _, i := range rand.Perm(100000) {
+		d.add(float64(i + 1))
+	}
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		assert.InDelta(t, q*100000, d.quantile(q), 100000*0.005, q)
+	}
+
+	assert.Equal(t, 100000.0, d.quantile(1))
+	assert.Equal(t, 100000.0, d.max)
+	assert.Less(t, len(d.centroids), 10*compression)
+
+	d = newDigest()
+	d.add(7)
+	assert.Equal(t, 7.0, d.quantile(0.5))
+	assert.Equal(t, 7.0, d.quantile(0.99))
+}
+
+func TestSummary(t *testing.T) {
+	s, err := New([]string{"rtt", "TotalRetrans"}, "DADDR", 2)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 100; i++ {
+		s.Add([]byte(fmt.Sprintf(`{"RTT":%d,"TotalRetrans":1,"DAddr":"10.0.0.1"}`, i)))
+	}
+	s.Add([]byte(`{"RTT":10,"DAddr":"10.0.0.2"}`))
+	s.Add([]byte(`{"RTT":20,"DAddr":"10.0.0.3"}`))
+	s.Add([]byte(`{"RTT":30,"DAddr":"10.0.0.4"}`))
+	s.Add([]byte(`invalid`))
+
+	out := new(bytes.Buffer)
+	s.Print(out)
+
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		rows = append(rows, strings.Fields(line))
+	}
+
+	assert.Equal(t, [][]string{
+		{"DADDR", "FIELD", "COUNT", "P50", "P90", "P99", "MAX"},
+		{"10.0.0.1", "RTT", "100", "50.5", "90.5", "99.5", "100"},
+		{"10.0.0.1", "TotalRetrans", "100", "1.0", "1.0", "1.0", "1"},
+		{"10.0.0.2", "RTT", "1", "10.0", "10.0", "10.0", "10"},
+		{"other", "RTT", "2", "25.0", "30.0", "30.0", "30"},
+	}, rows)
+
+	_, err = New([]string{"foo"}, "", 1)
+	assert.EqualError(t, err, "summary field foo is not available")
+
+	_, err = New(nil, "", 1)
+	assert.EqualError(t, err, "summary fields have not been configured")
+
+	_, err = New([]string{"rtt"}, "bar", 1)
+	assert.EqualError(t, err, "summary group bar is not available")
+}
@@ -0,0 +1,135 @@
+package tail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// filter represents a filter expression in disjunctive form, the
+// conditions are joined by && and the groups are joined by ||
+// e.g. DPort == 443 && RTT > 10000 || Task == curl
+type filter [][]condition
+
+type condition struct {
+	field string
+	op    string
+	value string
+	num   float64
+	isNum bool
+}
+
+// the two characters operators should be checked first
+var operators = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+func parseFilter(expr string) (filter, error) {
+	var f filter
+
+	if strings.TrimSpace(expr) == "" {
+		return f, nil
+	}
+
+	for _, or := range strings.Split(expr, "||") {
+		var group []condition
+
+		for _, and := range strings.Split(or, "&&") {
+			c, err := parseCondition(and)
+			if err != nil {
+				return nil, err
+			}
+
+			group = append(group, c)
+		}
+
+		f = append(f, group)
+	}
+
+	return f, nil
+}
+
+func parseCondition(s string) (condition, error) {
+	var (
+		c     condition
+		index = -1
+	)
+
+	for _, op := range operators {
+		i := strings.Index(s, op)
+		if i > -1 && (index < 0 || i < index) {
+			index, c.op = i, op
+		}
+	}
+
+	if index < 0 {
+		return c, fmt.Errorf("invalid filter condition: %s", strings.TrimSpace(s))
+	}
+
+	c.field = strings.TrimSpace(s[:index])
+	c.value = strings.Trim(strings.TrimSpace(s[index+len(c.op):]), `"'`)
+
+	if c.field == "" || c.value == "" {
+		return c, fmt.Errorf("invalid filter condition: %s", strings.TrimSpace(s))
+	}
+
+	if n, err := strconv.ParseFloat(c.value, 64); err == nil {
+		c.num, c.isNum = n, true
+	}
+
+	return c, nil
+}
+
+// match returns true if the record matches one of the groups,
+// an empty filter matches all the records.
+func (f filter) match(m map[string]interface{}) bool {
+	if len(f) < 1 {
+		return true
+	}
+
+	for _, group := range f {
+		matched := true
+		for _, c := range group {
+			if !c.match(m) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c condition) match(m map[string]interface{}) bool {
+	v, ok := m[c.field]
+	if !ok {
+		return false
+	}
+
+	if n, ok := v.(float64); ok && c.isNum {
+		return compare(c.op, n-c.num)
+	}
+
+	return compare(c.op, float64(strings.Compare(fmt.Sprint(v), c.value)))
+}
+
+func compare(op string, diff float64) bool {
+	switch op {
+	case "==":
+		return diff == 0
+	case "!=":
+		return diff != 0
+	case ">":
+		return diff > 0
+	case ">=":
+		return diff >= 0
+	case "<":
+		return diff < 0
+	case "<=":
+		return diff <= 0
+	}
+
+	return false
+}
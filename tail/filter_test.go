@@ -0,0 +1,44 @@
+package tail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	m := map[string]interface{}{
+		"Task":  "curl",
+		"DPort": float64(443),
+		"RTT":   float64(12000),
+		"DAddr": "10.0.0.1",
+	}
+
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"", true},
+		{"DPort == 443", true},
+		{"DPort != 443", false},
+		{"RTT > 10000 && DPort == 443", true},
+		{"RTT >= 12000", true},
+		{"RTT < 12000", false},
+		{"RTT <= 12000", true},
+		{"Task == 'wget' || DAddr == \"10.0.0.1\"", true},
+		{"Task == wget || RTT > 20000", false},
+		{"Foo == bar", false},
+		{"Foo != bar", false},
+	}
+
+	for _, tt := range tests {
+		f, err := parseFilter(tt.expr)
+		assert.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.match, f.match(m), tt.expr)
+	}
+
+	for _, expr := range []string{"DPort", "== 443", "RTT > 1 &&", "RTT >"} {
+		_, err := parseFilter(expr)
+		assert.Error(t, err, expr)
+	}
+}
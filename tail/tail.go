@@ -0,0 +1,70 @@
+package tail
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mehrdadrad/tcpdog/admin"
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+// Run connects to the server admin API and writes the live records
+// which match the filter to w as json lines until the context is
+// canceled or the server closes the stream.
+func Run(ctx context.Context, cfg *config.TailConfig, w io.Writer) error {
+	f, err := parseFilter(cfg.Filter)
+	if err != nil {
+		return err
+	}
+
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if cfg.TLSConfig != nil && cfg.TLSConfig.Enable {
+		creds, err := config.GetCreds(cfg.TLSConfig)
+		if err != nil {
+			return err
+		}
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	}
+
+	conn, err := grpc.DialContext(ctx, cfg.Addr, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if cfg.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, admin.TokenKey, cfg.Token)
+	}
+
+	stream, err := pb.NewAdminClient(conn).Tail(ctx, &pb.TailRequest{Sample: cfg.Sample})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF || status.Code(err) == codes.Canceled {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		m := r.GetFields().AsMap()
+		if !f.match(m) {
+			continue
+		}
+
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+}
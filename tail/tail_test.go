@@ -0,0 +1,81 @@
+package tail
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mehrdadrad/tcpdog/admin"
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+type fakeAdmin struct {
+	pb.UnimplementedAdminServer
+	records []map[string]interface{}
+	sample  uint32
+	token   string
+}
+
+func (f *fakeAdmin) Tail(req *pb.TailRequest, srv pb.Admin_TailServer) error {
+	f.sample = req.GetSample()
+
+	md, _ := metadata.FromIncomingContext(srv.Context())
+	if t := md.Get(admin.TokenKey); len(t) > 0 {
+		f.token = t[0]
+	}
+
+	for _, r := range f.records {
+		s, err := structpb.NewStruct(r)
+		if err != nil {
+			return err
+		}
+
+		if err := srv.Send(&pb.FieldsSPB{Fields: s}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	fake := &fakeAdmin{
+		records: []map[string]interface{}{
+			{"Task": "curl", "RTT": float64(5000)},
+			{"Task": "wget", "RTT": float64(15000)},
+			{"Task": "curl", "RTT": float64(25000)},
+		},
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	gServer := grpc.NewServer()
+	pb.RegisterAdminServer(gServer, fake)
+	go gServer.Serve(l)
+	defer gServer.Stop()
+
+	buf := &bytes.Buffer{}
+	err = Run(context.Background(), &config.TailConfig{
+		Addr:   l.Addr().String(),
+		Token:  "secret",
+		Sample: 10,
+		Filter: "Task == curl && RTT > 10000",
+	}, buf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"RTT":25000,"Task":"curl"}`+"\n", buf.String())
+	assert.Equal(t, uint32(10), fake.sample)
+	assert.Equal(t, "secret", fake.token)
+
+	// invalid filter
+	err = Run(context.Background(), &config.TailConfig{Filter: "RTT"}, buf)
+	assert.Error(t, err)
+}
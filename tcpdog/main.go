@@ -2,27 +2,26 @@ package main
 
 import (
 	"C"
-	"bytes"
+	"fmt"
 	"os"
-	"sync"
 
 	"github.com/sethvargo/go-signalcontext"
 	"go.uber.org/zap"
 
+	"github.com/mehrdadrad/tcpdog/agent"
 	"github.com/mehrdadrad/tcpdog/config"
-	"github.com/mehrdadrad/tcpdog/ebpf"
-	"github.com/mehrdadrad/tcpdog/egress"
+	"github.com/mehrdadrad/tcpdog/tail"
 )
 
 var version string
 
 func main() {
-	cfg, err := config.Get(os.Args, version)
-	if err != nil {
-		exit(err)
+	if len(os.Args) > 1 && os.Args[1] == "tail" {
+		runTail()
+		return
 	}
 
-	err = validate(cfg)
+	cfg, err := config.Get(os.Args, version)
 	if err != nil {
 		exit(err)
 	}
@@ -33,45 +32,31 @@ func main() {
 	ctx, cancel := signalcontext.OnInterrupt()
 	defer cancel()
 
-	ctx = cfg.WithContext(ctx)
-
-	e := ebpf.New(cfg)
-	defer e.Close()
-
-	bufPool := &sync.Pool{
-		New: func() interface{} {
-			return new(bytes.Buffer)
-		},
+	err = agent.Run(ctx, cfg)
+	if err == agent.ErrDegraded {
+		logger.Warn("tcpdog", zap.Error(err))
+		os.Exit(2)
+	} else if err != nil {
+		exit(err)
 	}
+}
 
-	chMap := map[string]chan *bytes.Buffer{}
-	for _, tracepoint := range cfg.Tracepoints {
-		if _, ok := chMap[tracepoint.Egress]; ok {
-			continue
-		}
-
-		ch := make(chan *bytes.Buffer, 1000)
-		chMap[tracepoint.Egress] = ch
-		err := egress.Start(ctx, tracepoint, bufPool, ch)
-		if err != nil {
-			logger.Fatal("egress", zap.Error(err))
-		}
-
-		eType := cfg.Egress[tracepoint.Egress].Type
-		logger.Info("egress", zap.String("msg", tracepoint.Egress+" has been started"), zap.String("type", eType))
+func runTail() {
+	cfg, err := config.GetTail(os.Args, version)
+	if err != nil {
+		exit(err)
 	}
 
-	for index, tracepoint := range cfg.Tracepoints {
-		e.Start(ctx, ebpf.TP{
-			Name:    tracepoint.Name,
-			Index:   index,
-			BufPool: bufPool,
-			OutChan: chMap[tracepoint.Egress],
-			INet:    tracepoint.INet,
-			Workers: tracepoint.Workers,
-			Fields:  cfg.GetTPFields(tracepoint.Fields),
-		})
+	ctx, cancel := signalcontext.OnInterrupt()
+	defer cancel()
+
+	err = tail.Run(ctx, cfg, os.Stdout)
+	if err != nil {
+		exit(err)
 	}
+}
 
-	<-ctx.Done()
+func exit(err error) {
+	fmt.Println(err)
+	os.Exit(1)
 }
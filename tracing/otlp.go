@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// exporter exports the finished traces to the OTLP/HTTP endpoint
+// in batches, the traces are dropped if the queue is full.
+type exporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+	logger   *zap.Logger
+	ch       chan *Trace
+	dropped  uint64
+}
+
+func newExporter(cfg config.Tracing, service string, logger *zap.Logger) *exporter {
+	return &exporter{
+		endpoint: cfg.Endpoint,
+		headers:  cfg.Headers,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+		ch:       make(chan *Trace, 1000),
+	}
+}
+
+func (e *exporter) add(t *Trace) {
+	select {
+	case e.ch <- t:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *exporter) run(ctx context.Context, interval, maxAge time.Duration) {
+	var (
+		batch   []*Trace
+		dropped uint64
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) < 1 {
+			return
+		}
+
+		if err := e.export(batch); err != nil {
+			e.logger.Warn("tracing", zap.Error(err), zap.Int("traces", len(batch)))
+		}
+
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case t := <-e.ch:
+			batch = append(batch, t)
+			if len(batch) >= cap(e.ch) {
+				flush()
+			}
+		case <-ticker.C:
+			sweep(maxAge)
+			flush()
+
+			if n := atomic.LoadUint64(&e.dropped); n != dropped {
+				e.logger.Warn("tracing", zap.String("msg", "queue is full, traces have been dropped"),
+					zap.Uint64("traces", n-dropped))
+				dropped = n
+			}
+		case <-ctx.Done():
+			for len(e.ch) > 0 {
+				batch = append(batch, <-e.ch)
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// export posts the traces to the endpoint in the OTLP json encoding
+func (e *exporter) export(batch []*Trace) error {
+	var spans []otlpSpan
+
+	for _, t := range batch {
+		for _, s := range t.Spans() {
+			spans = append(spans, newOTLPSpan(s))
+		}
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttr{stringAttr("service.name", e.service)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "tcpdog"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type otlpAttr struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Status            *otlpStatus `json:"status,omitempty"`
+}
+
+func newOTLPSpan(s Span) otlpSpan {
+	o := otlpSpan{
+		TraceID:           hex.EncodeToString(s.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.SpanID[:]),
+		Name:              s.Name,
+		Kind:              1, // internal
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+
+	if s.ParentID != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+	}
+
+	if s.Err != "" {
+		o.Status = &otlpStatus{Code: 2, Message: s.Err}
+	}
+
+	return o
+}
+
+func stringAttr(key, value string) otlpAttr {
+	return otlpAttr{Key: key, Value: map[string]string{"stringValue": value}}
+}
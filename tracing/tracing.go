@@ -0,0 +1,351 @@
+// Package tracing traces a sample of the records through the pipeline
+// stages for the slow records forensics. a sampled record has a trace
+// with a root span and a span per stage (e.g. unmarshal, processor, geo,
+// batch wait and write) and the finished traces are exported to an
+// OpenTelemetry collector (OTLP/HTTP json). the trace context is carried
+// from the agent to the server by the W3C traceparent, e.g. a kafka header.
+// it costs an atomic load per call if the tracing is disabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+)
+
+// Header is the trace context header name
+const Header = "traceparent"
+
+// ErrDropped is the error of a trace which its record has been dropped
+var ErrDropped = errors.New("record has been dropped")
+
+// ErrIncomplete is the error of a trace which hasn't been finished
+// within the max age, e.g. its record has been dropped.
+var ErrIncomplete = errors.New("incomplete trace")
+
+var (
+	enabled int32
+	rate    uint64
+	traces  sync.Map
+	state   = struct {
+		sync.Mutex
+		exporter *exporter
+	}{}
+)
+
+// Trace represents the trace of a record
+type Trace struct {
+	mu     sync.Mutex
+	record interface{}
+	key    uintptr
+	root   *Span
+	spans  []*Span
+	open   map[string]*Span
+	done   bool
+}
+
+// Span represents a stage of a trace
+type Span struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Err      string
+}
+
+// Enabled returns true if the tracing has been started
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Start validates the tracing configuration and starts the exporter,
+// it's disabled and the pending traces are exported once the context
+// is canceled.
+func Start(ctx context.Context, cfg config.Tracing, service string, logger *zap.Logger) error {
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+
+	if cfg.ServiceName != "" {
+		service = cfg.ServiceName
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 5 * time.Minute
+	}
+
+	e := newExporter(cfg, service, logger)
+
+	state.Lock()
+	state.exporter = e
+	state.Unlock()
+
+	atomic.StoreUint64(&rate, math.Float64bits(cfg.SampleRate))
+	atomic.StoreInt32(&enabled, 1)
+
+	go func() {
+		e.run(ctx, cfg.FlushInterval, cfg.MaxAge)
+
+		state.Lock()
+		if state.exporter == e {
+			atomic.StoreInt32(&enabled, 0)
+		}
+		state.Unlock()
+	}()
+
+	return nil
+}
+
+// Validate validates the tracing configuration
+func Validate(cfg config.Tracing) error {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid tracing endpoint %s", cfg.Endpoint)
+	}
+
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return fmt.Errorf("tracing sample rate %v is out of range [0, 1]", cfg.SampleRate)
+	}
+
+	return nil
+}
+
+// Sample starts the trace of the record if it's sampled, the trace
+// continues the traceparent trace if it's sampled by the sender. the
+// trace isn't attached if the record is nil, e.g. at the agent.
+func Sample(record interface{}, name, traceparent string, start time.Time) *Trace {
+	if !Enabled() {
+		return nil
+	}
+
+	root := &Span{Name: name, Start: start}
+
+	if traceID, parentID, sampled, ok := parse(traceparent); ok {
+		if !sampled {
+			return nil
+		}
+		root.TraceID, root.ParentID = traceID, parentID
+	} else {
+		if mrand.Float64() >= math.Float64frombits(atomic.LoadUint64(&rate)) {
+			return nil
+		}
+		rand.Read(root.TraceID[:])
+	}
+
+	rand.Read(root.SpanID[:])
+
+	t := &Trace{root: root, open: map[string]*Span{}}
+
+	if record != nil {
+		k, ok := key(record)
+		if !ok {
+			return nil
+		}
+		t.record, t.key = record, k
+		traces.Store(k, t)
+	}
+
+	return t
+}
+
+// From returns the trace of the record, it's nil if
+// the tracing is disabled or the record isn't sampled.
+func From(record interface{}) *Trace {
+	if !Enabled() {
+		return nil
+	}
+
+	k, ok := key(record)
+	if !ok {
+		return nil
+	}
+
+	if t, ok := traces.Load(k); ok {
+		return t.(*Trace)
+	}
+
+	return nil
+}
+
+// key returns the identity of the decoded record
+func key(record interface{}) (uintptr, bool) {
+	v := reflect.ValueOf(record)
+	switch v.Kind() {
+	case reflect.Map, reflect.Ptr:
+		return v.Pointer(), true
+	}
+
+	return 0, false
+}
+
+// Begin begins the span of a stage
+func (t *Trace) Begin(name string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.open[name] = t.span(name, time.Now())
+	t.mu.Unlock()
+}
+
+// End ends the span of a stage which has been begun
+func (t *Trace) End(name string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.open[name]; ok {
+		delete(t.open, name)
+		s.End = time.Now()
+		t.spans = append(t.spans, s)
+	}
+}
+
+// Span adds the span of a stage which has been measured
+func (t *Trace) Span(name string, start, end time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	s := t.span(name, start)
+	s.End = end
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+}
+
+func (t *Trace) span(name string, start time.Time) *Span {
+	s := &Span{TraceID: t.root.TraceID, ParentID: t.root.SpanID, Name: name, Start: start}
+	rand.Read(s.SpanID[:])
+
+	return s
+}
+
+// Traceparent returns the W3C trace context of the trace
+func (t *Trace) Traceparent() string {
+	if t == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(t.root.TraceID[:]), hex.EncodeToString(t.root.SpanID[:]))
+}
+
+// Finish ends the trace and its open spans with the error if
+// it's not nil, the trace is detached from its record and exported.
+func (t *Trace) Finish(err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		return
+	}
+	t.done = true
+
+	now := time.Now()
+	for _, s := range t.open {
+		s.End = now
+		t.spans = append(t.spans, s)
+	}
+	t.open = nil
+
+	t.root.End = now
+	if err != nil {
+		t.root.Err = err.Error()
+	}
+	t.mu.Unlock()
+
+	if t.record != nil {
+		traces.Delete(t.key)
+	}
+
+	state.Lock()
+	e := state.exporter
+	state.Unlock()
+
+	if e != nil {
+		e.add(t)
+	}
+}
+
+// Spans returns the spans of the trace, the root span is the first one
+func (t *Trace) Spans() []Span {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	spans := []Span{*t.root}
+	for _, s := range t.spans {
+		spans = append(spans, *s)
+	}
+
+	return spans
+}
+
+// parse parses the W3C traceparent (version 00)
+func parse(traceparent string) (traceID [16]byte, parentID [8]byte, sampled, ok bool) {
+	if len(traceparent) != 55 || traceparent[:3] != "00-" || traceparent[35] != '-' || traceparent[52] != '-' {
+		return
+	}
+
+	if _, err := hex.Decode(traceID[:], []byte(traceparent[3:35])); err != nil {
+		return
+	}
+
+	if _, err := hex.Decode(parentID[:], []byte(traceparent[36:52])); err != nil {
+		return
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(traceparent[53:])); err != nil {
+		return
+	}
+
+	if traceID == [16]byte{} || parentID == [8]byte{} {
+		return
+	}
+
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+// sweep finishes the traces which are older than the max age
+func sweep(maxAge time.Duration) {
+	deadline := time.Now().Add(-maxAge)
+
+	traces.Range(func(_, v interface{}) bool {
+		t := v.(*Trace)
+		if t.root.Start.Before(deadline) {
+			t.Finish(ErrIncomplete)
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mehrdadrad/tcpdog/config"
+	pb "github.com/mehrdadrad/tcpdog/proto"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(config.Tracing{Endpoint: "http://localhost:4318/v1/traces", SampleRate: 0.5}))
+	assert.EqualError(t, Validate(config.Tracing{Endpoint: "localhost:4318"}), "invalid tracing endpoint localhost:4318")
+	assert.EqualError(t, Validate(config.Tracing{Endpoint: "http://localhost:4318", SampleRate: 2}),
+		"tracing sample rate 2 is out of range [0, 1]")
+}
+
+func TestParse(t *testing.T) {
+	traceID, parentID, sampled, ok := parse("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	assert.True(t, ok)
+	assert.True(t, sampled)
+	assert.Equal(t, byte(0x0a), traceID[0])
+	assert.Equal(t, byte(0x31), parentID[7])
+
+	_, _, sampled, ok = parse("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	assert.True(t, ok)
+	assert.False(t, sampled)
+
+	for _, tp := range []string{
+		"",
+		"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b716920333x-01",
+	} {
+		_, _, _, ok = parse(tp)
+		assert.False(t, ok, tp)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	record := &pb.Fields{}
+
+	assert.False(t, Enabled())
+	assert.Nil(t, Sample(record, "grpc.receive", "", time.Now()))
+	assert.Nil(t, From(record))
+
+	// the nil trace is a no-op
+	var tr *Trace
+	tr.Begin("geo")
+	tr.End("geo")
+	tr.Finish(nil)
+	assert.Equal(t, "", tr.Traceparent())
+}
+
+func TestTrace(t *testing.T) {
+	ch := make(chan map[string]interface{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		m := map[string]interface{}{}
+		json.Unmarshal(b, &m)
+		ch <- m
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := Start(ctx, config.Tracing{Endpoint: ts.URL, SampleRate: 1, FlushInterval: time.Hour}, "tcpdog-server", zap.NewNop())
+	assert.NoError(t, err)
+
+	// the agent trace is continued
+	record := map[string]interface{}{"RTT": 5}
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	start := time.Now()
+	tr := Sample(record, "kafka.consume", parent, start)
+	assert.NotNil(t, tr)
+	tr.Span("unmarshal", start, start.Add(time.Millisecond))
+	assert.True(t, strings.HasPrefix(tr.Traceparent(), "00-0af7651916cd43dd8448eb211c80319c-"))
+
+	From(record).Begin("geo")
+	From(record).End("geo")
+	From(record).Begin("elasticsearch.bulk")
+	From(record).Finish(nil)
+
+	// the finished trace is detached
+	assert.Nil(t, From(record))
+
+	// the sender didn't sample it
+	assert.Nil(t, Sample(record, "kafka.consume", parent[:53]+"00", start))
+
+	spans := tr.Spans()
+	assert.Len(t, spans, 4)
+	assert.Equal(t, "kafka.consume", spans[0].Name)
+	for _, s := range spans[1:] {
+		assert.Equal(t, spans[0].TraceID, s.TraceID)
+		assert.Equal(t, spans[0].SpanID, s.ParentID)
+		assert.False(t, s.End.IsZero(), s.Name)
+	}
+
+	// a dropped record
+	Sample(&pb.Fields{}, "grpc.receive", "", time.Now()).Finish(ErrDropped)
+
+	cancel()
+
+	var m map[string]interface{}
+	select {
+	case m = <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("traces have not been exported")
+	}
+
+	rs := m["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "tcpdog-server", rs["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})["value"].(map[string]interface{})["stringValue"])
+
+	exported := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	assert.Len(t, exported, 5)
+
+	root := exported[0].(map[string]interface{})
+	assert.Equal(t, "kafka.consume", root["name"])
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", root["traceId"])
+	assert.Equal(t, "b7ad6b7169203331", root["parentSpanId"])
+
+	dropped := exported[4].(map[string]interface{})
+	assert.Equal(t, "grpc.receive", dropped["name"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "record has been dropped"}, dropped["status"])
+}
+
+func TestSweep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := Start(ctx, config.Tracing{Endpoint: "http://127.0.0.1:1", SampleRate: 1, FlushInterval: time.Hour}, "tcpdog-server", zap.NewNop())
+	assert.NoError(t, err)
+
+	record := &pb.Fields{}
+	tr := Sample(record, "grpc.receive", "", time.Now().Add(-time.Hour))
+	tr.Begin("processor anomaly")
+
+	sweep(time.Minute)
+
+	assert.Nil(t, From(record))
+
+	spans := tr.Spans()
+	assert.Equal(t, ErrIncomplete.Error(), spans[0].Err)
+	assert.Equal(t, "processor anomaly", spans[1].Name)
+	assert.False(t, spans[1].End.IsZero())
+}